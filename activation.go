@@ -0,0 +1,131 @@
+package mrpc
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// systemd socket activation：监听套接字由systemd打开，启动服务时从fd 3开始依次传入，
+// 环境变量LISTEN_PID、LISTEN_FDS、LISTEN_FDNAMES描述它们。服务重启期间套接字一直由systemd持有，
+// 新的连接在内核中排队，不会被拒绝
+//
+//	# mrpc.socket
+//	[Socket]
+//	ListenStream=1234
+//
+//	lis, err := mrpc.ListenSystemd("tcp", ":1234")
+//	server.Accept(lis)
+
+// 传入的第一个fd，见sd_listen_fds(3)
+const listenFDsStart = 3
+
+// 一个传入的监听套接字
+type ActivatedListener struct {
+	net.Listener
+	Name string // LISTEN_FDNAMES中的名称，对应.socket文件中的FileDescriptorName，默认为单元名
+}
+
+// 环境变量只读一次，读完后清除，不再传给子进程
+var systemdListeners = sync.OnceValues(func() ([]ActivatedListener, error) {
+	lis, err := activatedListeners(os.Getenv, listenFDsStart)
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+	return lis, err
+})
+
+// systemd传入的监听套接字，不是由socket activation启动时返回nil。
+// 多次调用返回相同的listener
+func SystemdListeners() ([]ActivatedListener, error) {
+	return systemdListeners()
+}
+
+// 由socket activation启动时使用传入的套接字中名称为address的，或者监听地址与address相同的，
+// 都不是时返回错误，不会在别的套接字上提供服务；没有传入套接字时自己监听
+func ListenSystemd(network, address string) (net.Listener, error) {
+	lis, err := SystemdListeners()
+	if err != nil {
+		return nil, err
+	}
+	if len(lis) == 0 {
+		return net.Listen(network, address)
+	}
+	return pickListener(lis, network, address)
+}
+
+func pickListener(lis []ActivatedListener, network, address string) (net.Listener, error) {
+	for _, l := range lis {
+		if l.Name == address {
+			return l.Listener, nil
+		}
+	}
+	for _, l := range lis {
+		if sameAddr(l.Addr(), network, address) {
+			return l.Listener, nil
+		}
+	}
+	return nil, fmt.Errorf("rpc server: no socket activated listener for %s %s", network, address)
+}
+
+// 监听地址addr是否就是address。address省略主机或者双方都是通配地址时只比较端口
+func sameAddr(addr net.Addr, network, address string) bool {
+	if !strings.HasPrefix(network, addr.Network()) { // tcp4、tcp6也是tcp
+		return false
+	}
+	if network == "unix" || network == "unixpacket" {
+		return addr.String() == address
+	}
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return false
+	}
+	lhost, lport, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return false
+	}
+	if p, err := net.LookupPort(network, port); err != nil || strconv.Itoa(p) != lport {
+		return false
+	}
+	ip, lip := net.ParseIP(host), net.ParseIP(lhost)
+	return host == "" || ip.Equal(lip) || ip != nil && ip.IsUnspecified() && lip.IsUnspecified()
+}
+
+func activatedListeners(getenv func(string) string, start int) ([]ActivatedListener, error) {
+	pid, err := strconv.Atoi(getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() { // 不是传给这个进程的
+		return nil, nil
+	}
+	n, err := strconv.Atoi(getenv("LISTEN_FDS"))
+	if err != nil || n <= 0 {
+		return nil, errors.New("rpc server: invalid LISTEN_FDS " + strconv.Quote(getenv("LISTEN_FDS")))
+	}
+	var names []string
+	if s := getenv("LISTEN_FDNAMES"); s != "" {
+		names = strings.Split(s, ":")
+	}
+	lis := make([]ActivatedListener, 0, n)
+	for i := 0; i < n; i++ {
+		fd := start + i
+		name := "LISTEN_FD_" + strconv.Itoa(fd)
+		if i < len(names) {
+			name = names[i]
+		}
+		f := os.NewFile(uintptr(fd), name)
+		// FileListener复制了fd，原来的可以关掉
+		l, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			for _, l := range lis {
+				l.Close()
+			}
+			return nil, fmt.Errorf("rpc server: fd %d is not a listener: %w", fd, err)
+		}
+		lis = append(lis, ActivatedListener{Listener: l, Name: name})
+	}
+	return lis, nil
+}
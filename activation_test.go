@@ -0,0 +1,69 @@
+package mrpc
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"testing"
+)
+
+func TestActivatedListeners(t *testing.T) {
+	orig, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer orig.Close()
+	f, err := orig.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := map[string]string{
+		"LISTEN_PID":     strconv.Itoa(os.Getpid()),
+		"LISTEN_FDS":     "1",
+		"LISTEN_FDNAMES": "rpc",
+	}
+	lis, err := activatedListeners(func(k string) string { return env[k] }, int(f.Fd()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert(t, len(lis) == 1 && lis[0].Name == "rpc" && lis[0].Addr().String() == orig.Addr().String(),
+		"unexpected listeners %+v", lis)
+	defer lis[0].Close()
+
+	s := NewServer()
+	s.Register(new(Calc))
+	go s.Accept(lis[0])
+	client, err := Dial("tcp", orig.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	var sum int
+	err = client.Call("Calc.Sum", Pair{1, 2}, &sum)
+	assert(t, err == nil && sum == 3, "sum=%d err=%v", sum, err)
+
+	env["LISTEN_PID"] = "1"
+	lis, err = activatedListeners(func(k string) string { return env[k] }, int(f.Fd()))
+	assert(t, lis == nil && err == nil, "other process's fds should be ignored")
+}
+
+func TestPickListener(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+	lis := []ActivatedListener{{Listener: l, Name: "mrpc.socket"}}
+	for _, address := range []string{"mrpc.socket", ":" + port, "127.0.0.1:" + port} {
+		got, err := pickListener(lis, "tcp", address)
+		assert(t, err == nil && got == l, "%s: got %v, %v", address, got, err)
+	}
+	// 没有匹配的套接字时不能在别的套接字上服务
+	for _, address := range []string{":1", "10.0.0.1:" + port, "metrics"} {
+		got, err := pickListener(lis, "tcp", address)
+		assert(t, err != nil && got == nil, "%s: want error, got %v", address, got)
+	}
+	_, err = pickListener(lis, "unix", "/run/mrpc.sock")
+	assert(t, err != nil, "unix address should not match a tcp listener")
+}
@@ -0,0 +1,91 @@
+package mrpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go/token"
+	"log"
+	"strings"
+	"sync/atomic"
+)
+
+// 方法别名：方法改名后，旧名称作为别名继续可用，调用原样交给新方法，客户端不必同时升级。
+// 读到请求时方法名就换成了新名称，之后的鉴权、中间件、统计、日志都与直接调用新方法相同。
+// 设置弃用通知后可以找出还在使用旧名称的调用方，全部迁移后删掉别名
+//
+//	s.Register(new(Accounts)) // 原来是Users.Get
+//	s.Alias("Users.Get", "Accounts.Lookup", mrpc.WithDeprecation(nil))
+type AliasOption func(*methodAlias)
+
+type methodAlias struct {
+	name, target string
+	svc          *service
+	mt           *methodType
+	deprecated   bool
+	notify       func(ctx context.Context, alias, target string)
+	logged       atomic.Bool // 没有notify时只记一次日志
+}
+
+// 调用别名时通知notify，它在处理请求的协程中、调用方法之前执行，ctx与传给方法的相同，
+// 可以从中取出元数据和客户端身份。notify为nil时只在第一次调用别名时记录日志
+func WithDeprecation(notify func(ctx context.Context, alias, target string)) AliasOption {
+	return func(a *methodAlias) {
+		a.deprecated = true
+		a.notify = notify
+	}
+}
+
+// 注册别名，alias和target都是"Service.Method"。target必须是已经注册的方法或者别名，
+// 别名不能与已经注册的方法重名。应当在开始服务之前调用
+func (s *Server) Alias(alias, target string, opts ...AliasOption) error {
+	dot := strings.LastIndex(alias, ".")
+	if dot < 0 || !token.IsExported(alias[:dot]) || !token.IsExported(alias[dot+1:]) {
+		return fmt.Errorf("rpc server: %q is not a valid method name", alias)
+	}
+	if svc, ok := s.serviceMap[alias[:dot]]; ok && svc.method[alias[dot+1:]] != nil {
+		return errors.New("rpc server: alias " + alias + " is already a registered method")
+	}
+	if _, dup := s.aliases[alias]; dup {
+		return errors.New("rpc server: duplicated alias " + alias)
+	}
+	a := &methodAlias{name: alias, target: target}
+	if t := s.aliases[target]; t != nil { // 别名的别名直接指向最终的方法
+		a.svc, a.mt, a.target = t.svc, t.mt, t.target
+	} else {
+		if dot := strings.LastIndex(target, "."); dot >= 0 {
+			if svc, _, mName := s.lookupService(target[:dot], target[dot+1:]); svc != nil {
+				a.svc, a.mt = svc, svc.method[mName]
+			}
+		}
+		if a.mt == nil {
+			return errors.New("rpc server: alias target " + target + " is not a registered method")
+		}
+	}
+	for _, opt := range opts {
+		opt(a)
+	}
+	if s.aliases == nil {
+		s.aliases = make(map[string]*methodAlias)
+	}
+	s.aliases[alias] = a
+	return nil
+}
+
+// 调用了弃用的别名
+func (a *methodAlias) called(ctx context.Context) {
+	if a == nil || !a.deprecated {
+		return
+	}
+	if a.notify != nil {
+		a.notify(ctx, a.name, a.target)
+		return
+	}
+	if !a.logged.Swap(true) {
+		log.Printf("rpc server: deprecated method %s called, use %s instead", a.name, a.target)
+	}
+}
+
+func Alias(alias, target string, opts ...AliasOption) error {
+	return DefaultServer.Alias(alias, target, opts...)
+}
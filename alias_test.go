@@ -0,0 +1,57 @@
+package mrpc
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestAlias(t *testing.T) {
+	s := NewServer()
+	s.Register(new(Calc))
+	var (
+		mu    sync.Mutex
+		calls []string
+	)
+	notify := func(ctx context.Context, alias, target string) {
+		mu.Lock()
+		defer mu.Unlock()
+		calls = append(calls, alias+"->"+target+" by "+IncomingMetadata(ctx)["user"])
+	}
+	for _, tc := range []struct {
+		alias, target string
+		opts          []AliasOption
+		err           string
+	}{
+		{"Math.Add", "Calc.Sum", []AliasOption{WithDeprecation(notify)}, ""},
+		{"Math.Plus", "Math.Add", nil, ""}, // 别名的别名
+		{"Math.Add", "Calc.Sum", nil, "duplicated alias"},
+		{"Calc.Sum", "Math.Add", nil, "already a registered method"},
+		{"Math.Minus", "Calc.Diff", nil, "not a registered method"},
+		{"math.add", "Calc.Sum", nil, "not a valid method name"},
+		{"Add", "Calc.Sum", nil, "not a valid method name"},
+	} {
+		err := s.Alias(tc.alias, tc.target, tc.opts...)
+		if tc.err == "" {
+			assert(t, err == nil, "alias %s: %v", tc.alias, err)
+		} else {
+			assert(t, err != nil && strings.Contains(err.Error(), tc.err), "alias %s: want %q, got %v", tc.alias, tc.err, err)
+		}
+	}
+
+	client, err := ConnectInProcess(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	for _, name := range []string{"Calc.Sum", "Math.Add", "Math.Plus"} {
+		var sum int
+		err := client.Call(name, Pair{1, 2}, &sum, WithCallMetadata(Metadata{"user": "ann"}))
+		assert(t, err == nil && sum == 3, "%s: sum=%d err=%v", name, sum, err)
+	}
+	// 只有设置了WithDeprecation的别名通知，直接调用新方法不通知
+	mu.Lock()
+	defer mu.Unlock()
+	assert(t, len(calls) == 1 && calls[0] == "Math.Add->Calc.Sum by ann", "deprecation calls: %v", calls)
+}
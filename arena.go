@@ -0,0 +1,145 @@
+package mrpc
+
+import (
+	"reflect"
+	"sync"
+
+	"github.com/micplus/mrpc/codec"
+)
+
+// 省GC模式(WithReducedGC)下每条连接自己的空闲列表。
+// 请求、参数、返回值在写完响应后清空放回，下一个请求直接复用，连同它们内部切片的底层数组：
+// gob解码切片时容量够就不再分配，固定形状的消息在稳定状态下不再产生垃圾。
+// 与sync.Pool不同，空闲列表不会在GC时被清掉，连接断开后整体释放，大小不超过连接上请求的最大并发数
+type arena struct {
+	mu       sync.Mutex // protect following
+	requests []*request
+	values   map[*methodType][]argReply
+}
+
+type argReply struct {
+	argv, replyv reflect.Value
+}
+
+func newArena() *arena {
+	return &arena{values: make(map[*methodType][]argReply)}
+}
+
+// a为nil时使用全局的池
+func (a *arena) getRequest() *request {
+	if a == nil {
+		return getRequest()
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if n := len(a.requests); n > 0 {
+		req := a.requests[n-1]
+		a.requests = a.requests[:n-1]
+		return req
+	}
+	req := newRequest()
+	req.arena = a
+	return req
+}
+
+func (a *arena) getValues(mt *methodType) (argv, replyv reflect.Value) {
+	if a == nil {
+		return mt.getArgv(), mt.getReplyv()
+	}
+	a.mu.Lock()
+	free := a.values[mt]
+	if n := len(free); n > 0 {
+		v := free[n-1]
+		a.values[mt] = free[:n-1]
+		a.mu.Unlock()
+		return v.argv, v.replyv
+	}
+	a.mu.Unlock()
+	return mt.newArgv(), mt.newReplyv()
+}
+
+// 清空请求放回，清空在锁外进行
+func (a *arena) put(req *request) {
+	mt, v := req.mType, argReply{req.argv, req.replyv}
+	if mt != nil {
+		recycle(v.argv)
+		recycle(v.replyv)
+	}
+	h := req.h
+	*h = codec.Header{}
+	*req = request{h: h, arena: a, serve: req.serve}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if mt != nil {
+		a.values[mt] = append(a.values[mt], v)
+	}
+	a.requests = append(a.requests, req)
+}
+
+// 清空v但保留它占用的存储。切片截断到0、map清空、结构体逐个字段清空；
+// 指针、字符串等没有可复用的存储，直接置零。
+// gob不传输零值字段，所以结构体不能留下旧值，但切片的元素每个都会重新解码，
+// 元素本身不含可复用的存储时不必逐个清空
+func recycle(v reflect.Value) {
+	if v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			return
+		}
+		if r, ok := v.Interface().(Resetter); ok {
+			r.Reset()
+			return
+		}
+		v = v.Elem()
+	} else if v.CanAddr() {
+		if r, ok := v.Addr().Interface().(Resetter); ok {
+			r.Reset()
+			return
+		}
+	}
+	clearValue(v)
+}
+
+func clearValue(v reflect.Value) {
+	switch v.Kind() {
+	case reflect.Slice:
+		if v.IsNil() {
+			return
+		}
+		if hasStorage(v.Type().Elem()) {
+			for i := 0; i < v.Len(); i++ {
+				clearValue(v.Index(i))
+			}
+		}
+		v.SetLen(0)
+	case reflect.Map:
+		v.Clear()
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			clearValue(v.Index(i))
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if !v.Field(i).CanSet() { // 有不导出的字段，整个置零
+				v.SetZero()
+				return
+			}
+		}
+		for i := 0; i < v.NumField(); i++ {
+			clearValue(v.Field(i))
+		}
+	default:
+		v.SetZero()
+	}
+}
+
+// 类型中是否有可以清空后复用的切片、map
+func hasStorage(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Slice, reflect.Map, reflect.Struct:
+		return true
+	case reflect.Array:
+		return hasStorage(t.Elem())
+	}
+	return false
+}
@@ -0,0 +1,71 @@
+package mrpc
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+type Batch struct {
+	IDs   []int
+	Items []Pair
+	Tags  map[string]int
+	Note  string
+	Next  *Pair
+}
+
+func TestRecycle(t *testing.T) {
+	b := &Batch{
+		IDs:   []int{1, 2, 3},
+		Items: []Pair{{1, 2}},
+		Tags:  map[string]int{"a": 1},
+		Note:  "n",
+		Next:  &Pair{3, 4},
+	}
+	items := b.Items
+	recycle(reflect.ValueOf(b))
+	assert(t, len(b.IDs) == 0 && cap(b.IDs) == 3, "IDs should keep capacity, got %v cap %d", b.IDs, cap(b.IDs))
+	assert(t, items[:1][0] == Pair{}, "struct elements should be cleared, got %v", items[:1])
+	assert(t, b.Tags != nil && len(b.Tags) == 0, "Tags should be cleared in place")
+	assert(t, b.Note == "" && b.Next == nil, "scalars and pointers should be zeroed")
+
+	buf := &Buf{Data: []byte("x")}
+	recycle(reflect.ValueOf(buf))
+	assert(t, buf.resets == 1, "Resetter should be used")
+}
+
+func TestReducedGC(t *testing.T) {
+	s := NewServer(WithReducedGC())
+	s.Register(new(Pooled))
+	client := pipeClient(t, s)
+
+	// 前一次的数据不能残留到下一次
+	for _, data := range []string{"hello world", "hi", ""} {
+		var reply Buf
+		err := client.Call("Pooled.Echo", &Buf{Data: []byte(data)}, &reply)
+		assert(t, err == nil && string(reply.Data) == data, "Echo(%q) = %q, %v", data, reply.Data, err)
+	}
+}
+
+// go test -bench ReducedGC -benchmem 与BenchmarkCall对比
+func BenchmarkCallReducedGC(b *testing.B) {
+	s := NewServer(WithReducedGC())
+	HandleFunc(s, "Calc.Sum", func(_ context.Context, p Pair, sum *int) error {
+		*sum = p.A + p.B
+		return nil
+	})
+	client, err := ConnectInProcess(s)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer client.Close()
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		var sum int
+		for pb.Next() {
+			if err := client.Call("Calc.Sum", Pair{1, 2}, &sum); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
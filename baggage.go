@@ -0,0 +1,94 @@
+package mrpc
+
+import "context"
+
+// 随ctx传递的数据(租户、追踪、语言等)：客户端发送请求时按Baggage从ctx中取值放进元数据，
+// 服务端读到请求后把元数据还原成方法ctx中的值，方法用同样配置的客户端调用下游时又会带上它们，
+// 不需要每一层都显式地WithOutgoingMetadata。反方向由方法SetTrailer设置尾部元数据，
+// 客户端从Call.Trailer或者WithCallTrailer读取
+//
+//	type tenantKey struct{}
+//	tenant := mrpc.ContextBaggage("tenant", tenantKey{})
+//	s := mrpc.NewServer(mrpc.WithBaggage(tenant))
+//	client, _ := mrpc.DialOptions("tcp", addr, mrpc.WithClientBaggage(tenant))
+//	client.CallContext(context.WithValue(ctx, tenantKey{}, "acme"), "Orders.List", args, &reply)
+//	// 服务端的方法中 ctx.Value(tenantKey{}) == "acme"
+type Baggage struct {
+	// 元数据中的键
+	Key string
+	// 客户端从ctx中取值，ok为false时不发送
+	Inject func(ctx context.Context) (v string, ok bool)
+	// 服务端把收到的值放回ctx
+	Extract func(ctx context.Context, v string) context.Context
+}
+
+// ctx中以ctxKey存放的string值，在元数据中的键是key
+func ContextBaggage(key string, ctxKey any) Baggage {
+	return Baggage{
+		Key: key,
+		Inject: func(ctx context.Context) (string, bool) {
+			v, ok := ctx.Value(ctxKey).(string)
+			return v, ok
+		},
+		Extract: func(ctx context.Context, v string) context.Context {
+			return context.WithValue(ctx, ctxKey, v)
+		},
+	}
+}
+
+// 服务端把请求元数据还原到方法的ctx中，可以设置多个，多次使用时追加
+func WithBaggage(bs ...Baggage) ServerOption {
+	return func(s *Server) {
+		s.baggage = append(s.baggage, bs...)
+	}
+}
+
+// 客户端把ctx中的值放进请求元数据，ctx中已经有同名的出站元数据时以出站元数据为准
+func WithClientBaggage(bs ...Baggage) ClientOption {
+	return func(o *clientOptions) {
+		o.baggage = append(o.baggage, bs...)
+	}
+}
+
+type baggages []Baggage
+
+// 把ctx中的值加到md上，需要修改时复制一份，不修改调用方的元数据
+func (bs baggages) inject(ctx context.Context, md Metadata) Metadata {
+	cloned := false
+	for _, b := range bs {
+		if _, ok := md[b.Key]; ok {
+			continue
+		}
+		v, ok := b.Inject(ctx)
+		if !ok {
+			continue
+		}
+		if !cloned {
+			md, cloned = md.Clone(), true
+			if md == nil {
+				md = make(Metadata, len(bs))
+			}
+		}
+		md[b.Key] = v
+	}
+	return md
+}
+
+// 把元数据中的值放回ctx
+func (bs baggages) extract(ctx context.Context, md Metadata) context.Context {
+	for _, b := range bs {
+		if v, ok := md[b.Key]; ok {
+			ctx = b.Extract(ctx, v)
+		}
+	}
+	return ctx
+}
+
+type callTrailerKey struct{}
+
+// 同步调用结束后把服务端设置的尾部元数据写到*md，没有尾部时为nil
+func WithCallTrailer(md *Metadata) CallOption {
+	return func(o *callOptions) {
+		o.trailer = md
+	}
+}
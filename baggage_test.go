@@ -0,0 +1,45 @@
+package mrpc
+
+import (
+	"context"
+	"testing"
+)
+
+type tenantKey struct{}
+
+func TestBaggage(t *testing.T) {
+	tenant := ContextBaggage("tenant", tenantKey{})
+	// 后端返回ctx中的租户，前端原样调用后端，验证经过两跳仍然带着租户
+	backend := NewServer(WithBaggage(tenant))
+	HandleFunc(backend, "Tenant.Get", func(ctx context.Context, _ int, reply *string) error {
+		*reply, _ = ctx.Value(tenantKey{}).(string)
+		return SetTrailer(ctx, Metadata{"served-by": "backend"})
+	})
+	downstream := pipeClient(t, backend, WithClientBaggage(tenant))
+	frontend := NewServer(WithBaggage(tenant))
+	HandleFunc(frontend, "Tenant.Get", func(ctx context.Context, _ int, reply *string) error {
+		return downstream.CallContext(ctx, "Tenant.Get", 0, reply)
+	})
+	client := pipeClient(t, frontend, WithClientBaggage(tenant))
+
+	ctx := context.WithValue(context.Background(), tenantKey{}, "acme")
+	var reply string
+	err := client.CallContext(ctx, "Tenant.Get", 0, &reply)
+	assert(t, err == nil && reply == "acme", "reply=%q err=%v", reply, err)
+
+	// 显式的出站元数据优先
+	err = client.CallContext(WithOutgoingMetadata(ctx, Metadata{"tenant": "other"}), "Tenant.Get", 0, &reply)
+	assert(t, err == nil && reply == "other", "reply=%q err=%v", reply, err)
+	err = client.Call("Tenant.Get", 0, &reply)
+	assert(t, err == nil && reply == "", "no tenant: reply=%q err=%v", reply, err)
+	// 不修改ctx中的出站元数据
+	withUser := WithOutgoingMetadata(ctx, Metadata{"user": "ann"})
+	err = client.CallContext(withUser, "Tenant.Get", 0, &reply)
+	md := OutgoingMetadata(withUser)
+	assert(t, err == nil && reply == "acme" && len(md) == 1, "reply=%q err=%v metadata=%v", reply, err, md)
+
+	// 同步调用读取尾部元数据
+	var trailer Metadata
+	err = downstream.Call("Tenant.Get", 0, &reply, WithCallTrailer(&trailer))
+	assert(t, err == nil && trailer["served-by"] == "backend", "trailer=%v err=%v", trailer, err)
+}
@@ -0,0 +1,123 @@
+package mrpc
+
+import (
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// 令牌桶限速器，令牌以字节计。可以在多条连接之间共享，共享的连接合起来不超过速率，
+// 比如按客户端身份缓存，同一身份的所有连接共用一份带宽
+//
+//	s := mrpc.NewServer(mrpc.WithBandwidthLimit(func(conn net.Conn, id *mrpc.Identity) (read, write *mrpc.RateLimiter) {
+//		return mrpc.NewRateLimiter(1<<20, 0), mrpc.NewRateLimiter(4<<20, 0) // 每条连接读1MB/s、写4MB/s
+//	}))
+type RateLimiter struct {
+	clock Clock
+
+	mu     sync.Mutex // protect following
+	rate   float64    // 每秒的字节数
+	burst  int        // 桶的容量，也是一次读写的上限
+	tokens float64    // 可以为负，表示之前的调用方预支了令牌，之后的要多等
+	last   time.Time
+}
+
+// bytesPerSecond是平均速率，burst是允许的突发字节数，<=0时取十分之一秒的量(至少4KB)
+func NewRateLimiter(bytesPerSecond, burst int) *RateLimiter {
+	if burst <= 0 {
+		burst = max(bytesPerSecond/10, 4096)
+	}
+	l := &RateLimiter{clock: SystemClock, rate: float64(bytesPerSecond), burst: burst}
+	l.tokens = float64(burst)
+	l.last = l.clock.Now()
+	return l
+}
+
+// 修改速率和突发量，参数同NewRateLimiter，使用它的连接从下一次读写起按新的限制
+func (l *RateLimiter) SetLimit(bytesPerSecond, burst int) {
+	if burst <= 0 {
+		burst = max(bytesPerSecond/10, 4096)
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.clock.Now()
+	l.tokens = min(float64(l.burst), l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+	l.rate, l.burst = float64(bytesPerSecond), burst
+	l.tokens = min(l.tokens, float64(burst))
+}
+
+// 一次读写的上限
+func (l *RateLimiter) chunk() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.burst
+}
+
+// 取n个令牌，不够时预支并等到补足为止
+func (l *RateLimiter) wait(n int) {
+	l.mu.Lock()
+	now := l.clock.Now()
+	l.tokens = min(float64(l.burst), l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+	l.tokens -= float64(n)
+	var d time.Duration
+	if l.tokens < 0 {
+		d = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+	l.mu.Unlock()
+	if d > 0 {
+		<-l.clock.NewTimer(d).C()
+	}
+}
+
+// 限速的连接，read、write为nil的方向不限速。
+// 放在读缓冲之下，读缓冲按限速从连接取数据，慢下来的读让TCP把发送方也压住
+type throttledConn struct {
+	io.ReadWriteCloser
+	read, write *RateLimiter
+}
+
+func (c *throttledConn) Read(p []byte) (int, error) {
+	if c.read != nil {
+		p = p[:min(len(p), c.read.chunk())]
+	}
+	n, err := c.ReadWriteCloser.Read(p)
+	if c.read != nil && n > 0 {
+		c.read.wait(n)
+	}
+	return n, err
+}
+
+func (c *throttledConn) Write(p []byte) (n int, err error) {
+	if c.write == nil {
+		return c.ReadWriteCloser.Write(p)
+	}
+	for len(p) > 0 {
+		chunk := p[:min(len(p), c.write.chunk())]
+		c.write.wait(len(chunk))
+		m, err := c.ReadWriteCloser.Write(chunk)
+		n += m
+		if err != nil {
+			return n, err
+		}
+		p = p[m:]
+	}
+	return n, nil
+}
+
+// 限制每条连接的读写带宽，limit在握手之后调用，id是客户端证书的身份(没有时为nil)。
+// 返回nil的方向不限速；每次新建的限速器只限制这一条连接，缓存起来的由共用它的连接分享
+func WithBandwidthLimit(limit func(conn net.Conn, id *Identity) (read, write *RateLimiter)) ServerOption {
+	return func(s *Server) {
+		s.bandwidth = limit
+	}
+}
+
+// 限制客户端连接的读写带宽，nil的方向不限速
+func WithClientBandwidthLimit(read, write *RateLimiter) ClientOption {
+	return func(o *clientOptions) {
+		o.readLimit, o.writeLimit = read, write
+	}
+}
@@ -0,0 +1,70 @@
+package mrpc
+
+import (
+	"bytes"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBandwidthLimit(t *testing.T) {
+	const rate = 256 << 10
+	var mu sync.Mutex
+	shared := map[string]*RateLimiter{} // 按身份共享，测试中都没有身份
+	s := NewServer(WithBandwidthLimit(func(conn net.Conn, id *Identity) (read, write *RateLimiter) {
+		mu.Lock()
+		defer mu.Unlock()
+		key := ""
+		if id != nil {
+			key = id.CommonName
+		}
+		if shared[key] == nil {
+			shared[key] = NewRateLimiter(rate, 0)
+		}
+		return nil, shared[key]
+	}))
+	s.Register(new(Blob))
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go s.Accept(l)
+
+	// 两条连接共用写限速，合起来约128KB，扣掉初始的突发量至少要0.4秒
+	data := bytes.Repeat([]byte("a"), 64<<10)
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		client, err := Dial("tcp", l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var reply []byte
+			err := client.Call("Blob.Upper", data, &reply)
+			assert(t, err == nil && len(reply) == len(data), "call error: %v", err)
+		}()
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+	assert(t, elapsed >= 350*time.Millisecond, "shared limit not applied, took %v", elapsed)
+	assert(t, len(shared) == 1, "want one shared limiter, got %d", len(shared))
+}
+
+func TestClientBandwidthLimit(t *testing.T) {
+	s := NewServer()
+	s.Register(new(Blob))
+	client := pipeClient(t, s, WithClientBandwidthLimit(nil, NewRateLimiter(128<<10, 0)))
+
+	start := time.Now()
+	var reply []byte
+	err := client.Call("Blob.Upper", bytes.Repeat([]byte("b"), 64<<10), &reply)
+	assert(t, err == nil && len(reply) == 64<<10, "call error: %v", err)
+	elapsed := time.Since(start)
+	assert(t, elapsed >= 350*time.Millisecond, "client write limit not applied, took %v", elapsed)
+}
@@ -0,0 +1,21 @@
+package mrpc
+
+import "testing"
+
+// go test -bench . -benchmem 观察每次调用的分配
+func BenchmarkCall(b *testing.B) {
+	client, _, err := NewClientServerPair(new(Calc))
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer client.Close()
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		var sum int
+		for pb.Next() {
+			if err := client.Call("Calc.Sum", Pair{1, 2}, &sum); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,82 @@
+package mrpc
+
+import (
+	"context"
+	"time"
+)
+
+// 单次调用的选项，传给Call、Go，可以任意组合，不必为每种组合增加方法
+//
+//	err := client.Call("Arith.Add", args, &reply,
+//		mrpc.WithCallTimeout(time.Second),
+//		mrpc.WithCallMetadata(mrpc.Metadata{"tenant": "a"}))
+//
+// 选项最终都落在调用的ctx上，与CallContext的ctx等价：超时就是ctx的期限，元数据就是出站元数据。
+// codec在连接建立时就确定了，不能按调用更换
+type CallOption func(*callOptions)
+
+type callOptions struct {
+	timeout  time.Duration
+	md       Metadata
+	compress int
+	trailer  *Metadata
+}
+
+// 调用的超时，超时后返回context.DeadlineExceeded，期限同样传给服务端
+func WithCallTimeout(d time.Duration) CallOption {
+	return func(o *callOptions) {
+		o.timeout = d
+	}
+}
+
+// 随请求发送的元数据，多次使用时合并，同名的键后面的覆盖前面的
+func WithCallMetadata(md Metadata) CallOption {
+	return func(o *callOptions) {
+		if o.md == nil {
+			o.md = Metadata{}
+		}
+		for k, v := range md {
+			o.md[k] = v
+		}
+	}
+}
+
+// 这次调用的请求超过threshold字节时压缩，覆盖WithClientCompression的设置，
+// threshold<=0时这次不压缩。只对支持压缩的codec生效
+func WithCallCompression(threshold int) CallOption {
+	return func(o *callOptions) {
+		if threshold <= 0 {
+			threshold = -1
+		}
+		o.compress = threshold
+	}
+}
+
+type callCompressKey struct{}
+
+// 把选项转成ctx，没有超时时cancel什么也不做
+func (c *Client) callContext(ctx context.Context, opts []CallOption) (context.Context, context.CancelFunc) {
+	var o callOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.md != nil {
+		ctx = WithOutgoingMetadata(ctx, o.md)
+	}
+	if o.compress != 0 {
+		ctx = context.WithValue(ctx, callCompressKey{}, o.compress)
+	}
+	if o.trailer != nil {
+		ctx = context.WithValue(ctx, callTrailerKey{}, o.trailer)
+	}
+	if o.timeout > 0 {
+		return c.clock.WithTimeout(ctx, o.timeout)
+	}
+	return ctx, func() {}
+}
+
+// ctx中的压缩阈值，0表示使用连接的设置
+func callCompression(ctx context.Context) int {
+	n, _ := ctx.Value(callCompressKey{}).(int)
+	return n
+}
@@ -0,0 +1,62 @@
+package mrpc
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCallOptions(t *testing.T) {
+	client, _, err := NewClientServerPair(new(Greeter))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	var reply string
+	err = client.Call("Greeter.Hello", "mrpc", &reply,
+		WithCallMetadata(Metadata{"greeting": "hi"}), WithCallMetadata(Metadata{"greeting": "hello"}))
+	assert(t, err == nil && reply == "hello, mrpc", "Greeter.Hello = %q, %v", reply, err)
+
+	var n int
+	err = client.Call("Greeter.Slow", 200*time.Millisecond, &n, WithCallTimeout(10*time.Millisecond))
+	assert(t, errors.Is(err, context.DeadlineExceeded), "Call should time out, got %v", err)
+
+	call := client.Go("Greeter.Slow", 200*time.Millisecond, &n, nil, WithCallTimeout(10*time.Millisecond))
+	select {
+	case <-call.Done:
+		assert(t, errors.Is(call.Error, context.DeadlineExceeded), "Go should time out, got %v", call.Error)
+	case <-time.After(100 * time.Millisecond):
+		t.Fatal("Go with timeout did not finish in time")
+	}
+	call = client.Go("Greeter.Hello", "go", &reply, nil, WithCallMetadata(Metadata{"greeting": "hey"}))
+	<-call.Done
+	assert(t, call.Error == nil && reply == "hey, go", "Go Greeter.Hello = %q, %v", reply, call.Error)
+}
+
+func TestCallCompression(t *testing.T) {
+	s := NewServer()
+	s.Register(new(Echo))
+	client := pipeClient(t, s)
+
+	var reply string
+	client.Call("Echo.Say", "warm up", &reply) // 等服务端登记连接
+	big := strings.Repeat("compress me ", 10000)
+	read := func() int64 { return s.ConnStats()[0].BytesRead }
+	before := read()
+	err := client.Call("Echo.Say", big, &reply)
+	assert(t, err == nil && reply == big, "plain call: %v", err)
+	plain := read() - before
+	before = read()
+	err = client.Call("Echo.Say", big, &reply, WithCallCompression(1024))
+	assert(t, err == nil && reply == big, "compressed call: %v", err)
+	compressed := read() - before
+	assert(t, plain > int64(len(big)) && compressed < int64(len(big)/10), "request sizes: plain %d compressed %d", plain, compressed)
+
+	// 覆盖只对这次调用有效
+	before = read()
+	client.Call("Echo.Say", big, &reply)
+	assert(t, read()-before > int64(len(big)), "compression should not persist")
+}
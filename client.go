@@ -1,12 +1,16 @@
 package mrpc
 
 import (
+	"context"
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/micplus/mrpc/codec"
 )
@@ -22,22 +26,54 @@ type Call struct {
 	// 向服务器发送的请求数据
 	Name string
 	Args any
+	// 随请求发送的元数据
+	Metadata Metadata
 	// 由Client动态生成
 	Seq uint64
 
 	// 来自服务器的响应数据
 	Error error
 	Reply any
+	// 服务端在响应之后发送的尾部元数据，见SetTrailer
+	Trailer Metadata
 
 	// 通知异步调用完成，用来阻塞获取*Call
 	Done chan *Call
+
+	// 设置了StatsHandler时记录调用的ctx和开始时间
+	ctx   context.Context
+	start time.Time
+	// ctx的期限，随请求传给服务端
+	deadline time.Time
+	// 不为0时覆盖连接的压缩阈值，见WithCallCompression
+	compress int
+	// Go带超时时结束计时
+	cancel context.CancelFunc
 }
 
-// 传回自己(replyCall := <-argsCall.Done，replyCall与argsCall指向相同)
+// 传回自己(replyCall := <-argsCall.Done，replyCall与argsCall指向相同)。
+// Done满了就丢弃并记日志，不能让receive阻塞在用户的通道上，那样整条连接都会卡住
 func (c *Call) done() {
-	c.Done <- c
+	if c.cancel != nil {
+		c.cancel()
+	}
+	select {
+	case c.Done <- c:
+	default:
+		clientDroppedCalls.Add(1)
+		log.Printf("rpc client: discarding %s reply due to insufficient Done chan capacity", c.Name)
+	}
+}
+
+// 发起调用的接口，*Client和测试替身(mrpctest.MockClient)都实现了它，
+// 业务代码依赖Caller而不是*Client，测试时就能替换掉真实连接
+type Caller interface {
+	Call(name string, args, reply any, opts ...CallOption) error
+	Go(name string, args, reply any, done chan *Call, opts ...CallOption) *Call
 }
 
+var _ Caller = (*Client)(nil)
+
 // 一个client可以发起多个调用，client入口可以被多个协程获取，
 // 注意并发性
 type Client struct {
@@ -49,76 +85,180 @@ type Client struct {
 	sending sync.Mutex // protect following
 	// 请求消息头部，这个数据可以复用，每次发送时加锁，发送出去后就可以改成别的数据
 	header codec.Header
+	// 合并请求写入，bw为nil时每个请求单独写，见WithClientWriteCoalescing
+	bw       codec.BatchWriter
+	maxBytes int
+	maxDelay time.Duration
+	since    time.Time    // 缓冲中最早的未刷新请求的写入时间
+	waiting  atomic.Int32 // 等待发送的请求数
+
+	// 帧转储，见SetTrace
+	trace atomic.Pointer[tracer]
+	// 统计钩子，见WithClientStatsHandler
+	stats StatsHandler
+	conn  net.Conn // 为nil时ConnStats中没有地址
+	// conn的对端地址，统计事件中的Target
+	target string
+	// 连接的压缩阈值，单次调用覆盖后恢复成它
+	compress int
+	cn       *countingConn // 统计每条消息的大小，为nil时不知道大小
 
-	// 对client状态的修改需要加互斥锁，保护下面的4项
+	// 状态的切换需要加互斥锁，读可以直接读
 	mu sync.Mutex // protect following
-	// 请求序号，修改是互斥的以免重复
-	seq uint64
-	// 记录当前尚未完成的请求，支持异步调用
-	pending map[uint64]*Call
 	// 主动关闭标志
-	closing bool // user has called Close
+	closing atomic.Bool // user has called Close
 	// 崩溃标志
-	shutdown bool // server has told us to stop
+	shutdown atomic.Bool // server has told us to stop
+	// 收到了服务端的GOAWAY，见Shutdown。drained表示已经因排空关闭了连接
+	draining atomic.Bool
+	drained  atomic.Bool
+	// 调用了CloseSend，不再发送请求
+	sendClosed atomic.Bool
+	// 握手被服务端拒绝的原因，之后的调用都返回它
+	rejected atomic.Pointer[error]
+	// 状态变化时close并置为nil，见WaitForStateChange
+	stateMu sync.Mutex
+	stateCh chan struct{}
+
+	// 服务端开启流量控制时的发送信用
+	window *sendWindow
+	// 故障注入，见WithClientFaults
+	faults faults
+	// 计算请求剩余时间的时钟，见WithClientClock
+	clock Clock
+	// 把*Error转换成应用的错误类型，见WithErrorDecoder
+	decoders []func(e *Error) error
+	// 请求签名的密钥，见WithRequestSigning
+	signKey []byte
+	// 方法名的编号，见WithClientNameInterning
+	names *clientNames
+	// 从ctx中取出放进元数据的值，见WithClientBaggage
+	baggage baggages
+	// 正在使用的心跳间隔，pingStop关闭时心跳停止，见startPing
+	pingInterval time.Duration
+	pingStop     chan struct{}
+
+	// 处理服务端发起的反向调用，见WithReverseServer。只在receive中使用
+	reverse   *Server
+	reverseW  *responseWriter
+	reverseWG sync.WaitGroup
+
+	// 请求序号，原子地递增以免重复，高位是连接的纪元，见nextSeq
+	seq atomic.Uint64
+	// 记录当前尚未完成的请求，支持异步调用。
+	// 按seq分片，发送和接收的协程不再争用同一把锁
+	pending [pendingShards]pendingShard
+}
+
+const pendingShards = 16
+
+type pendingShard struct {
+	mu    sync.Mutex // protect calls
+	calls map[uint64]*Call
+}
+
+func (c *Client) shard(seq uint64) *pendingShard {
+	return &c.pending[seq%pendingShards]
 }
 
 var ErrShutDown = errors.New("connection shut down")
 
+// 连接已关闭时调用返回的错误，握手被拒绝时是拒绝的原因
+func (c *Client) errShutDown() error {
+	if err := c.rejected.Load(); err != nil {
+		return *err
+	}
+	return ErrShutDown
+}
+
+// 按原样传输的字节，见codec.RawMessage
+type RawMessage = codec.RawMessage
+
+// 服务器返回的错误，与连接、编解码等本地错误区分开
+type ServerError string
+
+func (e ServerError) Error() string {
+	return string(e)
+}
+
 // 关闭客户端，修改closing状态，通过codec关闭连接
 func (c *Client) Close() error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	if c.shutdown || c.closing {
+	if c.shutdown.Load() || c.closing.Load() {
 		return ErrShutDown
 	}
-	c.closing = true
+	c.closing.Store(true)
+	c.stateChanged()
 	return c.cc.Close()
 }
 
-// 检查状态，若客户端关闭或崩溃则不可用
-func (c *Client) IsAvaliable() bool {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	return !c.shutdown && !c.closing
+// 连接的流量。NewClientWithCodec创建的客户端不经过net.Conn，只有零值
+func (c *Client) ConnStats() ConnInfo {
+	if c.conn == nil {
+		return ConnInfo{}
+	}
+	return newConnInfo(c.conn, c.cn)
 }
 
-// 将新的调用信息置入pending map当中，更新client的序号
+// 将新的调用信息置入pending map当中，分配序号
 func (c *Client) addCall(call *Call) (uint64, error) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	// 客户端已不可用
-	if c.closing || c.shutdown {
-		return 0, ErrShutDown
+	seq := c.nextSeq() // 计数从1开始，见seq.go
+	sh := c.shard(seq)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	// 在分片锁内检查状态：terminateCalls先改状态再逐个清空分片，
+	// 这里要么看到已关闭，要么放进去的call会被清理掉
+	if c.closing.Load() || c.shutdown.Load() || c.sendClosed.Load() {
+		return 0, c.errShutDown()
+	}
+	if c.draining.Load() {
+		return 0, ErrDraining
 	}
-	call.Seq = c.seq
-	c.pending[call.Seq] = call
-	c.seq++
-	return call.Seq, nil
+	call.Seq = seq
+	sh.calls[seq] = call
+	return seq, nil
 }
 
 // 按序号从pending map中移除Call并将其返回
 func (c *Client) removeCall(seq uint64) *Call {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	call := c.pending[seq] // 指针的零值就是nil
-	delete(c.pending, seq) // 幂等，重复无效
+	sh := c.shard(seq)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	call := sh.calls[seq] // 指针的零值就是nil
+	delete(sh.calls, seq) // 幂等，重复无效
 	return call
 }
 
 // 发生错误时的回调函数，需要终止客户端当前的一切调用。
 // 将错误信息写到call当中
 func (c *Client) terminateCalls(err error) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
 	// 阻止写数据，更新错误信息
 	c.sending.Lock()
 	defer c.sending.Unlock()
+	clientConns.Add(-1)
+	if c.stats != nil {
+		c.stats.HandleConn(c.ConnStats().end(true))
+	}
+	c.failPending(err)
+}
 
-	c.shutdown = true
-	// 修改所有的调用信息
-	for _, call := range c.pending {
-		call.Error = err
-		call.done()
+// 以err结束所有未完成的调用
+func (c *Client) failPending(err error) {
+	c.mu.Lock()
+	c.shutdown.Store(true)
+	c.mu.Unlock()
+	c.stateChanged()
+	c.window.close()
+	for i := range c.pending {
+		sh := &c.pending[i]
+		sh.mu.Lock()
+		for seq, call := range sh.calls {
+			delete(sh.calls, seq)
+			call.Error = err
+			c.finish(call)
+		}
+		sh.mu.Unlock()
 	}
 }
 
@@ -134,81 +274,235 @@ func (c *Client) receive() {
 	// 每当读取完一个body，就通知对应的call.done()
 
 	var err error
+	if c.conn != nil && c.cn != nil { // 经过Magic握手
+		if err = readRejection(c.cn.ReadWriteCloser); err != nil {
+			c.rejected.Store(&err)
+		}
+	}
 	for err == nil {
 		var h codec.Header
+		read := inBytes(c.cn)
 		if err = c.cc.ReadHeader(&h); err != nil { // 读不出数据EOF
 			break // return
 		}
-		// 读到一个响应的头部，标志着它对应的调用已经执行完毕，调用结果写给call
-		call := c.removeCall(h.Seq)
-		switch {
-		case call == nil: // 没能取到c.pending[h.Seq]
-			// call已经不存在/header在网络中传输出错，舍弃接下来的body
-			err = c.cc.ReadBody(nil)
-		case h.Error != "": // 根据header得知服务器返回了一个错误
-			call.Error = errors.New(h.Error)
-			err = c.cc.ReadBody(nil)
-			call.done()
-		default: // 正常情况
-			if err = c.cc.ReadBody(call.Reply); err != nil {
-				call.Error = errors.New("reading body error: " + err.Error())
+		c.cn.countRead()
+		if h.Seq == 0 && h.Name == windowFrame { // 服务端发放信用
+			var n uint32
+			if err = c.cc.ReadBody(&n); err == nil {
+				c.window.grant(int(n))
+			}
+			c.traceRecv(&h, read, n)
+			continue
+		}
+		if h.Seq == 0 && h.Name == keepaliveFrame { // 服务端的保活参数
+			var p keepaliveParams
+			if err = c.cc.ReadBody(&p); err == nil {
+				c.startPing(p.PingInterval)
 			}
-			call.done()
+			c.traceRecv(&h, read, p)
+			continue
+		}
+		if h.Seq == 0 && h.Name == goawayFrame { // 服务端正在关闭
+			err = c.cc.ReadBody(nil)
+			c.traceRecv(&h, read, nil)
+			c.drain()
+			continue
+		}
+		if h.Flags&codec.FlagReverse != 0 { // 服务端发起的请求
+			c.serveReverse(&h, read)
+			continue
+		}
+		if h.NameID != 0 { // 服务端确认了方法名的编号
+			c.names.ack(h.NameID)
+		}
+		err = c.handleResponse(&h, read)
+		if c.draining.Load() {
+			c.closeIfIdle()
 		}
 	}
 	// 从字节流中读取时发生了错误，客户端断开连接，终止未完成的调用
 	c.terminateCalls(err)
+	if c.pingStop != nil {
+		close(c.pingStop)
+	}
+	// 反向调用的方法都返回后再退出
+	c.reverseWG.Wait()
+}
+
+// 读到一个响应的头部，标志着它对应的调用已经执行完毕，读出消息体，调用结果写给call。
+// read是读头部之前连接上已读的字节数，返回连接上的错误
+func (c *Client) handleResponse(h *codec.Header, read int64) (err error) {
+	if h.Name == trailerFrame {
+		return c.handleTrailer(h, read)
+	}
+	call := c.removeCall(h.Seq)
+	switch {
+	case call == nil: // 没能取到c.pending[h.Seq]
+		// call已经不存在/header在网络中传输出错，舍弃接下来的body
+		err = c.cc.ReadBody(nil)
+		c.traceRecv(h, read, nil)
+	case h.Error != "": // 根据header得知服务器返回了一个错误
+		call.Error, err = c.readError(h)
+		c.traceRecv(h, read, nil)
+		c.received(call, int(inBytes(c.cn)-read))
+		c.complete(h, call)
+	default: // 正常情况
+		if err = c.cc.ReadBody(call.Reply); err != nil {
+			call.Error = errors.New("reading body error: " + err.Error())
+		}
+		c.traceRecv(h, read, call.Reply)
+		c.received(call, int(inBytes(c.cn)-read))
+		c.complete(h, call)
+	}
+	return err
+}
+
+// 读服务器返回的错误，没有错误码和详情时是ServerError，否则是*Error或解码器转换后的错误。
+// err是读消息体时连接上的错误
+func (c *Client) readError(h *codec.Header) (callErr, err error) {
+	if h.Code == 0 && len(h.Details) == 0 {
+		return ServerError(h.Error), c.cc.ReadBody(nil)
+	}
+	e := &Error{Code: Code(h.Code), Message: h.Error}
+	if e.Code == OK { // 有详情但没有错误码
+		e.Code = Unknown
+	}
+	if len(h.Details) == 0 {
+		return c.decodeError(e), c.cc.ReadBody(nil)
+	}
+	body, decode := decodeDetails(h.Details)
+	if err = c.cc.ReadBody(body); err != nil {
+		return e, err
+	}
+	e.Details = decode()
+	return c.decodeError(e), nil
 }
 
 // 检查codec支持，接管连接，写Magic(发送握手消息)，初始化Client并在另一goroutine启动
 func NewClient(conn net.Conn, codecType uint32) (*Client, error) {
-	ncf, ok := codec.NewCodecFuncMap[codecType]
+	return NewClientOptions(conn, WithClientCodecType(codecType))
+}
+
+// 同NewClient，编码类型等通过选项指定
+func NewClientOptions(conn net.Conn, opts ...ClientOption) (*Client, error) {
+	o := newClientOptions(opts)
+	if err := o.socket.apply(conn); err != nil {
+		log.Println("rpc client: set socket options error:", err)
+		return nil, err
+	}
+	ncf, ok := codec.NewCodecFuncMap[o.codecType]
 	if !ok {
-		err := fmt.Errorf("invalid codec type %v", codecType)
+		err := fmt.Errorf("invalid codec type %v", o.codecType)
 		log.Println("rpc client: codec error:", err)
 		return nil, err
 	}
 
 	buf := make([]byte, 8)
 	binary.BigEndian.PutUint32(buf, Magic)
-	binary.BigEndian.PutUint32(buf[4:], codecType)
-	_, err := conn.Write(buf)
-	if err != nil {
+	binary.BigEndian.PutUint32(buf[4:], o.codecType)
+	var rwc io.ReadWriteCloser = conn
+	if o.encryption != nil {
+		binary.BigEndian.PutUint32(buf, EncryptedMagic)
+		conn.SetReadDeadline(time.Now().Add(DefaultHandshakeTimeout))
+		secure, err := o.encryption.dial(conn, buf)
+		conn.SetReadDeadline(time.Time{})
+		if err != nil {
+			log.Println("rpc client: encrypted handshake error:", err)
+			conn.Close()
+			return nil, err
+		}
+		rwc = secure
+	} else if _, err := conn.Write(buf); err != nil {
 		log.Println("rpc client: write conn error:", err)
 		// 向连接写入时发生错误，断开连接
 		conn.Close()
 		return nil, err
 	}
 
-	client := &Client{
-		cc:      ncf(conn),
-		flag:    buf,
-		seq:     1, // gopl: 使用零值所具备的含义 => 正确的值从1开始
-		pending: make(map[uint64]*Call),
+	if o.readLimit != nil || o.writeLimit != nil {
+		rwc = &throttledConn{ReadWriteCloser: rwc, read: o.readLimit, write: o.writeLimit}
 	}
+	cn := newCountingConn(newBufferedConn(rwc, o.readBufferSize))
+	client := newClient(setCompression(ncf(cn), o.compress), conn, cn, o)
+	client.flag = buf
+	return client, nil
+}
 
+// 在已经建立好的codec上创建客户端，不发送Magic握手，
+// 用于自行实现协议的codec(如jsonrpc)
+func NewClientWithCodec(cc codec.Codec) *Client {
+	return newClient(cc, nil, nil, &clientOptions{})
+}
+
+// 配置好所有字段后才启动receive
+func newClient(cc codec.Codec, conn net.Conn, cn *countingConn, o *clientOptions) *Client {
+	client := &Client{cc: cc, window: newSendWindow(), stats: o.stats, conn: conn, cn: cn, reverse: o.reverse, faults: o.faults, clock: clockOrSystem(o.clock), decoders: o.decoders, signKey: o.signKey}
+	for i := range client.pending {
+		client.pending[i].calls = make(map[uint64]*Call)
+	}
+	if conn != nil {
+		client.target = conn.RemoteAddr().String()
+		client.seq.Store(newSeqBase())
+		client.startPing(o.pingInterval)
+		if o.ordered {
+			if err := client.requestOrder(); err != nil {
+				log.Println("rpc client: request ordered responses error:", err)
+			}
+		}
+	}
+	if bw, ok := cc.(codec.BatchWriter); ok && o.coalesceBytes > 0 {
+		client.bw = bw
+		client.maxBytes = o.coalesceBytes
+		client.maxDelay = o.coalesceDelay
+	}
+	client.compress = o.compress
+	client.baggage = o.baggage
+	if o.internNames {
+		client.names = newClientNames()
+	}
+	clientConns.Add(1)
+	if client.stats != nil {
+		begin := &ConnBegin{Client: true}
+		if conn != nil {
+			begin.LocalAddr, begin.RemoteAddr = conn.LocalAddr(), conn.RemoteAddr()
+		}
+		client.stats.HandleConn(begin)
+	}
 	go client.receive()
-	return client, nil
+	return client
 }
 
-// 实现一个包级的Dial方法方便用户操作
-func Dial(network, address string, codecType ...uint32) (*Client, error) {
-	ccType := codec.GobType
+// 可选的编码类型参数，默认gob
+func pickCodecType(codecType []uint32) (uint32, error) {
 	switch len(codecType) {
 	case 0:
+		return codec.GobType, nil
 	case 1:
-		ccType = codecType[0]
+		return codecType[0], nil
 	default:
 		err := errors.New("use case: Dial(\"tcp\", \"127.0.0.1:1234\", [codecType]")
 		log.Println("rpc client:", err)
+		return 0, err
+	}
+}
+
+// 实现一个包级的Dial方法方便用户操作
+func Dial(network, address string, codecType ...uint32) (*Client, error) {
+	ccType, err := pickCodecType(codecType)
+	if err != nil {
 		return nil, err
 	}
+	return DialOptions(network, address, WithClientCodecType(ccType))
+}
+
+// 同Dial，编码类型等通过选项指定
+func DialOptions(network, address string, opts ...ClientOption) (*Client, error) {
 	conn, err := net.Dial(network, address)
 	if err != nil {
 		log.Println("rpc client: dial error:", err)
 		return nil, err
 	}
-	client, err := NewClient(conn, ccType)
+	client, err := NewClientOptions(conn, opts...)
 	if err != nil {
 		// 创建客户端失败，断开连接
 		conn.Close()
@@ -218,41 +512,193 @@ func Dial(network, address string, codecType ...uint32) (*Client, error) {
 	return client, nil
 }
 
-// 将一次调用信息发送给服务器
-func (c *Client) send(call *Call) {
+// 将一次调用信息发送给服务器，服务端开启了流量控制时先等待信用
+func (c *Client) send(ctx context.Context, call *Call) {
+	if c.stats != nil {
+		call.ctx, call.start = ctx, time.Now()
+		service, _ := splitMethod(call.Name)
+		c.stats.HandleRPC(ctx, &Begin{Client: true, Method: call.Name, Service: service, Target: c.target, BeginTime: call.start})
+	}
+	if c.faults != nil && c.injectFault(ctx, call) {
+		return
+	}
+	if c.baggage != nil {
+		call.Metadata = c.baggage.inject(ctx, call.Metadata)
+	}
+	if c.signKey != nil {
+		call.Metadata = signMetadata(c.signKey, call.Name, call.Metadata, call.Args, c.clock.Now())
+	}
+	if err := c.window.acquire(ctx); err != nil {
+		if err == ErrShutDown {
+			err = c.errShutDown()
+		}
+		call.Error = err
+		c.finish(call)
+		return
+	}
 	// 保护发送数据头部。在Client中，我们封装了一个codec.Header方便这项工作，但要加锁
+	c.waiting.Add(1)
 	c.sending.Lock()
 	defer c.sending.Unlock()
+	// 之后还有请求在等锁，就把刷新留给它们
+	queued := c.waiting.Add(-1) > 0
 
 	// 客户端接收到用户指定的服务名、参数、返回值、(通道)，剩下的由客户端进行包装
 	seq, err := c.addCall(call)
 	if err != nil { // 这个call不能被添加进pending map，取消执行，写报错信息到call
 		call.Error = err
-		call.done()
+		c.finish(call)
 		return
 	}
 
 	call.Seq = seq
 	// header存放有上一个调用的遗留数据，刷新之
 	c.header.Seq = seq
-	c.header.Name = call.Name
+	c.names.encode(&c.header, call.Name)
 	c.header.Error = ""
+	c.header.Meta = call.Metadata
+	c.header.Timeout = 0
+	if !call.deadline.IsZero() {
+		// 已经过期的也要告诉服务端，让它直接按超时处理
+		c.header.Timeout = max(1, int64(call.deadline.Sub(c.clock.Now())))
+	}
 
-	if err := c.cc.Write(&c.header, call.Args); err != nil {
+	if call.compress != 0 {
+		if cc, ok := c.cc.(codec.Compressor); ok {
+			cc.SetCompression(call.compress)
+			defer cc.SetCompression(c.compress)
+		}
+	}
+	if err := c.write(ctx, call.Args, queued); err != nil {
 		// 向连接写入时发生错误，废弃这次请求
 		if call := c.removeCall(seq); call != nil { // 为空可以直接跳过
 			call.Error = err
-			call.done()
+			c.finish(call)
 		}
 	}
 }
 
+// 按WithClientFaults注入故障，返回true时请求不再发送
+func (c *Client) injectFault(ctx context.Context, call *Call) bool {
+	f := c.faults.pick(call.Name)
+	if f == nil {
+		return false
+	}
+	err := f.inject(ctx, c.clock, c.cc)
+	switch {
+	case err != nil:
+		call.Error = err
+		c.finish(call)
+	case f.Drop: // 等到ctx结束或连接断开
+		if _, err := c.addCall(call); err != nil {
+			call.Error = err
+			c.finish(call)
+		}
+	default:
+		return false
+	}
+	return true
+}
+
+// 请求写完的事件。写出之后call可能已经被receive结束并复用，只用c.header中的信息
+func (c *Client) statsOut(ctx context.Context, args any, n int) {
+	clientBytesWritten.Add(int64(n))
+	if t := c.trace.Load(); t != nil {
+		t.frame(c.remoteAddr(), "send", &c.header, n, args)
+	}
+	if c.stats != nil {
+		service, _ := splitMethod(c.header.Name)
+		c.stats.HandleRPC(ctx, &OutPayload{Client: true, Method: c.header.Name, Service: service, Target: c.target, Seq: c.header.Seq, Length: n, SentTime: time.Now()})
+	}
+}
+
+// 转储收到的帧，read是读这一帧之前连接上已读的字节数
+func (c *Client) traceRecv(h *codec.Header, read int64, body any) {
+	if t := c.trace.Load(); t != nil {
+		t.frame(c.remoteAddr(), "recv", h, int(inBytes(c.cn)-read), body)
+	}
+}
+
+// 收到响应的事件
+func (c *Client) received(call *Call, n int) {
+	clientBytesRead.Add(int64(n))
+	if c.stats != nil && !call.start.IsZero() {
+		service, _ := splitMethod(call.Name)
+		c.stats.HandleRPC(call.ctx, &InPayload{Client: true, Method: call.Name, Service: service, Target: c.target, Seq: call.Seq, Length: n, RecvTime: time.Now()})
+	}
+}
+
+// 结束调用，记录到expvar，设置了StatsHandler时先发出End
+func (c *Client) finish(call *Call) {
+	c.statsEnd(call)
+	call.done()
+}
+
+func (c *Client) statsEnd(call *Call) {
+	clientCalls.Add(1)
+	if call.Error != nil {
+		clientErrors.Add(1)
+	}
+	if c.stats != nil && !call.start.IsZero() {
+		service, _ := splitMethod(call.Name)
+		c.stats.HandleRPC(call.ctx, &End{
+			Client:    true,
+			Method:    call.Name,
+			Service:   service,
+			Target:    c.target,
+			Seq:       call.Seq,
+			BeginTime: call.start,
+			EndTime:   time.Now(),
+			Error:     call.Error,
+			Code:      CodeOf(call.Error),
+		})
+	}
+}
+
+// 写出c.header和请求体，调用方持有sending锁
+func (c *Client) write(ctx context.Context, args any, queued bool) error {
+	written := outBytes(c.cn, c.bw)
+	if c.bw == nil {
+		if err := c.cc.Write(&c.header, args); err != nil {
+			return err
+		}
+		c.cn.countWrite()
+		c.statsOut(ctx, args, int(outBytes(c.cn, nil)-written))
+		return nil
+	}
+	if err := c.bw.WriteBuffered(&c.header, args); err != nil {
+		return err
+	}
+	c.cn.countWrite()
+	// 在刷新之前发出，响应不会先于它到达
+	c.statsOut(ctx, args, int(outBytes(c.cn, c.bw)-written))
+	if c.since.IsZero() {
+		c.since = time.Now()
+	}
+	if queued && c.bw.Buffered() < c.maxBytes && time.Since(c.since) < c.maxDelay {
+		return nil
+	}
+	c.since = time.Time{}
+	if err := c.bw.Flush(); err != nil {
+		// 缓冲中可能还有别的请求，关闭连接让它们都由receive结束
+		c.cc.Close()
+		return err
+	}
+	return nil
+}
+
 // 异步调用
 // arithCall := cli.Go("Arith.Multiply", args, &reply, nil)
 // replyCall := <-arithCall.Done
-func (c *Client) Go(name string, args, reply any, done chan *Call) *Call {
+//
+// 多个调用共用done时，它的容量要足够放下所有同时完成的调用，放不下的结果被丢弃并记日志，
+// 计入expvar的client_dropped_calls_total。done无缓冲时panic
+func (c *Client) Go(name string, args, reply any, done chan *Call, opts ...CallOption) *Call {
 	if done == nil {
 		done = make(chan *Call, 1) // 非阻塞的，可以继续执行下去
+	} else if cap(done) == 0 {
+		// 与net/rpc一样，无缓冲的通道几乎一定会丢结果，直接拒绝
+		log.Panic("rpc client: done channel is unbuffered")
 	}
 
 	call := &Call{
@@ -261,13 +707,86 @@ func (c *Client) Go(name string, args, reply any, done chan *Call) *Call {
 		Reply: reply,
 		Done:  done,
 	}
-	c.send(call)
+	if len(opts) == 0 {
+		c.send(context.Background(), call)
+		return call
+	}
+	ctx, cancel := c.callContext(context.Background(), opts)
+	call.Metadata = OutgoingMetadata(ctx)
+	call.deadline, _ = ctx.Deadline()
+	call.compress = callCompression(ctx)
+	call.cancel = cancel
+	c.send(ctx, call)
+	// 超时后结束还在等待响应的调用；调用先结束时cancel也会触发这里，此时call已不在pending中
+	context.AfterFunc(ctx, func() {
+		if call := c.removeCall(call.Seq); call != nil {
+			call.Error = ctx.Err()
+			c.finish(call)
+		}
+	})
+	return call
+}
+
+// 同步调用只在内部使用Call，收到结果后放回池中复用，连同它的Done通道。
+// Go返回给用户的Call不能复用
+var callPool = sync.Pool{
+	New: func() any { return &Call{Done: make(chan *Call, 1)} },
+}
 
+func getCall(name string, args, reply any) *Call {
+	call := callPool.Get().(*Call)
+	call.Name, call.Args, call.Reply = name, args, reply
 	return call
 }
 
+// 只能在从Done收到call之后调用，此时没有其它地方再引用它
+func putCall(call *Call) {
+	*call = Call{Done: call.Done}
+	callPool.Put(call)
+}
+
 // 同步调用
-func (c *Client) Call(name string, args, reply any) error {
-	call := <-c.Go(name, args, reply, nil).Done
-	return call.Error
+func (c *Client) Call(name string, args, reply any, opts ...CallOption) error {
+	if len(opts) > 0 {
+		ctx, cancel := c.callContext(context.Background(), opts)
+		defer cancel()
+		return c.CallContext(ctx, name, args, reply)
+	}
+	call := getCall(name, args, reply)
+	c.send(context.Background(), call)
+	<-call.Done
+	err := call.Error
+	putCall(call)
+	return err
+}
+
+// 带ctx的同步调用，ctx中的元数据和期限随请求发送。
+// ctx结束时返回ctx.Err()，调用从等待响应的表中删除，之后到达的响应被丢弃；
+// 响应恰好正在读入reply时等它读完再返回，返回后reply不会再被修改。
+// 服务端处理超过期限时返回错误码为DeadlineExceeded的*Error，
+// 与客户端自己超时一样满足errors.Is(err, context.DeadlineExceeded)
+func (c *Client) CallContext(ctx context.Context, name string, args, reply any) error {
+	call := getCall(name, args, reply)
+	call.Metadata = OutgoingMetadata(ctx)
+	call.deadline, _ = ctx.Deadline()
+	call.compress = callCompression(ctx)
+	c.send(ctx, call)
+	select {
+	case <-ctx.Done():
+		if c.removeCall(call.Seq) != nil {
+			call.Error = ctx.Err()
+			c.finish(call)
+		}
+		// 没有删掉时receive已经取走了call，等它结束，之后才能复用call和reply
+		<-call.Done
+		putCall(call)
+		return ctx.Err()
+	case <-call.Done:
+		err := call.Error
+		if md, ok := ctx.Value(callTrailerKey{}).(*Metadata); ok {
+			*md = call.Trailer
+		}
+		putCall(call)
+		return err
+	}
 }
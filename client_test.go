@@ -0,0 +1,148 @@
+package mrpc
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTerminatePendingCalls(t *testing.T) {
+	s := NewServer()
+	s.Register(new(Greeter))
+	c1, c2 := net.Pipe()
+	go s.ServeConn(c2)
+	client, err := NewClient(c1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 分布在各个分片上的未完成调用，连接断开后都要结束
+	calls := make([]*Call, 3*pendingShards)
+	for i := range calls {
+		calls[i] = client.Go("Greeter.Slow", time.Second, new(int), nil)
+	}
+	c2.Close()
+	for i, call := range calls {
+		select {
+		case <-call.Done:
+			assert(t, call.Error != nil, "call %d should fail after disconnect", i)
+		case <-time.After(time.Second):
+			t.Fatalf("call %d not terminated", i)
+		}
+	}
+	assert(t, !client.IsAvailable(), "client should be unavailable")
+	call := <-client.Go("Greeter.Slow", time.Duration(0), new(int), nil).Done
+	assert(t, call.Error == ErrShutDown, "want ErrShutDown, got %v", call.Error)
+}
+
+func (c *Client) pendingCount() int {
+	n := 0
+	for i := range c.pending {
+		c.pending[i].mu.Lock()
+		n += len(c.pending[i].calls)
+		c.pending[i].mu.Unlock()
+	}
+	return n
+}
+
+func TestCallContextCancel(t *testing.T) {
+	stats := newRecordingStats()
+	s := NewServer()
+	s.Register(new(Greeter))
+	client := pipeClient(t, s, WithClientStatsHandler(stats))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+	err := client.CallContext(ctx, "Greeter.Slow", 50*time.Millisecond, new(int))
+	assert(t, errors.Is(err, context.Canceled), "want context.Canceled, got %v", err)
+	assert(t, client.pendingCount() == 0, "%d calls left pending", client.pendingCount())
+
+	// 迟到的响应被丢弃，连接照常可用
+	time.Sleep(80 * time.Millisecond)
+	var reply string
+	err = client.Call("Greeter.Hello", "mrpc", &reply)
+	assert(t, err == nil && reply == ", mrpc", "Greeter.Hello = %q, %v", reply, err)
+	stats.mu.Lock()
+	events := stats.calls["Greeter.Slow"]
+	stats.mu.Unlock()
+	assert(t, len(events) == 3 && events[2] == "end context canceled", "events %v", events)
+}
+
+func TestDoneChanCapacity(t *testing.T) {
+	client, _, err := NewClientServerPair(new(Greeter))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	func() {
+		defer func() {
+			assert(t, recover() != nil, "unbuffered done channel should panic")
+		}()
+		client.Go("Greeter.Slow", time.Duration(0), new(int), make(chan *Call))
+	}()
+
+	// 两个调用共用容量为1的通道且没人读，第二个结果被丢弃，receive不被卡住
+	dropped := clientDroppedCalls.Value()
+	done := make(chan *Call, 1)
+	first := client.Go("Greeter.Slow", time.Duration(0), new(int), done)
+	time.Sleep(10 * time.Millisecond)
+	client.Go("Greeter.Slow", time.Duration(0), new(int), done)
+	for i := 0; i < 100 && clientDroppedCalls.Value() == dropped; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	var reply string
+	err = client.Call("Greeter.Hello", "mrpc", &reply)
+	assert(t, err == nil && reply == ", mrpc", "receive is stuck: %q, %v", reply, err)
+	assert(t, <-done == first, "the first call should be delivered")
+	assert(t, clientDroppedCalls.Value() > dropped, "dropped call not counted")
+}
+
+func TestReadBufferSize(t *testing.T) {
+	for _, size := range []int{0, 16, 64 << 10} {
+		s := NewServer(WithReadBufferSize(size))
+		s.Register(new(Calc))
+		client := pipeClient(t, s, WithClientReadBufferSize(size))
+		var sum int
+		err := client.Call("Calc.Sum", Pair{size, 1}, &sum)
+		assert(t, err == nil && sum == size+1, "buffer %d: Calc.Sum = %d, %v", size, sum, err)
+		client.Close()
+	}
+}
+
+func TestSocketOptions(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	s := NewServer(WithNoDelay(false), WithSocketBuffers(64<<10, 64<<10))
+	s.Register(new(Calc))
+	go s.Accept(l)
+
+	client, err := DialOptions("tcp", l.Addr().String(),
+		WithClientNoDelay(false),
+		WithClientSocketBuffers(64<<10, 64<<10),
+		WithClientWriteCoalescing(1<<20, time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	assert(t, client.bw != nil, "gob codec should coalesce requests")
+
+	// 并发的请求合并写入后都能得到各自的响应
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var sum int
+			err := client.Call("Calc.Sum", Pair{i, 1}, &sum)
+			assert(t, err == nil && sum == i+1, "Calc.Sum(%d, 1) = %d, %v", i, sum, err)
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,76 @@
+package mrpc
+
+import (
+	"context"
+	"time"
+)
+
+// 时钟：超时、心跳、重试等计时都经过它，测试时换成mrpctest.FakeClock，
+// 手动拨动时间，不必真的等待
+//
+//	clock := mrpctest.NewFakeClock(time.Now())
+//	s := mrpc.NewServer(mrpc.WithClock(clock))
+//	...
+//	clock.Advance(time.Second)
+type Clock interface {
+	Now() time.Time
+	NewTimer(d time.Duration) Timer
+	NewTicker(d time.Duration) Ticker
+	// 同context.WithTimeout，期限按这个时钟计算
+	WithTimeout(ctx context.Context, d time.Duration) (context.Context, context.CancelFunc)
+}
+
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+}
+
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// 真实的时钟，默认使用它
+var SystemClock Clock = systemClock{}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+func (systemClock) NewTimer(d time.Duration) Timer { return systemTimer{time.NewTimer(d)} }
+
+func (systemClock) NewTicker(d time.Duration) Ticker { return systemTicker{time.NewTicker(d)} }
+
+func (systemClock) WithTimeout(ctx context.Context, d time.Duration) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, d)
+}
+
+type systemTimer struct{ *time.Timer }
+
+func (t systemTimer) C() <-chan time.Time { return t.Timer.C }
+
+type systemTicker struct{ *time.Ticker }
+
+func (t systemTicker) C() <-chan time.Time { return t.Ticker.C }
+
+// 为nil时用SystemClock
+func clockOrSystem(c Clock) Clock {
+	if c == nil {
+		return SystemClock
+	}
+	return c
+}
+
+// 服务端处理请求的超时、故障注入的延迟使用的时钟，默认SystemClock
+func WithClock(c Clock) ServerOption {
+	return func(s *Server) {
+		s.clock = clockOrSystem(c)
+	}
+}
+
+// 客户端计算请求剩余时间、故障注入的延迟使用的时钟，默认SystemClock
+func WithClientClock(c Clock) ClientOption {
+	return func(o *clientOptions) {
+		o.clock = clockOrSystem(c)
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/micplus/mrpc"
+)
+
+// 内置的压测目标，-serve启动的服务端注册它
+type Echo int
+
+func (*Echo) Echo(args []byte, reply *[]byte) error {
+	*reply = args
+	return nil
+}
+
+type Config struct {
+	Method      string
+	Concurrency int           // 并发发起调用的goroutine数
+	RPS         int           // 总的请求速率上限，<=0不限
+	Duration    time.Duration // 压测时长，与Requests先到者为准
+	Requests    int           // 总请求数，<=0不限
+	PayloadSize int           // 参数[]byte的长度
+}
+
+type Result struct {
+	Requests  int
+	Errors    int
+	Elapsed   time.Duration
+	Latencies []time.Duration // 成功调用的耗时，已排序
+	FirstErr  error
+}
+
+// 对clients轮流发起调用，参数是长度为PayloadSize的随机字节
+func run(clients []*mrpc.Client, cfg Config) *Result {
+	payload := make([]byte, cfg.PayloadSize)
+	rand.Read(payload)
+
+	// 所有worker从tokens取令牌，限速时由ticker发放
+	tokens := make(chan struct{})
+	stop := make(chan struct{})
+	go func() {
+		defer close(tokens)
+		var tick <-chan time.Time
+		if cfg.RPS > 0 {
+			t := time.NewTicker(time.Second / time.Duration(cfg.RPS))
+			defer t.Stop()
+			tick = t.C
+		}
+		var deadline <-chan time.Time
+		if cfg.Duration > 0 {
+			deadline = time.After(cfg.Duration)
+		}
+		for n := 0; cfg.Requests <= 0 || n < cfg.Requests; n++ {
+			if tick != nil {
+				select {
+				case <-tick:
+				case <-deadline:
+					return
+				case <-stop:
+					return
+				}
+			}
+			select {
+			case tokens <- struct{}{}:
+			case <-deadline:
+				return
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	var (
+		mu  sync.Mutex
+		res = new(Result)
+		wg  sync.WaitGroup
+	)
+	start := time.Now()
+	for i := 0; i < cfg.Concurrency; i++ {
+		wg.Add(1)
+		go func(client *mrpc.Client) {
+			defer wg.Done()
+			var lats []time.Duration
+			var errs int
+			var firstErr error
+			for range tokens {
+				var reply []byte
+				begin := time.Now()
+				if err := client.Call(cfg.Method, payload, &reply); err != nil {
+					errs++
+					if firstErr == nil {
+						firstErr = err
+					}
+					if err == mrpc.ErrShutDown { // 连接断了，继续压没有意义
+						break
+					}
+					continue
+				}
+				lats = append(lats, time.Since(begin))
+			}
+			mu.Lock()
+			res.Requests += len(lats) + errs
+			res.Errors += errs
+			res.Latencies = append(res.Latencies, lats...)
+			if res.FirstErr == nil {
+				res.FirstErr = firstErr
+			}
+			mu.Unlock()
+		}(clients[i%len(clients)])
+	}
+	wg.Wait()
+	close(stop)
+	res.Elapsed = time.Since(start)
+	sort.Slice(res.Latencies, func(i, j int) bool { return res.Latencies[i] < res.Latencies[j] })
+	return res
+}
+
+// 第p百分位的耗时，p取(0,100]
+func (r *Result) Percentile(p float64) time.Duration {
+	if len(r.Latencies) == 0 {
+		return 0
+	}
+	i := int(float64(len(r.Latencies))*p/100+0.5) - 1
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(r.Latencies) {
+		i = len(r.Latencies) - 1
+	}
+	return r.Latencies[i]
+}
+
+func (r *Result) Mean() time.Duration {
+	if len(r.Latencies) == 0 {
+		return 0
+	}
+	var sum time.Duration
+	for _, l := range r.Latencies {
+		sum += l
+	}
+	return sum / time.Duration(len(r.Latencies))
+}
+
+func (r *Result) Report(w io.Writer) {
+	qps := float64(r.Requests) / r.Elapsed.Seconds()
+	fmt.Fprintf(w, "requests: %d  errors: %d  elapsed: %v  throughput: %.1f req/s\n",
+		r.Requests, r.Errors, r.Elapsed.Round(time.Millisecond), qps)
+	if len(r.Latencies) > 0 {
+		fmt.Fprintf(w, "latency: min %v  mean %v  p50 %v  p90 %v  p99 %v  p99.9 %v  max %v\n",
+			r.Latencies[0], r.Mean(), r.Percentile(50), r.Percentile(90),
+			r.Percentile(99), r.Percentile(99.9), r.Latencies[len(r.Latencies)-1])
+	}
+	if r.FirstErr != nil {
+		fmt.Fprintln(w, "first error:", r.FirstErr)
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/micplus/mrpc"
+)
+
+func TestRun(t *testing.T) {
+	client, _, err := mrpc.NewClientServerPair(new(Echo))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	res := run([]*mrpc.Client{client}, Config{Method: "Echo.Echo", Concurrency: 4, Requests: 200, PayloadSize: 64})
+	if res.Requests != 200 || res.Errors != 0 || len(res.Latencies) != 200 {
+		t.Fatalf("unexpected result: %d requests, %d errors, %v", res.Requests, res.Errors, res.FirstErr)
+	}
+	if res.Percentile(50) > res.Percentile(99) || res.Percentile(100) != res.Latencies[199] {
+		t.Error("percentiles out of order")
+	}
+	var out bytes.Buffer
+	res.Report(&out)
+	if !strings.Contains(out.String(), "p99") {
+		t.Errorf("report missing percentiles: %s", out.String())
+	}
+
+	// 限速100rps，0.2秒左右只能发出约20个请求
+	res = run([]*mrpc.Client{client}, Config{Method: "Echo.Echo", Concurrency: 4, RPS: 100, Duration: 200 * time.Millisecond})
+	if res.Requests == 0 || res.Requests > 25 {
+		t.Errorf("rate limited run sent %d requests", res.Requests)
+	}
+
+	res = run([]*mrpc.Client{client}, Config{Method: "Echo.Missing", Concurrency: 2, Requests: 10})
+	if res.Errors != 10 || res.FirstErr == nil {
+		t.Errorf("want 10 errors, got %d", res.Errors)
+	}
+}
@@ -0,0 +1,86 @@
+// mrpcbench 对mrpc服务端压测，报告吞吐和耗时分位数
+//
+// 目标方法的参数和响应都应当是[]byte，例如内置的Echo.Echo：
+//
+//	mrpcbench -serve :9999                                   # 启动内置的Echo服务端
+//	mrpcbench -addr tcp@127.0.0.1:9999 -c 64 -conns 4 -d 10s -size 1024
+//	mrpcbench -addr 127.0.0.1:9999 -rps 5000 -n 100000
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/micplus/mrpc"
+	"github.com/micplus/mrpc/codec"
+)
+
+// 只列出codec.NewCodecFuncMap中已注册的编码，否则握手会失败
+var codecTypes = map[string]uint32{
+	"gob":     codec.GobType,
+	"json":    codec.JSONType,
+	"binjson": codec.BinaryJSONType,
+}
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("mrpcbench: ")
+	var (
+		serve     = flag.String("serve", "", "run the built-in Echo server on this address instead of benchmarking")
+		addr      = flag.String("addr", "", "[network@]address of the server")
+		method    = flag.String("method", "Echo.Echo", "method to call; args and reply must be []byte")
+		codecName = flag.String("codec", "gob", "codec: gob, json or binjson")
+		conns     = flag.Int("conns", 1, "number of connections")
+		cfg       Config
+	)
+	flag.IntVar(&cfg.Concurrency, "c", 16, "number of concurrent callers")
+	flag.IntVar(&cfg.RPS, "rps", 0, "total requests per second; 0 means unlimited")
+	flag.DurationVar(&cfg.Duration, "d", 10*time.Second, "benchmark duration")
+	flag.IntVar(&cfg.Requests, "n", 0, "total requests; 0 means until -d elapses")
+	flag.IntVar(&cfg.PayloadSize, "size", 128, "payload size in bytes")
+	flag.Parse()
+
+	if *serve != "" {
+		lis, err := net.Listen("tcp", *serve)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := mrpc.Register(new(Echo)); err != nil {
+			log.Fatal(err)
+		}
+		log.Println("serving Echo on", lis.Addr())
+		mrpc.Accept(lis)
+		return
+	}
+	if *addr == "" || *conns <= 0 || cfg.Concurrency <= 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	ccType, ok := codecTypes[*codecName]
+	if !ok {
+		log.Fatalf("unknown codec %q", *codecName)
+	}
+	network, address := "tcp", *addr
+	if i := strings.Index(address, "@"); i >= 0 {
+		network, address = address[:i], address[i+1:]
+	}
+	clients := make([]*mrpc.Client, *conns)
+	for i := range clients {
+		c, err := mrpc.Dial(network, address, ccType)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer c.Close()
+		clients[i] = c
+	}
+
+	cfg.Method = *method
+	fmt.Printf("benchmarking %s on %s: %d conns, %d callers, %d-byte payload\n",
+		cfg.Method, *addr, *conns, cfg.Concurrency, cfg.PayloadSize)
+	run(clients, cfg).Report(os.Stdout)
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+
+	"github.com/micplus/mrpc"
+)
+
+func (cmd *command) findMethod(name string) (mrpc.MethodInfo, error) {
+	dot := strings.LastIndex(name, ".")
+	if dot < 0 {
+		return mrpc.MethodInfo{}, fmt.Errorf("method name %q must be like \"Service.Method\"", name)
+	}
+	info, err := cmd.describeService(name[:dot])
+	if err != nil {
+		return mrpc.MethodInfo{}, err
+	}
+	for _, m := range info.Methods {
+		if m.Name == name[dot+1:] {
+			return m, nil
+		}
+	}
+	return mrpc.MethodInfo{}, fmt.Errorf("cannot find method %s", name)
+}
+
+// 按反射服务给出的类型把JSON解码成参数，调用后把响应编码成JSON输出
+func (cmd *command) invoke(name, input string) error {
+	m, err := cmd.findMethod(name)
+	if err != nil {
+		return err
+	}
+	argType, err := m.Args.Type()
+	if err != nil {
+		return fmt.Errorf("args of %s: %w", name, err)
+	}
+	replyType, err := m.Reply.Type()
+	if err != nil {
+		return fmt.Errorf("reply of %s: %w", name, err)
+	}
+
+	if input == "-" {
+		b, err := io.ReadAll(cmd.in)
+		if err != nil {
+			return err
+		}
+		input = string(b)
+	}
+	argv := reflect.New(argType)
+	if argType.Kind() == reflect.Pointer { // 省略参数时也不能发送nil指针
+		argv.Elem().Set(reflect.New(argType.Elem()))
+	}
+	if strings.TrimSpace(input) != "" {
+		if err := json.Unmarshal([]byte(input), argv.Interface()); err != nil {
+			return fmt.Errorf("decode args as %s: %w", m.Args, err)
+		}
+	}
+	// reply总是指针
+	replyv := reflect.New(replyType.Elem())
+	if err := cmd.call(name, argv.Elem().Interface(), replyv.Interface()); err != nil {
+		return err
+	}
+	out, err := json.MarshalIndent(replyv.Elem().Interface(), "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(cmd.out, string(out))
+	return nil
+}
@@ -0,0 +1,124 @@
+// mrpccall 连接一个开启了反射服务(Server.RegisterReflection)的服务端，
+// 列出服务、查看方法签名，或者用JSON参数发起一次调用并以JSON打印结果，用于排查线上部署。
+//
+// 用法：
+//
+//	mrpccall [-timeout 5s] tcp@127.0.0.1:9999 list
+//	mrpccall tcp@127.0.0.1:9999 describe Arith
+//	mrpccall tcp@127.0.0.1:9999 call Arith.Add '{"A":1,"B":2}'
+//
+// 地址的网络部分可以省略，默认tcp；参数为"-"时从标准输入读取，省略时使用零值
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/micplus/mrpc"
+)
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("mrpccall: ")
+	timeout := flag.Duration("timeout", 5*time.Second, "timeout of each call")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: mrpccall [-timeout d] [network@]addr list | describe Service | call Service.Method [json|-]\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() < 2 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	network, address := "tcp", flag.Arg(0)
+	if i := strings.Index(address, "@"); i >= 0 {
+		network, address = address[:i], address[i+1:]
+	}
+	client, err := mrpc.Dial(network, address)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer client.Close()
+
+	cmd := &command{c: client, timeout: *timeout, in: os.Stdin, out: os.Stdout}
+	if err := cmd.run(flag.Args()[1:]); err != nil {
+		log.Fatal(err)
+	}
+}
+
+type command struct {
+	c       *mrpc.Client
+	timeout time.Duration
+	in      io.Reader
+	out     io.Writer
+}
+
+func (cmd *command) run(args []string) error {
+	switch args[0] {
+	case "list":
+		return cmd.list()
+	case "describe":
+		if len(args) != 2 {
+			return errors.New("usage: describe Service")
+		}
+		return cmd.describe(args[1])
+	case "call":
+		if len(args) < 2 || len(args) > 3 {
+			return errors.New("usage: call Service.Method [json|-]")
+		}
+		input := ""
+		if len(args) == 3 {
+			input = args[2]
+		}
+		return cmd.invoke(args[1], input)
+	}
+	return fmt.Errorf("unknown command %q", args[0])
+}
+
+// 超时后不再等待响应
+func (cmd *command) call(name string, args, reply any) error {
+	select {
+	case call := <-cmd.c.Go(name, args, reply, nil).Done:
+		return call.Error
+	case <-time.After(cmd.timeout):
+		return fmt.Errorf("call %s: timeout after %v", name, cmd.timeout)
+	}
+}
+
+func (cmd *command) list() error {
+	var services []mrpc.ServiceInfo
+	if err := cmd.call(mrpc.ReflectionServiceName+".List", 0, &services); err != nil {
+		return err
+	}
+	for _, svc := range services {
+		fmt.Fprintln(cmd.out, svc.Name)
+		for _, m := range svc.Methods {
+			fmt.Fprintf(cmd.out, "\t%s\n", m.Name)
+		}
+	}
+	return nil
+}
+
+func (cmd *command) describeService(name string) (mrpc.ServiceInfo, error) {
+	var info mrpc.ServiceInfo
+	err := cmd.call(mrpc.ReflectionServiceName+".Describe", name, &info)
+	return info, err
+}
+
+func (cmd *command) describe(name string) error {
+	info, err := cmd.describeService(name)
+	if err != nil {
+		return err
+	}
+	for _, m := range info.Methods {
+		fmt.Fprintf(cmd.out, "%s.%s(%s, %s)\n", info.Name, m.Name, m.Args, m.Reply)
+	}
+	return nil
+}
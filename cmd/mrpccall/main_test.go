@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/micplus/mrpc"
+)
+
+type Args struct {
+	A, B int
+}
+
+type Result struct {
+	Sum   int
+	Items []string
+}
+
+type Arith int
+
+func (*Arith) Add(args *Args, reply *Result) error {
+	reply.Sum = args.A + args.B
+	reply.Items = []string{"a", "b"}
+	return nil
+}
+
+func TestCommand(t *testing.T) {
+	client, s, err := mrpc.NewClientServerPair(new(Arith))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	s.RegisterReflection()
+
+	var out bytes.Buffer
+	cmd := &command{c: client, timeout: time.Second, in: strings.NewReader(`{"A":3,"B":4}`), out: &out}
+	for _, tt := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"list"}, "Arith\n\tAdd\nReflection\n\tDescribe\n\tList\n"},
+		{[]string{"describe", "Arith"}, "Arith.Add(*main.Args, *main.Result)\n"},
+		{[]string{"call", "Arith.Add", `{"A":1,"B":2}`}, "\"Sum\": 3"},
+		{[]string{"call", "Arith.Add", "-"}, "\"Sum\": 7"},
+		{[]string{"call", "Arith.Add"}, "\"Sum\": 0"},
+	} {
+		out.Reset()
+		if err := cmd.run(tt.args); err != nil {
+			t.Errorf("%v: %v", tt.args, err)
+			continue
+		}
+		if !strings.Contains(out.String(), tt.want) {
+			t.Errorf("%v: output %q does not contain %q", tt.args, out.String(), tt.want)
+		}
+	}
+
+	for _, args := range [][]string{
+		{"call", "Arith.Missing"},
+		{"call", "Arith.Add", `{"A":"x"}`},
+		{"describe", "Missing"},
+	} {
+		if err := cmd.run(args); err == nil {
+			t.Errorf("%v: want error", args)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"go/token"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+const mrpcPath = "github.com/micplus/mrpc"
+
+// 模板中传给"handlers"的参数
+type handlerArgs struct {
+	Service, Recv string
+	Methods       []method
+}
+
+var fileTmpl = template.Must(template.New("file").Funcs(template.FuncMap{
+	"handlers": func(service, recv string, methods []method) handlerArgs {
+		return handlerArgs{service, recv, methods}
+	},
+}).Parse(`// Code generated by mrpcgen. DO NOT EDIT.
+
+package {{.Package}}
+
+import (
+{{- range .StdImports}}
+	{{.}}
+{{- end}}
+{{range .Imports}}
+	{{.}}
+{{- end}}
+)
+{{range .Services}}
+// {{.Name}}Client 是{{.Name}}服务的类型化客户端
+type {{.Name}}Client struct {
+	c *mrpc.Client
+}
+
+func New{{.Name}}Client(c *mrpc.Client) *{{.Name}}Client {
+	return &{{.Name}}Client{c: c}
+}
+
+// ctx中的元数据随请求发送，ctx结束时立即返回ctx.Err()，不再等待响应
+func (x *{{.Name}}Client) call(ctx context.Context, name string, args, reply any) error {
+	return x.c.CallContext(ctx, name, args, reply)
+}
+{{$svc := .Name}}{{range .Methods}}
+// {{.Name}} 调用{{$svc}}.{{.Name}}
+func (x *{{$svc}}Client) {{.Name}}(ctx context.Context, args {{.Args}}) ({{.Reply}}, error) {
+	var reply {{.Reply}}
+	if err := x.call(ctx, "{{$svc}}.{{.Name}}", args, &reply); err != nil {
+		var zero {{.Reply}}
+		return zero, err
+	}
+	return reply, nil
+}
+{{end}}
+{{- if .Interface}}
+// Register{{.Name}} 以服务名"{{.Name}}"注册impl，参数类型保证impl在编译期实现了{{.Name}}
+func Register{{.Name}}(s *mrpc.Server, impl {{.Name}}) error {
+{{- template "handlers" (handlers .Name "impl" .Methods)}}
+	return nil
+}
+{{- if .Impl}}
+
+// 编译期检查{{.Impl}}实现了{{.Name}}
+var _ {{.Name}} = {{.ImplValue}}
+{{- end}}
+{{else}}
+// Register{{.Name}} 把{{.Name}}注册到服务端
+func Register{{.Name}}(s *mrpc.Server, rcvr *{{.Name}}) error {
+{{- template "handlers" (handlers .Name "rcvr" .Methods)}}
+	return nil
+}
+{{end}}
+{{- end}}
+
+{{- define "handlers"}}
+{{- $svc := .Service}}{{$recv := .Recv}}
+	// 直接调用方法，不经过反射
+{{- range .Methods}}
+{{- if .Context}}
+	if err := mrpc.HandleFunc(s, "{{$svc}}.{{.Name}}", {{$recv}}.{{.Name}}); err != nil {
+		return err
+	}
+{{- else}}
+	if err := mrpc.HandleFunc(s, "{{$svc}}.{{.Name}}", func(_ context.Context, args {{.Args}}, reply *{{.Reply}}) error {
+		return {{$recv}}.{{.Name}}(args, reply)
+	}); err != nil {
+		return err
+	}
+{{- end}}
+{{- end}}
+{{- end}}`))
+
+// 为多个服务类型生成同一个文件，impls记录接口到实现类型的对应关系
+func generate(pkg *pkgInfo, typeNames []string, impls map[string]string) ([]byte, error) {
+	imports := map[string]string{"context": "context", "mrpc": mrpcPath}
+	var services []*service
+	for _, name := range typeNames {
+		svc, err := pkg.service(strings.TrimSpace(name))
+		if err != nil {
+			return nil, err
+		}
+		if !token.IsExported(svc.Name) {
+			return nil, fmt.Errorf("type %s is not exported and cannot be used as a service name", svc.Name)
+		}
+		if impl, ok := impls[svc.Name]; ok {
+			if !svc.Interface {
+				return nil, fmt.Errorf("-impl given for %s, which is not an interface", svc.Name)
+			}
+			svc.Impl = impl
+		}
+		for n, path := range svc.imports {
+			imports[n] = path
+		}
+		services = append(services, svc)
+	}
+
+	// 标准库和其他包分成两组
+	var stdLines, importLines []string
+	for name, path := range imports {
+		line := fmt.Sprintf("%q", path)
+		if path != name && !strings.HasSuffix(path, "/"+name) {
+			line = name + " " + line
+		}
+		if strings.Contains(strings.SplitN(path, "/", 2)[0], ".") {
+			importLines = append(importLines, line)
+		} else {
+			stdLines = append(stdLines, line)
+		}
+	}
+	sort.Strings(stdLines)
+	sort.Strings(importLines)
+
+	var buf bytes.Buffer
+	err := fileTmpl.Execute(&buf, map[string]any{
+		"Package":    pkg.name,
+		"StdImports": stdLines,
+		"Imports":    importLines,
+		"Services":   services,
+	})
+	if err != nil {
+		return nil, err
+	}
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("format generated code: %v\n%s", err, buf.Bytes())
+	}
+	return src, nil
+}
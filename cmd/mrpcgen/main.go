@@ -0,0 +1,73 @@
+// mrpcgen 根据服务类型生成类型化的客户端桩代码和注册代码，
+// 调用方不再需要手写"Service.Method"字符串和any参数，类型错误在编译期暴露。
+//
+// 用法(通常写在go:generate中)：
+//
+//	//go:generate mrpcgen -type Arith
+//
+// 会在同一目录生成arith_mrpc.go，包含：
+//
+//	type ArithClient struct{ ... }
+//	func NewArithClient(c *mrpc.Client) *ArithClient
+//	func (x *ArithClient) Add(ctx context.Context, args *Args) (int, error)
+//	func RegisterArith(s *mrpc.Server, rcvr *Arith) error
+//
+// -type也可以是接口，此时生成服务端骨架：RegisterX(s, impl X)要求impl实现接口X，
+// 并以接口名注册服务；用-impl X=*xImpl可额外生成"var _ X = (*xImpl)(nil)"的编译期检查
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("mrpcgen: ")
+	var (
+		typeNames = flag.String("type", "", "comma-separated list of service type names; must be set")
+		dir       = flag.String("dir", ".", "directory of the package containing the types")
+		output    = flag.String("output", "", "output file name; default <dir>/<type>_mrpc.go")
+		implPairs = flag.String("impl", "", "comma-separated Interface=ImplType pairs to check at compile time")
+	)
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: mrpcgen -type T [-impl I=*T] [-dir d] [-output file]\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if *typeNames == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	types := strings.Split(*typeNames, ",")
+
+	pkg, err := parsePackage(*dir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	impls := make(map[string]string)
+	if *implPairs != "" {
+		for _, pair := range strings.Split(*implPairs, ",") {
+			iface, impl, ok := strings.Cut(pair, "=")
+			if !ok {
+				log.Fatalf("invalid -impl %q, want Interface=ImplType", pair)
+			}
+			impls[strings.TrimSpace(iface)] = strings.TrimSpace(impl)
+		}
+	}
+	src, err := generate(pkg, types, impls)
+	if err != nil {
+		log.Fatal(err)
+	}
+	out := *output
+	if out == "" {
+		out = filepath.Join(*dir, strings.ToLower(types[0])+"_mrpc.go")
+	}
+	if err := os.WriteFile(out, src, 0o644); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"go/parser"
+	"go/token"
+	"strings"
+	"testing"
+)
+
+func TestGenerate(t *testing.T) {
+	pkg, err := parsePackage("testdata/arith")
+	if err != nil {
+		t.Fatal(err)
+	}
+	src, err := generate(pkg, []string{"Arith"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parser.ParseFile(token.NewFileSet(), "arith_mrpc.go", src, 0); err != nil {
+		t.Fatalf("generated code does not parse: %v\n%s", err, src)
+	}
+	code := string(src)
+	for _, want := range []string{
+		`"time"`,
+		"func (x *ArithClient) Add(ctx context.Context, args *Args) (int, error)",
+		"func (x *ArithClient) Sleep(ctx context.Context, args time.Duration) (time.Time, error)",
+		`x.call(ctx, "Arith.Add", args, &reply)`,
+		"func (x *ArithClient) Mul(ctx context.Context, args *Args) (int, error)",
+		"func RegisterArith(s *mrpc.Server, rcvr *Arith) error",
+		`mrpc.HandleFunc(s, "Arith.Mul", rcvr.Mul)`,
+		`return rcvr.Add(args, reply)`,
+	} {
+		if !strings.Contains(code, want) {
+			t.Errorf("generated code missing %q", want)
+		}
+	}
+	for _, unwanted := range []string{"Reset", "helper"} {
+		if strings.Contains(code, unwanted) {
+			t.Errorf("generated code should not contain %q", unwanted)
+		}
+	}
+}
+
+func TestGenerateSkeleton(t *testing.T) {
+	pkg, err := parsePackage("testdata/arith")
+	if err != nil {
+		t.Fatal(err)
+	}
+	src, err := generate(pkg, []string{"Calculator"}, map[string]string{"Calculator": "calc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	code := string(src)
+	for _, want := range []string{
+		"func (x *CalculatorClient) Add(ctx context.Context, args *Args) (int, error)",
+		"func RegisterCalculator(s *mrpc.Server, impl Calculator) error",
+		`mrpc.HandleFunc(s, "Calculator.Add", func(_ context.Context, args *Args, reply *int) error {`,
+		`return impl.Add(args, reply)`,
+		"var _ Calculator = *new(calc)",
+	} {
+		if !strings.Contains(code, want) {
+			t.Errorf("generated code missing %q\n%s", want, code)
+		}
+	}
+
+	if _, err := generate(pkg, []string{"Arith"}, map[string]string{"Arith": "*Arith"}); err == nil {
+		t.Error("-impl on a non-interface type should fail")
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// 解析得到的包
+type pkgInfo struct {
+	name  string
+	files []*ast.File
+}
+
+// 一个可以被远程调用的方法：func (T) Name(args A, reply *R) error
+type method struct {
+	Name    string
+	Args    string // 参数类型表达式
+	Reply   string // reply指向的类型，生成的客户端直接返回它
+	Context bool   // 方法的第一个参数是context.Context
+}
+
+type service struct {
+	Name      string // 类型名，也是默认的服务名
+	Interface bool
+	Methods   []method
+	Impl      string            // 接口的实现类型，如"*arith"，用于生成编译期检查
+	imports   map[string]string // 方法签名引用到的包：名称 -> 路径
+}
+
+// 实现类型的零值表达式
+func (svc *service) ImplValue() string {
+	if strings.HasPrefix(svc.Impl, "*") {
+		return "(" + svc.Impl + ")(nil)"
+	}
+	return "*new(" + svc.Impl + ")"
+}
+
+// 解析目录下的非测试Go文件，忽略已生成的文件
+func parsePackage(dir string) (*pkgInfo, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	fset := token.NewFileSet()
+	info := &pkgInfo{}
+	for _, e := range entries { // ReadDir按文件名排序，输出稳定
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, ".go") ||
+			strings.HasSuffix(name, "_test.go") || strings.HasSuffix(name, "_mrpc.go") {
+			continue
+		}
+		f, err := parser.ParseFile(fset, filepath.Join(dir, name), nil, 0)
+		if err != nil {
+			return nil, err
+		}
+		if info.name == "" {
+			info.name = f.Name.Name
+		} else if f.Name.Name != info.name {
+			return nil, fmt.Errorf("multiple packages in %s: %s, %s", dir, info.name, f.Name.Name)
+		}
+		info.files = append(info.files, f)
+	}
+	if info.name == "" {
+		return nil, fmt.Errorf("no Go files in %s", dir)
+	}
+	return info, nil
+}
+
+// 找到类型声明及它的RPC方法。结构体取接收者为T或*T的方法，接口取其方法集
+func (p *pkgInfo) service(typeName string) (*service, error) {
+	svc := &service{Name: typeName, imports: make(map[string]string)}
+	found := false
+	for _, f := range p.files {
+		for _, decl := range f.Decls {
+			switch d := decl.(type) {
+			case *ast.GenDecl:
+				for _, spec := range d.Specs {
+					ts, ok := spec.(*ast.TypeSpec)
+					if !ok || ts.Name.Name != typeName {
+						continue
+					}
+					found = true
+					if it, ok := ts.Type.(*ast.InterfaceType); ok {
+						svc.Interface = true
+						for _, m := range it.Methods.List {
+							ft, ok := m.Type.(*ast.FuncType)
+							if !ok || len(m.Names) == 0 {
+								continue // 嵌入的接口暂不展开
+							}
+							svc.add(f, m.Names[0].Name, ft)
+						}
+					}
+				}
+			case *ast.FuncDecl:
+				if d.Recv == nil || len(d.Recv.List) != 1 || receiverName(d.Recv.List[0].Type) != typeName {
+					continue
+				}
+				svc.add(f, d.Name.Name, d.Type)
+			}
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("type %s not found in package %s", typeName, p.name)
+	}
+	if len(svc.Methods) == 0 {
+		return nil, fmt.Errorf("type %s has no methods suitable for rpc", typeName)
+	}
+	sort.Slice(svc.Methods, func(i, j int) bool { return svc.Methods[i].Name < svc.Methods[j].Name })
+	return svc, nil
+}
+
+func receiverName(expr ast.Expr) string {
+	if star, ok := expr.(*ast.StarExpr); ok {
+		expr = star.X
+	}
+	if id, ok := expr.(*ast.Ident); ok {
+		return id.Name
+	}
+	return ""
+}
+
+// 与mrpc.Server注册时的规则一致：导出方法、两个参数(前面可以有context.Context)、
+// 第二个是指针、返回error
+func (svc *service) add(f *ast.File, name string, ft *ast.FuncType) {
+	if !ast.IsExported(name) {
+		return
+	}
+	var params []ast.Expr
+	for _, field := range ft.Params.List {
+		n := len(field.Names)
+		if n == 0 {
+			n = 1
+		}
+		for i := 0; i < n; i++ {
+			params = append(params, field.Type)
+		}
+	}
+	withContext := false
+	if len(params) == 3 && types.ExprString(params[0]) == "context.Context" {
+		params, withContext = params[1:], true
+	}
+	if len(params) != 2 || ft.Results == nil || len(ft.Results.List) != 1 {
+		return
+	}
+	if id, ok := ft.Results.List[0].Type.(*ast.Ident); !ok || id.Name != "error" {
+		return
+	}
+	reply, ok := params[1].(*ast.StarExpr)
+	if !ok {
+		return
+	}
+	svc.collectImports(f, params[0])
+	svc.collectImports(f, reply.X)
+	svc.Methods = append(svc.Methods, method{
+		Name:    name,
+		Args:    types.ExprString(params[0]),
+		Reply:   types.ExprString(reply.X),
+		Context: withContext,
+	})
+}
+
+// 记录类型表达式中引用的其他包
+func (svc *service) collectImports(f *ast.File, expr ast.Expr) {
+	ast.Inspect(expr, func(n ast.Node) bool {
+		sel, ok := n.(*ast.SelectorExpr)
+		if !ok {
+			return true
+		}
+		id, ok := sel.X.(*ast.Ident)
+		if !ok {
+			return true
+		}
+		for _, imp := range f.Imports {
+			path, _ := strconv.Unquote(imp.Path.Value)
+			name := path[strings.LastIndex(path, "/")+1:]
+			if imp.Name != nil {
+				name = imp.Name.Name
+			}
+			if name == id.Name {
+				svc.imports[name] = path
+			}
+		}
+		return false
+	})
+}
@@ -0,0 +1,43 @@
+package arith
+
+import (
+	"context"
+	"time"
+)
+
+type Args struct{ A, B int }
+
+type Arith int
+
+func (*Arith) Add(args *Args, reply *int) error {
+	*reply = args.A + args.B
+	return nil
+}
+
+func (*Arith) Sleep(d time.Duration, reply *time.Time) error {
+	time.Sleep(d)
+	*reply = time.Now()
+	return nil
+}
+
+func (*Arith) Mul(ctx context.Context, args *Args, reply *int) error {
+	*reply = args.A * args.B
+	return nil
+}
+
+// 不符合RPC签名，不会生成
+func (*Arith) Reset() {}
+
+func (*Arith) helper(args *Args, reply *int) error { return nil }
+
+// 接口形式声明的服务，由未导出的calc实现
+type Calculator interface {
+	Add(args *Args, reply *int) error
+}
+
+type calc struct{}
+
+func (calc) Add(args *Args, reply *int) error {
+	*reply = args.A + args.B
+	return nil
+}
@@ -0,0 +1,66 @@
+// mrpcreplay 查看或回放wire.Recorder录下的流量
+//
+//	mrpcreplay -file capture.bin                          # 打印每一帧
+//	mrpcreplay -file capture.bin -addr tcp@127.0.0.1:9999 -speed 1 -loops 10
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/micplus/mrpc/wire"
+)
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("mrpcreplay: ")
+	var (
+		file  = flag.String("file", "", "recorded file; must be set")
+		addr  = flag.String("addr", "", "[network@]address to replay against; print frames if empty")
+		speed = flag.Float64("speed", 0, "replay speed relative to the recording; 0 sends as fast as possible")
+		wait  = flag.Duration("wait", time.Second, "how long to wait for responses after the last request")
+		loops = flag.Int("loops", 1, "times to replay each connection")
+	)
+	flag.Parse()
+	if *file == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	f, err := os.Open(*file)
+	if err != nil {
+		log.Fatal(err)
+	}
+	frames, err := wire.ReadAll(f)
+	f.Close()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *addr == "" {
+		for _, fr := range frames {
+			fmt.Println(fr)
+		}
+		return
+	}
+	network, address := "tcp", *addr
+	if i := strings.Index(address, "@"); i >= 0 {
+		network, address = address[:i], address[i+1:]
+	}
+	r := &wire.Replayer{
+		Dial:  func() (net.Conn, error) { return net.Dial(network, address) },
+		Speed: *speed,
+		Wait:  *wait,
+		Loops: *loops,
+	}
+	stats, err := r.Replay(frames)
+	fmt.Printf("conns=%d frames=%d sent=%dB received=%dB elapsed=%v\n",
+		stats.Conns, stats.Frames, stats.BytesSent, stats.BytesReceived, stats.Elapsed)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,28 @@
+// protoc-gen-mrpc 是protoc插件，把.proto中的service定义生成为mrpc的客户端桩和服务端接口：
+//
+//	protoc --go_out=. --mrpc_out=. arith.proto
+//
+// 每个service生成：
+//
+//	type ArithServer interface { Add(*AddRequest, *AddReply) error }
+//	func RegisterArithServer(s *mrpc.Server, impl ArithServer) error
+//	type ArithClient struct{ ... }
+//	func (x *ArithClient) Add(ctx context.Context, in *AddRequest) (*AddReply, error)
+//
+// 服务名取.proto中service的短名称，不带package前缀。流式方法不受支持，会被跳过。
+package main
+
+import (
+	"google.golang.org/protobuf/compiler/protogen"
+)
+
+func main() {
+	protogen.Options{}.Run(func(gen *protogen.Plugin) error {
+		for _, f := range gen.Files {
+			if f.Generate && len(f.Services) > 0 {
+				generateFile(gen, f)
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"google.golang.org/protobuf/compiler/protogen"
+)
+
+const (
+	contextPackage = protogen.GoImportPath("context")
+	mrpcPackage    = protogen.GoImportPath("github.com/micplus/mrpc")
+	codecPackage   = protogen.GoImportPath("github.com/micplus/mrpc/codec")
+)
+
+// 为一个.proto文件生成<name>_mrpc.pb.go
+func generateFile(gen *protogen.Plugin, file *protogen.File) *protogen.GeneratedFile {
+	filename := file.GeneratedFilenamePrefix + "_mrpc.pb.go"
+	g := gen.NewGeneratedFile(filename, file.GoImportPath)
+	g.P("// Code generated by protoc-gen-mrpc. DO NOT EDIT.")
+	g.P("// source: ", file.Desc.Path())
+	g.P()
+	g.P("package ", file.GoPackageName)
+	g.P()
+	for _, svc := range file.Services {
+		generateService(g, svc)
+	}
+	return g
+}
+
+// 流式方法没有对应的mrpc调用方式
+func unaryMethods(svc *protogen.Service) []*protogen.Method {
+	var methods []*protogen.Method
+	for _, m := range svc.Methods {
+		if !m.Desc.IsStreamingClient() && !m.Desc.IsStreamingServer() {
+			methods = append(methods, m)
+		}
+	}
+	return methods
+}
+
+func generateService(g *protogen.GeneratedFile, svc *protogen.Service) {
+	name := svc.GoName
+	methods := unaryMethods(svc)
+	for _, m := range svc.Methods {
+		if m.Desc.IsStreamingClient() || m.Desc.IsStreamingServer() {
+			g.P("// ", name, ".", m.GoName, " 是流式方法，mrpc不支持，已跳过")
+		}
+	}
+
+	// 服务端接口
+	g.P("// ", name, "Server 是", name, "服务的服务端接口")
+	g.P("type ", name, "Server interface {")
+	for _, m := range methods {
+		g.P(m.Comments.Leading, m.GoName, "(*", g.QualifiedGoIdent(m.Input.GoIdent), ", *", g.QualifiedGoIdent(m.Output.GoIdent), ") error")
+	}
+	g.P("}")
+	g.P()
+	g.P("// Register", name, "Server 以服务名\"", name, "\"注册impl。客户端要使用protobuf编码，见New", name, "Client")
+	g.P("func Register", name, "Server(s *", g.QualifiedGoIdent(mrpcPackage.Ident("Server")), ", impl ", name, "Server) error {")
+	g.P("return s.RegisterName(\"", name, "\", impl)")
+	g.P("}")
+	g.P()
+
+	// 客户端
+	client := name + "Client"
+	mrpcClient := g.QualifiedGoIdent(mrpcPackage.Ident("Client"))
+	ctx := g.QualifiedGoIdent(contextPackage.Ident("Context"))
+	g.P("// ", client, " 是", name, "服务的类型化客户端")
+	g.P("type ", client, " struct {")
+	g.P("c *", mrpcClient)
+	g.P("}")
+	g.P()
+	// 参数和返回值是protoc生成的类型，只有protobuf编码能发送
+	protoType := g.QualifiedGoIdent(codecPackage.Ident("ProtoType"))
+	g.P("// New", client, " 包装c，c必须以", protoType, "编码建立，见Dial", client)
+	g.P("func New", client, "(c *", mrpcClient, ") *", client, " {")
+	g.P("return &", client, "{c: c}")
+	g.P("}")
+	g.P()
+	g.P("// Dial", client, " 以", protoType, "编码连接服务端，opts中的编码类型会被覆盖")
+	g.P("func Dial", client, "(network, address string, opts ...", g.QualifiedGoIdent(mrpcPackage.Ident("ClientOption")), ") (*", client, ", error) {")
+	g.P("opts = append(opts, ", g.QualifiedGoIdent(mrpcPackage.Ident("WithClientCodecType")), "(", protoType, "))")
+	g.P("c, err := ", g.QualifiedGoIdent(mrpcPackage.Ident("DialOptions")), "(network, address, opts...)")
+	g.P("if err != nil {")
+	g.P("return nil, err")
+	g.P("}")
+	g.P("return New", client, "(c), nil")
+	g.P("}")
+	g.P()
+	g.P("// ctx中的元数据随请求发送，ctx结束时立即返回ctx.Err()，不再等待响应")
+	g.P("func (x *", client, ") call(ctx ", ctx, ", name string, args, reply any) error {")
+	g.P("return x.c.CallContext(ctx, name, args, reply)")
+	g.P("}")
+	for _, m := range methods {
+		in, out := g.QualifiedGoIdent(m.Input.GoIdent), g.QualifiedGoIdent(m.Output.GoIdent)
+		g.P()
+		g.P("// ", m.GoName, " 调用", name, ".", m.GoName)
+		g.P("func (x *", client, ") ", m.GoName, "(ctx ", ctx, ", in *", in, ") (*", out, ", error) {")
+		g.P("out := new(", out, ")")
+		g.P("if err := x.call(ctx, \"", name, ".", m.GoName, "\", in, out); err != nil {")
+		g.P("return nil, err")
+		g.P("}")
+		g.P("return out, nil")
+		g.P("}")
+	}
+	g.P()
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"google.golang.org/protobuf/compiler/protogen"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+func arithProto() *descriptorpb.FileDescriptorProto {
+	field := func(name string, num int32) *descriptorpb.FieldDescriptorProto {
+		return &descriptorpb.FieldDescriptorProto{
+			Name:   proto.String(name),
+			Number: proto.Int32(num),
+			Label:  descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+			Type:   descriptorpb.FieldDescriptorProto_TYPE_INT64.Enum(),
+		}
+	}
+	return &descriptorpb.FileDescriptorProto{
+		Name:    proto.String("arith.proto"),
+		Package: proto.String("arith"),
+		Syntax:  proto.String("proto3"),
+		Options: &descriptorpb.FileOptions{GoPackage: proto.String("example.com/arith;arith")},
+		MessageType: []*descriptorpb.DescriptorProto{
+			{Name: proto.String("AddRequest"), Field: []*descriptorpb.FieldDescriptorProto{field("a", 1), field("b", 2)}},
+			{Name: proto.String("AddReply"), Field: []*descriptorpb.FieldDescriptorProto{field("sum", 1)}},
+		},
+		Service: []*descriptorpb.ServiceDescriptorProto{{
+			Name: proto.String("Arith"),
+			Method: []*descriptorpb.MethodDescriptorProto{
+				{Name: proto.String("Add"), InputType: proto.String(".arith.AddRequest"), OutputType: proto.String(".arith.AddReply")},
+				{Name: proto.String("Watch"), InputType: proto.String(".arith.AddRequest"), OutputType: proto.String(".arith.AddReply"), ServerStreaming: proto.Bool(true)},
+			},
+		}},
+	}
+}
+
+func TestGenerateFile(t *testing.T) {
+	gen, err := protogen.Options{}.New(&pluginpb.CodeGeneratorRequest{
+		FileToGenerate: []string{"arith.proto"},
+		ProtoFile:      []*descriptorpb.FileDescriptorProto{arithProto()},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range gen.Files {
+		if f.Generate {
+			generateFile(gen, f)
+		}
+	}
+	resp := gen.Response()
+	if resp.Error != nil {
+		t.Fatal(resp.GetError())
+	}
+	if len(resp.File) != 1 || resp.File[0].GetName() != "example.com/arith/arith_mrpc.pb.go" {
+		t.Fatalf("unexpected generated files %v", resp.File)
+	}
+	code := resp.File[0].GetContent()
+	for _, want := range []string{
+		"Add(*AddRequest, *AddReply) error",
+		"func RegisterArithServer(s *mrpc.Server, impl ArithServer) error",
+		`s.RegisterName("Arith", impl)`,
+		"func (x *ArithClient) Add(ctx context.Context, in *AddRequest) (*AddReply, error)",
+		"func DialArithClient(network, address string, opts ...mrpc.ClientOption) (*ArithClient, error)",
+		"mrpc.WithClientCodecType(codec.ProtoType)",
+		"Arith.Watch 是流式方法",
+	} {
+		if !strings.Contains(code, want) {
+			t.Errorf("generated code missing %q\n%s", want, code)
+		}
+	}
+}
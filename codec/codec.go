@@ -1,6 +1,11 @@
 package codec
 
-import "io"
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
 
 // codec is short for COder-DECoder
 
@@ -16,6 +21,83 @@ type Header struct {
 	Seq   uint64
 	Name  string
 	Error string
+	// 随请求传递的元数据(调用方身份、追踪id等)，服务端从context中读取
+	Meta map[string]string
+	// 描述消息体的标志位，由codec设置和解释
+	Flags uint32
+	// 请求剩余的超时时间(纳秒)，0表示没有期限。传相对时间以免两端时钟不一致
+	Timeout int64
+	// 错误码，Error不为空时有意义，0表示没有错误码(旧版本的服务端)
+	Code uint32
+	// 错误详情的类型名称，不为空时消息体是依次包含各个详情的结构体
+	Details []string
+	// 方法名在这条连接上的编号，0表示没有编号。Name为空时用编号代替方法名，见mrpc.WithClientNameInterning
+	NameID uint16
+}
+
+// Header各字段的长度上限，编码和解码时检查，一个畸形或恶意的Header不会撑大内存和日志
+const (
+	MaxNameSize     = 1 << 10  // 方法名
+	MaxErrorSize    = 64 << 10 // 错误信息
+	MaxMetadataSize = 64 << 10 // 元数据所有键和值的总长
+)
+
+// 整个Header编码后的上限，解码时读到这么多字节还没读完就报错，不会先分配内存
+const maxHeaderSize = 1 << 20
+
+// Header的字段超过了上限
+var ErrHeaderTooLarge = errors.New("rpc codec: header too large")
+
+// 检查各字段的长度，codec在写之前和读之后调用
+func (h *Header) Check() error {
+	if len(h.Name) > MaxNameSize {
+		return fmt.Errorf("%w: name is %d bytes, max %d", ErrHeaderTooLarge, len(h.Name), MaxNameSize)
+	}
+	if len(h.Error) > MaxErrorSize {
+		return fmt.Errorf("%w: error is %d bytes, max %d", ErrHeaderTooLarge, len(h.Error), MaxErrorSize)
+	}
+	n := 0
+	for k, v := range h.Meta {
+		n += len(k) + len(v)
+	}
+	if n > MaxMetadataSize {
+		return fmt.Errorf("%w: metadata is %d bytes, max %d", ErrHeaderTooLarge, n, MaxMetadataSize)
+	}
+	return nil
+}
+
+const (
+	// 消息体是原样传输的字节，没有经过编码
+	FlagRaw uint32 = 1 << iota
+	// 服务端向客户端发起的请求，以及客户端对它的响应
+	FlagReverse
+	// 响应之后还有一帧相同Seq的尾部元数据
+	FlagTrailer
+	// 消息体经过压缩，见Compressor
+	FlagCompressed
+)
+
+// 按原样传输的字节，不经过编码，用于转发已经序列化好的数据。
+// 参数或返回值是[]byte、RawMessage(或它们的指针)时，支持的codec直接拷贝字节
+type RawMessage []byte
+
+// body是否可以按原始字节传输
+func rawBytes(body any) ([]byte, bool) {
+	switch b := body.(type) {
+	case []byte:
+		return b, true
+	case *[]byte:
+		if b != nil {
+			return *b, true
+		}
+	case RawMessage:
+		return b, true
+	case *RawMessage:
+		if b != nil {
+			return *b, true
+		}
+	}
+	return nil, false
 }
 
 // Codec原则上应当支持不同的编解码方式，
@@ -32,12 +114,41 @@ type Codec interface {
 	io.Closer // Close() error
 }
 
+// 可以先把多个消息编码进缓冲区、再一次写入连接的codec。
+// 服务端在多个响应同时完成时借此合并写操作，减少系统调用
+type BatchWriter interface {
+	// 编码到缓冲区，不写入连接
+	WriteBuffered(*Header, any) error
+	// 把缓冲区写入连接
+	Flush() error
+	// 缓冲区中尚未写入连接的字节数
+	Buffered() int
+}
+
+// 编码类型在握手时发给对方，取值固定，新的编码往后追加，不能改动已有的值
 const (
-	GobType uint32 = iota
-	JSONType
-	CustomType // ...
+	GobType        uint32 = 0
+	JSONType       uint32 = 1 // 长度前缀的JSON，见NewJSONCodec
+	CustomType     uint32 = 2 // ...
+	BinaryJSONType uint32 = 3 // 紧凑的二进制Header和JSON消息体，见NewBinaryJSONCodec
+	ProtoType      uint32 = 4 // 长度前缀的protobuf，见NewProtoCodec
 )
 
+// 编码类型的名称，握手被拒绝时用于提示
+func TypeName(t uint32) string {
+	switch t {
+	case GobType:
+		return "gob"
+	case JSONType:
+		return "json"
+	case BinaryJSONType:
+		return "binjson"
+	case ProtoType:
+		return "proto"
+	}
+	return "codec" + strconv.FormatUint(uint64(t), 10)
+}
+
 type NewCodecFunc func(io.ReadWriteCloser) Codec
 
 // 由此，服务器可以接受多种编码请求，客户端传来编码类型，服务端可以检查是否接受
@@ -46,4 +157,7 @@ var NewCodecFuncMap map[uint32]NewCodecFunc
 func init() {
 	NewCodecFuncMap = make(map[uint32]NewCodecFunc)
 	NewCodecFuncMap[GobType] = NewGobCodec // 注册支持的编码类型
+	NewCodecFuncMap[JSONType] = NewJSONCodec
+	NewCodecFuncMap[BinaryJSONType] = NewBinaryJSONCodec
+	NewCodecFuncMap[ProtoType] = NewProtoCodec
 }
@@ -0,0 +1,137 @@
+package codec
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// 压缩：编码后超过阈值的消息体用deflate压缩，Header带上FlagCompressed，
+// 小消息不压缩，不增加延迟。压缩后的消息体：uvarint长度 | deflate数据，
+// 解压后与不压缩时的消息体相同。
+// 读端总是能解压，写端开启压缩前要确认对端也是支持它的版本
+
+// 可以压缩较大消息体的codec
+type Compressor interface {
+	// 消息体编码后超过threshold字节时压缩，<=0时不压缩
+	SetCompression(threshold int)
+}
+
+var flateWriters = sync.Pool{New: func() any {
+	w, _ := flate.NewWriter(nil, flate.BestSpeed)
+	return w
+}}
+
+var flateReaders sync.Pool
+
+// 压缩data，压缩后没有变小时返回false
+func deflate(data []byte) ([]byte, bool) {
+	var out bytes.Buffer
+	fw := flateWriters.Get().(*flate.Writer)
+	defer flateWriters.Put(fw)
+	fw.Reset(&out)
+	if _, err := fw.Write(data); err != nil {
+		return nil, false
+	}
+	if err := fw.Close(); err != nil || out.Len() >= len(data) {
+		return nil, false
+	}
+	return out.Bytes(), true
+}
+
+// 读出压缩的消息体并解压，解压后同样不能超过maxRawSize
+func readCompressed(r io.Reader) ([]byte, error) {
+	var compressed []byte
+	if err := readRaw(r, &compressed); err != nil {
+		return nil, err
+	}
+	src := bytes.NewReader(compressed)
+	fr, ok := flateReaders.Get().(io.ReadCloser)
+	if ok {
+		fr.(flate.Resetter).Reset(src, nil)
+	} else {
+		fr = flate.NewReader(src)
+	}
+	defer flateReaders.Put(fr)
+	data, err := io.ReadAll(io.LimitReader(fr, maxRawSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxRawSize {
+		return nil, fmt.Errorf("rpc codec: decompressed body too large")
+	}
+	return data, nil
+}
+
+// gob的编码器固定写向一个Writer，压缩时先把消息体编码到缓冲区，
+// 编码器的类型信息仍然留在同一个流里
+type switchWriter struct {
+	w io.Writer
+}
+
+func (s *switchWriter) Write(p []byte) (int, error) {
+	return s.w.Write(p)
+}
+
+// 同理，解压后的消息体由同一个解码器从解压的数据中读出。
+// 实现了io.ByteReader，gob不会再套一层缓冲多读
+type switchReader struct {
+	r interface {
+		io.Reader
+		io.ByteReader
+	}
+	// limited时最多再读left字节，用来限制Header的大小
+	limited bool
+	left    int
+}
+
+func (s *switchReader) Read(p []byte) (int, error) {
+	if !s.limited {
+		return s.r.Read(p)
+	}
+	if s.left <= 0 {
+		return 0, ErrHeaderTooLarge
+	}
+	if len(p) > s.left {
+		p = p[:s.left]
+	}
+	n, err := s.r.Read(p)
+	s.left -= n
+	return n, err
+}
+
+func (s *switchReader) ReadByte() (byte, error) {
+	if !s.limited {
+		return s.r.ReadByte()
+	}
+	if s.left <= 0 {
+		return 0, ErrHeaderTooLarge
+	}
+	b, err := s.r.ReadByte()
+	if err == nil {
+		s.left--
+	}
+	return b, err
+}
+
+// 限制接下来最多读n字节，n<0时取消限制
+func (s *switchReader) limit(n int) {
+	s.limited, s.left = n >= 0, n
+}
+
+// 读端已经是io.ByteReader时直接用它
+func byteReader(r io.Reader) interface {
+	io.Reader
+	io.ByteReader
+} {
+	if br, ok := r.(interface {
+		io.Reader
+		io.ByteReader
+	}); ok {
+		return br
+	}
+	return bufio.NewReader(r)
+}
@@ -2,41 +2,167 @@ package codec
 
 import (
 	"bufio"
+	"bytes"
+	"encoding/binary"
 	"encoding/gob"
+	"fmt"
 	"io"
 	"log"
 )
 
 type GobCodec struct {
 	conn io.ReadWriteCloser // 编解码器不需要关心连接地址信息，只用读写关闭
+	r    *switchReader      // 读端，实现了io.ByteReader
+	w    *switchWriter      // 编码器的写端，平时是buf
 	buf  *bufio.Writer      // bufio带缓冲区防阻塞，数据先写缓冲，优化执行效率
 	dec  *gob.Decoder       // 从连接中读数据，解码
 	enc  *gob.Encoder       // 向缓冲区写数据，编码
+	raw  bool               // 接下来的消息体是原始字节
+	// 接下来的消息体是压缩的
+	compressed bool
+	// 消息体超过这么多字节时压缩，为0时不压缩，见SetCompression
+	compress int
+	scratch  bytes.Buffer // 压缩前先把消息体编码到这里
 }
 
 // 接收连接，返回一个可以从/向连接读写信息的编解码器
 func NewGobCodec(conn io.ReadWriteCloser) Codec {
+	// 读端实现了io.ByteReader时gob只读取消息本身的字节，不会多读，
+	// 原始字节的消息体才能从同一个读端紧接着读出来
+	r := &switchReader{r: byteReader(conn)}
 	buf := bufio.NewWriter(conn)
+	w := &switchWriter{buf}
 	return &GobCodec{
 		conn: conn,
+		r:    r,
+		w:    w,
 		buf:  buf,
-		dec:  gob.NewDecoder(conn),
-		enc:  gob.NewEncoder(buf),
+		dec:  gob.NewDecoder(r),
+		enc:  gob.NewEncoder(w),
 	}
 }
 
+var _ Compressor = (*GobCodec)(nil)
+
+func (c *GobCodec) SetCompression(threshold int) {
+	c.compress = max(threshold, 0)
+}
+
 // 读Header
 func (c *GobCodec) ReadHeader(h *Header) error {
-	return c.dec.Decode(h)
+	c.r.limit(maxHeaderSize)
+	err := c.dec.Decode(h)
+	c.r.limit(-1)
+	if err != nil {
+		return err
+	}
+	if err := h.Check(); err != nil {
+		return err
+	}
+	c.raw = h.Flags&FlagRaw != 0
+	c.compressed = h.Flags&FlagCompressed != 0
+	return nil
 }
 
 // 读Body
 func (c *GobCodec) ReadBody(body any) error {
+	if c.compressed {
+		return c.readCompressedBody(body)
+	}
+	if c.raw {
+		c.raw = false
+		return readRaw(c.r, body)
+	}
 	return c.dec.Decode(body)
 }
 
+// 原始字节消息体的最大长度，与gob对单条消息的限制相同
+const maxRawSize = 1 << 30
+
+// 原始字节的消息体：uvarint长度 | 字节
+func writeRaw(w *bufio.Writer, b []byte) error {
+	var lenBuf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(lenBuf[:], uint64(len(b)))
+	if _, err := w.Write(lenBuf[:n]); err != nil {
+		return err
+	}
+	_, err := w.Write(b)
+	return err
+}
+
+// 直接读进body指向的切片，容量够时复用它
+func readRaw(r io.Reader, body any) error {
+	n, err := binary.ReadUvarint(r.(io.ByteReader))
+	if err != nil {
+		return err
+	}
+	if n > maxRawSize {
+		return fmt.Errorf("rpc codec: raw body too large: %d bytes", n)
+	}
+	dst := rawDest(body)
+	if dst == nil {
+		if _, err := io.CopyN(io.Discard, r, int64(n)); err != nil {
+			return err
+		}
+		if body == nil {
+			return nil
+		}
+		return fmt.Errorf("rpc codec: cannot decode raw body into %T", body)
+	}
+	b := *dst
+	if uint64(cap(b)) >= n {
+		b = b[:n]
+	} else {
+		b = make([]byte, n)
+	}
+	if _, err := io.ReadFull(r, b); err != nil {
+		return err
+	}
+	*dst = b
+	return nil
+}
+
+// 能接收原始字节的body
+func rawDest(body any) *[]byte {
+	switch p := body.(type) {
+	case *[]byte:
+		return p
+	case *RawMessage:
+		return (*[]byte)(p)
+	}
+	return nil
+}
+
+// 解压后，原始字节直接交给body，gob消息由同一个解码器从解压的数据中解码
+func (c *GobCodec) readCompressedBody(body any) error {
+	raw := c.raw
+	c.raw, c.compressed = false, false
+	data, err := readCompressed(c.r)
+	if err != nil {
+		return err
+	}
+	if raw {
+		if dst := rawDest(body); dst != nil {
+			*dst = data
+		} else if body != nil {
+			return fmt.Errorf("rpc codec: cannot decode raw body into %T", body)
+		}
+		return nil
+	}
+	src := c.r.r
+	c.r.r = bytes.NewReader(data)
+	defer func() { c.r.r = src }()
+	return c.dec.Decode(body)
+}
+
+var _ BatchWriter = (*GobCodec)(nil)
+
 // 先写缓冲，再把缓冲写入连接
 func (c *GobCodec) Write(h *Header, body any) (err error) {
+	// 超过上限时什么也没写，连接还能用
+	if err := h.Check(); err != nil {
+		return err
+	}
 	// 把缓冲区数据写进conn
 	defer func() {
 		c.buf.Flush()
@@ -47,19 +173,95 @@ func (c *GobCodec) Write(h *Header, body any) (err error) {
 			c.Close()
 		}
 	}()
+	return c.encode(h, body)
+}
+
+// 只写缓冲，由调用方决定何时Flush。编码出错时流已经不完整，关闭连接
+func (c *GobCodec) WriteBuffered(h *Header, body any) error {
+	if err := h.Check(); err != nil {
+		return err
+	}
+	if err := c.encode(h, body); err != nil {
+		c.Close()
+		return err
+	}
+	return nil
+}
 
+func (c *GobCodec) encode(h *Header, body any) error {
+	raw, isRaw := rawBytes(body)
+	h.Flags &^= FlagRaw | FlagCompressed
+	if isRaw {
+		h.Flags |= FlagRaw
+	}
+	if c.compress > 0 {
+		return c.encodeCompressed(h, body, raw, isRaw)
+	}
 	if err := c.enc.Encode(h); err != nil {
 		log.Println("rpc codec: gob encoding header error:", err)
 		return err
 	}
+	if isRaw { // 不经过gob，直接拷贝字节
+		return writeRaw(c.buf, raw)
+	}
 	if err := c.enc.Encode(body); err != nil {
 		log.Println("rpc codec: gob encoding body error:", err)
 		return err
 	}
-
 	return nil
 }
 
+// 先把消息体编码到缓冲区，超过阈值且压缩后变小了才压缩。
+// 消息体中新类型的定义先于Header编码，gob按类型id解码，与顺序无关
+func (c *GobCodec) encodeCompressed(h *Header, body any, raw []byte, isRaw bool) error {
+	if !isRaw {
+		c.scratch.Reset()
+		c.w.w = &c.scratch
+		err := c.enc.Encode(body)
+		c.w.w = c.buf
+		if err != nil {
+			log.Println("rpc codec: gob encoding body error:", err)
+			return err
+		}
+		raw = c.scratch.Bytes()
+	}
+	var compressed []byte
+	if len(raw) > c.compress {
+		var ok bool
+		if compressed, ok = deflate(raw); ok {
+			h.Flags |= FlagCompressed
+		}
+	}
+	if err := c.enc.Encode(h); err != nil {
+		log.Println("rpc codec: gob encoding header error:", err)
+		return err
+	}
+	var err error
+	switch {
+	case compressed != nil:
+		err = writeRaw(c.buf, compressed)
+	case isRaw:
+		err = writeRaw(c.buf, raw)
+	default:
+		_, err = c.buf.Write(raw)
+	}
+	if c.scratch.Cap() > maxScratchSize { // 偶尔的大消息不要一直占着内存
+		c.scratch = bytes.Buffer{}
+	}
+	return err
+}
+
+// 压缩用的缓冲区保留的最大容量
+const maxScratchSize = 1 << 20
+
+func (c *GobCodec) Flush() error {
+	return c.buf.Flush()
+}
+
+func (c *GobCodec) Buffered() int {
+	return c.buf.Buffered()
+}
+
 func (c *GobCodec) Close() error {
 	return c.conn.Close()
 }
@@ -0,0 +1,144 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"io"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+type rwc struct {
+	io.Reader
+	io.Writer
+}
+
+func (rwc) Close() error { return nil }
+
+// 原始字节和gob消息交替出现，读端不能多读
+func TestGobRawBody(t *testing.T) {
+	var stream bytes.Buffer
+	w := NewGobCodec(rwc{Writer: &stream}).(*GobCodec)
+	blob := bytes.Repeat([]byte("x"), 10000)
+	msgs := []any{blob, "gob", RawMessage("raw"), &blob, 42}
+	for i, m := range msgs {
+		if err := w.Write(&Header{Seq: uint64(i)}, m); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 不实现io.ByteReader的读端
+	r := NewGobCodec(rwc{Reader: struct{ io.Reader }{&stream}})
+	var (
+		h   Header
+		b   []byte
+		s   string
+		rm  RawMessage
+		n   int
+		got = []any{&b, &s, &rm, nil, &n}
+	)
+	for i, x := range got {
+		h = Header{}
+		if err := r.ReadHeader(&h); err != nil {
+			t.Fatalf("header %d: %v", i, err)
+		}
+		_, raw := rawBytes(msgs[i])
+		if h.Seq != uint64(i) || (h.Flags&FlagRaw != 0) != raw {
+			t.Errorf("header %d: %+v", i, h)
+		}
+		if err := r.ReadBody(x); err != nil {
+			t.Fatalf("body %d: %v", i, err)
+		}
+	}
+	if !bytes.Equal(b, blob) || s != "gob" || string(rm) != "raw" || n != 42 {
+		t.Errorf("unexpected bodies: %d bytes, %q, %q, %d", len(b), s, rm, n)
+	}
+}
+
+type report struct {
+	Lines []string
+}
+
+// 超过阈值且压缩后变小的消息体才压缩，读端不需要任何设置
+func TestGobCompression(t *testing.T) {
+	var stream bytes.Buffer
+	w := NewGobCodec(rwc{Writer: &stream}).(*GobCodec)
+	w.SetCompression(100)
+	big := &report{Lines: make([]string, 100)}
+	for i := range big.Lines {
+		big.Lines[i] = "the same line over and over"
+	}
+	noise := make([]byte, 1000)
+	rand.New(rand.NewSource(1)).Read(noise)
+	blob := bytes.Repeat([]byte("abc"), 1000)
+	msgs := []any{"small", big, blob, noise, &report{Lines: []string{"short"}}, big}
+	wantCompressed := []bool{false, true, true, false, false, true}
+	for i, m := range msgs {
+		if err := w.Write(&Header{Seq: uint64(i)}, m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if stream.Len() > 2000 {
+		t.Errorf("stream is %d bytes, compression not applied", stream.Len())
+	}
+
+	r := NewGobCodec(rwc{Reader: &stream})
+	var (
+		s          string
+		r1, r2, r3 report
+		b, n       []byte
+		got        = []any{&s, &r1, &b, &n, &r2, &r3}
+	)
+	for i, x := range got {
+		var h Header
+		if err := r.ReadHeader(&h); err != nil {
+			t.Fatalf("header %d: %v", i, err)
+		}
+		if (h.Flags&FlagCompressed != 0) != wantCompressed[i] {
+			t.Errorf("message %d: flags %b", i, h.Flags)
+		}
+		if err := r.ReadBody(x); err != nil {
+			t.Fatalf("body %d: %v", i, err)
+		}
+	}
+	if s != "small" || len(r1.Lines) != 100 || r1.Lines[99] != big.Lines[99] || !bytes.Equal(b, blob) ||
+		!bytes.Equal(n, noise) || r2.Lines[0] != "short" || len(r3.Lines) != 100 {
+		t.Errorf("unexpected bodies")
+	}
+}
+
+func TestHeaderLimits(t *testing.T) {
+	var stream bytes.Buffer
+	w := NewGobCodec(rwc{Writer: &stream}).(*GobCodec)
+	long := strings.Repeat("x", MaxNameSize+1)
+	if err := w.Write(&Header{Name: long}, 1); !errors.Is(err, ErrHeaderTooLarge) {
+		t.Fatalf("write long name: %v", err)
+	}
+	if err := w.Write(&Header{Meta: map[string]string{"k": strings.Repeat("v", MaxMetadataSize)}}, 1); !errors.Is(err, ErrHeaderTooLarge) {
+		t.Fatalf("write large metadata: %v", err)
+	}
+	// 超限时什么也没写，codec还能继续用
+	if err := w.Write(&Header{Seq: 1, Name: "A.B"}, 1); err != nil {
+		t.Fatal(err)
+	}
+	r := NewGobCodec(rwc{Reader: &stream})
+	var h Header
+	if err := r.ReadHeader(&h); err != nil || h.Seq != 1 {
+		t.Fatalf("read header %+v: %v", h, err)
+	}
+
+	// 绕过检查直接编码的Header在读端被拒绝
+	for _, bad := range []Header{
+		{Error: strings.Repeat("e", MaxErrorSize+1)},
+		{Meta: map[string]string{"k": strings.Repeat("v", 2*maxHeaderSize)}},
+	} {
+		stream.Reset()
+		gob.NewEncoder(&stream).Encode(bad)
+		r := NewGobCodec(rwc{Reader: &stream})
+		if err := r.ReadHeader(&Header{}); !errors.Is(err, ErrHeaderTooLarge) {
+			t.Errorf("read oversized header: %v", err)
+		}
+	}
+}
@@ -0,0 +1,354 @@
+package codec
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"unicode/utf8"
+)
+
+// 与语言无关的编码：gob流带有状态，其它语言很难实现，这两种编码只用长度前缀和JSON，
+// 按下面的格式就能写出其它语言的客户端和服务端。
+//
+// 握手与gob相同，客户端先发8个字节：Magic(0x5a2b71c3) | 编码类型，都是uint32大端，
+// 编码类型为JSONType(1)或BinaryJSONType(3)。服务端不接受时回复拒绝帧而不是关闭连接，见mrpc.RejectMagic。
+// 之后两个方向都是连续的消息，每条消息是Header帧和消息体帧，每帧是：
+//
+//	长度(uint32大端，不含自身) | 内容
+//
+// JSONType的Header帧内容是一个JSON对象，省略零值的字段：
+//
+//	{"seq": 1, "name": "Arith.Mul", "error": "", "meta": {"k": "v"},
+//	 "flags": 0, "timeout": 0, "code": 0, "details": ["pkg.Type"], "nameid": 0}
+//
+// BinaryJSONType的Header帧内容是定长字段和带长度的字符串，整数都是大端：
+//
+//	seq     uint64
+//	flags   uint32
+//	code    uint32
+//	timeout int64
+//	name    string
+//	error   string
+//	meta    uint32个数，之后依次是键和值，都是string
+//	details uint32个数，之后依次是string
+//
+// 其中string是uint32字节数 | UTF-8字节，没有NameID，服务端也就不会确认方法名编号。
+// Header各字段的含义和上限见Header，Header帧不超过1MB。
+//
+// 消息体帧的内容是参数或返回值的JSON，没有消息体时为null。flags带FlagRaw(1)时是原样的字节，
+// 对应Go中的[]byte和RawMessage。这两种编码不压缩，不会设置FlagCompressed(8)。
+// 错误响应的error不为空，有details时消息体是{"D0": 详情0, "D1": 详情1, ...}。
+// seq为0的消息是控制帧(流量控制的信用、GOAWAY、心跳等)，不认识的可以丢弃
+
+// 消息体帧的上限，与gob的原始字节相同
+const maxJSONBodySize = maxRawSize
+
+// 长度前缀的JSON编码，binaryHeader为true时Header按紧凑的二进制编码
+type JSONCodec struct {
+	conn         io.ReadWriteCloser
+	r            *bufio.Reader
+	buf          *bufio.Writer
+	binaryHeader bool
+	raw          bool   // 接下来的消息体是原始字节
+	frame        []byte // 读Header帧的缓冲区，复用
+	hbuf         []byte // 编码Header的缓冲区，复用
+}
+
+// Header帧是JSON对象的编码，见JSONType
+func NewJSONCodec(conn io.ReadWriteCloser) Codec {
+	return newJSONCodec(conn, false)
+}
+
+// Header帧是紧凑的二进制，消息体是JSON，见BinaryJSONType
+func NewBinaryJSONCodec(conn io.ReadWriteCloser) Codec {
+	return newJSONCodec(conn, true)
+}
+
+func newJSONCodec(conn io.ReadWriteCloser, binaryHeader bool) *JSONCodec {
+	return &JSONCodec{
+		conn:         conn,
+		r:            bufio.NewReader(conn),
+		buf:          bufio.NewWriter(conn),
+		binaryHeader: binaryHeader,
+	}
+}
+
+// Header的JSON形式，字段名是小写的
+type jsonHeader struct {
+	Seq     uint64            `json:"seq,omitempty"`
+	Name    string            `json:"name,omitempty"`
+	Error   string            `json:"error,omitempty"`
+	Meta    map[string]string `json:"meta,omitempty"`
+	Flags   uint32            `json:"flags,omitempty"`
+	Timeout int64             `json:"timeout,omitempty"`
+	Code    uint32            `json:"code,omitempty"`
+	Details []string          `json:"details,omitempty"`
+	NameID  uint16            `json:"nameid,omitempty"`
+}
+
+// 读一帧的长度，超过limit时报错，不先分配内存
+func (c *JSONCodec) readLength(limit int) (int, error) {
+	return readFrameLength(c.r, limit)
+}
+
+func readFrameLength(r io.Reader, limit int) (int, error) {
+	var head [4]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		return 0, err
+	}
+	n := binary.BigEndian.Uint32(head[:])
+	if uint64(n) > uint64(limit) {
+		return 0, fmt.Errorf("rpc codec: frame too large: %d bytes", n)
+	}
+	return int(n), nil
+}
+
+func (c *JSONCodec) ReadHeader(h *Header) error {
+	n, err := c.readLength(maxHeaderSize)
+	if err != nil {
+		return err
+	}
+	if cap(c.frame) < n {
+		c.frame = make([]byte, n)
+	}
+	c.frame = c.frame[:n]
+	if _, err := io.ReadFull(c.r, c.frame); err != nil {
+		return io.ErrUnexpectedEOF
+	}
+	if c.binaryHeader {
+		err = decodeBinaryHeader(c.frame, h)
+	} else {
+		var jh jsonHeader
+		if err = json.Unmarshal(c.frame, &jh); err == nil {
+			*h = Header(jh)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("rpc codec: invalid header: %w", err)
+	}
+	if err := h.Check(); err != nil {
+		return err
+	}
+	if h.Flags&FlagCompressed != 0 {
+		return errors.New("rpc codec: compressed bodies are not supported by the json codec")
+	}
+	c.raw = h.Flags&FlagRaw != 0
+	return nil
+}
+
+func (c *JSONCodec) ReadBody(body any) error {
+	raw := c.raw
+	c.raw = false
+	n, err := c.readLength(maxJSONBodySize)
+	if err != nil {
+		return err
+	}
+	if body == nil {
+		_, err := c.r.Discard(n)
+		return err
+	}
+	var data []byte
+	if dst := rawDest(body); raw && dst != nil && cap(*dst) >= n {
+		data = (*dst)[:n]
+	} else {
+		data = make([]byte, n)
+	}
+	if _, err := io.ReadFull(c.r, data); err != nil {
+		return io.ErrUnexpectedEOF
+	}
+	if raw {
+		dst := rawDest(body)
+		if dst == nil {
+			return fmt.Errorf("rpc codec: cannot decode raw body into %T", body)
+		}
+		*dst = data
+		return nil
+	}
+	return json.Unmarshal(data, body)
+}
+
+var _ BatchWriter = (*JSONCodec)(nil)
+
+func (c *JSONCodec) Write(h *Header, body any) (err error) {
+	if err := h.Check(); err != nil {
+		return err
+	}
+	defer func() {
+		c.buf.Flush()
+		if err != nil {
+			c.Close()
+		}
+	}()
+	return c.encode(h, body)
+}
+
+func (c *JSONCodec) WriteBuffered(h *Header, body any) error {
+	if err := h.Check(); err != nil {
+		return err
+	}
+	if err := c.encode(h, body); err != nil {
+		c.Close()
+		return err
+	}
+	return nil
+}
+
+// 消息体先编码好，编码失败时连接上什么都没写。调用方仍然关闭连接，对端不会等一个不会来的响应
+func (c *JSONCodec) encode(h *Header, body any) error {
+	raw, isRaw := rawBytes(body)
+	h.Flags &^= FlagRaw | FlagCompressed
+	if isRaw {
+		h.Flags |= FlagRaw
+	} else {
+		var err error
+		if raw, err = json.Marshal(body); err != nil {
+			log.Println("rpc codec: json encoding body error:", err)
+			return err
+		}
+	}
+	if c.binaryHeader {
+		c.hbuf = appendBinaryHeader(c.hbuf[:0], h)
+	} else {
+		data, err := json.Marshal((*jsonHeader)(h))
+		if err != nil {
+			log.Println("rpc codec: json encoding header error:", err)
+			return err
+		}
+		c.hbuf = data
+	}
+	if err := c.writeFrame(c.hbuf); err != nil {
+		return err
+	}
+	return c.writeFrame(raw)
+}
+
+func (c *JSONCodec) writeFrame(p []byte) error {
+	return writeFrame(c.buf, p)
+}
+
+// 写一帧：长度 | 内容
+func writeFrame(w io.Writer, p []byte) error {
+	var head [4]byte
+	binary.BigEndian.PutUint32(head[:], uint32(len(p)))
+	if _, err := w.Write(head[:]); err != nil {
+		return err
+	}
+	_, err := w.Write(p)
+	return err
+}
+
+func (c *JSONCodec) Flush() error {
+	return c.buf.Flush()
+}
+
+func (c *JSONCodec) Buffered() int {
+	return c.buf.Buffered()
+}
+
+func (c *JSONCodec) Close() error {
+	return c.conn.Close()
+}
+
+func appendString(b []byte, s string) []byte {
+	b = binary.BigEndian.AppendUint32(b, uint32(len(s)))
+	return append(b, s...)
+}
+
+func appendBinaryHeader(b []byte, h *Header) []byte {
+	b = binary.BigEndian.AppendUint64(b, h.Seq)
+	b = binary.BigEndian.AppendUint32(b, h.Flags)
+	b = binary.BigEndian.AppendUint32(b, h.Code)
+	b = binary.BigEndian.AppendUint64(b, uint64(h.Timeout))
+	b = appendString(b, h.Name)
+	b = appendString(b, h.Error)
+	b = binary.BigEndian.AppendUint32(b, uint32(len(h.Meta)))
+	for k, v := range h.Meta {
+		b = appendString(b, k)
+		b = appendString(b, v)
+	}
+	b = binary.BigEndian.AppendUint32(b, uint32(len(h.Details)))
+	for _, d := range h.Details {
+		b = appendString(b, d)
+	}
+	return b
+}
+
+var errShortHeader = errors.New("truncated binary header")
+
+// 解码紧凑的二进制Header，每个长度都先与剩余的字节比较
+type headerReader struct {
+	b   []byte
+	err error
+}
+
+func (r *headerReader) next(n int) []byte {
+	if r.err != nil || n > len(r.b) {
+		r.err = errShortHeader
+		return nil
+	}
+	p := r.b[:n]
+	r.b = r.b[n:]
+	return p
+}
+
+func (r *headerReader) uint32() uint32 {
+	if p := r.next(4); p != nil {
+		return binary.BigEndian.Uint32(p)
+	}
+	return 0
+}
+
+func (r *headerReader) uint64() uint64 {
+	if p := r.next(8); p != nil {
+		return binary.BigEndian.Uint64(p)
+	}
+	return 0
+}
+
+func (r *headerReader) string() string {
+	p := r.next(int(r.uint32()))
+	if r.err == nil && !utf8.Valid(p) {
+		r.err = errors.New("string is not valid UTF-8")
+	}
+	return string(p)
+}
+
+func decodeBinaryHeader(b []byte, h *Header) error {
+	r := &headerReader{b: b}
+	*h = Header{
+		Seq:     r.uint64(),
+		Flags:   r.uint32(),
+		Code:    r.uint32(),
+		Timeout: int64(r.uint64()),
+		Name:    r.string(),
+		Error:   r.string(),
+	}
+	// 个数不能超过剩余字节能容纳的，每项至少有4字节的长度
+	if n := r.uint32(); n > 0 && r.err == nil {
+		if uint64(n)*8 > uint64(len(r.b)) {
+			return errShortHeader
+		}
+		h.Meta = make(map[string]string, n)
+		for i := uint32(0); i < n && r.err == nil; i++ {
+			k := r.string()
+			h.Meta[k] = r.string()
+		}
+	}
+	if n := r.uint32(); n > 0 && r.err == nil {
+		if uint64(n)*4 > uint64(len(r.b)) {
+			return errShortHeader
+		}
+		h.Details = make([]string, 0, n)
+		for i := uint32(0); i < n && r.err == nil; i++ {
+			h.Details = append(h.Details, r.string())
+		}
+	}
+	if r.err == nil && len(r.b) > 0 {
+		return fmt.Errorf("%d unexpected bytes after binary header", len(r.b))
+	}
+	return r.err
+}
@@ -0,0 +1,142 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestJSONCodec(t *testing.T) {
+	for _, newCodec := range []NewCodecFunc{NewJSONCodec, NewBinaryJSONCodec} {
+		var stream bytes.Buffer
+		w := newCodec(rwc{Writer: &stream})
+		headers := []Header{
+			{Seq: 1, Name: "Arith.Mul", Meta: map[string]string{"trace": "abc", "user": "ann"}, Timeout: 1e9},
+			{Seq: 2, Error: "bad request", Code: 3, Details: []string{"pkg.FieldViolation"}},
+			{Seq: 3, Name: "Blob.Len"},
+			{Seq: 4},
+		}
+		bodies := []any{&report{Lines: []string{"a", "b"}}, nil, RawMessage("\x00\xffraw"), 42}
+		for i := range headers {
+			h := headers[i]
+			if err := w.Write(&h, bodies[i]); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		r := newCodec(rwc{Reader: &stream})
+		var (
+			rep report
+			rm  RawMessage
+			n   int
+		)
+		for i, body := range []any{&rep, nil, &rm, &n} {
+			var h Header
+			if err := r.ReadHeader(&h); err != nil {
+				t.Fatalf("header %d: %v", i, err)
+			}
+			want := headers[i]
+			if i == 2 {
+				want.Flags = FlagRaw
+			}
+			if !reflect.DeepEqual(h, want) {
+				t.Errorf("header %d: want %+v, got %+v", i, want, h)
+			}
+			if err := r.ReadBody(body); err != nil {
+				t.Fatalf("body %d: %v", i, err)
+			}
+		}
+		if !reflect.DeepEqual(rep.Lines, []string{"a", "b"}) || string(rm) != "\x00\xffraw" || n != 42 {
+			t.Errorf("unexpected bodies: %+v, %q, %d", rep, rm, n)
+		}
+		if stream.Len() != 0 {
+			t.Errorf("%d bytes left in the stream", stream.Len())
+		}
+	}
+}
+
+// 格式是给其它语言实现的，按字节核对
+func TestJSONCodecLayout(t *testing.T) {
+	frame := func(s string) string {
+		return string(binary.BigEndian.AppendUint32(nil, uint32(len(s)))) + s
+	}
+	var stream bytes.Buffer
+	NewJSONCodec(rwc{Writer: &stream}).Write(&Header{Seq: 7, Name: "Echo.Say"}, "hi")
+	want := frame(`{"seq":7,"name":"Echo.Say"}`) + frame(`"hi"`)
+	if stream.String() != want {
+		t.Errorf("json: want %q, got %q", want, stream.String())
+	}
+
+	stream.Reset()
+	NewBinaryJSONCodec(rwc{Writer: &stream}).Write(&Header{Seq: 7, Name: "Echo.Say", Meta: map[string]string{"k": "v"}}, "hi")
+	header := "\x00\x00\x00\x00\x00\x00\x00\x07" + // seq
+		"\x00\x00\x00\x00" + "\x00\x00\x00\x00" + // flags, code
+		"\x00\x00\x00\x00\x00\x00\x00\x00" + // timeout
+		"\x00\x00\x00\x08Echo.Say" + "\x00\x00\x00\x00" + // name, error
+		"\x00\x00\x00\x01" + "\x00\x00\x00\x01k" + "\x00\x00\x00\x01v" + // meta
+		"\x00\x00\x00\x00" // details
+	want = frame(header) + frame(`"hi"`)
+	if stream.String() != want {
+		t.Errorf("binary json: want %q, got %q", want, stream.String())
+	}
+}
+
+func TestJSONCodecInvalidHeader(t *testing.T) {
+	frame := func(b []byte) []byte {
+		return append(binary.BigEndian.AppendUint32(nil, uint32(len(b))), b...)
+	}
+	valid := appendBinaryHeader(nil, &Header{Seq: 1, Name: "A.B", Meta: map[string]string{"k": "v"}})
+	for _, tc := range []struct {
+		newCodec NewCodecFunc
+		stream   []byte
+		want     string
+	}{
+		{NewJSONCodec, frame([]byte(`{"seq":"one"}`)), "invalid header"},
+		{NewJSONCodec, frame([]byte(`{"flags":8}`)), "compressed bodies are not supported"},
+		{NewJSONCodec, frame([]byte(`{"name":"` + strings.Repeat("x", MaxNameSize+1) + `"}`)), ErrHeaderTooLarge.Error()},
+		{NewJSONCodec, binary.BigEndian.AppendUint32(nil, maxHeaderSize+1), "frame too large"},
+		{NewBinaryJSONCodec, frame(valid[:len(valid)-3]), "truncated binary header"},
+		{NewBinaryJSONCodec, frame(append(valid, 0)), "unexpected bytes"},
+		// 声称有很多元数据，不能先按个数分配
+		{NewBinaryJSONCodec, frame(append(valid[:24+4+3+4], 0xff, 0xff, 0xff, 0xff)), "truncated binary header"},
+	} {
+		var h Header
+		err := tc.newCodec(rwc{Reader: bytes.NewReader(tc.stream)}).ReadHeader(&h)
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("want %q, got %v", tc.want, err)
+		}
+	}
+
+	var stream bytes.Buffer
+	w := NewJSONCodec(rwc{Writer: &stream})
+	if err := w.Write(&Header{Seq: 1}, make(chan int)); err == nil {
+		t.Error("unencodable body should fail")
+	}
+	if stream.Len() != 0 {
+		t.Errorf("a failed write left %d bytes", stream.Len())
+	}
+	if !errors.Is(w.Write(&Header{Name: strings.Repeat("x", MaxNameSize+1)}, nil), ErrHeaderTooLarge) {
+		t.Error("oversized header should be rejected")
+	}
+}
+
+// 编码类型是线上协议的一部分，改动会与旧版本的对端不兼容
+func TestTypeValues(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		got, want uint32
+	}{
+		{"GobType", GobType, 0},
+		{"JSONType", JSONType, 1},
+		{"CustomType", CustomType, 2},
+		{"BinaryJSONType", BinaryJSONType, 3},
+		{"ProtoType", ProtoType, 4},
+	} {
+		if tc.got != tc.want {
+			t.Errorf("%s = %d, want %d", tc.name, tc.got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,358 @@
+package codec
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"unicode/utf8"
+
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+)
+
+// protobuf编码：参数和返回值是proto.Message(一般是protoc生成的类型)，Header也按protobuf编码，
+// 其它语言用同一份.proto就能读写。
+//
+// 握手与其它编码相同，客户端先发8个字节：Magic(0x5a2b71c3) | 编码类型，都是uint32大端，
+// 编码类型为ProtoType(4)。服务端按编码类型在NewCodecFuncMap中找到NewProtoCodec，
+// 没有注册时回复列出所支持类型的拒绝帧，见mrpc.RejectMagic。之后的帧格式与JSONType相同，
+// 每条消息是Header帧和消息体帧，每帧是长度(uint32大端，不含自身) | 内容。Header帧的内容是：
+//
+//	message Header {
+//	  uint64 seq = 1;
+//	  string name = 2;
+//	  string error = 3;
+//	  map<string, string> meta = 4;
+//	  uint32 flags = 5;
+//	  int64 timeout = 6;
+//	  uint32 code = 7;
+//	  repeated string details = 8;
+//	  uint32 name_id = 9;
+//	}
+//
+// 消息体帧的内容是参数或返回值的protobuf编码，它们不是proto.Message时编码失败，调用返回错误。例外有三个：
+// flags带FlagRaw(1)时是原样的字节；seq为0的控制帧(流量控制的信用、GOAWAY、心跳等)的消息体是JSON，
+// 与JSONType相同；错误响应的消息体为空，不带错误详情。这种编码不压缩，不会设置FlagCompressed(8)
+
+// Header帧中各字段的编号
+const (
+	protoSeq protowire.Number = iota + 1
+	protoName
+	protoError
+	protoMeta
+	protoFlags
+	protoTimeout
+	protoCode
+	protoDetails
+	protoNameID
+)
+
+// 长度前缀的protobuf编码，见ProtoType
+type ProtoCodec struct {
+	conn    io.ReadWriteCloser
+	r       *bufio.Reader
+	buf     *bufio.Writer
+	raw     bool   // 接下来的消息体是原始字节
+	control bool   // 接下来的消息体属于控制帧，是JSON
+	frame   []byte // 读帧的缓冲区，复用
+	hbuf    []byte // 编码Header的缓冲区，复用
+}
+
+var _ BatchWriter = (*ProtoCodec)(nil)
+
+func NewProtoCodec(conn io.ReadWriteCloser) Codec {
+	return &ProtoCodec{
+		conn: conn,
+		r:    bufio.NewReader(conn),
+		buf:  bufio.NewWriter(conn),
+	}
+}
+
+// 读一帧的内容到c.frame，下一次读帧之前有效
+func (c *ProtoCodec) readFrame(limit int) ([]byte, error) {
+	n, err := readFrameLength(c.r, limit)
+	if err != nil {
+		return nil, err
+	}
+	if cap(c.frame) < n {
+		c.frame = make([]byte, n)
+	}
+	c.frame = c.frame[:n]
+	if _, err := io.ReadFull(c.r, c.frame); err != nil {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return c.frame, nil
+}
+
+func (c *ProtoCodec) ReadHeader(h *Header) error {
+	frame, err := c.readFrame(maxHeaderSize)
+	if err != nil {
+		return err
+	}
+	if err := decodeProtoHeader(frame, h); err != nil {
+		return fmt.Errorf("rpc codec: invalid header: %w", err)
+	}
+	if err := h.Check(); err != nil {
+		return err
+	}
+	if h.Flags&FlagCompressed != 0 {
+		return errors.New("rpc codec: compressed bodies are not supported by the proto codec")
+	}
+	c.raw = h.Flags&FlagRaw != 0
+	c.control = h.Seq == 0
+	return nil
+}
+
+func (c *ProtoCodec) ReadBody(body any) error {
+	raw, control := c.raw, c.control
+	c.raw, c.control = false, false
+	if body == nil {
+		n, err := readFrameLength(c.r, maxJSONBodySize)
+		if err != nil {
+			return err
+		}
+		_, err = c.r.Discard(n)
+		return err
+	}
+	if raw {
+		dst := rawDest(body)
+		if dst == nil {
+			return fmt.Errorf("rpc codec: cannot decode raw body into %T", body)
+		}
+		frame, err := c.readFrame(maxJSONBodySize)
+		if err != nil {
+			return err
+		}
+		*dst = append((*dst)[:0], frame...)
+		return nil
+	}
+	frame, err := c.readFrame(maxJSONBodySize)
+	if err != nil {
+		return err
+	}
+	if control {
+		return json.Unmarshal(frame, body)
+	}
+	if m, ok := body.(proto.Message); ok {
+		return proto.Unmarshal(frame, m)
+	}
+	if len(frame) == 0 { // 没有内容的消息体，如错误响应
+		return nil
+	}
+	return fmt.Errorf("rpc codec: proto body %T is not a proto.Message", body)
+}
+
+func (c *ProtoCodec) Write(h *Header, body any) (err error) {
+	if err := h.Check(); err != nil {
+		return err
+	}
+	defer func() {
+		c.buf.Flush()
+		if err != nil {
+			c.Close()
+		}
+	}()
+	return c.encode(h, body)
+}
+
+func (c *ProtoCodec) WriteBuffered(h *Header, body any) error {
+	if err := h.Check(); err != nil {
+		return err
+	}
+	if err := c.encode(h, body); err != nil {
+		c.Close()
+		return err
+	}
+	return nil
+}
+
+// 消息体先编码好，编码失败时连接上什么都没写
+func (c *ProtoCodec) encode(h *Header, body any) error {
+	raw, isRaw := rawBytes(body)
+	h.Flags &^= FlagRaw | FlagCompressed
+	var err error
+	switch m, isProto := body.(proto.Message); {
+	case isRaw:
+		h.Flags |= FlagRaw
+	case h.Seq == 0:
+		raw, err = json.Marshal(body)
+	case h.Error != "":
+		h.Details = nil // 详情的类型只在Go中注册，不发送
+	case isProto:
+		raw, err = proto.Marshal(m)
+	case body != nil && body != any(struct{}{}):
+		err = fmt.Errorf("rpc codec: proto body %T is not a proto.Message", body)
+	}
+	if err != nil {
+		log.Println("rpc codec: proto encoding body error:", err)
+		return err
+	}
+	c.hbuf = appendProtoHeader(c.hbuf[:0], h)
+	if err := writeFrame(c.buf, c.hbuf); err != nil {
+		return err
+	}
+	return writeFrame(c.buf, raw)
+}
+
+func (c *ProtoCodec) Flush() error {
+	return c.buf.Flush()
+}
+
+func (c *ProtoCodec) Buffered() int {
+	return c.buf.Buffered()
+}
+
+func (c *ProtoCodec) Close() error {
+	return c.conn.Close()
+}
+
+func appendProtoString(b []byte, num protowire.Number, s string) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, s)
+}
+
+func appendProtoVarint(b []byte, num protowire.Number, v uint64) []byte {
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, v)
+}
+
+// 按proto3的习惯省略零值的字段
+func appendProtoHeader(b []byte, h *Header) []byte {
+	if h.Seq != 0 {
+		b = appendProtoVarint(b, protoSeq, h.Seq)
+	}
+	if h.Name != "" {
+		b = appendProtoString(b, protoName, h.Name)
+	}
+	if h.Error != "" {
+		b = appendProtoString(b, protoError, h.Error)
+	}
+	for k, v := range h.Meta {
+		var entry []byte
+		entry = appendProtoString(entry, 1, k)
+		entry = appendProtoString(entry, 2, v)
+		b = protowire.AppendTag(b, protoMeta, protowire.BytesType)
+		b = protowire.AppendBytes(b, entry)
+	}
+	if h.Flags != 0 {
+		b = appendProtoVarint(b, protoFlags, uint64(h.Flags))
+	}
+	if h.Timeout != 0 {
+		b = appendProtoVarint(b, protoTimeout, uint64(h.Timeout))
+	}
+	if h.Code != 0 {
+		b = appendProtoVarint(b, protoCode, uint64(h.Code))
+	}
+	for _, d := range h.Details {
+		b = appendProtoString(b, protoDetails, d)
+	}
+	if h.NameID != 0 {
+		b = appendProtoVarint(b, protoNameID, uint64(h.NameID))
+	}
+	return b
+}
+
+// 解码Header帧，跳过不认识的字段，以便以后增加字段
+func decodeProtoHeader(b []byte, h *Header) error {
+	*h = Header{}
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		switch {
+		case typ == protowire.VarintType && (num == protoSeq || num == protoFlags || num == protoTimeout ||
+			num == protoCode || num == protoNameID):
+			var v uint64
+			if v, n = protowire.ConsumeVarint(b); n < 0 {
+				break
+			}
+			switch num {
+			case protoSeq:
+				h.Seq = v
+			case protoFlags:
+				h.Flags = uint32(v)
+			case protoTimeout:
+				h.Timeout = int64(v)
+			case protoCode:
+				h.Code = uint32(v)
+			case protoNameID:
+				if v > math.MaxUint16 {
+					return fmt.Errorf("name id %d out of range", v)
+				}
+				h.NameID = uint16(v)
+			}
+		case typ == protowire.BytesType && (num == protoName || num == protoError || num == protoMeta || num == protoDetails):
+			var v []byte
+			if v, n = protowire.ConsumeBytes(b); n < 0 {
+				break
+			}
+			if num == protoMeta {
+				k, val, err := decodeProtoMetaEntry(v)
+				if err != nil {
+					return err
+				}
+				if h.Meta == nil {
+					h.Meta = make(map[string]string)
+				}
+				h.Meta[k] = val
+				break
+			}
+			if !utf8.Valid(v) {
+				return errors.New("string is not valid UTF-8")
+			}
+			switch num {
+			case protoName:
+				h.Name = string(v)
+			case protoError:
+				h.Error = string(v)
+			case protoDetails:
+				h.Details = append(h.Details, string(v))
+			}
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+	}
+	return nil
+}
+
+// map<string, string>的一项：key = 1，value = 2
+func decodeProtoMetaEntry(b []byte) (k, v string, err error) {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return "", "", protowire.ParseError(n)
+		}
+		b = b[n:]
+		if typ != protowire.BytesType || num != 1 && num != 2 {
+			if n = protowire.ConsumeFieldValue(num, typ, b); n < 0 {
+				return "", "", protowire.ParseError(n)
+			}
+			b = b[n:]
+			continue
+		}
+		s, n := protowire.ConsumeBytes(b)
+		if n < 0 {
+			return "", "", protowire.ParseError(n)
+		}
+		if !utf8.Valid(s) {
+			return "", "", errors.New("string is not valid UTF-8")
+		}
+		if num == 1 {
+			k = string(s)
+		} else {
+			v = string(s)
+		}
+		b = b[n:]
+	}
+	return k, v, nil
+}
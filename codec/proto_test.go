@@ -0,0 +1,80 @@
+package codec
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestProtoCodec(t *testing.T) {
+	var stream bytes.Buffer
+	w := NewProtoCodec(rwc{Writer: &stream})
+	headers := []Header{
+		{Seq: 1, Name: "Greeter.Hello", Meta: map[string]string{"trace": "abc", "user": "ann"}, Timeout: 1e9, NameID: 3},
+		{Seq: 2, Error: "bad request", Code: 3, Details: []string{"pkg.FieldViolation"}},
+		{Seq: 3, Name: "Blob.Len"},
+		{Name: "mrpc.window"},
+	}
+	bodies := []any{wrapperspb.String("world"), struct{ D0 int }{1}, RawMessage("\x00\xffraw"), uint32(16)}
+	for i := range headers {
+		h := headers[i]
+		if err := w.Write(&h, bodies[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r := NewProtoCodec(rwc{Reader: &stream})
+	var (
+		s   wrapperspb.StringValue
+		rm  RawMessage
+		win uint32
+	)
+	for i, body := range []any{&s, nil, &rm, &win} {
+		var h Header
+		if err := r.ReadHeader(&h); err != nil {
+			t.Fatalf("header %d: %v", i, err)
+		}
+		want := headers[i]
+		switch i {
+		case 1:
+			want.Details = nil // 不发送错误详情
+		case 2:
+			want.Flags = FlagRaw
+		}
+		if !reflect.DeepEqual(h, want) {
+			t.Errorf("header %d: want %+v, got %+v", i, want, h)
+		}
+		if err := r.ReadBody(body); err != nil {
+			t.Fatalf("body %d: %v", i, err)
+		}
+	}
+	if s.GetValue() != "world" || string(rm) != "\x00\xffraw" || win != 16 {
+		t.Errorf("unexpected bodies: %q, %q, %d", s.GetValue(), rm, win)
+	}
+	if stream.Len() != 0 {
+		t.Errorf("%d bytes left in the stream", stream.Len())
+	}
+
+	// 不是proto.Message的参数编码失败，连接上什么都没写
+	stream.Reset()
+	err := NewProtoCodec(rwc{Writer: &stream}).Write(&Header{Seq: 1, Name: "Calc.Sum"}, 42)
+	if err == nil || !strings.Contains(err.Error(), "not a proto.Message") || stream.Len() != 0 {
+		t.Errorf("want encoding error, got %v with %d bytes written", err, stream.Len())
+	}
+}
+
+func TestProtoHeaderUnknownFields(t *testing.T) {
+	b := appendProtoHeader(nil, &Header{Seq: 7, Name: "A.B"})
+	b = appendProtoVarint(b, 100, 1) // 以后增加的字段
+	b = appendProtoString(b, 101, "x")
+	var h Header
+	if err := decodeProtoHeader(b, &h); err != nil || h.Seq != 7 || h.Name != "A.B" {
+		t.Errorf("got %+v, err=%v", h, err)
+	}
+	if err := decodeProtoHeader(b[:len(b)-1], &h); err == nil {
+		t.Error("want error for truncated header")
+	}
+}
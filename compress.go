@@ -0,0 +1,28 @@
+package mrpc
+
+import "github.com/micplus/mrpc/codec"
+
+// 编码后超过threshold字节的消息体压缩后发送，小消息不压缩，不增加延迟。
+// 只对支持压缩的codec(gob)生效；收到压缩的消息总能解压，
+// 但旧版本的对端不能，开启前要确认连接的客户端都已升级
+//
+//	s := mrpc.NewServer(mrpc.WithCompression(16 << 10))
+func WithCompression(threshold int) ServerOption {
+	return func(s *Server) {
+		s.UpdateSettings(func(st *ServerSettings) { st.Compression = threshold })
+	}
+}
+
+// 客户端发送的请求超过threshold字节时压缩，见WithCompression
+func WithClientCompression(threshold int) ClientOption {
+	return func(o *clientOptions) {
+		o.compress = threshold
+	}
+}
+
+func setCompression(cc codec.Codec, threshold int) codec.Codec {
+	if c, ok := cc.(codec.Compressor); ok && threshold > 0 {
+		c.SetCompression(threshold)
+	}
+	return cc
+}
@@ -0,0 +1,43 @@
+package mrpc
+
+import (
+	"strings"
+	"testing"
+)
+
+type Echo int
+
+func (*Echo) Say(s string, reply *string) error {
+	*reply = s
+	return nil
+}
+
+func TestCompression(t *testing.T) {
+	s := NewServer(WithCompression(1024))
+	if err := s.Register(new(Echo)); err != nil {
+		t.Fatal(err)
+	}
+	client, err := ConnectInProcess(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	compressing := pipeClient(t, s, WithClientCompression(1024))
+
+	big := strings.Repeat("compress me ", 10000)
+	for _, c := range []*Client{client, compressing} {
+		var reply string
+		err := c.Call("Echo.Say", "small", &reply)
+		assert(t, err == nil && reply == "small", "small call: %v %q", err, reply)
+		err = c.Call("Echo.Say", big, &reply)
+		assert(t, err == nil && reply == big, "big call: %v, %d bytes", err, len(reply))
+	}
+	// 两条连接的响应都压缩了，只有第二条的请求压缩了
+	conns := s.ConnStats()
+	assert(t, len(conns) == 2, "%d conns", len(conns))
+	for _, ci := range conns {
+		assert(t, ci.BytesWritten < int64(len(big)/10), "response not compressed: %+v", ci)
+	}
+	lo, hi := min(conns[0].BytesRead, conns[1].BytesRead), max(conns[0].BytesRead, conns[1].BytesRead)
+	assert(t, hi > int64(len(big)) && lo < int64(len(big)/10), "requests: %d and %d bytes read", lo, hi)
+}
@@ -0,0 +1,486 @@
+// 声明式的配置：从JSON、YAML文件和环境变量读出服务端、客户端和服务发现的设置，
+// 校验之后生成对应的选项，部署时改配置不必改代码：
+//
+//	cfg, err := config.Load("mrpc.yaml")
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	s := mrpc.NewServer(cfg.Server.Options()...)
+//	s.Register(new(Arith))
+//	log.Fatal(cfg.Server.Serve(s))
+//
+// 配置文件的格式：
+//
+//	server:
+//	  address: tcp@:8001
+//	  handshake_timeout: 5s
+//	  tls:
+//	    cert: /etc/mrpc/server.pem
+//	    key: /etc/mrpc/server.key
+//	client:
+//	  codec: gob
+//	  ping_interval: 30s
+//	registry:
+//	  kind: static
+//	  endpoints: ["tcp@10.0.0.1:8001", "tcp@10.0.0.2:8001 weight=2"]
+//
+// 时间写成"5s"、"1m30s"的形式。环境变量覆盖文件中的值，名称是EnvPrefix加上各级的键，
+// 全部大写、以下划线连接，如MRPC_SERVER_ADDRESS、MRPC_SERVER_TLS_CERT，列表以逗号分隔
+package config
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/micplus/mrpc"
+	"github.com/micplus/mrpc/codec"
+	"github.com/micplus/mrpc/registry/mdns"
+	"github.com/micplus/mrpc/registry/nacos"
+	"github.com/micplus/mrpc/xclient"
+)
+
+// 环境变量的前缀
+const EnvPrefix = "MRPC"
+
+type Config struct {
+	Server   ServerConfig   `json:"server"`
+	Client   ClientConfig   `json:"client"`
+	Registry RegistryConfig `json:"registry"`
+}
+
+type ServerConfig struct {
+	// 监听的地址，形如"tcp@:8001"，省略"network@"时为tcp
+	Address string    `json:"address"`
+	TLS     TLSConfig `json:"tls"`
+
+	HandshakeTimeout Duration `json:"handshake_timeout"`
+	WriteTimeout     Duration `json:"write_timeout"`
+	// 连接空闲多久后关闭，和告诉客户端的心跳间隔，见mrpc.WithKeepalive
+	IdleTimeout  Duration `json:"idle_timeout"`
+	PingInterval Duration `json:"ping_interval"`
+
+	ReadBufferSize int `json:"read_buffer_size"`
+	FlowWindow     int `json:"flow_window"`
+	// 压缩阈值，见mrpc.WithCompression
+	Compression int `json:"compression"`
+	// 每条连接读、写各自的字节速率和突发量，为0时不限速
+	BandwidthLimit int `json:"bandwidth_limit"`
+	BandwidthBurst int `json:"bandwidth_burst"`
+}
+
+type ClientConfig struct {
+	// 服务端地址，形如"tcp@127.0.0.1:8001"。使用服务发现时不需要
+	Address string `json:"address"`
+	// 编码类型，"gob"(默认)、"json"、"binjson"或"proto"，见codec.JSONType和codec.ProtoType
+	Codec string    `json:"codec"`
+	TLS   TLSConfig `json:"tls"`
+
+	PingInterval   Duration `json:"ping_interval"`
+	ReadBufferSize int      `json:"read_buffer_size"`
+	Compression    int      `json:"compression"`
+	BandwidthLimit int      `json:"bandwidth_limit"`
+	BandwidthBurst int      `json:"bandwidth_burst"`
+}
+
+// 证书和密钥都是PEM文件的路径。服务端配置了CA时要求并校验客户端证书(双向认证)，
+// 客户端配置了CA时用它代替系统的根证书校验服务端
+type TLSConfig struct {
+	Cert string `json:"cert"`
+	Key  string `json:"key"`
+	CA   string `json:"ca"`
+	// 客户端校验的服务端主机名，为空时取地址中的主机名
+	ServerName string `json:"server_name"`
+}
+
+// 是否配置了TLS，任意一项不为空即开启
+func (t *TLSConfig) Enabled() bool {
+	return t.Cert != "" || t.Key != "" || t.CA != "" || t.ServerName != ""
+}
+
+// 服务发现，Kind为空时不使用
+type RegistryConfig struct {
+	// "static"、"file"、"nacos"或"mdns"
+	Kind string `json:"kind"`
+	// static的实例，每项的格式同xclient.ParseEndpoints的一行，如"tcp@10.0.0.1:8001 weight=2"
+	Endpoints []string `json:"endpoints"`
+	// file的实例列表文件
+	Path string `json:"path"`
+	// nacos和mdns查找的服务名
+	Service string `json:"service"`
+	// nacos的服务地址、命名空间、分组和集群
+	Server    string `json:"server"`
+	Namespace string `json:"namespace"`
+	Group     string `json:"group"`
+	Cluster   string `json:"cluster"`
+	// 重新拉取实例(file为检查文件)的间隔，为0时使用各自的默认值
+	RefreshInterval Duration `json:"refresh_interval"`
+}
+
+// 可以从JSON字符串"5s"或者环境变量中解析的时间
+type Duration time.Duration
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return fmt.Errorf("invalid duration %q", text)
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// 读配置文件(path为空时跳过)，再以EnvPrefix的环境变量覆盖，最后校验。
+// 文件格式按扩展名：.json为JSON，.yaml、.yml为YAML
+func Load(path string) (*Config, error) {
+	cfg := new(Config)
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if cfg, err = Parse(data, filepath.Ext(path)); err != nil {
+			return nil, fmt.Errorf("config: %s: %w", path, err)
+		}
+	}
+	if err := cfg.ApplyEnv(EnvPrefix); err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// 按format("json"、"yaml"或"yml"，可以带点)解析配置，不认识的键是错误，不校验
+func Parse(data []byte, format string) (*Config, error) {
+	cfg := new(Config)
+	switch strings.ToLower(strings.TrimPrefix(format, ".")) {
+	case "json":
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(cfg); err != nil {
+			return nil, err
+		}
+	case "yaml", "yml":
+		doc, err := parseYAML(data)
+		if err != nil {
+			return nil, err
+		}
+		if err := assign(cfg, doc); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown config format %q", format)
+	}
+	return cfg, nil
+}
+
+var codecTypes = map[string]uint32{
+	"gob":     codec.GobType,
+	"json":    codec.JSONType,
+	"binjson": codec.BinaryJSONType,
+	"proto":   codec.ProtoType,
+}
+
+// 检查所有的设置，返回全部问题
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf("config: "+format, args...))
+		}
+	}
+	s := &c.Server
+	check(s.Address == "" || validAddr(s.Address), "server.address: invalid address %q", s.Address)
+	check(s.TLS.ServerName == "", "server.tls.server_name: only for clients")
+	check(s.TLS.CA == "" || s.TLS.Cert != "", "server.tls: ca requires cert and key")
+	checkTLS(check, "server.tls", &s.TLS)
+	for _, d := range []struct {
+		name string
+		d    Duration
+	}{
+		{"server.handshake_timeout", s.HandshakeTimeout},
+		{"server.write_timeout", s.WriteTimeout},
+		{"server.idle_timeout", s.IdleTimeout},
+		{"server.ping_interval", s.PingInterval},
+		{"client.ping_interval", c.Client.PingInterval},
+		{"registry.refresh_interval", c.Registry.RefreshInterval},
+	} {
+		check(d.d >= 0, "%s: negative duration %v", d.name, time.Duration(d.d))
+	}
+	for _, n := range []struct {
+		name string
+		n    int
+	}{
+		{"server.read_buffer_size", s.ReadBufferSize},
+		{"server.flow_window", s.FlowWindow},
+		{"server.compression", s.Compression},
+		{"server.bandwidth_limit", s.BandwidthLimit},
+		{"server.bandwidth_burst", s.BandwidthBurst},
+		{"client.read_buffer_size", c.Client.ReadBufferSize},
+		{"client.compression", c.Client.Compression},
+		{"client.bandwidth_limit", c.Client.BandwidthLimit},
+		{"client.bandwidth_burst", c.Client.BandwidthBurst},
+	} {
+		check(n.n >= 0, "%s: negative value %d", n.name, n.n)
+	}
+	check(s.BandwidthBurst == 0 || s.BandwidthLimit > 0, "server.bandwidth_burst: requires bandwidth_limit")
+
+	cl := &c.Client
+	check(cl.Address == "" || validAddr(cl.Address), "client.address: invalid address %q", cl.Address)
+	_, ok := codecTypes[cl.Codec]
+	check(cl.Codec == "" || ok, "client.codec: unknown codec %q", cl.Codec)
+	checkTLS(check, "client.tls", &cl.TLS)
+	check(cl.BandwidthBurst == 0 || cl.BandwidthLimit > 0, "client.bandwidth_burst: requires bandwidth_limit")
+
+	r := &c.Registry
+	switch r.Kind {
+	case "":
+	case "static":
+		check(len(r.Endpoints) > 0, "registry.endpoints: required for static registry")
+		if _, err := r.endpoints(); err != nil {
+			check(false, "registry.endpoints: %v", err)
+		}
+	case "file":
+		check(r.Path != "", "registry.path: required for file registry")
+	case "nacos":
+		check(r.Server != "", "registry.server: required for nacos registry")
+		check(r.Service != "", "registry.service: required for nacos registry")
+	case "mdns":
+	default:
+		check(false, "registry.kind: unknown registry %q", r.Kind)
+	}
+	return errors.Join(errs...)
+}
+
+func checkTLS(check func(bool, string, ...any), name string, t *TLSConfig) {
+	check((t.Cert == "") == (t.Key == ""), "%s: cert and key must be set together", name)
+}
+
+func validAddr(addr string) bool {
+	network, address := splitAddr(addr)
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+		_, _, err := net.SplitHostPort(address)
+		return err == nil
+	case "unix":
+		return address != ""
+	}
+	return false
+}
+
+// 拆出"network@address"中的网络类型和地址，同xclient.Endpoint
+func splitAddr(addr string) (network, address string) {
+	if i := strings.Index(addr, "@"); i >= 0 {
+		return addr[:i], addr[i+1:]
+	}
+	return "tcp", addr
+}
+
+// 服务端的选项，TLS和地址不在其中，见Serve
+func (c *ServerConfig) Options() []mrpc.ServerOption {
+	var opts []mrpc.ServerOption
+	if c.HandshakeTimeout > 0 {
+		opts = append(opts, mrpc.WithHandshakeTimeout(time.Duration(c.HandshakeTimeout)))
+	}
+	if c.WriteTimeout > 0 {
+		opts = append(opts, mrpc.WithWriteTimeout(time.Duration(c.WriteTimeout)))
+	}
+	if c.IdleTimeout > 0 || c.PingInterval > 0 {
+		opts = append(opts, mrpc.WithKeepalive(time.Duration(c.IdleTimeout), time.Duration(c.PingInterval)))
+	}
+	if c.ReadBufferSize > 0 {
+		opts = append(opts, mrpc.WithReadBufferSize(c.ReadBufferSize))
+	}
+	if c.FlowWindow > 0 {
+		opts = append(opts, mrpc.WithFlowWindow(c.FlowWindow))
+	}
+	if c.Compression > 0 {
+		opts = append(opts, mrpc.WithCompression(c.Compression))
+	}
+	if c.BandwidthLimit > 0 {
+		rate, burst := c.BandwidthLimit, c.BandwidthBurst
+		opts = append(opts, mrpc.WithBandwidthLimit(func(net.Conn, *mrpc.Identity) (read, write *mrpc.RateLimiter) {
+			return mrpc.NewRateLimiter(rate, burst), mrpc.NewRateLimiter(rate, burst)
+		}))
+	}
+	return opts
+}
+
+// 服务端的TLS配置，没有配置TLS时返回nil
+func (c *ServerConfig) TLSConfig() (*tls.Config, error) {
+	if !c.TLS.Enabled() {
+		return nil, nil
+	}
+	cert, err := tls.LoadX509KeyPair(c.TLS.Cert, c.TLS.Key)
+	if err != nil {
+		return nil, fmt.Errorf("config: server.tls: %w", err)
+	}
+	config := &tls.Config{Certificates: []tls.Certificate{cert}}
+	if c.TLS.CA != "" {
+		if config.ClientCAs, err = loadPool(c.TLS.CA); err != nil {
+			return nil, fmt.Errorf("config: server.tls: %w", err)
+		}
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config, nil
+}
+
+// 在Address上监听，按配置接受TLS或普通的连接，直到listener关闭
+func (c *ServerConfig) Serve(s *mrpc.Server) error {
+	if c.Address == "" {
+		return errors.New("config: server.address is empty")
+	}
+	config, err := c.TLSConfig()
+	if err != nil {
+		return err
+	}
+	lis, err := net.Listen(splitAddr(c.Address))
+	if err != nil {
+		return err
+	}
+	if config != nil {
+		s.ServeTLS(lis, config)
+	} else {
+		s.Accept(lis)
+	}
+	return nil
+}
+
+// 客户端使用的编码类型
+func (c *ClientConfig) CodecType() uint32 {
+	if t, ok := codecTypes[c.Codec]; ok {
+		return t
+	}
+	return codec.GobType
+}
+
+// 客户端的选项，TLS和地址不在其中，见Dial
+func (c *ClientConfig) Options() []mrpc.ClientOption {
+	opts := []mrpc.ClientOption{mrpc.WithClientCodecType(c.CodecType())}
+	if c.PingInterval > 0 {
+		opts = append(opts, mrpc.WithClientKeepalive(time.Duration(c.PingInterval)))
+	}
+	if c.ReadBufferSize > 0 {
+		opts = append(opts, mrpc.WithClientReadBufferSize(c.ReadBufferSize))
+	}
+	if c.Compression > 0 {
+		opts = append(opts, mrpc.WithClientCompression(c.Compression))
+	}
+	if c.BandwidthLimit > 0 {
+		opts = append(opts, mrpc.WithClientBandwidthLimit(
+			mrpc.NewRateLimiter(c.BandwidthLimit, c.BandwidthBurst),
+			mrpc.NewRateLimiter(c.BandwidthLimit, c.BandwidthBurst)))
+	}
+	return opts
+}
+
+// 客户端的TLS配置，没有配置TLS时返回nil
+func (c *ClientConfig) TLSConfig() (*tls.Config, error) {
+	if !c.TLS.Enabled() {
+		return nil, nil
+	}
+	config := &tls.Config{ServerName: c.TLS.ServerName}
+	if c.TLS.Cert != "" {
+		cert, err := tls.LoadX509KeyPair(c.TLS.Cert, c.TLS.Key)
+		if err != nil {
+			return nil, fmt.Errorf("config: client.tls: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	if c.TLS.CA != "" {
+		pool, err := loadPool(c.TLS.CA)
+		if err != nil {
+			return nil, fmt.Errorf("config: client.tls: %w", err)
+		}
+		config.RootCAs = pool
+	}
+	return config, nil
+}
+
+// 连接Address，opts追加在配置生成的选项之后
+func (c *ClientConfig) Dial(opts ...mrpc.ClientOption) (*mrpc.Client, error) {
+	if c.Address == "" {
+		return nil, errors.New("config: client.address is empty")
+	}
+	config, err := c.TLSConfig()
+	if err != nil {
+		return nil, err
+	}
+	network, address := splitAddr(c.Address)
+	opts = append(c.Options(), opts...)
+	if config != nil {
+		return mrpc.DialTLS(network, address, config, opts...)
+	}
+	return mrpc.DialOptions(network, address, opts...)
+}
+
+func loadPool(path string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates in %s", path)
+	}
+	return pool, nil
+}
+
+func (r *RegistryConfig) endpoints() ([]xclient.Endpoint, error) {
+	return xclient.ParseEndpoints(strings.NewReader(strings.Join(r.Endpoints, "\n")))
+}
+
+// 按Kind创建服务发现，Kind为空时返回nil。file、nacos和mdns的服务发现在后台刷新，
+// 不再使用时调用它们的Close
+func (r *RegistryConfig) Discovery() (xclient.Discovery, error) {
+	refresh := time.Duration(r.RefreshInterval)
+	switch r.Kind {
+	case "":
+		return nil, nil
+	case "static":
+		endpoints, err := r.endpoints()
+		if err != nil {
+			return nil, fmt.Errorf("config: registry.endpoints: %w", err)
+		}
+		return xclient.NewStaticDiscovery(endpoints...), nil
+	case "file":
+		return xclient.NewFileDiscovery(r.Path, refresh)
+	case "nacos":
+		return nacos.NewDiscovery(nacos.Config{
+			Server:          r.Server,
+			Namespace:       r.Namespace,
+			Group:           r.Group,
+			Cluster:         r.Cluster,
+			RefreshInterval: refresh,
+		}, r.Service), nil
+	case "mdns":
+		return mdns.NewDiscovery(mdns.Config{Service: r.Service, RefreshInterval: refresh})
+	}
+	return nil, fmt.Errorf("config: unknown registry %q", r.Kind)
+}
+
+// 以服务发现和客户端的编码类型创建XClient
+func (c *Config) XClient(mode xclient.SelectMode, opts ...xclient.Option) (*xclient.XClient, error) {
+	d, err := c.Registry.Discovery()
+	if err != nil {
+		return nil, err
+	}
+	if d == nil {
+		return nil, errors.New("config: registry.kind is empty")
+	}
+	opts = append([]xclient.Option{xclient.WithCodecType(c.Client.CodecType())}, opts...)
+	return xclient.NewXClient(d, mode, opts...), nil
+}
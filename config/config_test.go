@@ -0,0 +1,169 @@
+package config
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/micplus/mrpc"
+)
+
+func assert(t *testing.T, cond bool, format string, args ...any) {
+	t.Helper()
+	if !cond {
+		t.Fatalf(format, args...)
+	}
+}
+
+const yamlConfig = `
+# 服务端
+server:
+  address: "tcp@127.0.0.1:8001"
+  handshake_timeout: 5s   # 握手期限
+  flow_window: 64
+  tls:
+    cert: server.pem
+    key: 'server.key'
+client:
+  codec: json
+  ping_interval: 1m30s
+registry:
+  kind: static
+  endpoints:
+    - tcp@10.0.0.1:8001
+    - "tcp@10.0.0.2:8001 weight=2"
+`
+
+const jsonConfig = `{
+	"server": {
+		"address": "tcp@127.0.0.1:8001",
+		"handshake_timeout": "5s",
+		"flow_window": 64,
+		"tls": {"cert": "server.pem", "key": "server.key"}
+	},
+	"client": {"codec": "json", "ping_interval": "1m30s"},
+	"registry": {
+		"kind": "static",
+		"endpoints": ["tcp@10.0.0.1:8001", "tcp@10.0.0.2:8001 weight=2"]
+	}
+}`
+
+func TestParse(t *testing.T) {
+	fromYAML, err := Parse([]byte(yamlConfig), "yaml")
+	assert(t, err == nil, "parse yaml: %v", err)
+	fromJSON, err := Parse([]byte(jsonConfig), ".json")
+	assert(t, err == nil, "parse json: %v", err)
+	assert(t, reflect.DeepEqual(fromYAML, fromJSON), "yaml %+v != json %+v", fromYAML, fromJSON)
+	assert(t, fromYAML.Server.HandshakeTimeout == Duration(5*time.Second), "handshake timeout %v", fromYAML.Server.HandshakeTimeout)
+	assert(t, fromYAML.Client.PingInterval == Duration(90*time.Second), "ping interval %v", fromYAML.Client.PingInterval)
+
+	// 同一行的列表与分行的列表相同
+	flow, err := Parse([]byte("registry:\n  kind: static\n  endpoints: [tcp@10.0.0.1:8001, \"tcp@10.0.0.2:8001 weight=2\"]\n"), "yml")
+	assert(t, err == nil, "parse flow list: %v", err)
+	assert(t, reflect.DeepEqual(flow.Registry, fromYAML.Registry), "flow list %+v", flow.Registry)
+
+	for _, tc := range []struct{ format, data, want string }{
+		{"yaml", "server:\n  adress: :8001\n", `unknown key "server.adress"`},
+		{"yaml", "server:\n  write_timeout: soon\n", "server.write_timeout: invalid duration"},
+		{"yaml", "server:\n  flow_window: many\n", "server.flow_window: invalid integer"},
+		{"yaml", "server: :8001\n", "want a mapping"},
+		{"yaml", "server:\n  address: :8001\n    tls: x\n", "line 3: unexpected indentation"},
+		{"yaml", "registry:\n  endpoints:\n    - addr: x\n", "line 3: only scalar list items"},
+		{"json", `{"server": {"adress": ":8001"}}`, "unknown field"},
+		{"json", `{"server": {"write_timeout": 5}}`, "cannot unmarshal"},
+		{"toml", "", "unknown config format"},
+	} {
+		_, err := Parse([]byte(tc.data), tc.format)
+		assert(t, err != nil && strings.Contains(err.Error(), tc.want), "parse %q: want %q, got %v", tc.data, tc.want, err)
+	}
+}
+
+func TestLoadEnv(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mrpc.yaml")
+	os.WriteFile(path, []byte(yamlConfig), 0o600)
+	t.Setenv("MRPC_SERVER_ADDRESS", "tcp@:9001")
+	t.Setenv("MRPC_SERVER_WRITE_TIMEOUT", "10s")
+	t.Setenv("MRPC_SERVER_TLS_CERT", "other.pem")
+	t.Setenv("MRPC_REGISTRY_ENDPOINTS", "tcp@10.0.0.3:8001, tcp@10.0.0.4:8001")
+	cfg, err := Load(path)
+	assert(t, err == nil, "load: %v", err)
+	assert(t, cfg.Server.Address == "tcp@:9001", "address %q", cfg.Server.Address)
+	assert(t, cfg.Server.WriteTimeout == Duration(10*time.Second), "write timeout %v", cfg.Server.WriteTimeout)
+	assert(t, cfg.Server.TLS.Cert == "other.pem" && cfg.Server.TLS.Key == "server.key", "tls %+v", cfg.Server.TLS)
+	assert(t, cfg.Server.FlowWindow == 64, "values from the file are kept: %+v", cfg.Server)
+	assert(t, reflect.DeepEqual(cfg.Registry.Endpoints, []string{"tcp@10.0.0.3:8001", "tcp@10.0.0.4:8001"}),
+		"endpoints %q", cfg.Registry.Endpoints)
+
+	t.Setenv("MRPC_CLIENT_PING_INTERVAL", "often")
+	_, err = Load(path)
+	assert(t, err != nil && strings.Contains(err.Error(), "MRPC_CLIENT_PING_INTERVAL"), "bad env: %v", err)
+}
+
+func TestValidate(t *testing.T) {
+	cfg := &Config{
+		Server: ServerConfig{
+			Address:        "udp@:8001",
+			TLS:            TLSConfig{Cert: "server.pem"},
+			WriteTimeout:   Duration(-time.Second),
+			BandwidthBurst: 1024,
+		},
+		Client:   ClientConfig{Address: "localhost", Codec: "xml"},
+		Registry: RegistryConfig{Kind: "nacos", Service: "Arith"},
+	}
+	err := cfg.Validate()
+	assert(t, err != nil, "invalid config passed validation")
+	for _, want := range []string{
+		`server.address: invalid address "udp@:8001"`,
+		"server.tls: cert and key must be set together",
+		"server.write_timeout: negative duration",
+		"server.bandwidth_burst: requires bandwidth_limit",
+		`client.address: invalid address "localhost"`,
+		`client.codec: unknown codec "xml"`,
+		"registry.server: required for nacos registry",
+	} {
+		assert(t, strings.Contains(err.Error(), want), "want %q in\n%v", want, err)
+	}
+	assert(t, strings.Count(err.Error(), "\n") == 6, "want 7 problems, got\n%v", err)
+
+	assert(t, (&Config{}).Validate() == nil, "empty config is valid")
+	assert(t, (&Config{Registry: RegistryConfig{Kind: "static", Endpoints: []string{"a:1 weight"}}}).Validate() != nil,
+		"bad endpoint passed validation")
+}
+
+type Arith int
+
+func (*Arith) Add(args [2]int, reply *int) error {
+	*reply = args[0] + args[1]
+	return nil
+}
+
+func TestDial(t *testing.T) {
+	cfg, err := Parse([]byte(yamlConfig), "yaml")
+	assert(t, err == nil, "parse: %v", err)
+	cfg.Server.TLS = TLSConfig{}
+	s := mrpc.NewServer(cfg.Server.Options()...)
+	s.Register(new(Arith))
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	assert(t, err == nil, "listen: %v", err)
+	defer lis.Close()
+	go s.Accept(lis)
+
+	cfg.Client.Codec = ""
+	cfg.Client.Address = "tcp@" + lis.Addr().String()
+	client, err := cfg.Client.Dial()
+	assert(t, err == nil, "dial: %v", err)
+	defer client.Close()
+	var sum int
+	err = client.Call("Arith.Add", [2]int{1, 2}, &sum)
+	assert(t, err == nil && sum == 3, "call: %d, %v", sum, err)
+
+	d, err := cfg.Registry.Discovery()
+	assert(t, err == nil, "discovery: %v", err)
+	endpoints, _ := d.GetAll()
+	assert(t, len(endpoints) == 2 && endpoints[1].Addr == "tcp@10.0.0.2:8001" && endpoints[1].Weight == 2,
+		"endpoints %+v", endpoints)
+}
@@ -0,0 +1,154 @@
+package config
+
+import (
+	"encoding"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// 以prefix开头的环境变量覆盖配置，变量名见包的说明。只处理设置了的变量，空值也会覆盖
+func (c *Config) ApplyEnv(prefix string) error {
+	var errs []error
+	walkFields(reflect.ValueOf(c).Elem(), nil, func(v reflect.Value, path []string) {
+		name := strings.ToUpper(prefix + "_" + strings.Join(path, "_"))
+		if s, ok := os.LookupEnv(name); ok {
+			if err := setText(v, s); err != nil {
+				errs = append(errs, fmt.Errorf("config: %s: %v", name, err))
+			}
+		}
+	})
+	return errors.Join(errs...)
+}
+
+// 对每个不是结构体的字段调用fn，path是各级的键
+func walkFields(v reflect.Value, path []string, fn func(v reflect.Value, path []string)) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		key := fieldKey(t.Field(i))
+		if key == "" {
+			continue
+		}
+		f := v.Field(i)
+		if f.Kind() == reflect.Struct && !isText(f) {
+			walkFields(f, append(path, key), fn)
+			continue
+		}
+		fn(f, append(path, key))
+	}
+}
+
+func fieldKey(f reflect.StructField) string {
+	if !f.IsExported() {
+		return ""
+	}
+	name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+	if name == "-" {
+		return ""
+	}
+	return name
+}
+
+// 把parseYAML的结果赋给cfg，不认识的键是错误
+func assign(cfg *Config, doc map[string]any) error {
+	return assignValue(reflect.ValueOf(cfg).Elem(), doc, "")
+}
+
+func assignValue(v reflect.Value, node any, path string) error {
+	switch node := node.(type) {
+	case nil:
+		return nil
+	case map[string]any:
+		if v.Kind() != reflect.Struct || isText(v) {
+			return fmt.Errorf("%s: want a value, got a mapping", path)
+		}
+		for _, key := range slices.Sorted(maps.Keys(node)) {
+			child := node[key]
+			f, ok := fieldByKey(v, key)
+			if !ok {
+				return fmt.Errorf("unknown key %q", joinPath(path, key))
+			}
+			if err := assignValue(f, child, joinPath(path, key)); err != nil {
+				return err
+			}
+		}
+		return nil
+	case []string:
+		if v.Kind() != reflect.Slice {
+			return fmt.Errorf("%s: want a value, got a list", path)
+		}
+		v.Set(reflect.ValueOf(node))
+		return nil
+	case string:
+		if v.Kind() == reflect.Struct && !isText(v) {
+			return fmt.Errorf("%s: want a mapping, got %q", path, node)
+		}
+		if v.Kind() == reflect.Slice {
+			return fmt.Errorf("%s: want a list, got %q", path, node)
+		}
+		if err := setText(v, node); err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+	}
+	return nil
+}
+
+func fieldByKey(v reflect.Value, key string) (reflect.Value, bool) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		if fieldKey(t.Field(i)) == key {
+			return v.Field(i), true
+		}
+	}
+	return reflect.Value{}, false
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func isText(v reflect.Value) bool {
+	return v.CanAddr() && v.Addr().Type().Implements(reflect.TypeFor[encoding.TextUnmarshaler]())
+}
+
+// 按字段的类型解析s，列表以逗号分隔
+func setText(v reflect.Value, s string) error {
+	if isText(v) {
+		return v.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(s))
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", s)
+		}
+		v.SetInt(n)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", s)
+		}
+		v.SetBool(b)
+	case reflect.Slice:
+		var items []string
+		for _, item := range strings.Split(s, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		v.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+	return nil
+}
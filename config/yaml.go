@@ -0,0 +1,237 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// 配置文件用到的YAML子集：以空格缩进的映射、"- "开头的列表、[a, b]形式的列表、
+// 带引号或不带引号的标量和#注释。标量都作为字符串，由字段的类型解析。
+// 不支持锚点、多行字符串、{}形式的映射和元素是映射的列表
+
+// 解析结果的节点是map[string]any、[]string、string，或者空值nil
+func parseYAML(data []byte) (map[string]any, error) {
+	var lines []yamlLine
+	for i, text := range strings.Split(string(data), "\n") {
+		text = strings.TrimRight(stripComment(text), " \t\r")
+		if strings.TrimSpace(text) == "" || text == "---" {
+			continue
+		}
+		content := strings.TrimLeft(text, " ")
+		if strings.HasPrefix(content, "\t") {
+			return nil, fmt.Errorf("line %d: tabs are not allowed in indentation", i+1)
+		}
+		lines = append(lines, yamlLine{num: i + 1, indent: len(text) - len(content), text: content})
+	}
+	p := &yamlParser{lines: lines}
+	if len(lines) == 0 {
+		return map[string]any{}, nil
+	}
+	if lines[0].indent != 0 {
+		return nil, fmt.Errorf("line %d: unexpected indentation", lines[0].num)
+	}
+	doc, err := p.mapping(0)
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(lines) {
+		return nil, fmt.Errorf("line %d: unexpected indentation", lines[p.pos].num)
+	}
+	return doc, nil
+}
+
+type yamlLine struct {
+	num    int
+	indent int
+	text   string
+}
+
+type yamlParser struct {
+	lines []yamlLine
+	pos   int
+}
+
+func (p *yamlParser) mapping(indent int) (map[string]any, error) {
+	m := make(map[string]any)
+	for p.pos < len(p.lines) && p.lines[p.pos].indent == indent {
+		line := p.lines[p.pos]
+		if strings.HasPrefix(line.text, "-") {
+			return nil, fmt.Errorf("line %d: unexpected list item", line.num)
+		}
+		key, rest, ok := cutKey(line.text)
+		if !ok {
+			return nil, fmt.Errorf("line %d: want key: value, got %q", line.num, line.text)
+		}
+		if _, dup := m[key]; dup {
+			return nil, fmt.Errorf("line %d: duplicate key %q", line.num, key)
+		}
+		p.pos++
+		if rest != "" {
+			v, err := flowValue(rest)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", line.num, err)
+			}
+			m[key] = v
+			continue
+		}
+		// 值在下面缩进更多的行中，没有时为空
+		m[key] = nil
+		if p.pos == len(p.lines) {
+			continue
+		}
+		next := p.lines[p.pos]
+		switch {
+		case next.indent > indent && strings.HasPrefix(next.text, "-"):
+			v, err := p.list(next.indent)
+			if err != nil {
+				return nil, err
+			}
+			m[key] = v
+		case next.indent > indent:
+			v, err := p.mapping(next.indent)
+			if err != nil {
+				return nil, err
+			}
+			m[key] = v
+		case next.indent == indent && strings.HasPrefix(next.text, "-"): // 列表可以与键对齐
+			v, err := p.list(next.indent)
+			if err != nil {
+				return nil, err
+			}
+			m[key] = v
+		}
+	}
+	if p.pos < len(p.lines) && p.lines[p.pos].indent > indent {
+		return nil, fmt.Errorf("line %d: unexpected indentation", p.lines[p.pos].num)
+	}
+	return m, nil
+}
+
+func (p *yamlParser) list(indent int) ([]string, error) {
+	var items []string
+	for p.pos < len(p.lines) && p.lines[p.pos].indent == indent && strings.HasPrefix(p.lines[p.pos].text, "-") {
+		line := p.lines[p.pos]
+		item := strings.TrimSpace(line.text[1:])
+		if line.text != "-" && line.text[1] != ' ' {
+			return nil, fmt.Errorf("line %d: want \"- item\", got %q", line.num, line.text)
+		}
+		if _, _, ok := cutKey(item); ok || strings.HasPrefix(item, "[") {
+			return nil, fmt.Errorf("line %d: only scalar list items are supported", line.num)
+		}
+		s, err := scalar(item)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line.num, err)
+		}
+		items = append(items, s)
+		p.pos++
+	}
+	return items, nil
+}
+
+// 拆出"key: value"，冒号后必须是空白或者行尾
+func cutKey(text string) (key, rest string, ok bool) {
+	if text == "" || text[0] == '"' || text[0] == '\'' {
+		return "", "", false
+	}
+	for i := 0; i < len(text); i++ {
+		if text[i] == ':' && (i+1 == len(text) || text[i+1] == ' ') {
+			key = strings.TrimSpace(text[:i])
+			return key, strings.TrimSpace(text[i+1:]), key != ""
+		}
+	}
+	return "", "", false
+}
+
+// 同一行上的值：标量或者[a, b]
+func flowValue(text string) (any, error) {
+	switch {
+	case strings.HasPrefix(text, "{"):
+		return nil, fmt.Errorf("flow mappings are not supported")
+	case strings.HasPrefix(text, "["):
+		if !strings.HasSuffix(text, "]") {
+			return nil, fmt.Errorf("unterminated list %q", text)
+		}
+		items := []string{}
+		for _, item := range splitFlow(text[1 : len(text)-1]) {
+			if item = strings.TrimSpace(item); item == "" {
+				continue
+			}
+			s, err := scalar(item)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, s)
+		}
+		return items, nil
+	case text == "~" || text == "null":
+		return nil, nil
+	}
+	return scalar(text)
+}
+
+// 按不在引号中的逗号分开
+func splitFlow(text string) []string {
+	var items []string
+	var quote byte
+	start := 0
+	for i := 0; i < len(text); i++ {
+		switch c := text[i]; {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == ',':
+			items = append(items, text[start:i])
+			start = i + 1
+		}
+	}
+	return append(items, text[start:])
+}
+
+func scalar(text string) (string, error) {
+	switch {
+	case strings.HasPrefix(text, `"`):
+		s, err := strconv.Unquote(text)
+		if err != nil {
+			return "", fmt.Errorf("invalid quoted string %s", text)
+		}
+		return s, nil
+	case strings.HasPrefix(text, "'"):
+		if len(text) < 2 || !strings.HasSuffix(text, "'") {
+			return "", fmt.Errorf("invalid quoted string %s", text)
+		}
+		return strings.ReplaceAll(text[1:len(text)-1], "''", "'"), nil
+	case strings.HasPrefix(text, "&") || strings.HasPrefix(text, "*"):
+		return "", fmt.Errorf("anchors are not supported")
+	case text == "|" || text == ">":
+		return "", fmt.Errorf("multi-line strings are not supported")
+	}
+	return text, nil
+}
+
+// 去掉不在引号中、行首或者空白之后的#开始的注释
+func stripComment(text string) string {
+	var quote byte
+	for i := 0; i < len(text); i++ {
+		switch c := text[i]; {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			if i == 0 || strings.ContainsRune(" [,:-", rune(text[i-1])) {
+				quote = c
+			}
+		case c == '#' && (i == 0 || text[i-1] == ' ' || text[i-1] == '\t'):
+			return text[:i]
+		}
+	}
+	return text
+}
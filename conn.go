@@ -0,0 +1,57 @@
+package mrpc
+
+import (
+	"bufio"
+	"crypto/tls"
+	"io"
+	"net"
+)
+
+// 读端带缓冲的连接，写和关闭直接作用于原连接。
+// bufio.Reader实现了io.ByteReader，gob解码器不会再套一层自己的缓冲
+type bufferedConn struct {
+	*bufio.Reader
+	io.WriteCloser
+}
+
+func newBufferedConn(conn io.ReadWriteCloser, size int) io.ReadWriteCloser {
+	if size <= 0 {
+		return conn
+	}
+	return &bufferedConn{Reader: bufio.NewReaderSize(conn, size), WriteCloser: conn}
+}
+
+// TCP连接的套接字参数，非TCP连接(如net.Pipe、unix socket)忽略
+type socketOptions struct {
+	noDelay    bool // 关闭Nagle算法，Go的TCP连接默认如此
+	sendBuffer int  // SO_SNDBUF，<=0时使用系统默认值
+	recvBuffer int  // SO_RCVBUF，<=0时使用系统默认值
+}
+
+func defaultSocketOptions() socketOptions {
+	return socketOptions{noDelay: true}
+}
+
+func (o socketOptions) apply(conn net.Conn) error {
+	if t, ok := conn.(*tls.Conn); ok { // 设置在下层的TCP连接上
+		conn = t.NetConn()
+	}
+	tc, ok := conn.(*net.TCPConn)
+	if !ok {
+		return nil
+	}
+	if err := tc.SetNoDelay(o.noDelay); err != nil {
+		return err
+	}
+	if o.sendBuffer > 0 {
+		if err := tc.SetWriteBuffer(o.sendBuffer); err != nil {
+			return err
+		}
+	}
+	if o.recvBuffer > 0 {
+		if err := tc.SetReadBuffer(o.recvBuffer); err != nil {
+			return err
+		}
+	}
+	return nil
+}
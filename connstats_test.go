@@ -0,0 +1,46 @@
+package mrpc
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConnStats(t *testing.T) {
+	client, s, err := NewClientServerPair(new(Calc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sum int
+	for i := 0; i < 3; i++ {
+		client.Call("Calc.Sum", Pair{i, i}, &sum)
+	}
+
+	cs := client.ConnStats()
+	assert(t, cs.MessagesWritten == 3 && cs.MessagesRead == 3, "client messages: %+v", cs)
+	conns := s.ConnStats()
+	if len(conns) != 1 {
+		t.Fatalf("want 1 server connection, got %+v", conns)
+	}
+	// 客户端收到最后一个响应时，服务端的Write可能还没返回
+	for i := 0; i < 100 && conns[0].BytesWritten < cs.BytesRead; i++ {
+		time.Sleep(time.Millisecond)
+		conns = s.ConnStats()
+	}
+	ss := conns[0]
+	// 握手的8字节不经过计数
+	assert(t, ss.MessagesRead == 3 && ss.BytesRead == cs.BytesWritten,
+		"server read %d messages %d bytes, client wrote %d bytes", ss.MessagesRead, ss.BytesRead, cs.BytesWritten)
+	assert(t, ss.BytesWritten == cs.BytesRead, "server wrote %d bytes, client read %d", ss.BytesWritten, cs.BytesRead)
+
+	rec := httptest.NewRecorder()
+	s.DebugHandler().ServeHTTP(rec, httptest.NewRequest("GET", DefaultDebugPath, nil))
+	assert(t, strings.Contains(rec.Body.String(), "<h2>Connections</h2>"), "debug page missing connections")
+
+	client.Close()
+	for i := 0; i < 100 && len(s.ConnStats()) > 0; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	assert(t, len(s.ConnStats()) == 0, "closed connection still listed")
+}
@@ -0,0 +1,74 @@
+package mrpc
+
+import (
+	"html/template"
+	"log"
+	"net/http"
+)
+
+// 调试页面的默认路径
+//
+//	http.Handle(mrpc.DefaultDebugPath, server.DebugHandler())
+const DefaultDebugPath = "/debug/mrpc"
+
+var debugPage = template.Must(template.New("debug").Parse(`<html>
+<head><title>mrpc services</title></head>
+<body>
+<h2>Methods</h2>
+<table border="1" cellpadding="5">
+<tr><th>Method</th><th>Calls</th><th>Errors</th><th>Active</th><th>Mean</th><th>p50</th><th>p99</th><th>Max</th><th>Last error</th></tr>
+{{range .Methods}}<tr>
+<td>{{.Name}}</td><td align="right">{{.Calls}}</td><td align="right">{{.Errors}}</td><td align="right">{{.Active}}</td>
+<td align="right">{{.Latency.Mean}}</td><td align="right">{{.Latency.Quantile 0.5}}</td>
+<td align="right">{{.Latency.Quantile 0.99}}</td><td align="right">{{.Latency.Max}}</td><td>{{.LastError}}</td>
+</tr>
+{{end}}</table>
+<h2>Connections</h2>
+<table border="1" cellpadding="5">
+<tr><th>Remote</th><th>Since</th><th>Bytes in</th><th>Bytes out</th><th>Messages in</th><th>Messages out</th></tr>
+{{range .Conns}}<tr>
+<td>{{.RemoteAddr}}</td><td>{{.Since.Format "2006-01-02 15:04:05"}}</td>
+<td align="right">{{.BytesRead}}</td><td align="right">{{.BytesWritten}}</td>
+<td align="right">{{.MessagesRead}}</td><td align="right">{{.MessagesWritten}}</td>
+</tr>
+{{end}}</table>
+{{if .Tracing}}
+<h2>Active requests</h2>
+{{template "requests" .Active}}
+<h2>Recent errors</h2>
+{{template "requests" .Errors}}
+<h2>Recent requests</h2>
+{{template "requests" .Recent}}
+{{end}}
+</body>
+</html>
+{{define "requests"}}<table border="1" cellpadding="5">
+<tr><th>Start</th><th>Elapsed</th><th>Method</th><th>Seq</th><th>Remote</th><th>Error</th></tr>
+{{range .}}<tr>
+<td>{{.Start.Format "15:04:05.000000"}}</td><td align="right">{{.Elapsed}}</td>
+<td>{{.Method}}</td><td align="right">{{.Seq}}</td><td>{{.Remote}}</td><td>{{.Error}}</td>
+</tr>
+{{end}}</table>{{end}}`))
+
+// 以HTML表格展示MethodStats、ConnStats，开启了WithRequestTrace时还有请求追踪，
+// 类似net/rpc的/debug/rpc
+func (s *Server) DebugHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := debugPage.Execute(w, struct {
+			Methods                []MethodStats
+			Conns                  []ConnInfo
+			Tracing                bool
+			Active, Errors, Recent []RequestTrace
+		}{
+			Methods: s.MethodStats(),
+			Conns:   s.ConnStats(),
+			Tracing: s.requests != nil,
+			Active:  s.ActiveRequests(),
+			Errors:  s.RecentRequests("", true),
+			Recent:  s.RecentRequests("", false),
+		}); err != nil {
+			log.Println("rpc server: executing debug template error:", err)
+		}
+	})
+}
@@ -0,0 +1,97 @@
+package mrpc
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+)
+
+// 动态方法：没有事先注册，在第一次收到请求时才确定参数类型和处理函数，
+// 代理等转发调用的服务端使用它。Handler的参数同HandleFunc：arg指向参数
+// (参数本身是指针时就是它)，reply是返回值指针
+type DynamicMethod struct {
+	ArgType   reflect.Type
+	ReplyType reflect.Type // 必须是指针
+	Handler   func(ctx context.Context, arg, reply any) error
+}
+
+type dynamicMethod struct {
+	svc *service
+	mt  *methodType
+}
+
+// 找不到注册的方法时由find给出，name形如"Service.Method"，找到的方法按名称缓存。
+// find在连接的读协程中调用，会阻塞这条连接上后续请求的读取，应当尽快返回；
+// 它返回的错误作为调用的错误回给客户端，不缓存；设置了WithFallbackHandler时改由兜底处理
+func WithDynamicMethods(find func(name string) (*DynamicMethod, error)) ServerOption {
+	return func(s *Server) {
+		s.findDynamic = find
+	}
+}
+
+func (s *Server) dynamicMethod(name string) (*service, *methodType, error) {
+	if dm, ok := s.dynamic.Load(name); ok {
+		return dm.(*dynamicMethod).svc, dm.(*dynamicMethod).mt, nil
+	}
+	if s.findDynamic == nil {
+		return s.fallback.svc, s.fallback.mt, nil
+	}
+	m, err := s.findDynamic(name)
+	if err != nil {
+		if s.fallback != nil {
+			return s.fallback.svc, s.fallback.mt, nil
+		}
+		return nil, nil, err
+	}
+	if m == nil || m.Handler == nil || m.ArgType == nil || m.ReplyType == nil || m.ReplyType.Kind() != reflect.Pointer {
+		return nil, nil, errors.New("rpc server: invalid dynamic method " + name)
+	}
+	mt := &methodType{
+		name:        name,
+		ArgType:     m.ArgType,
+		ReplyType:   m.ReplyType,
+		withContext: true,
+		handler:     m.Handler,
+		vars:        newMethodVars(name),
+	}
+	mt.initPools()
+	sName := name[:strings.LastIndex(name, ".")]
+	dm, _ := s.dynamic.LoadOrStore(name, &dynamicMethod{svc: &service{name: sName}, mt: mt})
+	return dm.(*dynamicMethod).svc, dm.(*dynamicMethod).mt, nil
+}
+
+// 兜底的处理函数收到的方法名，见WithFallbackHandler
+type fallbackKey struct{}
+
+// 没有注册、也不由WithDynamicMethods给出的方法都交给fn处理，method形如"Service.Method"。
+// 参数和返回值都是原始字节，客户端须以RawMessage或[]byte传参、接收返回值，
+// 按类型编码的参数读不出来，调用返回错误。转发给其它系统、接脚本语言、
+// 逐步迁移时不必为每个方法写接收者：
+//
+//	s := mrpc.NewServer(mrpc.WithFallbackHandler(func(ctx context.Context, method string, args mrpc.RawMessage, reply *mrpc.RawMessage) error {
+//		resp, err := legacy.Invoke(ctx, method, args)
+//		*reply = resp
+//		return err
+//	}))
+//
+// args在fn返回后会被复用，需要保留时先拷贝。这些调用在MethodStats中合并记为"*"，
+// 统计钩子、日志等仍使用实际的方法名
+func WithFallbackHandler(fn func(ctx context.Context, method string, args RawMessage, reply *RawMessage) error) ServerOption {
+	return func(s *Server) {
+		mt := &methodType{
+			name:        "*",
+			ArgType:     reflect.TypeFor[RawMessage](),
+			ReplyType:   reflect.TypeFor[*RawMessage](),
+			withContext: true,
+			handler: func(ctx context.Context, arg, reply any) error {
+				method, _ := ctx.Value(fallbackKey{}).(string)
+				return fn(ctx, method, *arg.(*RawMessage), reply.(*RawMessage))
+			},
+			vars:     newMethodVars("*"),
+			fallback: true,
+		}
+		mt.initPools()
+		s.fallback = &dynamicMethod{svc: &service{name: "*"}, mt: mt}
+	}
+}
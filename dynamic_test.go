@@ -0,0 +1,87 @@
+package mrpc
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDynamicMethods(t *testing.T) {
+	lookups := 0
+	s := NewServer(WithDynamicMethods(func(name string) (*DynamicMethod, error) {
+		lookups++
+		if name != "Echo.Upper" {
+			return nil, errors.New("no such method " + name)
+		}
+		return &DynamicMethod{
+			ArgType:   reflect.TypeFor[string](),
+			ReplyType: reflect.TypeFor[*string](),
+			Handler: func(_ context.Context, arg, reply any) error {
+				*reply.(*string) = strings.ToUpper(*arg.(*string))
+				return nil
+			},
+		}, nil
+	}))
+	s.Register(new(Calc))
+	client, err := ConnectInProcess(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	var reply string
+	for i := 0; i < 3; i++ {
+		err = client.Call("Echo.Upper", "hi", &reply)
+		assert(t, err == nil && reply == "HI", "reply=%q err=%v", reply, err)
+	}
+	var sum int
+	err = client.Call("Calc.Sum", Pair{1, 2}, &sum)
+	assert(t, err == nil && sum == 3 && lookups == 1, "registered methods come first, lookups=%d err=%v", lookups, err)
+	err = client.Call("Echo.Lower", "hi", &reply)
+	assert(t, err != nil && err.Error() == "no such method Echo.Lower", "unexpected error %v", err)
+	assert(t, len(s.MethodStats()) == 2, "want Calc.Sum and Echo.Upper, got %+v", s.MethodStats())
+}
+
+func TestFallbackHandler(t *testing.T) {
+	var methods []string
+	s := NewServer(WithFallbackHandler(func(ctx context.Context, method string, args RawMessage, reply *RawMessage) error {
+		methods = append(methods, method)
+		if method == "Legacy.Fail" {
+			return Errorf(NotFound, "gone")
+		}
+		*reply = append(RawMessage(method+":"), args...)
+		return nil
+	}))
+	s.Register(new(Calc))
+	client, err := ConnectInProcess(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	var reply RawMessage
+	err = client.Call("Legacy.Echo", RawMessage("hi"), &reply)
+	assert(t, err == nil && string(reply) == "Legacy.Echo:hi", "reply=%q err=%v", reply, err)
+	err = client.Call("Calc.Other", []byte("x"), &reply)
+	assert(t, err == nil && string(reply) == "Calc.Other:x", "unknown method of a registered service: reply=%q err=%v", reply, err)
+	err = client.Call("Legacy.Fail", RawMessage(nil), &reply)
+	assert(t, CodeOf(err) == NotFound, "want NotFound, got %v", err)
+	var sum int
+	err = client.Call("Calc.Sum", Pair{1, 2}, &sum)
+	assert(t, err == nil && sum == 3 && len(methods) == 3, "registered methods come first, methods=%v err=%v", methods, err)
+	// 按类型编码的参数读不出来
+	err = client.Call("Legacy.Echo", "typed", &reply)
+	assert(t, err != nil && len(methods) == 3, "typed args: %v", err)
+	err = client.Call("Calc.Sum", Pair{2, 3}, &sum)
+	assert(t, err == nil && sum == 5, "connection still usable: %v", err)
+
+	var fallback *MethodStats
+	for _, st := range s.MethodStats() {
+		if st.Name == "*" {
+			fallback = &st
+		}
+	}
+	assert(t, fallback != nil && fallback.Calls == 3, "fallback stats: %+v", fallback)
+}
@@ -0,0 +1,276 @@
+package mrpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"sync"
+)
+
+// 带错误码和结构化详情的错误。方法返回*Error时，错误码和详情随响应传给客户端，
+// 客户端得到的也是*Error，调用方可以按错误码和详情处理，而不是解析错误信息：
+//
+//	return mrpc.Errorf(mrpc.InvalidArgument, "bad quantity %d", n).
+//		WithDetails(FieldViolation{Field: "quantity", Reason: "must be positive"})
+//
+//	var e *mrpc.Error
+//	if errors.As(err, &e) && e.Code == mrpc.InvalidArgument {
+//		for _, d := range e.Details {
+//			if v, ok := d.(FieldViolation); ok { ... }
+//		}
+//	}
+//
+// 详情用连接的codec编码，客户端要先用RegisterErrorDetail注册详情的类型才能解码，
+// 没有注册的详情被丢弃
+type Error struct {
+	Code    Code
+	Message string
+	Details []any
+}
+
+// 错误码，取值与gRPC一致
+type Code uint32
+
+const (
+	OK Code = iota
+	Canceled
+	Unknown
+	InvalidArgument
+	DeadlineExceeded
+	NotFound
+	AlreadyExists
+	PermissionDenied
+	ResourceExhausted
+	FailedPrecondition
+	Aborted
+	OutOfRange
+	Unimplemented
+	Internal
+	Unavailable
+	DataLoss
+	Unauthenticated
+)
+
+var codeNames = [...]string{
+	"OK", "Canceled", "Unknown", "InvalidArgument", "DeadlineExceeded", "NotFound",
+	"AlreadyExists", "PermissionDenied", "ResourceExhausted", "FailedPrecondition",
+	"Aborted", "OutOfRange", "Unimplemented", "Internal", "Unavailable", "DataLoss",
+	"Unauthenticated",
+}
+
+func (c Code) String() string {
+	if int(c) < len(codeNames) {
+		return codeNames[c]
+	}
+	return "Code(" + strconv.FormatUint(uint64(c), 10) + ")"
+}
+
+func Errorf(code Code, format string, args ...any) *Error {
+	return &Error{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// 客户端收到的*Error同样是服务器返回的错误，errors.As(err, &ServerError)仍然成立
+func (e *Error) Unwrap() error {
+	return ServerError(e.Message)
+}
+
+// 错误码为DeadlineExceeded、Canceled时分别等同于context.DeadlineExceeded、context.Canceled，
+// 超时处理不必区分超时发生在客户端还是服务端
+func (e *Error) Is(target error) bool {
+	switch e.Code {
+	case DeadlineExceeded:
+		return target == context.DeadlineExceeded
+	case Canceled:
+		return target == context.Canceled
+	}
+	return false
+}
+
+// 返回附加了详情的副本
+func (e *Error) WithDetails(details ...any) *Error {
+	cp := *e
+	cp.Details = append(e.Details[:len(e.Details):len(e.Details)], details...)
+	return &cp
+}
+
+// 错误的错误码：nil是OK，ctx的错误是DeadlineExceeded或Canceled，
+// 其它不是*Error的错误是Unknown
+func CodeOf(err error) Code {
+	if err == nil {
+		return OK
+	}
+	var e *Error
+	switch {
+	case errors.As(err, &e):
+		return e.Code
+	case errors.Is(err, context.DeadlineExceeded):
+		return DeadlineExceeded
+	case errors.Is(err, context.Canceled):
+		return Canceled
+	}
+	return Unknown
+}
+
+// 已注册的详情类型，名称 -> 类型
+var errorDetails sync.Map
+
+// 注册错误详情的类型，客户端据此解码服务器返回的详情。v是该类型的一个值，
+// 传指针时解码出的详情也是指针。服务端发送详情不需要注册
+func RegisterErrorDetail(v any) {
+	t := reflect.TypeOf(v)
+	errorDetails.Store(detailName(t), t)
+}
+
+// 详情类型在连接上的名称，指针与它指向的类型相同
+func detailName(t reflect.Type) string {
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t.PkgPath() == "" {
+		return t.String()
+	}
+	return t.PkgPath() + "." + t.Name()
+}
+
+func elemType(t reflect.Type) reflect.Type {
+	if t.Kind() == reflect.Pointer {
+		return t.Elem()
+	}
+	return t
+}
+
+// 把详情编码成一个结构体，字段D0、D1...依次是各个详情，codec能直接编码它
+func encodeDetails(details []any) (names []string, body any) {
+	var fields []reflect.StructField
+	var values []reflect.Value
+	for _, d := range details {
+		v := reflect.ValueOf(d)
+		if !v.IsValid() || v.Kind() == reflect.Pointer && v.IsNil() {
+			continue
+		}
+		v = reflect.Indirect(v)
+		names = append(names, detailName(v.Type()))
+		fields = append(fields, reflect.StructField{Name: "D" + strconv.Itoa(len(fields)), Type: v.Type()})
+		values = append(values, v)
+	}
+	if len(fields) == 0 {
+		return nil, nil
+	}
+	sv := reflect.New(reflect.StructOf(fields)).Elem()
+	for i, v := range values {
+		sv.Field(i).Set(v)
+	}
+	return names, sv.Interface()
+}
+
+// 按名称构造同样形状的结构体解码详情，没有注册的类型不放进结构体，解码时被跳过。
+// 返回的decode在ReadBody之后取出详情
+func decodeDetails(names []string) (body any, decode func() []any) {
+	var fields []reflect.StructField
+	var types []reflect.Type
+	for i, name := range names {
+		t, ok := errorDetails.Load(name)
+		if !ok {
+			continue
+		}
+		types = append(types, t.(reflect.Type))
+		fields = append(fields, reflect.StructField{Name: "D" + strconv.Itoa(i), Type: elemType(t.(reflect.Type))})
+	}
+	if len(fields) == 0 {
+		return nil, func() []any { return nil }
+	}
+	sv := reflect.New(reflect.StructOf(fields))
+	return sv.Interface(), func() []any {
+		details := make([]any, len(types))
+		for i, t := range types {
+			f := sv.Elem().Field(i)
+			if t.Kind() == reflect.Pointer {
+				details[i] = f.Addr().Interface()
+			} else {
+				details[i] = f.Interface()
+			}
+		}
+		return details
+	}
+}
+
+// 集中转换方法返回的错误，不必在每个方法里包装：
+//
+//	mrpc.NewServer(mrpc.WithErrorTranslator(func(ctx context.Context, method string, err error) error {
+//		if errors.Is(err, sql.ErrNoRows) {
+//			return mrpc.Errorf(mrpc.NotFound, "%v", err)
+//		}
+//		return err
+//	}))
+//
+// 只对方法返回的错误调用，已经是*Error的错误不经过它。返回nil时保留原来的错误，
+// 不会把失败的调用变成成功
+func WithErrorTranslator(translate func(ctx context.Context, method string, err error) error) ServerOption {
+	return func(s *Server) {
+		s.translate = translate
+	}
+}
+
+// 按errors.Is把错误对应到错误码的转换，用于WithErrorTranslator，消息保持不变：
+//
+//	mrpc.WithErrorTranslator(mrpc.ErrorCodes(map[error]mrpc.Code{
+//		sql.ErrNoRows:    mrpc.NotFound,
+//		os.ErrPermission: mrpc.PermissionDenied,
+//	}))
+func ErrorCodes(codes map[error]Code) func(ctx context.Context, method string, err error) error {
+	return func(_ context.Context, _ string, err error) error {
+		for target, code := range codes {
+			if errors.Is(err, target) {
+				return &Error{Code: code, Message: err.Error()}
+			}
+		}
+		return err
+	}
+}
+
+func (w *responseWriter) translateError(ctx context.Context, method string, err error) error {
+	if err == nil || w.translate == nil {
+		return err
+	}
+	var e *Error
+	if errors.As(err, &e) {
+		return err
+	}
+	if t := w.translate(ctx, method, err); t != nil {
+		return t
+	}
+	return err
+}
+
+// 客户端把服务器返回的*Error转换成应用自己的错误类型，调用方用errors.As处理，
+// 与WithErrorTranslator相对：
+//
+//	mrpc.WithErrorDecoder(func(e *mrpc.Error) error {
+//		if e.Code == mrpc.NotFound {
+//			return &NotFoundError{Msg: e.Message, Err: e}
+//		}
+//		return nil
+//	})
+//
+// 可以设置多个，按顺序尝试，第一个返回非nil的生效，都返回nil时仍是*Error。
+// 返回的错误最好能Unwrap到e，这样CodeOf和errors.As(err, &*Error)依然可用
+func WithErrorDecoder(decode func(e *Error) error) ClientOption {
+	return func(o *clientOptions) {
+		o.decoders = append(o.decoders, decode)
+	}
+}
+
+func (c *Client) decodeError(e *Error) error {
+	for _, decode := range c.decoders {
+		if err := decode(e); err != nil {
+			return err
+		}
+	}
+	return e
+}
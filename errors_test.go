@@ -0,0 +1,205 @@
+package mrpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/micplus/mrpc/codec"
+)
+
+type FieldViolation struct {
+	Field, Reason string
+}
+
+type RetryInfo struct {
+	DelayMillis int
+}
+
+// 客户端没有注册的详情类型
+type debugInfo struct {
+	Stack string
+}
+
+func TestErrorDetails(t *testing.T) {
+	RegisterErrorDetail(FieldViolation{})
+	RegisterErrorDetail(&RetryInfo{})
+
+	s := NewServer()
+	HandleFunc(s, "Order.Create", func(_ context.Context, n int, _ *int) error {
+		switch n {
+		case 0:
+			return errors.New("plain")
+		case 1:
+			return Errorf(NotFound, "no such item")
+		}
+		return Errorf(InvalidArgument, "bad quantity %d", n).
+			WithDetails(FieldViolation{"quantity", "must be positive"}, debugInfo{"..."}, &RetryInfo{100})
+	})
+	client, err := ConnectInProcess(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	err = client.Call("Order.Create", 0, new(int))
+	var se ServerError
+	assert(t, errors.As(err, &se) && CodeOf(err) == Unknown, "plain error: %#v", err)
+
+	err = client.Call("Order.Create", 1, new(int))
+	var e *Error
+	assert(t, errors.As(err, &e) && e.Code == NotFound && e.Message == "no such item" && len(e.Details) == 0,
+		"coded error: %#v", err)
+	assert(t, errors.As(err, &se), "*Error should still be a ServerError")
+
+	err = client.Call("Order.Create", -1, new(int))
+	if !errors.As(err, &e) || e.Code != InvalidArgument || len(e.Details) != 2 {
+		t.Fatalf("detailed error: %#v", err)
+	}
+	fv, ok := e.Details[0].(FieldViolation)
+	assert(t, ok && fv.Field == "quantity", "detail 0: %#v", e.Details[0])
+	ri, ok := e.Details[1].(*RetryInfo)
+	assert(t, ok && ri.DelayMillis == 100, "detail 1: %#v", e.Details[1])
+
+	// 连接仍然可用
+	err = client.Call("Order.Create", 1, new(int))
+	assert(t, CodeOf(err) == NotFound, "after details: %v", err)
+}
+
+func TestDeadlineExceeded(t *testing.T) {
+	s := NewServer()
+	HandleFunc(s, "Clock.Remaining", func(ctx context.Context, _ int, reply *time.Duration) error {
+		deadline, ok := ctx.Deadline()
+		if !ok {
+			return errors.New("no deadline")
+		}
+		*reply = time.Until(deadline)
+		return nil
+	})
+	HandleFunc(s, "Clock.Sleep", func(_ context.Context, d time.Duration, _ *int) error {
+		time.Sleep(d) // 不理会ctx，结束时期限已过
+		return nil
+	})
+	client, err := ConnectInProcess(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	var remaining time.Duration
+	err = client.CallContext(ctx, "Clock.Remaining", 0, &remaining)
+	assert(t, err == nil && remaining > 0 && remaining <= time.Second, "remaining = %v, %v", remaining, err)
+
+	// 绕过CallContext自己的超时，看服务端返回的错误
+	call := getCall("Clock.Sleep", 20*time.Millisecond, new(int))
+	call.deadline = time.Now().Add(5 * time.Millisecond)
+	client.send(context.Background(), call)
+	<-call.Done
+	err = call.Error
+	assert(t, errors.Is(err, context.DeadlineExceeded) && CodeOf(err) == DeadlineExceeded,
+		"server-side timeout: %#v", err)
+
+	// 客户端先超时，同样满足errors.Is
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Millisecond)
+	defer cancel()
+	err = client.CallContext(ctx, "Clock.Sleep", 20*time.Millisecond, new(int))
+	assert(t, errors.Is(err, context.DeadlineExceeded), "client-side timeout: %v", err)
+}
+
+func TestHeaderTooLarge(t *testing.T) {
+	client, s, err := NewClientServerPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	HandleFunc(s, "Big.Fail", func(_ context.Context, n int, _ *int) error {
+		return errors.New(strings.Repeat("x", n))
+	})
+
+	// 过长的错误被截断后照常返回
+	err = client.Call("Big.Fail", 2*codec.MaxErrorSize, new(int))
+	assert(t, err != nil && len(err.Error()) == codec.MaxErrorSize && strings.HasSuffix(err.Error(), truncated),
+		"want truncated error, got %d bytes", len(err.Error()))
+
+	// 过长的方法名在发送前就失败，连接不受影响
+	err = client.Call(strings.Repeat("A", codec.MaxNameSize)+".Fail", 1, new(int))
+	assert(t, errors.Is(err, codec.ErrHeaderTooLarge), "want ErrHeaderTooLarge, got %v", err)
+	err = client.Call("Big.Fail", 3, new(int))
+	assert(t, err != nil && err.Error() == "xxx", "client should still work, got %v", err)
+}
+
+func TestErrorTranslator(t *testing.T) {
+	errNoRows := errors.New("no rows")
+	var methods []string
+	s := NewServer(WithErrorTranslator(func(ctx context.Context, method string, err error) error {
+		methods = append(methods, method)
+		return ErrorCodes(map[error]Code{errNoRows: NotFound})(ctx, method, err)
+	}))
+	HandleFunc(s, "Store.Get", func(_ context.Context, key string, _ *string) error {
+		switch key {
+		case "missing":
+			return fmt.Errorf("get %s: %w", key, errNoRows)
+		case "denied":
+			return Errorf(PermissionDenied, "denied")
+		case "broken":
+			return errors.New("broken")
+		}
+		return nil
+	})
+	client, err := ConnectInProcess(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	err = client.Call("Store.Get", "missing", new(string))
+	assert(t, CodeOf(err) == NotFound && err.Error() == "get missing: no rows", "missing: %#v", err)
+	err = client.Call("Store.Get", "denied", new(string))
+	assert(t, CodeOf(err) == PermissionDenied, "denied: %#v", err)
+	err = client.Call("Store.Get", "broken", new(string))
+	assert(t, CodeOf(err) == Unknown && err.Error() == "broken", "broken: %#v", err)
+	err = client.Call("Store.Get", "ok", new(string))
+	assert(t, err == nil, "ok: %v", err)
+	// *Error和成功的调用不经过转换
+	assert(t, len(methods) == 2 && methods[0] == "Store.Get", "translator called for %v", methods)
+}
+
+type notFoundError struct {
+	Key string
+	err *Error
+}
+
+func (e *notFoundError) Error() string { return "not found: " + e.Key }
+func (e *notFoundError) Unwrap() error { return e.err }
+
+func TestErrorDecoder(t *testing.T) {
+	RegisterErrorDetail(FieldViolation{})
+	s := NewServer()
+	HandleFunc(s, "Store.Get", func(_ context.Context, key string, _ *string) error {
+		if key == "bad" {
+			return Errorf(InvalidArgument, "bad key").WithDetails(FieldViolation{Field: "key"})
+		}
+		return Errorf(NotFound, "missing").WithDetails(FieldViolation{Field: key})
+	})
+	client := pipeClient(t, s,
+		WithErrorDecoder(func(e *Error) error { return nil }),
+		WithErrorDecoder(func(e *Error) error {
+			if e.Code != NotFound {
+				return nil
+			}
+			return &notFoundError{Key: e.Details[0].(FieldViolation).Field, err: e}
+		}))
+
+	err := client.Call("Store.Get", "apple", new(string))
+	var nf *notFoundError
+	assert(t, errors.As(err, &nf) && nf.Key == "apple", "decoded: %#v", err)
+	assert(t, CodeOf(err) == NotFound, "code of decoded error: %v", CodeOf(err))
+	err = client.Call("Store.Get", "bad", new(string))
+	var e *Error
+	assert(t, errors.As(err, &e) && e.Code == InvalidArgument && !errors.As(err, &nf), "not decoded: %#v", err)
+}
@@ -0,0 +1,79 @@
+package mrpc
+
+import (
+	"expvar"
+	"sync"
+)
+
+// 核心计数，不需要任何配置就发布在expvar的"mrpc"下，引入net/http/pprof或
+// expvar的程序可以直接从/debug/vars抓取：
+//
+//	"mrpc": {
+//		"server_connections": 1,
+//		"server_requests_total": 10,
+//		"server_errors_total": 0,
+//		"server_bytes_read_total": 420,
+//		"server_bytes_written_total": 380,
+//		"server_method_calls_total": {"Arith.Add": 10},
+//		"server_method_errors_total": {"Arith.Add": 0},
+//		"client_connections": 0, ...
+//	}
+//
+// 同一进程中所有的Server、Client累计在一起，名称与WithMetrics记录的指标一致。
+// 需要按实例区分时用WithMetrics
+var (
+	vars = expvar.NewMap("mrpc")
+
+	serverConns         = newVar("server_connections")
+	serverRequests      = newVar("server_requests_total")
+	serverErrors        = newVar("server_errors_total")
+	serverBytesRead     = newVar("server_bytes_read_total")
+	serverBytesWritten  = newVar("server_bytes_written_total")
+	serverEvictedConns  = newVar("server_evicted_connections_total") // 写超时而关闭的连接
+	serverStuckHandlers = newVar("server_stuck_handlers")            // 见WithWatchdog
+	methodCalls         = newMapVar("server_method_calls_total")
+	methodErrors        = newMapVar("server_method_errors_total")
+
+	clientConns        = newVar("client_connections")
+	clientCalls        = newVar("client_requests_total")
+	clientErrors       = newVar("client_errors_total")
+	clientBytesRead    = newVar("client_bytes_read_total")
+	clientBytesWritten = newVar("client_bytes_written_total")
+	clientDroppedCalls = newVar("client_dropped_calls_total") // Done通道满了而丢弃的结果
+)
+
+func newVar(name string) *expvar.Int {
+	v := new(expvar.Int)
+	vars.Set(name, v)
+	return v
+}
+
+func newMapVar(name string) *expvar.Map {
+	v := new(expvar.Map)
+	vars.Set(name, v)
+	return v
+}
+
+// 一个方法的计数，注册时取得，调用时不再查表
+type methodVars struct {
+	calls, errors *expvar.Int
+}
+
+var methodVarsMu sync.Mutex // protect creating method vars
+
+// 同名的方法(如多个Server注册了同一个服务)共享计数
+func newMethodVars(name string) methodVars {
+	methodVarsMu.Lock()
+	defer methodVarsMu.Unlock()
+	calls, ok := methodCalls.Get(name).(*expvar.Int)
+	if !ok {
+		calls = new(expvar.Int)
+		methodCalls.Set(name, calls)
+	}
+	errs, ok := methodErrors.Get(name).(*expvar.Int)
+	if !ok {
+		errs = new(expvar.Int)
+		methodErrors.Set(name, errs)
+	}
+	return methodVars{calls: calls, errors: errs}
+}
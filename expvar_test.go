@@ -0,0 +1,40 @@
+package mrpc
+
+import (
+	"context"
+	"expvar"
+	"testing"
+	"time"
+)
+
+func TestExpvar(t *testing.T) {
+	// 计数是全局的，调用前后各取一次快照，比较增量；方法名只在这里使用
+	s := NewServer()
+	HandleFunc(s, "ExpvarTest.Echo", func(_ context.Context, n int, reply *int) error {
+		*reply = n
+		return nil
+	})
+	calls := func() int64 {
+		v, _ := expvar.Get("mrpc").(*expvar.Map).Get("server_method_calls_total").(*expvar.Map).Get("ExpvarTest.Echo").(*expvar.Int)
+		return v.Value()
+	}
+	before, requests, clientReqs, written := calls(), serverRequests.Value(), clientCalls.Value(), clientBytesWritten.Value()
+
+	client, err := ConnectInProcess(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var n int
+	client.Call("ExpvarTest.Echo", 1, &n)
+	client.Call("ExpvarTest.Missing", 1, &n)
+	client.Close()
+	// 服务端在写完响应之后才计数
+	for i := 0; i < 100 && serverRequests.Value()-requests < 2; i++ {
+		time.Sleep(time.Millisecond)
+	}
+
+	assert(t, calls()-before == 1, "ExpvarTest.Echo calls: %d", calls()-before)
+	assert(t, serverRequests.Value()-requests == 2, "server requests: %d", serverRequests.Value()-requests)
+	assert(t, clientCalls.Value()-clientReqs == 2, "client calls: %d", clientCalls.Value()-clientReqs)
+	assert(t, clientBytesWritten.Value() > written, "client bytes not counted")
+}
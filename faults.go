@@ -0,0 +1,91 @@
+package mrpc
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"time"
+)
+
+// 故障注入：按方法和概率给调用加延迟、返回错误、丢掉消息或断开连接，
+// 用来测试建立在mrpc之上的系统能否扛住这些情况。只应在测试环境中开启
+//
+//	s := mrpc.NewServer(mrpc.WithFaults(
+//		mrpc.Fault{Method: "Order.*", Percent: 10, Delay: 200 * time.Millisecond},
+//		mrpc.Fault{Method: "Order.Pay", Percent: 1, Error: mrpc.Errorf(mrpc.Unavailable, "injected")},
+//	))
+type Fault struct {
+	Method  string  // "Service.Method"，"Service.*"匹配服务的所有方法，为空时匹配所有
+	Percent float64 // 命中的概率，0~100
+	// 命中后先延迟，调用的ctx先结束时不再继续
+	Delay time.Duration
+	// 不为nil时不调用方法(客户端不发送请求)，直接返回它
+	Error error
+	// 服务端照常调用方法但不发送响应；客户端不发送请求。调用方等到超时或连接断开
+	Drop bool
+	// 断开整条连接，连接上所有未完成的调用都会失败
+	Reset bool
+}
+
+// 命中Reset时这次调用的错误
+var errFaultReset = errors.New("rpc: connection reset by fault injection")
+
+func (f *Fault) match(method string) bool {
+	return matchMethod(f.Method, method)
+}
+
+// pattern为"Service.Method"、"Service.*"或空(匹配所有)
+func matchMethod(pattern, method string) bool {
+	switch {
+	case pattern == "" || pattern == method:
+		return true
+	case strings.HasSuffix(pattern, ".*"):
+		return strings.HasPrefix(method, pattern[:len(pattern)-1])
+	}
+	return false
+}
+
+// 延迟，然后断开连接或返回注入的错误
+func (f *Fault) inject(ctx context.Context, clock Clock, conn io.Closer) error {
+	if f.Delay > 0 {
+		t := clock.NewTimer(f.Delay)
+		defer t.Stop()
+		select {
+		case <-t.C():
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	if f.Reset {
+		conn.Close()
+		return errFaultReset
+	}
+	return f.Error
+}
+
+type faults []Fault
+
+// 按顺序逐条掷骰子，返回第一条命中的，没有时为nil
+func (fs faults) pick(method string) *Fault {
+	for i := range fs {
+		if f := &fs[i]; f.match(method) && sample(f.Percent/100) {
+			return f
+		}
+	}
+	return nil
+}
+
+// 在服务端处理请求时注入故障，规则见Fault
+func WithFaults(fs ...Fault) ServerOption {
+	return func(s *Server) {
+		s.faults = append(s.faults, fs...)
+	}
+}
+
+// 在客户端发送请求时注入故障，规则见Fault
+func WithClientFaults(fs ...Fault) ClientOption {
+	return func(o *clientOptions) {
+		o.faults = append(o.faults, fs...)
+	}
+}
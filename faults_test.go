@@ -0,0 +1,55 @@
+package mrpc
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFaults(t *testing.T) {
+	s := NewServer(WithFaults(
+		Fault{Method: "Chaos.Slow", Percent: 100, Delay: 30 * time.Millisecond},
+		Fault{Method: "Chaos.Fail", Percent: 100, Error: Errorf(Unavailable, "injected")},
+		Fault{Method: "Chaos.Drop", Percent: 100, Drop: true},
+		Fault{Method: "Chaos.Reset", Percent: 100, Reset: true},
+		Fault{Method: "Chaos.Never", Percent: 0, Error: errors.New("never")},
+	))
+	var calls atomic.Int32
+	for _, name := range []string{"Chaos.Slow", "Chaos.Fail", "Chaos.Drop", "Chaos.Reset", "Chaos.Never", "Chaos.Echo"} {
+		HandleFunc(s, name, func(_ context.Context, n int, reply *int) error {
+			calls.Add(1)
+			*reply = n
+			return nil
+		})
+	}
+	client, err := ConnectInProcess(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	var reply int
+	start := time.Now()
+	err = client.Call("Chaos.Slow", 1, &reply)
+	assert(t, err == nil && time.Since(start) >= 30*time.Millisecond, "delay not injected: %v %v", time.Since(start), err)
+	err = client.Call("Chaos.Fail", 1, &reply)
+	assert(t, CodeOf(err) == Unavailable && calls.Load() == 1, "want injected error without calling, got %v calls=%d", err, calls.Load())
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	err = client.CallContext(ctx, "Chaos.Drop", 1, &reply)
+	cancel()
+	assert(t, errors.Is(err, context.DeadlineExceeded), "dropped response should time out, got %v", err)
+	err = client.Call("Chaos.Never", 1, &reply)
+	assert(t, err == nil, "0%% fault fired: %v", err)
+	err = client.Call("Chaos.Reset", 1, &reply)
+	assert(t, err != nil && !client.IsAvailable(), "want connection reset, got %v", err)
+
+	// 客户端
+	c := pipeClient(t, s, WithClientFaults(Fault{Method: "Chaos.*", Percent: 100, Drop: true}))
+	before := calls.Load()
+	ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err = c.CallContext(ctx, "Chaos.Echo", 1, &reply)
+	assert(t, errors.Is(err, context.DeadlineExceeded) && calls.Load() == before, "request should not be sent: %v", err)
+}
@@ -0,0 +1,136 @@
+package mrpc
+
+import (
+	"context"
+	"sync"
+
+	"github.com/micplus/mrpc/codec"
+)
+
+// 基于信用的流量控制：服务端给每条连接一个窗口(可同时处理的请求数)，
+// 握手后以控制帧告知客户端初始窗口，之后每处理完一批请求再发放相应的信用。
+// 客户端信用用完就等待，不会在慢服务端上堆积无限多未处理的请求；
+// 每个信用对应一对请求/响应，服务端为慢客户端缓存的响应也因此有界。
+//
+// 控制帧的Seq为0(正常调用的序号从1开始)，旧版本的客户端会把它当作无主的响应丢弃
+
+// 发放信用的控制帧名称，消息体是uint32的信用数
+const windowFrame = "mrpc.window"
+
+// 客户端的发送信用
+type sendWindow struct {
+	mu      sync.Mutex // protect following
+	enabled bool       // 收到过服务端的窗口，之前不限制
+	credits int
+	closed  bool
+	wake    chan struct{} // 信用增加或关闭时close并换新
+}
+
+func newSendWindow() *sendWindow {
+	return &sendWindow{wake: make(chan struct{})}
+}
+
+// 取一个信用，没有时等待服务端发放
+func (w *sendWindow) acquire(ctx context.Context) error {
+	for {
+		w.mu.Lock()
+		switch {
+		case w.closed:
+			w.mu.Unlock()
+			return ErrShutDown
+		case !w.enabled || w.credits > 0:
+			if w.enabled {
+				w.credits--
+			}
+			w.mu.Unlock()
+			return nil
+		}
+		wake := w.wake
+		w.mu.Unlock()
+
+		select {
+		case <-wake:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (w *sendWindow) grant(n int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.enabled = true
+	w.credits += n
+	close(w.wake)
+	w.wake = make(chan struct{})
+}
+
+// 连接断开，唤醒所有等待者
+func (w *sendWindow) close() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.closed {
+		w.closed = true
+		close(w.wake)
+	}
+}
+
+// 服务端一条连接上的窗口
+type recvWindow struct {
+	slots chan struct{} // 正在处理的请求占用一个位置
+	// 为nil时只限制并发，不发控制帧(jsonrpc等不认识控制帧的codec)
+	w *responseWriter
+
+	mu      sync.Mutex // protect pending
+	pending int        // 已释放还没发给客户端的信用
+}
+
+// size<=0时不限制
+func newRecvWindow(size int, w *responseWriter) *recvWindow {
+	if size <= 0 {
+		return nil
+	}
+	return &recvWindow{slots: make(chan struct{}, size), w: w}
+}
+
+// 读下一个请求之前占一个位置，窗口满时不再从连接读数据
+func (rw *recvWindow) take() {
+	if rw != nil {
+		rw.slots <- struct{}{}
+	}
+}
+
+// 读到的不是请求(反向调用的响应)，让出位置但不发放信用
+func (rw *recvWindow) untake() {
+	if rw != nil {
+		<-rw.slots
+	}
+}
+
+// 握手后告知客户端初始窗口
+func (rw *recvWindow) announce() {
+	if rw != nil && rw.w != nil {
+		rw.w.write(&codec.Header{Name: windowFrame}, uint32(cap(rw.slots)))
+	}
+}
+
+// 响应写出后释放位置，攒够四分之一窗口再一并发放信用，减少控制帧
+func (rw *recvWindow) release() {
+	if rw == nil {
+		return
+	}
+	<-rw.slots
+	if rw.w == nil {
+		return
+	}
+	rw.mu.Lock()
+	rw.pending++
+	n := rw.pending
+	if n < max(1, cap(rw.slots)/4) {
+		rw.mu.Unlock()
+		return
+	}
+	rw.pending = 0
+	rw.mu.Unlock()
+	rw.w.write(&codec.Header{Name: windowFrame}, uint32(n))
+}
@@ -0,0 +1,61 @@
+package mrpc
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// 记录同时在处理的请求数
+type Gauge struct {
+	cur, peak atomic.Int32
+}
+
+func (g *Gauge) Hold(d time.Duration, reply *int) error {
+	n := g.cur.Add(1)
+	defer g.cur.Add(-1)
+	for {
+		p := g.peak.Load()
+		if n <= p || g.peak.CompareAndSwap(p, n) {
+			break
+		}
+	}
+	time.Sleep(d)
+	return nil
+}
+
+func TestFlowWindow(t *testing.T) {
+	const window = 4
+	s := NewServer(WithFlowWindow(window))
+	g := new(Gauge)
+	s.Register(g)
+	client := pipeClient(t, s)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8*window; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := client.Call("Gauge.Hold", 5*time.Millisecond, new(int))
+			assert(t, err == nil, "call error: %v", err)
+		}()
+	}
+	wg.Wait()
+	assert(t, g.peak.Load() <= window, "%d requests in flight, window is %d", g.peak.Load(), window)
+
+	// 信用用完时CallContext按ctx超时返回
+	calls := make([]*Call, window)
+	for i := range calls {
+		calls[i] = client.Go("Gauge.Hold", 200*time.Millisecond, new(int), nil)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err := client.CallContext(ctx, "Gauge.Hold", time.Duration(0), new(int))
+	assert(t, err == context.DeadlineExceeded, "want DeadlineExceeded, got %v", err)
+	for _, call := range calls {
+		<-call.Done
+		assert(t, call.Error == nil, "call error: %v", call.Error)
+	}
+}
@@ -0,0 +1,280 @@
+// gateway 把HTTP请求转换成对后端mrpc服务的调用，内部服务可以直接提供给REST调用方：
+//
+//	POST /Arith/Add
+//	X-Mrpc-User: alice
+//
+//	{"A": 1, "B": 2}
+//
+// 请求体按方法参数类型解码，响应体是JSON编码的reply。
+// 参数类型通过后端的反射服务(Server.RegisterReflection)获取并缓存，
+// 以HeaderPrefix开头的请求头转换成元数据，键为去掉前缀后的小写名称("user")。
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/micplus/mrpc"
+)
+
+// 转发调用的后端，*mrpc.Client实现了它
+type Backend interface {
+	CallContext(ctx context.Context, name string, args, reply any) error
+}
+
+var _ Backend = (*mrpc.Client)(nil)
+
+// 默认的元数据请求头前缀
+const DefaultHeaderPrefix = "X-Mrpc-"
+
+// 请求体的最大长度
+const maxBodySize = 4 << 20
+
+type method struct {
+	argType   reflect.Type
+	replyType reflect.Type // 指针
+}
+
+type Gateway struct {
+	// 转换为元数据的请求头前缀，为空时使用DefaultHeaderPrefix
+	HeaderPrefix string
+
+	mu       sync.RWMutex // protect following
+	fallback Backend
+	backends map[string]Backend // 服务名 -> 后端
+	methods  map[string]*method // "Service.Method" -> 参数类型
+}
+
+var _ http.Handler = (*Gateway)(nil)
+
+// fallback处理没有单独指定后端的服务，可以为nil
+func New(fallback Backend) *Gateway {
+	return &Gateway{
+		fallback: fallback,
+		backends: make(map[string]Backend),
+		methods:  make(map[string]*method),
+	}
+}
+
+// 把某个服务的调用转发给b
+func (g *Gateway) Route(service string, b Backend) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.backends[service] = b
+	for name := range g.methods { // 换了后端，类型需要重新获取
+		if strings.HasPrefix(name, service+".") {
+			delete(g.methods, name)
+		}
+	}
+}
+
+func (g *Gateway) backend(service string) Backend {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	if b, ok := g.backends[service]; ok {
+		return b
+	}
+	return g.fallback
+}
+
+// 网关产生的错误，带上要返回的HTTP状态码
+type httpError struct {
+	code int
+	msg  string
+}
+
+func (e *httpError) Error() string { return e.msg }
+
+func errorf(code int, format string, a ...any) error {
+	return &httpError{code: code, msg: fmt.Sprintf(format, a...)}
+}
+
+// 查询并缓存方法的参数类型
+func (g *Gateway) lookup(ctx context.Context, b Backend, service, name string) (*method, error) {
+	full := service + "." + name
+	g.mu.RLock()
+	m, ok := g.methods[full]
+	g.mu.RUnlock()
+	if ok {
+		return m, nil
+	}
+
+	var info mrpc.ServiceInfo
+	if err := b.CallContext(ctx, mrpc.ReflectionServiceName+".Describe", service, &info); err != nil {
+		if errors.As(err, new(mrpc.ServerError)) {
+			return nil, errorf(http.StatusNotFound, "%v", err)
+		}
+		return nil, err
+	}
+	for _, mi := range info.Methods {
+		if mi.Name != name {
+			continue
+		}
+		argType, err := mi.Args.Type()
+		if err != nil {
+			return nil, errorf(http.StatusNotImplemented, "args of %s: %v", full, err)
+		}
+		replyType, err := mi.Reply.Type()
+		if err != nil {
+			return nil, errorf(http.StatusNotImplemented, "reply of %s: %v", full, err)
+		}
+		m = &method{argType: argType, replyType: replyType}
+		g.mu.Lock()
+		g.methods[full] = m
+		g.mu.Unlock()
+		return m, nil
+	}
+	return nil, errorf(http.StatusNotFound, "cannot find method %s", full)
+}
+
+// 请求头中的元数据
+func (g *Gateway) metadata(h http.Header) mrpc.Metadata {
+	prefix := g.HeaderPrefix
+	if prefix == "" {
+		prefix = DefaultHeaderPrefix
+	}
+	prefix = http.CanonicalHeaderKey(prefix)
+	var md mrpc.Metadata
+	for k, v := range h {
+		if !strings.HasPrefix(k, prefix) || len(k) == len(prefix) || len(v) == 0 {
+			continue
+		}
+		if md == nil {
+			md = make(mrpc.Metadata)
+		}
+		md[strings.ToLower(k[len(prefix):])] = v[0]
+	}
+	return md
+}
+
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	reply, err := g.serve(r)
+	if err != nil {
+		code, msg := statusCode(err), err.Error()
+		if code >= http.StatusInternalServerError {
+			log.Printf("rpc gateway: %s %s: %v", r.Method, r.URL.Path, err)
+		}
+		writeJSON(w, code, map[string]string{"error": msg})
+		return
+	}
+	writeJSON(w, http.StatusOK, reply)
+}
+
+func (g *Gateway) serve(r *http.Request) (any, error) {
+	if r.Method != http.MethodPost {
+		return nil, errorf(http.StatusMethodNotAllowed, "method %s not allowed, use POST", r.Method)
+	}
+	service, name, ok := strings.Cut(strings.Trim(r.URL.Path, "/"), "/")
+	if !ok || service == "" || name == "" || strings.Contains(name, "/") {
+		return nil, errorf(http.StatusNotFound, "path must be /Service/Method")
+	}
+	b := g.backend(service)
+	if b == nil {
+		return nil, errorf(http.StatusNotFound, "no backend for service %s", service)
+	}
+	ctx := r.Context()
+	m, err := g.lookup(ctx, b, service, name)
+	if err != nil {
+		return nil, err
+	}
+
+	argv := reflect.New(m.argType)
+	if m.argType.Kind() == reflect.Pointer {
+		argv.Elem().Set(reflect.New(m.argType.Elem()))
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxBodySize+1))
+	if err != nil {
+		return nil, errorf(http.StatusBadRequest, "read body: %v", err)
+	}
+	if len(body) > maxBodySize {
+		return nil, errorf(http.StatusRequestEntityTooLarge, "request body too large")
+	}
+	if len(strings.TrimSpace(string(body))) > 0 {
+		if err := json.Unmarshal(body, argv.Interface()); err != nil {
+			return nil, errorf(http.StatusBadRequest, "decode args: %v", err)
+		}
+	}
+
+	if md := g.metadata(r.Header); md != nil {
+		ctx = mrpc.WithOutgoingMetadata(ctx, md)
+	}
+	replyv := reflect.New(m.replyType.Elem())
+	if err := b.CallContext(ctx, service+"."+name, argv.Elem().Interface(), replyv.Interface()); err != nil {
+		return nil, err
+	}
+	return replyv.Elem().Interface(), nil
+}
+
+// 错误到HTTP状态码的映射
+func statusCode(err error) int {
+	var he *httpError
+	var me *mrpc.Error
+	var se mrpc.ServerError
+	switch {
+	case errors.As(err, &he):
+		return he.code
+	case errors.As(err, &me):
+		return codeStatus(me.Code)
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout
+	case errors.Is(err, context.Canceled):
+		return 499 // 调用方已经断开，约定俗成的状态码
+	case errors.As(err, &se):
+		msg := string(se)
+		switch {
+		case strings.HasPrefix(msg, "rpc server: cannot find"):
+			return http.StatusNotFound
+		case strings.HasPrefix(msg, "rpc server: read request body error"):
+			return http.StatusBadRequest
+		}
+		return http.StatusInternalServerError
+	}
+	// 连接断开、编解码失败等，后端不可用
+	return http.StatusBadGateway
+}
+
+// 服务器返回的错误码对应的HTTP状态码
+func codeStatus(code mrpc.Code) int {
+	switch code {
+	case mrpc.InvalidArgument, mrpc.OutOfRange, mrpc.FailedPrecondition:
+		return http.StatusBadRequest
+	case mrpc.Unauthenticated:
+		return http.StatusUnauthorized
+	case mrpc.PermissionDenied:
+		return http.StatusForbidden
+	case mrpc.NotFound:
+		return http.StatusNotFound
+	case mrpc.AlreadyExists, mrpc.Aborted:
+		return http.StatusConflict
+	case mrpc.ResourceExhausted:
+		return http.StatusTooManyRequests
+	case mrpc.Canceled:
+		return 499
+	case mrpc.Unimplemented:
+		return http.StatusNotImplemented
+	case mrpc.Unavailable:
+		return http.StatusServiceUnavailable
+	case mrpc.DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	}
+	return http.StatusInternalServerError
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		code = http.StatusInternalServerError
+		b, _ = json.Marshal(map[string]string{"error": "encode reply: " + err.Error()})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(append(b, '\n'))
+}
@@ -0,0 +1,103 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/micplus/mrpc"
+)
+
+type Args struct {
+	A, B int
+}
+
+type Arith int
+
+func (*Arith) Add(args *Args, reply *int) error {
+	*reply = args.A + args.B
+	return nil
+}
+
+func (*Arith) Div(args Args, reply *int) error {
+	if args.B == 0 {
+		return errors.New("divide by zero")
+	}
+	*reply = args.A / args.B
+	return nil
+}
+
+type Who int
+
+func (*Who) Am(ctx context.Context, _ int, reply *map[string]string) error {
+	*reply = mrpc.IncomingMetadata(ctx)
+	return nil
+}
+
+func newBackend(t *testing.T, rcvrs ...any) *mrpc.Client {
+	client, s, err := mrpc.NewClientServerPair(rcvrs...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.RegisterReflection()
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func TestGateway(t *testing.T) {
+	g := New(newBackend(t, new(Arith)))
+	g.Route("Who", newBackend(t, new(Who)))
+	ts := httptest.NewServer(g)
+	defer ts.Close()
+
+	for _, tt := range []struct {
+		method, path, body string
+		header             map[string]string
+		code               int
+		want               string
+	}{
+		{"POST", "/Arith/Add", `{"A":1,"B":2}`, nil, 200, "3"},
+		{"POST", "/Arith/Div", `{"A":7,"B":2}`, nil, 200, "3"},
+		{"POST", "/Arith/Div", `{"A":7,"B":0}`, nil, 500, `{"error":"divide by zero"}`},
+		{"POST", "/Arith/Add", `{"A":"x"}`, nil, 400, "decode args"},
+		{"POST", "/Arith/Missing", `{}`, nil, 404, "cannot find method Arith.Missing"},
+		{"POST", "/Missing/Add", `{}`, nil, 404, "cannot find service Missing"},
+		{"POST", "/Arith", `{}`, nil, 404, "path must be"},
+		{"GET", "/Arith/Add", ``, nil, 405, "not allowed"},
+		{"POST", "/Who/Am", ``, map[string]string{"X-Mrpc-User": "alice", "X-Other": "x"}, 200, `{"user":"alice"}`},
+	} {
+		req, _ := http.NewRequest(tt.method, ts.URL+tt.path, strings.NewReader(tt.body))
+		for k, v := range tt.header {
+			req.Header.Set(k, v)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != tt.code || !strings.Contains(string(body), tt.want) {
+			t.Errorf("%s %s: got %d %s, want %d %s", tt.method, tt.path, resp.StatusCode, body, tt.code, tt.want)
+		}
+	}
+}
+
+func TestStatusCode(t *testing.T) {
+	for _, tt := range []struct {
+		err  error
+		code int
+	}{
+		{context.DeadlineExceeded, http.StatusGatewayTimeout},
+		{mrpc.ErrShutDown, http.StatusBadGateway},
+		{mrpc.ServerError("rpc server: read request body error: EOF"), http.StatusBadRequest},
+		{mrpc.Errorf(mrpc.PermissionDenied, "denied"), http.StatusForbidden},
+	} {
+		if code := statusCode(tt.err); code != tt.code {
+			t.Errorf("statusCode(%v) = %d, want %d", tt.err, code, tt.code)
+		}
+	}
+}
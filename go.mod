@@ -1,3 +1,8 @@
 module github.com/micplus/mrpc
 
-go 1.19
+go 1.23.0
+
+require (
+	golang.org/x/net v0.38.0
+	google.golang.org/protobuf v1.36.12
+)
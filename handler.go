@@ -0,0 +1,128 @@
+package mrpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go/token"
+	"reflect"
+	"strings"
+)
+
+// 预编译的方法：参数和返回值都以指针传入，直接调用类型确定的函数，
+// 不经过reflect.Value.Call，也不需要为参数列表分配[]reflect.Value。
+// arg指向参数(参数本身是指针时就是它)，reply就是返回值指针
+type handlerFunc func(ctx context.Context, arg, reply any) error
+
+func newHandler[A, R any](fn func(context.Context, A, *R) error) handlerFunc {
+	if reflect.TypeFor[A]().Kind() == reflect.Pointer {
+		return func(ctx context.Context, arg, reply any) error {
+			return fn(ctx, arg.(A), reply.(*R))
+		}
+	}
+	return func(ctx context.Context, arg, reply any) error {
+		return fn(ctx, *arg.(*A), reply.(*R))
+	}
+}
+
+func plainHandler[A, R any](fn func(A, *R) error) handlerFunc {
+	return newHandler(func(_ context.Context, arg A, reply *R) error {
+		return fn(arg, reply)
+	})
+}
+
+// 注册时把常见的内置类型签名转换成类型确定的函数，其余的仍然走反射。
+// fn是绑定了接收者的方法值
+func precompile(fn any) handlerFunc {
+	switch f := fn.(type) {
+	case func(int, *int) error:
+		return plainHandler(f)
+	case func(int64, *int64) error:
+		return plainHandler(f)
+	case func(string, *string) error:
+		return plainHandler(f)
+	case func([]byte, *[]byte) error:
+		return plainHandler(f)
+	case func(context.Context, int, *int) error:
+		return newHandler(f)
+	case func(context.Context, int64, *int64) error:
+		return newHandler(f)
+	case func(context.Context, string, *string) error:
+		return newHandler(f)
+	case func(context.Context, []byte, *[]byte) error:
+		return newHandler(f)
+	}
+	return nil
+}
+
+// 以函数的形式注册方法，name形如"Service.Method"，同一服务的方法可以分多次注册，
+// 带版本的"Service.Method@2"注册为服务的第2个版本，见RegisterVersion。
+// 调用时直接执行fn，不经过反射，mrpcgen生成的注册代码使用它：
+//
+//	mrpc.HandleFunc(s, "Arith.Add", func(ctx context.Context, args *Args, reply *int) error {
+//		*reply = args.A + args.B
+//		return nil
+//	})
+//
+// 参数按值(Args)或指针(*Args)声明都可以，类型由fn推断，不需要接收者结构体
+func HandleFunc[A, R any](s *Server, name string, fn func(context.Context, A, *R) error, opts ...MethodOption) error {
+	dot := strings.LastIndex(name, ".")
+	if dot < 0 {
+		return errors.New("rpc server: method name must be like \"Service.Method\"")
+	}
+	sName, mName := name[:dot], name[dot+1:]
+	if !token.IsIdentifier(sName) || !token.IsExported(sName) {
+		return fmt.Errorf("rpc server: %q is not a valid service name", sName)
+	}
+	mName, version, err := splitVersion(mName)
+	if err != nil {
+		return err
+	}
+	if !token.IsIdentifier(mName) || !token.IsExported(mName) {
+		return fmt.Errorf("rpc server: %q is not a valid method name", mName)
+	}
+	argType, replyType := reflect.TypeFor[A](), reflect.TypeFor[*R]()
+	if !isExportedOrBuiltin(argType) || !isExportedOrBuiltin(replyType) {
+		return fmt.Errorf("rpc server: %s has unexported argument or reply type", name)
+	}
+
+	key := sName
+	if version > 0 { // "Arith.Mul@2"注册到服务"Arith@2"中，见RegisterVersion
+		key = versionedName(sName, version)
+	}
+	svc, ok := s.serviceMap[key]
+	if !ok {
+		svc = &service{name: key, method: make(map[string]*methodType)}
+		s.serviceMap[key] = svc
+		if version > 0 {
+			s.addVersion(sName, version, svc)
+		}
+	}
+	if _, dup := svc.method[mName]; dup {
+		return errors.New("rpc server: duplicated method " + name)
+	}
+	mType := &methodType{
+		name:        name,
+		ArgType:     argType,
+		ReplyType:   replyType,
+		withContext: true,
+		handler:     newHandler(fn),
+		vars:        newMethodVars(name),
+	}
+	mType.initPools()
+	for _, opt := range opts {
+		opt(mType)
+	}
+	svc.method[mName] = mType
+	return nil
+}
+
+// 以类型确定的函数注册方法，参数和返回值都是指针，类型参数可以显式写出：
+//
+//	mrpc.Handle[Args, int](s, "Arith.Add", add)
+//
+// 与HandleFunc相同，调用时直接执行fn，不经过反射。
+// Go的方法不能有类型参数，所以它是函数而不是Server的方法
+func Handle[TArgs, TReply any](s *Server, name string, fn func(ctx context.Context, args *TArgs, reply *TReply) error, opts ...MethodOption) error {
+	return HandleFunc(s, name, fn, opts...)
+}
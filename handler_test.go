@@ -0,0 +1,77 @@
+package mrpc
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+type Counter int
+
+func (c *Counter) Incr(n int, reply *int) error {
+	*c += Counter(n)
+	*reply = int(*c)
+	return nil
+}
+
+func TestPrecompiled(t *testing.T) {
+	s := newService(new(Counter))
+	mt := s.method["Incr"]
+	assert(t, mt.handler != nil, "func(int, *int) error should be precompiled")
+	assert(t, newService(new(Calc)).method["Sum"].handler == nil, "user types fall back to reflection")
+
+	argv, replyv := mt.getArgv(), mt.getReplyv()
+	argv.SetInt(3)
+	err := s.call(context.Background(), mt, argv, replyv)
+	assert(t, err == nil && *replyv.Interface().(*int) == 3, "Counter.Incr = %d, %v", *replyv.Interface().(*int), err)
+}
+
+func TestHandleFunc(t *testing.T) {
+	s := NewServer()
+	err := HandleFunc(s, "Calc.Sum", func(_ context.Context, args Pair, reply *int) error {
+		*reply = args.A + args.B
+		return nil
+	})
+	assert(t, err == nil, "HandleFunc: %v", err)
+	err = HandleFunc(s, "Calc.Greet", func(ctx context.Context, name *string, reply *string) error {
+		*reply = IncomingMetadata(ctx)["greeting"] + " " + *name
+		return nil
+	})
+	assert(t, err == nil, "HandleFunc: %v", err)
+	err = HandleFunc(s, "Calc.Sum", func(context.Context, Pair, *int) error { return nil })
+	assert(t, err != nil, "duplicated method should fail")
+	err = HandleFunc(s, "calc.Sum", func(context.Context, Pair, *int) error { return nil })
+	assert(t, err != nil, "unexported service name should fail")
+
+	client, err := ConnectInProcess(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	var sum int
+	err = client.Call("Calc.Sum", Pair{1, 2}, &sum)
+	assert(t, err == nil && sum == 3, "Calc.Sum = %d, %v", sum, err)
+	var greeting string
+	name := "mrpc"
+	ctx := WithOutgoingMetadata(context.Background(), Metadata{"greeting": "hello"})
+	err = client.CallContext(ctx, "Calc.Greet", &name, &greeting)
+	assert(t, err == nil && greeting == "hello mrpc", "Calc.Greet = %q, %v", greeting, err)
+}
+
+func TestHandle(t *testing.T) {
+	s := NewServer()
+	err := Handle[Pair, int](s, "Calc.Sum", func(_ context.Context, args *Pair, reply *int) error {
+		*reply = args.A + args.B
+		return nil
+	})
+	assert(t, err == nil, "Handle: %v", err)
+	mt := s.serviceMap["Calc"].method["Sum"]
+	assert(t, mt.handler != nil && mt.ArgType == reflect.TypeFor[*Pair](), "Handle should register a precompiled handler for *Pair")
+	err = Handle(s, "Calc.Sum", func(context.Context, *Pair, *int) error { return nil })
+	assert(t, err != nil, "duplicated method should fail")
+
+	client := pipeClient(t, s)
+	var sum int
+	err = client.Call("Calc.Sum", Pair{1, 2}, &sum)
+	assert(t, err == nil && sum == 3, "Calc.Sum = %d, %v", sum, err)
+}
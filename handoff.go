@@ -0,0 +1,242 @@
+package mrpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// 不停机升级：旧进程把监听套接字作为继承的fd交给新版本的进程，新进程就绪后旧进程用Shutdown排空。
+// 整个过程中套接字一直打开，新连接在内核中排队，由两个进程之一接受，不会被拒绝；
+// 旧连接上的客户端收到GOAWAY，处理完在途的请求后重新连接到新进程(见ReconnectingClient)：
+//
+//	lis, err := mrpc.ListenInherited("tcp", ":1234") // 是升级出来的进程时使用旧进程的套接字
+//	go s.Accept(lis)
+//	mrpc.UpgradeReady() // 告诉旧进程可以退出了
+//
+//	for range sighup {
+//		if _, err := mrpc.Upgrade(ctx); err != nil {
+//			log.Println("upgrade failed:", err) // 新进程没有就绪，旧进程照常服务
+//			continue
+//		}
+//		s.Shutdown(ctx)
+//		return
+//	}
+//
+// 新进程从fd 3开始依次收到就绪管道和各个套接字，由下面的环境变量描述，读完后清除
+const (
+	upgradePPIDEnv  = "MRPC_UPGRADE_PPID"
+	upgradeNamesEnv = "MRPC_UPGRADE_NAMES" // 各套接字的名称，逗号分隔
+)
+
+// 新进程没有在ctx结束前就绪，已经被终止
+var ErrUpgradeNotReady = errors.New("rpc server: upgraded process did not become ready")
+
+// 用Upgrade交出的套接字，键为network@address
+var handoff = struct {
+	sync.Mutex
+	listeners map[string]net.Listener
+	upgrading bool
+}{listeners: make(map[string]net.Listener)}
+
+type inheritance struct {
+	listeners map[string]net.Listener
+	ready     *os.File // 为nil时不是由Upgrade启动的
+}
+
+var inherited = sync.OnceValues(func() (*inheritance, error) {
+	in, err := inheritedListeners(os.Getenv, listenFDsStart)
+	os.Unsetenv(upgradePPIDEnv)
+	os.Unsetenv(upgradeNamesEnv)
+	return in, err
+})
+
+// 是由Upgrade启动的进程时使用旧进程交出的同名套接字，否则自己监听。
+// 返回的listener在之后的Upgrade中交给下一个进程
+func ListenInherited(network, address string) (net.Listener, error) {
+	in, err := inherited()
+	if err != nil {
+		return nil, err
+	}
+	name := network + "@" + address
+	handoff.Lock()
+	defer handoff.Unlock()
+	lis, ok := in.listeners[name]
+	delete(in.listeners, name)
+	if !ok {
+		if lis, err = net.Listen(network, address); err != nil {
+			return nil, err
+		}
+	}
+	// 旧进程关闭listener时不能删掉新进程还在用的socket文件
+	if ul, ok := lis.(*net.UnixListener); ok {
+		ul.SetUnlinkOnClose(false)
+	}
+	handoff.listeners[name] = lis
+	return lis, nil
+}
+
+// 是由Upgrade启动的进程时通知旧进程已经就绪，应当在ListenInherited取得了所有的套接字之后调用。
+// 旧进程交出了但没有被取用的套接字在这时关闭。不是由Upgrade启动时什么都不做
+func UpgradeReady() error {
+	in, err := inherited()
+	if err != nil {
+		return err
+	}
+	handoff.Lock()
+	defer handoff.Unlock()
+	for name, lis := range in.listeners {
+		lis.Close()
+		delete(in.listeners, name)
+	}
+	if in.ready == nil {
+		return nil
+	}
+	_, err = in.ready.Write([]byte{1})
+	in.ready.Close()
+	in.ready = nil
+	return err
+}
+
+// 以args(为空时同当前进程)启动当前可执行文件的新进程，把ListenInherited返回的套接字交给它，
+// 等它调用UpgradeReady。成功后返回新进程，调用方应当Shutdown排空自己的连接后退出；
+// 新进程在ctx结束前没有就绪或者先退出时终止它，返回错误，当前进程照常服务
+func Upgrade(ctx context.Context, args ...string) (*os.Process, error) {
+	handoff.Lock()
+	if handoff.upgrading {
+		handoff.Unlock()
+		return nil, errors.New("rpc server: upgrade already in progress")
+	}
+	files, names, err := listenerFiles()
+	handoff.upgrading = err == nil
+	handoff.Unlock()
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		handoff.Lock()
+		handoff.upgrading = false
+		handoff.Unlock()
+	}()
+	return startUpgrade(ctx, files, names, args)
+}
+
+// 复制所有套接字的fd，listener已经关闭的跳过
+func listenerFiles() (files []*os.File, names []string, err error) {
+	for name, lis := range handoff.listeners {
+		filer, ok := lis.(interface{ File() (*os.File, error) })
+		if !ok {
+			err = fmt.Errorf("rpc server: listener %s cannot be handed off", name)
+			break
+		}
+		f, ferr := filer.File()
+		if errors.Is(ferr, net.ErrClosed) {
+			delete(handoff.listeners, name)
+			continue
+		}
+		if ferr != nil {
+			err = fmt.Errorf("rpc server: listener %s: %w", name, ferr)
+			break
+		}
+		files = append(files, f)
+		names = append(names, url.QueryEscape(name))
+	}
+	return files, names, err
+}
+
+func startUpgrade(ctx context.Context, files []*os.File, names []string, args []string) (*os.Process, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	if len(args) == 0 {
+		args = os.Args[1:]
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	cmd := exec.Command(exe, args...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.ExtraFiles = append([]*os.File{w}, files...)
+	cmd.Env = append(os.Environ(),
+		upgradePPIDEnv+"="+strconv.Itoa(os.Getpid()),
+		upgradeNamesEnv+"="+strings.Join(names, ","))
+	err = cmd.Start()
+	w.Close() // 只留新进程的写端，它退出时读到EOF
+	// 启动时os把传出的fd设成了阻塞模式，它与listener共享文件状态，Accept会阻塞在系统调用里，
+	// Close也要等它返回。FileListener会把复制的fd设回非阻塞
+	for _, f := range files {
+		if l, err := net.FileListener(f); err == nil {
+			l.Close()
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	ready := make(chan error, 1)
+	go func() {
+		var b [1]byte
+		_, err := r.Read(b[:])
+		ready <- err
+	}()
+	select {
+	case err = <-ready:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	if err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return nil, fmt.Errorf("%w: %v", ErrUpgradeNotReady, err)
+	}
+	// 新进程独立运行，不再等待它
+	go cmd.Wait()
+	return cmd.Process, nil
+}
+
+// 读Upgrade传来的就绪管道和套接字，不是由Upgrade启动时listeners为空
+func inheritedListeners(getenv func(string) string, start int) (*inheritance, error) {
+	in := &inheritance{listeners: make(map[string]net.Listener)}
+	ppid, err := strconv.Atoi(getenv(upgradePPIDEnv))
+	if err != nil || ppid != os.Getppid() { // 不是传给这个进程的
+		return in, nil
+	}
+	in.ready = os.NewFile(uintptr(start), "upgrade-ready")
+	var names []string
+	if s := getenv(upgradeNamesEnv); s != "" {
+		names = strings.Split(s, ",")
+	}
+	for i, escaped := range names {
+		fd := start + 1 + i
+		name, err := url.QueryUnescape(escaped)
+		if err != nil {
+			name = escaped
+		}
+		f := os.NewFile(uintptr(fd), name)
+		l, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			for _, l := range in.listeners {
+				l.Close()
+			}
+			return nil, fmt.Errorf("rpc server: inherited fd %d is not a listener: %w", fd, err)
+		}
+		in.listeners[name] = l
+	}
+	return in, nil
+}
@@ -0,0 +1,98 @@
+package mrpc
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+)
+
+// 标记处理调用的进程
+type Generation string
+
+func (g *Generation) Name(_ int, reply *string) error {
+	*reply = string(*g)
+	return nil
+}
+
+const upgradeTestEnv = "MRPC_TEST_UPGRADE"
+
+// Upgrade启动的新进程运行这个测试，接过套接字后就绪，服务一段时间后退出
+func TestUpgradeChild(t *testing.T) {
+	mode := os.Getenv(upgradeTestEnv)
+	if mode == "" {
+		t.Skip("only runs in a process started by TestUpgrade")
+	}
+	if mode == "fail" {
+		os.Exit(1) // 没有就绪就退出
+	}
+	lis, err := ListenInherited("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	gen := Generation("new")
+	s := NewServer()
+	s.Register(&gen)
+	go s.Accept(lis)
+	if err := UpgradeReady(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Second)
+}
+
+func TestUpgrade(t *testing.T) {
+	lis, err := ListenInherited("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := lis.Addr().String()
+	gen := Generation("old")
+	s := NewServer()
+	s.Register(&gen)
+	go s.Accept(lis)
+	assert(t, UpgradeReady() == nil, "UpgradeReady should do nothing in a process not started by Upgrade")
+
+	name := func() string {
+		client, err := Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+		var reply string
+		if err := client.Call("Generation.Name", 0, &reply); err != nil {
+			t.Fatal(err)
+		}
+		return reply
+	}
+	assert(t, name() == "old", "calls before the upgrade go to the old process")
+	client, err := Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	args := []string{"-test.run=^TestUpgradeChild$"}
+	// 新进程没有就绪时旧进程照常服务
+	t.Setenv(upgradeTestEnv, "fail")
+	_, err = Upgrade(ctx, args...)
+	assert(t, errors.Is(err, ErrUpgradeNotReady), "want ErrUpgradeNotReady, got %v", err)
+	assert(t, name() == "old", "the old process keeps serving after a failed upgrade")
+
+	t.Setenv(upgradeTestEnv, "serve")
+	proc, err := Upgrade(ctx, args...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proc.Kill()
+	// 旧进程排空，已有的连接收到GOAWAY，新连接都由新进程接受
+	done := make(chan error, 1)
+	go func() { done <- s.Shutdown(ctx) }()
+	assert(t, client.WaitForStateChange(ctx, Ready), "old connection should be draining")
+	assert(t, <-done == nil, "shutdown did not finish")
+	for i := 0; i < 3; i++ {
+		assert(t, name() == "new", "calls after the upgrade go to the new process")
+	}
+}
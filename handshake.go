@@ -0,0 +1,88 @@
+package mrpc
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"slices"
+	"strings"
+
+	"github.com/micplus/mrpc/codec"
+)
+
+// 握手被拒绝：服务端读到错误的Magic或不支持的编码类型时，先写一个拒绝帧再关闭连接，
+// 客户端据此报告协议不匹配，而不是卡住或者只得到EOF。拒绝帧：
+//
+//	RejectMagic | 原因(1字节) | 类型数n(1字节) | n个服务端支持的编码类型(各4字节)
+//
+// 正常的连接上服务端从不以RejectMagic开头写数据
+
+// 拒绝帧的开头
+const RejectMagic uint32 = 0x5a2b71ff
+
+// 握手被服务端拒绝，具体的错误说明了原因和服务端支持的编码类型
+var ErrProtocolMismatch = errors.New("rpc client: protocol mismatch")
+
+const (
+	rejectBadMagic byte = iota + 1
+	rejectUnsupportedCodec
+	rejectEncryptionRequired    // 服务端只接受加密会话
+	rejectEncryptionUnsupported // 服务端没有开启加密会话
+	rejectUpgradeUnsupported    // 服务端不接受STARTTLS，见StartTLS
+)
+
+// 写拒绝帧，失败也没关系，连接随后就关闭
+func writeRejection(conn net.Conn, reason byte) {
+	types := make([]uint32, 0, len(codec.NewCodecFuncMap))
+	for t := range codec.NewCodecFuncMap {
+		types = append(types, t)
+	}
+	slices.Sort(types)
+	buf := binary.BigEndian.AppendUint32(nil, RejectMagic)
+	buf = append(buf, reason, byte(len(types)))
+	for _, t := range types {
+		buf = binary.BigEndian.AppendUint32(buf, t)
+	}
+	if _, err := conn.Write(buf); err != nil {
+		log.Println("rpc server: write rejection error:", err)
+	}
+}
+
+// 在读第一个响应之前检查服务端是否拒绝了握手，是则读出拒绝帧返回错误。
+// 读端不能预读时不检查
+func readRejection(r io.Reader) error {
+	p, ok := r.(interface{ Peek(n int) ([]byte, error) })
+	if !ok {
+		return nil
+	}
+	head, err := p.Peek(4)
+	if err != nil || binary.BigEndian.Uint32(head) != RejectMagic {
+		return nil // 出错时留给codec报告
+	}
+	buf := make([]byte, 6)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return fmt.Errorf("%w: %v", ErrProtocolMismatch, err)
+	}
+	switch buf[4] {
+	case rejectBadMagic:
+		return fmt.Errorf("%w: server rejected the magic number", ErrProtocolMismatch)
+	case rejectEncryptionRequired:
+		return fmt.Errorf("%w: server requires an encrypted session", ErrProtocolMismatch)
+	case rejectEncryptionUnsupported:
+		return fmt.Errorf("%w: server does not accept encrypted sessions", ErrProtocolMismatch)
+	case rejectUpgradeUnsupported:
+		return fmt.Errorf("%w: server does not support STARTTLS", ErrProtocolMismatch)
+	}
+	types := make([]byte, 4*int(buf[5]))
+	if _, err := io.ReadFull(r, types); err != nil {
+		return fmt.Errorf("%w: %v", ErrProtocolMismatch, err)
+	}
+	names := make([]string, 0, len(types)/4)
+	for i := 0; i < len(types); i += 4 {
+		names = append(names, codec.TypeName(binary.BigEndian.Uint32(types[i:])))
+	}
+	return fmt.Errorf("%w: server supports [%s]", ErrProtocolMismatch, strings.Join(names, ","))
+}
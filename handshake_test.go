@@ -0,0 +1,111 @@
+package mrpc
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/micplus/mrpc/codec"
+)
+
+// 拒绝帧中列出的编码：codec.NewCodecFuncMap中注册的类型，按值排序
+func supportedCodecs() string {
+	types := make([]uint32, 0, len(codec.NewCodecFuncMap))
+	for t := range codec.NewCodecFuncMap {
+		types = append(types, t)
+	}
+	slices.Sort(types)
+	names := make([]string, len(types))
+	for i, t := range types {
+		names[i] = codec.TypeName(t)
+	}
+	return "server supports [" + strings.Join(names, ",") + "]"
+}
+
+func TestHandshakeRejection(t *testing.T) {
+	s := NewServer()
+	for _, tc := range []struct {
+		magic, codecType uint32
+		want             string
+	}{
+		{Magic, 99, supportedCodecs()},
+		{0x47455420, 0, "server rejected the magic number"},
+	} {
+		c1, c2 := net.Pipe()
+		go s.ServeConn(c2)
+		buf := binary.BigEndian.AppendUint32(nil, tc.magic)
+		c1.Write(binary.BigEndian.AppendUint32(buf, tc.codecType))
+		err := readRejection(bufio.NewReader(c1))
+		assert(t, errors.Is(err, ErrProtocolMismatch) && strings.Contains(err.Error(), tc.want),
+			"want %q, got %v", tc.want, err)
+		c1.Close()
+	}
+}
+
+func TestClientReportsRejection(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+	go func() {
+		io.ReadFull(c2, make([]byte, 8))
+		writeRejection(c2, rejectUnsupportedCodec)
+		io.Copy(io.Discard, c2)
+	}()
+	client, err := NewClientOptions(c1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	// 已经发出的调用和之后的调用都报告拒绝的原因
+	for range 2 {
+		err = client.Call("Calc.Sum", Pair{1, 2}, new(int))
+		assert(t, errors.Is(err, ErrProtocolMismatch), "want protocol mismatch, got %v", err)
+		assert(t, err != nil && err.Error() == "rpc client: protocol mismatch: "+supportedCodecs(), "unexpected error %v", err)
+	}
+}
+
+func TestHandshakeTimeout(t *testing.T) {
+	s := NewServer(WithHandshakeTimeout(20 * time.Millisecond))
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	done := make(chan struct{})
+	go func() {
+		s.ServeConn(c2)
+		close(done)
+	}()
+	c1.Write([]byte{0x5a}) // 只发一个字节
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("connection without handshake was not closed")
+	}
+	_, err := c1.Read(make([]byte, 1))
+	assert(t, err == io.EOF, "want EOF, got %v", err)
+}
+
+func TestWriteTimeout(t *testing.T) {
+	s := NewServer(WithWriteTimeout(20 * time.Millisecond))
+	s.Register(new(Echo))
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	done := make(chan struct{})
+	go func() {
+		s.ServeConn(c2)
+		close(done)
+	}()
+	buf := binary.BigEndian.AppendUint32(nil, Magic)
+	c1.Write(binary.BigEndian.AppendUint32(buf, codec.GobType))
+	// 只发请求不读响应，net.Pipe没有缓冲，第一个响应就写不出去
+	cc := codec.NewGobCodec(c1)
+	go cc.Write(&codec.Header{Name: "Echo.Say", Seq: 1}, "hello")
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("connection of a client that stopped reading was not closed")
+	}
+}
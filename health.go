@@ -0,0 +1,81 @@
+package mrpc
+
+// 维护模式：SetServing(false)之后节点照常接受连接，但新的请求都以Unavailable拒绝，
+// 已经在处理的请求照常完成，健康检查报告NOT_SERVING，负载均衡和服务发现据此摘除节点。
+// 同时在每条连接上发送GOAWAY，客户端不再往这条连接发请求，换到其它节点(见xclient)，
+// 维护期间新建的连接在握手后也会收到GOAWAY。
+// 与Shutdown不同，进程和listener都还在，维护完后SetServing(true)恢复接受请求。
+// 收到过GOAWAY的连接不会恢复，排空后关闭，客户端要重新建立连接，xclient会自动重连
+//
+//	s.RegisterHealth()
+//	s.SetServing(false)
+//	// 等流量排空后维护
+//	s.SetServing(true)
+
+// 健康检查服务注册使用的服务名，维护模式下它的方法不被拒绝
+const HealthServiceName = "Health"
+
+// 健康检查的结果，与gRPC健康检查的取值相同
+type HealthStatus string
+
+const (
+	HealthServing    HealthStatus = "SERVING"
+	HealthNotServing HealthStatus = "NOT_SERVING"
+)
+
+// 维护模式下拒绝请求的错误，请求没有执行，可以换一个节点重试
+var errNotServing = &Error{Code: Unavailable, Message: "rpc server: server is not serving"}
+
+// 进入或退出维护模式，进入时通知所有连接上的客户端。退出时不会恢复已经收到GOAWAY的连接
+func (s *Server) SetServing(serving bool) {
+	if was := s.notServing.Swap(!serving); serving || was {
+		return
+	}
+	s.goaways.Range(func(w, _ any) bool {
+		w.(*responseWriter).goaway()
+		return true
+	})
+}
+
+// 是否在正常服务，维护模式或者开始Shutdown后为false
+func (s *Server) Serving() bool {
+	return !s.notServing.Load() && !s.inShutdown.Load()
+}
+
+// 维护模式下拒绝健康检查以外的请求
+func (s *Server) checkServing(req *request) error {
+	if s.notServing.Load() && req.svc.name != HealthServiceName {
+		return errNotServing
+	}
+	return nil
+}
+
+// 注册为HealthServiceName的服务
+type health struct {
+	s *Server
+}
+
+// 参数无意义
+func (h *health) Check(_ int, reply *HealthStatus) error {
+	*reply = HealthNotServing
+	if h.s.Serving() {
+		*reply = HealthServing
+	}
+	return nil
+}
+
+// 注册健康检查服务，客户端调用"Health.Check"得到HealthStatus
+func (s *Server) RegisterHealth() error {
+	return s.RegisterName(HealthServiceName, &health{s: s})
+}
+
+func RegisterHealth() error {
+	return DefaultServer.RegisterHealth()
+}
+
+// 调用对端的健康检查，对端没有注册健康检查服务时返回错误
+func (c *Client) CheckHealth() (HealthStatus, error) {
+	var status HealthStatus
+	err := c.Call(HealthServiceName+".Check", 0, &status)
+	return status, err
+}
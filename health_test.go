@@ -0,0 +1,103 @@
+package mrpc
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/micplus/mrpc/codec"
+)
+
+func TestSetServing(t *testing.T) {
+	s, g, l := newGateServer(t)
+	defer l.Close()
+	if err := s.RegisterHealth(); err != nil {
+		t.Fatal(err)
+	}
+	dial := func() *Client {
+		client, err := Dial("tcp", l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { client.Close() })
+		return client
+	}
+	client := dial()
+	status, err := client.CheckHealth()
+	assert(t, err == nil && status == HealthServing, "want SERVING, got %q, %v", status, err)
+
+	call := client.Go("Gate.Wait", 1, new(int), nil)
+	<-g.entered
+	s.SetServing(false)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	assert(t, client.WaitForStateChange(ctx, Ready) && client.GetState() == Degraded,
+		"the connection should be draining, got %v", client.GetState())
+
+	// 维护期间新建的连接在握手后也收到GOAWAY
+	other := dial()
+	assert(t, other.WaitForStateChange(ctx, Ready), "new connection should be draining, got %v", other.GetState())
+
+	// 收到GOAWAY之前发出的请求只有健康检查可用
+	cc := rawConn(t, s)
+	status = ""
+	h := rawCall(t, cc, 1, HealthServiceName+".Check", 0, &status)
+	assert(t, h.Error == "" && status == HealthNotServing, "want NOT_SERVING, got %q, %q", status, h.Error)
+	h = rawCall(t, cc, 2, "Gate.Wait", 1, new(int))
+	assert(t, Code(h.Code) == Unavailable, "want Unavailable, got %v %q", Code(h.Code), h.Error)
+
+	// 在途的请求照常完成
+	g.release <- struct{}{}
+	<-call.Done
+	assert(t, call.Error == nil && *call.Reply.(*int) == 1, "in-flight call failed: %v", call.Error)
+
+	// 收到过GOAWAY的连接不会恢复，要重新连接
+	s.SetServing(true)
+	assert(t, client.GetState() != Ready && other.GetState() != Ready, "drained connections should not come back")
+	fresh := dial()
+	status, err = fresh.CheckHealth()
+	assert(t, err == nil && status == HealthServing, "want SERVING after maintenance, got %q, %v", status, err)
+	go func() { g.release <- struct{}{} }()
+	err = fresh.Call("Gate.Wait", 1, new(int))
+	assert(t, err == nil, "calls should be accepted again, got %v", err)
+}
+
+// 不理会GOAWAY的原始gob连接
+func rawConn(t *testing.T, s *Server) codec.Codec {
+	c1, c2 := net.Pipe()
+	go s.ServeConn(c2)
+	t.Cleanup(func() { c1.Close() })
+	buf := binary.BigEndian.AppendUint32(nil, Magic)
+	if _, err := c1.Write(binary.BigEndian.AppendUint32(buf, codec.GobType)); err != nil {
+		t.Fatal(err)
+	}
+	return codec.NewGobCodec(c1)
+}
+
+// 发出请求并读取它的响应，跳过控制帧。服务端可能正在写GOAWAY等控制帧，
+// 写请求的同时要读，但下一次写要等这次写完，codec的写不能并发
+func rawCall(t *testing.T, cc codec.Codec, seq uint64, name string, args, reply any) *codec.Header {
+	written := make(chan error, 1)
+	go func() { written <- cc.Write(&codec.Header{Seq: seq, Name: name}, args) }()
+	defer func() {
+		if err := <-written; err != nil {
+			t.Error(err)
+		}
+	}()
+	for {
+		var h codec.Header
+		if err := cc.ReadHeader(&h); err != nil {
+			t.Fatal(err)
+		}
+		if h.Seq != seq || h.Error != "" {
+			cc.ReadBody(nil)
+		} else {
+			cc.ReadBody(reply)
+		}
+		if h.Seq == seq {
+			return &h
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package mrpc
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// HTTP/2传输：每条mrpc连接对应一个HTTP/2流，客户端POST的请求体和服务端的响应体
+// 组成双向的字节流，其上的握手、帧格式与TCP完全相同，多个调用照常在流上并发。
+// 只支持HTTP/2的负载均衡、服务网格可以直接转发。
+// HTTP/1.1不能同时读请求、写响应，服务端会拒绝
+//
+//	http.Handle(mrpc.DefaultHTTP2Path, server.HTTP2Handler())
+//	client, err := mrpc.DialHTTP2(http.DefaultClient, "https://host"+mrpc.DefaultHTTP2Path)
+
+const (
+	DefaultHTTP2Path = "/mrpc"
+	http2ContentType = "application/mrpc"
+)
+
+type streamAddr string
+
+func (streamAddr) Network() string  { return "http2" }
+func (a streamAddr) String() string { return string(a) }
+
+// 把HTTP/2流的读写两端包装成net.Conn，写入后立即Flush，codec自己会攒满一条消息再写
+type streamConn struct {
+	io.Reader
+	w             io.Writer
+	flush         func() error
+	close         func() error
+	closeOnce     sync.Once
+	local, remote net.Addr
+}
+
+func (c *streamConn) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	if err == nil && c.flush != nil {
+		err = c.flush()
+	}
+	return n, err
+}
+
+func (c *streamConn) Close() error {
+	var err error
+	c.closeOnce.Do(func() { err = c.close() })
+	return err
+}
+
+func (c *streamConn) LocalAddr() net.Addr  { return c.local }
+func (c *streamConn) RemoteAddr() net.Addr { return c.remote }
+
+// 流上没有单独的超时，调用的超时由ctx控制
+func (c *streamConn) SetDeadline(time.Time) error      { return nil }
+func (c *streamConn) SetReadDeadline(time.Time) error  { return nil }
+func (c *streamConn) SetWriteDeadline(time.Time) error { return nil }
+
+// 响应体读到结束或出错时释放整个流
+type streamBody struct {
+	io.ReadCloser
+	done func()
+}
+
+func (b *streamBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err != nil {
+		b.ReadCloser.Close()
+		b.done()
+	}
+	return n, err
+}
+
+// 在HTTP/2流上提供服务的http.Handler，每个请求流当作一条连接交给ServeConn
+func (s *Server) HTTP2Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "rpc server: method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if r.ProtoMajor < 2 {
+			// 不读完请求体就关闭连接，否则服务端会等客户端写完，而客户端在等响应
+			w.Header().Set("Connection", "close")
+			http.Error(w, "rpc server: HTTP/2 required", http.StatusHTTPVersionNotSupported)
+			return
+		}
+		// 先发出响应头，客户端拿到响应后才开始读
+		w.Header().Set("Content-Type", http2ContentType)
+		w.WriteHeader(http.StatusOK)
+		rc := http.NewResponseController(w)
+		if err := rc.Flush(); err != nil {
+			log.Println("rpc server: flush response error:", err)
+			return
+		}
+		local, _ := r.Context().Value(http.LocalAddrContextKey).(net.Addr)
+		if local == nil {
+			local = streamAddr(r.Host)
+		}
+		s.ServeConn(&streamConn{
+			Reader: r.Body,
+			w:      w,
+			flush:  rc.Flush,
+			close:  r.Body.Close,
+			local:  local,
+			remote: streamAddr(r.RemoteAddr),
+		})
+	})
+}
+
+// 通过HTTP/2流连接服务端，url指向HTTP2Handler。
+// hc必须能发出HTTP/2请求：https时默认即可，明文(h2c)需要自行配置Transport
+func DialHTTP2(hc *http.Client, url string, opts ...ClientOption) (*Client, error) {
+	pr, pw := io.Pipe()
+	ctx, cancel := context.WithCancel(context.Background())
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, pr)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	req.Header.Set("Content-Type", http2ContentType)
+	resp, err := hc.Do(req)
+	if err != nil {
+		cancel()
+		log.Println("rpc client: dial error:", err)
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK || resp.ProtoMajor < 2 {
+		resp.Body.Close()
+		cancel()
+		err = fmt.Errorf("rpc client: unexpected HTTP response %s %s", resp.Proto, resp.Status)
+		log.Println(err)
+		return nil, err
+	}
+	conn := &streamConn{
+		Reader: &streamBody{ReadCloser: resp.Body, done: cancel},
+		w:      pw,
+		// 只结束请求体，服务端处理完已收到的请求后结束响应，读到响应结束时再释放流
+		close:  pw.Close,
+		local:  streamAddr(""),
+		remote: streamAddr(req.URL.Host),
+	}
+	return NewClientOptions(conn, opts...)
+}
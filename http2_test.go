@@ -0,0 +1,48 @@
+package mrpc
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestHTTP2(t *testing.T) {
+	s := NewServer()
+	s.Register(new(Calc))
+	ts := httptest.NewUnstartedServer(s.HTTP2Handler())
+	ts.EnableHTTP2 = true
+	ts.StartTLS()
+	defer ts.Close()
+
+	client, err := DialHTTP2(ts.Client(), ts.URL+DefaultHTTP2Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			var sum int
+			err := client.Call("Calc.Sum", Pair{i, i}, &sum)
+			assert(t, err == nil && sum == 2*i, "call %d: sum=%d err=%v", i, sum, err)
+		}(i)
+	}
+	wg.Wait()
+	conns := s.ConnStats()
+	assert(t, len(conns) == 1 && conns[0].MessagesRead == 10, "want one stream with 10 requests, got %+v", conns)
+	client.Close()
+
+	// HTTP/1.1不能双向流
+	h1 := httptest.NewServer(s.HTTP2Handler())
+	defer h1.Close()
+	_, err = DialHTTP2(h1.Client(), h1.URL)
+	assert(t, err != nil, "HTTP/1.1 should be rejected")
+	resp, err := ts.Client().Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	assert(t, resp.StatusCode == http.StatusMethodNotAllowed, "GET should be rejected, got %s", resp.Status)
+}
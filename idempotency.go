@@ -0,0 +1,158 @@
+package mrpc
+
+import (
+	"bytes"
+	"container/heap"
+	"context"
+	"encoding/gob"
+	"log"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// 幂等键：客户端给会修改状态的调用带上唯一的键，重试(包括换连接后的重试)时用同一个键。
+// 服务端开启WithIdempotency后，按(客户端身份, 键)保存成功调用的返回值，
+// TTL内再收到同一个键就直接返回保存的结果，不再执行方法；原调用还在执行时等它完成。
+// 失败的调用不保存，重试时会再次执行
+//
+//	ctx = mrpc.WithIdempotencyKey(ctx, orderID)
+//	err := xc.CallContext(ctx, "Order.Pay", args, &reply)
+
+// 元数据中幂等键的键名
+const MetaIdempotencyKey = "idempotency-key"
+
+// 给调用附加幂等键
+func WithIdempotencyKey(ctx context.Context, key string) context.Context {
+	return WithOutgoingMetadata(ctx, Metadata{MetaIdempotencyKey: key})
+}
+
+// 保存带幂等键的调用结果ttl时间。结果用gob编码保存，返回值类型要能被gob编解码，
+// 不能编码的结果不保存，只记录日志，重试时会再次执行
+func WithIdempotency(ttl time.Duration) ServerOption {
+	return func(s *Server) {
+		s.dedup = &dedupStore{ttl: ttl, entries: make(map[dedupKey]*dedupEntry)}
+	}
+}
+
+type dedupKey struct {
+	identity, key string
+}
+
+type dedupEntry struct {
+	method  string
+	done    chan struct{} // 原调用完成后关闭
+	reply   []byte        // 编码后的返回值，为nil时原调用失败了
+	expires time.Time
+}
+
+// 内存中的结果表，过期的结果在加入新的键时按到期时间从堆顶清除
+type dedupStore struct {
+	ttl time.Duration
+
+	mu      sync.Mutex // protect following
+	entries map[dedupKey]*dedupEntry
+	order   dedupHeap // 已保存结果的条目，按到期时间排序
+}
+
+// 客户端身份，没有证书时为空，所有这样的客户端共用键空间
+func identityString(id *Identity) string {
+	switch {
+	case id == nil:
+		return ""
+	case id.SPIFFEID != "":
+		return id.SPIFFEID
+	}
+	return id.CommonName
+}
+
+// 执行带幂等键的调用，没有键时直接调用call。replyv是方法的返回值指针
+func (d *dedupStore) do(ctx context.Context, clock Clock, method string, id *Identity, replyv reflect.Value, call func() error) error {
+	if d == nil {
+		return call()
+	}
+	key := IncomingMetadata(ctx)[MetaIdempotencyKey]
+	if key == "" {
+		return call()
+	}
+	k := dedupKey{identityString(id), key}
+	for {
+		now := clock.Now()
+		d.mu.Lock()
+		e, ok := d.entries[k]
+		if ok && e.reply != nil && now.After(e.expires) {
+			ok = false
+		}
+		if !ok {
+			d.prune(now)
+			e = &dedupEntry{method: method, done: make(chan struct{})}
+			d.entries[k] = e
+			d.mu.Unlock()
+			return d.run(e, k, clock, replyv, call)
+		}
+		d.mu.Unlock()
+		if e.method != method {
+			return Errorf(InvalidArgument, "rpc server: idempotency key %q was used for %s", key, e.method)
+		}
+		select {
+		case <-e.done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if e.reply != nil {
+			return gob.NewDecoder(bytes.NewReader(e.reply)).Decode(replyv.Interface())
+		}
+		// 原调用失败，条目已删除，重新执行
+	}
+}
+
+func (d *dedupStore) run(e *dedupEntry, k dedupKey, clock Clock, replyv reflect.Value, call func() error) error {
+	var buf bytes.Buffer
+	err := call()
+	cached := err == nil
+	if cached {
+		// 方法已经成功执行，编码失败只是不能保存结果，照常返回给这次调用
+		if encErr := gob.NewEncoder(&buf).Encode(replyv.Interface()); encErr != nil {
+			log.Printf("rpc server: cannot save result of %s for idempotency: %v", e.method, encErr)
+			cached = false
+		}
+	}
+	d.mu.Lock()
+	if !cached {
+		delete(d.entries, k)
+	} else {
+		e.reply, e.expires = buf.Bytes(), clock.Now().Add(d.ttl)
+		heap.Push(&d.order, dedupItem{k, e})
+	}
+	d.mu.Unlock()
+	close(e.done)
+	return err
+}
+
+// 清除过期的结果，调用时持有d.mu。键过期后可能已被新的调用占用，只删除堆中记录的那个条目
+func (d *dedupStore) prune(now time.Time) {
+	for len(d.order) > 0 && now.After(d.order[0].entry.expires) {
+		it := heap.Pop(&d.order).(dedupItem)
+		if d.entries[it.key] == it.entry {
+			delete(d.entries, it.key)
+		}
+	}
+}
+
+type dedupItem struct {
+	key   dedupKey
+	entry *dedupEntry
+}
+
+type dedupHeap []dedupItem
+
+func (h dedupHeap) Len() int           { return len(h) }
+func (h dedupHeap) Less(i, j int) bool { return h[i].entry.expires.Before(h[j].entry.expires) }
+func (h dedupHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *dedupHeap) Push(x any)        { *h = append(*h, x.(dedupItem)) }
+func (h *dedupHeap) Pop() any {
+	old := *h
+	it := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return it
+}
@@ -0,0 +1,139 @@
+package mrpc
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type Receipt struct {
+	ID     int64
+	Amount int
+}
+
+type Payments struct {
+	charges atomic.Int64
+	gate    chan struct{}
+}
+
+func (p *Payments) Charge(amount int, reply *Receipt) error {
+	if p.gate != nil {
+		<-p.gate
+	}
+	if amount < 0 {
+		return errors.New("negative amount")
+	}
+	*reply = Receipt{ID: p.charges.Add(1), Amount: amount}
+	return nil
+}
+
+func (p *Payments) Refund(amount int, reply *Receipt) error {
+	return nil
+}
+
+func TestIdempotency(t *testing.T) {
+	clock := &skewClock{}
+	p := new(Payments)
+	s := NewServer(WithIdempotency(time.Minute), WithClock(clock))
+	s.Register(p)
+	client := pipeClient(t, s)
+	ctx := WithIdempotencyKey(context.Background(), "order-1")
+
+	var r1, r2 Receipt
+	err := client.CallContext(ctx, "Payments.Charge", 10, &r1)
+	assert(t, err == nil && r1.ID == 1, "first charge: %v %+v", err, r1)
+	// 重试返回保存的结果，不再执行
+	err = client.CallContext(ctx, "Payments.Charge", 10, &r2)
+	assert(t, err == nil && r2 == r1 && p.charges.Load() == 1, "retry: %v %+v, %d charges", err, r2, p.charges.Load())
+
+	err = client.CallContext(ctx, "Payments.Refund", 10, &r2)
+	assert(t, CodeOf(err) == InvalidArgument, "key reused for another method: want InvalidArgument, got %v", err)
+	err = client.Call("Payments.Charge", 10, &r2)
+	assert(t, err == nil && r2.ID == 2, "call without key: %v %+v", err, r2)
+
+	// 失败的调用不保存
+	failCtx := WithIdempotencyKey(context.Background(), "order-2")
+	err = client.CallContext(failCtx, "Payments.Charge", -1, &r2)
+	assert(t, err != nil, "negative amount should fail")
+	err = client.CallContext(failCtx, "Payments.Charge", 5, &r2)
+	assert(t, err == nil && r2.ID == 3, "retry after failure: %v %+v", err, r2)
+
+	// 过期后再次执行
+	clock.skew.Add(int64(2 * time.Minute))
+	err = client.CallContext(ctx, "Payments.Charge", 10, &r2)
+	assert(t, err == nil && r2.ID == 4, "after TTL: %v %+v", err, r2)
+}
+
+func TestIdempotencyConcurrent(t *testing.T) {
+	p := &Payments{gate: make(chan struct{})}
+	s := NewServer(WithIdempotency(time.Minute))
+	s.Register(p)
+	client := pipeClient(t, s)
+	ctx := WithIdempotencyKey(context.Background(), "order-1")
+
+	var wg sync.WaitGroup
+	receipts := make([]Receipt, 4)
+	for i := range receipts {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := client.CallContext(ctx, "Payments.Charge", 10, &receipts[i]); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	// 只有一个调用进入方法，其余的等它完成
+	p.gate <- struct{}{}
+	wg.Wait()
+	for _, r := range receipts {
+		assert(t, r.ID == 1, "receipts %+v", receipts)
+	}
+	assert(t, p.charges.Load() == 1, "charged %d times", p.charges.Load())
+}
+
+func TestDedupPrune(t *testing.T) {
+	clock := &skewClock{}
+	d := &dedupStore{ttl: time.Minute, entries: make(map[dedupKey]*dedupEntry)}
+	add := func(key string) {
+		k, e := dedupKey{"", key}, &dedupEntry{method: "Payments.Charge", done: make(chan struct{})}
+		d.entries[k] = e
+		d.run(e, k, clock, reflect.ValueOf(new(int)), func() error { return nil })
+	}
+	add("order-1")
+	clock.skew.Add(int64(30 * time.Second))
+	add("order-2")
+
+	// 只清除堆顶已过期的条目
+	clock.skew.Add(int64(45 * time.Second))
+	d.mu.Lock()
+	d.prune(clock.Now())
+	d.mu.Unlock()
+	_, ok := d.entries[dedupKey{"", "order-2"}]
+	assert(t, len(d.entries) == 1 && len(d.order) == 1 && ok, "want only order-2 left, got %d entries", len(d.entries))
+}
+
+// gob不能编码的返回值
+type unsavable struct{ N int }
+
+func (unsavable) GobEncode() ([]byte, error) { return nil, errors.New("cannot encode") }
+
+// 方法成功但结果不能保存时照常返回，重试会再次执行
+func TestDedupUnsavableReply(t *testing.T) {
+	d := &dedupStore{ttl: time.Minute, entries: make(map[dedupKey]*dedupEntry)}
+	ctx := withIncomingMetadata(context.Background(), Metadata{MetaIdempotencyKey: "order-1"})
+	calls := 0
+	for i := 0; i < 2; i++ {
+		reply := new(unsavable)
+		err := d.do(ctx, SystemClock, "Payments.Charge", nil, reflect.ValueOf(reply), func() error {
+			calls++
+			reply.N = calls
+			return nil
+		})
+		assert(t, err == nil && reply.N == i+1, "call %d: reply %d, %v", i, reply.N, err)
+	}
+	assert(t, calls == 2 && len(d.entries) == 0 && len(d.order) == 0, "%d calls, %d entries", calls, len(d.entries))
+}
@@ -0,0 +1,106 @@
+package mrpc
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+
+	"github.com/micplus/mrpc/codec"
+)
+
+// 方法名编号：反复调用少数几个方法的连接上，方法名占了请求头的大部分。开启后客户端第一次调用
+// 某个方法时在请求头中同时带上方法名和它分配的编号(Header.NameID)，服务端记下这条连接上的对应关系，
+// 响应原样带回编号表示确认；确认之后的请求只带编号，响应也不再带方法名。
+// 旧版本的服务端不认识NameID，响应中不会带回它，客户端就一直发送方法名，所以不需要事先协商。
+// 每条连接最多编号maxInternedNames个方法名，超出的照常发送方法名。
+// BinaryJSONType的头部没有NameID字段，不会启用
+
+// 一条连接上编号的方法名个数上限，服务端按它限制每条连接的表的大小
+const maxInternedNames = 1024
+
+// 客户端为调用的方法名编号，见上
+func WithClientNameInterning() ClientOption {
+	return func(o *clientOptions) {
+		o.internNames = true
+	}
+}
+
+// 客户端一条连接上分配的编号，为nil时不编号
+type clientNames struct {
+	mu    sync.Mutex // protect following
+	ids   map[string]uint16
+	acked []bool // 下标是编号，服务端已经确认
+}
+
+func newClientNames() *clientNames {
+	return &clientNames{ids: make(map[string]uint16), acked: []bool{false}}
+}
+
+// 方法名的编号，第一次调用时分配，编号用完时为0。acked为true时请求可以只带编号
+func (t *clientNames) id(name string) (id uint16, acked bool) {
+	if t == nil {
+		return 0, false
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	id, ok := t.ids[name]
+	if !ok {
+		if len(t.ids) >= maxInternedNames {
+			return 0, false
+		}
+		id = uint16(len(t.acked))
+		t.ids[name] = id
+		t.acked = append(t.acked, false)
+	}
+	return id, t.acked[id]
+}
+
+// 响应带回了编号
+func (t *clientNames) ack(id uint16) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if int(id) < len(t.acked) {
+		t.acked[id] = true
+	}
+}
+
+// 设置请求头中的方法名和编号
+func (t *clientNames) encode(h *codec.Header, name string) {
+	h.Name, h.NameID = name, 0
+	id, acked := t.id(name)
+	if id == 0 {
+		return
+	}
+	h.NameID = id
+	if acked {
+		h.Name = ""
+	}
+}
+
+// 服务端一条连接上客户端分配的编号，只在读循环中使用。下标是编号-1
+type serverNames []string
+
+// 记下客户端分配的编号，或者把编号换回方法名。interned表示请求只带了编号。
+// 超出上限的编号不记录，清除后响应不会带回它，客户端就继续发送方法名
+func (t *serverNames) resolve(h *codec.Header) (interned bool, err error) {
+	id := int(h.NameID)
+	if h.Name != "" {
+		if id > maxInternedNames {
+			h.NameID = 0
+			return false, nil
+		}
+		for len(*t) < id {
+			*t = append(*t, "")
+		}
+		(*t)[id-1] = h.Name
+		return false, nil
+	}
+	if id > len(*t) || (*t)[id-1] == "" {
+		return false, errors.New("rpc server: unknown method id " + strconv.Itoa(id))
+	}
+	h.Name = (*t)[id-1]
+	return true, nil
+}
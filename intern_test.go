@@ -0,0 +1,92 @@
+package mrpc
+
+import (
+	"bytes"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/micplus/mrpc/codec"
+)
+
+// 记录连接上读写的字节
+type tappedConn struct {
+	net.Conn
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (c *tappedConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.mu.Lock()
+	c.buf.Write(p[:n])
+	c.mu.Unlock()
+	return n, err
+}
+
+func (c *tappedConn) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	c.buf.Write(p)
+	c.mu.Unlock()
+	return c.Conn.Write(p)
+}
+
+func (c *tappedConn) take() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := c.buf.String()
+	c.buf.Reset()
+	return s
+}
+
+func TestNameInterning(t *testing.T) {
+	s := NewServer()
+	s.Register(new(Calc))
+	for _, tc := range []struct {
+		codecType uint32
+		interned  bool
+	}{
+		{codec.GobType, true},
+		{codec.JSONType, true},
+		{codec.BinaryJSONType, false}, // 头部没有NameID，一直发送方法名
+	} {
+		c1, c2 := net.Pipe()
+		go s.ServeConn(c2)
+		conn := &tappedConn{Conn: c1}
+		client, err := NewClientOptions(conn, WithClientCodecType(tc.codecType), WithClientNameInterning())
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 3; i++ {
+			var sum int
+			err := client.Call("Calc.Sum", Pair{i, 1}, &sum)
+			assert(t, err == nil && sum == i+1, "%s: sum=%d err=%v", codec.TypeName(tc.codecType), sum, err)
+			err = client.Call("Calc.Missing", Pair{}, &sum)
+			assert(t, err != nil && strings.Contains(err.Error(), "Missing"), "%s: want missing method, got %v", codec.TypeName(tc.codecType), err)
+		}
+		conn.take()
+		var sum int
+		client.Call("Calc.Sum", Pair{1, 1}, &sum)
+		wire := conn.take()
+		assert(t, strings.Contains(wire, "Calc.Sum") != tc.interned,
+			"%s: interned=%v but wire is %q", codec.TypeName(tc.codecType), tc.interned, wire)
+		client.Close()
+	}
+}
+
+func TestServerNames(t *testing.T) {
+	var names serverNames
+	h := &codec.Header{Name: "Calc.Sum", NameID: 2}
+	interned, err := names.resolve(h)
+	assert(t, !interned && err == nil && len(names) == 2, "proposal: %v %v %v", interned, err, names)
+	h = &codec.Header{NameID: 2}
+	interned, err = names.resolve(h)
+	assert(t, interned && err == nil && h.Name == "Calc.Sum", "lookup: %v %v %q", interned, err, h.Name)
+	_, err = names.resolve(&codec.Header{NameID: 1})
+	assert(t, err != nil && strings.Contains(err.Error(), "unknown method id 1"), "want unknown id, got %v", err)
+	// 超出上限的编号不确认
+	h = &codec.Header{Name: "Calc.Sum", NameID: maxInternedNames + 1}
+	interned, err = names.resolve(h)
+	assert(t, !interned && err == nil && h.NameID == 0 && len(names) == 2, "over limit: %+v %v", h, names)
+}
@@ -0,0 +1,308 @@
+package mrpc
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// 异步任务：耗时超过任何合理超时的操作不在调用中等它完成，调用立即返回任务ID，
+// 客户端之后通过JobServiceName服务查询进度、取结果或取消。任务与连接无关，
+// 换一条连接、甚至换一个客户端也能用ID查到。
+//
+// 任务属于启动它的客户端身份(见IdentityFromContext)，其它身份查询、取消时返回NotFound。
+// 没有证书的客户端共用一个身份，ID是随机的128位，只有拿到ID的调用方才能访问任务，
+// 这些客户端不能列出任务
+//
+//	mrpc.HandleJob(s, "Report.Build", func(ctx context.Context, args *Args, job *mrpc.Job) (*Report, error) {
+//		job.SetProgress(0.5, "querying")
+//		...
+//	})
+//
+//	var id string
+//	client.Call("Report.Build", args, &id)
+//	var report Report
+//	err := client.WaitJob(ctx, id, &report, time.Second)
+
+// 任务服务注册使用的服务名
+const JobServiceName = "Job"
+
+// 默认保留已结束任务的时间
+const DefaultJobRetention = 10 * time.Minute
+
+type JobState int
+
+const (
+	JobRunning JobState = iota
+	JobSucceeded
+	JobFailed
+	JobCanceled
+)
+
+var jobStateNames = [...]string{"Running", "Succeeded", "Failed", "Canceled"}
+
+func (s JobState) String() string {
+	if int(s) < len(jobStateNames) {
+		return jobStateNames[s]
+	}
+	return "JobState(" + strconv.Itoa(int(s)) + ")"
+}
+
+type JobStatus struct {
+	ID       string
+	Method   string // 启动任务的方法
+	State    JobState
+	Progress float64 // 0到1，由任务自己设置
+	Message  string
+	Error    string // 失败时的错误
+	Created  time.Time
+	Updated  time.Time
+}
+
+// 一个正在执行或已经结束的任务
+type Job struct {
+	clock  Clock
+	cancel context.CancelFunc
+	done   chan struct{}
+	owner  string // 启动任务的客户端身份，见identityString
+
+	mu       sync.Mutex // protect following
+	status   JobStatus
+	result   any
+	err      error
+	canceled bool
+}
+
+func (j *Job) ID() string {
+	return j.status.ID // 创建后不变
+}
+
+// 更新进度，查询状态时返回
+func (j *Job) SetProgress(progress float64, message string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.status.Progress, j.status.Message = progress, message
+	j.status.Updated = j.clock.Now()
+}
+
+func (j *Job) Status() JobStatus {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.status
+}
+
+// 任务结束后才能调用
+func (j *Job) finish(result any, err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.result, j.err = result, err
+	switch {
+	case j.canceled && err != nil:
+		j.status.State = JobCanceled
+	case err != nil:
+		j.status.State = JobFailed
+		j.status.Error = err.Error()
+	default:
+		j.status.State, j.status.Progress = JobSucceeded, 1
+	}
+	j.status.Updated = j.clock.Now()
+	close(j.done)
+}
+
+// 服务端的任务表，结束超过retention的任务在启动新任务时清除
+type jobTable struct {
+	clock     Clock
+	retention time.Duration
+
+	mu   sync.Mutex // protect following
+	jobs map[string]*Job
+}
+
+// 已结束的任务保留多久，过期后查询返回NotFound，默认DefaultJobRetention
+func WithJobRetention(d time.Duration) ServerOption {
+	return func(s *Server) {
+		s.jobRetention = d
+	}
+}
+
+func (t *jobTable) start(ctx context.Context, method string, run func(ctx context.Context, job *Job) (any, error)) *Job {
+	// 任务比请求活得久，保留ctx中的元数据、身份等，但不随请求结束而取消
+	ctx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	now := t.clock.Now()
+	job := &Job{
+		clock:  t.clock,
+		cancel: cancel,
+		done:   make(chan struct{}),
+		owner:  identityString(IdentityFromContext(ctx)),
+		status: JobStatus{ID: newJobID(), Method: method, Created: now, Updated: now},
+	}
+	t.mu.Lock()
+	for id, j := range t.jobs {
+		if st := j.Status(); st.State != JobRunning && now.Sub(st.Updated) > t.retention {
+			delete(t.jobs, id)
+		}
+	}
+	t.jobs[job.status.ID] = job
+	t.mu.Unlock()
+
+	go func() {
+		defer cancel()
+		job.finish(run(ctx, job))
+	}()
+	return job
+}
+
+// 按ID找到调用方自己的任务，其它身份的任务与不存在一样返回NotFound
+func (t *jobTable) get(ctx context.Context, id string) (*Job, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if job, ok := t.jobs[id]; ok && job.owner == identityString(IdentityFromContext(ctx)) {
+		return job, nil
+	}
+	return nil, Errorf(NotFound, "rpc server: job %s not found", id)
+}
+
+func newJobID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// 以函数的形式注册一个启动任务的方法，调用它时在新的协程中执行fn，立即返回任务ID。
+// fn返回的结果由Job.Result取回，返回的错误(*Error保留错误码)作为Job.Result的错误。
+// fn的ctx在任务被取消时结束，它带有调用时的元数据，但不带调用的期限。
+// 第一次调用时注册JobServiceName服务
+//
+// 参数在任务执行期间一直被持有，不要使用实现了Resetter的参数类型
+func HandleJob[A, R any](s *Server, name string, fn func(ctx context.Context, arg A, job *Job) (R, error)) error {
+	if s.jobs == nil {
+		jobs := &jobTable{clock: s.clock, retention: s.jobRetention, jobs: make(map[string]*Job)}
+		if jobs.retention <= 0 {
+			jobs.retention = DefaultJobRetention
+		}
+		if err := s.RegisterName(JobServiceName, &jobService{jobs: jobs}); err != nil {
+			return err
+		}
+		s.jobs = jobs
+	}
+	return HandleFunc(s, name, func(ctx context.Context, arg A, id *string) error {
+		job := s.jobs.start(ctx, name, func(ctx context.Context, job *Job) (any, error) {
+			return fn(ctx, arg, job)
+		})
+		*id = job.ID()
+		return nil
+	})
+}
+
+// Job.Result的返回值，写响应时换成任务的结果，客户端按结果的类型解码
+type JobResult struct {
+	value any
+}
+
+// 写响应用的消息体
+func replyBody(replyv any) any {
+	if r, ok := replyv.(*JobResult); ok {
+		return r.value
+	}
+	return replyv
+}
+
+// 注册为JobServiceName的服务
+type jobService struct {
+	jobs *jobTable
+}
+
+// 查询任务状态
+func (js *jobService) Status(ctx context.Context, id string, reply *JobStatus) error {
+	job, err := js.jobs.get(ctx, id)
+	if err != nil {
+		return err
+	}
+	*reply = job.Status()
+	return nil
+}
+
+// 取任务的结果。还在执行时返回FailedPrecondition，被取消时返回Canceled，
+// 失败时返回任务的错误
+func (js *jobService) Result(ctx context.Context, id string, reply *JobResult) error {
+	job, err := js.jobs.get(ctx, id)
+	if err != nil {
+		return err
+	}
+	select {
+	case <-job.done:
+	default:
+		return Errorf(FailedPrecondition, "rpc server: job %s is still running", id)
+	}
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	switch {
+	case job.status.State == JobCanceled:
+		return Errorf(Canceled, "rpc server: job %s canceled", id)
+	case job.err != nil:
+		return job.err
+	}
+	reply.value = job.result
+	return nil
+}
+
+// 取消任务，返回取消时的状态。任务的ctx结束，任务返回后状态变为Canceled；
+// 已经结束的任务不受影响
+func (js *jobService) Cancel(ctx context.Context, id string, reply *JobStatus) error {
+	job, err := js.jobs.get(ctx, id)
+	if err != nil {
+		return err
+	}
+	job.mu.Lock()
+	if job.status.State == JobRunning {
+		job.canceled = true
+	}
+	job.mu.Unlock()
+	job.cancel()
+	*reply = job.Status()
+	return nil
+}
+
+// 列出调用方的任务，按创建时间排序，参数无意义。没有证书的客户端返回PermissionDenied
+func (js *jobService) List(ctx context.Context, _ int, reply *[]JobStatus) error {
+	owner := identityString(IdentityFromContext(ctx))
+	if owner == "" {
+		return Errorf(PermissionDenied, "rpc server: listing jobs requires a client certificate")
+	}
+	js.jobs.mu.Lock()
+	for _, job := range js.jobs.jobs {
+		if job.owner == owner {
+			*reply = append(*reply, job.Status())
+		}
+	}
+	js.jobs.mu.Unlock()
+	sort.Slice(*reply, func(i, j int) bool {
+		return (*reply)[i].Created.Before((*reply)[j].Created)
+	})
+	return nil
+}
+
+// 每隔interval查询一次任务状态，结束后把结果取到reply中。
+// ctx结束时返回ctx.Err()，任务不会因此取消，需要时调用Job.Cancel
+func (c *Client) WaitJob(ctx context.Context, id string, reply any, interval time.Duration) error {
+	for {
+		var st JobStatus
+		if err := c.CallContext(ctx, JobServiceName+".Status", id, &st); err != nil {
+			return err
+		}
+		if st.State != JobRunning {
+			return c.CallContext(ctx, JobServiceName+".Result", id, reply)
+		}
+		t := c.clock.NewTimer(interval)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		case <-t.C():
+		}
+	}
+}
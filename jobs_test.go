@@ -0,0 +1,163 @@
+package mrpc
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type BuildArgs struct {
+	N    int
+	Fail bool
+}
+
+func newJobServer(t *testing.T, step chan struct{}) *Client {
+	s := NewServer()
+	err := HandleJob(s, "Report.Build", func(ctx context.Context, args *BuildArgs, job *Job) ([]int, error) {
+		var out []int
+		for i := 0; i < args.N; i++ {
+			select {
+			case <-step:
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+			out = append(out, i)
+			job.SetProgress(float64(i+1)/float64(args.N), "step")
+		}
+		if args.Fail {
+			return nil, Errorf(ResourceExhausted, "out of quota")
+		}
+		return out, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pipeClient(t, s)
+}
+
+func TestJob(t *testing.T) {
+	step := make(chan struct{})
+	client := newJobServer(t, step)
+	ctx := context.Background()
+
+	var id string
+	err := client.Call("Report.Build", &BuildArgs{N: 2}, &id)
+	assert(t, err == nil && id != "", "start job: %v", err)
+	err = client.Call("Job.Result", id, new([]int))
+	assert(t, CodeOf(err) == FailedPrecondition, "running job: want FailedPrecondition, got %v", err)
+
+	step <- struct{}{}
+	var st JobStatus
+	for st.Progress < 0.5 {
+		err = client.Call("Job.Status", id, &st)
+		assert(t, err == nil && st.State == JobRunning, "status: %v %+v", err, st)
+	}
+	assert(t, st.Method == "Report.Build", "method = %q", st.Method)
+
+	step <- struct{}{}
+	var out []int
+	err = client.WaitJob(ctx, id, &out, time.Millisecond)
+	assert(t, err == nil && len(out) == 2 && out[1] == 1, "WaitJob: %v %v", err, out)
+	client.Call("Job.Status", id, &st)
+	assert(t, st.State == JobSucceeded && st.Progress == 1, "status after finish %+v", st)
+
+	// 任务的错误保留错误码
+	client.Call("Report.Build", &BuildArgs{Fail: true}, &id)
+	err = client.WaitJob(ctx, id, &out, time.Millisecond)
+	assert(t, CodeOf(err) == ResourceExhausted, "want ResourceExhausted, got %v", err)
+	client.Call("Job.Status", id, &st)
+	assert(t, st.State == JobFailed && st.Error == "out of quota", "status of failed job %+v", st)
+
+	// 没有证书的客户端只能凭ID访问任务
+	err = client.Call("Job.List", 0, new([]JobStatus))
+	assert(t, CodeOf(err) == PermissionDenied, "anonymous List: want PermissionDenied, got %v", err)
+	err = client.Call("Job.Status", "nope", &st)
+	assert(t, CodeOf(err) == NotFound, "want NotFound, got %v", err)
+}
+
+func TestJobCancel(t *testing.T) {
+	client := newJobServer(t, make(chan struct{}))
+	var id string
+	if err := client.Call("Report.Build", &BuildArgs{N: 1}, &id); err != nil {
+		t.Fatal(err)
+	}
+	// 等待中途ctx结束时返回，任务继续执行
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err := client.WaitJob(ctx, id, new([]int), time.Millisecond)
+	assert(t, errors.Is(err, context.DeadlineExceeded), "want DeadlineExceeded, got %v", err)
+
+	var st JobStatus
+	err = client.Call("Job.Cancel", id, &st)
+	assert(t, err == nil, "Cancel: %v", err)
+	err = client.WaitJob(context.Background(), id, new([]int), time.Millisecond)
+	assert(t, CodeOf(err) == Canceled, "want Canceled, got %v", err)
+	client.Call("Job.Status", id, &st)
+	assert(t, st.State == JobCanceled, "state = %v", st.State)
+}
+
+// Now比真实时间快skew，计时器照常
+type skewClock struct {
+	systemClock
+	skew atomic.Int64
+}
+
+func (c *skewClock) Now() time.Time {
+	return time.Now().Add(time.Duration(c.skew.Load()))
+}
+
+func TestJobRetention(t *testing.T) {
+	clock := &skewClock{}
+	s := NewServer(WithClock(clock), WithJobRetention(time.Minute))
+	HandleJob(s, "Quick.Run", func(_ context.Context, n int, _ *Job) (int, error) { return n, nil })
+	client, err := ConnectInProcess(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	var first, second string
+	client.Call("Quick.Run", 1, &first)
+	var n int
+	err = client.WaitJob(context.Background(), first, &n, time.Millisecond)
+	assert(t, err == nil && n == 1, "WaitJob: %v %d", err, n)
+	// 过了保留时间，启动下一个任务时清除
+	clock.skew.Add(int64(2 * time.Minute))
+	client.Call("Quick.Run", 2, &second)
+	err = client.Call("Job.Status", first, new(JobStatus))
+	assert(t, CodeOf(err) == NotFound, "expired job: want NotFound, got %v", err)
+}
+
+// 任务只对启动它的身份可见
+func TestJobOwner(t *testing.T) {
+	jobs := &jobTable{clock: SystemClock, retention: time.Minute, jobs: make(map[string]*Job)}
+	js := &jobService{jobs: jobs}
+	as := func(name string) context.Context {
+		return context.WithValue(context.Background(), identityKey{}, &Identity{CommonName: name})
+	}
+	alice, bob := as("alice"), as("bob")
+	job := jobs.start(alice, "Report.Build", func(ctx context.Context, job *Job) (any, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	jobs.start(bob, "Report.Build", func(context.Context, *Job) (any, error) { return 1, nil })
+
+	var st JobStatus
+	err := js.Status(bob, job.ID(), &st)
+	assert(t, CodeOf(err) == NotFound, "Status by another identity: want NotFound, got %v", err)
+	err = js.Result(bob, job.ID(), new(JobResult))
+	assert(t, CodeOf(err) == NotFound, "Result by another identity: want NotFound, got %v", err)
+	err = js.Cancel(bob, job.ID(), &st)
+	assert(t, CodeOf(err) == NotFound && job.Status().State == JobRunning, "Cancel by another identity: %v", err)
+	err = js.Status(context.Background(), job.ID(), &st)
+	assert(t, CodeOf(err) == NotFound, "Status without identity: want NotFound, got %v", err)
+
+	var list []JobStatus
+	err = js.List(alice, 0, &list)
+	assert(t, err == nil && len(list) == 1 && list[0].ID == job.ID(), "List: %v %+v", err, list)
+	err = js.Cancel(alice, job.ID(), &st)
+	assert(t, err == nil, "Cancel by owner: %v", err)
+	<-job.done
+}
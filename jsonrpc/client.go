@@ -0,0 +1,120 @@
+package jsonrpc
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"strconv"
+	"sync"
+
+	"github.com/micplus/mrpc"
+	"github.com/micplus/mrpc/codec"
+)
+
+type clientRequest struct {
+	Version string `json:"jsonrpc"`
+	Method  string `json:"method"`
+	Params  any    `json:"params,omitempty"`
+	ID      uint64 `json:"id"`
+}
+
+type clientResponse struct {
+	ID     json.RawMessage `json:"id"`
+	Result json.RawMessage `json:"result"`
+	Error  *Error          `json:"error"`
+}
+
+type clientCodec struct {
+	dec  *json.Decoder
+	c    io.ReadWriteCloser
+	resp clientResponse
+
+	mu sync.Mutex // protect Write
+}
+
+var _ codec.Codec = (*clientCodec)(nil)
+
+func NewClientCodec(conn io.ReadWriteCloser) codec.Codec {
+	return &clientCodec{dec: json.NewDecoder(conn), c: conn}
+}
+
+// 结构体和map直接作为命名参数，其余的值包成单元素数组
+func params(body any) any {
+	if body == nil {
+		return nil
+	}
+	t := reflect.TypeOf(body)
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t.Kind() == reflect.Struct || t.Kind() == reflect.Map {
+		return body
+	}
+	return []any{body}
+}
+
+func (c *clientCodec) Write(h *codec.Header, body any) error {
+	b, err := json.Marshal(&clientRequest{
+		Version: version,
+		Method:  h.Name,
+		Params:  params(body),
+		ID:      h.Seq,
+	})
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, err = c.c.Write(append(b, '\n'))
+	return err
+}
+
+func (c *clientCodec) ReadHeader(h *codec.Header) error {
+	c.resp = clientResponse{}
+	if err := c.dec.Decode(&c.resp); err != nil {
+		return err
+	}
+	h.Seq = 0
+	if id := bytes.TrimSpace(c.resp.ID); len(id) > 0 && !bytes.Equal(id, null) {
+		seq, err := strconv.ParseUint(string(id), 10, 64)
+		if err != nil {
+			return fmt.Errorf("rpc jsonrpc: invalid response id %s", id)
+		}
+		h.Seq = seq
+	}
+	h.Error = ""
+	if c.resp.Error != nil {
+		h.Error = c.resp.Error.Message
+		if h.Error == "" {
+			h.Error = c.resp.Error.Error()
+		}
+	}
+	return nil
+}
+
+func (c *clientCodec) ReadBody(x any) error {
+	if x == nil || len(c.resp.Result) == 0 {
+		return nil
+	}
+	return json.Unmarshal(c.resp.Result, x)
+}
+
+func (c *clientCodec) Close() error {
+	return c.c.Close()
+}
+
+// 在连接上创建使用JSON-RPC 2.0协议的客户端
+func NewClient(conn io.ReadWriteCloser) *mrpc.Client {
+	return mrpc.NewClientWithCodec(NewClientCodec(conn))
+}
+
+func Dial(network, address string) (*mrpc.Client, error) {
+	conn, err := net.Dial(network, address)
+	if err != nil {
+		return nil, err
+	}
+	return NewClient(conn), nil
+}
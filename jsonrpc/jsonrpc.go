@@ -0,0 +1,56 @@
+// jsonrpc 让已注册的mrpc服务以JSON-RPC 2.0协议对外提供，
+// Python、JS等语言的客户端不必实现gob编码和mrpc的握手帧就能调用。
+//
+// 连接上不发送Magic，直接是连续的JSON对象：
+//
+//	--> {"jsonrpc":"2.0","method":"Arith.Add","params":{"A":1,"B":2},"id":1}
+//	<-- {"jsonrpc":"2.0","id":1,"result":3}
+//
+// params可以是对象(按字段名解码到参数)，也可以是只有一个元素的数组(该元素即参数)；
+// 参数本身是切片时，其余形式的数组整体解码到参数。
+// 没有id的请求是通知，执行后不返回响应。暂不支持批量请求。
+package jsonrpc
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+const version = "2.0"
+
+// JSON-RPC 2.0规定的错误码
+const (
+	CodeParseError     = -32700
+	CodeInvalidRequest = -32600
+	CodeMethodNotFound = -32601
+	CodeInvalidParams  = -32602
+	CodeInternalError  = -32603
+	// 服务方法返回的错误
+	CodeServerError = -32000
+)
+
+// 响应中的错误对象
+type Error struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Data    any    `json:"data,omitempty"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("jsonrpc: code %d: %s", e.Code, e.Message)
+}
+
+// 按mrpc服务端的错误信息推断错误码
+func errorCode(msg string) int {
+	switch {
+	case strings.HasPrefix(msg, "rpc server: cannot find"),
+		strings.HasPrefix(msg, "rpc server: service name must be"):
+		return CodeMethodNotFound
+	case strings.HasPrefix(msg, "rpc server: read request body error"):
+		return CodeInvalidParams
+	}
+	return CodeServerError
+}
+
+var null = json.RawMessage("null")
@@ -0,0 +1,133 @@
+package jsonrpc
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/micplus/mrpc"
+)
+
+type Args struct {
+	A, B int
+}
+
+type Arith int
+
+func (*Arith) Add(args *Args, reply *int) error {
+	*reply = args.A + args.B
+	return nil
+}
+
+func (*Arith) Sum(nums []int, reply *int) error {
+	for _, n := range nums {
+		*reply += n
+	}
+	return nil
+}
+
+func (*Arith) Neg(n int, reply *int) error {
+	*reply = -n
+	return nil
+}
+
+func (*Arith) Fail(_ int, _ *int) error {
+	return errors.New("always fails")
+}
+
+func newServer(t *testing.T) *mrpc.Server {
+	s := mrpc.NewServer()
+	if err := s.Register(new(Arith)); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// 模拟其它语言的客户端，直接收发JSON文本
+func TestServerRaw(t *testing.T) {
+	c1, c2 := net.Pipe()
+	go ServeConn(newServer(t), c2)
+	defer c1.Close()
+	r := bufio.NewReader(c1)
+
+	for _, tt := range []struct {
+		req  string
+		want string
+	}{
+		{`{"jsonrpc":"2.0","method":"Arith.Add","params":{"A":1,"B":2},"id":1}`, `{"jsonrpc":"2.0","id":1,"result":3}`},
+		{`{"jsonrpc":"2.0","method":"Arith.Add","params":[{"A":3,"B":4}],"id":"a"}`, `{"jsonrpc":"2.0","id":"a","result":7}`},
+		{`{"jsonrpc":"2.0","method":"Arith.Sum","params":[1,2,3],"id":2}`, `{"jsonrpc":"2.0","id":2,"result":6}`},
+		{`{"jsonrpc":"2.0","method":"Arith.Sum","params":[5],"id":3}`, `{"jsonrpc":"2.0","id":3,"result":5}`},
+		{`{"jsonrpc":"2.0","method":"Arith.Neg","params":[5],"id":4}`, `{"jsonrpc":"2.0","id":4,"result":-5}`},
+		{`{"jsonrpc":"2.0","method":"Arith.Missing","id":5}`, `"code":-32601`},
+		{`{"jsonrpc":"2.0","method":"Arith.Add","params":{"A":"x"},"id":6}`, `"code":-32602`},
+		{`{"jsonrpc":"2.0","method":"Arith.Fail","params":[0],"id":7}`, `{"jsonrpc":"2.0","id":7,"error":{"code":-32000,"message":"always fails"}}`},
+		{`{"jsonrpc":"1.0","method":"Arith.Neg","id":8}`, `{"jsonrpc":"2.0","id":8,"error":{"code":-32600`},
+		{`[{"jsonrpc":"2.0","method":"Arith.Neg","id":9}]`, `{"jsonrpc":"2.0","id":null,"error":{"code":-32600`},
+	} {
+		if _, err := c1.Write([]byte(tt.req + "\n")); err != nil {
+			t.Fatal(err)
+		}
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(line, tt.want) {
+			t.Errorf("%s:\n got %s want %s", tt.req, line, tt.want)
+		}
+	}
+
+	// 通知没有响应，下一个请求的响应紧接着到达
+	c1.Write([]byte(`{"jsonrpc":"2.0","method":"Arith.Neg","params":[1]}` + "\n"))
+	c1.Write([]byte(`{"jsonrpc":"2.0","method":"Arith.Neg","params":[2],"id":10}` + "\n"))
+	var resp struct {
+		ID     int
+		Result int
+	}
+	line, _ := r.ReadString('\n')
+	if err := json.Unmarshal([]byte(line), &resp); err != nil || resp.ID != 10 || resp.Result != -2 {
+		t.Errorf("want response to id 10 after notification, got %s", line)
+	}
+}
+
+func TestClient(t *testing.T) {
+	c1, c2 := net.Pipe()
+	go ServeConn(newServer(t), c2)
+	client := NewClient(c1)
+	defer client.Close()
+
+	var reply int
+	err := client.Call("Arith.Add", &Args{1, 2}, &reply)
+	if err != nil || reply != 3 {
+		t.Errorf("Arith.Add = %d, %v", reply, err)
+	}
+	reply = 0
+	err = client.Call("Arith.Sum", []int{4}, &reply)
+	if err != nil || reply != 4 {
+		t.Errorf("Arith.Sum = %d, %v", reply, err)
+	}
+	err = client.Call("Arith.Fail", 0, &reply)
+	if _, ok := err.(mrpc.ServerError); !ok || err.Error() != "always fails" {
+		t.Errorf("want ServerError, got %v", err)
+	}
+}
+
+func TestWebSocket(t *testing.T) {
+	ts := httptest.NewServer(WebSocketHandler(newServer(t)))
+	defer ts.Close()
+
+	client, err := DialWebSocket("ws"+strings.TrimPrefix(ts.URL, "http"), ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	var reply int
+	err = client.Call("Arith.Neg", 42, &reply)
+	if err != nil || reply != -42 {
+		t.Errorf("Arith.Neg = %d, %v", reply, err)
+	}
+}
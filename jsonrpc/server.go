@@ -0,0 +1,196 @@
+package jsonrpc
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"reflect"
+	"sync"
+
+	"github.com/micplus/mrpc"
+	"github.com/micplus/mrpc/codec"
+)
+
+type serverRequest struct {
+	Version string          `json:"jsonrpc"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params"`
+	ID      json.RawMessage `json:"id"`
+}
+
+type serverResponse struct {
+	Version string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  any             `json:"result,omitempty"`
+	Error   *Error          `json:"error,omitempty"`
+}
+
+// 请求的id是任意JSON值，转换成服务端内部使用的序号，响应时再换回来
+type pendingRequest struct {
+	id      json.RawMessage // 为nil时是通知，不需要响应
+	invalid *Error          // 请求格式不对，直接以此响应
+}
+
+type serverCodec struct {
+	dec *json.Decoder
+	c   io.ReadWriteCloser
+	req serverRequest
+
+	mu      sync.Mutex // protect following
+	seq     uint64
+	pending map[uint64]*pendingRequest
+}
+
+var _ codec.Codec = (*serverCodec)(nil)
+
+// 在连接上按JSON-RPC 2.0解析请求
+func NewServerCodec(conn io.ReadWriteCloser) codec.Codec {
+	return &serverCodec{
+		dec:     json.NewDecoder(conn),
+		c:       conn,
+		pending: make(map[uint64]*pendingRequest),
+	}
+}
+
+func (c *serverCodec) ReadHeader(h *codec.Header) error {
+	var raw json.RawMessage
+	if err := c.dec.Decode(&raw); err != nil {
+		var syntaxErr *json.SyntaxError
+		if errors.As(err, &syntaxErr) { // 流已经无法继续解析，回一个错误后断开
+			c.writeResponse(&serverResponse{ID: null, Error: &Error{Code: CodeParseError, Message: err.Error()}})
+		}
+		return err
+	}
+
+	c.req = serverRequest{}
+	p := &pendingRequest{}
+	raw = bytes.TrimSpace(raw)
+	switch {
+	case len(raw) > 0 && raw[0] == '[':
+		p.id = null
+		p.invalid = &Error{Code: CodeInvalidRequest, Message: "batch requests are not supported"}
+	case json.Unmarshal(raw, &c.req) != nil:
+		p.id = null
+		p.invalid = &Error{Code: CodeInvalidRequest, Message: "invalid request object"}
+	default:
+		p.id = c.req.ID
+		if c.req.Version != version || c.req.Method == "" {
+			if p.id == nil {
+				p.id = null
+			}
+			p.invalid = &Error{Code: CodeInvalidRequest, Message: `"jsonrpc" must be "2.0" and "method" must be set`}
+		}
+	}
+	if p.invalid != nil { // 让服务端找不到方法，不会执行任何调用
+		c.req = serverRequest{}
+	}
+
+	c.mu.Lock()
+	c.seq++
+	c.pending[c.seq] = p
+	h.Seq = c.seq
+	c.mu.Unlock()
+	h.Name = c.req.Method
+	h.Error = ""
+	return nil
+}
+
+func (c *serverCodec) ReadBody(x any) error {
+	if x == nil {
+		return nil
+	}
+	params := bytes.TrimSpace(c.req.Params)
+	if len(params) == 0 || bytes.Equal(params, null) {
+		return nil
+	}
+	if params[0] == '[' {
+		var elems []json.RawMessage
+		if err := json.Unmarshal(params, &elems); err != nil {
+			return err
+		}
+		if len(elems) == 1 && !(isList(x) && !isArray(elems[0])) {
+			params = elems[0]
+		}
+	}
+	return json.Unmarshal(params, x)
+}
+
+// x指向切片或数组([]byte除外，它在JSON中是字符串)
+func isList(x any) bool {
+	t := reflect.TypeOf(x)
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	return t.Kind() == reflect.Array || t.Kind() == reflect.Slice && t.Elem().Kind() != reflect.Uint8
+}
+
+func isArray(raw json.RawMessage) bool {
+	raw = bytes.TrimSpace(raw)
+	return len(raw) > 0 && raw[0] == '['
+}
+
+func (c *serverCodec) Write(h *codec.Header, body any) error {
+	c.mu.Lock()
+	p, ok := c.pending[h.Seq]
+	delete(c.pending, h.Seq)
+	c.mu.Unlock()
+	if !ok {
+		return errors.New("rpc jsonrpc: invalid sequence number in response")
+	}
+
+	resp := &serverResponse{ID: p.id}
+	switch {
+	case p.invalid != nil:
+		resp.Error = p.invalid
+	case p.id == nil: // 通知
+		return nil
+	case h.Error != "":
+		resp.Error = &Error{Code: errorCode(h.Error), Message: h.Error}
+	default:
+		resp.Result = body
+	}
+	return c.writeResponse(resp)
+}
+
+// 每个响应一次性写出，在WebSocket上正好是一帧
+func (c *serverCodec) writeResponse(resp *serverResponse) error {
+	resp.Version = version
+	b, err := json.Marshal(resp)
+	if err != nil {
+		log.Println("rpc jsonrpc: encoding response error:", err)
+		resp.Result = nil
+		resp.Error = &Error{Code: CodeInternalError, Message: err.Error()}
+		if b, err = json.Marshal(resp); err != nil {
+			return err
+		}
+	}
+	_, err = c.c.Write(append(b, '\n'))
+	return err
+}
+
+func (c *serverCodec) Close() error {
+	return c.c.Close()
+}
+
+// 以JSON-RPC 2.0协议服务一条连接，阻塞到连接断开
+func ServeConn(s *mrpc.Server, conn io.ReadWriteCloser) {
+	s.ServeCodec(NewServerCodec(conn))
+}
+
+// 循环接受连接并以JSON-RPC 2.0协议服务，listener关闭后返回
+func Accept(s *mrpc.Server, lis net.Listener) {
+	for {
+		conn, err := lis.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			log.Println("rpc jsonrpc: listener accept error:", err)
+			continue
+		}
+		go ServeConn(s, conn)
+	}
+}
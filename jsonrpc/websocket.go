@@ -0,0 +1,27 @@
+package jsonrpc
+
+import (
+	"net/http"
+
+	"github.com/micplus/mrpc"
+	"golang.org/x/net/websocket"
+)
+
+// 在WebSocket上提供JSON-RPC 2.0服务，每个请求和响应各占一个文本帧
+//
+//	http.Handle("/rpc", jsonrpc.WebSocketHandler(server))
+func WebSocketHandler(s *mrpc.Server) http.Handler {
+	return websocket.Handler(func(ws *websocket.Conn) {
+		ws.PayloadType = websocket.TextFrame
+		ServeConn(s, ws)
+	})
+}
+
+// 通过WebSocket连接服务端，url形如"ws://host/rpc"
+func DialWebSocket(url, origin string) (*mrpc.Client, error) {
+	ws, err := websocket.Dial(url, "", origin)
+	if err != nil {
+		return nil, err
+	}
+	return NewClient(ws), nil
+}
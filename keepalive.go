@@ -0,0 +1,145 @@
+package mrpc
+
+import (
+	"errors"
+	"io"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/micplus/mrpc/codec"
+)
+
+// 保活：服务端关闭空闲太久的连接，并在握手后以控制帧告知客户端空闲超时和希望的心跳间隔，
+// 客户端据此定时发送心跳帧，不会在没有调用时被意外回收。
+// 心跳帧与其它控制帧一样Seq为0，旧版本的服务端把它当作找不到方法的请求回复，客户端丢弃回复
+
+// 告知保活参数的控制帧名称，消息体是keepaliveParams
+const keepaliveFrame = "mrpc.keepalive"
+
+// 客户端心跳帧的名称，没有消息体
+const pingFrame = "mrpc.ping"
+
+var errPingFrame = errors.New("rpc server: ping frame")
+
+type keepaliveParams struct {
+	IdleTimeout  time.Duration // 服务端关闭空闲连接的时间，0表示不关闭
+	PingInterval time.Duration // 希望客户端发送心跳的间隔
+}
+
+// 没有收到任何帧、也没有请求在处理超过idleTimeout的连接会被关闭，<=0时不关闭(默认)。
+// pingInterval是告诉客户端的心跳间隔，<=0时取idleTimeout的一半。
+// 两者都<=0时不发送保活参数
+func WithKeepalive(idleTimeout, pingInterval time.Duration) ServerOption {
+	return func(s *Server) {
+		s.keepalive = keepaliveParams{IdleTimeout: max(idleTimeout, 0), PingInterval: pingInterval}
+		if pingInterval <= 0 {
+			s.keepalive.PingInterval = s.keepalive.IdleTimeout / 2
+		}
+	}
+}
+
+// 客户端自己的心跳间隔，<=0时不主动发心跳(默认)。
+// 服务端告知了保活参数时，取两者中较短的间隔。NewClientWithCodec创建的客户端不发心跳
+func WithClientKeepalive(pingInterval time.Duration) ClientOption {
+	return func(o *clientOptions) {
+		o.pingInterval = pingInterval
+	}
+}
+
+// 握手后告知客户端保活参数
+func (w *responseWriter) announceKeepalive(p keepaliveParams) {
+	if w.frames && p.PingInterval > 0 {
+		w.write(&codec.Header{Name: keepaliveFrame}, p)
+	}
+}
+
+// 连接的空闲检测，为nil时不检测
+type idleTracker struct {
+	clock  Clock
+	last   atomic.Int64 // 最后一次读到帧的时间
+	active atomic.Int32 // 正在处理的请求数
+}
+
+func newIdleTracker(clock Clock) *idleTracker {
+	t := &idleTracker{clock: clock}
+	t.read()
+	return t
+}
+
+// 读到一帧
+func (t *idleTracker) read() {
+	if t != nil {
+		t.last.Store(t.clock.Now().UnixNano())
+	}
+}
+
+func (t *idleTracker) begin() {
+	if t != nil {
+		t.active.Add(1)
+	}
+}
+
+// 请求处理完也算一次活动，处理得久的请求结束后不会马上被判为空闲
+func (t *idleTracker) end() {
+	if t != nil {
+		t.read()
+		t.active.Add(-1)
+	}
+}
+
+// 每隔timeout的四分之一检查一次，空闲超过timeout时关闭连接，done关闭时退出
+func (t *idleTracker) watch(timeout time.Duration, conn io.Closer, done <-chan struct{}) {
+	ticker := t.clock.NewTicker(max(timeout/4, time.Millisecond))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case now := <-ticker.C():
+			idle := now.Sub(time.Unix(0, t.last.Load()))
+			if t.active.Load() == 0 && idle >= timeout {
+				log.Printf("rpc server: closing connection idle for %v", idle)
+				conn.Close()
+				return
+			}
+		}
+	}
+}
+
+// 按间隔发送心跳，已经在发且间隔不更长时不变。只在newClient和receive中调用，
+// receive结束时停止
+func (c *Client) startPing(interval time.Duration) {
+	if interval <= 0 || c.pingStop != nil && interval >= c.pingInterval {
+		return
+	}
+	if c.pingStop != nil {
+		close(c.pingStop)
+	}
+	c.pingInterval = interval
+	c.pingStop = make(chan struct{})
+	go func(stop <-chan struct{}) {
+		t := c.clock.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-t.C():
+				if err := c.ping(); err != nil {
+					return
+				}
+			}
+		}
+	}(c.pingStop)
+}
+
+// 半关闭之后服务端不再接受任何请求帧，也就不再发心跳
+func (c *Client) ping() error {
+	c.sending.Lock()
+	defer c.sending.Unlock()
+	if c.closing.Load() || c.shutdown.Load() || c.sendClosed.Load() {
+		return ErrShutDown
+	}
+	return c.cc.Write(&codec.Header{Name: pingFrame}, invalidRequest)
+}
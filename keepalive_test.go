@@ -0,0 +1,73 @@
+package mrpc
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func startKeepaliveServer(t *testing.T, idle, ping time.Duration) string {
+	s := NewServer(WithKeepalive(idle, ping))
+	s.Register(new(Calc))
+	s.Register(new(Gauge))
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go s.Accept(l)
+	return l.Addr().String()
+}
+
+func TestKeepalive(t *testing.T) {
+	addr := startKeepaliveServer(t, 100*time.Millisecond, 20*time.Millisecond)
+	client, err := Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	var sum int
+	err = client.Call("Calc.Sum", Pair{1, 2}, &sum)
+	assert(t, err == nil && client.pingInterval == 20*time.Millisecond, "want negotiated ping interval, got %v %v", client.pingInterval, err)
+
+	// 心跳让空闲的连接保持打开
+	time.Sleep(300 * time.Millisecond)
+	err = client.Call("Calc.Sum", Pair{1, 2}, &sum)
+	assert(t, err == nil && client.IsAvailable(), "pinged connection should stay open: %v", err)
+
+	// 不发心跳的连接被关闭
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint32(buf, Magic)
+	conn.Write(buf)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = io.Copy(io.Discard, conn)
+	assert(t, err == nil, "idle connection should be closed by the server, got %v", err)
+}
+
+func TestKeepaliveActiveRequest(t *testing.T) {
+	// 心跳间隔比空闲超时还长，连接只靠处理中的请求保持
+	addr := startKeepaliveServer(t, 50*time.Millisecond, time.Hour)
+	client, err := Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	err = client.Call("Gauge.Hold", 200*time.Millisecond, new(int))
+	assert(t, err == nil, "long request should not be reaped: %v", err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for state := client.GetState(); state != Shutdown; state = client.GetState() {
+		if !client.WaitForStateChange(ctx, state) {
+			t.Fatalf("idle connection not closed, state %v", state)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package mrpc
+
+import (
+	"context"
+	"log"
+	"math/rand/v2"
+	"time"
+)
+
+// 请求日志的采样规则，调用量大时只记录一部分，出错和慢的调用可以全部记录：
+//
+//	s := mrpc.NewServer(mrpc.WithRequestLogging(log.Default(), mrpc.LogSampling{
+//		Success: 0.01, Error: 1, Slow: 100 * time.Millisecond,
+//	}))
+type LogSampling struct {
+	Success float64       // 记录成功调用的比例，0~1
+	Error   float64       // 记录出错调用的比例，0~1
+	Slow    time.Duration // 耗时不少于Slow的调用总是记录，<=0时不按耗时记录
+}
+
+// 成功调用记1%，错误全记，超过1秒的全记
+var DefaultLogSampling = LogSampling{Success: 0.01, Error: 1, Slow: time.Second}
+
+// 按采样规则，调用结束时记一行日志，形如
+//
+//	rpc server: Arith.Div seq=12 1.52ms error=divide by zero
+//
+// 由一个StatsHandler实现，与其它钩子互不影响
+type loggingHandler struct {
+	l        *log.Logger
+	side     string // "rpc client: "或"rpc server: "
+	sampling LogSampling
+}
+
+func newLoggingHandler(l *log.Logger, sampling LogSampling, client bool) *loggingHandler {
+	side := "rpc server: "
+	if client {
+		side = "rpc client: "
+	}
+	return &loggingHandler{l: l, side: side, sampling: sampling}
+}
+
+// 以概率p返回true，0和1不取随机数
+func sample(p float64) bool {
+	return p >= 1 || p > 0 && rand.Float64() < p
+}
+
+func (h *loggingHandler) HandleRPC(_ context.Context, s RPCStats) {
+	e, ok := s.(*End)
+	if !ok {
+		return
+	}
+	elapsed := e.EndTime.Sub(e.BeginTime)
+	slow := h.sampling.Slow > 0 && elapsed >= h.sampling.Slow
+	switch {
+	case e.Error != nil:
+		if slow || sample(h.sampling.Error) {
+			h.l.Printf("%s%s seq=%d %v error=%v", h.side, e.Method, e.Seq, elapsed, e.Error)
+		}
+	case slow:
+		h.l.Printf("%s%s seq=%d %v slow", h.side, e.Method, e.Seq, elapsed)
+	case sample(h.sampling.Success):
+		h.l.Printf("%s%s seq=%d %v", h.side, e.Method, e.Seq, elapsed)
+	}
+}
+
+func (h *loggingHandler) HandleConn(ConnStats) {}
+
+// 记录到日志的消息体的最大长度
+const maxLoggedPayload = 1024
+
+// 记录参数和返回值的中间件，调试用。匹配r的字段和类型，以及带`mrpc:"redact"`标签的字段记为[REDACTED]，
+// 敏感数据不会出现在日志中：
+//
+//	s.Register(new(Account), mrpc.WithMiddleware(mrpc.PayloadLogging(nil, mrpc.Redaction{
+//		Fields: []string{"Password", "Token"},
+//	})))
+//
+// 每次调用记一行，形如
+//
+//	rpc server: Account.Login 1.52ms arg={User:alice Password:[REDACTED]} reply={Session:[REDACTED]}
+//
+// 出错时不记返回值而是记错误。消息体截断到1KB，l为nil时使用log.Default()
+func PayloadLogging(l *log.Logger, r Redaction) Middleware {
+	if l == nil {
+		l = log.Default()
+	}
+	rd := newRedactor(r)
+	return func(ctx context.Context, method string, arg, reply any, next func(ctx context.Context) error) error {
+		start := time.Now()
+		err := next(ctx)
+		elapsed := time.Since(start)
+		if err != nil {
+			l.Printf("rpc server: %s %v arg=%s error=%v", method, elapsed, rd.format(arg, maxLoggedPayload), err)
+		} else {
+			l.Printf("rpc server: %s %v arg=%s reply=%s", method, elapsed, rd.format(arg, maxLoggedPayload), rd.format(reply, maxLoggedPayload))
+		}
+		return err
+	}
+}
@@ -0,0 +1,138 @@
+package mrpc
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 并发写日志的缓冲
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestRequestLogging(t *testing.T) {
+	var sbuf, cbuf syncBuffer
+	sampling := LogSampling{Success: 0, Error: 1, Slow: 20 * time.Millisecond}
+	s := NewServer(WithRequestLogging(log.New(&sbuf, "", 0), sampling))
+	HandleFunc(s, "Job.Run", func(_ context.Context, n int, _ *int) error {
+		switch {
+		case n < 0:
+			return errors.New("negative")
+		case n == 0:
+			time.Sleep(30 * time.Millisecond)
+		}
+		return nil
+	})
+	c1, c2 := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		s.ServeConn(c2)
+		close(done)
+	}()
+	client, err := NewClientOptions(c1, WithClientRequestLogging(log.New(&cbuf, "", 0), LogSampling{Success: 1, Error: 0}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 10; i++ {
+		client.Call("Job.Run", i, new(int))
+	}
+	client.Call("Job.Run", -1, new(int))
+	client.Call("Job.Run", 0, new(int))
+	client.Close()
+	<-done
+
+	epoch := client.seq.Load() &^ seqCounterMask
+	lines := strings.Split(strings.TrimSpace(sbuf.String()), "\n")
+	assert(t, len(lines) == 2, "want error and slow call logged, got\n%s", sbuf.String())
+	assert(t, strings.HasPrefix(lines[0], fmt.Sprintf("rpc server: Job.Run seq=%d ", epoch|11)) && strings.HasSuffix(lines[0], "error=negative"),
+		"unexpected error line %q", lines[0])
+	assert(t, strings.HasPrefix(lines[1], fmt.Sprintf("rpc server: Job.Run seq=%d ", epoch|12)) && strings.HasSuffix(lines[1], " slow"),
+		"unexpected slow line %q", lines[1])
+	assert(t, strings.Count(cbuf.String(), "rpc client: Job.Run") == 11 && !strings.Contains(cbuf.String(), "error="),
+		"client should log successes only, got\n%s", cbuf.String())
+}
+
+func TestSample(t *testing.T) {
+	n := 0
+	for i := 0; i < 10000; i++ {
+		if sample(0.1) {
+			n++
+		}
+		assert(t, !sample(0) && sample(1), "0 and 1 should be exact")
+	}
+	assert(t, n > 800 && n < 1200, "sampled %d of 10000 at 10%%", n)
+}
+
+type Credentials struct {
+	User     string
+	Password string
+	Token    string `mrpc:"redact"`
+	Key      *SecretKey
+	Extra    map[string]string
+	When     time.Time
+}
+
+type SecretKey struct {
+	ID string
+}
+
+func TestPayloadLogging(t *testing.T) {
+	var buf syncBuffer
+	s := NewServer()
+	HandleFunc(s, "Auth.Login", func(_ context.Context, c Credentials, reply *Credentials) error {
+		if c.User == "" {
+			return errors.New("no user")
+		}
+		*reply = c
+		return nil
+	})
+	s.SetServiceOptions("Auth", WithMiddleware(PayloadLogging(log.New(&buf, "", 0), Redaction{
+		Fields: []string{"Password", "pin"},
+		Types:  []reflect.Type{reflect.TypeFor[SecretKey]()},
+	})))
+	client, err := ConnectInProcess(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	when := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	c := Credentials{User: "alice", Password: "hunter2", Token: "tok", Key: &SecretKey{ID: "k1"},
+		Extra: map[string]string{"pin": "1234"}, When: when}
+	err = client.Call("Auth.Login", c, new(Credentials))
+	assert(t, err == nil, "call: %v", err)
+	client.Call("Auth.Login", Credentials{Password: "hunter2"}, new(Credentials))
+
+	out := buf.String()
+	for _, secret := range []string{"hunter2", "tok", "k1", "1234"} {
+		assert(t, !strings.Contains(out, secret), "%q leaked into log:\n%s", secret, out)
+	}
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	assert(t, len(lines) == 2, "want 2 lines, got\n%s", out)
+	arg := "arg={User:alice Password:[REDACTED] Token:[REDACTED] Key:[REDACTED] Extra:map[pin:[REDACTED]] When:" + when.String() + "}"
+	assert(t, strings.HasPrefix(lines[0], "rpc server: Auth.Login ") && strings.Contains(lines[0], arg+" reply={User:alice"),
+		"unexpected line %q", lines[0])
+	assert(t, strings.HasSuffix(lines[1], "error=no user") && !strings.Contains(lines[1], "reply="), "unexpected line %q", lines[1])
+}
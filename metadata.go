@@ -0,0 +1,59 @@
+package mrpc
+
+import "context"
+
+// 随请求传递的键值对，放在请求头里，不属于方法参数。
+// 客户端用WithOutgoingMetadata附加到ctx再CallContext，
+// 服务端方法声明ctx参数后用IncomingMetadata读取：
+//
+//	func (*Arith) Add(ctx context.Context, args *Args, reply *int) error {
+//		user := mrpc.IncomingMetadata(ctx)["user"]
+//		...
+//	}
+type Metadata map[string]string
+
+// 复制一份，修改时不影响原来的
+func (md Metadata) Clone() Metadata {
+	if md == nil {
+		return nil
+	}
+	out := make(Metadata, len(md))
+	for k, v := range md {
+		out[k] = v
+	}
+	return out
+}
+
+type outgoingKey struct{}
+type incomingKey struct{}
+
+// 附加要发送的元数据，与ctx中已有的合并，同名的以md为准
+func WithOutgoingMetadata(ctx context.Context, md Metadata) context.Context {
+	merged := OutgoingMetadata(ctx).Clone()
+	if merged == nil {
+		merged = make(Metadata, len(md))
+	}
+	for k, v := range md {
+		merged[k] = v
+	}
+	return context.WithValue(ctx, outgoingKey{}, merged)
+}
+
+// 将要随调用发送的元数据
+func OutgoingMetadata(ctx context.Context) Metadata {
+	md, _ := ctx.Value(outgoingKey{}).(Metadata)
+	return md
+}
+
+// 服务端收到的元数据。不会自动转发给下游，需要时显式地WithOutgoingMetadata
+func IncomingMetadata(ctx context.Context) Metadata {
+	md, _ := ctx.Value(incomingKey{}).(Metadata)
+	return md
+}
+
+func withIncomingMetadata(ctx context.Context, md Metadata) context.Context {
+	if md == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, incomingKey{}, md)
+}
@@ -0,0 +1,44 @@
+package mrpc
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+type Greeter int
+
+func (*Greeter) Hello(ctx context.Context, name string, reply *string) error {
+	*reply = IncomingMetadata(ctx)["greeting"] + ", " + name
+	return nil
+}
+
+func (*Greeter) Slow(ctx context.Context, d time.Duration, reply *int) error {
+	time.Sleep(d)
+	return nil
+}
+
+func TestCallContextMetadata(t *testing.T) {
+	client, _, err := NewClientServerPair(new(Greeter))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	ctx := WithOutgoingMetadata(context.Background(), Metadata{"greeting": "hi", "user": "a"})
+	ctx = WithOutgoingMetadata(ctx, Metadata{"greeting": "hello"})
+	var reply string
+	err = client.CallContext(ctx, "Greeter.Hello", "mrpc", &reply)
+	assert(t, err == nil && reply == "hello, mrpc", "Greeter.Hello = %q, %v", reply, err)
+	assert(t, len(OutgoingMetadata(ctx)) == 2, "metadata should be merged")
+
+	// 不带元数据的普通调用同样适用于ctx方法
+	err = client.Call("Greeter.Hello", "mrpc", &reply)
+	assert(t, err == nil && reply == ", mrpc", "Greeter.Hello = %q, %v", reply, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err = client.CallContext(ctx, "Greeter.Slow", time.Second, new(int))
+	assert(t, errors.Is(err, context.DeadlineExceeded), "want deadline exceeded, got %v", err)
+}
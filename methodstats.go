@@ -0,0 +1,164 @@
+package mrpc
+
+import (
+	"math/bits"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// 方法的调用统计：调用数、错误数和耗时分布，不依赖外部工具就能找出热点和慢方法
+//
+//	for _, st := range server.MethodStats() {
+//		fmt.Println(st.Name, st.Calls, st.Latency.Quantile(0.99))
+//	}
+
+// 耗时分桶的个数，第i个桶统计耗时小于2^i微秒的调用(前一个桶之外的)，
+// 最后一个桶收下所有更慢的
+const latencyBuckets = 32
+
+// 一个方法运行时的统计，全部是原子操作
+type methodStats struct {
+	errors  atomic.Uint64
+	active  atomic.Int64 // 正在执行的调用数
+	sum     atomic.Int64 // 纳秒
+	max     atomic.Int64
+	buckets [latencyBuckets]atomic.Uint64
+	lastErr atomic.Pointer[lastError]
+}
+
+type lastError struct {
+	msg  string
+	time time.Time
+}
+
+func latencyBucket(d time.Duration) int {
+	i := bits.Len64(uint64(d / time.Microsecond))
+	return min(i, latencyBuckets-1)
+}
+
+func (st *methodStats) record(d time.Duration, err error) {
+	if err != nil {
+		st.errors.Add(1)
+		st.lastErr.Store(&lastError{err.Error(), time.Now()})
+	}
+	st.sum.Add(int64(d))
+	for {
+		m := st.max.Load()
+		if int64(d) <= m || st.max.CompareAndSwap(m, int64(d)) {
+			break
+		}
+	}
+	st.buckets[latencyBucket(d)].Add(1)
+}
+
+// 清零累计的统计，正在执行的调用数不变
+func (mt *methodType) resetStats() {
+	atomic.StoreUint64(&mt.numCalls, 0)
+	st := &mt.stats
+	st.errors.Store(0)
+	st.sum.Store(0)
+	st.max.Store(0)
+	for i := range st.buckets {
+		st.buckets[i].Store(0)
+	}
+	st.lastErr.Store(nil)
+}
+
+// 某一时刻的统计快照
+type MethodStats struct {
+	Name          string // "Service.Method"
+	Calls         uint64
+	Errors        uint64
+	Active        int64     // 正在执行的调用数
+	LastError     string    // 最近一次的错误，没有出过错时为空
+	LastErrorTime time.Time // 最近一次出错的时间
+	Latency       LatencyStats
+}
+
+// 耗时分布，Buckets[i]是耗时在[BucketBound(i-1), BucketBound(i))之间的调用数
+type LatencyStats struct {
+	Count   uint64
+	Sum     time.Duration
+	Max     time.Duration
+	Buckets [latencyBuckets]uint64
+}
+
+// 第i个桶的上界，最后一个桶实际上没有上界
+func BucketBound(i int) time.Duration {
+	return time.Duration(1<<i) * time.Microsecond
+}
+
+func (l LatencyStats) Mean() time.Duration {
+	if l.Count == 0 {
+		return 0
+	}
+	return l.Sum / time.Duration(l.Count)
+}
+
+// 估计分位数，返回所在桶的上界(不超过Max)，q在0到1之间
+func (l LatencyStats) Quantile(q float64) time.Duration {
+	if l.Count == 0 {
+		return 0
+	}
+	rank := uint64(q * float64(l.Count))
+	var seen uint64
+	for i, n := range l.Buckets {
+		seen += n
+		if seen > rank || seen == l.Count {
+			if i == latencyBuckets-1 { // 最后一个桶没有上界
+				return l.Max
+			}
+			return min(BucketBound(i), l.Max)
+		}
+	}
+	return l.Max
+}
+
+func (mt *methodType) snapshot(name string) MethodStats {
+	st := MethodStats{Name: name, Calls: mt.NumCalls(), Errors: mt.stats.errors.Load(), Active: mt.stats.active.Load()}
+	if e := mt.stats.lastErr.Load(); e != nil {
+		st.LastError, st.LastErrorTime = e.msg, e.time
+	}
+	st.Latency.Sum = time.Duration(mt.stats.sum.Load())
+	st.Latency.Max = time.Duration(mt.stats.max.Load())
+	for i := range st.Latency.Buckets {
+		n := mt.stats.buckets[i].Load()
+		st.Latency.Buckets[i] = n
+		st.Latency.Count += n
+	}
+	return st
+}
+
+// 所有方法的统计，包括已经用到的动态方法，按名称排序
+func (s *Server) MethodStats() []MethodStats {
+	var stats []MethodStats
+	s.rangeMethods(func(name string, mt *methodType) {
+		stats = append(stats, mt.snapshot(name))
+	})
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Name < stats[j].Name })
+	return stats
+}
+
+// 清零所有方法的统计，比如在每个上报周期之后。各个计数分别清零，
+// 与同时进行的调用之间没有原子性，刚清零时的快照可能略有出入
+func (s *Server) ResetMethodStats() {
+	s.rangeMethods(func(_ string, mt *methodType) {
+		mt.resetStats()
+	})
+}
+
+func (s *Server) rangeMethods(f func(name string, mt *methodType)) {
+	for _, svc := range s.serviceMap {
+		for name, mt := range svc.method {
+			f(svc.name+"."+name, mt)
+		}
+	}
+	s.dynamic.Range(func(name, dm any) bool {
+		f(name.(string), dm.(*dynamicMethod).mt)
+		return true
+	})
+	if s.fallback != nil {
+		f(s.fallback.mt.name, s.fallback.mt)
+	}
+}
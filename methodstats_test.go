@@ -0,0 +1,81 @@
+package mrpc
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLatencyStats(t *testing.T) {
+	mt := &methodType{numCalls: 5}
+	for _, d := range []time.Duration{500 * time.Nanosecond, 3 * time.Microsecond, 3 * time.Microsecond, time.Millisecond} {
+		mt.stats.record(d, nil)
+	}
+	mt.stats.record(time.Hour, errors.New("slow"))
+	got := mt.snapshot("A.B")
+	l := got.Latency
+	assert(t, got.Calls == 5 && got.Errors == 1 && l.Count == 5, "unexpected stats %+v", got)
+	assert(t, l.Buckets[0] == 1 && l.Buckets[2] == 2, "unexpected buckets %v", l.Buckets)
+	assert(t, l.Quantile(0.5) == 4*time.Microsecond, "p50 = %v", l.Quantile(0.5))
+	assert(t, l.Quantile(1) == time.Hour && l.Max == time.Hour, "p100 = %v", l.Quantile(1))
+}
+
+func TestMethodStats(t *testing.T) {
+	s := NewServer()
+	s.Register(new(Calc))
+	HandleFunc(s, "Calc.Fail", func(context.Context, int, *int) error { return errors.New("fail") })
+	client, err := ConnectInProcess(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	var sum int
+	client.Call("Calc.Sum", Pair{1, 2}, &sum)
+	client.Call("Calc.Fail", 1, &sum)
+
+	stats := s.MethodStats()
+	if len(stats) != 2 {
+		t.Fatalf("want 2 methods, got %+v", stats)
+	}
+	assert(t, stats[0].Name == "Calc.Fail" && stats[0].Calls == 1 && stats[0].Errors == 1, "unexpected %+v", stats[0])
+	assert(t, stats[1].Name == "Calc.Sum" && stats[1].Errors == 0 && stats[1].Latency.Count == 1, "unexpected %+v", stats[1])
+
+	rec := httptest.NewRecorder()
+	s.DebugHandler().ServeHTTP(rec, httptest.NewRequest("GET", DefaultDebugPath, nil))
+	body := rec.Body.String()
+	assert(t, strings.Contains(body, "<td>Calc.Sum</td>") && strings.Contains(body, "<td>Calc.Fail</td>"),
+		"debug page missing methods:\n%s", body)
+}
+
+func TestMethodStatsActiveAndReset(t *testing.T) {
+	s := NewServer()
+	release, started := make(chan struct{}), make(chan struct{})
+	HandleFunc(s, "Work.Block", func(context.Context, int, *int) error {
+		started <- struct{}{}
+		<-release
+		return errors.New("released")
+	})
+	client, err := ConnectInProcess(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	call := client.Go("Work.Block", 1, new(int), nil)
+	<-started
+	st := s.MethodStats()[0]
+	assert(t, st.Active == 1 && st.Calls == 1 && st.LastError == "", "while running: %+v", st)
+	close(release)
+	<-call.Done
+	st = s.MethodStats()[0]
+	assert(t, st.Active == 0 && st.Errors == 1 && st.LastError == "released" && !st.LastErrorTime.IsZero(),
+		"after error: %+v", st)
+
+	s.ResetMethodStats()
+	st = s.MethodStats()[0]
+	assert(t, st.Calls == 0 && st.Errors == 0 && st.LastError == "" && st.Latency.Count == 0 && st.Latency.Max == 0,
+		"after reset: %+v", st)
+}
@@ -0,0 +1,150 @@
+package mrpc
+
+import (
+	"context"
+	"sync"
+
+	"github.com/micplus/mrpc/metrics"
+)
+
+// 客户端和服务端记录的指标，名称以"client_"或"server_"开头：
+//
+//	connections              gauge     当前连接数
+//	requests_total           counter   调用数，按method
+//	errors_total             counter   出错的调用数，按method
+//	request_seconds          histogram 调用耗时，按method
+//	bytes_read_total         counter   读到的字节数
+//	bytes_written_total      counter   写出的字节数
+//
+// 服务端的method标签是"Service.Method"。客户端的调用指标按service、method(不含服务名)、
+// target(服务端地址)分开，requests_total还按code(调用结果的错误码，如"OK")分开，
+// 仪表盘可以直接按实例、错误码拆分流量
+//
+// 由一个StatsHandler实现，与WithStatsHandler设置的钩子互不影响
+
+type metricsHandler struct {
+	m       metrics.Metrics
+	side    string // "client_"或"server_"
+	conns   metrics.Gauge
+	read    metrics.Counter
+	written metrics.Counter
+
+	client  bool
+	methods sync.Map // methodKey -> *methodMetrics
+}
+
+type methodKey struct {
+	method, target string
+}
+
+type methodMetrics struct {
+	labels   []string
+	requests metrics.Counter // 服务端使用，客户端按错误码分开记在codes中
+	codes    sync.Map        // Code -> metrics.Counter
+	errors   metrics.Counter
+	latency  metrics.Histogram
+}
+
+func newMetricsHandler(m metrics.Metrics, client bool) *metricsHandler {
+	side := "server_"
+	if client {
+		side = "client_"
+	}
+	return &metricsHandler{
+		m:       m,
+		side:    side,
+		client:  client,
+		conns:   m.Gauge(side + "connections"),
+		read:    m.Counter(side + "bytes_read_total"),
+		written: m.Counter(side + "bytes_written_total"),
+	}
+}
+
+func (h *metricsHandler) method(name, target string) *methodMetrics {
+	k := methodKey{name, target}
+	if mm, ok := h.methods.Load(k); ok {
+		return mm.(*methodMetrics)
+	}
+	labels := []string{"method", name}
+	if h.client {
+		service, method := splitMethod(name)
+		labels = []string{"service", service, "method", method, "target", target}
+	}
+	mm := &methodMetrics{
+		labels:  labels,
+		errors:  h.m.Counter(h.side+"errors_total", labels...),
+		latency: h.m.Histogram(h.side+"request_seconds", labels...),
+	}
+	if !h.client {
+		mm.requests = h.m.Counter(h.side+"requests_total", labels...)
+	}
+	actual, _ := h.methods.LoadOrStore(k, mm)
+	return actual.(*methodMetrics)
+}
+
+// 按错误码分开的调用数
+func (h *metricsHandler) requests(mm *methodMetrics, code Code) metrics.Counter {
+	if mm.requests != nil {
+		return mm.requests
+	}
+	if c, ok := mm.codes.Load(code); ok {
+		return c.(metrics.Counter)
+	}
+	labels := append(mm.labels[:len(mm.labels):len(mm.labels)], "code", code.String())
+	c, _ := mm.codes.LoadOrStore(code, h.m.Counter(h.side+"requests_total", labels...))
+	return c.(metrics.Counter)
+}
+
+func (h *metricsHandler) HandleRPC(_ context.Context, s RPCStats) {
+	switch s := s.(type) {
+	case *InPayload:
+		h.read.Add(float64(s.Length))
+	case *OutPayload:
+		h.written.Add(float64(s.Length))
+	case *End:
+		mm := h.method(s.Method, s.Target)
+		h.requests(mm, s.Code).Add(1)
+		if s.Error != nil {
+			mm.errors.Add(1)
+		}
+		mm.latency.Observe(s.EndTime.Sub(s.BeginTime).Seconds())
+	}
+}
+
+func (h *metricsHandler) HandleConn(s ConnStats) {
+	switch s.(type) {
+	case *ConnBegin:
+		h.conns.Add(1)
+	case *ConnEnd:
+		h.conns.Add(-1)
+	}
+}
+
+// 依次调用多个StatsHandler
+type multiStats []StatsHandler
+
+func (ms multiStats) HandleRPC(ctx context.Context, s RPCStats) {
+	for _, h := range ms {
+		h.HandleRPC(ctx, s)
+	}
+}
+
+func (ms multiStats) HandleConn(s ConnStats) {
+	for _, h := range ms {
+		h.HandleConn(s)
+	}
+}
+
+// 在已有的钩子之后追加h
+func chainStats(prev, h StatsHandler) StatsHandler {
+	switch {
+	case h == nil:
+		return prev
+	case prev == nil:
+		return h
+	}
+	if ms, ok := prev.(multiStats); ok {
+		return append(ms[:len(ms):len(ms)], h)
+	}
+	return multiStats{prev, h}
+}
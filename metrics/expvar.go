@@ -0,0 +1,114 @@
+package metrics
+
+import (
+	"encoding/json"
+	"expvar"
+	"math"
+	"strings"
+	"sync"
+)
+
+// 发布到expvar的指标，在/debug/vars中的结构为：
+//
+//	"namespace": {
+//		"name": 1,                                  // 没有标签的指标
+//		"name": {"method=Arith.Add": 1, ...},       // 有标签的指标，按标签分开
+//	}
+//
+// 直方图发布为{"count":..,"sum":..,"min":..,"max":..}
+type Expvar struct {
+	mu   sync.Mutex // protect creating vars
+	root *expvar.Map
+}
+
+// 在expvar中发布名为namespace的map，同名的已经存在时复用它
+func NewExpvar(namespace string) *Expvar {
+	if m, ok := expvar.Get(namespace).(*expvar.Map); ok {
+		return &Expvar{root: m}
+	}
+	return &Expvar{root: expvar.NewMap(namespace)}
+}
+
+func (e *Expvar) Counter(name string, labels ...string) Counter {
+	return e.get(name, labels, func() expvar.Var { return new(expvar.Float) }).(*expvar.Float)
+}
+
+func (e *Expvar) Gauge(name string, labels ...string) Gauge {
+	return e.get(name, labels, func() expvar.Var { return new(expvar.Float) }).(*expvar.Float)
+}
+
+func (e *Expvar) Histogram(name string, labels ...string) Histogram {
+	return e.get(name, labels, func() expvar.Var { return new(histogram) }).(*histogram)
+}
+
+// 取已有的指标或者创建一个，类型与已有的不符时会panic，属于使用错误
+func (e *Expvar) get(name string, labels []string, newVar func() expvar.Var) expvar.Var {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if len(labels) == 0 {
+		if v := e.root.Get(name); v != nil {
+			return v
+		}
+		v := newVar()
+		e.root.Set(name, v)
+		return v
+	}
+	m, ok := e.root.Get(name).(*expvar.Map)
+	if !ok {
+		m = new(expvar.Map)
+		e.root.Set(name, m)
+	}
+	key := labelKey(labels)
+	if v := m.Get(key); v != nil {
+		return v
+	}
+	v := newVar()
+	m.Set(key, v)
+	return v
+}
+
+// "method", "Arith.Add", "code", "ok" -> "method=Arith.Add,code=ok"
+func labelKey(labels []string) string {
+	var b strings.Builder
+	for i := 0; i < len(labels); i += 2 {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(labels[i])
+		b.WriteByte('=')
+		if i+1 < len(labels) {
+			b.WriteString(labels[i+1])
+		}
+	}
+	return b.String()
+}
+
+type histogram struct {
+	mu                  sync.Mutex // protect following
+	count               int64
+	sum, minVal, maxVal float64
+}
+
+func (h *histogram) Observe(value float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.count == 0 {
+		h.minVal, h.maxVal = value, value
+	}
+	h.count++
+	h.sum += value
+	h.minVal = math.Min(h.minVal, value)
+	h.maxVal = math.Max(h.maxVal, value)
+}
+
+func (h *histogram) String() string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	b, _ := json.Marshal(struct {
+		Count int64   `json:"count"`
+		Sum   float64 `json:"sum"`
+		Min   float64 `json:"min"`
+		Max   float64 `json:"max"`
+	}{h.count, h.sum, h.minVal, h.maxVal})
+	return string(b)
+}
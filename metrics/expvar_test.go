@@ -0,0 +1,39 @@
+package metrics
+
+import (
+	"encoding/json"
+	"expvar"
+	"testing"
+)
+
+func TestExpvar(t *testing.T) {
+	e := NewExpvar("metrics_test")
+	e.Counter("calls", "method", "A.B").Add(2)
+	e.Counter("calls", "method", "A.B").Add(1)
+	e.Gauge("conns").Set(3)
+	h := e.Histogram("seconds")
+	h.Observe(2)
+	h.Observe(1)
+
+	var got struct {
+		Calls   map[string]float64 `json:"calls"`
+		Conns   float64            `json:"conns"`
+		Seconds struct {
+			Count    int64
+			Sum, Min float64
+			Max      float64
+		} `json:"seconds"`
+	}
+	if err := json.Unmarshal([]byte(expvar.Get("metrics_test").String()), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Calls["method=A.B"] != 3 || got.Conns != 3 {
+		t.Errorf("unexpected counters %+v", got)
+	}
+	if s := got.Seconds; s.Count != 2 || s.Sum != 3 || s.Min != 1 || s.Max != 2 {
+		t.Errorf("unexpected histogram %+v", s)
+	}
+	if NewExpvar("metrics_test").root != e.root {
+		t.Error("same namespace should be reused")
+	}
+}
@@ -0,0 +1,41 @@
+// Package metrics 定义mrpc记录指标使用的最小接口，不依赖任何监控系统。
+// 包内提供不记录的Nop和发布到expvar的实现，Prometheus、StatsD等通过适配这几个接口接入：
+//
+//	s := mrpc.NewServer(mrpc.WithMetrics(metrics.NewExpvar("myapp")))
+package metrics
+
+// 只增不减的计数
+type Counter interface {
+	Add(delta float64)
+}
+
+// 可以任意设置的瞬时值
+type Gauge interface {
+	Set(value float64)
+	Add(delta float64)
+}
+
+// 观测值的分布，如延迟
+type Histogram interface {
+	Observe(value float64)
+}
+
+// 按名称和标签取得指标，同样的名称和标签应当返回同一个指标。
+// labels是成对的键和值，如"method", "Arith.Add"
+type Metrics interface {
+	Counter(name string, labels ...string) Counter
+	Gauge(name string, labels ...string) Gauge
+	Histogram(name string, labels ...string) Histogram
+}
+
+// 丢弃所有记录
+var Nop Metrics = nop{}
+
+type nop struct{}
+
+func (nop) Counter(string, ...string) Counter     { return nop{} }
+func (nop) Gauge(string, ...string) Gauge         { return nop{} }
+func (nop) Histogram(string, ...string) Histogram { return nop{} }
+func (nop) Add(float64)                           {}
+func (nop) Set(float64)                           {}
+func (nop) Observe(float64)                       {}
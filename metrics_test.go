@@ -0,0 +1,54 @@
+package mrpc
+
+import (
+	"expvar"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/micplus/mrpc/metrics"
+)
+
+func TestMetrics(t *testing.T) {
+	// expvar不能删除，-count多次运行时换用新的名字
+	ns := fmt.Sprint("mrpc_test_", time.Now().UnixNano())
+	sm, cm := metrics.NewExpvar(ns+"_server"), metrics.NewExpvar(ns+"_client")
+	s := NewServer(WithMetrics(sm), WithStatsHandler(newRecordingStats()))
+	s.Register(new(Calc))
+	c1, c2 := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		s.ServeConn(c2)
+		close(done)
+	}()
+	client, err := NewClientOptions(c1, WithClientMetrics(cm))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sum int
+	client.Call("Calc.Sum", Pair{1, 2}, &sum)
+	client.Call("Calc.Sum", Pair{1, 2}, &sum)
+	client.Call("Calc.Missing", Pair{}, &sum)
+	client.Close()
+	<-done
+
+	vars := expvar.Get(ns + "_server").String()
+	for _, want := range []string{
+		`"server_connections": 0`,
+		`"server_requests_total": {"method=Calc.Missing": 1, "method=Calc.Sum": 2}`,
+		`"server_errors_total": {"method=Calc.Missing": 1, "method=Calc.Sum": 0}`,
+	} {
+		assert(t, strings.Contains(vars, want), "missing %s in\n%s", want, vars)
+	}
+	assert(t, !strings.Contains(vars, `"server_bytes_read_total": 0`), "bytes should be counted:\n%s", vars)
+	vars = expvar.Get(ns + "_client").String()
+	for _, want := range []string{
+		`"service=Calc,method=Sum,target=pipe,code=OK": 2`,
+		`"service=Calc,method=Missing,target=pipe,code=Unknown": 1`,
+		`"client_errors_total": {"service=Calc,method=Missing,target=pipe": 1, "service=Calc,method=Sum,target=pipe": 0}`,
+	} {
+		assert(t, strings.Contains(vars, want), "missing %s in\n%s", want, vars)
+	}
+}
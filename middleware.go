@@ -0,0 +1,72 @@
+package mrpc
+
+import (
+	"context"
+	"errors"
+	"reflect"
+)
+
+// 服务的中间件：包在这个服务的每个方法外面，只对注册时指定的服务生效，
+// 比如只有Admin服务要求鉴权，公开的服务不受影响
+//
+//	s.Register(new(Admin), mrpc.WithMiddleware(requireAdmin))
+//	s.Register(new(Public))
+//
+// method形如"Service.Method"，arg、reply同DynamicMethod.Handler。
+// 调用next执行方法(或内层的中间件)，可以换掉ctx；不调用next方法就不执行
+type Middleware func(ctx context.Context, method string, arg, reply any, next func(ctx context.Context) error) error
+
+// 注册服务时的选项
+type ServiceOption func(*service)
+
+// 依次套上中间件，第一个在最外层。多次使用时追加
+func WithMiddleware(mws ...Middleware) ServiceOption {
+	return func(svc *service) {
+		svc.middleware = append(svc.middleware, mws...)
+	}
+}
+
+// 设置已注册服务的选项，用于HandleFunc注册的服务，应当在开始服务之前调用
+//
+//	mrpc.HandleFunc(s, "Admin.Reset", reset)
+//	s.SetServiceOptions("Admin", mrpc.WithMiddleware(requireAdmin))
+func (s *Server) SetServiceOptions(name string, opts ...ServiceOption) error {
+	svc, ok := s.serviceMap[name]
+	if !ok {
+		return errors.New("rpc server: cannot find service " + name)
+	}
+	for _, opt := range opts {
+		opt(svc)
+	}
+	return nil
+}
+
+// 经过中间件调用方法
+func (s *service) intercept(ctx context.Context, m *methodType, argv, replyv reflect.Value) error {
+	arg := argv
+	if arg.Kind() != reflect.Pointer {
+		arg = arg.Addr()
+	}
+	var next func(i int, ctx context.Context) error
+	next = func(i int, ctx context.Context) error {
+		if i == len(s.middleware) {
+			return s.run(ctx, m, argv, replyv)
+		}
+		return s.middleware[i](ctx, m.name, arg.Interface(), replyv.Interface(), func(ctx context.Context) error {
+			return next(i+1, ctx)
+		})
+	}
+	return next(0, ctx)
+}
+
+// 客户端请求的方法名，用别名或不带版本调用时与中间件收到的method不同，见requestedMethod
+type requestedKey struct{}
+
+// 客户端请求的方法名。中间件收到的method是实际执行的方法，
+// 需要与客户端一致的名称时(如校验签名)用这个
+func requestedMethod(ctx context.Context, method string) string {
+	if name, ok := ctx.Value(requestedKey{}).(string); ok {
+		return name
+	}
+	return method
+}
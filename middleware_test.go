@@ -0,0 +1,58 @@
+package mrpc
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestServiceMiddleware(t *testing.T) {
+	var trace []string
+	record := func(tag string) Middleware {
+		return func(ctx context.Context, method string, arg, reply any, next func(context.Context) error) error {
+			trace = append(trace, tag+" "+method)
+			return next(ctx)
+		}
+	}
+	requireAdmin := func(ctx context.Context, method string, arg, reply any, next func(context.Context) error) error {
+		if IncomingMetadata(ctx)["token"] != "admin" {
+			return Errorf(PermissionDenied, "rpc server: %s requires admin", method)
+		}
+		return next(ctx)
+	}
+	s := NewServer()
+	s.Register(new(Calc), WithMiddleware(record("outer"), requireAdmin, record("inner")))
+	s.Register(new(Echo))
+	HandleFunc(s, "Upper.Do", func(_ context.Context, in string, out *string) error {
+		*out = strings.ToUpper(in)
+		return nil
+	})
+	// 中间件看到的参数与方法相同，也可以改写返回值
+	s.SetServiceOptions("Upper", WithMiddleware(func(ctx context.Context, method string, arg, reply any, next func(context.Context) error) error {
+		err := next(ctx)
+		*reply.(*string) += "!" + *arg.(*string)
+		return err
+	}))
+	assert(t, s.SetServiceOptions("Missing") != nil, "unknown service should fail")
+	client, err := ConnectInProcess(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	var sum int
+	err = client.Call("Calc.Sum", Pair{1, 2}, &sum)
+	assert(t, CodeOf(err) == PermissionDenied, "want PermissionDenied, got %v", err)
+	ctx := WithOutgoingMetadata(context.Background(), Metadata{"token": "admin"})
+	err = client.CallContext(ctx, "Calc.Sum", Pair{1, 2}, &sum)
+	assert(t, err == nil && sum == 3, "Calc.Sum = %d, %v", sum, err)
+	// 第一次被鉴权挡住，没有到达内层
+	want := "outer Calc.Sum,outer Calc.Sum,inner Calc.Sum"
+	assert(t, strings.Join(trace, ",") == want, "middleware trace %q, want %q", trace, want)
+
+	var reply string
+	err = client.Call("Echo.Say", "hi", &reply)
+	assert(t, err == nil && reply == "hi", "public service: %q, %v", reply, err)
+	err = client.Call("Upper.Do", "go", &reply)
+	assert(t, err == nil && reply == "GO!go", "Upper.Do = %q, %v", reply, err)
+}
@@ -0,0 +1,108 @@
+package mrpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"log"
+	"reflect"
+	"time"
+)
+
+// 流量镜像：按方法和比例把请求异步地复制一份发给影子后端，丢弃它的响应，
+// 用生产流量验证新的实现。镜像不影响原调用：参数在调用方法前复制，
+// 影子调用在另外的协程中进行，积压太多时直接放弃
+//
+//	shadow, _ := mrpc.Dial("tcp", "10.0.0.9:1234")
+//	s := mrpc.NewServer(mrpc.WithMirror(mrpc.Mirror{Method: "Order.*", Percent: 5, Backend: shadow}))
+
+// 默认的影子调用超时
+const DefaultMirrorTimeout = 5 * time.Second
+
+// 默认的每条规则同时进行的影子调用数
+const DefaultMirrorInFlight = 64
+
+type Mirror struct {
+	Method  string  // 同Fault.Method
+	Percent float64 // 镜像的比例，0~100
+	// 影子后端，*Client、xclient.XClient都可以
+	Backend interface {
+		CallContext(ctx context.Context, name string, args, reply any) error
+	}
+	// 影子调用的超时，为0时使用DefaultMirrorTimeout
+	Timeout time.Duration
+	// 同时进行的影子调用数上限，超过时不镜像，为0时使用DefaultMirrorInFlight
+	MaxInFlight int
+	// 不为nil时在每次影子调用结束后调用，可以用来统计影子后端的错误
+	Done func(method string, err error)
+}
+
+type mirror struct {
+	Mirror
+	inflight chan struct{}
+}
+
+type mirrors []*mirror
+
+// 按顺序匹配规则，与Fault一样不区分连接
+func WithMirror(ms ...Mirror) ServerOption {
+	return func(s *Server) {
+		for _, m := range ms {
+			if m.Timeout <= 0 {
+				m.Timeout = DefaultMirrorTimeout
+			}
+			if m.MaxInFlight <= 0 {
+				m.MaxInFlight = DefaultMirrorInFlight
+			}
+			s.mirrors = append(s.mirrors, &mirror{Mirror: m, inflight: make(chan struct{}, m.MaxInFlight)})
+		}
+	}
+}
+
+// 第一条匹配的规则掷骰子，命中时复制参数，在新的协程中发给影子后端。
+// 必须在调用方法之前，方法可能修改参数
+func (ms mirrors) send(ctx context.Context, clock Clock, req *request) {
+	var m *mirror
+	for _, r := range ms {
+		if matchMethod(r.Method, req.h.Name) {
+			m = r
+			break
+		}
+	}
+	if m == nil || !sample(m.Percent/100) {
+		return
+	}
+	select {
+	case m.inflight <- struct{}{}:
+	default:
+		return
+	}
+	// 参数和返回值都可能被复用，用gob复制一份参数
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(req.argv.Interface()); err != nil {
+		<-m.inflight
+		log.Println("rpc server: mirror encode args error:", err)
+		return
+	}
+	argType, replyType, name := req.mType.ArgType, req.mType.ReplyType, req.h.Name
+	md := IncomingMetadata(ctx).Clone()
+	go func() {
+		defer func() { <-m.inflight }()
+		if argType.Kind() == reflect.Pointer {
+			argType = argType.Elem()
+		}
+		arg := reflect.New(argType)
+		err := gob.NewDecoder(&buf).Decode(arg.Interface())
+		if err == nil {
+			ctx, cancel := clock.WithTimeout(context.Background(), m.Timeout)
+			defer cancel()
+			if md != nil {
+				ctx = WithOutgoingMetadata(ctx, md)
+			}
+			err = m.Backend.CallContext(ctx, name, arg.Interface(), reflect.New(replyType.Elem()).Interface())
+		}
+		if m.Done != nil {
+			m.Done(name, err)
+		}
+	}()
+}
@@ -0,0 +1,68 @@
+package mrpc
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+// 影子后端上的新实现，记下收到的参数和元数据
+type shadowCalc struct {
+	calls chan Pair
+	meta  chan Metadata
+}
+
+func (c *shadowCalc) Sum(ctx context.Context, args Pair, reply *int) error {
+	c.calls <- args
+	c.meta <- IncomingMetadata(ctx)
+	if args.A < 0 {
+		return errors.New("shadow bug")
+	}
+	*reply = -1 // 与主后端不同，客户端不应该看到
+	return nil
+}
+
+func TestMirror(t *testing.T) {
+	shadow := &shadowCalc{calls: make(chan Pair, 10), meta: make(chan Metadata, 10)}
+	ss := NewServer()
+	if err := ss.RegisterName("Calc", shadow); err != nil {
+		t.Fatal(err)
+	}
+	shadowClient := pipeClient(t, ss)
+
+	done := make(chan error, 10)
+	s := NewServer(WithMirror(Mirror{Method: "Calc.Sum", Percent: 100, Backend: shadowClient, Done: func(_ string, err error) {
+		done <- err
+	}}))
+	s.Register(new(Calc))
+	client := pipeClient(t, s)
+	ctx := WithOutgoingMetadata(context.Background(), Metadata{"user": "alice"})
+	var reply int
+	err := client.CallContext(ctx, "Calc.Sum", Pair{1, 2}, &reply)
+	assert(t, err == nil && reply == 3, "primary call: %v %d", err, reply)
+	assert(t, <-shadow.calls == Pair{1, 2}, "shadow got wrong args")
+	assert(t, (<-shadow.meta)["user"] == "alice", "metadata not mirrored")
+	assert(t, <-done == nil, "shadow call failed")
+
+	// 影子后端的错误只交给Done
+	err = client.Call("Calc.Sum", Pair{-1, 2}, &reply)
+	assert(t, err == nil && reply == 1, "primary call: %v %d", err, reply)
+	<-shadow.calls
+	<-shadow.meta
+	assert(t, <-done != nil, "shadow error should be reported to Done")
+
+	// 按顺序匹配，第一条匹配的规则比例为0，不镜像
+	s = NewServer(WithMirror(
+		Mirror{Method: "Calc.*", Percent: 0, Backend: shadowClient},
+		Mirror{Percent: 100, Backend: shadowClient},
+	))
+	s.Register(new(Calc))
+	client = pipeClient(t, s)
+	err = client.Call("Calc.Sum", Pair{2, 3}, &reply)
+	assert(t, err == nil && reply == 5, "primary call: %v %d", err, reply)
+	select {
+	case args := <-shadow.calls:
+		t.Fatalf("unexpected mirrored call %+v", args)
+	default:
+	}
+}
@@ -0,0 +1,173 @@
+package mrpctest
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/micplus/mrpc"
+)
+
+// 手动拨动的时钟，实现mrpc.Clock。时间只在Advance时前进，
+// 到期的定时器、周期定时器和WithTimeout的期限按到期时间依次触发
+type FakeClock struct {
+	mu      sync.Mutex // protect following
+	now     time.Time
+	waiters []*waiter
+}
+
+var _ mrpc.Clock = (*FakeClock)(nil)
+
+// 到期时向ch发送时间，或者调用fn
+type waiter struct {
+	at     time.Time
+	period time.Duration // 周期定时器
+	ch     chan time.Time
+	fn     func()
+}
+
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now}
+}
+
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// 把时间拨快d，期间到期的都会触发
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	end := c.now.Add(d)
+	for {
+		sort.SliceStable(c.waiters, func(i, j int) bool { return c.waiters[i].at.Before(c.waiters[j].at) })
+		if len(c.waiters) == 0 || c.waiters[0].at.After(end) {
+			break
+		}
+		w := c.waiters[0]
+		c.now = w.at
+		if w.period > 0 {
+			w.at = w.at.Add(w.period)
+		} else {
+			c.waiters = c.waiters[1:]
+		}
+		if w.fn != nil {
+			c.mu.Unlock() // fn可能会停止其它定时器
+			w.fn()
+			c.mu.Lock()
+			continue
+		}
+		select {
+		case w.ch <- c.now:
+		default: // 与time.Ticker一样，来不及接收的丢掉
+		}
+	}
+	c.now = end
+	c.mu.Unlock()
+}
+
+// 还没有到期的定时器数，可以用来等待被测代码开始计时
+func (c *FakeClock) Waiters() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.waiters)
+}
+
+// 等到至少有n个定时器在等待
+func (c *FakeClock) BlockUntil(n int) {
+	for c.Waiters() < n {
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// d之后到期
+func (c *FakeClock) add(d time.Duration, w *waiter) *waiter {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	w.at = c.now.Add(d)
+	c.waiters = append(c.waiters, w)
+	return w
+}
+
+// 移除w，返回它是否还在等待
+func (c *FakeClock) remove(w *waiter) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, x := range c.waiters {
+		if x == w {
+			c.waiters = append(c.waiters[:i], c.waiters[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+type fakeTimer struct {
+	c *FakeClock
+	w *waiter
+}
+
+func (t fakeTimer) C() <-chan time.Time { return t.w.ch }
+func (t fakeTimer) Stop() bool          { return t.c.remove(t.w) }
+
+type fakeTicker struct{ fakeTimer }
+
+func (t fakeTicker) Stop() { t.c.remove(t.w) }
+
+func (c *FakeClock) NewTimer(d time.Duration) mrpc.Timer {
+	w := c.add(d, &waiter{ch: make(chan time.Time, 1)})
+	return fakeTimer{c, w}
+}
+
+func (c *FakeClock) NewTicker(d time.Duration) mrpc.Ticker {
+	if d <= 0 {
+		panic("mrpctest: non-positive interval for NewTicker")
+	}
+	w := c.add(d, &waiter{period: d, ch: make(chan time.Time, 1)})
+	return fakeTicker{fakeTimer{c, w}}
+}
+
+func (c *FakeClock) WithTimeout(parent context.Context, d time.Duration) (context.Context, context.CancelFunc) {
+	ctx := &deadlineCtx{Context: parent, deadline: c.Now().Add(d), done: make(chan struct{})}
+	if d <= 0 {
+		ctx.cancel(context.DeadlineExceeded)
+		return ctx, func() {}
+	}
+	w := c.add(d, &waiter{fn: func() { ctx.cancel(context.DeadlineExceeded) }})
+	stop := context.AfterFunc(parent, func() { ctx.cancel(parent.Err()) })
+	return ctx, func() {
+		stop()
+		c.remove(w)
+		ctx.cancel(context.Canceled)
+	}
+}
+
+// 期限由FakeClock触发的ctx
+type deadlineCtx struct {
+	context.Context
+	deadline time.Time
+	done     chan struct{}
+
+	mu  sync.Mutex // protect err
+	err error
+}
+
+func (ctx *deadlineCtx) Deadline() (time.Time, bool) { return ctx.deadline, true }
+func (ctx *deadlineCtx) Done() <-chan struct{}       { return ctx.done }
+
+func (ctx *deadlineCtx) Err() error {
+	ctx.mu.Lock()
+	defer ctx.mu.Unlock()
+	return ctx.err
+}
+
+func (ctx *deadlineCtx) cancel(err error) {
+	ctx.mu.Lock()
+	defer ctx.mu.Unlock()
+	if ctx.err == nil {
+		ctx.err = err
+		close(ctx.done)
+	}
+}
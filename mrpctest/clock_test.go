@@ -0,0 +1,92 @@
+package mrpctest
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/micplus/mrpc"
+)
+
+func TestFakeClock(t *testing.T) {
+	start := time.Unix(1000, 0)
+	c := NewFakeClock(start)
+	timer := c.NewTimer(2 * time.Second)
+	ticker := c.NewTicker(time.Second)
+	defer ticker.Stop()
+	ctx, cancel := c.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	c.Advance(time.Second)
+	select {
+	case now := <-ticker.C():
+		if !now.Equal(start.Add(time.Second)) {
+			t.Errorf("ticker fired at %v", now)
+		}
+	default:
+		t.Fatal("ticker should fire after 1s")
+	}
+	select {
+	case <-timer.C():
+		t.Fatal("timer fired too early")
+	default:
+	}
+
+	c.Advance(time.Second)
+	<-ticker.C()
+	<-timer.C()
+	if timer.Stop() {
+		t.Error("Stop should report the timer already fired")
+	}
+	if ctx.Err() != nil {
+		t.Fatalf("ctx done too early: %v", ctx.Err())
+	}
+	c.Advance(time.Second)
+	<-ctx.Done()
+	if ctx.Err() != context.DeadlineExceeded {
+		t.Errorf("want DeadlineExceeded, got %v", ctx.Err())
+	}
+	if !c.Now().Equal(start.Add(3 * time.Second)) {
+		t.Errorf("now = %v", c.Now())
+	}
+}
+
+type Sleeper int
+
+// 等到期限过了才返回
+func (*Sleeper) Wait(ctx context.Context, _ int, reply *int) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+// 客户端和服务端都用FakeClock，期限在Advance后立即生效，不必真的等待
+func TestCallDeadlineWithFakeClock(t *testing.T) {
+	c := NewFakeClock(time.Now())
+	s := mrpc.NewServer(mrpc.WithClock(c))
+	if err := s.Register(new(Sleeper)); err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go s.Accept(l)
+	client, err := mrpc.DialOptions("tcp", l.Addr().String(), mrpc.WithClientClock(c))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	ctx, cancel := c.WithTimeout(context.Background(), time.Hour)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- client.CallContext(ctx, "Sleeper.Wait", 1, new(int)) }()
+	c.BlockUntil(2) // 客户端的ctx和服务端处理请求的ctx
+	c.Advance(time.Hour)
+	if err := <-done; !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("want DeadlineExceeded, got %v", err)
+	}
+}
@@ -0,0 +1,141 @@
+// mrpctest 为依赖mrpc的业务代码提供测试工具：
+// MockClient按方法预设响应、记录调用、注入错误；Server在内存中运行真实的服务端。
+package mrpctest
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+
+	"github.com/micplus/mrpc"
+)
+
+// 调用了没有预设响应的方法
+var ErrNotScripted = errors.New("mrpctest: no scripted response")
+
+// 一次被记录的调用
+type RecordedCall struct {
+	Name  string
+	Args  any
+	Error error
+}
+
+// 预设的响应：写reply或返回错误
+type Responder func(args, reply any) error
+
+// 实现mrpc.Caller的测试替身。对同一方法多次预设时按顺序使用，用完后重复最后一个
+type MockClient struct {
+	mu      sync.Mutex // protect following
+	scripts map[string][]Responder
+	calls   []RecordedCall
+}
+
+var _ mrpc.Caller = (*MockClient)(nil)
+
+func NewMockClient() *MockClient {
+	return &MockClient{scripts: make(map[string][]Responder)}
+}
+
+// 为方法预设一个响应函数
+func (m *MockClient) On(name string, fn Responder) *MockClient {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.scripts[name] = append(m.scripts[name], fn)
+	return m
+}
+
+// 预设方法返回的值，调用时复制到reply指向的对象
+func (m *MockClient) Reply(name string, value any) *MockClient {
+	return m.On(name, func(_, reply any) error {
+		return setReply(reply, value)
+	})
+}
+
+// 预设方法返回错误
+func (m *MockClient) Fail(name string, err error) *MockClient {
+	return m.On(name, func(_, _ any) error {
+		return err
+	})
+}
+
+// value可以是reply指向的类型的值，也可以是同类型的指针
+func setReply(reply, value any) error {
+	rv := reflect.ValueOf(reply)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() {
+		return fmt.Errorf("mrpctest: reply must be a non-nil pointer, got %T", reply)
+	}
+	v := reflect.ValueOf(value)
+	if v.Type() != rv.Elem().Type() && v.Kind() == reflect.Pointer {
+		v = v.Elem()
+	}
+	if !v.Type().AssignableTo(rv.Elem().Type()) {
+		return fmt.Errorf("mrpctest: cannot assign %T to %T", value, reply)
+	}
+	rv.Elem().Set(v)
+	return nil
+}
+
+func (m *MockClient) next(name string) Responder {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	script := m.scripts[name]
+	if len(script) == 0 {
+		return nil
+	}
+	if len(script) > 1 {
+		m.scripts[name] = script[1:]
+	}
+	return script[0]
+}
+
+// 调用选项被忽略，预设的响应不受超时等影响
+func (m *MockClient) Call(name string, args, reply any, _ ...mrpc.CallOption) error {
+	var err error
+	if fn := m.next(name); fn != nil {
+		err = fn(args, reply)
+	} else {
+		err = fmt.Errorf("%w for %s", ErrNotScripted, name)
+	}
+	m.mu.Lock()
+	m.calls = append(m.calls, RecordedCall{Name: name, Args: args, Error: err})
+	m.mu.Unlock()
+	return err
+}
+
+// 同步执行预设的响应，再通过done返回
+func (m *MockClient) Go(name string, args, reply any, done chan *mrpc.Call, _ ...mrpc.CallOption) *mrpc.Call {
+	if done == nil {
+		done = make(chan *mrpc.Call, 1)
+	}
+	call := &mrpc.Call{Name: name, Args: args, Reply: reply, Done: done}
+	call.Error = m.Call(name, args, reply)
+	done <- call
+	return call
+}
+
+// 全部调用记录
+func (m *MockClient) Calls() []RecordedCall {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]RecordedCall(nil), m.calls...)
+}
+
+// 对某个方法的调用记录
+func (m *MockClient) CallsTo(name string) []RecordedCall {
+	var calls []RecordedCall
+	for _, c := range m.Calls() {
+		if c.Name == name {
+			calls = append(calls, c)
+		}
+	}
+	return calls
+}
+
+// 清空预设和调用记录
+func (m *MockClient) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.scripts = make(map[string][]Responder)
+	m.calls = nil
+}
@@ -0,0 +1,57 @@
+package mrpctest
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/micplus/mrpc"
+)
+
+// 被测的业务代码只依赖mrpc.Caller
+func double(c mrpc.Caller, n int) (int, error) {
+	var reply int
+	err := c.Call("Arith.Double", n, &reply)
+	return reply, err
+}
+
+type Arith int
+
+func (*Arith) Double(n int, reply *int) error {
+	*reply = 2 * n
+	return nil
+}
+
+func TestMockClient(t *testing.T) {
+	errBoom := errors.New("boom")
+	m := NewMockClient().
+		Reply("Arith.Double", 4).
+		Fail("Arith.Double", errBoom)
+
+	if got, err := double(m, 2); err != nil || got != 4 {
+		t.Fatalf("first call: got %d %v", got, err)
+	}
+	for i := 0; i < 2; i++ { // 最后一个预设会被重复使用
+		if _, err := double(m, 3); !errors.Is(err, errBoom) {
+			t.Fatalf("want injected error, got %v", err)
+		}
+	}
+	if err := m.Call("Arith.Other", 1, new(int)); !errors.Is(err, ErrNotScripted) {
+		t.Errorf("want ErrNotScripted, got %v", err)
+	}
+
+	calls := m.CallsTo("Arith.Double")
+	if len(calls) != 3 || calls[0].Args != 2 || calls[1].Error != errBoom {
+		t.Errorf("unexpected recorded calls %+v", calls)
+	}
+	call := <-m.Go("Arith.Double", 5, new(int), nil).Done
+	if call.Error != errBoom {
+		t.Errorf("Go: want injected error, got %v", call.Error)
+	}
+}
+
+func TestServer(t *testing.T) {
+	s := NewServer(t, new(Arith))
+	if got, err := double(s.Client(), 21); err != nil || got != 42 {
+		t.Fatalf("got %d %v", got, err)
+	}
+}
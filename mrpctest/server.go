@@ -0,0 +1,66 @@
+package mrpctest
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/micplus/mrpc"
+)
+
+// 在内存中运行的真实服务端，客户端经由进程内管道连接，
+// 不占用端口，测试结束时自动关闭
+type Server struct {
+	*mrpc.Server
+	tb  testing.TB
+	lis *mrpc.PipeListener
+
+	mu      sync.Mutex // protect following
+	clients []*mrpc.Client
+}
+
+// 创建服务端并注册rcvrs，注册失败时直接让测试失败
+func NewServer(tb testing.TB, rcvrs ...any) *Server {
+	tb.Helper()
+	s := &Server{
+		Server: mrpc.NewServer(),
+		tb:     tb,
+		lis:    mrpc.NewPipeListener(),
+	}
+	for _, rcvr := range rcvrs {
+		if err := s.Register(rcvr); err != nil {
+			tb.Fatalf("mrpctest: register %T: %v", rcvr, err)
+		}
+	}
+	go s.Accept(s.lis)
+	tb.Cleanup(s.Close)
+	return s
+}
+
+// 新建一个连到该服务端的客户端(gob编码)
+func (s *Server) Client() *mrpc.Client {
+	s.tb.Helper()
+	return s.ClientWithCodec(0)
+}
+
+func (s *Server) ClientWithCodec(codecType uint32) *mrpc.Client {
+	s.tb.Helper()
+	client, err := s.lis.DialClient(codecType)
+	if err != nil {
+		s.tb.Fatalf("mrpctest: dial: %v", err)
+	}
+	s.mu.Lock()
+	s.clients = append(s.clients, client)
+	s.mu.Unlock()
+	return client
+}
+
+// 关闭所有客户端并停止接受连接，可以重复调用
+func (s *Server) Close() {
+	s.lis.Close()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, c := range s.clients {
+		c.Close()
+	}
+	s.clients = nil
+}
@@ -0,0 +1,254 @@
+package mrpc
+
+import (
+	"log"
+	"time"
+
+	"github.com/micplus/mrpc/codec"
+	"github.com/micplus/mrpc/metrics"
+)
+
+// 服务端的可选配置
+//
+//	s := mrpc.NewServer(mrpc.WithWriteCoalescing(64<<10, time.Millisecond))
+type ServerOption func(*Server)
+
+// 默认的读缓冲大小，与gob自带的缓冲一致
+const DefaultReadBufferSize = 4096
+
+// 连接读端的缓冲大小。小消息多时增大它可以减少read系统调用，
+// n<=0时不额外加缓冲，由codec自行处理
+func WithReadBufferSize(n int) ServerOption {
+	return func(s *Server) {
+		s.readBufferSize = n
+	}
+}
+
+// 默认的握手期限
+const DefaultHandshakeTimeout = 10 * time.Second
+
+// 连接建立后必须在d内发来Magic和编码类型(TLS连接还包括TLS握手)，否则关闭连接，
+// 防止只连接不发数据的客户端耗尽协程和文件描述符。d<=0时不限制
+func WithHandshakeTimeout(d time.Duration) ServerOption {
+	return func(s *Server) {
+		s.UpdateSettings(func(st *ServerSettings) { st.HandshakeTimeout = d })
+	}
+}
+
+// 默认的响应写入期限
+const DefaultWriteTimeout = 30 * time.Second
+
+// 写一个响应(包括合并写入时的刷新)最多阻塞d，超时说明客户端不再读数据，关闭连接。
+// 同一连接的响应共用一把写锁，不这样做一个卡住的客户端会让这条连接上所有的响应都停下来。
+// d<=0时不限制
+func WithWriteTimeout(d time.Duration) ServerOption {
+	return func(s *Server) {
+		s.UpdateSettings(func(st *ServerSettings) { st.WriteTimeout = d })
+	}
+}
+
+// 是否对接受的TCP连接设置TCP_NODELAY，默认开启。
+// 关闭后内核会把小包攒成大包再发，吞吐更高，但单个响应的延迟会增加
+func WithNoDelay(noDelay bool) ServerOption {
+	return func(s *Server) {
+		s.socket.noDelay = noDelay
+	}
+}
+
+// 接受的TCP连接的内核发送、接收缓冲大小(SO_SNDBUF、SO_RCVBUF)，<=0时使用系统默认值。
+// 高带宽、高延迟的链路上增大它们才能跑满带宽
+func WithSocketBuffers(send, recv int) ServerOption {
+	return func(s *Server) {
+		s.socket.sendBuffer = send
+		s.socket.recvBuffer = recv
+	}
+}
+
+// 省GC模式：请求、参数、返回值由每条连接自己的空闲列表提供，写完响应后清空复用，
+// 其中切片的底层数组和map也会保留下来供下一次解码使用，固定形状的消息在稳定状态下几乎不再分配。
+// 代价是方法不能在返回后继续持有参数和返回值(包括其中的切片、map)，
+// 且收到的空切片不再是nil。反射调用和gob解码本身仍有少量分配，
+// 对分配敏感的方法应当用HandleFunc注册
+func WithReducedGC() ServerOption {
+	return func(s *Server) {
+		s.reducedGC = true
+	}
+}
+
+// 设置统计钩子，见StatsHandler。可以设置多个，按顺序调用
+func WithStatsHandler(h StatsHandler) ServerOption {
+	return func(s *Server) {
+		s.stats = chainStats(s.stats, h)
+	}
+}
+
+// 把连接数、调用数、错误数、耗时、流量记录到m，指标名称见metricsHandler
+func WithMetrics(m metrics.Metrics) ServerOption {
+	return func(s *Server) {
+		s.stats = chainStats(s.stats, newMetricsHandler(m, false))
+	}
+}
+
+// 允许方法通过ReverseClient(ctx)调用客户端用WithReverseServer注册的服务
+func WithReverseRPC() ServerOption {
+	return func(s *Server) {
+		s.reverse = true
+	}
+}
+
+// 按采样规则把调用记录到l，见LogSampling。l为nil时使用log.Default()
+func WithRequestLogging(l *log.Logger, sampling LogSampling) ServerOption {
+	if l == nil {
+		l = log.Default()
+	}
+	return func(s *Server) {
+		s.stats = chainStats(s.stats, newLoggingHandler(l, sampling, false))
+	}
+}
+
+// 开启请求追踪，每个方法保留最近n个完成的请求和n个出错的请求，
+// 连同正在处理的请求通过ActiveRequests、RecentRequests和调试页面查看。n<=0时不追踪(默认)
+func WithRequestTrace(n int) ServerOption {
+	return func(s *Server) {
+		s.requests = nil
+		if n > 0 {
+			s.requests = newRequestLog(n)
+		}
+	}
+}
+
+// 开启按连接的流量控制，每条连接最多有size个请求在处理中，
+// 客户端按服务端发放的信用发送请求。size<=0时不限制(默认)
+func WithFlowWindow(size int) ServerOption {
+	return func(s *Server) {
+		s.UpdateSettings(func(st *ServerSettings) { st.FlowWindow = size })
+	}
+}
+
+// 合并响应写入的默认上限，与codec的写缓冲大小一致
+const (
+	DefaultCoalesceBytes = 4096
+	DefaultCoalesceDelay = time.Millisecond
+)
+
+// 多个响应同时完成时，先写进缓冲区，由最后一个一并刷新到连接。
+// 缓冲超过maxBytes或最早的响应已等待maxDelay时立即刷新，
+// 没有其它响应排队时也立即刷新，所以空闲时不会增加延迟。maxBytes<=0时每个响应单独刷新
+func WithWriteCoalescing(maxBytes int, maxDelay time.Duration) ServerOption {
+	return func(s *Server) {
+		s.coalesceBytes = maxBytes
+		s.coalesceDelay = maxDelay
+	}
+}
+
+// 客户端的可选配置
+//
+//	client, err := mrpc.DialOptions("tcp", addr, mrpc.WithClientReadBufferSize(64<<10))
+type ClientOption func(*clientOptions)
+
+type clientOptions struct {
+	codecType      uint32
+	readBufferSize int
+	socket         socketOptions
+	coalesceBytes  int
+	coalesceDelay  time.Duration
+	stats          StatsHandler
+	reverse        *Server
+	faults         faults
+	clock          Clock
+	pingInterval   time.Duration
+	readLimit      *RateLimiter
+	writeLimit     *RateLimiter
+	compress       int
+	decoders       []func(e *Error) error
+	encryption     *sessionConfig
+	signKey        []byte
+	ordered        bool
+	internNames    bool
+	baggage        []Baggage
+}
+
+func newClientOptions(opts []ClientOption) *clientOptions {
+	o := &clientOptions{
+		codecType:      codec.GobType,
+		readBufferSize: DefaultReadBufferSize,
+		socket:         defaultSocketOptions(),
+		coalesceBytes:  DefaultCoalesceBytes,
+		coalesceDelay:  DefaultCoalesceDelay,
+		clock:          SystemClock,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// 编码类型，默认gob
+func WithClientCodecType(codecType uint32) ClientOption {
+	return func(o *clientOptions) {
+		o.codecType = codecType
+	}
+}
+
+// 连接读端的缓冲大小，见WithReadBufferSize
+func WithClientReadBufferSize(n int) ClientOption {
+	return func(o *clientOptions) {
+		o.readBufferSize = n
+	}
+}
+
+// 见WithNoDelay
+func WithClientNoDelay(noDelay bool) ClientOption {
+	return func(o *clientOptions) {
+		o.socket.noDelay = noDelay
+	}
+}
+
+// 见WithSocketBuffers
+func WithClientSocketBuffers(send, recv int) ClientOption {
+	return func(o *clientOptions) {
+		o.socket.sendBuffer = send
+		o.socket.recvBuffer = recv
+	}
+}
+
+// 并发的请求先写进缓冲，由最后一个一并刷新，规则同WithWriteCoalescing。
+// maxBytes<=0时每个请求单独刷新
+func WithClientWriteCoalescing(maxBytes int, maxDelay time.Duration) ClientOption {
+	return func(o *clientOptions) {
+		o.coalesceBytes = maxBytes
+		o.coalesceDelay = maxDelay
+	}
+}
+
+// 设置统计钩子，见StatsHandler。可以设置多个，按顺序调用
+func WithClientStatsHandler(h StatsHandler) ClientOption {
+	return func(o *clientOptions) {
+		o.stats = chainStats(o.stats, h)
+	}
+}
+
+// 见WithMetrics
+func WithClientMetrics(m metrics.Metrics) ClientOption {
+	return func(o *clientOptions) {
+		o.stats = chainStats(o.stats, newMetricsHandler(m, true))
+	}
+}
+
+// 见WithRequestLogging
+func WithClientRequestLogging(l *log.Logger, sampling LogSampling) ClientOption {
+	if l == nil {
+		l = log.Default()
+	}
+	return func(o *clientOptions) {
+		o.stats = chainStats(o.stats, newLoggingHandler(l, sampling, true))
+	}
+}
+
+// 在连接上响应服务端的反向调用，由s中注册的服务处理，见WithReverseRPC。
+// s只用来注册服务，它的选项中只有统计钩子、请求追踪等对反向调用生效
+func WithReverseServer(s *Server) ClientOption {
+	return func(o *clientOptions) {
+		o.reverse = s
+	}
+}
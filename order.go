@@ -0,0 +1,72 @@
+package mrpc
+
+import (
+	"errors"
+
+	"github.com/micplus/mrpc/codec"
+)
+
+// 按序响应：同一连接上的请求默认并发处理，谁先处理完谁先写响应，响应的顺序与请求不同。
+// 有的客户端(多是其它语言实现的简单客户端)只能按发出的顺序匹配响应，
+// 这样的连接在发出第一个请求之前发送ordered控制帧，之后的请求仍然并发处理，
+// 但响应按读到请求的顺序写出，一个慢请求会挡住它之后所有请求的响应。
+// 控制帧Seq为0，没有消息体，旧版本的服务端把它当作找不到方法的请求回复
+
+// 要求按序响应的控制帧名称，没有消息体
+const orderedFrame = "mrpc.ordered"
+
+var errOrderedFrame = errors.New("rpc server: ordered frame")
+
+// 所有连接都按序响应，不需要客户端发送控制帧。
+// 用于不能发送控制帧的客户端，比如ServeCodec处理的jsonrpc
+func WithOrderedResponses() ServerOption {
+	return func(s *Server) {
+		s.ordered = true
+	}
+}
+
+// 要求服务端在这条连接上按请求的顺序写响应，见orderedFrame。
+// Go的客户端按Seq匹配响应，不需要它，除非方法依赖于请求的先后
+func WithClientOrderedResponses() ClientOption {
+	return func(o *clientOptions) {
+		o.ordered = true
+	}
+}
+
+// 一条连接上响应的顺序，为nil时不排序。只在读循环中使用
+type responseOrder struct {
+	last chan struct{} // 上一个请求的响应写完时关闭
+}
+
+// 给读到的请求排队，它要等前一个请求的响应写完
+func (o *responseOrder) enqueue(req *request) {
+	if o == nil {
+		return
+	}
+	req.prev = o.last
+	req.done = make(chan struct{})
+	o.last = req.done
+}
+
+// 写响应前等前一个请求的响应写完
+func (req *request) waitTurn() {
+	if req.prev != nil {
+		<-req.prev
+		req.prev = nil
+	}
+}
+
+// 写完响应，或者不写响应时，让下一个请求写。不写响应时也要等前一个，顺序才能传递下去
+func (req *request) endTurn() {
+	if req.done != nil {
+		req.waitTurn()
+		close(req.done)
+	}
+}
+
+// 握手后要求服务端按序响应
+func (c *Client) requestOrder() error {
+	c.sending.Lock()
+	defer c.sending.Unlock()
+	return c.cc.Write(&codec.Header{Name: orderedFrame}, invalidRequest)
+}
@@ -0,0 +1,94 @@
+package mrpc
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/micplus/mrpc/codec"
+)
+
+// 编号越小处理得越慢，并发处理时响应的顺序正好相反
+func newDelayServer(opts ...ServerOption) *Server {
+	s := NewServer(opts...)
+	HandleFunc(s, "Delay.Echo", func(ctx context.Context, n int, reply *int) error {
+		time.Sleep(time.Duration(5-n) * 20 * time.Millisecond)
+		*reply = n
+		return nil
+	})
+	return s
+}
+
+func TestOrderedResponses(t *testing.T) {
+	frame := func(s string) []byte {
+		return append(binary.BigEndian.AppendUint32(nil, uint32(len(s))), s...)
+	}
+	for _, tc := range []struct {
+		name    string
+		opts    []ServerOption
+		ordered bool // 发送控制帧
+	}{
+		{"frame", nil, true},
+		{"server option", []ServerOption{WithOrderedResponses()}, false},
+	} {
+		c1, c2 := net.Pipe()
+		go newDelayServer(tc.opts...).ServeConn(c2)
+		req := binary.BigEndian.AppendUint32(binary.BigEndian.AppendUint32(nil, Magic), codec.JSONType)
+		if tc.ordered {
+			req = append(req, frame(`{"name":"mrpc.ordered"}`)...)
+			req = append(req, frame(`null`)...)
+		}
+		for seq := 1; seq <= 4; seq++ {
+			name := "Delay.Echo"
+			if seq == 3 { // 无法处理的请求也按序回复
+				name = "Delay.Nope"
+			}
+			req = append(req, frame(`{"seq":`+strconv.Itoa(seq)+`,"name":"`+name+`"}`)...)
+			req = append(req, frame(strconv.Itoa(seq))...)
+		}
+		go c1.Write(req)
+		readFrame := func() []byte {
+			head := make([]byte, 4)
+			if _, err := io.ReadFull(c1, head); err != nil {
+				t.Fatal(err)
+			}
+			p := make([]byte, binary.BigEndian.Uint32(head))
+			if _, err := io.ReadFull(c1, p); err != nil {
+				t.Fatal(err)
+			}
+			return p
+		}
+		var seqs []uint64
+		for len(seqs) < 4 {
+			var h struct{ Seq uint64 }
+			if err := json.Unmarshal(readFrame(), &h); err != nil {
+				t.Fatal(err)
+			}
+			readFrame()
+			if h.Seq != 0 {
+				seqs = append(seqs, h.Seq)
+			}
+		}
+		for i, seq := range seqs {
+			assert(t, seq == uint64(i+1), "%s: responses out of order: %v", tc.name, seqs)
+		}
+		c1.Close()
+	}
+}
+
+func TestClientOrderedResponses(t *testing.T) {
+	client := pipeClient(t, newDelayServer(), WithClientOrderedResponses())
+	done := make(chan *Call, 4)
+	for n := 1; n <= 4; n++ {
+		client.Go("Delay.Echo", n, new(int), done)
+	}
+	for n := 1; n <= 4; n++ {
+		call := <-done
+		assert(t, call.Error == nil && *call.Reply.(*int) == n, "call %d: got %v, %v", n, *call.Reply.(*int), call.Error)
+	}
+}
@@ -0,0 +1,64 @@
+package mrpc
+
+import (
+	"errors"
+	"net"
+)
+
+// 全双工的对等连接：两端各有一个Server提供服务、一个Client调用对方，共用同一条连接。
+// 建立在反向调用之上(见ReverseClient)，发起连接的一端发送握手，
+// 它发出的请求是正向的，对端发出的请求带codec.FlagReverse，两个方向各有自己的序号。
+// 方法中也可以通过ReverseClient(ctx)回调对端
+//
+//	// A
+//	conn, _ := net.Dial("tcp", addr)
+//	p, err := mrpc.NewPeer(conn, serverA)
+//	// B
+//	conn, _ := lis.Accept()
+//	p, err := mrpc.AcceptPeer(conn, serverB)
+//
+//	err = p.Call("Greeter.Hello", "A", &reply)
+type Peer struct {
+	*Client
+	conn net.Conn // 接受的一端才有，反向客户端不拥有连接
+}
+
+// 在发起的连接上建立Peer，s处理对端的调用，opts同NewClientOptions
+func NewPeer(conn net.Conn, s *Server, opts ...ClientOption) (*Peer, error) {
+	client, err := NewClientOptions(conn, append(opts, WithReverseServer(s))...)
+	if err != nil {
+		return nil, err
+	}
+	return &Peer{Client: client}, nil
+}
+
+// 在接受的连接上建立Peer，等到握手完成后返回，之后在另一协程中由s处理对端的调用
+func AcceptPeer(conn net.Conn, s *Server) (*Peer, error) {
+	ready := make(chan *Client, 1)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.serveConn(conn, func(c *Client) { ready <- c })
+	}()
+	select {
+	case c := <-ready:
+		return &Peer{Client: c, conn: conn}, nil
+	case <-done:
+		// 握手之后连接可能马上就断了，serveConn已经返回
+		select {
+		case c := <-ready:
+			return &Peer{Client: c, conn: conn}, nil
+		default:
+			return nil, errors.New("rpc server: peer handshake failed")
+		}
+	}
+}
+
+// 不再发起调用并关闭连接，对端的调用随之结束
+func (p *Peer) Close() error {
+	err := p.Client.Close()
+	if p.conn != nil {
+		return p.conn.Close()
+	}
+	return err
+}
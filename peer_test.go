@@ -0,0 +1,60 @@
+package mrpc
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestPeer(t *testing.T) {
+	newServer := func(name string) *Server {
+		s := NewServer()
+		HandleFunc(s, "Peer.Echo", func(ctx context.Context, msg string, reply *string) error {
+			// 回调对端
+			var other string
+			if err := ReverseClient(ctx).Call("Peer.Whoami", 0, &other); err != nil {
+				return err
+			}
+			*reply = name + " got " + msg + " from " + other
+			return nil
+		})
+		HandleFunc(s, "Peer.Whoami", func(_ context.Context, _ int, reply *string) error {
+			*reply = name
+			return nil
+		})
+		return s
+	}
+	c1, c2 := net.Pipe()
+	accepted := make(chan *Peer)
+	go func() {
+		p, err := AcceptPeer(c2, newServer("b"))
+		if err != nil {
+			t.Error(err)
+		}
+		accepted <- p
+	}()
+	a, err := NewPeer(c1, newServer("a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := <-accepted
+
+	var reply string
+	err = a.Call("Peer.Echo", "hi", &reply)
+	assert(t, err == nil && reply == "b got hi from a", "a->b: %q %v", reply, err)
+	err = b.Call("Peer.Echo", "hello", &reply)
+	assert(t, err == nil && reply == "a got hello from b", "b->a: %q %v", reply, err)
+
+	b.Close()
+	for a.IsAvailable() {
+		a.Call("Peer.Whoami", 0, &reply)
+	}
+	err = b.Call("Peer.Whoami", 0, &reply)
+	assert(t, errors.Is(err, ErrShutDown), "calls after close should fail, got %v", err)
+
+	c1, c2 = net.Pipe()
+	go c1.Close()
+	_, err = AcceptPeer(c2, NewServer())
+	assert(t, err != nil, "handshake should fail")
+}
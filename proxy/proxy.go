@@ -0,0 +1,196 @@
+// proxy 是mrpc的七层代理：接受mrpc连接，按路由规则把调用转发给后端的mrpc服务，
+// 客户端只需要连接代理：
+//
+//	p := proxy.New()
+//	p.Route(proxy.Route{Service: "User", Meta: map[string]string{"env": "canary"}, Backend: canary})
+//	p.Route(proxy.Route{Service: "User", Backend: xclient.NewXClient(d, xclient.RoundRobinSelect)})
+//	p.Route(proxy.Route{Backend: fallback})
+//	p.Server().Accept(lis)
+//
+// 路由按添加的顺序匹配服务名和元数据。后端需要注册反射服务(Server.RegisterReflection)，
+// 代理第一次收到某个方法的请求时向它查询参数类型，之后由代理的Server缓存。
+// 连接池和负载均衡由Backend负责，xclient.XClient对每个实例复用一个连接；
+// 元数据和期限随调用转发，后端返回的错误(包括错误码)原样返回给客户端
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/micplus/mrpc"
+)
+
+// 转发调用的后端，*mrpc.Client、*xclient.XClient都实现了它
+type Backend interface {
+	CallContext(ctx context.Context, name string, args, reply any) error
+}
+
+var _ Backend = (*mrpc.Client)(nil)
+
+// 路由规则
+type Route struct {
+	Service string            // 服务名，为空时匹配所有服务
+	Meta    map[string]string // 调用的元数据须包含这些键值，为空时不限
+	Backend Backend
+}
+
+func (r *Route) match(service string, md mrpc.Metadata) bool {
+	if r.Service != "" && r.Service != service {
+		return false
+	}
+	for k, v := range r.Meta {
+		if md[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// 一条路由的累计调用
+type RouteStats struct {
+	Service string
+	Meta    map[string]string
+	Calls   uint64
+	Errors  uint64 // 返回给客户端的错误，包括后端返回的
+	Retries uint64
+}
+
+type route struct {
+	Route
+	calls, errors, retries atomic.Uint64
+}
+
+// 默认的查询参数类型的超时
+const DefaultLookupTimeout = 5 * time.Second
+
+type Proxy struct {
+	// 后端不可用(拨号失败、连接断开等，不包括后端返回的错误)时的重试次数，
+	// 每次重试由Backend重新选择实例。请求可能已经到达后端，只应对幂等的服务开启
+	Retries int
+	// 查询参数类型的超时，为0时使用DefaultLookupTimeout
+	LookupTimeout time.Duration
+
+	mu     sync.RWMutex // protect routes
+	routes []*route
+}
+
+func New() *Proxy {
+	return new(Proxy)
+}
+
+// 追加一条路由，先添加的优先匹配
+func (p *Proxy) Route(r Route) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.routes = append(p.routes, &route{Route: r})
+}
+
+// 接受客户端连接的Server，opts是它的其它选项
+func (p *Proxy) Server(opts ...mrpc.ServerOption) *mrpc.Server {
+	return mrpc.NewServer(append(opts, mrpc.WithDynamicMethods(p.find))...)
+}
+
+// 各条路由的调用数，按添加的顺序
+func (p *Proxy) Stats() []RouteStats {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	stats := make([]RouteStats, len(p.routes))
+	for i, r := range p.routes {
+		stats[i] = RouteStats{
+			Service: r.Service,
+			Meta:    r.Meta,
+			Calls:   r.calls.Load(),
+			Errors:  r.errors.Load(),
+			Retries: r.retries.Load(),
+		}
+	}
+	return stats
+}
+
+// 第一条匹配的路由，service为空时不看服务名
+func (p *Proxy) route(service string, md mrpc.Metadata, ignoreMeta bool) *route {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	for _, r := range p.routes {
+		if ignoreMeta && (r.Service == "" || r.Service == service) || !ignoreMeta && r.match(service, md) {
+			return r
+		}
+	}
+	return nil
+}
+
+// 向服务所在的后端查询方法的参数类型。同一服务的各个后端应当有相同的类型，
+// 不看元数据，用服务名匹配到的第一条路由
+func (p *Proxy) find(name string) (*mrpc.DynamicMethod, error) {
+	dot := strings.LastIndex(name, ".")
+	service, method := name[:dot], name[dot+1:]
+	r := p.route(service, nil, true)
+	if r == nil {
+		return nil, fmt.Errorf("rpc proxy: no route for service %s", service)
+	}
+	timeout := p.LookupTimeout
+	if timeout == 0 {
+		timeout = DefaultLookupTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	var info mrpc.ServiceInfo
+	if err := r.Backend.CallContext(ctx, mrpc.ReflectionServiceName+".Describe", service, &info); err != nil {
+		return nil, fmt.Errorf("rpc proxy: describe %s: %w", service, err)
+	}
+	for _, mi := range info.Methods {
+		if mi.Name != method {
+			continue
+		}
+		argType, err := mi.Args.Type()
+		if err != nil {
+			return nil, fmt.Errorf("rpc proxy: args of %s: %w", name, err)
+		}
+		replyType, err := mi.Reply.Type()
+		if err != nil {
+			return nil, fmt.Errorf("rpc proxy: reply of %s: %w", name, err)
+		}
+		return &mrpc.DynamicMethod{
+			ArgType:   argType,
+			ReplyType: replyType,
+			Handler: func(ctx context.Context, arg, reply any) error {
+				return p.forward(ctx, service, name, arg, reply)
+			},
+		}, nil
+	}
+	return nil, fmt.Errorf("rpc proxy: cannot find method %s", name)
+}
+
+// 按元数据选择路由，转发调用，后端不可用时重试
+func (p *Proxy) forward(ctx context.Context, service, name string, arg, reply any) error {
+	md := mrpc.IncomingMetadata(ctx)
+	r := p.route(service, md, false)
+	if r == nil {
+		return mrpc.Errorf(mrpc.Unavailable, "rpc proxy: no route for service %s", service)
+	}
+	r.calls.Add(1)
+	if md != nil {
+		ctx = mrpc.WithOutgoingMetadata(ctx, md)
+	}
+	var err error
+	for i := 0; ; i++ {
+		err = r.Backend.CallContext(ctx, name, arg, reply)
+		if err == nil || errors.As(err, new(mrpc.ServerError)) || ctx.Err() != nil {
+			break
+		}
+		if i >= p.Retries {
+			err = mrpc.Errorf(mrpc.Unavailable, "rpc proxy: backend unavailable: %v", err)
+			break
+		}
+		r.retries.Add(1)
+	}
+	if err != nil {
+		r.errors.Add(1)
+	}
+	return err
+}
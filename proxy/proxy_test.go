@@ -0,0 +1,110 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/micplus/mrpc"
+)
+
+type Args struct {
+	A, B int
+}
+
+type Arith int
+
+func (*Arith) Add(args *Args, reply *int) error {
+	*reply = args.A + args.B
+	return nil
+}
+
+func (*Arith) Div(args Args, reply *int) error {
+	if args.B == 0 {
+		return mrpc.Errorf(mrpc.InvalidArgument, "divide by zero")
+	}
+	*reply = args.A / args.B
+	return nil
+}
+
+type Who string
+
+func (w *Who) Am(ctx context.Context, _ int, reply *string) error {
+	*reply = string(*w) + " " + mrpc.IncomingMetadata(ctx)["user"]
+	return nil
+}
+
+func newBackend(t *testing.T, rcvrs ...any) *mrpc.Client {
+	client, s, err := mrpc.NewClientServerPair(rcvrs...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.RegisterReflection()
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+// 前fails次调用返回连接错误
+type flaky struct {
+	Backend
+	fails int
+}
+
+func (f *flaky) CallContext(ctx context.Context, name string, args, reply any) error {
+	if !strings.HasPrefix(name, mrpc.ReflectionServiceName) && f.fails > 0 {
+		f.fails--
+		return mrpc.ErrShutDown
+	}
+	return f.Backend.CallContext(ctx, name, args, reply)
+}
+
+func assert(t *testing.T, cond bool, format string, args ...any) {
+	t.Helper()
+	if !cond {
+		t.Fatalf(format, args...)
+	}
+}
+
+func TestProxy(t *testing.T) {
+	stable, canary := Who("stable"), Who("canary")
+	p := New()
+	p.Retries = 1
+	p.Route(Route{Service: "Who", Meta: map[string]string{"env": "canary"}, Backend: newBackend(t, &canary)})
+	p.Route(Route{Service: "Who", Backend: newBackend(t, &stable)})
+	p.Route(Route{Backend: &flaky{Backend: newBackend(t, new(Arith)), fails: 3}})
+	s := p.Server()
+	client, err := mrpc.ConnectInProcess(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	var reply string
+	ctx := mrpc.WithOutgoingMetadata(context.Background(), mrpc.Metadata{"user": "alice"})
+	err = client.CallContext(ctx, "Who.Am", 0, &reply)
+	assert(t, err == nil && reply == "stable alice", "default route: %q %v", reply, err)
+	ctx = mrpc.WithOutgoingMetadata(context.Background(), mrpc.Metadata{"user": "bob", "env": "canary"})
+	err = client.CallContext(ctx, "Who.Am", 0, &reply)
+	assert(t, err == nil && reply == "canary bob", "canary route: %q %v", reply, err)
+
+	// 前两次失败：第一次重试后仍失败，第二次调用重试后成功
+	var sum int
+	err = client.Call("Arith.Add", &Args{1, 2}, &sum)
+	assert(t, mrpc.CodeOf(err) == mrpc.Unavailable, "want Unavailable, got %v", err)
+	err = client.Call("Arith.Add", &Args{1, 2}, &sum)
+	assert(t, err == nil && sum == 3, "retry: sum=%d err=%v", sum, err)
+	err = client.Call("Arith.Div", Args{1, 0}, &sum)
+	assert(t, mrpc.CodeOf(err) == mrpc.InvalidArgument && err.Error() == "divide by zero", "backend error not passed through: %v", err)
+	err = client.Call("Arith.Missing", Args{}, &sum)
+	assert(t, errors.As(err, new(mrpc.ServerError)) && strings.Contains(err.Error(), "cannot find method Arith.Missing"), "unexpected error %v", err)
+
+	stats := p.Stats()
+	assert(t, stats[0].Calls == 1 && stats[1].Calls == 1, "who stats %+v", stats)
+	assert(t, stats[2].Calls == 3 && stats[2].Errors == 2 && stats[2].Retries == 2, "arith stats %+v", stats[2])
+	var found bool
+	for _, ms := range s.MethodStats() {
+		found = found || ms.Name == "Arith.Add" && ms.Calls == 2
+	}
+	assert(t, found, "proxied methods should be in MethodStats: %+v", s.MethodStats())
+}
@@ -0,0 +1,145 @@
+package mrpc
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+)
+
+// 自动更换连接的客户端。连接收到GOAWAY、被关闭，或者调用Replace(如证书轮换后)时，
+// 先建立新连接，把之后的调用都发到新连接上，旧连接等已发出的调用完成后再关闭，
+// 调用方不会在更换期间看到错误
+//
+//	rc, err := mrpc.NewReconnectingClient(func() (*mrpc.Client, error) {
+//		return mrpc.DialTLS("tcp", addr, loadTLSConfig())
+//	})
+//	...
+//	rc.Replace() // 证书更新了
+type ReconnectingClient struct {
+	dial func() (*Client, error)
+
+	dialMu sync.Mutex // 同时只有一个调用方建立新连接
+
+	mu     sync.Mutex // protect following
+	cur    *Client
+	closed bool
+}
+
+var _ Caller = (*ReconnectingClient)(nil)
+
+// 立即建立第一条连接，失败时返回错误
+func NewReconnectingClient(dial func() (*Client, error)) (*ReconnectingClient, error) {
+	c, err := dial()
+	if err != nil {
+		return nil, err
+	}
+	return &ReconnectingClient{dial: dial, cur: c}, nil
+}
+
+// 当前的连接
+func (rc *ReconnectingClient) Client() *Client {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	return rc.cur
+}
+
+// 可用的连接，当前的不再可用时换一条
+func (rc *ReconnectingClient) client() (*Client, error) {
+	c := rc.Client()
+	if c.GetState() == Ready {
+		return c, nil
+	}
+	return rc.replace(c)
+}
+
+// 把old换成新连接，别的调用方已经换过时直接用它换上的。old排空后自己关闭
+func (rc *ReconnectingClient) replace(old *Client) (*Client, error) {
+	rc.dialMu.Lock()
+	defer rc.dialMu.Unlock()
+	rc.mu.Lock()
+	cur, closed := rc.cur, rc.closed
+	rc.mu.Unlock()
+	switch {
+	case closed:
+		return nil, ErrShutDown
+	case cur != old:
+		return cur, nil
+	}
+	c, err := rc.dial()
+	if err != nil {
+		return nil, err
+	}
+	rc.mu.Lock()
+	if rc.closed {
+		rc.mu.Unlock()
+		c.Close()
+		return nil, ErrShutDown
+	}
+	rc.cur = c
+	rc.mu.Unlock()
+	old.Drain()
+	return c, nil
+}
+
+// 建立新连接替换当前的，旧连接上已发出的调用照常完成
+func (rc *ReconnectingClient) Replace() error {
+	_, err := rc.replace(rc.Client())
+	return err
+}
+
+func (rc *ReconnectingClient) Call(name string, args, reply any, opts ...CallOption) error {
+	return rc.do(func(c *Client) error { return c.Call(name, args, reply, opts...) })
+}
+
+// 同Client.CallContext。选中的连接恰好开始排空时请求没有发出，换新连接重试一次
+func (rc *ReconnectingClient) CallContext(ctx context.Context, name string, args, reply any) error {
+	return rc.do(func(c *Client) error { return c.CallContext(ctx, name, args, reply) })
+}
+
+// 同Client.Go。在后台等待响应，选中的连接恰好开始排空时换新连接重发一次，之后才交给done
+func (rc *ReconnectingClient) Go(name string, args, reply any, done chan *Call, opts ...CallOption) *Call {
+	if done == nil {
+		done = make(chan *Call, 1)
+	} else if cap(done) == 0 {
+		log.Panic("rpc client: done channel is unbuffered")
+	}
+	call := &Call{Name: name, Args: args, Reply: reply, Done: done}
+	go func() {
+		var sent *Call
+		call.Error = rc.do(func(c *Client) error {
+			sent = <-c.Go(name, args, reply, make(chan *Call, 1), opts...).Done
+			return sent.Error
+		})
+		if sent != nil {
+			call.Seq, call.Metadata, call.Trailer = sent.Seq, sent.Metadata, sent.Trailer
+		}
+		call.done()
+	}()
+	return call
+}
+
+func (rc *ReconnectingClient) do(call func(c *Client) error) error {
+	c, err := rc.client()
+	if err != nil {
+		return err
+	}
+	err = call(c)
+	if errors.Is(err, ErrDraining) {
+		if c, err = rc.replace(c); err == nil {
+			err = call(c)
+		}
+	}
+	return err
+}
+
+// 关闭当前的连接，之后不再建立新连接
+func (rc *ReconnectingClient) Close() error {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if rc.closed {
+		return ErrShutDown
+	}
+	rc.closed = true
+	return rc.cur.Close()
+}
@@ -0,0 +1,120 @@
+package mrpc
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestReconnectingClientReplace(t *testing.T) {
+	s, g, l := newGateServer(t)
+	defer l.Close()
+	if err := s.Register(new(Echo)); err != nil {
+		t.Fatal(err)
+	}
+	var dials atomic.Int32
+	rc, err := NewReconnectingClient(func() (*Client, error) {
+		dials.Add(1)
+		return Dial("tcp", l.Addr().String())
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+
+	old := rc.Client()
+	call := old.Go("Gate.Wait", 1, new(int), nil)
+	<-g.entered
+	err = rc.Replace()
+	assert(t, err == nil && rc.Client() != old, "Replace: %v", err)
+	assert(t, old.GetState() == Degraded, "old connection should drain, got %v", old.GetState())
+	var reply string
+	err = rc.Call("Echo.Say", "hi", &reply)
+	assert(t, err == nil && reply == "hi" && dials.Load() == 2, "call after Replace: %v %q, %d dials", err, reply, dials.Load())
+
+	// 旧连接上的调用完成后它才关闭
+	close(g.release)
+	<-call.Done
+	assert(t, call.Error == nil, "in-flight call on old connection: %v", call.Error)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for state := old.GetState(); state != Shutdown; state = old.GetState() {
+		if !old.WaitForStateChange(ctx, state) {
+			t.Fatalf("old connection not closed, state %v", state)
+		}
+	}
+
+	rc.Close()
+	err = rc.Call("Echo.Say", "hi", &reply)
+	assert(t, err == ErrShutDown && dials.Load() == 2, "call after Close: %v, %d dials", err, dials.Load())
+}
+
+// 异步调用同样换到新连接上
+func TestReconnectingClientGo(t *testing.T) {
+	s := NewServer()
+	s.Register(new(Echo))
+	var dials atomic.Int32
+	rc, err := NewReconnectingClient(func() (*Client, error) {
+		dials.Add(1)
+		return ConnectInProcess(s)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+
+	rc.Client().Drain()
+	var reply string
+	call := <-rc.Go("Echo.Say", "hi", &reply, nil).Done
+	assert(t, call.Error == nil && reply == "hi" && dials.Load() == 2, "Go after drain: %v %q, %d dials", call.Error, reply, dials.Load())
+
+	rc.Close()
+	call = <-rc.Go("Echo.Say", "hi", &reply, make(chan *Call, 1)).Done
+	assert(t, call.Error == ErrShutDown, "Go after Close: %v", call.Error)
+}
+
+// 服务端优雅关闭时调用换到另一个服务端，不出错
+func TestReconnectingClientGoaway(t *testing.T) {
+	var addrs [2]string
+	servers := make([]*Server, 2)
+	for i := range servers {
+		servers[i] = NewServer()
+		servers[i].Register(new(Echo))
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer l.Close()
+		go servers[i].Accept(l)
+		addrs[i] = l.Addr().String()
+	}
+	var next atomic.Int32
+	rc, err := NewReconnectingClient(func() (*Client, error) {
+		return Dial("tcp", addrs[next.Load()])
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+
+	next.Store(1)
+	done := make(chan error, 1)
+	go func() { done <- servers[0].Shutdown(context.Background()) }()
+	for {
+		var reply string
+		err := rc.Call("Echo.Say", "hi", &reply)
+		assert(t, err == nil && reply == "hi", "call during shutdown: %v", err)
+		select {
+		case err := <-done:
+			assert(t, err == nil, "Shutdown: %v", err)
+			// 旧连接已经关闭，下一次调用一定在新连接上
+			err = rc.Call("Echo.Say", "hi", &reply)
+			assert(t, err == nil, "call after shutdown: %v", err)
+			assert(t, rc.Client().conn.RemoteAddr().String() == addrs[1], "not moved to the second server")
+			return
+		default:
+		}
+	}
+}
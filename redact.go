@@ -0,0 +1,149 @@
+package mrpc
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// 记录消息体时的脱敏规则，匹配的值记为[REDACTED]，见PayloadLogging。
+// 除了这里列出的，带`mrpc:"redact"`标签的结构体字段总是脱敏：
+//
+//	type Login struct {
+//		User     string
+//		Password string `mrpc:"redact"`
+//	}
+type Redaction struct {
+	// 这些名称的结构体字段和map的键，区分大小写
+	Fields []string
+	// 这些类型的值，指针与它指向的类型相同
+	Types []reflect.Type
+}
+
+const redactedValue = "[REDACTED]"
+
+// 嵌套太深时不再展开，也避免指针成环
+const maxRedactDepth = 10
+
+type redactor struct {
+	fields map[string]bool
+	types  map[reflect.Type]bool
+}
+
+func newRedactor(r Redaction) *redactor {
+	rd := &redactor{fields: make(map[string]bool), types: make(map[reflect.Type]bool)}
+	for _, f := range r.Fields {
+		rd.fields[f] = true
+	}
+	for _, t := range r.Types {
+		rd.types[elemType(t)] = true
+	}
+	return rd
+}
+
+// 按%+v的样子格式化v，脱敏匹配的值，截断到limit字节
+func (rd *redactor) format(v any, limit int) string {
+	var b strings.Builder
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Pointer && !rv.IsNil() { // 参数和返回值都以指针传入，不必带上&
+		rv = rv.Elem()
+	}
+	rd.write(&b, rv, 0)
+	if b.Len() > limit {
+		return fmt.Sprintf("%s...(%d bytes)", strings.ToValidUTF8(b.String()[:limit], ""), b.Len())
+	}
+	return b.String()
+}
+
+func (rd *redactor) write(b *strings.Builder, v reflect.Value, depth int) {
+	if !v.IsValid() {
+		b.WriteString("<nil>")
+		return
+	}
+	if rd.types[elemType(v.Type())] {
+		b.WriteString(redactedValue)
+		return
+	}
+	if depth > maxRedactDepth {
+		b.WriteString("...")
+		return
+	}
+	// 标准库的类型(time.Time等)按它自己的格式，其余类型的String可能带出要脱敏的字段
+	if v.CanInterface() && !strings.Contains(v.Type().PkgPath(), ".") {
+		if s, ok := v.Interface().(fmt.Stringer); ok && v.Kind() != reflect.Pointer {
+			b.WriteString(s.String())
+			return
+		}
+	}
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			b.WriteString("<nil>")
+			return
+		}
+		if v.Kind() == reflect.Pointer {
+			b.WriteByte('&')
+		}
+		rd.write(b, v.Elem(), depth+1)
+	case reflect.Struct:
+		t := v.Type()
+		b.WriteByte('{')
+		for i := 0; i < v.NumField(); i++ {
+			f := t.Field(i)
+			if i > 0 {
+				b.WriteByte(' ')
+			}
+			b.WriteString(f.Name)
+			b.WriteByte(':')
+			if rd.fields[f.Name] || f.Tag.Get("mrpc") == "redact" {
+				b.WriteString(redactedValue)
+				continue
+			}
+			rd.write(b, v.Field(i), depth+1)
+		}
+		b.WriteByte('}')
+	case reflect.Map:
+		b.WriteString("map[")
+		iter := v.MapRange()
+		for i := 0; iter.Next(); i++ {
+			if i > 0 {
+				b.WriteByte(' ')
+			}
+			k := iter.Key()
+			rd.write(b, k, depth+1)
+			b.WriteByte(':')
+			if k.Kind() == reflect.String && rd.fields[k.String()] {
+				b.WriteString(redactedValue)
+				continue
+			}
+			rd.write(b, iter.Value(), depth+1)
+		}
+		b.WriteByte(']')
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8 { // 字节按十六进制，同SetTrace
+			b.WriteString(traceBody(v.Bytes()))
+			return
+		}
+		b.WriteByte('[')
+		for i := 0; i < v.Len(); i++ {
+			if i > 0 {
+				b.WriteByte(' ')
+			}
+			rd.write(b, v.Index(i), depth+1)
+		}
+		b.WriteByte(']')
+	case reflect.String:
+		b.WriteString(v.String())
+	case reflect.Bool:
+		b.WriteString(strconv.FormatBool(v.Bool()))
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		b.WriteString(strconv.FormatInt(v.Int(), 10))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		b.WriteString(strconv.FormatUint(v.Uint(), 10))
+	case reflect.Float32, reflect.Float64:
+		b.WriteString(strconv.FormatFloat(v.Float(), 'g', -1, v.Type().Bits()))
+	default: // chan、func等
+		b.WriteString(v.Type().String())
+	}
+}
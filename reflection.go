@@ -0,0 +1,251 @@
+package mrpc
+
+import (
+	"errors"
+	"fmt"
+	"go/ast"
+	"reflect"
+	"sort"
+)
+
+// 反射服务：让客户端在运行时查询服务端注册了哪些服务、方法及其参数类型，
+// mrpccall等工具据此把JSON参数构造成服务端能解码的值
+//
+//	server.RegisterReflection()
+//	client.Call("Reflection.List", 0, &services)
+
+// 反射服务注册使用的服务名
+const ReflectionServiceName = "Reflection"
+
+// 类型的描述，可以还原出一个与原类型编码兼容的reflect.Type
+type TypeSchema struct {
+	Kind   string        // reflect.Kind的名称，如"int" "struct" "slice" "ptr"
+	Name   string        // 具名类型的完整名称，如"main.Args"，仅作展示
+	Elem   *TypeSchema   // ptr slice array map的元素类型
+	Key    *TypeSchema   // map的键类型
+	Len    int           // array的长度
+	Fields []FieldSchema // struct的导出字段
+	Ref    bool          // 递归引用了正在描述的外层类型，不再展开
+}
+
+type FieldSchema struct {
+	Name string
+	Type *TypeSchema
+}
+
+type MethodInfo struct {
+	Name    string
+	Args    *TypeSchema
+	Reply   *TypeSchema
+	Context bool // 方法的第一个参数是context.Context
+	Threads int  // WithLockedThreads的工作协程数，0表示没有锁定线程
+}
+
+type ServiceInfo struct {
+	Name       string
+	Methods    []MethodInfo
+	Middleware int // WithMiddleware设置的中间件个数
+}
+
+// 生成类型描述，只包括编解码时可见的导出字段
+func NewTypeSchema(t reflect.Type) *TypeSchema {
+	return newTypeSchema(t, make(map[reflect.Type]bool))
+}
+
+// visiting记录正在展开的结构体，遇到递归类型时只留下引用
+func newTypeSchema(t reflect.Type, visiting map[reflect.Type]bool) *TypeSchema {
+	ts := &TypeSchema{Kind: t.Kind().String()}
+	if t.Name() != "" && t.PkgPath() != "" {
+		ts.Name = t.String()
+	}
+	switch t.Kind() {
+	case reflect.Pointer, reflect.Slice:
+		ts.Elem = newTypeSchema(t.Elem(), visiting)
+	case reflect.Array:
+		ts.Elem = newTypeSchema(t.Elem(), visiting)
+		ts.Len = t.Len()
+	case reflect.Map:
+		ts.Key = newTypeSchema(t.Key(), visiting)
+		ts.Elem = newTypeSchema(t.Elem(), visiting)
+	case reflect.Struct:
+		if visiting[t] {
+			ts.Ref = true
+			return ts
+		}
+		visiting[t] = true
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if !ast.IsExported(f.Name) {
+				continue
+			}
+			ts.Fields = append(ts.Fields, FieldSchema{Name: f.Name, Type: newTypeSchema(f.Type, visiting)})
+		}
+		delete(visiting, t)
+	}
+	return ts
+}
+
+var kinds = map[string]reflect.Type{
+	"bool":       reflect.TypeOf(false),
+	"int":        reflect.TypeOf(int(0)),
+	"int8":       reflect.TypeOf(int8(0)),
+	"int16":      reflect.TypeOf(int16(0)),
+	"int32":      reflect.TypeOf(int32(0)),
+	"int64":      reflect.TypeOf(int64(0)),
+	"uint":       reflect.TypeOf(uint(0)),
+	"uint8":      reflect.TypeOf(uint8(0)),
+	"uint16":     reflect.TypeOf(uint16(0)),
+	"uint32":     reflect.TypeOf(uint32(0)),
+	"uint64":     reflect.TypeOf(uint64(0)),
+	"uintptr":    reflect.TypeOf(uintptr(0)),
+	"float32":    reflect.TypeOf(float32(0)),
+	"float64":    reflect.TypeOf(float64(0)),
+	"complex64":  reflect.TypeOf(complex64(0)),
+	"complex128": reflect.TypeOf(complex128(0)),
+	"string":     reflect.TypeOf(""),
+}
+
+// 按描述构造一个匿名的等价类型。gob按字段名而不是类型名匹配，
+// 所以用它编解码的值能与服务端的原类型互通
+func (ts *TypeSchema) Type() (reflect.Type, error) {
+	if ts.Ref {
+		return nil, fmt.Errorf("recursive type %s is not supported", ts.Name)
+	}
+	if t, ok := kinds[ts.Kind]; ok {
+		return t, nil
+	}
+	switch ts.Kind {
+	case "ptr", "slice", "array":
+		if ts.Elem == nil {
+			return nil, errors.New("missing element type of " + ts.Kind)
+		}
+		elem, err := ts.Elem.Type()
+		if err != nil {
+			return nil, err
+		}
+		switch ts.Kind {
+		case "ptr":
+			return reflect.PointerTo(elem), nil
+		case "slice":
+			return reflect.SliceOf(elem), nil
+		}
+		return reflect.ArrayOf(ts.Len, elem), nil
+	case "map":
+		if ts.Key == nil || ts.Elem == nil {
+			return nil, errors.New("missing key or element type of map")
+		}
+		key, err := ts.Key.Type()
+		if err != nil {
+			return nil, err
+		}
+		elem, err := ts.Elem.Type()
+		if err != nil {
+			return nil, err
+		}
+		return reflect.MapOf(key, elem), nil
+	case "struct":
+		fields := make([]reflect.StructField, 0, len(ts.Fields))
+		for _, f := range ts.Fields {
+			ft, err := f.Type.Type()
+			if err != nil {
+				return nil, fmt.Errorf("field %s: %w", f.Name, err)
+			}
+			fields = append(fields, reflect.StructField{Name: f.Name, Type: ft})
+		}
+		return reflect.StructOf(fields), nil
+	}
+	return nil, fmt.Errorf("unsupported kind %q", ts.Kind)
+}
+
+// 易读的类型描述，如"*struct { A int; B []string }"
+func (ts *TypeSchema) String() string {
+	if ts.Name != "" {
+		return ts.Name
+	}
+	switch ts.Kind {
+	case "ptr":
+		return "*" + ts.Elem.String()
+	case "slice":
+		return "[]" + ts.Elem.String()
+	case "array":
+		return fmt.Sprintf("[%d]%s", ts.Len, ts.Elem)
+	case "map":
+		return fmt.Sprintf("map[%s]%s", ts.Key, ts.Elem)
+	case "struct":
+		s := "struct {"
+		for i, f := range ts.Fields {
+			if i > 0 {
+				s += ";"
+			}
+			s += " " + f.Name + " " + f.Type.String()
+		}
+		if len(ts.Fields) > 0 {
+			s += " "
+		}
+		return s + "}"
+	}
+	return ts.Kind
+}
+
+func (svc *service) info() ServiceInfo {
+	info := ServiceInfo{Name: svc.name, Middleware: len(svc.middleware)}
+	for name, mt := range svc.method {
+		mi := MethodInfo{
+			Name:    name,
+			Args:    NewTypeSchema(mt.ArgType),
+			Reply:   NewTypeSchema(mt.ReplyType),
+			Context: mt.withContext,
+		}
+		if mt.threads != nil {
+			mi.Threads = mt.threads.workers
+		}
+		info.Methods = append(info.Methods, mi)
+	}
+	sort.Slice(info.Methods, func(i, j int) bool {
+		return info.Methods[i].Name < info.Methods[j].Name
+	})
+	return info
+}
+
+// 注册为ReflectionServiceName的服务，方法不导出给其它包直接使用
+type reflection struct {
+	s *Server
+}
+
+// 列出所有服务，参数无意义
+func (r *reflection) List(_ int, reply *[]ServiceInfo) error {
+	*reply = r.s.Services()
+	return nil
+}
+
+// 描述一个服务
+func (r *reflection) Describe(name string, reply *ServiceInfo) error {
+	svc, ok := r.s.serviceMap[name]
+	if !ok {
+		return errors.New("rpc server: cannot find service " + name)
+	}
+	*reply = svc.info()
+	return nil
+}
+
+// 所有注册的服务及其方法，按名称排序，嵌入mrpc的程序可以据此生成文档、网关或管理界面。
+// 与反射服务返回的相同，但不需要经过网络。WithDynamicMethods找到的方法不在其中
+func (s *Server) Services() []ServiceInfo {
+	services := make([]ServiceInfo, 0, len(s.serviceMap))
+	for _, svc := range s.serviceMap {
+		services = append(services, svc.info())
+	}
+	sort.Slice(services, func(i, j int) bool {
+		return services[i].Name < services[j].Name
+	})
+	return services
+}
+
+// 注册反射服务，它本身也会出现在列表中
+func (s *Server) RegisterReflection() error {
+	return s.RegisterName(ReflectionServiceName, &reflection{s: s})
+}
+
+func RegisterReflection() error {
+	return DefaultServer.RegisterReflection()
+}
@@ -0,0 +1,83 @@
+package mrpc
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+type Node struct {
+	Value int
+	Next  *Node
+}
+
+func TestTypeSchema(t *testing.T) {
+	ts := NewTypeSchema(reflect.TypeOf(map[string][]Pair{}))
+	assert(t, ts.String() == "map[string][]mrpc.Pair", "got %s", ts)
+	typ, err := ts.Type()
+	assert(t, err == nil && typ.String() == "map[string][]struct { A int; B int }", "got %v %v", typ, err)
+
+	ts = NewTypeSchema(reflect.TypeOf(Node{}))
+	assert(t, ts.Fields[1].Type.Elem.Ref, "recursive field should be a reference")
+	_, err = ts.Type()
+	assert(t, err != nil, "recursive type should not be rebuilt")
+}
+
+func TestReflection(t *testing.T) {
+	client, s, err := NewClientServerPair(new(Calc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if err := s.RegisterReflection(); err != nil {
+		t.Fatal(err)
+	}
+
+	var services []ServiceInfo
+	err = client.Call("Reflection.List", 0, &services)
+	assert(t, err == nil && len(services) == 2, "List = %v, %v", services, err)
+
+	var info ServiceInfo
+	err = client.Call("Reflection.Describe", "Calc", &info)
+	if err != nil || len(info.Methods) != 1 {
+		t.Fatalf("Describe = %+v, %v", info, err)
+	}
+	m := info.Methods[0]
+	assert(t, m.Name == "Sum" && m.Args.String() == "mrpc.Pair" && m.Reply.String() == "*int",
+		"unexpected method %s(%s, %s)", m.Name, m.Args, m.Reply)
+
+	// 用还原出的类型调用，不依赖Pair
+	argType, _ := m.Args.Type()
+	replyType, _ := m.Reply.Type()
+	argv := reflect.New(argType)
+	if err := json.Unmarshal([]byte(`{"A":1,"B":2}`), argv.Interface()); err != nil {
+		t.Fatal(err)
+	}
+	replyv := reflect.New(replyType.Elem())
+	err = client.Call("Calc.Sum", argv.Elem().Interface(), replyv.Interface())
+	assert(t, err == nil && replyv.Elem().Int() == 3, "dynamic Calc.Sum = %v, %v", replyv.Elem(), err)
+
+	err = client.Call("Reflection.Describe", "Missing", &info)
+	assert(t, err != nil, "Describe unknown service should fail")
+}
+
+func TestServices(t *testing.T) {
+	s := NewServer()
+	s.Register(new(Calc), WithMiddleware(func(ctx context.Context, _ string, _, _ any, next func(context.Context) error) error {
+		return next(ctx)
+	}))
+	HandleFunc(s, "GPU.Infer", func(_ context.Context, in []byte, out *[]byte) error { return nil }, WithLockedThreads(2))
+
+	services := s.Services()
+	if len(services) != 2 {
+		t.Fatalf("Services = %+v", services)
+	}
+	calc, gpu := services[0], services[1]
+	assert(t, calc.Name == "Calc" && calc.Middleware == 1 && len(calc.Methods) == 1, "unexpected %+v", calc)
+	m := calc.Methods[0]
+	assert(t, m.Name == "Sum" && m.Args.String() == "mrpc.Pair" && !m.Context && m.Threads == 0, "unexpected %+v", m)
+	m = gpu.Methods[0]
+	assert(t, gpu.Name == "GPU" && m.Name == "Infer" && m.Context && m.Threads == 2 && m.Reply.String() == "*[]uint8",
+		"unexpected %+v", m)
+}
@@ -0,0 +1,201 @@
+package gossip
+
+import (
+	"errors"
+	"net"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/micplus/mrpc"
+	"github.com/micplus/mrpc/xclient"
+)
+
+const (
+	defaultRefreshInterval = 10 * time.Second
+	defaultQueryTimeout    = time.Second
+)
+
+var errNoNodes = errors.New("rpc registry: gossip no reachable nodes")
+
+// 客户端配置
+type DiscoveryConfig struct {
+	// 任意几个节点的gossip地址，之后也会询问从成员列表中得知的节点
+	Seeds []string
+	// 要查找的mrpc服务名，空串表示所有提供服务的成员
+	Service string
+	// 定期拉取成员列表的间隔
+	RefreshInterval time.Duration
+	// 等待一个节点应答的时间，超时后换下一个节点
+	QueryTimeout time.Duration
+	// 定期拉取使用的时钟，默认mrpc.SystemClock
+	Clock mrpc.Clock
+}
+
+// 客户端使用：向节点拉取成员列表，实现xclient.Discovery。客户端不加入集群，
+// 节点不会探测它
+type Discovery struct {
+	cfg       DiscoveryConfig
+	clock     mrpc.Clock
+	conn      *net.UDPConn
+	closeOnce sync.Once
+	done      chan struct{}
+
+	refreshMu sync.Mutex // 同一时间只有一次拉取在读conn
+
+	mu        sync.Mutex // protect following
+	nodes     []string   // 可以询问的节点，Seeds在前
+	next      int        // 下次先询问的节点
+	endpoints []xclient.Endpoint
+	manual    []xclient.Endpoint // Update设置的列表，下次拉取后失效
+	fetched   bool
+}
+
+var _ xclient.Discovery = (*Discovery)(nil)
+
+func NewDiscovery(cfg DiscoveryConfig) (*Discovery, error) {
+	if len(cfg.Seeds) == 0 {
+		return nil, errors.New("rpc registry: gossip discovery needs at least one seed")
+	}
+	if cfg.RefreshInterval <= 0 {
+		cfg.RefreshInterval = defaultRefreshInterval
+	}
+	if cfg.QueryTimeout <= 0 {
+		cfg.QueryTimeout = defaultQueryTimeout
+	}
+	clock := cfg.Clock
+	if clock == nil {
+		clock = mrpc.SystemClock
+	}
+	conn, err := net.ListenUDP("udp", nil)
+	if err != nil {
+		return nil, err
+	}
+	d := &Discovery{
+		cfg:   cfg,
+		clock: clock,
+		conn:  conn,
+		done:  make(chan struct{}),
+		nodes: append([]string(nil), cfg.Seeds...),
+	}
+	go d.loop()
+	return d, nil
+}
+
+func (d *Discovery) loop() {
+	t := d.clock.NewTicker(d.cfg.RefreshInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-d.done:
+			return
+		case <-t.C():
+			d.Refresh()
+		}
+	}
+}
+
+// 依次询问已知的节点，直到一个节点应答
+func (d *Discovery) Refresh() error {
+	d.refreshMu.Lock()
+	defer d.refreshMu.Unlock()
+	d.mu.Lock()
+	nodes, start := d.nodes, d.next
+	d.mu.Unlock()
+	for i := range nodes {
+		j := (start + i) % len(nodes)
+		members, err := d.query(nodes[j])
+		if err != nil {
+			continue
+		}
+		d.update(members, j)
+		return nil
+	}
+	return errNoNodes
+}
+
+// 向一个节点拉取成员列表
+func (d *Discovery) query(node string) ([]Member, error) {
+	addr, err := net.ResolveUDPAddr("udp", node)
+	if err != nil {
+		return nil, err
+	}
+	b, _ := (&message{Type: msgSync}).pack()
+	if _, err := d.conn.WriteToUDP(b, addr); err != nil {
+		return nil, err
+	}
+	d.conn.SetReadDeadline(time.Now().Add(d.cfg.QueryTimeout))
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, from, err := d.conn.ReadFromUDP(buf)
+		if err != nil {
+			return nil, err
+		}
+		// 丢弃之前超时的节点迟到的应答
+		if from.String() != addr.String() {
+			continue
+		}
+		msg, err := unpack(buf[:n])
+		if err != nil {
+			return nil, err
+		}
+		return msg.Members, nil
+	}
+}
+
+// 记下存活的成员，下次从下一个节点开始询问，分摊各节点的负担
+func (d *Discovery) update(members []Member, answered int) {
+	var endpoints []xclient.Endpoint
+	nodes := append([]string(nil), d.cfg.Seeds...)
+	for i := range members {
+		m := &members[i]
+		if m.State != Alive {
+			continue
+		}
+		if !slices.Contains(nodes, m.Gossip) {
+			nodes = append(nodes, m.Gossip)
+		}
+		if m.Addr != "" && m.provides(d.cfg.Service) {
+			endpoints = append(endpoints, m.endpoint())
+		}
+	}
+	sort.Slice(endpoints, func(i, j int) bool { return endpoints[i].Addr < endpoints[j].Addr })
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.nodes = nodes
+	d.next = (answered + 1) % len(nodes)
+	d.endpoints = endpoints
+	d.manual = nil
+	d.fetched = true
+}
+
+func (d *Discovery) Update(endpoints []xclient.Endpoint) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.manual = endpoints
+	return nil
+}
+
+func (d *Discovery) GetAll() ([]xclient.Endpoint, error) {
+	d.mu.Lock()
+	fetched := d.fetched
+	d.mu.Unlock()
+	if !fetched {
+		if err := d.Refresh(); err != nil {
+			return nil, err
+		}
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.manual != nil {
+		return d.manual, nil
+	}
+	return d.endpoints, nil
+}
+
+func (d *Discovery) Close() error {
+	d.closeOnce.Do(func() { close(d.done) })
+	return d.conn.Close()
+}
@@ -0,0 +1,107 @@
+// gossip 去中心化的服务发现：服务端各自运行一个Node，按SWIM的方式互相探测、
+// 在探测报文中捎带成员和服务宣告，成员状态最终在所有节点上一致；客户端从任意一个节点
+// 拉取成员列表，之后轮流询问已知的节点，不依赖单个注册中心。
+//
+// 每条消息捎带全部成员，适合几十到几百个实例的集群。
+package gossip
+
+import (
+	"encoding/json"
+	"strconv"
+
+	"github.com/micplus/mrpc/xclient"
+)
+
+// 一条UDP消息的上限
+const maxPacketSize = 65507
+
+// 成员状态，同一incarnation下Dead > Suspect > Alive
+type State uint8
+
+const (
+	Alive State = iota
+	Suspect
+	Dead
+)
+
+func (s State) String() string {
+	switch s {
+	case Alive:
+		return "alive"
+	case Suspect:
+		return "suspect"
+	case Dead:
+		return "dead"
+	}
+	return "State(" + strconv.Itoa(int(s)) + ")"
+}
+
+// 集群成员及其宣告的服务
+type Member struct {
+	// 成员名，集群内唯一
+	Name string `json:"name"`
+	// 其他节点探测它使用的UDP地址
+	Gossip string `json:"gossip"`
+	// mrpc服务地址，形如"tcp@10.0.0.1:1234"，为空时只参与gossip，不提供服务
+	Addr     string            `json:"addr,omitempty"`
+	Services []string          `json:"services,omitempty"`
+	Weight   int               `json:"weight,omitempty"`
+	Meta     map[string]string `json:"meta,omitempty"`
+	// 只有成员自己能增大它，用来反驳别人对它的怀疑，或者宣告新的服务
+	Incarnation uint64 `json:"inc"`
+	State       State  `json:"state"`
+}
+
+// other是否比m新：incarnation大的新，相同时状态更坏的新
+func (m *Member) olderThan(other *Member) bool {
+	if m.Incarnation != other.Incarnation {
+		return m.Incarnation < other.Incarnation
+	}
+	return m.State < other.State
+}
+
+// service为空时表示任意服务
+func (m *Member) provides(service string) bool {
+	if service == "" {
+		return true
+	}
+	for _, s := range m.Services {
+		if s == service {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *Member) endpoint() xclient.Endpoint {
+	return xclient.Endpoint{Addr: m.Addr, Weight: m.Weight, Meta: m.Meta}
+}
+
+type msgType uint8
+
+const (
+	msgPing    msgType = iota + 1 // 探测，对方回复msgAck
+	msgAck                        // 探测的应答
+	msgPingReq                    // 请对方代为探测Target，收到应答后转回msgAck
+	msgGossip                     // 只传播成员，不需要应答，如离开集群
+	msgSync                       // 客户端拉取成员列表，回复msgGossip
+)
+
+type message struct {
+	Type    msgType  `json:"type"`
+	Seq     uint64   `json:"seq,omitempty"`
+	Target  string   `json:"target,omitempty"`
+	Members []Member `json:"members,omitempty"`
+}
+
+func (msg *message) pack() ([]byte, error) {
+	return json.Marshal(msg)
+}
+
+func unpack(b []byte) (*message, error) {
+	msg := new(message)
+	if err := json.Unmarshal(b, msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
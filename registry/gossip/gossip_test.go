@@ -0,0 +1,112 @@
+package gossip
+
+import (
+	"testing"
+	"time"
+
+	"github.com/micplus/mrpc"
+)
+
+func startNode(t *testing.T, name string, services []string, seeds ...string) *Node {
+	n, err := Start(Config{
+		Name:           name,
+		Bind:           "127.0.0.1:0",
+		Addr:           "tcp@" + name + ":9000",
+		Services:       services,
+		Seeds:          seeds,
+		ProbeInterval:  20 * time.Millisecond,
+		SuspectTimeout: 100 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { n.Close() })
+	return n
+}
+
+// 等到n看到的各成员状态为want
+func waitStates(t *testing.T, n *Node, want map[string]State) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		got := make(map[string]State)
+		for _, m := range n.Members() {
+			got[m.Name] = m.State
+		}
+		ok := true
+		for name, state := range want {
+			if s, found := got[name]; !found || s != state {
+				ok = false
+			}
+		}
+		if ok {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%s: want %v, got %v", n.self, want, got)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestCluster(t *testing.T) {
+	a := startNode(t, "a", []string{"Arith"})
+	seed := a.Addr().String()
+	b := startNode(t, "b", []string{"Arith", "Echo"}, seed)
+	c := startNode(t, "c", []string{"Echo"}, seed)
+	all := map[string]State{"a": Alive, "b": Alive, "c": Alive}
+	waitStates(t, a, all)
+	waitStates(t, b, all) // b从a的应答中得知c
+	waitStates(t, c, all)
+
+	// 客户端从c引导，之后即使c不在也能从其他节点拉取
+	d, err := NewDiscovery(DiscoveryConfig{Seeds: []string{c.Addr().String()}, Service: "Arith"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	eps, err := d.GetAll()
+	if err != nil || len(eps) != 2 || eps[0].Addr != "tcp@a:9000" || eps[1].Addr != "tcp@b:9000" {
+		t.Fatalf("want a and b, got %v err=%v", eps, err)
+	}
+
+	// c不宣告离开就停止，其他成员经过怀疑确认它死亡
+	c.shutdown(false)
+	waitStates(t, a, map[string]State{"c": Dead})
+	waitStates(t, b, map[string]State{"c": Dead})
+
+	// b宣告离开，立即传到a
+	b.Close()
+	waitStates(t, a, map[string]State{"a": Alive, "b": Dead})
+	if err := d.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	eps, _ = d.GetAll()
+	if len(eps) != 1 || eps[0].Addr != "tcp@a:9000" {
+		t.Fatalf("want only a, got %v", eps)
+	}
+	d.Close() // 可以重复关闭，defer中还会再关闭一次
+}
+
+func TestMergeRefutes(t *testing.T) {
+	n := &Node{self: "a", clock: mrpc.SystemClock, members: map[string]*entry{
+		"a": {Member: Member{Name: "a", Incarnation: 5}},
+		"b": {Member: Member{Name: "b", Incarnation: 3}},
+	}}
+	// 同一incarnation下怀疑盖过存活，旧的incarnation被忽略
+	n.merge([]Member{{Name: "b", Incarnation: 3, State: Suspect}})
+	n.merge([]Member{{Name: "b", Incarnation: 2, State: Alive}})
+	if m := n.members["b"]; m.State != Suspect {
+		t.Errorf("b: want suspect, got %v", m.State)
+	}
+	// b反驳后恢复存活
+	n.merge([]Member{{Name: "b", Incarnation: 4, State: Alive}})
+	if m := n.members["b"]; m.State != Alive {
+		t.Errorf("b: want alive, got %v", m.State)
+	}
+	// 有人怀疑本节点时增大incarnation反驳
+	n.merge([]Member{{Name: "a", Incarnation: 5, State: Suspect}})
+	if m := n.members["a"]; m.State != Alive || m.Incarnation != 6 {
+		t.Errorf("a: want alive at 6, got %v at %d", m.State, m.Incarnation)
+	}
+}
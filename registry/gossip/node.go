@@ -0,0 +1,440 @@
+package gossip
+
+import (
+	"log"
+	"math/rand"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/micplus/mrpc"
+)
+
+const (
+	defaultProbeInterval  = time.Second
+	defaultIndirectChecks = 3
+)
+
+// 服务端配置
+type Config struct {
+	// 成员名，默认取Advertise
+	Name string
+	// gossip监听的UDP地址，如":7946"
+	Bind string
+	// 其他节点访问本节点的gossip地址，默认取实际监听的地址，Bind是未指定地址时必须设置
+	Advertise string
+	// 本节点的mrpc服务地址和提供的服务，见Member
+	Addr     string
+	Services []string
+	Weight   int
+	Meta     map[string]string
+	// 启动时联系的节点，任意一个可达即可加入集群。集群中没有其他成员时会反复联系它们
+	Seeds []string
+	// 每隔多久探测一个成员，默认1秒
+	ProbeInterval time.Duration
+	// 等待直接探测应答的时间，超时后请其他成员代为探测，默认ProbeInterval的一半
+	ProbeTimeout time.Duration
+	// 代为探测的成员数，默认3
+	IndirectChecks int
+	// 被怀疑的成员这么久没有反驳就视为死亡，默认5个ProbeInterval
+	SuspectTimeout time.Duration
+	// 探测计时使用的时钟，默认mrpc.SystemClock
+	Clock mrpc.Clock
+}
+
+// 本地记录的成员
+type entry struct {
+	Member
+	since time.Time // 进入当前状态的时间
+}
+
+// 等待探测的应答
+type waiter struct {
+	fn       func()
+	deadline time.Time
+}
+
+// 服务端使用：加入集群，探测其他成员，宣告本节点的服务
+type Node struct {
+	cfg   Config
+	clock mrpc.Clock
+	conn  *net.UDPConn
+	self  string
+
+	closeOnce sync.Once
+	done      chan struct{}
+
+	mu      sync.Mutex // protect following
+	members map[string]*entry
+	waiters map[uint64]waiter
+	seq     uint64
+	order   []string // 本轮探测的顺序，探测完一轮重新打乱
+}
+
+// 开始监听并联系Seeds加入集群
+func Start(cfg Config) (*Node, error) {
+	if cfg.ProbeInterval <= 0 {
+		cfg.ProbeInterval = defaultProbeInterval
+	}
+	if cfg.ProbeTimeout <= 0 || cfg.ProbeTimeout >= cfg.ProbeInterval {
+		cfg.ProbeTimeout = cfg.ProbeInterval / 2
+	}
+	if cfg.IndirectChecks <= 0 {
+		cfg.IndirectChecks = defaultIndirectChecks
+	}
+	if cfg.SuspectTimeout <= 0 {
+		cfg.SuspectTimeout = 5 * cfg.ProbeInterval
+	}
+	clock := cfg.Clock
+	if clock == nil {
+		clock = mrpc.SystemClock
+	}
+	laddr, err := net.ResolveUDPAddr("udp", cfg.Bind)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp", laddr)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Advertise == "" {
+		cfg.Advertise = conn.LocalAddr().String()
+	}
+	if cfg.Name == "" {
+		cfg.Name = cfg.Advertise
+	}
+	now := clock.Now()
+	n := &Node{
+		cfg:     cfg,
+		clock:   clock,
+		conn:    conn,
+		self:    cfg.Name,
+		done:    make(chan struct{}),
+		members: make(map[string]*entry),
+		waiters: make(map[uint64]waiter),
+	}
+	// 重启的节点用更大的incarnation，盖过集群中记录的它上次离开时的状态
+	n.members[n.self] = &entry{Member: Member{
+		Name:        cfg.Name,
+		Gossip:      cfg.Advertise,
+		Addr:        cfg.Addr,
+		Services:    cfg.Services,
+		Weight:      cfg.Weight,
+		Meta:        cfg.Meta,
+		Incarnation: uint64(now.UnixNano()),
+	}, since: now}
+	go n.serve()
+	n.join()
+	go n.loop()
+	return n, nil
+}
+
+// 本节点实际监听的gossip地址
+func (n *Node) Addr() net.Addr {
+	return n.conn.LocalAddr()
+}
+
+// 已知的全部成员，包括本节点和尚未遗忘的死亡成员，按名称排序
+func (n *Node) Members() []Member {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.snapshot()
+}
+
+// 持有n.mu时调用
+func (n *Node) snapshot() []Member {
+	members := make([]Member, 0, len(n.members))
+	for _, e := range n.members {
+		members = append(members, e.Member)
+	}
+	sort.Slice(members, func(i, j int) bool { return members[i].Name < members[j].Name })
+	return members
+}
+
+// 改变本节点宣告的服务，随之后的探测传播到整个集群
+func (n *Node) SetServices(services []string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	self := n.members[n.self]
+	self.Services = services
+	self.Incarnation++
+}
+
+// 向所有成员宣告离开，然后停止探测和应答
+func (n *Node) Close() error {
+	return n.shutdown(true)
+}
+
+func (n *Node) shutdown(leave bool) error {
+	err := net.ErrClosed
+	n.closeOnce.Do(func() {
+		if leave {
+			n.mu.Lock()
+			self := n.members[n.self]
+			self.Incarnation++
+			self.State = Dead
+			var peers []string
+			for _, e := range n.members {
+				if e.Name != n.self && e.State != Dead {
+					peers = append(peers, e.Gossip)
+				}
+			}
+			n.mu.Unlock()
+			for _, addr := range peers {
+				n.send(addr, &message{Type: msgGossip})
+			}
+		}
+		close(n.done)
+		err = n.conn.Close()
+	})
+	return err
+}
+
+// 发送msg，捎带全部成员
+func (n *Node) send(addr string, msg *message) {
+	n.mu.Lock()
+	msg.Members = n.snapshot()
+	n.mu.Unlock()
+	n.sendTo(addr, msg)
+}
+
+func (n *Node) sendTo(addr string, msg *message) {
+	b, err := msg.pack()
+	if err != nil {
+		log.Println("rpc registry: gossip encode error:", err)
+		return
+	}
+	if len(b) > maxPacketSize {
+		log.Println("rpc registry: gossip message too large:", len(b))
+		return
+	}
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		log.Println("rpc registry: gossip resolve error:", err)
+		return
+	}
+	n.conn.WriteToUDP(b, udpAddr)
+}
+
+// 向Seeds发送探测，应答中带着它们知道的成员
+func (n *Node) join() {
+	for _, addr := range n.cfg.Seeds {
+		if addr != n.cfg.Advertise {
+			n.send(addr, &message{Type: msgPing})
+		}
+	}
+}
+
+func (n *Node) serve() {
+	buf := make([]byte, maxPacketSize)
+	for {
+		size, from, err := n.conn.ReadFromUDP(buf)
+		if err != nil {
+			select {
+			case <-n.done:
+			default:
+				log.Println("rpc registry: gossip read error:", err)
+			}
+			return
+		}
+		msg, err := unpack(buf[:size])
+		if err != nil {
+			continue
+		}
+		n.merge(msg.Members)
+		switch msg.Type {
+		case msgPing:
+			n.send(from.String(), &message{Type: msgAck, Seq: msg.Seq})
+		case msgAck:
+			n.acked(msg.Seq)
+		case msgPingReq:
+			n.relay(from.String(), msg)
+		case msgSync:
+			n.send(from.String(), &message{Type: msgGossip})
+		}
+	}
+}
+
+// 代为探测msg.Target，收到应答后以原来的编号回复请求方
+func (n *Node) relay(from string, msg *message) {
+	seq := msg.Seq
+	relaySeq := n.expect(func() { n.sendTo(from, &message{Type: msgAck, Seq: seq}) }, n.cfg.ProbeTimeout)
+	n.send(msg.Target, &message{Type: msgPing, Seq: relaySeq})
+}
+
+// 登记一个等待应答的编号，返回这个编号
+func (n *Node) expect(fn func(), timeout time.Duration) uint64 {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.seq++
+	n.waiters[n.seq] = waiter{fn: fn, deadline: n.clock.Now().Add(timeout)}
+	return n.seq
+}
+
+func (n *Node) acked(seq uint64) {
+	n.mu.Lock()
+	w, ok := n.waiters[seq]
+	delete(n.waiters, seq)
+	n.mu.Unlock()
+	if ok {
+		w.fn()
+	}
+}
+
+// 合并收到的成员，有关本节点的怀疑或死亡消息用更大的incarnation反驳
+func (n *Node) merge(members []Member) {
+	if len(members) == 0 {
+		return
+	}
+	now := n.clock.Now()
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for _, m := range members {
+		if m.Name == n.self {
+			self := n.members[n.self]
+			if self.State == Alive && m.State != Alive && m.Incarnation >= self.Incarnation {
+				self.Incarnation = m.Incarnation + 1
+			}
+			continue
+		}
+		cur, ok := n.members[m.Name]
+		if !ok {
+			n.members[m.Name] = &entry{Member: m, since: now}
+			continue
+		}
+		if !cur.olderThan(&m) {
+			continue
+		}
+		if m.State != cur.State {
+			cur.since = now
+			if m.State == Dead {
+				log.Printf("rpc registry: gossip member %s is dead", m.Name)
+			}
+		}
+		cur.Member = m
+	}
+}
+
+func (n *Node) loop() {
+	t := n.clock.NewTicker(n.cfg.ProbeInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-n.done:
+			return
+		case <-t.C():
+		}
+		n.expire(n.clock.Now())
+		n.probe()
+	}
+}
+
+// 怀疑超时的成员视为死亡，死亡很久的成员遗忘，丢弃超时的等待
+func (n *Node) expire(now time.Time) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for name, e := range n.members {
+		switch {
+		case e.State == Suspect && now.Sub(e.since) >= n.cfg.SuspectTimeout:
+			e.State, e.since = Dead, now
+			log.Printf("rpc registry: gossip member %s is dead", name)
+		case e.State == Dead && now.Sub(e.since) >= 10*n.cfg.SuspectTimeout:
+			// 死亡的消息已经传遍集群，之后收到的旧消息不会再让它复活
+			delete(n.members, name)
+		}
+	}
+	for seq, w := range n.waiters {
+		if now.After(w.deadline) {
+			delete(n.waiters, seq)
+		}
+	}
+}
+
+// 探测下一个成员：直接探测没有应答时请其他成员代为探测，仍然没有应答就怀疑它
+func (n *Node) probe() {
+	target, ok := n.next()
+	if !ok {
+		n.join()
+		return
+	}
+	acked := make(chan struct{}, 1)
+	seq := n.expect(func() {
+		select {
+		case acked <- struct{}{}:
+		default:
+		}
+	}, n.cfg.ProbeInterval)
+	n.send(target.Gossip, &message{Type: msgPing, Seq: seq})
+	if n.wait(acked, n.cfg.ProbeTimeout) {
+		return
+	}
+	for _, addr := range n.helpers(target.Name) {
+		n.send(addr, &message{Type: msgPingReq, Seq: seq, Target: target.Gossip})
+	}
+	if n.wait(acked, n.cfg.ProbeInterval-n.cfg.ProbeTimeout) {
+		return
+	}
+	n.suspect(target)
+}
+
+func (n *Node) wait(acked chan struct{}, d time.Duration) bool {
+	t := n.clock.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-acked:
+		return true
+	case <-t.C():
+		return false
+	case <-n.done:
+		return true
+	}
+}
+
+// 按打乱的顺序轮流探测没有死亡的成员
+func (n *Node) next() (Member, bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for {
+		if len(n.order) == 0 {
+			for name, e := range n.members {
+				if name != n.self && e.State != Dead {
+					n.order = append(n.order, name)
+				}
+			}
+			if len(n.order) == 0 {
+				return Member{}, false
+			}
+			rand.Shuffle(len(n.order), func(i, j int) { n.order[i], n.order[j] = n.order[j], n.order[i] })
+		}
+		name := n.order[0]
+		n.order = n.order[1:]
+		if e, ok := n.members[name]; ok && e.State != Dead {
+			return e.Member, true
+		}
+	}
+}
+
+// 随机挑选最多IndirectChecks个代为探测的成员
+func (n *Node) helpers(target string) []string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	var addrs []string
+	for name, e := range n.members {
+		if name != n.self && name != target && e.State == Alive {
+			addrs = append(addrs, e.Gossip)
+		}
+	}
+	rand.Shuffle(len(addrs), func(i, j int) { addrs[i], addrs[j] = addrs[j], addrs[i] })
+	return addrs[:min(len(addrs), n.cfg.IndirectChecks)]
+}
+
+// 探测期间成员没有更新时才怀疑它
+func (n *Node) suspect(target Member) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	e, ok := n.members[target.Name]
+	if ok && e.State == Alive && e.Incarnation == target.Incarnation {
+		e.State, e.since = Suspect, n.clock.Now()
+	}
+}
@@ -0,0 +1,215 @@
+// mdns 局域网内的零配置服务发现：服务端通过mDNS(DNS-SD)宣告自己，客户端组播查询即可找到实例，
+// 不需要任何注册中心，适合开发环境和嵌入式设备集群。
+package mdns
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	// DNS-SD中mrpc使用的服务类型
+	DefaultServiceType = "_mrpc._tcp"
+	domain             = "local."
+	defaultTTL         = 120
+)
+
+var (
+	mdnsGroup = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+)
+
+// TXT记录中使用的键
+const (
+	txtServices = "services" // 逗号分隔的mrpc服务名
+	txtWeight   = "weight"
+)
+
+// 宣告的实例信息
+type Instance struct {
+	// 实例名，默认取主机名+端口
+	Name string
+	// DNS-SD服务类型，默认DefaultServiceType
+	ServiceType string
+	// 该实例提供的mrpc服务名，如"Arith"，客户端据此过滤
+	Services []string
+	Port     int
+	// 实例地址，默认取本机所有非回环地址
+	IPs    []net.IP
+	Weight int
+	Meta   map[string]string
+	// 组播使用的网卡，nil表示由系统选择
+	Interface *net.Interface
+}
+
+func (in *Instance) serviceName() string {
+	st := in.ServiceType
+	if st == "" {
+		st = DefaultServiceType
+	}
+	return canonical(st + "." + domain)
+}
+
+func (in *Instance) instanceName() string {
+	return canonical(escapeLabel(in.Name) + "." + in.serviceName())
+}
+
+func (in *Instance) hostName() string {
+	return canonical(escapeLabel(in.Name) + "." + domain)
+}
+
+// 实例名作为单个标签，不能含"."
+func escapeLabel(s string) string {
+	return strings.ReplaceAll(s, ".", "-")
+}
+
+func (in *Instance) txt() []string {
+	txt := []string{txtServices + "=" + strings.Join(in.Services, ",")}
+	if in.Weight > 0 {
+		txt = append(txt, txtWeight+"="+strconv.Itoa(in.Weight))
+	}
+	keys := make([]string, 0, len(in.Meta))
+	for k := range in.Meta {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		txt = append(txt, k+"="+in.Meta[k])
+	}
+	return txt
+}
+
+// 针对这个实例的完整应答：PTR作为Answer，SRV/TXT/A作为附加记录
+func (in *Instance) records(ttl uint32) (answers, extra []record) {
+	answers = []record{{Name: in.serviceName(), Type: typePTR, TTL: ttl, Target: in.instanceName()}}
+	extra = []record{
+		{Name: in.instanceName(), Type: typeSRV, TTL: ttl, Port: uint16(in.Port), Target: in.hostName()},
+		{Name: in.instanceName(), Type: typeTXT, TTL: ttl, Txt: in.txt()},
+	}
+	for _, ip := range in.IPs {
+		if ip4 := ip.To4(); ip4 != nil {
+			extra = append(extra, record{Name: in.hostName(), Type: typeA, TTL: ttl, IP: ip4})
+		} else {
+			extra = append(extra, record{Name: in.hostName(), Type: typeAAAA, TTL: ttl, IP: ip})
+		}
+	}
+	return
+}
+
+// 默认地址：本机的非回环单播地址；一个都没有时退回回环地址
+func localIPs() []net.IP {
+	var ips, loopback []net.IP
+	addrs, _ := net.InterfaceAddrs()
+	for _, a := range addrs {
+		ipnet, ok := a.(*net.IPNet)
+		if !ok || ipnet.IP.IsLinkLocalUnicast() {
+			continue
+		}
+		if ipnet.IP.IsLoopback() {
+			loopback = append(loopback, ipnet.IP)
+		} else {
+			ips = append(ips, ipnet.IP)
+		}
+	}
+	if len(ips) == 0 {
+		return loopback
+	}
+	return ips
+}
+
+// 服务端使用：在组播组上监听查询，回答对本实例的查询
+type Announcer struct {
+	in   Instance
+	conn *net.UDPConn
+
+	closeOnce sync.Once
+	done      chan struct{}
+}
+
+// 开始宣告实例，并主动广播一次，让正在监听的客户端立即发现
+func Announce(in Instance) (*Announcer, error) {
+	if in.Port <= 0 {
+		return nil, fmt.Errorf("rpc registry: mdns invalid port %d", in.Port)
+	}
+	if in.Name == "" {
+		host, _ := os.Hostname()
+		in.Name = fmt.Sprintf("%s-%d", host, in.Port)
+	}
+	if len(in.IPs) == 0 {
+		in.IPs = localIPs()
+	}
+	conn, err := net.ListenMulticastUDP("udp4", in.Interface, mdnsGroup)
+	if err != nil {
+		return nil, err
+	}
+	a := &Announcer{in: in, conn: conn, done: make(chan struct{})}
+	a.announce(defaultTTL)
+	go a.serve()
+	return a, nil
+}
+
+func (a *Announcer) announce(ttl uint32) {
+	answers, extra := a.in.records(ttl)
+	msg := &message{Response: true, Answers: answers, Extra: extra}
+	if _, err := a.conn.WriteToUDP(msg.pack(), mdnsGroup); err != nil {
+		log.Println("rpc registry: mdns announce error:", err)
+	}
+}
+
+func (a *Announcer) serve() {
+	buf := make([]byte, 9000)
+	for {
+		n, from, err := a.conn.ReadFromUDP(buf)
+		if err != nil {
+			select {
+			case <-a.done:
+			default:
+				log.Println("rpc registry: mdns read error:", err)
+			}
+			return
+		}
+		msg, err := unpack(buf[:n])
+		if err != nil || msg.Response {
+			continue
+		}
+		if resp := a.answer(msg); resp != nil {
+			dst := mdnsGroup
+			// 不是从5353端口发出的查询，按传统单播DNS回复给查询者
+			if from.Port != mdnsGroup.Port {
+				dst = from
+				resp.ID = msg.ID
+				resp.Questions = msg.Questions
+			}
+			a.conn.WriteToUDP(resp.pack(), dst)
+		}
+	}
+}
+
+// 查询服务类型、实例名或主机名时回答，其余忽略
+func (a *Announcer) answer(q *message) *message {
+	service, instance, host := a.in.serviceName(), a.in.instanceName(), a.in.hostName()
+	for _, qq := range q.Questions {
+		name := canonical(qq.Name)
+		if name == service || name == instance || name == host {
+			answers, extra := a.in.records(defaultTTL)
+			return &message{Response: true, Answers: answers, Extra: extra}
+		}
+	}
+	return nil
+}
+
+// 发送TTL为0的告别报文，让客户端立即移除本实例，然后停止应答
+func (a *Announcer) Close() error {
+	var err error
+	a.closeOnce.Do(func() {
+		a.announce(0)
+		close(a.done)
+		err = a.conn.Close()
+	})
+	return err
+}
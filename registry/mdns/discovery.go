@@ -0,0 +1,296 @@
+package mdns
+
+import (
+	"log"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/micplus/mrpc"
+	"github.com/micplus/mrpc/xclient"
+)
+
+const (
+	defaultRefreshInterval = 30 * time.Second
+	defaultQueryWait       = 500 * time.Millisecond
+)
+
+// 客户端配置
+type Config struct {
+	// 要查找的mrpc服务名，空串表示该服务类型下的所有实例
+	Service string
+	// DNS-SD服务类型，默认DefaultServiceType
+	ServiceType string
+	// 定期重新查询的间隔
+	RefreshInterval time.Duration
+	// 一次查询等待应答的时间
+	QueryWait time.Duration
+	Interface *net.Interface
+	// 定期查询、等待应答和记录过期使用的时钟，默认mrpc.SystemClock
+	Clock mrpc.Clock
+}
+
+func (cfg *Config) clock() mrpc.Clock {
+	if cfg.Clock != nil {
+		return cfg.Clock
+	}
+	return mrpc.SystemClock
+}
+
+type srvInfo struct {
+	host   string
+	port   uint16
+	expire time.Time
+}
+
+type txtInfo struct {
+	txt    []string
+	expire time.Time
+}
+
+type addrInfo struct {
+	ips map[string]time.Time // ip -> 过期时间
+}
+
+// 客户端使用：组播查询并监听宣告，维护实例缓存，实现xclient.Discovery
+type Discovery struct {
+	cfg       Config
+	service   string // 规范化的服务类型域名
+	conn      *net.UDPConn
+	closeOnce sync.Once
+	done      chan struct{}
+
+	mu        sync.Mutex           // protect following
+	instances map[string]time.Time // 实例名 -> PTR过期时间
+	srv       map[string]srvInfo
+	txt       map[string]txtInfo
+	addrs     map[string]addrInfo // 主机名 -> 地址
+	manual    []xclient.Endpoint  // Update设置的列表，收到新应答后失效
+	queried   bool
+}
+
+var _ xclient.Discovery = (*Discovery)(nil)
+
+func NewDiscovery(cfg Config) (*Discovery, error) {
+	if cfg.RefreshInterval <= 0 {
+		cfg.RefreshInterval = defaultRefreshInterval
+	}
+	if cfg.QueryWait <= 0 {
+		cfg.QueryWait = defaultQueryWait
+	}
+	conn, err := net.ListenMulticastUDP("udp4", cfg.Interface, mdnsGroup)
+	if err != nil {
+		return nil, err
+	}
+	d := &Discovery{
+		cfg:       cfg,
+		service:   (&Instance{ServiceType: cfg.ServiceType}).serviceName(),
+		conn:      conn,
+		done:      make(chan struct{}),
+		instances: make(map[string]time.Time),
+		srv:       make(map[string]srvInfo),
+		txt:       make(map[string]txtInfo),
+		addrs:     make(map[string]addrInfo),
+	}
+	go d.listen()
+	go d.loop()
+	return d, nil
+}
+
+func (d *Discovery) loop() {
+	t := d.cfg.clock().NewTicker(d.cfg.RefreshInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-d.done:
+			return
+		case <-t.C():
+			d.query()
+		}
+	}
+}
+
+func (d *Discovery) query() error {
+	msg := &message{Questions: []question{{Name: d.service, Type: typePTR}}}
+	_, err := d.conn.WriteToUDP(msg.pack(), mdnsGroup)
+	return err
+}
+
+// 接收组播组上的所有应答，包括其他客户端触发的应答和服务端的主动宣告
+func (d *Discovery) listen() {
+	buf := make([]byte, 9000)
+	for {
+		n, _, err := d.conn.ReadFromUDP(buf)
+		if err != nil {
+			select {
+			case <-d.done:
+			default:
+				log.Println("rpc registry: mdns read error:", err)
+			}
+			return
+		}
+		if msg, err := unpack(buf[:n]); err == nil && msg.Response {
+			d.handle(msg)
+		}
+	}
+}
+
+// 按记录类型更新缓存，TTL为0表示删除
+func (d *Discovery) handle(msg *message) {
+	now := d.cfg.clock().Now()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, rr := range msg.Answers {
+		expire := now.Add(time.Duration(rr.TTL) * time.Second)
+		switch rr.Type {
+		case typePTR:
+			if rr.Name != d.service {
+				continue
+			}
+			if rr.TTL == 0 {
+				delete(d.instances, rr.Target)
+			} else {
+				d.instances[rr.Target] = expire
+			}
+		case typeSRV:
+			d.srv[rr.Name] = srvInfo{host: rr.Target, port: rr.Port, expire: expire}
+		case typeTXT:
+			d.txt[rr.Name] = txtInfo{txt: rr.Txt, expire: expire}
+		case typeA, typeAAAA:
+			ai, ok := d.addrs[rr.Name]
+			if !ok {
+				ai = addrInfo{ips: make(map[string]time.Time)}
+				d.addrs[rr.Name] = ai
+			}
+			if rr.TTL == 0 {
+				delete(ai.ips, rr.IP.String())
+			} else {
+				ai.ips[rr.IP.String()] = expire
+			}
+		}
+	}
+	d.manual = nil
+}
+
+// 组播一次查询，并等待一段时间收集应答
+func (d *Discovery) Refresh() error {
+	if err := d.query(); err != nil {
+		return err
+	}
+	t := d.cfg.clock().NewTimer(d.cfg.QueryWait)
+	<-t.C()
+	d.mu.Lock()
+	d.queried = true
+	d.mu.Unlock()
+	return nil
+}
+
+func (d *Discovery) Update(endpoints []xclient.Endpoint) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.manual = endpoints
+	return nil
+}
+
+func (d *Discovery) GetAll() ([]xclient.Endpoint, error) {
+	d.mu.Lock()
+	queried := d.queried
+	d.mu.Unlock()
+	if !queried {
+		if err := d.Refresh(); err != nil {
+			return nil, err
+		}
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.manual != nil {
+		return d.manual, nil
+	}
+	return d.endpoints(d.cfg.clock().Now()), nil
+}
+
+// 把缓存中未过期的记录组装成实例列表
+func (d *Discovery) endpoints(now time.Time) []xclient.Endpoint {
+	var endpoints []xclient.Endpoint
+	for name, expire := range d.instances {
+		if now.After(expire) {
+			delete(d.instances, name)
+			continue
+		}
+		srv, ok := d.srv[name]
+		if !ok || now.After(srv.expire) {
+			continue
+		}
+		ep := xclient.Endpoint{Meta: make(map[string]string)}
+		var services []string
+		if txt, ok := d.txt[name]; ok && !now.After(txt.expire) {
+			for _, kv := range txt.txt {
+				k, v, _ := strings.Cut(kv, "=")
+				switch k {
+				case txtServices:
+					services = strings.Split(v, ",")
+				case txtWeight:
+					ep.Weight, _ = strconv.Atoi(v)
+				default:
+					ep.Meta[k] = v
+				}
+			}
+		}
+		if !d.provides(services) {
+			continue
+		}
+		ip := d.pickIP(srv.host, now)
+		if ip == "" {
+			continue
+		}
+		ep.Addr = "tcp@" + net.JoinHostPort(ip, strconv.Itoa(int(srv.port)))
+		endpoints = append(endpoints, ep)
+	}
+	sort.Slice(endpoints, func(i, j int) bool { return endpoints[i].Addr < endpoints[j].Addr })
+	return endpoints
+}
+
+func (d *Discovery) provides(services []string) bool {
+	if d.cfg.Service == "" {
+		return true
+	}
+	for _, s := range services {
+		if s == d.cfg.Service {
+			return true
+		}
+	}
+	return false
+}
+
+// 同一主机可能宣告多个地址，优先IPv4，保证结果稳定
+func (d *Discovery) pickIP(host string, now time.Time) string {
+	var v4, v6 []string
+	for ip, expire := range d.addrs[host].ips {
+		if now.After(expire) {
+			continue
+		}
+		if strings.Contains(ip, ":") {
+			v6 = append(v6, ip)
+		} else {
+			v4 = append(v4, ip)
+		}
+	}
+	sort.Strings(v4)
+	sort.Strings(v6)
+	if len(v4) > 0 {
+		return v4[0]
+	}
+	if len(v6) > 0 {
+		return v6[0]
+	}
+	return ""
+}
+
+func (d *Discovery) Close() error {
+	d.closeOnce.Do(func() { close(d.done) })
+	return d.conn.Close()
+}
@@ -0,0 +1,62 @@
+package mdns
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestMessageRoundTrip(t *testing.T) {
+	in := &Instance{
+		Name:     "node1",
+		Services: []string{"Arith", "Echo"},
+		Port:     9001,
+		IPs:      []net.IP{net.IPv4(192, 168, 1, 10)},
+		Weight:   5,
+		Meta:     map[string]string{"zone": "a"},
+	}
+	answers, extra := in.records(defaultTTL)
+	msg, err := unpack((&message{Response: true, Answers: answers, Extra: extra}).pack())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !msg.Response || len(msg.Answers) != 4 {
+		t.Fatalf("want 4 records, got %d", len(msg.Answers))
+	}
+	if ptr := msg.Answers[0]; ptr.Type != typePTR || ptr.Target != "node1._mrpc._tcp.local." {
+		t.Errorf("unexpected PTR %+v", ptr)
+	}
+}
+
+func TestDiscoveryEndpoints(t *testing.T) {
+	d := &Discovery{
+		cfg:       Config{Service: "Arith"},
+		service:   (&Instance{}).serviceName(),
+		instances: make(map[string]time.Time),
+		srv:       make(map[string]srvInfo),
+		txt:       make(map[string]txtInfo),
+		addrs:     make(map[string]addrInfo),
+	}
+	announce := func(in *Instance, ttl uint32) {
+		answers, extra := in.records(ttl)
+		msg, _ := unpack((&message{Response: true, Answers: answers, Extra: extra}).pack())
+		d.handle(msg)
+	}
+	a := &Instance{Name: "a", Services: []string{"Arith"}, Port: 9001, IPs: []net.IP{net.IPv4(10, 0, 0, 1)}, Weight: 2, Meta: map[string]string{"zone": "z1"}}
+	b := &Instance{Name: "b", Services: []string{"Echo"}, Port: 9002, IPs: []net.IP{net.IPv4(10, 0, 0, 2)}}
+	announce(a, defaultTTL)
+	announce(b, defaultTTL)
+
+	eps := d.endpoints(time.Now())
+	if len(eps) != 1 {
+		t.Fatalf("want 1 Arith endpoint, got %v", eps)
+	}
+	if eps[0].Addr != "tcp@10.0.0.1:9001" || eps[0].Weight != 2 || eps[0].Meta["zone"] != "z1" {
+		t.Errorf("unexpected endpoint %+v", eps[0])
+	}
+
+	announce(a, 0) // goodbye
+	if eps := d.endpoints(time.Now()); len(eps) != 0 {
+		t.Errorf("want no endpoints after goodbye, got %v", eps)
+	}
+}
@@ -0,0 +1,237 @@
+package mdns
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"strings"
+)
+
+// 只实现mDNS/DNS-SD用到的那一小部分DNS报文：
+// PTR(服务类型 -> 实例)、SRV(实例 -> 主机:端口)、TXT(元数据)、A/AAAA(主机 -> 地址)
+
+const (
+	typeA    uint16 = 1
+	typePTR  uint16 = 12
+	typeTXT  uint16 = 16
+	typeAAAA uint16 = 28
+	typeSRV  uint16 = 33
+	typeANY  uint16 = 255
+
+	classIN uint16 = 1
+	// mDNS中class最高位在问题里表示希望单播回复，在记录里表示cache-flush
+	classMask uint16 = 0x7fff
+
+	flagResponse uint16 = 0x8400 // QR + AA
+)
+
+var errMalformed = errors.New("rpc registry: mdns malformed message")
+
+type question struct {
+	Name string
+	Type uint16
+}
+
+type record struct {
+	Name string
+	Type uint16
+	TTL  uint32
+
+	// 依Type使用其中的字段
+	Target string   // PTR、SRV
+	Port   uint16   // SRV
+	Txt    []string // TXT
+	IP     net.IP   // A、AAAA
+}
+
+type message struct {
+	ID        uint16
+	Response  bool
+	Questions []question
+	Answers   []record // 解析时Answer与Additional合并在一起
+	Extra     []record
+}
+
+// 域名统一为小写、以"."结尾
+func canonical(name string) string {
+	name = strings.ToLower(name)
+	if !strings.HasSuffix(name, ".") {
+		name += "."
+	}
+	return name
+}
+
+func appendName(b []byte, name string) []byte {
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		if label == "" {
+			continue
+		}
+		b = append(b, byte(len(label)))
+		b = append(b, label...)
+	}
+	return append(b, 0)
+}
+
+func (m *message) pack() []byte {
+	b := make([]byte, 12, 512)
+	binary.BigEndian.PutUint16(b[0:], m.ID)
+	if m.Response {
+		binary.BigEndian.PutUint16(b[2:], flagResponse)
+	}
+	binary.BigEndian.PutUint16(b[4:], uint16(len(m.Questions)))
+	binary.BigEndian.PutUint16(b[6:], uint16(len(m.Answers)))
+	binary.BigEndian.PutUint16(b[10:], uint16(len(m.Extra)))
+	for _, q := range m.Questions {
+		b = appendName(b, q.Name)
+		b = binary.BigEndian.AppendUint16(b, q.Type)
+		b = binary.BigEndian.AppendUint16(b, classIN)
+	}
+	for _, rrs := range [][]record{m.Answers, m.Extra} {
+		for _, rr := range rrs {
+			b = rr.pack(b)
+		}
+	}
+	return b
+}
+
+func (rr *record) pack(b []byte) []byte {
+	b = appendName(b, rr.Name)
+	b = binary.BigEndian.AppendUint16(b, rr.Type)
+	b = binary.BigEndian.AppendUint16(b, classIN)
+	b = binary.BigEndian.AppendUint32(b, rr.TTL)
+	lenAt := len(b)
+	b = append(b, 0, 0) // rdlength稍后回填
+	switch rr.Type {
+	case typePTR:
+		b = appendName(b, rr.Target)
+	case typeSRV:
+		b = append(b, 0, 0, 0, 0) // priority, weight
+		b = binary.BigEndian.AppendUint16(b, rr.Port)
+		b = appendName(b, rr.Target)
+	case typeTXT:
+		if len(rr.Txt) == 0 {
+			b = append(b, 0)
+		}
+		for _, s := range rr.Txt {
+			if len(s) > 255 {
+				s = s[:255]
+			}
+			b = append(b, byte(len(s)))
+			b = append(b, s...)
+		}
+	case typeA:
+		b = append(b, rr.IP.To4()...)
+	case typeAAAA:
+		b = append(b, rr.IP.To16()...)
+	}
+	binary.BigEndian.PutUint16(b[lenAt:], uint16(len(b)-lenAt-2))
+	return b
+}
+
+// 读取域名，支持压缩指针
+func readName(msg []byte, off int) (string, int, error) {
+	var sb strings.Builder
+	end := -1 // 第一次跳转前的位置，即返回给调用者的偏移
+	for jumps := 0; ; {
+		if off >= len(msg) {
+			return "", 0, errMalformed
+		}
+		n := int(msg[off])
+		switch {
+		case n == 0:
+			off++
+			if end < 0 {
+				end = off
+			}
+			if sb.Len() == 0 {
+				sb.WriteByte('.')
+			}
+			return strings.ToLower(sb.String()), end, nil
+		case n&0xc0 == 0xc0:
+			if off+1 >= len(msg) || jumps > 10 {
+				return "", 0, errMalformed
+			}
+			if end < 0 {
+				end = off + 2
+			}
+			off = int(binary.BigEndian.Uint16(msg[off:]) & 0x3fff)
+			jumps++
+		default:
+			off++
+			if off+n > len(msg) {
+				return "", 0, errMalformed
+			}
+			sb.Write(msg[off : off+n])
+			sb.WriteByte('.')
+			off += n
+		}
+	}
+}
+
+func unpack(msg []byte) (*message, error) {
+	if len(msg) < 12 {
+		return nil, errMalformed
+	}
+	m := &message{
+		ID:       binary.BigEndian.Uint16(msg),
+		Response: msg[2]&0x80 != 0,
+	}
+	qd := int(binary.BigEndian.Uint16(msg[4:]))
+	rrs := int(binary.BigEndian.Uint16(msg[6:])) + int(binary.BigEndian.Uint16(msg[8:])) + int(binary.BigEndian.Uint16(msg[10:]))
+	off := 12
+	for i := 0; i < qd; i++ {
+		name, next, err := readName(msg, off)
+		if err != nil || next+4 > len(msg) {
+			return nil, errMalformed
+		}
+		m.Questions = append(m.Questions, question{Name: name, Type: binary.BigEndian.Uint16(msg[next:])})
+		off = next + 4
+	}
+	for i := 0; i < rrs; i++ {
+		name, next, err := readName(msg, off)
+		if err != nil || next+10 > len(msg) {
+			return nil, errMalformed
+		}
+		rr := record{
+			Name: name,
+			Type: binary.BigEndian.Uint16(msg[next:]),
+			TTL:  binary.BigEndian.Uint32(msg[next+4:]),
+		}
+		rdlen := int(binary.BigEndian.Uint16(msg[next+8:]))
+		start := next + 10
+		if start+rdlen > len(msg) {
+			return nil, errMalformed
+		}
+		rdata := msg[start : start+rdlen]
+		switch rr.Type {
+		case typePTR:
+			if rr.Target, _, err = readName(msg, start); err != nil {
+				return nil, err
+			}
+		case typeSRV:
+			if rdlen < 7 {
+				return nil, errMalformed
+			}
+			rr.Port = binary.BigEndian.Uint16(rdata[4:])
+			if rr.Target, _, err = readName(msg, start+6); err != nil {
+				return nil, err
+			}
+		case typeTXT:
+			for j := 0; j < len(rdata); {
+				n := int(rdata[j])
+				if j+1+n > len(rdata) {
+					return nil, errMalformed
+				}
+				if n > 0 {
+					rr.Txt = append(rr.Txt, string(rdata[j+1:j+1+n]))
+				}
+				j += 1 + n
+			}
+		case typeA, typeAAAA:
+			rr.IP = net.IP(append([]byte(nil), rdata...))
+		}
+		m.Answers = append(m.Answers, rr)
+		off = start + rdlen
+	}
+	return m, nil
+}
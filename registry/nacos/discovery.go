@@ -0,0 +1,161 @@
+package nacos
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"reflect"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/micplus/mrpc/xclient"
+)
+
+// /nacos/v1/ns/instance/list 的响应
+type instanceList struct {
+	Hosts []struct {
+		IP       string            `json:"ip"`
+		Port     int               `json:"port"`
+		Weight   float64           `json:"weight"`
+		Healthy  bool              `json:"healthy"`
+		Enabled  bool              `json:"enabled"`
+		Metadata map[string]string `json:"metadata"`
+	} `json:"hosts"`
+}
+
+// 客户端使用：从Nacos获取某个服务的健康实例，实现xclient.Discovery。
+// 后台按RefreshInterval轮询，实例列表变化时通知订阅者
+type Discovery struct {
+	cfg       Config
+	service   string
+	closeOnce sync.Once
+	done      chan struct{}
+
+	mu        sync.RWMutex // protect following
+	endpoints []xclient.Endpoint
+	lastFetch time.Time
+	watchers  []func([]xclient.Endpoint)
+}
+
+var _ xclient.Discovery = (*Discovery)(nil)
+
+func NewDiscovery(cfg Config, service string) *Discovery {
+	if cfg.RefreshInterval <= 0 {
+		cfg.RefreshInterval = defaultRefreshInterval
+	}
+	d := &Discovery{
+		cfg:     cfg,
+		service: service,
+		done:    make(chan struct{}),
+	}
+	go d.watch()
+	return d
+}
+
+func (d *Discovery) watch() {
+	t := d.cfg.clock().NewTicker(d.cfg.RefreshInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-d.done:
+			return
+		case <-t.C():
+			if err := d.Refresh(); err != nil {
+				logf("refresh %s error: %v", d.service, err)
+			}
+		}
+	}
+}
+
+// 拉取健康且启用的实例，权重为0的实例不参与负载均衡
+func (d *Discovery) fetch() ([]xclient.Endpoint, error) {
+	v := d.cfg.values(d.service)
+	v.Set("healthyOnly", "true")
+	if d.cfg.Cluster != "" {
+		v.Set("clusters", d.cfg.Cluster)
+	}
+	data, err := d.cfg.do(http.MethodGet, "/nacos/v1/ns/instance/list", v)
+	if err != nil {
+		return nil, err
+	}
+	var list instanceList
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, err
+	}
+	endpoints := make([]xclient.Endpoint, 0, len(list.Hosts))
+	for _, h := range list.Hosts {
+		if !h.Healthy || !h.Enabled || h.Weight <= 0 {
+			continue
+		}
+		network := "tcp"
+		if n := h.Metadata[metaNetwork]; n != "" {
+			network = n
+		}
+		endpoints = append(endpoints, xclient.Endpoint{
+			Addr:   network + "@" + net.JoinHostPort(h.IP, strconv.Itoa(h.Port)),
+			Weight: int(h.Weight + 0.5),
+			Meta:   h.Metadata,
+		})
+	}
+	return endpoints, nil
+}
+
+func (d *Discovery) Refresh() error {
+	endpoints, err := d.fetch()
+	if err != nil {
+		return err
+	}
+	return d.Update(endpoints)
+}
+
+// 更新实例列表，有变化时回调订阅者
+func (d *Discovery) Update(endpoints []xclient.Endpoint) error {
+	d.mu.Lock()
+	changed := !reflect.DeepEqual(d.endpoints, endpoints)
+	d.endpoints = endpoints
+	d.lastFetch = d.cfg.clock().Now()
+	watchers := d.watchers
+	d.mu.Unlock()
+
+	if changed {
+		for _, fn := range watchers {
+			fn(endpoints)
+		}
+	}
+	return nil
+}
+
+// 首次调用或缓存过期时同步拉取一次
+func (d *Discovery) GetAll() ([]xclient.Endpoint, error) {
+	d.mu.RLock()
+	stale := d.lastFetch.Add(d.cfg.RefreshInterval).Before(d.cfg.clock().Now())
+	endpoints := d.endpoints
+	d.mu.RUnlock()
+	if stale {
+		if err := d.Refresh(); err != nil {
+			if endpoints == nil {
+				return nil, err
+			}
+			logf("refresh %s error, using cached endpoints: %v", d.service, err)
+			return endpoints, nil
+		}
+		d.mu.RLock()
+		endpoints = d.endpoints
+		d.mu.RUnlock()
+	}
+	return endpoints, nil
+}
+
+// 订阅实例列表的变化
+func (d *Discovery) Subscribe(fn func([]xclient.Endpoint)) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.watchers = append(d.watchers, fn)
+}
+
+// 停止后台轮询
+func (d *Discovery) Close() error {
+	d.closeOnce.Do(func() { close(d.done) })
+	return nil
+}
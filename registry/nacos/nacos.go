@@ -0,0 +1,131 @@
+// nacos 对接Nacos注册中心：服务端注册实例并定时发送心跳，客户端拉取实例列表并订阅变化。
+// 只使用Nacos的HTTP Open API(v1)，不引入官方SDK。
+package nacos
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/micplus/mrpc"
+)
+
+const (
+	defaultBeatInterval    = 5 * time.Second
+	defaultRefreshInterval = 10 * time.Second
+)
+
+// 连接Nacos所需的配置，零值字段使用Nacos的默认值
+type Config struct {
+	// Nacos服务地址，如"http://127.0.0.1:8848"
+	Server    string
+	Namespace string // namespaceId，默认public
+	Group     string // groupName，默认DEFAULT_GROUP
+	Cluster   string // clusterName，默认DEFAULT
+
+	// 临时实例的心跳间隔
+	BeatInterval time.Duration
+	// 客户端拉取实例列表的间隔
+	RefreshInterval time.Duration
+
+	HTTPClient *http.Client
+	// 心跳、拉取间隔和过期判断使用的时钟，默认mrpc.SystemClock
+	Clock mrpc.Clock
+}
+
+func (cfg *Config) httpClient() *http.Client {
+	if cfg.HTTPClient != nil {
+		return cfg.HTTPClient
+	}
+	return http.DefaultClient
+}
+
+func (cfg *Config) clock() mrpc.Clock {
+	if cfg.Clock != nil {
+		return cfg.Clock
+	}
+	return mrpc.SystemClock
+}
+
+// 公共参数
+func (cfg *Config) values(service string) url.Values {
+	v := url.Values{}
+	v.Set("serviceName", service)
+	if cfg.Namespace != "" {
+		v.Set("namespaceId", cfg.Namespace)
+	}
+	if cfg.Group != "" {
+		v.Set("groupName", cfg.Group)
+	}
+	return v
+}
+
+func (cfg *Config) cluster() string {
+	if cfg.Cluster == "" {
+		return "DEFAULT"
+	}
+	return cfg.Cluster
+}
+
+// 发出请求，非2xx响应视为错误，返回响应体。
+// Nacos对DELETE/PUT不解析表单体，所以参数统一放在查询串里
+func (cfg *Config) do(method, path string, v url.Values) ([]byte, error) {
+	u := strings.TrimRight(cfg.Server, "/") + path + "?" + v.Encode()
+	req, err := http.NewRequest(method, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := cfg.httpClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("rpc registry: nacos %s %s: %s %s", method, path, resp.Status, data)
+	}
+	return data, nil
+}
+
+// 把"tcp@127.0.0.1:1234"拆成Nacos使用的ip、port，网络类型存进元数据
+func splitAddr(addr string) (network, ip string, port int, err error) {
+	network = "tcp"
+	if i := strings.Index(addr, "@"); i >= 0 {
+		network, addr = addr[:i], addr[i+1:]
+	}
+	host, p, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", "", 0, err
+	}
+	port, err = strconv.Atoi(p)
+	if err != nil {
+		return "", "", 0, errors.New("rpc registry: invalid port " + p)
+	}
+	return network, host, port, nil
+}
+
+// 元数据中用来保存网络类型的键
+const metaNetwork = "mrpc.network"
+
+func logf(format string, v ...any) {
+	log.Printf("rpc registry: nacos "+format, v...)
+}
+
+func encodeMeta(meta map[string]string) string {
+	if len(meta) == 0 {
+		return ""
+	}
+	b, _ := json.Marshal(meta) // map[string]string不会出错
+	return string(b)
+}
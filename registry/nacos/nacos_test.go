@@ -0,0 +1,90 @@
+package nacos
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/micplus/mrpc/xclient"
+)
+
+// 模拟Nacos的实例注册、注销、列表接口
+type fakeNacos struct {
+	mu    sync.Mutex
+	hosts map[string]map[string]any // ip:port -> host
+}
+
+func (f *fakeNacos) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	q := r.URL.Query()
+	key := q.Get("ip") + ":" + q.Get("port")
+	switch {
+	case r.URL.Path == "/nacos/v1/ns/instance" && r.Method == http.MethodPost:
+		var meta map[string]string
+		json.Unmarshal([]byte(q.Get("metadata")), &meta)
+		var port int
+		json.Unmarshal([]byte(q.Get("port")), &port)
+		var weight float64
+		json.Unmarshal([]byte(q.Get("weight")), &weight)
+		f.hosts[key] = map[string]any{
+			"ip": q.Get("ip"), "port": port, "weight": weight,
+			"healthy": true, "enabled": true, "metadata": meta,
+		}
+	case r.URL.Path == "/nacos/v1/ns/instance" && r.Method == http.MethodDelete:
+		delete(f.hosts, key)
+	case r.URL.Path == "/nacos/v1/ns/instance/beat":
+	case r.URL.Path == "/nacos/v1/ns/instance/list":
+		hosts := []any{}
+		for _, h := range f.hosts {
+			hosts = append(hosts, h)
+		}
+		json.NewEncoder(w).Encode(map[string]any{"hosts": hosts})
+		return
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	w.Write([]byte("ok"))
+}
+
+func TestRegisterAndDiscover(t *testing.T) {
+	ts := httptest.NewServer(&fakeNacos{hosts: make(map[string]map[string]any)})
+	defer ts.Close()
+	cfg := Config{Server: ts.URL, RefreshInterval: time.Hour}
+
+	r := NewRegistrar(cfg)
+	defer r.Close()
+	ep := xclient.Endpoint{Addr: "tcp@127.0.0.1:9001", Weight: 3, Meta: map[string]string{"version": "2"}}
+	if err := r.Register("Arith", ep); err != nil {
+		t.Fatal(err)
+	}
+
+	d := NewDiscovery(cfg, "Arith")
+	defer d.Close()
+	changed := make(chan []xclient.Endpoint, 1)
+	d.Subscribe(func(eps []xclient.Endpoint) { changed <- eps })
+
+	eps, err := d.GetAll()
+	if err != nil || len(eps) != 1 {
+		t.Fatalf("want 1 endpoint, got %v %v", eps, err)
+	}
+	if eps[0].Addr != ep.Addr || eps[0].Weight != 3 || eps[0].Meta["version"] != "2" {
+		t.Errorf("unexpected endpoint %+v", eps[0])
+	}
+	<-changed
+
+	if err := r.Deregister("Arith", ep); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	if eps := <-changed; len(eps) != 0 {
+		t.Errorf("want no endpoints after deregister, got %v", eps)
+	}
+	d.Close() // 可以重复关闭，defer中还会再关闭一次
+}
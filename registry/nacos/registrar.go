@@ -0,0 +1,154 @@
+package nacos
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/micplus/mrpc/xclient"
+)
+
+// 服务端使用：把实例注册为Nacos临时实例，并在后台发送心跳维持健康状态
+type Registrar struct {
+	cfg Config
+
+	mu    sync.Mutex               // protect following
+	beats map[string]chan struct{} // service+addr -> 停止心跳
+}
+
+func NewRegistrar(cfg Config) *Registrar {
+	if cfg.BeatInterval <= 0 {
+		cfg.BeatInterval = defaultBeatInterval
+	}
+	return &Registrar{
+		cfg:   cfg,
+		beats: make(map[string]chan struct{}),
+	}
+}
+
+// 注册实例的参数
+func (r *Registrar) instanceValues(service string, ep xclient.Endpoint) (map[string]string, error) {
+	network, ip, port, err := splitAddr(ep.Addr)
+	if err != nil {
+		return nil, err
+	}
+	meta := make(map[string]string, len(ep.Meta)+1)
+	for k, v := range ep.Meta {
+		meta[k] = v
+	}
+	if network != "tcp" {
+		meta[metaNetwork] = network
+	}
+	weight := ep.Weight
+	if weight <= 0 {
+		weight = 1
+	}
+	return map[string]string{
+		"ip":          ip,
+		"port":        strconv.Itoa(port),
+		"weight":      strconv.Itoa(weight),
+		"clusterName": r.cfg.cluster(),
+		"metadata":    encodeMeta(meta),
+		"ephemeral":   "true",
+		"enabled":     "true",
+		"healthy":     "true",
+	}, nil
+}
+
+// 注册一个实例，权重和元数据取自ep，随后开始心跳
+func (r *Registrar) Register(service string, ep xclient.Endpoint) error {
+	params, err := r.instanceValues(service, ep)
+	if err != nil {
+		return err
+	}
+	v := r.cfg.values(service)
+	for k, val := range params {
+		v.Set(k, val)
+	}
+	if _, err := r.cfg.do(http.MethodPost, "/nacos/v1/ns/instance", v); err != nil {
+		return err
+	}
+
+	key := service + "|" + ep.Addr
+	stop := make(chan struct{})
+	r.mu.Lock()
+	if old, ok := r.beats[key]; ok { // 重复注册，替换旧的心跳
+		close(old)
+	}
+	r.beats[key] = stop
+	r.mu.Unlock()
+	go r.heartbeat(service, params, stop)
+	return nil
+}
+
+// Nacos通过心跳判断临时实例是否存活，超时未收到会将实例标记为不健康并最终删除
+func (r *Registrar) heartbeat(service string, params map[string]string, stop chan struct{}) {
+	port, _ := strconv.Atoi(params["port"])
+	weight, _ := strconv.Atoi(params["weight"])
+	var meta map[string]string
+	if params["metadata"] != "" {
+		json.Unmarshal([]byte(params["metadata"]), &meta)
+	}
+	beat, _ := json.Marshal(map[string]any{
+		"serviceName": service,
+		"ip":          params["ip"],
+		"port":        port,
+		"cluster":     params["clusterName"],
+		"weight":      weight,
+		"metadata":    meta,
+		"scheduled":   true,
+	})
+	v := r.cfg.values(service)
+	v.Set("ip", params["ip"])
+	v.Set("port", params["port"])
+	v.Set("clusterName", params["clusterName"])
+	v.Set("beat", string(beat))
+
+	t := r.cfg.clock().NewTicker(r.cfg.BeatInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-t.C():
+			if _, err := r.cfg.do(http.MethodPut, "/nacos/v1/ns/instance/beat", v); err != nil {
+				logf("heartbeat %s %s:%s error: %v", service, params["ip"], params["port"], err)
+			}
+		}
+	}
+}
+
+// 停止心跳并注销实例
+func (r *Registrar) Deregister(service string, ep xclient.Endpoint) error {
+	key := service + "|" + ep.Addr
+	r.mu.Lock()
+	if stop, ok := r.beats[key]; ok {
+		close(stop)
+		delete(r.beats, key)
+	}
+	r.mu.Unlock()
+
+	_, ip, port, err := splitAddr(ep.Addr)
+	if err != nil {
+		return err
+	}
+	v := r.cfg.values(service)
+	v.Set("ip", ip)
+	v.Set("port", strconv.Itoa(port))
+	v.Set("clusterName", r.cfg.cluster())
+	v.Set("ephemeral", "true")
+	_, err = r.cfg.do(http.MethodDelete, "/nacos/v1/ns/instance", v)
+	return err
+}
+
+// 停止所有心跳，实例会在Nacos判定超时后被移除；需要立即下线请先Deregister
+func (r *Registrar) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for key, stop := range r.beats {
+		close(stop)
+		delete(r.beats, key)
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+package mrpc
+
+import (
+	"container/heap"
+	"context"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// 重放保护的窗口：签名时间必须在服务端时间前后Skew之内，窗口内每个nonce只接受一次，
+// 截获的请求原样重发会被拒绝。窗口之外的旧请求由时间检查拒绝，所以只需记住最近2*Skew内的nonce
+type ReplayWindow struct {
+	// 签名时间与服务端时间允许的偏差，<=0时为DefaultReplaySkew
+	Skew time.Duration
+	// 每个身份窗口内最多记录的nonce数，满了之后拒绝新的请求，<=0时为DefaultReplayWindowSize
+	Size int
+	// 区分调用方，每个身份的窗口各自计数，一个调用方发得太多不影响其它调用方。
+	// 为nil时按TLS证书的身份(见IdentityFromContext)，没有证书的调用方共用一个窗口
+	Identity func(ctx context.Context) string
+	// 为nil时用SystemClock
+	Clock Clock
+}
+
+const (
+	DefaultReplaySkew       = 5 * time.Minute
+	DefaultReplayWindowSize = 100000
+)
+
+// 拒绝重放请求的中间件，应当放在VerifySignatures之后，只记录签名正确的nonce：
+//
+//	s.Register(new(Payment), mrpc.WithMiddleware(
+//		mrpc.VerifySignatures(key, 0), mrpc.RejectReplays(mrpc.ReplayWindow{Skew: time.Minute})))
+//
+// 没有nonce、时间超出窗口或者nonce已经用过的调用返回Unauthenticated，
+// 窗口满了返回ResourceExhausted
+func RejectReplays(w ReplayWindow) Middleware {
+	if w.Skew <= 0 {
+		w.Skew = DefaultReplaySkew
+	}
+	if w.Size <= 0 {
+		w.Size = DefaultReplayWindowSize
+	}
+	if w.Identity == nil {
+		w.Identity = tlsIdentity
+	}
+	w.Clock = clockOrSystem(w.Clock)
+	g := &replayGuard{window: w, seen: make(map[string]*nonceSet)}
+	return func(ctx context.Context, method string, arg, reply any, next func(ctx context.Context) error) error {
+		if err := g.check(ctx); err != nil {
+			return err
+		}
+		return next(ctx)
+	}
+}
+
+func tlsIdentity(ctx context.Context) string {
+	id := IdentityFromContext(ctx)
+	switch {
+	case id == nil:
+		return ""
+	case id.SPIFFEID != "":
+		return id.SPIFFEID
+	}
+	return id.CommonName
+}
+
+type replayGuard struct {
+	window ReplayWindow
+	mu     sync.Mutex // protect seen
+	seen   map[string]*nonceSet
+}
+
+func (g *replayGuard) check(ctx context.Context) error {
+	md := IncomingMetadata(ctx)
+	nonce := md[SignatureNonceKey]
+	if nonce == "" {
+		return Errorf(Unauthenticated, "rpc server: request has no nonce")
+	}
+	ts, err := strconv.ParseInt(md[SignatureTimeKey], 10, 64)
+	if err != nil {
+		return Errorf(Unauthenticated, "rpc server: invalid signature time")
+	}
+	now := g.window.Clock.Now()
+	signed := time.Unix(0, ts)
+	if skew := now.Sub(signed); skew > g.window.Skew || skew < -g.window.Skew {
+		return Errorf(Unauthenticated, "rpc server: request outside the replay window")
+	}
+	id := g.window.Identity(ctx)
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	set := g.seen[id]
+	if set == nil {
+		set = &nonceSet{expires: make(map[string]time.Time)}
+		g.seen[id] = set
+	}
+	set.prune(now)
+	if _, ok := set.expires[nonce]; ok {
+		return Errorf(Unauthenticated, "rpc server: replayed request")
+	}
+	if len(set.expires) >= g.window.Size {
+		return Errorf(ResourceExhausted, "rpc server: too many requests in the replay window")
+	}
+	// 签名时间超过Skew后时间检查就会拒绝它，不必再记
+	set.add(nonce, signed.Add(g.window.Skew))
+	return nil
+}
+
+// 一个身份窗口内的nonce，按过期时间排成小顶堆
+type nonceSet struct {
+	expires map[string]time.Time
+	order   nonceHeap
+}
+
+func (s *nonceSet) add(nonce string, expire time.Time) {
+	s.expires[nonce] = expire
+	heap.Push(&s.order, nonceEntry{nonce, expire})
+}
+
+func (s *nonceSet) prune(now time.Time) {
+	for len(s.order) > 0 && !s.order[0].expire.After(now) {
+		e := heap.Pop(&s.order).(nonceEntry)
+		delete(s.expires, e.nonce)
+	}
+}
+
+type nonceEntry struct {
+	nonce  string
+	expire time.Time
+}
+
+type nonceHeap []nonceEntry
+
+func (h nonceHeap) Len() int           { return len(h) }
+func (h nonceHeap) Less(i, j int) bool { return h[i].expire.Before(h[j].expire) }
+func (h nonceHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *nonceHeap) Push(x any)        { *h = append(*h, x.(nonceEntry)) }
+func (h *nonceHeap) Pop() any {
+	old := *h
+	e := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return e
+}
@@ -0,0 +1,57 @@
+package mrpc
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestRejectReplays(t *testing.T) {
+	key := []byte("k")
+	s := NewServer()
+	HandleFunc(s, "Bank.Transfer", func(_ context.Context, tr Transfer, reply *int64) error {
+		*reply = tr.Amount
+		return nil
+	})
+	s.SetServiceOptions("Bank", WithMiddleware(VerifySignatures(key, 0), RejectReplays(ReplayWindow{Skew: time.Minute, Size: 3})))
+	client := pipeClient(t, s, WithRequestSigning(key))
+
+	var reply int64
+	for i := 0; i < 3; i++ {
+		err := client.Call("Bank.Transfer", Transfer{Amount: 1}, &reply)
+		assert(t, err == nil, "call %d: %v", i, err)
+	}
+	err := client.Call("Bank.Transfer", Transfer{Amount: 1}, &reply)
+	assert(t, CodeOf(err) == ResourceExhausted, "window full: %v", err)
+}
+
+func TestReplayWindow(t *testing.T) {
+	now := time.Now()
+	clock := &skewedClock{Clock: SystemClock}
+	mw := RejectReplays(ReplayWindow{Skew: time.Minute, Clock: clock, Identity: func(ctx context.Context) string {
+		return IncomingMetadata(ctx)["user"]
+	}})
+	call := func(user, nonce string, signed time.Time) error {
+		ctx := withIncomingMetadata(context.Background(), Metadata{
+			"user":            user,
+			SignatureNonceKey: nonce,
+			SignatureTimeKey:  strconv.FormatInt(signed.UnixNano(), 10),
+		})
+		return mw(ctx, "Bank.Transfer", nil, nil, func(context.Context) error { return nil })
+	}
+	assert(t, call("a", "n1", now) == nil, "first use")
+	err := call("a", "n1", now)
+	assert(t, CodeOf(err) == Unauthenticated && err.Error() == "rpc server: replayed request", "replay: %v", err)
+	assert(t, call("b", "n1", now) == nil, "identities have separate windows")
+	err = call("a", "n2", now.Add(-2*time.Minute))
+	assert(t, CodeOf(err) == Unauthenticated, "too old: %v", err)
+	err = call("a", "", now)
+	assert(t, CodeOf(err) == Unauthenticated, "no nonce: %v", err)
+
+	// 过了窗口，旧的nonce被清掉，它的请求也因为时间被拒绝
+	clock.d = 2 * time.Minute
+	err = call("a", "n1", now)
+	assert(t, CodeOf(err) == Unauthenticated && err.Error() == "rpc server: request outside the replay window", "expired: %v", err)
+	assert(t, call("a", "n3", now.Add(2*time.Minute)) == nil, "new request after the window")
+}
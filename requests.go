@@ -0,0 +1,145 @@
+package mrpc
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// 请求追踪：记录正在处理的请求，以及每个方法最近完成的请求和最近出错的请求，
+// 在调试页面上展示，线上排查时能直接看到卡住的请求和刚发生的错误。
+// 用WithRequestTrace开启，每个请求多一次加锁
+
+// 一个请求的记录
+type RequestTrace struct {
+	Method  string
+	Seq     uint64
+	Remote  string // 客户端地址，ServeCodec时为空
+	Start   time.Time
+	Elapsed time.Duration // 正在处理的请求是到目前为止的耗时
+	Error   string
+	Active  bool
+}
+
+type requestLog struct {
+	size int // 每个方法保留的记录数
+
+	mu      sync.Mutex // protect following
+	nextID  uint64
+	active  map[uint64]*RequestTrace
+	methods map[string]*methodLog
+}
+
+// 环形缓冲，满了覆盖最早的
+type traceRing struct {
+	traces []RequestTrace
+	next   int
+}
+
+func (r *traceRing) add(t RequestTrace, size int) {
+	if len(r.traces) < size {
+		r.traces = append(r.traces, t)
+		return
+	}
+	r.traces[r.next] = t
+	r.next = (r.next + 1) % size
+}
+
+type methodLog struct {
+	recent traceRing
+	errors traceRing // 出错的请求单独保留，不会被大量成功的请求挤掉
+}
+
+func newRequestLog(size int) *requestLog {
+	return &requestLog{
+		size:    size,
+		active:  make(map[uint64]*RequestTrace),
+		methods: make(map[string]*methodLog),
+	}
+}
+
+// 开始处理，返回结束时使用的id。l为nil时不记录
+func (l *requestLog) begin(method string, seq uint64, remote string) uint64 {
+	if l == nil {
+		return 0
+	}
+	t := &RequestTrace{Method: method, Seq: seq, Remote: remote, Start: time.Now(), Active: true}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.nextID++
+	l.active[l.nextID] = t
+	return l.nextID
+}
+
+func (l *requestLog) end(id uint64, err error) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	t, ok := l.active[id]
+	if !ok {
+		return
+	}
+	delete(l.active, id)
+	t.Active = false
+	t.Elapsed = time.Since(t.Start)
+	if err != nil {
+		t.Error = err.Error()
+	}
+	ml := l.methods[t.Method]
+	if ml == nil {
+		ml = new(methodLog)
+		l.methods[t.Method] = ml
+	}
+	ml.recent.add(*t, l.size)
+	if err != nil {
+		ml.errors.add(*t, l.size)
+	}
+}
+
+// 最新的在前
+func sortTraces(traces []RequestTrace) []RequestTrace {
+	sort.Slice(traces, func(i, j int) bool { return traces[i].Start.After(traces[j].Start) })
+	return traces
+}
+
+// 正在处理的请求，最新的在前。没有开启WithRequestTrace时为空
+func (s *Server) ActiveRequests() []RequestTrace {
+	l := s.requests
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	traces := make([]RequestTrace, 0, len(l.active))
+	for _, t := range l.active {
+		cp := *t
+		cp.Elapsed = time.Since(t.Start)
+		traces = append(traces, cp)
+	}
+	return sortTraces(traces)
+}
+
+// 方法最近完成的请求，errorsOnly时只返回出错的，最新的在前。
+// method为空时返回所有方法的
+func (s *Server) RecentRequests(method string, errorsOnly bool) []RequestTrace {
+	l := s.requests
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var traces []RequestTrace
+	for name, ml := range l.methods {
+		if method != "" && name != method {
+			continue
+		}
+		ring := &ml.recent
+		if errorsOnly {
+			ring = &ml.errors
+		}
+		traces = append(traces, ring.traces...)
+	}
+	return sortTraces(traces)
+}
@@ -0,0 +1,57 @@
+package mrpc
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRequestTrace(t *testing.T) {
+	s := NewServer(WithRequestTrace(2))
+	release := make(chan struct{})
+	HandleFunc(s, "Job.Run", func(_ context.Context, n int, _ *int) error {
+		if n < 0 {
+			return errors.New("negative")
+		}
+		if n == 0 {
+			<-release
+		}
+		return nil
+	})
+	client, err := ConnectInProcess(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	blocked := client.Go("Job.Run", 0, new(int), nil)
+	client.Call("Job.Run", -1, new(int))
+	for i := 1; i <= 3; i++ {
+		client.Call("Job.Run", i, new(int))
+	}
+
+	active := s.ActiveRequests()
+	if len(active) != 1 || !active[0].Active || active[0].Seq != blocked.Seq {
+		t.Fatalf("unexpected active requests %+v", active)
+	}
+	recent := s.RecentRequests("Job.Run", false)
+	assert(t, len(recent) == 2 && recent[0].Seq > recent[1].Seq, "want 2 newest requests first, got %+v", recent)
+	errs := s.RecentRequests("", true)
+	assert(t, len(errs) == 1 && errs[0].Error == "negative", "error should be kept, got %+v", errs)
+
+	rec := httptest.NewRecorder()
+	s.DebugHandler().ServeHTTP(rec, httptest.NewRequest("GET", DefaultDebugPath, nil))
+	assert(t, strings.Contains(rec.Body.String(), "<h2>Active requests</h2>") && strings.Contains(rec.Body.String(), "negative"),
+		"debug page missing requests:\n%s", rec.Body.String())
+
+	close(release)
+	<-blocked.Done
+	for i := 0; i < 100 && len(s.ActiveRequests()) > 0; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	assert(t, len(s.ActiveRequests()) == 0, "finished request still active")
+	assert(t, NewServer().ActiveRequests() == nil, "tracing is off by default")
+}
@@ -0,0 +1,115 @@
+package mrpc
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/micplus/mrpc/codec"
+)
+
+// 反向调用：客户端也可以注册服务，服务端在同一条连接上调用它们，
+// 服务端要主动访问NAT后面的边缘节点(agent)时，由agent建立连接即可。
+// 反向的请求和对它的响应都带codec.FlagReverse，两个方向的序号互不相干
+//
+//	// agent
+//	agent := mrpc.NewServer()
+//	agent.Register(new(Agent))
+//	client, err := mrpc.DialOptions("tcp", addr, mrpc.WithReverseServer(agent))
+//
+//	// 服务端开启WithReverseRPC，在方法中保存连接对端的客户端，之后随时调用
+//	func (h *Hub) Hello(ctx context.Context, id string, _ *struct{}) error {
+//		h.agents.Store(id, mrpc.ReverseClient(ctx))
+//		return nil
+//	}
+
+type reverseKey struct{}
+
+// readRequest读到的是反向调用的响应，消息体还没有读
+var errReverseFrame = errors.New("rpc server: reverse frame")
+
+// 调用连接对端服务的客户端，只在方法的ctx中有：服务端开启了WithReverseRPC，
+// 或者方法本身是客户端用WithReverseServer提供的。没有时返回nil。
+// 连接断开后它的调用都返回ErrShutDown，Close不会关闭连接
+func ReverseClient(ctx context.Context) *Client {
+	c, _ := ctx.Value(reverseKey{}).(*Client)
+	return c
+}
+
+// 给写出的消息加上标志位，写入时持有mu，与连接上的其它写入互斥。
+// 不拥有连接，Close什么也不做
+type flagCodec struct {
+	codec.Codec
+	mu    *sync.Mutex
+	flags uint32
+}
+
+func (c *flagCodec) Write(h *codec.Header, body any) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	h.Flags |= c.flags
+	return c.Codec.Write(h, body)
+}
+
+func (c *flagCodec) Close() error { return nil }
+
+// 服务端一条连接上的反向客户端：请求经w写出，响应由serveCodec读到后交给handleResponse，
+// 没有自己的receive协程
+func newReverseClient(w *responseWriter, conn net.Conn, cn *countingConn) *Client {
+	c := &Client{
+		cc:     &flagCodec{Codec: w.cc, mu: &w.mu, flags: codec.FlagReverse},
+		window: newSendWindow(),
+		conn:   conn,
+		cn:     cn,
+		clock:  w.clock,
+	}
+	for i := range c.pending {
+		c.pending[i].calls = make(map[uint64]*Call)
+	}
+	c.seq.Store(newSeqBase())
+	return c
+}
+
+// 没有注册反向服务的客户端也要回复，免得服务端一直等
+var noReverseServer = NewServer()
+
+// 在receive中处理服务端发起的请求，与服务端处理请求相同，只是不做流量控制。
+// 读消息体出错时与服务端一样回复错误，连接真的断了下一次读头部会发现。
+// read是读头部之前连接上已读的字节数
+func (c *Client) serveReverse(h *codec.Header, read int64) {
+	if c.reverseW == nil {
+		s := c.reverse
+		if s == nil {
+			s = noReverseServer
+		}
+		c.reverseW = s.newResponseWriter(&flagCodec{Codec: c.cc, mu: &c.sending, flags: codec.FlagReverse})
+		c.reverseW.cn = c.cn
+		if c.conn != nil {
+			c.reverseW.remote = c.conn.RemoteAddr()
+		}
+		c.reverseW.peer = c
+		c.reverseW.frames = true
+		c.reverse = s
+	}
+	w := c.reverseW
+	req := getRequest()
+	*req.h = *h
+	err := c.reverse.readRequestBody(c.cc, req, nil)
+	req.inLen = int(inBytes(c.cn) - read)
+	if w.stats != nil {
+		req.begin = time.Now()
+	}
+	if err != nil {
+		c.reverseWG.Add(1)
+		go func() {
+			defer c.reverseWG.Done()
+			w.writeInvalid(req, err)
+		}()
+		return
+	}
+	req.w, req.wg = w, &c.reverseWG
+	c.reverseWG.Add(1)
+	go req.serve()
+}
@@ -0,0 +1,65 @@
+package mrpc
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestReverseRPC(t *testing.T) {
+	hub := NewServer(WithReverseRPC())
+	peers := make(chan *Client, 2)
+	HandleFunc(hub, "Hub.Hello", func(ctx context.Context, n int, reply *int) error {
+		peer := ReverseClient(ctx)
+		peers <- peer
+		// 在处理请求时回调客户端
+		return peer.Call("Calc.Sum", Pair{n, n}, reply)
+	})
+
+	agent := NewServer()
+	agent.Register(new(Calc))
+	client := pipeClient(t, hub, WithReverseServer(agent))
+	var sum int
+	err := client.Call("Hub.Hello", 21, &sum)
+	assert(t, err == nil && sum == 42, "nested reverse call: sum=%d err=%v", sum, err)
+
+	// 保存下来的客户端可以随时调用，与正向的调用并发
+	peer := <-peers
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			var sum int
+			err := peer.Call("Calc.Sum", Pair{i, 1}, &sum)
+			assert(t, err == nil && sum == i+1, "reverse call %d: sum=%d err=%v", i, sum, err)
+		}(i)
+		go func(i int) {
+			defer wg.Done()
+			var sum int
+			err := client.Call("Hub.Hello", i, &sum)
+			assert(t, err == nil && sum == 2*i, "call %d: sum=%d err=%v", i, sum, err)
+			<-peers
+		}(i)
+	}
+	wg.Wait()
+	err = peer.Call("Calc.Missing", Pair{}, &sum)
+	assert(t, err != nil && strings.Contains(err.Error(), "cannot find method"), "unexpected error %v", err)
+
+	client.Close()
+	for peer.IsAvailable() {
+		peer.Call("Calc.Sum", Pair{}, &sum)
+	}
+	err = peer.Call("Calc.Sum", Pair{}, &sum)
+	assert(t, errors.Is(err, ErrShutDown), "calls after disconnect should fail, got %v", err)
+
+	// 客户端没有注册服务时回复错误
+	plain := pipeClient(t, hub)
+	err = plain.Call("Hub.Hello", 1, &sum)
+	assert(t, err != nil && strings.Contains(err.Error(), "cannot find service Calc"), "unexpected error %v", err)
+
+	// 未开启时ctx中没有
+	assert(t, ReverseClient(context.Background()) == nil, "no reverse client expected")
+}
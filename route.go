@@ -0,0 +1,18 @@
+package mrpc
+
+// 分发前的路由：读到请求头之后、查找方法之前由route改写方法名和元数据，
+// 比如把旧的方法名映射到新名字，或者按租户转到不同的服务，普通的服务端也能像网关一样分流。
+// 返回的名称和元数据代替请求中的，之后的查找、统计、日志都用新名称；返回错误时不调用方法，
+// 错误回给客户端(*Error保留错误码)。route在连接的读协程中调用，应当尽快返回
+//
+//	s := mrpc.NewServer(mrpc.WithRouter(func(name string, md mrpc.Metadata) (string, mrpc.Metadata, error) {
+//		if tenant := md["tenant"]; tenant != "" {
+//			name = tenant + "_" + name
+//		}
+//		return name, md, nil
+//	}))
+func WithRouter(route func(name string, md Metadata) (string, Metadata, error)) ServerOption {
+	return func(s *Server) {
+		s.route = route
+	}
+}
@@ -0,0 +1,53 @@
+package mrpc
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestRouter(t *testing.T) {
+	s := NewServer(WithRouter(func(name string, md Metadata) (string, Metadata, error) {
+		switch {
+		case name == "Legacy.Add": // 旧名称
+			return "Calc.Sum", md, nil
+		case name == "Blocked.Call":
+			return "", nil, Errorf(PermissionDenied, "blocked")
+		case md["tenant"] != "":
+			md = md.Clone()
+			md["routed"] = "yes"
+			return md["tenant"] + "." + name[len("Tenant."):], md, nil
+		}
+		return name, md, nil
+	}))
+	s.Register(new(Calc))
+	for _, tenant := range []string{"A", "B"} {
+		HandleFunc(s, tenant+".Who", func(ctx context.Context, _ int, reply *string) error {
+			*reply = tenant + IncomingMetadata(ctx)["routed"]
+			return nil
+		})
+	}
+	client, err := ConnectInProcess(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	var sum int
+	err = client.Call("Legacy.Add", Pair{1, 2}, &sum)
+	assert(t, err == nil && sum == 3, "legacy name: got %d %v", sum, err)
+
+	for _, tenant := range []string{"A", "B"} {
+		ctx := WithOutgoingMetadata(context.Background(), Metadata{"tenant": tenant})
+		var who string
+		err = client.CallContext(ctx, "Tenant.Who", 0, &who)
+		assert(t, err == nil && who == tenant+"yes", "tenant %s: got %q %v", tenant, who, err)
+	}
+
+	err = client.Call("Blocked.Call", 0, new(int))
+	var e *Error
+	assert(t, errors.As(err, &e) && e.Code == PermissionDenied, "want PermissionDenied, got %v", err)
+	// 出错后连接上的下一个请求照常处理
+	err = client.Call("Calc.Sum", Pair{3, 4}, &sum)
+	assert(t, err == nil && sum == 7, "after error: got %d %v", sum, err)
+}
@@ -0,0 +1,270 @@
+package mrpc
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// 加密会话：不需要证书的连接加密，用于难以维护TLS证书的封闭环境。
+// 客户端以EncryptedMagic代替Magic开始握手，双方交换X25519临时公钥，
+// 由共享密钥和预共享密钥(psk)导出每条连接、每个方向各自的密钥，之后的帧都用AES-GCM加密：
+//
+//	客户端 -> EncryptedMagic | 编码类型(4字节) | 客户端公钥(32字节)
+//	服务端 -> 服务端公钥(32字节) | 服务端确认码(32字节)
+//	客户端 -> 客户端确认码(32字节)
+//
+// 确认码是对握手内容的HMAC，双方的psk不同时握手失败，而不是在第一个请求上才出错。
+// psk为空时只能防窃听，不能防中间人，需要认证对端时使用相同的非空psk或者TLS：
+//
+//	s := mrpc.NewServer(mrpc.WithEncryption(psk))
+//	client, err := mrpc.NewClientOptions(conn, mrpc.WithClientEncryption(psk))
+//
+// 开启加密的服务端拒绝不加密的连接，没有开启的服务端拒绝加密的连接，客户端都得到ErrProtocolMismatch
+
+// 加密会话的握手开头
+const EncryptedMagic uint32 = 0x5a2b71c4
+
+// 握手时确认码不符，通常是双方的psk不同
+var ErrHandshakeFailed = errors.New("rpc: encrypted handshake failed")
+
+// 服务端接受加密会话，拒绝不加密的连接，见EncryptedMagic
+func WithEncryption(psk []byte) ServerOption {
+	return func(s *Server) {
+		s.encryption = &sessionConfig{psk: psk}
+	}
+}
+
+// 客户端使用加密会话，psk须与服务端相同，见EncryptedMagic
+func WithClientEncryption(psk []byte) ClientOption {
+	return func(o *clientOptions) {
+		o.encryption = &sessionConfig{psk: psk}
+	}
+}
+
+type sessionConfig struct {
+	psk []byte
+}
+
+const sessionKeySize = 32
+
+// 一条连接的密钥，c2s、s2c分别加密两个方向的帧
+type sessionKeys struct {
+	c2s, s2c                 []byte
+	clientProof, serverProof []byte
+}
+
+// 由ECDH的共享密钥、psk和握手内容导出密钥，形同HKDF-SHA256
+func deriveKeys(shared, psk, hello, clientPub, serverPub []byte) *sessionKeys {
+	salt := psk
+	if len(salt) == 0 {
+		salt = make([]byte, sha256.Size)
+	}
+	mac := hmac.New(sha256.New, salt)
+	mac.Write(shared)
+	prk := mac.Sum(nil)
+	h := sha256.New()
+	h.Write(hello)
+	h.Write(clientPub)
+	h.Write(serverPub)
+	transcript := h.Sum(nil)
+	expand := func(label string) []byte {
+		mac := hmac.New(sha256.New, prk)
+		mac.Write(transcript)
+		mac.Write([]byte("mrpc session " + label))
+		mac.Write([]byte{1})
+		return mac.Sum(nil)[:sessionKeySize]
+	}
+	return &sessionKeys{
+		c2s:         expand("c2s"),
+		s2c:         expand("s2c"),
+		clientProof: expand("client finished"),
+		serverProof: expand("server finished"),
+	}
+}
+
+// 服务端在读到EncryptedMagic和编码类型(hello)之后完成握手，返回加密的连接
+func (cfg *sessionConfig) accept(rwc io.ReadWriteCloser, hello []byte) (io.ReadWriteCloser, error) {
+	clientPub := make([]byte, 32)
+	if _, err := io.ReadFull(rwc, clientPub); err != nil {
+		return nil, err
+	}
+	peer, err := ecdh.X25519().NewPublicKey(clientPub)
+	if err != nil {
+		return nil, err
+	}
+	// 客户端先检查拒绝帧，公钥不能以RejectMagic开头
+	var priv *ecdh.PrivateKey
+	for priv == nil || binary.BigEndian.Uint32(priv.PublicKey().Bytes()) == RejectMagic {
+		if priv, err = ecdh.X25519().GenerateKey(rand.Reader); err != nil {
+			return nil, err
+		}
+	}
+	shared, err := priv.ECDH(peer)
+	if err != nil {
+		return nil, err
+	}
+	serverPub := priv.PublicKey().Bytes()
+	keys := deriveKeys(shared, cfg.psk, hello, clientPub, serverPub)
+	if _, err := rwc.Write(append(serverPub, keys.serverProof...)); err != nil {
+		return nil, err
+	}
+	proof := make([]byte, sessionKeySize)
+	if _, err := io.ReadFull(rwc, proof); err != nil {
+		return nil, err
+	}
+	if !hmac.Equal(proof, keys.clientProof) {
+		return nil, ErrHandshakeFailed
+	}
+	return newSecureConn(rwc, keys.s2c, keys.c2s)
+}
+
+// 客户端写完hello之后完成握手。服务端拒绝时返回拒绝的原因
+func (cfg *sessionConfig) dial(rwc io.ReadWriteCloser, hello []byte) (io.ReadWriteCloser, error) {
+	priv, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	clientPub := priv.PublicKey().Bytes()
+	if _, err := rwc.Write(append(hello, clientPub...)); err != nil {
+		return nil, err
+	}
+	br := bufio.NewReader(rwc)
+	if err := readRejection(br); err != nil {
+		return nil, err
+	}
+	reply := make([]byte, 32+sessionKeySize)
+	if _, err := io.ReadFull(br, reply); err != nil {
+		return nil, err
+	}
+	peer, err := ecdh.X25519().NewPublicKey(reply[:32])
+	if err != nil {
+		return nil, err
+	}
+	shared, err := priv.ECDH(peer)
+	if err != nil {
+		return nil, err
+	}
+	keys := deriveKeys(shared, cfg.psk, hello, clientPub, reply[:32])
+	if !hmac.Equal(reply[32:], keys.serverProof) {
+		return nil, ErrHandshakeFailed
+	}
+	if _, err := rwc.Write(keys.clientProof); err != nil {
+		return nil, err
+	}
+	return newSecureConn(&bufferedConn{Reader: br, WriteCloser: rwc}, keys.c2s, keys.s2c)
+}
+
+// 每条记录的明文上限
+const maxRecordSize = 16 << 10
+
+// 加密的连接，每次Write按maxRecordSize分成若干条记录：
+//
+//	密文长度(4字节) | AES-GCM密文
+//
+// nonce是各方向从0开始的记录序号，不随记录传输
+type secureConn struct {
+	rwc  io.ReadWriteCloser
+	seal cipher.AEAD
+	open cipher.AEAD
+
+	wmu    sync.Mutex // protect wseq, wbuf
+	wseq   uint64
+	wbuf   []byte
+	rseq   uint64
+	rbuf   []byte // 解密后还没有读走的明文
+	record []byte
+}
+
+func newSecureConn(rwc io.ReadWriteCloser, sealKey, openKey []byte) (*secureConn, error) {
+	seal, err := newGCM(sealKey)
+	if err != nil {
+		return nil, err
+	}
+	open, err := newGCM(openKey)
+	if err != nil {
+		return nil, err
+	}
+	return &secureConn{rwc: rwc, seal: seal, open: open}, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func nonce(seq uint64) []byte {
+	n := make([]byte, 12)
+	binary.BigEndian.PutUint64(n[4:], seq)
+	return n
+}
+
+func (c *secureConn) Write(p []byte) (int, error) {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	written := 0
+	for len(p) > 0 {
+		chunk := p[:min(len(p), maxRecordSize)]
+		c.wbuf = binary.BigEndian.AppendUint32(c.wbuf[:0], uint32(len(chunk)+c.seal.Overhead()))
+		c.wbuf = c.seal.Seal(c.wbuf, nonce(c.wseq), chunk, nil)
+		c.wseq++
+		if _, err := c.rwc.Write(c.wbuf); err != nil {
+			return written, err
+		}
+		written += len(chunk)
+		p = p[len(chunk):]
+	}
+	return written, nil
+}
+
+func (c *secureConn) Read(p []byte) (int, error) {
+	if len(c.rbuf) == 0 {
+		if err := c.readRecord(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, c.rbuf)
+	c.rbuf = c.rbuf[n:]
+	return n, nil
+}
+
+func (c *secureConn) readRecord() error {
+	var head [4]byte
+	if _, err := io.ReadFull(c.rwc, head[:]); err != nil {
+		return err
+	}
+	n := int(binary.BigEndian.Uint32(head[:]))
+	if n < c.open.Overhead() || n > maxRecordSize+c.open.Overhead() {
+		return fmt.Errorf("rpc: invalid encrypted record size %d", n)
+	}
+	if cap(c.record) < n {
+		c.record = make([]byte, n)
+	}
+	c.record = c.record[:n]
+	if _, err := io.ReadFull(c.rwc, c.record); err != nil {
+		return io.ErrUnexpectedEOF
+	}
+	plain, err := c.open.Open(c.record[:0], nonce(c.rseq), c.record, nil)
+	if err != nil {
+		return errors.New("rpc: decrypt record: message authentication failed")
+	}
+	c.rseq++
+	c.rbuf = plain
+	return nil
+}
+
+func (c *secureConn) Close() error {
+	return c.rwc.Close()
+}
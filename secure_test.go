@@ -0,0 +1,80 @@
+package mrpc
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/micplus/mrpc/codec"
+)
+
+// 记录写到连接上的字节
+type recordingConn struct {
+	net.Conn
+	written bytes.Buffer
+}
+
+func (c *recordingConn) Write(p []byte) (int, error) {
+	c.written.Write(p)
+	return c.Conn.Write(p)
+}
+
+func TestEncryptedSession(t *testing.T) {
+	psk := []byte("shared secret")
+	s := NewServer(WithEncryption(psk))
+	s.Register(new(Echo))
+	c1, c2 := net.Pipe()
+	rc := &recordingConn{Conn: c1}
+	go s.ServeConn(c2)
+	client, err := NewClientOptions(rc, WithClientEncryption(psk))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	secret := "attack at dawn"
+	var reply string
+	err = client.Call("Echo.Say", secret, &reply)
+	assert(t, err == nil && reply == secret, "reply=%q err=%v", reply, err)
+	big := strings.Repeat("x", 3*maxRecordSize+1) // 分成多条记录
+	err = client.Call("Echo.Say", big, &reply)
+	assert(t, err == nil && reply == big, "big reply: len=%d err=%v", len(reply), err)
+	assert(t, !bytes.Contains(rc.written.Bytes(), []byte(secret)) && !bytes.Contains(rc.written.Bytes(), []byte("Echo.Say")),
+		"plaintext on the wire")
+}
+
+func TestEncryptedSessionMismatch(t *testing.T) {
+	for _, tc := range []struct {
+		name         string
+		server       []ServerOption
+		client       []ClientOption
+		want         error
+		wantContains string
+	}{
+		{"wrong psk", []ServerOption{WithEncryption([]byte("a"))}, []ClientOption{WithClientEncryption([]byte("b"))}, ErrHandshakeFailed, ""},
+		{"plain server", nil, []ClientOption{WithClientEncryption(nil)}, ErrProtocolMismatch, "does not accept encrypted sessions"},
+	} {
+		s := NewServer(tc.server...)
+		s.Register(new(Echo))
+		c1, c2 := net.Pipe()
+		go s.ServeConn(c2)
+		_, err := NewClientOptions(c1, tc.client...)
+		assert(t, errors.Is(err, tc.want) && strings.Contains(err.Error(), tc.wantContains), "%s: got %v", tc.name, err)
+		c1.Close()
+	}
+}
+
+func TestEncryptionRequired(t *testing.T) {
+	s := NewServer(WithEncryption(nil))
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	go s.ServeConn(c2)
+	buf := binary.BigEndian.AppendUint32(nil, Magic)
+	c1.Write(binary.BigEndian.AppendUint32(buf, codec.GobType))
+	err := readRejection(bufio.NewReader(c1))
+	assert(t, errors.Is(err, ErrProtocolMismatch) && strings.Contains(err.Error(), "requires an encrypted session"), "got %v", err)
+}
@@ -0,0 +1,28 @@
+package mrpc
+
+import "math/rand/v2"
+
+// 请求序号：高16位是纪元(epoch)，低48位是连接内的计数。
+// 经过Magic握手的连接创建时随机选一个纪元作为连接ID，旧连接上迟到的响应即使被转到新连接，
+// 序号也对不上，只会被当作无主的响应丢弃。计数用完时进位到下一个纪元，
+// 64位整体回绕之前同一个序号不会出现两次。计数为0的序号跳过，Seq为0留给控制帧。
+//
+// NewClientWithCodec创建的客户端纪元从0开始，jsonrpc等协议的id保持为较小的整数
+const (
+	seqCounterBits = 48
+	seqCounterMask = 1<<seqCounterBits - 1
+)
+
+// 随机纪元的起点，第一个序号是它加1
+func newSeqBase() uint64 {
+	return rand.Uint64() &^ seqCounterMask
+}
+
+// 分配下一个序号
+func (c *Client) nextSeq() uint64 {
+	for {
+		if seq := c.seq.Add(1); seq&seqCounterMask != 0 {
+			return seq
+		}
+	}
+}
@@ -0,0 +1,30 @@
+package mrpc
+
+import (
+	"math"
+	"testing"
+)
+
+func TestSeqOverflow(t *testing.T) {
+	client, _, err := NewClientServerPair(new(Calc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	base := client.seq.Load() &^ seqCounterMask
+	client.seq.Store(base | (seqCounterMask - 1))
+
+	// 计数用完时进位到下一个纪元，跳过计数为0的序号
+	want := []uint64{base | seqCounterMask, base + 1<<seqCounterBits | 1}
+	for _, seq := range want {
+		var sum int
+		call := <-client.Go("Calc.Sum", Pair{1, 2}, &sum, nil).Done
+		assert(t, call.Error == nil && sum == 3, "call error: %v", call.Error)
+		assert(t, call.Seq == seq, "want seq %#x, got %#x", seq, call.Seq)
+	}
+
+	// 64位回绕时跳过0，0留给控制帧
+	c := &Client{}
+	c.seq.Store(math.MaxUint64)
+	assert(t, c.nextSeq() == 1, "seq should wrap to 1")
+}
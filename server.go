@@ -1,14 +1,22 @@
 package mrpc
 
 import (
+	"context"
+	"crypto/tls"
 	"encoding/binary"
 	"errors"
+	"fmt"
+	"go/token"
 	"io"
 	"log"
 	"net"
+	"os"
 	"reflect"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/micplus/mrpc/codec"
 )
@@ -24,28 +32,187 @@ const Magic uint32 = 0x5a2b71c3
 
 type Server struct {
 	serviceMap map[string]*service
+
+	// 连接读端的缓冲大小
+	readBufferSize int
+	// 运行中可以修改的设置，见UpdateSettings
+	settings atomic.Pointer[ServerSettings]
+	// 加密会话，见WithEncryption
+	encryption *sessionConfig
+	// 明文连接升级到TLS，见WithStartTLS
+	startTLS *tls.Config
+	// 接受的TCP连接的套接字参数，见WithNoDelay、WithSocketBuffers
+	socket socketOptions
+	// 统计钩子，见WithStatsHandler
+	stats StatsHandler
+	// 帧转储，见SetTrace
+	trace atomic.Pointer[tracer]
+	// ServeConn正在处理的连接，*countingConn -> net.Conn
+	conns sync.Map
+	// 请求追踪，见WithRequestTrace
+	requests *requestLog
+	// 省GC模式，见WithReducedGC
+	reducedGC bool
+	// 响应合并写入的上限，见WithWriteCoalescing
+	coalesceBytes int
+	coalesceDelay time.Duration
+	// 方法可以通过ReverseClient调用对端注册的服务，见WithReverseRPC
+	reverse bool
+	// 查找没有注册的方法，见WithDynamicMethods。dynamic缓存找到的方法，name -> *dynamicMethod
+	findDynamic func(name string) (*DynamicMethod, error)
+	dynamic     sync.Map
+	// 服务名 -> 各个版本，见RegisterVersion
+	versions map[string]*serviceVersions
+	// 旧方法名 -> 别名，见Alias
+	aliases map[string]*methodAlias
+	// 其余方法的兜底处理，见WithFallbackHandler
+	fallback *dynamicMethod
+	// 查找方法前改写方法名和元数据，见WithRouter
+	route func(name string, md Metadata) (string, Metadata, error)
+	// 调用方法前的鉴权，见WithAuthorizer
+	authorize func(ctx context.Context, method string) error
+	// 把方法返回的错误转换成带错误码的错误，见WithErrorTranslator
+	translate func(ctx context.Context, method string, err error) error
+	// 故障注入，见WithFaults
+	faults faults
+	// 流量镜像，见WithMirror
+	mirrors mirrors
+	// 从元数据还原到方法ctx中的值，见WithBaggage
+	baggage baggages
+	// 卡住的方法检测，见WithWatchdog
+	watchdog *watchdog
+	// 所有连接都按序响应，见WithOrderedResponses
+	ordered bool
+	// 请求超时等计时用的时钟，见WithClock
+	clock Clock
+	// 空闲超时和告知客户端的心跳间隔，见WithKeepalive
+	keepalive keepaliveParams
+	// 给出每条连接的限速器，见WithBandwidthLimit
+	bandwidth func(conn net.Conn, id *Identity) (read, write *RateLimiter)
+	// 带幂等键的调用结果，见WithIdempotency
+	dedup *dedupStore
+	// 异步任务，第一次HandleJob时创建，见WithJobRetention
+	jobs         *jobTable
+	jobRetention time.Duration
+	// 优雅关闭，见Shutdown。listeners是Accept中的listener，
+	// goaways是经过握手、能识别控制帧的连接，*responseWriter -> struct{}
+	inShutdown atomic.Bool
+	// 维护模式，见SetServing
+	notServing atomic.Bool
+	listeners  sync.Map
+	goaways    sync.Map
 }
 
-func NewServer() *Server {
-	return &Server{
-		serviceMap: make(map[string]*service),
+func NewServer(opts ...ServerOption) *Server {
+	s := &Server{
+		serviceMap:     make(map[string]*service),
+		readBufferSize: DefaultReadBufferSize,
+		socket:         defaultSocketOptions(),
+		coalesceBytes:  DefaultCoalesceBytes,
+		coalesceDelay:  DefaultCoalesceDelay,
+		clock:          SystemClock,
+	}
+	s.settings.Store(&ServerSettings{
+		HandshakeTimeout: DefaultHandshakeTimeout,
+		WriteTimeout:     DefaultWriteTimeout,
+	})
+	for _, opt := range opts {
+		opt(s)
 	}
+	if s.watchdog != nil {
+		s.watchdog.clock = s.clock
+	}
+	return s
 }
 
 var DefaultServer = NewServer()
 
 // 把某个类型(指针)的服务注册给server
-func (s *Server) Register(rcvr any) error {
+func (s *Server) Register(rcvr any, opts ...ServiceOption) error {
 	svc := newService(rcvr)
 	if _, dup := s.serviceMap[svc.name]; dup {
 		return errors.New("rcp server: duplicated service " + svc.name)
 	}
+	for _, opt := range opts {
+		opt(svc)
+	}
 	s.serviceMap[svc.name] = svc
 	return nil
 }
 
-func Register(rcvr any) error {
-	return DefaultServer.Register(rcvr)
+func Register(rcvr any, opts ...ServiceOption) error {
+	return DefaultServer.Register(rcvr, opts...)
+}
+
+// 以指定的服务名注册，客户端使用"name.Method"调用。
+// 名称必须是导出的标识符，接收者的类型可以不导出
+func (s *Server) RegisterName(name string, rcvr any, opts ...ServiceOption) error {
+	if !token.IsIdentifier(name) || !token.IsExported(name) {
+		return fmt.Errorf("rpc server: %q is not a valid service name", name)
+	}
+	svc := newNamedService(rcvr, name)
+	if _, dup := s.serviceMap[svc.name]; dup {
+		return errors.New("rpc server: duplicated service " + svc.name)
+	}
+	for _, opt := range opts {
+		opt(svc)
+	}
+	s.serviceMap[svc.name] = svc
+	return nil
+}
+
+func RegisterName(name string, rcvr any, opts ...ServiceOption) error {
+	return DefaultServer.RegisterName(name, rcvr, opts...)
+}
+
+// 按接口I注册服务，服务名是接口名，只注册接口中声明的方法。
+// 接口中有不符合rpc规定的方法时注册失败，返回每个方法的原因，而不是像Register一样跳过
+//
+//	type Arith interface {
+//		Add(args *Args, reply *int) error
+//	}
+//	err := mrpc.RegisterChecked[Arith](s, new(arith))
+func RegisterChecked[I any](s *Server, rcvr I, opts ...ServiceOption) error {
+	it := reflect.TypeFor[I]()
+	if it.Kind() != reflect.Interface {
+		return fmt.Errorf("rpc server: %s is not an interface", it)
+	}
+	name := it.Name()
+	if !token.IsExported(name) {
+		return fmt.Errorf("rpc server: %q is not a valid service name", name)
+	}
+	v := reflect.ValueOf(rcvr)
+	if !v.IsValid() {
+		return errors.New("rpc server: nil receiver for service " + name)
+	}
+	if it.NumMethod() == 0 {
+		return errors.New("rpc server: " + name + " declares no methods")
+	}
+	var errs []error
+	for i := range it.NumMethod() {
+		im := it.Method(i)
+		if !im.IsExported() {
+			errs = append(errs, fmt.Errorf("rpc server: %s.%s is not exported", name, im.Name))
+			continue
+		}
+		m, _ := v.Type().MethodByName(im.Name)
+		if err := checkMethod(m.Type); err != nil {
+			errs = append(errs, fmt.Errorf("rpc server: %s.%s %w", name, im.Name, err))
+		}
+	}
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+	if _, dup := s.serviceMap[name]; dup {
+		return errors.New("rpc server: duplicated service " + name)
+	}
+	svc := &service{name: name, typ: v.Type(), rcvr: v}
+	svc.registerMethods(it)
+	for _, opt := range opts {
+		opt(svc)
+	}
+	s.serviceMap[name] = svc
+	return nil
 }
 
 // name="Service.Method"
@@ -57,14 +224,20 @@ func (s *Server) findService(name string) (svc *service, mt *methodType, err err
 		return
 	}
 	sName, mName := name[:dot], name[dot+1:]
-	// 寻找service
+	// 寻找service，名称中可能带有版本
 	var ok bool
-	if svc, ok = s.serviceMap[sName]; !ok {
+	if svc, sName, mName = s.lookupService(sName, mName); svc == nil {
+		if s.findDynamic != nil || s.fallback != nil {
+			return s.dynamicMethod(name)
+		}
 		err = errors.New("rpc server: cannot find service " + sName)
 		return
 	}
 	// 寻找method
 	if mt, ok = svc.method[mName]; !ok {
+		if s.findDynamic != nil || s.fallback != nil {
+			return s.dynamicMethod(name)
+		}
 		err = errors.New("rpc server: cannot find method " + mName + " on service " + sName)
 		return
 	}
@@ -73,9 +246,18 @@ func (s *Server) findService(name string) (svc *service, mt *methodType, err err
 
 // 接管listener的Accept方法，循环等待连接，开启goroutine作处理
 func (s *Server) Accept(lis net.Listener) {
+	s.listeners.Store(lis, struct{}{})
+	defer s.listeners.Delete(lis)
+	if s.inShutdown.Load() { // Shutdown之后才开始Accept
+		lis.Close()
+		return
+	}
 	for {
 		conn, err := lis.Accept()
 		if err != nil {
+			if errors.Is(err, net.ErrClosed) || s.inShutdown.Load() { // listener已关闭，不会再有新连接
+				return
+			}
 			log.Println("rpc server: listener accept error:", err)
 			continue
 		}
@@ -91,17 +273,46 @@ func Accept(lis net.Listener) {
 
 // 处理建立的连接，检查是不是rpc请求、编码是否支持，包装连接给相应的codec处理
 func (s *Server) ServeConn(conn net.Conn) {
+	s.serveConn(conn, nil)
+}
+
+// ready不为nil时无论是否开启WithReverseRPC都创建反向客户端，握手成功后交给它
+func (s *Server) serveConn(conn net.Conn, ready func(*Client)) {
 	defer func() {
 		conn.Close()
 	}()
+	if err := s.socket.apply(conn); err != nil {
+		log.Println("rpc server: set socket options error:", err)
+		return
+	}
+	// 握手和之后的请求都从带缓冲的读端读取，限速在握手之后才知道
+	var rwc io.ReadWriteCloser = conn
+	var throttled *throttledConn
+	if s.bandwidth != nil {
+		throttled = &throttledConn{ReadWriteCloser: conn}
+		rwc = throttled
+	}
+	rwc = newBufferedConn(rwc, s.readBufferSize)
+	// 一直不完成握手的连接到期后关闭，不让它们占着协程和文件描述符。
+	// TLS的握手在第一次读时进行，同样受这个期限约束
+	settings := s.settings.Load()
+	if settings.HandshakeTimeout > 0 {
+		conn.SetReadDeadline(time.Now().Add(settings.HandshakeTimeout))
+	}
 	buf := make([]byte, 8)
-	if _, err := io.ReadFull(conn, buf); err != nil {
+	if _, err := io.ReadFull(rwc, buf); err != nil {
 		log.Println("rpc server: read conn error:", err)
 		return
 	}
 	// 检查是否以Magic开头，即是不是rpc请求
-	if num := binary.BigEndian.Uint32(buf[:4]); num != Magic {
+	num := binary.BigEndian.Uint32(buf[:4])
+	if num == StartTLSMagic {
+		s.serveStartTLS(conn, rwc, binary.BigEndian.Uint32(buf[4:]), ready)
+		return
+	}
+	if num != Magic && num != EncryptedMagic {
 		log.Printf("rpc server: invalid magic number: %x", num)
+		writeRejection(conn, rejectBadMagic)
 		return
 	}
 	// 检查编码类型
@@ -109,43 +320,235 @@ func (s *Server) ServeConn(conn net.Conn) {
 	ncf := codec.NewCodecFuncMap[codecType]
 	if ncf == nil {
 		log.Printf("rpc server: invalid codec type: %v", codecType)
+		writeRejection(conn, rejectUnsupportedCodec)
+		return
+	}
+	switch {
+	case num == Magic && s.encryption != nil:
+		log.Println("rpc server: rejected unencrypted connection from", conn.RemoteAddr())
+		writeRejection(conn, rejectEncryptionRequired)
 		return
+	case num == EncryptedMagic && s.encryption == nil:
+		log.Println("rpc server: encrypted sessions are not enabled")
+		writeRejection(conn, rejectEncryptionUnsupported)
+		return
+	case num == EncryptedMagic:
+		var err error
+		if rwc, err = s.encryption.accept(rwc, buf); err != nil {
+			log.Println("rpc server: encrypted handshake error:", err)
+			return
+		}
+	}
+	if settings.HandshakeTimeout > 0 {
+		conn.SetReadDeadline(time.Time{})
+	}
+	if throttled != nil {
+		throttled.read, throttled.write = s.bandwidth(conn, connIdentity(conn))
+	}
+	cn := newCountingConn(rwc)
+	serverConns.Add(1)
+	defer serverConns.Add(-1)
+	s.conns.Store(cn, conn)
+	defer s.conns.Delete(cn)
+	if s.stats != nil {
+		s.stats.HandleConn(&ConnBegin{LocalAddr: conn.LocalAddr(), RemoteAddr: conn.RemoteAddr()})
+	}
+	s.serveCodec(setCompression(ncf(cn), settings.Compression), conn, cn, ready)
+	if s.stats != nil {
+		s.stats.HandleConn(newConnInfo(conn, cn).end(false))
 	}
-	s.serveCodec(ncf(conn))
+}
+
+// 当前所有连接的流量，按建立时间排序。ServeCodec处理的codec不经过net.Conn，不在其中
+func (s *Server) ConnStats() []ConnInfo {
+	var conns []ConnInfo
+	s.conns.Range(func(cn, conn any) bool {
+		conns = append(conns, newConnInfo(conn.(net.Conn), cn.(*countingConn)))
+		return true
+	})
+	sort.Slice(conns, func(i, j int) bool { return conns[i].Since.Before(conns[j].Since) })
+	return conns
 }
 
 var invalidRequest = struct{}{}
 
-// 编解码
-func (s *Server) serveCodec(cc codec.Codec) {
+// 在codec上循环读请求、处理、写响应，直到读出错。
+// 不经过Magic握手，自行实现协议的codec(如jsonrpc)可以直接交给它
+func (s *Server) ServeCodec(cc codec.Codec) {
+	s.serveCodec(cc, nil, nil, nil)
+}
+
+// conn不为nil时codec经过了Magic握手，能识别控制帧，发送流量控制的窗口。
+// cn统计连接上读写的字节数，为nil时不知道每条消息的大小。ready见serveConn
+func (s *Server) serveCodec(cc codec.Codec, conn net.Conn, cn *countingConn, ready func(*Client)) {
 	defer cc.Close()
 	// 由于一次连接允许发送多个请求，处理请求是并发的。对于并发的请求，处理后要把响应数据写到连接。
 	// 既然要并发地写数据，而bufio本身没有线程(协程)安全的处理，
 	// 无论哪个请求处理协程要写数据，都应该给codec上的字节流（连接）加锁，
 	// 防止不同协程的响应数据交织在一起。
-	// A Mutex must not be copied after first use.
-	mu := new(sync.Mutex)
+	w := s.newResponseWriter(cc)
+	w.cn = cn
+	if conn != nil {
+		w.conn = conn
+		w.remote = conn.RemoteAddr()
+		w.identity = connIdentity(conn)
+		w.frames = true
+	}
+	var window *recvWindow
+	if conn != nil {
+		window = newRecvWindow(s.settings.Load().FlowWindow, w)
+	} else {
+		window = newRecvWindow(s.settings.Load().FlowWindow, nil)
+	}
+	window.announce()
+	w.announceKeepalive(s.keepalive)
+	if s.keepalive.IdleTimeout > 0 {
+		w.idle = newIdleTracker(s.clock)
+		done := make(chan struct{})
+		defer close(done)
+		go w.idle.watch(s.keepalive.IdleTimeout, cc, done)
+	}
+	if conn != nil {
+		s.goaways.Store(w, struct{}{})
+		defer s.goaways.Delete(w)
+		if s.inShutdown.Load() || s.notServing.Load() { // 握手时已经开始关闭或处于维护模式
+			w.goaway()
+		}
+	}
+	var a *arena
+	if s.reducedGC {
+		a = newArena()
+	}
+	var names serverNames
+	var peer *Client
+	if (s.reverse || ready != nil) && conn != nil {
+		peer = newReverseClient(w, conn, cn)
+		w.peer = peer
+		if ready != nil {
+			ready(peer)
+		}
+	}
 	// 所有请求都应该被处理，先者要等后者
 	// A WaitGroup must not be copied after first use.
 	wg := new(sync.WaitGroup)
+	var order *responseOrder
+	if s.ordered {
+		order = new(responseOrder)
+	}
+	closeSend := false // 客户端半关闭了，只会再收到反向调用的响应
 	for {
-		req, err := s.readRequest(cc)
+		window.take()
+		read := inBytes(cn)
+		req, err := s.readRequest(cc, a, &names)
+		w.idle.read()
+		if err == errPingFrame { // 心跳只用来刷新空闲时间
+			window.untake()
+			cn.countRead()
+			err = cc.ReadBody(nil)
+			putRequest(req)
+			if err != nil {
+				break
+			}
+			continue
+		}
+		if err == errOrderedFrame { // 之后读到的请求按序响应
+			window.untake()
+			cn.countRead()
+			err = cc.ReadBody(nil)
+			putRequest(req)
+			if err != nil {
+				break
+			}
+			if order == nil {
+				order = new(responseOrder)
+			}
+			continue
+		}
+		if err == errReverseFrame { // 反向调用的响应，不占流量控制的窗口
+			window.untake()
+			cn.countRead()
+			if peer != nil {
+				err = peer.handleResponse(req.h, read)
+			} else {
+				err = cc.ReadBody(nil)
+			}
+			putRequest(req)
+			if err != nil {
+				break
+			}
+			continue
+		}
+		if err == errCloseSend || closeSend && req != nil {
+			window.untake()
+			cn.countRead()
+			if err == errCloseSend {
+				err = cc.ReadBody(nil)
+			}
+			putRequest(req)
+			// 没有反向调用时不会再读到什么，直接等在途请求写完；
+			// 否则继续读反向调用的响应，请求都处理完后再关闭连接。半关闭后又发来请求也直接断开
+			if err != nil || peer == nil || closeSend {
+				break
+			}
+			closeSend = true
+			go func() {
+				wg.Wait()
+				conn.Close()
+			}()
+			continue
+		}
+		if req != nil {
+			cn.countRead()
+			req.inLen = int(inBytes(cn) - read)
+			if s.stats != nil {
+				req.begin = time.Now()
+			}
+			if t := s.trace.Load(); t.enabled(w.remote) {
+				t.frame(w.remote, "recv", req.h, req.inLen, req.body())
+			}
+		}
 		if err != nil {
 			if req == nil { // EOF也是error
 				break
 			}
 			// 写回错误信息
-			req.h.Error = err.Error()
-			go s.writeResponse(cc, req.h, invalidRequest, mu)
+			order.enqueue(req)
+			wg.Add(1)
+			w.idle.begin()
+			go func() {
+				defer wg.Done()
+				defer w.idle.end()
+				w.writeInvalid(req, err)
+				window.release()
+			}()
 			continue
 		}
+		req.w, req.window, req.wg = w, window, wg
+		order.enqueue(req)
 		wg.Add(1)
-		go s.handleRequest(cc, req, mu, wg)
+		w.idle.begin()
+		go req.serve()
+	}
+	// 连接已断开，等待反向调用的方法不会再收到响应
+	if peer != nil {
+		peer.failPending(ErrShutDown)
 	}
 	wg.Wait()
 
 }
 
+// 回复无法处理的请求，err是*Error时带上错误码
+func (w *responseWriter) writeInvalid(req *request, err error) {
+	w.statsBegin(context.Background(), req)
+	w.requests.end(w.requests.begin(req.h.Name, req.h.Seq, w.remoteString()), err)
+	req.waitTurn()
+	n := w.write(req.h, errorBody(req.h, err))
+	req.endTurn()
+	countRequest(req, n, err)
+	w.statsEnd(context.Background(), req, n, err)
+	putRequest(req)
+}
+
 // 整合Header、Body，记录了一次调用所用的完整信息
 type request struct {
 	h *codec.Header
@@ -154,35 +557,133 @@ type request struct {
 	svc          *service
 	mType        *methodType
 	argv, replyv reflect.Value
+	alias        *methodAlias // 用别名调用时不为nil
+	interned     bool         // 请求只带了方法名的编号，响应也不带方法名
+
+	// 所在的连接
+	w      *responseWriter
+	window *recvWindow
+	wg     *sync.WaitGroup
+	arena  *arena // 不为nil时处理完放回连接的空闲列表
+
+	// 请求的大小，设置了StatsHandler时还记录读到请求的时间
+	begin time.Time
+	inLen int
+
+	// 按序响应时，prev关闭后才能写响应，写完关闭done，见responseOrder
+	prev, done chan struct{}
+
+	// 绑定了这个请求的handleRequest，随请求复用，go req.serve()不必每次分配闭包
+	serve func()
+}
+
+// 解码出的参数，没有时为nil
+func (req *request) body() any {
+	if !req.argv.IsValid() {
+		return nil
+	}
+	return req.argv.Interface()
+}
+
+func newRequest() *request {
+	req := &request{h: new(codec.Header)}
+	req.serve = func() { handleRequest(req) }
+	return req
+}
+
+// 高频调用时request和Header的分配很可观，写完响应后放回池中复用
+var requestPool sync.Pool
+
+func init() {
+	// newRequest间接引用了requestPool，不能在声明时初始化
+	requestPool.New = func() any { return newRequest() }
+}
+
+func getRequest() *request {
+	return requestPool.Get().(*request)
+}
+
+// 放回前清空，gob解码不会覆盖流中没有的零值字段
+func putRequest(req *request) {
+	if req.arena != nil {
+		req.arena.put(req)
+		return
+	}
+	if req.mType != nil {
+		req.mType.putArgv(req.argv)
+		req.mType.putReplyv(req.replyv)
+	}
+	h := req.h
+	*h = codec.Header{}
+	*req = request{h: h, serve: req.serve}
+	requestPool.Put(req)
 }
 
 // 读请求头，读到EOF或其它错误就返回
-func (s *Server) readRequestHeader(cc codec.Codec) (*codec.Header, error) {
-	var h codec.Header
-	if err := cc.ReadHeader(&h); err != nil {
+func (s *Server) readRequestHeader(cc codec.Codec, h *codec.Header) error {
+	if err := cc.ReadHeader(h); err != nil {
 		if err != io.EOF && err != io.ErrUnexpectedEOF {
 			log.Println("rpc server: read request header error:", err)
 		}
-		return nil, err
+		return err
 	}
-	return &h, nil
+	return nil
 }
 
-// 读请求头部，读请求体
-func (s *Server) readRequest(cc codec.Codec) (*request, error) {
-	h, err := s.readRequestHeader(cc)
-	if err != nil {
+// 读请求头部，读请求体。a不为nil时从连接的空闲列表取请求和参数，names是连接上方法名的编号
+func (s *Server) readRequest(cc codec.Codec, a *arena, names *serverNames) (*request, error) {
+	req := a.getRequest()
+	if err := s.readRequestHeader(cc, req.h); err != nil {
+		putRequest(req)
 		return nil, err
 	}
+	if req.h.Flags&codec.FlagReverse != 0 {
+		return req, errReverseFrame
+	}
+	if req.h.Seq == 0 {
+		switch req.h.Name {
+		case closeSendFrame:
+			return req, errCloseSend
+		case pingFrame:
+			return req, errPingFrame
+		case orderedFrame:
+			return req, errOrderedFrame
+		}
+	}
+	if req.h.NameID != 0 {
+		var err error
+		if req.interned, err = names.resolve(req.h); err != nil {
+			cc.ReadBody(nil)
+			return req, err
+		}
+	}
+	return req, s.readRequestBody(cc, req, a)
+}
 
-	req := &request{h: h}
-	req.svc, req.mType, err = s.findService(h.Name)
+// 按请求头找到方法，读出参数
+func (s *Server) readRequestBody(cc codec.Codec, req *request, a *arena) error {
+	if s.route != nil {
+		name, md, err := s.route(req.h.Name, req.h.Meta)
+		if err != nil {
+			cc.ReadBody(nil)
+			return err
+		}
+		req.h.Name, req.h.Meta = name, md
+	}
+	var err error
+	if a := s.aliases[req.h.Name]; a != nil {
+		req.svc, req.mType, req.alias = a.svc, a.mt, a
+		req.h.Name = a.target
+	} else {
+		req.svc, req.mType, err = s.findService(req.h.Name)
+	}
 	if err != nil {
-		return nil, err
+		// 找不到服务也要读掉请求体，连接上的下一个请求才能正确解析
+		cc.ReadBody(nil)
+		return err
 	}
 	// 动态地创建方法所绑定的参数类型
-	req.argv = req.mType.newArgv()
-	req.replyv = req.mType.newReplyv()
+	req.argv, req.replyv = a.getValues(req.mType)
 
 	// 交由codec读数据，绑定到argv
 	iargv := req.argv.Interface()
@@ -192,26 +693,316 @@ func (s *Server) readRequest(cc codec.Codec) (*request, error) {
 	}
 	if err := cc.ReadBody(iargv); err != nil {
 		log.Println("rpc server: read request body error:", err)
+		return errors.New("rpc server: read request body error: " + err.Error())
+	}
+	return s.checkServing(req)
+}
+
+// 一条连接上的响应写入，加锁串行。codec支持BatchWriter时，
+// 排队等锁的响应先写进缓冲，由队尾的那个一起刷新
+type responseWriter struct {
+	cc        codec.Codec
+	stats     StatsHandler
+	cn        *countingConn // 不为nil时统计响应的大小
+	conn      net.Conn      // 设置写期限，ServeCodec时为nil
+	remote    net.Addr      // 客户端地址，ServeCodec时为nil
+	trace     *atomic.Pointer[tracer]
+	requests  *requestLog
+	peer      *Client   // 调用对端服务的客户端，见ReverseClient
+	identity  *Identity // 客户端证书的身份，见IdentityFromContext
+	authorize func(ctx context.Context, method string) error
+	translate func(ctx context.Context, method string, err error) error
+	faults    faults
+	mirrors   mirrors
+	baggage   baggages
+	watchdog  *watchdog
+	clock     Clock
+	dedup     *dedupStore
+	frames    bool              // codec经过Magic握手，能识别控制帧和尾部帧
+	idle      *idleTracker      // 空闲检测，见WithKeepalive
+	bw        codec.BatchWriter // 为nil时每个响应单独写
+	maxBytes  int
+	maxDelay  time.Duration
+	settings  *atomic.Pointer[ServerSettings] // 每次写时读取写期限，见WithWriteTimeout
+
+	waiting  atomic.Int32 // 等待写入的响应数
+	mu       sync.Mutex   // protect following
+	since    time.Time    // 缓冲中最早的未刷新响应的写入时间
+	deadline time.Time    // 连接上设置的写期限
+}
+
+func (s *Server) newResponseWriter(cc codec.Codec) *responseWriter {
+	w := &responseWriter{
+		cc:        cc,
+		stats:     s.stats,
+		trace:     &s.trace,
+		requests:  s.requests,
+		authorize: s.authorize,
+		translate: s.translate,
+		faults:    s.faults,
+		mirrors:   s.mirrors,
+		baggage:   s.baggage,
+		watchdog:  s.watchdog,
+		clock:     s.clock,
+		dedup:     s.dedup,
+		maxBytes:  s.coalesceBytes,
+		maxDelay:  s.coalesceDelay,
+		settings:  &s.settings,
+	}
+	if bw, ok := cc.(codec.BatchWriter); ok && s.coalesceBytes > 0 {
+		w.bw = bw
+	}
+	return w
+}
+
+func (w *responseWriter) remoteString() string {
+	if w.remote == nil || w.requests == nil {
+		return ""
+	}
+	return w.remote.String()
+}
+
+// 返回响应的大小，不统计时为0
+func (w *responseWriter) write(h *codec.Header, body any) int {
+	n := w.writeFrame(h, body)
+	if t := w.trace.Load(); t.enabled(w.remote) {
+		t.frame(w.remote, "send", h, n, body)
+	}
+	return n
+}
+
+func (w *responseWriter) writeFrame(h *codec.Header, body any) int {
+	if w.bw == nil {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		written := outBytes(w.cn, nil)
+		w.setDeadline()
+		if err := w.cc.Write(h, body); err != nil {
+			w.writeError(err)
+			return 0
+		}
+		w.cn.countWrite()
+		return int(outBytes(w.cn, nil) - written)
+	}
+
+	w.waiting.Add(1)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	// 之后还有响应在等锁，就把刷新留给它们
+	queued := w.waiting.Add(-1) > 0
+	written := outBytes(w.cn, w.bw)
+	w.setDeadline() // 缓冲满了时WriteBuffered也会写连接
+	if err := w.bw.WriteBuffered(h, body); err != nil {
+		w.writeError(err)
+		return 0
+	}
+	n := int(outBytes(w.cn, w.bw) - written)
+	w.cn.countWrite()
+	if w.since.IsZero() {
+		w.since = time.Now()
+	}
+	if queued && w.bw.Buffered() < w.maxBytes && time.Since(w.since) < w.maxDelay {
+		return n
+	}
+	w.since = time.Time{}
+	if err := w.bw.Flush(); err != nil {
+		w.writeError(err)
+	}
+	return n
+}
+
+// 持有w.mu时调用。期限还剩一半以上时沿用，不必每个响应都重设，
+// 这样一次写入最少也能等timeout/2
+func (w *responseWriter) setDeadline() {
+	if w.conn == nil {
+		return
+	}
+	timeout := w.settings.Load().WriteTimeout
+	if timeout <= 0 {
+		if !w.deadline.IsZero() { // 运行中关闭了写期限
+			w.deadline = time.Time{}
+			w.conn.SetWriteDeadline(w.deadline)
+		}
+		return
+	}
+	now := time.Now()
+	if left := w.deadline.Sub(now); left > timeout/2 && left <= timeout {
+		return
+	}
+	w.deadline = now.Add(timeout)
+	w.conn.SetWriteDeadline(w.deadline)
+}
+
+// 写超时说明客户端不再读数据，关闭连接，读循环随之退出，
+// 之后的响应立即失败，不再排队等锁
+func (w *responseWriter) writeError(err error) {
+	if w.conn != nil && errors.Is(err, os.ErrDeadlineExceeded) {
+		log.Println("rpc server: client stopped reading, closing connection:", w.remote)
+		serverEvictedConns.Add(1)
+		w.conn.Close()
+		return
+	}
+	log.Println("rpc server: write response error:", err)
+}
+
+// 读到请求时的事件，在处理请求的协程中补发，这样ctx才是传给方法的ctx
+func (w *responseWriter) statsBegin(ctx context.Context, req *request) {
+	if w.stats == nil {
+		return
 	}
-	return req, nil
+	service, _ := splitMethod(req.h.Name)
+	w.stats.HandleRPC(ctx, &Begin{Method: req.h.Name, Service: service, Seq: req.h.Seq, BeginTime: req.begin})
+	w.stats.HandleRPC(ctx, &InPayload{Method: req.h.Name, Service: service, Seq: req.h.Seq, Length: req.inLen, RecvTime: req.begin})
 }
 
-// 写响应数据的协程，加锁
-func (s *Server) writeResponse(cc codec.Codec, h *codec.Header, body any, mu *sync.Mutex) {
-	mu.Lock()
-	defer mu.Unlock()
-	if err := cc.Write(h, body); err != nil {
-		log.Println("rpc server: write response error:", err)
+// 写完响应时的事件，n是响应的大小
+func (w *responseWriter) statsEnd(ctx context.Context, req *request, n int, err error) {
+	if w.stats == nil {
+		return
 	}
+	now := time.Now()
+	service, _ := splitMethod(req.h.Name)
+	w.stats.HandleRPC(ctx, &OutPayload{Method: req.h.Name, Service: service, Seq: req.h.Seq, Length: n, SentTime: now})
+	w.stats.HandleRPC(ctx, &End{Method: req.h.Name, Service: service, Seq: req.h.Seq, BeginTime: req.begin, EndTime: now, Error: err, Code: CodeOf(err)})
 }
 
-// 处理请求，写回响应
-func (s *Server) handleRequest(cc codec.Codec, req *request, mu *sync.Mutex, wg *sync.WaitGroup) {
+// 处理请求，写回响应，最后归还流量控制的窗口
+func handleRequest(req *request) {
+	w, window, wg := req.w, req.window, req.wg
 	defer wg.Done()
+	defer w.idle.end()
+	defer window.release()
+	defer putRequest(req)
+	defer req.endTurn()
+
+	ctx := context.Background()
+	var tc *trailerCtx
+	if req.mType.withContext || req.mType.handler != nil { // 方法能拿到ctx，才可能设置尾部
+		tc = &trailerCtx{Context: ctx}
+		ctx = tc
+	}
+	ctx = withIncomingMetadata(ctx, req.h.Meta)
+	ctx = w.baggage.extract(ctx, req.h.Meta)
+	req.h.Meta = nil // 响应不带回元数据
+	if w.peer != nil {
+		ctx = context.WithValue(ctx, reverseKey{}, w.peer)
+	}
+	if w.identity != nil {
+		ctx = context.WithValue(ctx, identityKey{}, w.identity)
+	}
+	if req.mType.fallback {
+		ctx = context.WithValue(ctx, fallbackKey{}, req.h.Name)
+	}
+	if req.h.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = w.clock.WithTimeout(ctx, time.Duration(req.h.Timeout))
+		defer cancel()
+		req.h.Timeout = 0
+	}
+	if len(req.svc.middleware) > 0 {
+		name := req.h.Name
+		if req.alias != nil {
+			name = req.alias.name
+		}
+		if name != req.mType.name {
+			ctx = context.WithValue(ctx, requestedKey{}, name)
+		}
+	}
+	ctx, running := w.watchdog.begin(ctx, req.h.Name)
+	req.alias.called(ctx)
+	w.statsBegin(ctx, req)
+	id := w.requests.begin(req.h.Name, req.h.Seq, w.remoteString())
+	var n int
+	var err error
+	var fault *Fault
+	if w.faults != nil {
+		fault = w.faults.pick(req.h.Name)
+	}
+	if w.authorize != nil {
+		if err = w.authorize(ctx, req.h.Name); err != nil {
+			err = authorizeError(err)
+		}
+	}
+	if err == nil && fault != nil {
+		err = fault.inject(ctx, w.clock, w.cc)
+	}
+	if err == nil && w.mirrors != nil {
+		w.mirrors.send(ctx, w.clock, req)
+	}
+	if err == nil {
+		err = w.dedup.do(ctx, w.clock, req.h.Name, w.identity, req.replyv, func() error {
+			err := req.svc.call(ctx, req.mType, req.argv, req.replyv)
+			return deadlineError(ctx, w.translateError(ctx, req.h.Name, err))
+		})
+	}
+	err = w.watchdog.end(running, err)
+	w.requests.end(id, err)
+	req.waitTurn()
+	name := req.h.Name
+	if req.interned {
+		req.h.Name = ""
+	}
+	switch {
+	case fault != nil && (fault.Drop || fault.Reset): // 不发送响应
+	case err != nil:
+		n = w.writeResponse(req.h, errorBody(req.h, err), tc)
+	default:
+		n = w.writeResponse(req.h, replyBody(req.replyv.Interface()), tc)
+	}
+	req.h.Name = name
+	countRequest(req, n, err)
+	w.statsEnd(ctx, req, n, err)
+}
+
+// 调用方传来的期限已过时，方法的结果已经没有意义，统一返回DeadlineExceeded，
+// 方法自己返回了带错误码的*Error时保留它
+func deadlineError(ctx context.Context, err error) error {
+	if ctx.Err() != context.DeadlineExceeded {
+		return err
+	}
+	var e *Error
+	if errors.As(err, &e) {
+		return err
+	}
+	if err == nil {
+		err = ctx.Err()
+	}
+	return &Error{Code: DeadlineExceeded, Message: "rpc server: " + err.Error()}
+}
+
+const truncated = "...(truncated)"
+
+// 把错误写进响应头，返回响应的消息体：*Error带详情时是详情，否则为空
+func errorBody(h *codec.Header, err error) any {
+	h.Error = err.Error()
+	if len(h.Error) > codec.MaxErrorSize { // 截断过长的错误，否则响应写不出去
+		h.Error = strings.ToValidUTF8(h.Error[:codec.MaxErrorSize-len(truncated)], "") + truncated
+	}
+	var e *Error
+	if !errors.As(err, &e) {
+		return invalidRequest
+	}
+	h.Code = uint32(e.Code)
+	names, body := encodeDetails(e.Details)
+	if body == nil {
+		return invalidRequest
+	}
+	h.Details = names
+	return body
+}
 
-	if err := req.svc.call(req.mType, req.argv, req.replyv); err != nil {
-		req.h.Error = err.Error()
-		s.writeResponse(cc, req.h, invalidRequest, mu)
+// 记录到expvar，n是响应的大小
+func countRequest(req *request, n int, err error) {
+	serverRequests.Add(1)
+	serverBytesRead.Add(int64(req.inLen))
+	serverBytesWritten.Add(int64(n))
+	if err != nil {
+		serverErrors.Add(1)
+	}
+	if req.mType != nil {
+		req.mType.vars.calls.Add(1)
+		if err != nil {
+			req.mType.vars.errors.Add(1)
+		}
 	}
-	s.writeResponse(cc, req.h, req.replyv.Interface(), mu)
 }
@@ -0,0 +1,242 @@
+package mrpc
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/micplus/mrpc/codec"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// 只记录写入和刷新次数的codec
+type countingCodec struct {
+	codec.Codec
+	buffered, writes, flushes int
+}
+
+func (c *countingCodec) WriteBuffered(*codec.Header, any) error {
+	c.buffered += 10
+	c.writes++
+	return nil
+}
+
+func (c *countingCodec) Flush() error {
+	c.buffered = 0
+	c.flushes++
+	return nil
+}
+
+func (c *countingCodec) Buffered() int { return c.buffered }
+
+func TestCoalescedWrites(t *testing.T) {
+	for _, tt := range []struct {
+		maxBytes int
+		flushes  int
+	}{
+		{1 << 20, 1}, // 排队的响应一次刷新
+		{30, 4},      // 每3个响应达到上限刷新一次，最后一个单独刷新
+	} {
+		cc := new(countingCodec)
+		s := NewServer(WithWriteCoalescing(tt.maxBytes, time.Hour))
+		w := s.newResponseWriter(cc)
+
+		// 先占住锁，让10个响应都排队
+		w.mu.Lock()
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				w.write(&codec.Header{}, invalidRequest)
+			}()
+		}
+		for w.waiting.Load() != 10 {
+			time.Sleep(time.Millisecond)
+		}
+		w.mu.Unlock()
+		wg.Wait()
+		assert(t, cc.writes == 10 && cc.flushes == tt.flushes,
+			"maxBytes %d: %d writes %d flushes, want 10 writes %d flushes", tt.maxBytes, cc.writes, cc.flushes, tt.flushes)
+	}
+
+	// 单个响应没有排队，立即刷新
+	cc := new(countingCodec)
+	w := NewServer().newResponseWriter(cc)
+	w.write(&codec.Header{}, invalidRequest)
+	assert(t, cc.flushes == 1, "lone response should be flushed immediately")
+}
+
+// 不依赖gob的两种编码：Go客户端照常使用，其它语言按格式直接读写帧
+func TestInteropCodecs(t *testing.T) {
+	RegisterErrorDetail(FieldViolation{})
+	s := NewServer()
+	s.Register(new(Calc))
+	HandleFunc(s, "Order.Check", func(ctx context.Context, n int, reply *string) error {
+		if n < 0 {
+			return Errorf(InvalidArgument, "bad quantity").WithDetails(FieldViolation{"quantity", "must be positive"})
+		}
+		*reply = IncomingMetadata(ctx)["user"]
+		return nil
+	})
+	for _, codecType := range []uint32{codec.JSONType, codec.BinaryJSONType} {
+		client := pipeClient(t, s, WithClientCodecType(codecType))
+		var sum int
+		err := client.Call("Calc.Sum", Pair{1, 2}, &sum)
+		assert(t, err == nil && sum == 3, "%s: sum=%d err=%v", codec.TypeName(codecType), sum, err)
+		var user string
+		err = client.Call("Order.Check", 1, &user, WithCallMetadata(Metadata{"user": "ann"}))
+		assert(t, err == nil && user == "ann", "%s: user=%q err=%v", codec.TypeName(codecType), user, err)
+		err = client.Call("Order.Check", -1, &user)
+		var e *Error
+		assert(t, errors.As(err, &e) && e.Code == InvalidArgument && len(e.Details) == 1 &&
+			e.Details[0].(FieldViolation).Field == "quantity", "%s: error %#v", codec.TypeName(codecType), err)
+		client.Close()
+	}
+
+	// 只用长度前缀和encoding/json，模拟其它语言的客户端
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	go s.ServeConn(c2)
+	frame := func(s string) []byte {
+		return append(binary.BigEndian.AppendUint32(nil, uint32(len(s))), s...)
+	}
+	req := binary.BigEndian.AppendUint32(binary.BigEndian.AppendUint32(nil, Magic), codec.JSONType)
+	req = append(req, frame(`{"seq":1,"name":"Calc.Sum"}`)...)
+	req = append(req, frame(`{"A":20,"B":22}`)...)
+	go c1.Write(req)
+	readFrame := func() []byte {
+		head := make([]byte, 4)
+		if _, err := io.ReadFull(c1, head); err != nil {
+			t.Fatal(err)
+		}
+		p := make([]byte, binary.BigEndian.Uint32(head))
+		if _, err := io.ReadFull(c1, p); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+	for {
+		var h struct{ Seq uint64 }
+		header := readFrame()
+		if err := json.Unmarshal(header, &h); err != nil {
+			t.Fatal(err)
+		}
+		body := readFrame()
+		if h.Seq == 0 { // 控制帧
+			continue
+		}
+		assert(t, string(header) == `{"seq":1,"name":"Calc.Sum"}` && string(body) == "42", "response %s %s", header, body)
+		break
+	}
+}
+
+// protobuf编码：参数和返回值是proto.Message，控制帧照常收发
+func TestProtoCodec(t *testing.T) {
+	s := NewServer()
+	HandleFunc(s, "Greeter.Hello", func(ctx context.Context, name *wrapperspb.StringValue, reply *wrapperspb.StringValue) error {
+		if name.GetValue() == "" {
+			return Errorf(InvalidArgument, "empty name").WithDetails(FieldViolation{"name", "required"})
+		}
+		reply.Value = "hello " + name.GetValue()
+		return nil
+	})
+	client := pipeClient(t, s, WithClientCodecType(codec.ProtoType))
+	for i := 0; i < 3; i++ {
+		reply := new(wrapperspb.StringValue)
+		err := client.Call("Greeter.Hello", wrapperspb.String("ann"), reply)
+		assert(t, err == nil && reply.GetValue() == "hello ann", "reply=%q err=%v", reply.GetValue(), err)
+	}
+	err := client.Call("Greeter.Hello", wrapperspb.String(""), new(wrapperspb.StringValue))
+	assert(t, CodeOf(err) == InvalidArgument, "want InvalidArgument, got %v", err)
+
+	var sum int
+	err = client.Call("Calc.Sum", Pair{1, 2}, &sum)
+	assert(t, err != nil && strings.Contains(err.Error(), "not a proto.Message"), "want encoding error, got %v", err)
+}
+
+type Faulty int
+
+func (*Faulty) Fail(args int, reply *int) error {
+	*reply = args
+	return errors.New("always fails")
+}
+
+func (*Faulty) Echo(args int, reply *int) error {
+	*reply = args
+	return nil
+}
+
+func TestAcceptReturnsOnClose(t *testing.T) {
+	s := NewServer()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	go func() {
+		s.Accept(l)
+		close(done)
+	}()
+	l.Close()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Accept did not return after the listener was closed")
+	}
+}
+
+func TestUnknownMethodKeepsConnection(t *testing.T) {
+	s := NewServer()
+	s.Register(new(Faulty))
+	client := pipeClient(t, s)
+
+	var reply int
+	err := client.Call("Faulty.Missing", 1, &reply)
+	_, ok := err.(ServerError)
+	assert(t, ok, "want ServerError for unknown method, got %v", err)
+	// 请求体已被读掉，下一个请求能正常解析
+	err = client.Call("Faulty.Echo", 2, &reply)
+	assert(t, err == nil && reply == 2, "Faulty.Echo = %d, %v", reply, err)
+}
+
+func TestErrorWritesOneResponse(t *testing.T) {
+	s := NewServer()
+	s.Register(new(Faulty))
+	c1, c2 := net.Pipe()
+	go s.ServeConn(c2)
+	defer c1.Close()
+
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint32(buf[:4], Magic)
+	binary.BigEndian.PutUint32(buf[4:], codec.GobType)
+	if _, err := c1.Write(buf); err != nil {
+		t.Fatal(err)
+	}
+	cc := codec.NewGobCodec(c1)
+	call := func(seq uint64, name string) *codec.Header {
+		// 服务端读完整个请求才会写响应，同步写不会阻塞
+		if err := cc.Write(&codec.Header{Seq: seq, Name: name}, 1); err != nil {
+			t.Fatal(err)
+		}
+		var h codec.Header
+		if err := cc.ReadHeader(&h); err != nil {
+			t.Fatal(err)
+		}
+		cc.ReadBody(nil)
+		return &h
+	}
+
+	// 出错的请求只应有一个响应，否则下一个读到的还是它
+	h := call(1, "Faulty.Fail")
+	assert(t, h.Seq == 1 && h.Error != "", "want error response for seq 1, got %+v", h)
+	h = call(2, "Faulty.Echo")
+	assert(t, h.Seq == 2 && h.Error == "", "want response for seq 2, got %+v", h)
+}
@@ -1,10 +1,14 @@
 package mrpc
 
 import (
+	"context"
+	"fmt"
 	"go/ast"
 	"log"
 	"reflect"
+	"sync"
 	"sync/atomic"
+	"time"
 )
 
 // 通过反射注册服务
@@ -17,12 +21,27 @@ import (
 
 // 具体的方法（Add、Multiply等）方法名、参数、返回值
 type methodType struct {
+	name      string // "Service.Method"
 	method    reflect.Method
 	ArgType   reflect.Type
 	ReplyType reflect.Type
+	// 方法的第一个参数是context.Context
+	withContext bool
+	// 不为nil时直接调用它而不是反射调用method
+	handler handlerFunc
+	// 兜底的处理函数，调用时方法名放在ctx中，见WithFallbackHandler
+	fallback bool
+	// 不为nil时在锁定了线程的工作协程中调用，见WithLockedThreads
+	threads *lockedThreads
+	// 复用的参数、返回值，存放的是指向它们的指针，为nil时不复用
+	argPool, replyPool *sync.Pool
 
 	// 辅助记录调用次数
 	numCalls uint64
+	// 发布到expvar的计数
+	vars methodVars
+	// 错误数和耗时分布，见MethodStats
+	stats methodStats
 }
 
 func (mt *methodType) NumCalls() uint64 {
@@ -54,6 +73,79 @@ func (mt *methodType) newReplyv() reflect.Value {
 	return replyv
 }
 
+// 参数、返回值类型实现了Resetter时，请求处理完后值会被Reset并复用，
+// 方法不能在返回后继续持有它们
+type Resetter interface {
+	Reset()
+}
+
+var typeOfResetter = reflect.TypeOf((*Resetter)(nil)).Elem()
+
+// 参数按值传入时方法拿到的是副本，总是可以复用。指针参数和返回值可能被方法在返回后继续持有，
+// 只有类型实现了Resetter、表明遵守复用的约定时才复用
+func (mt *methodType) initPools() {
+	if mt.ArgType.Kind() != reflect.Pointer || mt.ArgType.Implements(typeOfResetter) {
+		mt.argPool = &sync.Pool{New: func() any {
+			argv := mt.newArgv()
+			if argv.Kind() != reflect.Pointer {
+				return argv.Addr().Interface()
+			}
+			return argv.Interface()
+		}}
+	}
+	if mt.ReplyType.Implements(typeOfResetter) {
+		mt.replyPool = &sync.Pool{New: func() any {
+			return mt.newReplyv().Interface()
+		}}
+	}
+}
+
+// 取一个参数值，先从池中取
+func (mt *methodType) getArgv() reflect.Value {
+	if mt.argPool == nil {
+		return mt.newArgv()
+	}
+	argv := reflect.ValueOf(mt.argPool.Get())
+	if mt.ArgType.Kind() != reflect.Pointer {
+		return argv.Elem()
+	}
+	return argv
+}
+
+func (mt *methodType) getReplyv() reflect.Value {
+	if mt.replyPool == nil {
+		return mt.newReplyv()
+	}
+	return reflect.ValueOf(mt.replyPool.Get())
+}
+
+// 清空后放回池中，p是指向值的指针
+func reset(pool *sync.Pool, p any) {
+	if r, ok := p.(Resetter); ok {
+		r.Reset()
+	} else {
+		reflect.ValueOf(p).Elem().SetZero()
+	}
+	pool.Put(p)
+}
+
+func (mt *methodType) putArgv(argv reflect.Value) {
+	if mt.argPool == nil || !argv.IsValid() {
+		return
+	}
+	if argv.Kind() != reflect.Pointer {
+		argv = argv.Addr()
+	}
+	reset(mt.argPool, argv.Interface())
+}
+
+func (mt *methodType) putReplyv(replyv reflect.Value) {
+	if mt.replyPool == nil || !replyv.IsValid() {
+		return
+	}
+	reset(mt.replyPool, replyv.Interface())
+}
+
 // type Arith int
 // func (*Arith) Add(args []int, reply *int) error
 // 被解释成
@@ -65,6 +157,8 @@ type service struct {
 	typ    reflect.Type // Arith类型 typ=reflect.ValueOf(rcvr)
 	rcvr   reflect.Value
 	method map[string]*methodType
+	// 包在方法外面的中间件，见WithMiddleware
+	middleware []Middleware
 }
 
 // receiver可以是结构体或指向结构体的指针
@@ -73,58 +167,137 @@ type service struct {
 // x := ValueOf(rcvr) -> Value.Kind()==Pointer
 // x = Indirect(x)	-> Value.Kind()==Struct
 func newService(rcvr any) *service {
+	name := reflect.Indirect(reflect.ValueOf(rcvr)).Type().Name()
+	if !ast.IsExported(name) { // 不是导出的结构体，rpc服务注册失败
+		log.Fatalf("rpc server: %s is not a valid service name", name)
+	}
+	return newNamedService(rcvr, name)
+}
+
+// 以指定的名称创建服务，接收者类型本身可以不导出
+func newNamedService(rcvr any, name string) *service {
 	s := new(service)
 	// 绑定到结构体的方法，可以用指针接收也可以不是指针
 	s.rcvr = reflect.ValueOf(rcvr)
 	s.typ = reflect.TypeOf(rcvr)
-	s.name = reflect.Indirect(s.rcvr).Type().Name()
-	if !ast.IsExported(s.name) { // 不是导出的结构体，rpc服务注册失败
-		log.Fatalf("rpc server: %s is not a valid service name", s.name)
-	}
-	s.registerMethods()
+	s.name = name
+	s.registerMethods(nil)
 
 	return s
 }
 
+var (
+	typeOfError   = reflect.TypeOf((*error)(nil)).Elem()
+	typeOfContext = reflect.TypeOf((*context.Context)(nil)).Elem()
+)
+
 // 取出传入结构体的所有方法名，及其实体，映射到方法表。
-// 函数也是引用类型的值
-func (s *service) registerMethods() {
+// 函数也是引用类型的值。only不为nil时只注册这个接口中的方法
+func (s *service) registerMethods(only reflect.Type) {
 	s.method = make(map[string]*methodType)
 	// Arith结构可以注册多种方法，不一定是供rpc调用的
 	for i := 0; i < s.typ.NumMethod(); i++ {
 		m := s.typ.Method(i)
-		mt := m.Type
-		// func(*Arith, int, *int) error
-		if mt.NumIn() != 3 || mt.NumOut() != 1 {
-			continue
+		if only != nil {
+			if _, ok := only.MethodByName(m.Name); !ok {
+				continue
+			}
 		}
-		// 返回值是error类型
-		if mt.Out(0) != reflect.TypeOf((*error)(nil)).Elem() {
+		mt := m.Type
+		if checkMethod(mt) != nil {
 			continue
 		}
-		argType, replyType := mt.In(1), mt.In(2)
-		if !isExportedOrBuiltin(argType) || !isExportedOrBuiltin(replyType) {
-			continue
+		in := 1
+		if mt.NumIn() == 4 {
+			in = 2
 		}
-		s.method[m.Name] = &methodType{
-			method:    m,
-			ArgType:   argType,
-			ReplyType: replyType,
+		mType := &methodType{
+			name:        methodName(s.name, m.Name),
+			method:      m,
+			ArgType:     mt.In(in),
+			ReplyType:   mt.In(in + 1),
+			withContext: in == 2,
 		}
-		log.Printf("rpc server: register %s.%s", s.name, m.Name)
+		mType.initPools()
+		mType.handler = precompile(s.rcvr.Method(i).Interface())
+		mType.vars = newMethodVars(mType.name)
+		s.method[m.Name] = mType
+		log.Printf("rpc server: register %s", mType.name)
 	}
 }
 
+// 检查方法是否符合rpc的规定，mt带有接收者：
+// func(*Arith, int, *int) error
+// 或者 func(*Arith, context.Context, int, *int) error
+func checkMethod(mt reflect.Type) error {
+	in := 1
+	if mt.NumIn() == 4 && mt.In(1) == typeOfContext {
+		in = 2
+	}
+	if mt.NumIn() != in+2 {
+		return fmt.Errorf("has %d parameters, want (args, *reply) or (context.Context, args, *reply)", mt.NumIn()-1)
+	}
+	// 返回值是error类型
+	if mt.NumOut() != 1 {
+		return fmt.Errorf("returns %d results, want error", mt.NumOut())
+	}
+	if mt.Out(0) != typeOfError {
+		return fmt.Errorf("returns %s, want error", mt.Out(0))
+	}
+	argType, replyType := mt.In(in), mt.In(in+1)
+	if !isExportedOrBuiltin(argType) {
+		return fmt.Errorf("argument type %s is not exported", argType)
+	}
+	if replyType.Kind() != reflect.Pointer {
+		return fmt.Errorf("reply type %s is not a pointer", replyType)
+	}
+	if !isExportedOrBuiltin(replyType) {
+		return fmt.Errorf("reply type %s is not exported", replyType)
+	}
+	return nil
+}
+
 // rpc的参数需要是可访问的导出类型或内置类型
 func isExportedOrBuiltin(t reflect.Type) bool {
 	return ast.IsExported(t.Name()) || t.PkgPath() == ""
 }
 
-// 使用反射来调用方法
-func (s *service) call(m *methodType, argv, replyv reflect.Value) error {
+// 使用反射来调用方法，方法不接收ctx时忽略它
+func (s *service) call(ctx context.Context, m *methodType, argv, replyv reflect.Value) error {
 	atomic.AddUint64(&m.numCalls, 1) // 记录
+	m.stats.active.Add(1)
+	defer m.stats.active.Add(-1)
+	start := time.Now()
+	var err error
+	if len(s.middleware) > 0 {
+		err = s.intercept(ctx, m, argv, replyv)
+	} else {
+		err = s.run(ctx, m, argv, replyv)
+	}
+	m.stats.record(time.Since(start), err)
+	return err
+}
 
-	rets := m.method.Func.Call([]reflect.Value{s.rcvr, argv, replyv})
+func (s *service) run(ctx context.Context, m *methodType, argv, replyv reflect.Value) error {
+	if m.threads != nil {
+		return m.threads.run(ctx, func() error { return s.invoke(ctx, m, argv, replyv) })
+	}
+	return s.invoke(ctx, m, argv, replyv)
+}
+
+func (s *service) invoke(ctx context.Context, m *methodType, argv, replyv reflect.Value) error {
+	if m.handler != nil {
+		arg := argv
+		if arg.Kind() != reflect.Pointer {
+			arg = arg.Addr()
+		}
+		return m.handler(ctx, arg.Interface(), replyv.Interface())
+	}
+	in := []reflect.Value{s.rcvr, argv, replyv}
+	if m.withContext {
+		in = []reflect.Value{s.rcvr, reflect.ValueOf(ctx), argv, replyv}
+	}
+	rets := m.method.Func.Call(in)
 	if iErr := rets[0].Interface(); iErr != nil {
 		return iErr.(error)
 	}
@@ -1,7 +1,10 @@
 package mrpc
 
 import (
+	"context"
+	"net"
 	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -33,13 +36,152 @@ func TestService(t *testing.T) {
 	} else {
 		argv.Set(reflect.ValueOf(*args))
 	}
-	err := s.call(addType, argv, replyv)
+	err := s.call(context.Background(), addType, argv, replyv)
 	assert(t, err == nil && *replyv.Interface().(*int) == 3 && addType.NumCalls() == 1, "call Arith.Add failed")
 }
 
+// 让s处理一条新的进程内连接，返回连接另一端的客户端，测试结束时关闭
+func pipeClient(t *testing.T, s *Server, opts ...ClientOption) *Client {
+	c1, c2 := net.Pipe()
+	go s.ServeConn(c2)
+	client, err := NewClientOptions(c1, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
 func assert(t *testing.T, cond bool, format string, v ...any) {
 	t.Helper()
 	if !cond {
 		t.Errorf(format, v...)
 	}
 }
+
+type arithImpl struct{ Arith }
+
+func TestRegisterName(t *testing.T) {
+	s := NewServer()
+	assert(t, s.RegisterName("Calculator", new(arithImpl)) == nil, "RegisterName with unexported type failed")
+	_, mt, err := s.findService("Calculator.Add")
+	assert(t, err == nil && mt != nil, "Calculator.Add not found: %v", err)
+	assert(t, s.RegisterName("Calculator", new(Arith)) != nil, "duplicated name should fail")
+	assert(t, s.RegisterName("calc", new(Arith)) != nil, "unexported name should fail")
+	assert(t, s.RegisterName("A.B", new(Arith)) != nil, "dotted name should fail")
+}
+
+type Buf struct {
+	Data   []byte
+	resets int
+}
+
+func (b *Buf) Reset() {
+	b.Data = b.Data[:0]
+	b.resets++
+}
+
+type Pooled int
+
+func (*Pooled) Echo(args *Buf, reply *Buf) error {
+	reply.Data = append(reply.Data, args.Data...)
+	return nil
+}
+
+func (*Pooled) Keep(args *Args, reply *int) error {
+	return nil
+}
+
+func (*Pooled) Value(args Args, reply *int) error {
+	return nil
+}
+
+func TestMethodPools(t *testing.T) {
+	s := newService(new(Pooled))
+	echo, keep, value := s.method["Echo"], s.method["Keep"], s.method["Value"]
+	assert(t, echo.argPool != nil && echo.replyPool != nil, "Resetter values should be pooled")
+	assert(t, keep.argPool == nil && keep.replyPool == nil, "values without Reset should not be pooled")
+	assert(t, value.argPool != nil, "by-value args should be pooled")
+
+	replyv := echo.getReplyv()
+	replyv.Interface().(*Buf).Data = []byte("stale")
+	echo.putReplyv(replyv)
+	// Pool不保证一定取回同一个值，只检查取回的值是干净的
+	got := echo.getReplyv().Interface().(*Buf)
+	assert(t, len(got.Data) == 0, "reused reply not reset: %q", got.Data)
+
+	argv := value.getArgv()
+	argv.Set(reflect.ValueOf(Args{1, 2}))
+	value.putArgv(argv)
+	assert(t, value.getArgv().Interface().(Args) == Args{}, "reused arg not zeroed")
+}
+
+// 返回后仍持有返回值的服务
+type Keeper struct {
+	kept []*Pair
+}
+
+func (k *Keeper) Remember(n int, reply *Pair) error {
+	reply.A = n
+	k.kept = append(k.kept, reply)
+	return nil
+}
+
+func TestKeptReplyNotReused(t *testing.T) {
+	k := new(Keeper)
+	client, _, err := NewClientServerPair(k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	for i := 1; i <= 3; i++ {
+		if err := client.Call("Keeper.Remember", i, new(Pair)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i, p := range k.kept {
+		assert(t, p.A == i+1, "kept reply %d was reused: %+v", i, *p)
+	}
+}
+
+type Adder interface {
+	Add(args *Args, reply *int) error
+}
+
+type BadCalc interface {
+	Add(args *Args, reply *int) error
+	Sum(a, b int) int
+	Neg(x int, reply int) error
+	hidden()
+}
+
+type badCalc struct{ Arith }
+
+func (badCalc) Sum(a, b int) int           { return a + b }
+func (badCalc) Neg(x int, reply int) error { return nil }
+func (badCalc) hidden()                    {}
+
+func TestRegisterChecked(t *testing.T) {
+	s := NewServer()
+	assert(t, RegisterChecked[Adder](s, new(Arith)) == nil, "RegisterChecked failed")
+	_, _, err := s.findService("Adder.Add")
+	assert(t, err == nil, "Adder.Add not found: %v", err)
+	_, _, err = s.findService("Adder.Multiply")
+	assert(t, err != nil, "methods outside the interface should not be registered")
+	assert(t, RegisterChecked[Adder](s, new(Arith)) != nil, "duplicated service should fail")
+
+	err = RegisterChecked[BadCalc](s, new(badCalc))
+	for _, want := range []string{
+		"BadCalc.Sum returns int, want error",
+		"BadCalc.Neg reply type int is not a pointer",
+		"BadCalc.hidden is not exported",
+	} {
+		assert(t, err != nil && strings.Contains(err.Error(), want), "missing %q in %v", want, err)
+	}
+	assert(t, !strings.Contains(err.Error(), "BadCalc.Add"), "Add is valid: %v", err)
+	_, _, err = s.findService("BadCalc.Add")
+	assert(t, err != nil, "failed service should not be registered")
+
+	assert(t, RegisterChecked[*Arith](s, new(Arith)) != nil, "non-interface should fail")
+	assert(t, RegisterChecked[Adder](NewServer(), nil) != nil, "nil receiver should fail")
+}
@@ -0,0 +1,83 @@
+package mrpc
+
+import (
+	"time"
+
+	"github.com/micplus/mrpc/codec"
+)
+
+// 服务端运行中可以修改的设置，初值来自对应的选项。修改是原子的，
+// 不需要重启或让客户端重连：
+//
+//	s.UpdateSettings(func(st *mrpc.ServerSettings) {
+//		st.WriteTimeout = 5 * time.Second
+//		st.FlowWindow = 64
+//	})
+//
+// 限速在RateLimiter.SetLimit中修改，共用限速器的连接立即生效
+type ServerSettings struct {
+	// 见WithHandshakeTimeout，对之后的连接生效
+	HandshakeTimeout time.Duration
+	// 见WithWriteTimeout，对所有连接之后的响应生效
+	WriteTimeout time.Duration
+	// 每条连接上同时处理的请求数，见WithFlowWindow。窗口在握手后告知客户端，对之后的连接生效
+	FlowWindow int
+	// 见WithCompression，对之后的连接生效
+	Compression int
+}
+
+// 当前的设置
+func (s *Server) Settings() ServerSettings {
+	return *s.settings.Load()
+}
+
+// 在当前设置的副本上调用update，再整体替换，并发的修改不会互相覆盖
+func (s *Server) UpdateSettings(update func(st *ServerSettings)) {
+	for {
+		old := s.settings.Load()
+		st := *old
+		update(&st)
+		if s.settings.CompareAndSwap(old, &st) {
+			return
+		}
+	}
+}
+
+// 客户端运行中可以修改的设置，见ServerSettings。限速在RateLimiter.SetLimit中修改
+type ClientSettings struct {
+	// 见WithClientCompression，对之后的请求生效
+	Compression int
+	// 见WithClientWriteCoalescing，对之后的请求生效
+	CoalesceBytes int
+	CoalesceDelay time.Duration
+}
+
+func (c *Client) Settings() ClientSettings {
+	c.sending.Lock()
+	defer c.sending.Unlock()
+	return ClientSettings{Compression: c.compress, CoalesceBytes: c.maxBytes, CoalesceDelay: c.maxDelay}
+}
+
+// 同Server.UpdateSettings，修改在发送请求的锁内进行，与正在发送的请求互不干扰
+func (c *Client) UpdateSettings(update func(st *ClientSettings)) {
+	c.sending.Lock()
+	defer c.sending.Unlock()
+	st := ClientSettings{Compression: c.compress, CoalesceBytes: c.maxBytes, CoalesceDelay: c.maxDelay}
+	update(&st)
+	if cc, ok := c.cc.(codec.Compressor); ok && st.Compression != c.compress {
+		cc.SetCompression(max(st.Compression, 0))
+	}
+	c.compress = st.Compression
+	c.maxBytes, c.maxDelay = st.CoalesceBytes, st.CoalesceDelay
+	bw, _ := c.cc.(codec.BatchWriter)
+	if st.CoalesceBytes <= 0 {
+		bw = nil
+	}
+	if c.bw != nil && bw == nil { // 不再合并，先把缓冲中的请求发出去
+		if err := c.bw.Flush(); err != nil {
+			c.cc.Close()
+		}
+		c.since = time.Time{}
+	}
+	c.bw = bw
+}
@@ -0,0 +1,84 @@
+package mrpc
+
+import (
+	"encoding/binary"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/micplus/mrpc/codec"
+)
+
+func TestServerSettings(t *testing.T) {
+	s := NewServer(WithFlowWindow(8), WithWriteTimeout(0))
+	st := s.Settings()
+	assert(t, st.FlowWindow == 8 && st.WriteTimeout == 0 && st.HandshakeTimeout == DefaultHandshakeTimeout, "initial settings %+v", st)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.UpdateSettings(func(st *ServerSettings) { st.FlowWindow++ })
+		}()
+	}
+	wg.Wait()
+	assert(t, s.Settings().FlowWindow == 108, "concurrent updates lost: %d", s.Settings().FlowWindow)
+
+	// 写期限对已经建立的连接生效
+	s.Register(new(Echo))
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	done := make(chan struct{})
+	go func() {
+		s.ServeConn(c2)
+		close(done)
+	}()
+	buf := binary.BigEndian.AppendUint32(nil, Magic)
+	c1.Write(binary.BigEndian.AppendUint32(buf, codec.GobType))
+	s.UpdateSettings(func(st *ServerSettings) { st.WriteTimeout = 20 * time.Millisecond })
+	go codec.NewGobCodec(c1).Write(&codec.Header{Name: "Echo.Say", Seq: 1}, "hello")
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("updated write timeout was not applied to the open connection")
+	}
+}
+
+func TestClientSettings(t *testing.T) {
+	s := NewServer()
+	s.Register(new(Echo))
+	client := pipeClient(t, s)
+
+	var reply string
+	client.Call("Echo.Say", "warm up", &reply) // 等服务端登记连接
+	big := strings.Repeat("compress me ", 10000)
+	read := func() int64 { return s.ConnStats()[0].BytesRead }
+	client.UpdateSettings(func(st *ClientSettings) {
+		st.Compression = 1024
+		st.CoalesceBytes = 0
+	})
+	st := client.Settings()
+	assert(t, st.Compression == 1024 && st.CoalesceBytes == 0, "settings %+v", st)
+	before := read()
+	err := client.Call("Echo.Say", big, &reply)
+	assert(t, err == nil && reply == big, "compressed call: %v", err)
+	assert(t, read()-before < int64(len(big)/10), "request not compressed: %d bytes", read()-before)
+
+	client.UpdateSettings(func(st *ClientSettings) { st.Compression = 0 })
+	before = read()
+	err = client.Call("Echo.Say", big, &reply)
+	assert(t, err == nil && read()-before > int64(len(big)), "compression should be off: %d bytes, %v", read()-before, err)
+}
+
+func TestRateLimiterSetLimit(t *testing.T) {
+	l := NewRateLimiter(1<<20, 0)
+	assert(t, l.chunk() == 1<<20/10, "initial burst %d", l.chunk())
+	l.SetLimit(1<<10, 8192)
+	assert(t, l.chunk() == 8192, "burst after SetLimit %d", l.chunk())
+	start := time.Now()
+	l.wait(8192 + 100) // 突发量用完后按1KB/s等100字节
+	assert(t, time.Since(start) >= 80*time.Millisecond, "new rate not applied, waited %v", time.Since(start))
+}
@@ -0,0 +1,148 @@
+package mrpc
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/micplus/mrpc/codec"
+)
+
+// 优雅关闭：服务端停止接受新连接，并在每条连接上发送GOAWAY控制帧，
+// 告诉客户端不要再发新请求，已发出的照常处理。客户端收到后把连接标记为draining，
+// 新调用立即返回ErrDraining，等已发出的调用都收到响应后自己关闭连接。
+// 服务端不拒绝GOAWAY之后才读到的请求，在途的请求不会因为竞争丢失。
+//
+// GOAWAY与信用帧一样是Seq为0的控制帧，旧版本的客户端会丢弃它，
+// 这样的连接只能等Shutdown的ctx结束后强制关闭
+
+// 优雅关闭的控制帧名称，没有消息体
+const goawayFrame = "mrpc.goaway"
+
+// 客户端的半关闭：CloseSend发送closesend控制帧，之后不再发请求，但照常接收响应。
+// 服务端读到它就不再读新请求(反向调用的响应除外)，写完在途请求的响应后关闭连接，
+// 客户端读到EOF时已经没有未完成的调用，两端都不会丢掉响应。
+// 旧版本的服务端把它当作找不到方法的请求回复，连接不会关闭，仍需Close
+const closeSendFrame = "mrpc.closesend"
+
+var errCloseSend = errors.New("rpc server: client closed send")
+
+// 连接收到了GOAWAY，调用没有发出，可以换一条连接重试
+var ErrDraining = errors.New("connection draining")
+
+// Shutdown轮询连接是否都已关闭的间隔
+const shutdownPollInterval = 10 * time.Millisecond
+
+// 优雅关闭：关闭Accept中的listener，通知所有连接上的客户端不再发送新请求，
+// 等它们处理完在途的请求、关闭连接后返回nil。ctx先结束时强制关闭剩下的连接，返回ctx.Err()。
+// 只等待经过Magic握手的连接，ServeCodec处理的codec(如jsonrpc)不受影响。
+// 返回时停止WithWatchdog的后台检查和WithLockedThreads的工作协程
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.inShutdown.Store(true)
+	defer s.watchdog.stop() // 排空期间的方法仍然要检查
+	defer s.stopThreads()
+	s.listeners.Range(func(lis, _ any) bool {
+		lis.(net.Listener).Close()
+		return true
+	})
+	s.goaways.Range(func(w, _ any) bool {
+		w.(*responseWriter).goaway()
+		return true
+	})
+
+	t := s.clock.NewTicker(shutdownPollInterval)
+	defer t.Stop()
+	for {
+		if s.idle() {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			s.conns.Range(func(_, conn any) bool {
+				conn.(net.Conn).Close()
+				return true
+			})
+			return ctx.Err()
+		case <-t.C():
+		}
+	}
+}
+
+// 没有正在处理的连接
+func (s *Server) idle() bool {
+	idle := true
+	s.conns.Range(func(_, _ any) bool {
+		idle = false
+		return false
+	})
+	return idle
+}
+
+// 通知客户端连接即将关闭
+func (w *responseWriter) goaway() {
+	w.write(&codec.Header{Name: goawayFrame}, invalidRequest)
+}
+
+// 计划内的更换连接：与收到GOAWAY一样进入Degraded状态，之后的调用返回ErrDraining，
+// 已发出的调用照常完成，之后关闭连接。新的调用应当已经换到了新连接上，见ReconnectingClient
+func (c *Client) Drain() {
+	if c.GetState() == Ready {
+		c.drain()
+	}
+}
+
+// 收到GOAWAY，进入Degraded状态，之后的调用返回ErrDraining，没有未完成的调用时关闭连接
+func (c *Client) drain() {
+	c.draining.Store(true)
+	c.stateChanged()
+	c.closeIfIdle()
+}
+
+// 半关闭：通知服务端不再发送请求，之后的调用返回ErrShutDown，已发出的调用照常收到响应，
+// 最后由服务端关闭连接，状态变为Shutdown
+//
+//	client.CloseSend()
+//	for state := client.GetState(); state != mrpc.Shutdown; state = client.GetState() {
+//		if !client.WaitForStateChange(ctx, state) {
+//			client.Close() // 等太久了
+//			break
+//		}
+//	}
+func (c *Client) CloseSend() error {
+	c.sending.Lock()
+	defer c.sending.Unlock()
+	if !c.IsAvailable() && !c.draining.Load() {
+		return ErrShutDown
+	}
+	// send在sending锁内addCall，之后的请求都会看到sendClosed
+	c.sendClosed.Store(true)
+	c.stateChanged()
+	if c.bw != nil {
+		c.since = time.Time{}
+		if err := c.bw.Flush(); err != nil {
+			return err
+		}
+	}
+	return c.cc.Write(&codec.Header{Name: closeSendFrame}, invalidRequest)
+}
+
+// 排空中且没有未完成的调用时关闭连接，只关闭一次。
+// addCall在分片锁内检查draining，这里逐个加锁检查，不会漏掉刚放进去的调用
+func (c *Client) closeIfIdle() {
+	if !c.draining.Load() {
+		return
+	}
+	for i := range c.pending {
+		sh := &c.pending[i]
+		sh.mu.Lock()
+		n := len(sh.calls)
+		sh.mu.Unlock()
+		if n > 0 {
+			return
+		}
+	}
+	if c.drained.CompareAndSwap(false, true) {
+		c.cc.Close() // receive读到错误后结束，与服务端断开一样
+	}
+}
@@ -0,0 +1,129 @@
+package mrpc
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+// 收到release之前不返回
+type Gate struct {
+	entered chan struct{}
+	release chan struct{}
+}
+
+func (g *Gate) Wait(_ int, reply *int) error {
+	g.entered <- struct{}{}
+	<-g.release
+	*reply = 1
+	return nil
+}
+
+func newGateServer(t *testing.T, opts ...ServerOption) (*Server, *Gate, net.Listener) {
+	g := &Gate{entered: make(chan struct{}, 1), release: make(chan struct{})}
+	s := NewServer(opts...)
+	if err := s.Register(g); err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Accept(l)
+	return s, g, l
+}
+
+func TestShutdownDrains(t *testing.T) {
+	s, g, l := newGateServer(t)
+	client, err := Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	call := client.Go("Gate.Wait", 1, new(int), nil)
+	<-g.entered
+	done := make(chan error, 1)
+	go func() { done <- s.Shutdown(context.Background()) }()
+
+	client.WaitForStateChange(context.Background(), Ready)
+	assert(t, client.GetState() == Degraded && !client.IsAvailable(), "want Degraded, got %v", client.GetState())
+	err = client.Call("Gate.Wait", 1, new(int))
+	assert(t, errors.Is(err, ErrDraining), "want ErrDraining, got %v", err)
+	select {
+	case err := <-done:
+		t.Fatalf("Shutdown returned before in-flight call finished: %v", err)
+	default:
+	}
+
+	// 在途的调用照常完成，之后客户端关闭连接，Shutdown返回
+	close(g.release)
+	<-call.Done
+	assert(t, call.Error == nil && *call.Reply.(*int) == 1, "in-flight call: %v", call.Error)
+	err = <-done
+	assert(t, err == nil, "Shutdown: %v", err)
+	_, err = net.Dial("tcp", l.Addr().String())
+	assert(t, err != nil, "listener should be closed")
+}
+
+func TestShutdownContext(t *testing.T) {
+	s, g, l := newGateServer(t)
+	defer close(g.release)
+	client, err := Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	call := client.Go("Gate.Wait", 1, new(int), nil)
+	<-g.entered
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err = s.Shutdown(ctx)
+	assert(t, err == context.DeadlineExceeded, "want DeadlineExceeded, got %v", err)
+	// 剩下的连接被强制关闭
+	<-call.Done
+	assert(t, call.Error != nil, "in-flight call should fail after forced close")
+}
+
+func TestCloseSend(t *testing.T) {
+	t.Run("plain", func(t *testing.T) { testCloseSend(t) })
+	// 开启反向调用时服务端继续读反向调用的响应，请求都处理完后再关闭
+	t.Run("reverse", func(t *testing.T) { testCloseSend(t, WithReverseRPC()) })
+}
+
+func testCloseSend(t *testing.T, opts ...ServerOption) {
+	s, g, l := newGateServer(t, opts...)
+	defer l.Close()
+	client, err := Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	call := client.Go("Gate.Wait", 1, new(int), nil)
+	<-g.entered
+	err = client.CloseSend()
+	assert(t, err == nil, "CloseSend: %v", err)
+	assert(t, client.GetState() == Closing, "want Closing, got %v", client.GetState())
+	err = client.Call("Gate.Wait", 1, new(int))
+	assert(t, err == ErrShutDown, "want ErrShutDown after CloseSend, got %v", err)
+	assert(t, client.CloseSend() == ErrShutDown, "second CloseSend should fail")
+
+	// 在途的调用收到响应后服务端关闭连接
+	close(g.release)
+	<-call.Done
+	assert(t, call.Error == nil, "in-flight call: %v", call.Error)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for state := client.GetState(); state != Shutdown; state = client.GetState() {
+		if !client.WaitForStateChange(ctx, state) {
+			t.Fatalf("connection not closed, state %v", state)
+		}
+	}
+	for len(s.ConnStats()) > 0 {
+		time.Sleep(time.Millisecond)
+	}
+}
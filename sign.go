@@ -0,0 +1,241 @@
+package mrpc
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/gob"
+	"math"
+	"reflect"
+	"slices"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// 请求签名：客户端用共享密钥对方法名、时间、元数据和参数的摘要计算HMAC-SHA256，
+// 放在元数据中发送，服务端的VerifySignatures中间件校验，拒绝没有签名、签名不符或者过期的调用。
+// 与传输无关，不加密的连接、经过代理的调用同样适用：
+//
+//	client, err := mrpc.NewClientOptions(conn, mrpc.WithRequestSigning(key))
+//	s.Register(new(Payment), mrpc.WithMiddleware(mrpc.VerifySignatures(key, time.Minute)))
+//
+// 参数的摘要按值计算而不是按编码后的字节，零值的字段与不存在的字段相同(gob不发送零值)，
+// map按键排序。用别名或不带版本调用时按客户端请求的方法名校验。服务端用WithRouter改写了方法名或元数据时签名不再相符
+const (
+	SignatureKey      = "mrpc-signature"       // 元数据中的签名，base64编码
+	SignatureTimeKey  = "mrpc-signature-time"  // 签名时的Unix时间(纳秒)
+	SignatureNonceKey = "mrpc-signature-nonce" // 每个请求不同的随机数，见RejectReplays
+)
+
+// 客户端对每个请求签名，见SignatureKey
+func WithRequestSigning(key []byte) ClientOption {
+	return func(o *clientOptions) {
+		o.signKey = key
+	}
+}
+
+// 校验请求签名的中间件，签名时间与服务端时间相差超过maxSkew的调用也被拒绝，防止重放旧请求。
+// maxSkew<=0时不检查时间。失败时返回Unauthenticated。
+// 服务端时间取自可选的clock，默认SystemClock，应与WithClock、ReplayWindow.Clock一致
+func VerifySignatures(key []byte, maxSkew time.Duration, clock ...Clock) Middleware {
+	var c Clock
+	if len(clock) > 0 {
+		c = clock[0]
+	}
+	c = clockOrSystem(c)
+	return func(ctx context.Context, method string, arg, reply any, next func(ctx context.Context) error) error {
+		md := IncomingMetadata(ctx)
+		sig, err := base64.StdEncoding.DecodeString(md[SignatureKey])
+		if err != nil || len(sig) == 0 {
+			return Errorf(Unauthenticated, "rpc server: request is not signed")
+		}
+		ts, err := strconv.ParseInt(md[SignatureTimeKey], 10, 64)
+		if err != nil {
+			return Errorf(Unauthenticated, "rpc server: invalid signature time")
+		}
+		if maxSkew > 0 {
+			if skew := c.Now().Sub(time.Unix(0, ts)); skew > maxSkew || skew < -maxSkew {
+				return Errorf(Unauthenticated, "rpc server: signature expired")
+			}
+		}
+		if !hmac.Equal(sig, signature(key, requestedMethod(ctx, method), md, arg)) {
+			return Errorf(Unauthenticated, "rpc server: invalid signature")
+		}
+		return next(ctx)
+	}
+}
+
+// 返回带签名的元数据，不修改md
+func signMetadata(key []byte, method string, md Metadata, args any, now time.Time) Metadata {
+	signed := make(Metadata, len(md)+2)
+	for k, v := range md {
+		signed[k] = v
+	}
+	delete(signed, SignatureKey)
+	var nonce [12]byte
+	rand.Read(nonce[:])
+	signed[SignatureNonceKey] = base64.RawURLEncoding.EncodeToString(nonce[:])
+	signed[SignatureTimeKey] = strconv.FormatInt(now.UnixNano(), 10)
+	signed[SignatureKey] = base64.StdEncoding.EncodeToString(signature(key, method, signed, args))
+	return signed
+}
+
+// 签名的内容：方法名、除签名以外按键排序的元数据(包括签名时间和nonce)、参数的摘要
+func signature(key []byte, method string, md Metadata, args any) []byte {
+	mac := hmac.New(sha256.New, key)
+	var lenBuf [binary.MaxVarintLen64]byte
+	writeString := func(s string) {
+		mac.Write(lenBuf[:binary.PutUvarint(lenBuf[:], uint64(len(s)))])
+		mac.Write([]byte(s))
+	}
+	writeString(method)
+	keys := make([]string, 0, len(md))
+	for k := range md {
+		if k != SignatureKey {
+			keys = append(keys, k)
+		}
+	}
+	slices.Sort(keys)
+	for _, k := range keys {
+		writeString(k)
+		writeString(md[k])
+	}
+	digest := sha256.Sum256(digestValue(nil, reflect.ValueOf(args), 0))
+	mac.Write(digest[:])
+	return mac.Sum(nil)
+}
+
+// 嵌套超过这么深的值不再展开
+const maxDigestDepth = 32
+
+var (
+	typeOfGobEncoder    = reflect.TypeFor[gob.GobEncoder]()
+	typeOfBinaryMarshal = reflect.TypeFor[encoding.BinaryMarshaler]()
+)
+
+// 参数值的规范编码，追加到b。指针和它指向的值相同，nil与零值相同，
+// 实现了GobEncoder或BinaryMarshaler的类型(如time.Time)按它们的编码，与gob一致
+func digestValue(b []byte, v reflect.Value, depth int) []byte {
+	for v.IsValid() && (v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface) {
+		if v.IsNil() {
+			return append(b, 'n')
+		}
+		v = v.Elem()
+	}
+	if !v.IsValid() {
+		return append(b, 'n')
+	}
+	if depth > maxDigestDepth {
+		return append(b, '?')
+	}
+	if data, ok := marshalBinary(v); ok {
+		b = append(b, 'b')
+		b = binary.AppendUvarint(b, uint64(len(data)))
+		return append(b, data...)
+	}
+	switch v.Kind() {
+	case reflect.Struct:
+		b = append(b, '{')
+		t := v.Type()
+		for i := 0; i < v.NumField(); i++ {
+			f := t.Field(i)
+			if !f.IsExported() || isDeepZero(v.Field(i)) {
+				continue
+			}
+			b = binary.AppendUvarint(b, uint64(len(f.Name)))
+			b = append(b, f.Name...)
+			b = digestValue(b, v.Field(i), depth+1)
+		}
+		return append(b, '}')
+	case reflect.Map:
+		entries := make([][]byte, 0, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			entry := digestValue(nil, iter.Key(), depth+1)
+			entries = append(entries, digestValue(entry, iter.Value(), depth+1))
+		}
+		sort.Slice(entries, func(i, j int) bool { return bytes.Compare(entries[i], entries[j]) < 0 })
+		b = append(b, 'm')
+		b = binary.AppendUvarint(b, uint64(len(entries)))
+		for _, e := range entries {
+			b = binary.AppendUvarint(b, uint64(len(e)))
+			b = append(b, e...)
+		}
+		return b
+	case reflect.Slice, reflect.Array:
+		b = append(b, 'l')
+		b = binary.AppendUvarint(b, uint64(v.Len()))
+		if v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8 {
+			return append(b, v.Bytes()...)
+		}
+		for i := 0; i < v.Len(); i++ {
+			b = digestValue(b, v.Index(i), depth+1)
+		}
+		return b
+	case reflect.String:
+		b = append(b, 's')
+		b = binary.AppendUvarint(b, uint64(v.Len()))
+		return append(b, v.String()...)
+	case reflect.Bool:
+		if v.Bool() {
+			return append(b, 't')
+		}
+		return append(b, 'f')
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return binary.AppendVarint(append(b, 'i'), v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return binary.AppendUvarint(append(b, 'u'), v.Uint())
+	case reflect.Float32, reflect.Float64:
+		return binary.BigEndian.AppendUint64(append(b, 'd'), math.Float64bits(v.Float()))
+	case reflect.Complex64, reflect.Complex128:
+		c := v.Complex()
+		b = binary.BigEndian.AppendUint64(append(b, 'c'), math.Float64bits(real(c)))
+		return binary.BigEndian.AppendUint64(b, math.Float64bits(imag(c)))
+	}
+	return append(b, '?') // chan、func不能传输
+}
+
+func marshalBinary(v reflect.Value) ([]byte, bool) {
+	if !v.CanInterface() {
+		return nil, false
+	}
+	if v.CanAddr() && !v.Type().Implements(typeOfGobEncoder) && !v.Type().Implements(typeOfBinaryMarshal) {
+		v = v.Addr()
+	}
+	switch m := v.Interface().(type) {
+	case gob.GobEncoder:
+		data, err := m.GobEncode()
+		return data, err == nil
+	case encoding.BinaryMarshaler:
+		data, err := m.MarshalBinary()
+		return data, err == nil
+	}
+	return nil, false
+}
+
+// 零值、nil、指向零值的指针、空的slice和map，gob都不发送
+func isDeepZero(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		return v.IsNil() || isDeepZero(v.Elem())
+	case reflect.Slice, reflect.Map:
+		return v.Len() == 0
+	case reflect.Struct:
+		if _, ok := marshalBinary(v); ok {
+			return v.IsZero()
+		}
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).IsExported() && !isDeepZero(v.Field(i)) {
+				return false
+			}
+		}
+		return true
+	}
+	return v.IsZero()
+}
@@ -0,0 +1,121 @@
+package mrpc
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type Transfer struct {
+	From, To string
+	Amount   int64
+	Note     *string
+	Tags     map[string]int
+	When     time.Time
+}
+
+func TestRequestSigning(t *testing.T) {
+	key := []byte("signing key")
+	s := NewServer()
+	HandleFunc(s, "Bank.Transfer", func(_ context.Context, tr Transfer, reply *int64) error {
+		*reply = tr.Amount
+		return nil
+	})
+	s.SetServiceOptions("Bank", WithMiddleware(VerifySignatures(key, time.Minute)))
+	note := ""
+	tr := Transfer{From: "a", To: "b", Amount: 100, Note: &note, Tags: map[string]int{"x": 1, "y": 2},
+		When: time.Date(2024, 1, 2, 3, 4, 5, 6, time.FixedZone("", 3600))}
+
+	var reply int64
+	signed := pipeClient(t, s, WithRequestSigning(key))
+	ctx := WithOutgoingMetadata(context.Background(), Metadata{"tenant": "t1"})
+	err := signed.CallContext(ctx, "Bank.Transfer", tr, &reply)
+	assert(t, err == nil && reply == 100, "signed call: reply=%d err=%v", reply, err)
+
+	err = pipeClient(t, s).Call("Bank.Transfer", tr, &reply)
+	assert(t, CodeOf(err) == Unauthenticated, "unsigned call: %v", err)
+	err = pipeClient(t, s, WithRequestSigning([]byte("other key"))).Call("Bank.Transfer", tr, &reply)
+	assert(t, CodeOf(err) == Unauthenticated && err.Error() == "rpc server: invalid signature", "wrong key: %v", err)
+
+	// 签名过期
+	old := pipeClient(t, s, WithRequestSigning(key), WithClientClock(skewedClock{SystemClock, -time.Hour}))
+	err = old.Call("Bank.Transfer", tr, &reply)
+	assert(t, CodeOf(err) == Unauthenticated && err.Error() == "rpc server: signature expired", "expired: %v", err)
+}
+
+// 时间偏差按服务端的时钟检查
+func TestSignatureClock(t *testing.T) {
+	key := []byte("signing key")
+	slow := skewedClock{SystemClock, -time.Hour}
+	s := NewServer(WithClock(slow))
+	HandleFunc(s, "Bank.Transfer", func(_ context.Context, tr Transfer, reply *int64) error {
+		*reply = tr.Amount
+		return nil
+	})
+	s.SetServiceOptions("Bank", WithMiddleware(VerifySignatures(key, time.Minute, slow)))
+	client := pipeClient(t, s, WithRequestSigning(key), WithClientClock(slow))
+
+	var reply int64
+	err := client.Call("Bank.Transfer", Transfer{Amount: 7}, &reply)
+	assert(t, err == nil && reply == 7, "same clock on both sides: reply=%d err=%v", reply, err)
+}
+
+// 篡改参数、方法名或元数据，签名都不再相符
+// 客户端对别名签名，服务端按别名校验，而不是别名指向的方法
+func TestSignedAlias(t *testing.T) {
+	key := []byte("signing key")
+	s := NewServer()
+	HandleFunc(s, "Bank.Transfer", func(_ context.Context, tr Transfer, reply *int64) error {
+		*reply = tr.Amount
+		return nil
+	})
+	s.SetServiceOptions("Bank", WithMiddleware(VerifySignatures(key, time.Minute)))
+	if err := s.Alias("Bank.Send", "Bank.Transfer"); err != nil {
+		t.Fatal(err)
+	}
+	client := pipeClient(t, s, WithRequestSigning(key))
+
+	var reply int64
+	err := client.Call("Bank.Send", Transfer{Amount: 5}, &reply)
+	assert(t, err == nil && reply == 5, "signed call through alias: reply=%d err=%v", reply, err)
+	err = client.Call("Bank.Transfer", Transfer{Amount: 6}, &reply)
+	assert(t, err == nil && reply == 6, "signed call to target: reply=%d err=%v", reply, err)
+}
+
+// 不带版本的调用由默认版本处理，服务端按客户端请求的方法名校验
+func TestSignedDefaultVersion(t *testing.T) {
+	key := []byte("signing key")
+	s := NewServer()
+	s.RegisterVersion("Calc", 2, new(CalcV2), WithMiddleware(VerifySignatures(key, time.Minute)))
+	client := pipeClient(t, s, WithRequestSigning(key))
+
+	for _, name := range []string{"Calc.Sum", "Calc.Sum@2"} {
+		var sum int
+		err := client.Call(name, []int{1, 2, 3}, &sum)
+		assert(t, err == nil && sum == 6, "signed call to %s: sum=%d err=%v", name, sum, err)
+	}
+}
+
+func TestSignatureCoversRequest(t *testing.T) {
+	key := []byte("k")
+	args := &Transfer{From: "a", Amount: 1}
+	md := signMetadata(key, "Bank.Transfer", Metadata{"tenant": "t1"}, args, time.Now())
+	sig := signature(key, "Bank.Transfer", md, Transfer{From: "a", Amount: 1, Tags: map[string]int{}})
+	assert(t, reflect.DeepEqual(sig, signature(key, "Bank.Transfer", md, args)), "pointer, empty map and nil map should not matter")
+	for name, changed := range map[string][]byte{
+		"args":   signature(key, "Bank.Transfer", md, &Transfer{From: "a", Amount: 2}),
+		"method": signature(key, "Bank.Refund", md, args),
+		"meta":   signature(key, "Bank.Transfer", Metadata{"tenant": "t2", SignatureTimeKey: md[SignatureTimeKey]}, args),
+	} {
+		assert(t, !reflect.DeepEqual(sig, changed), "changing %s kept the signature", name)
+	}
+}
+
+// 与真实时间相差d的时钟
+type skewedClock struct {
+	Clock
+	d time.Duration
+}
+
+func (c skewedClock) Now() time.Time { return c.Clock.Now().Add(c.d) }
@@ -0,0 +1,98 @@
+package mrpc
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 按TLS握手中的SNI主机名把连接交给不同的Server，一个listener可以承载多个互相隔离的租户，
+// 各自有自己注册的服务和选项(限速、流量控制、鉴权等)：
+//
+//	r := mrpc.NewSNIRouter()
+//	r.Handle("a.example.com", serverA)
+//	r.Handle("*.b.example.com", serverB)
+//	r.Default = fallback // 为nil时关闭没有匹配的连接
+//	go r.ServeTLS(lis, config)
+//
+// 各租户的证书可以在config.GetCertificate中按ClientHelloInfo.ServerName给出
+type SNIRouter struct {
+	// 没有匹配的主机名(包括客户端没有发送SNI)时使用，为nil时关闭连接
+	Default *Server
+	// TLS握手的期限，为0时使用DefaultHandshakeTimeout
+	HandshakeTimeout time.Duration
+
+	mu      sync.RWMutex // protect servers
+	servers map[string]*Server
+}
+
+func NewSNIRouter() *SNIRouter {
+	return &SNIRouter{servers: make(map[string]*Server)}
+}
+
+// 把主机名为host的连接交给s，host不区分大小写，"*.example.com"匹配example.com的下一级子域名。
+// 精确的主机名优先于通配
+func (r *SNIRouter) Handle(host string, s *Server) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.servers[strings.ToLower(host)] = s
+}
+
+// 主机名对应的Server，没有时是Default
+func (r *SNIRouter) Match(host string) *Server {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if s, ok := r.servers[host]; ok {
+		return s
+	}
+	if dot := strings.IndexByte(host, '.'); dot > 0 {
+		if s, ok := r.servers["*"+host[dot:]]; ok {
+			return s
+		}
+	}
+	return r.Default
+}
+
+// 在TLS上接受连接，握手完成后按SNI交给对应的Server，直到lis关闭
+func (r *SNIRouter) ServeTLS(lis net.Listener, config *tls.Config) {
+	lis = tls.NewListener(lis, config)
+	for {
+		conn, err := lis.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			log.Println("rpc server: listener accept error:", err)
+			continue
+		}
+		go r.serve(conn.(*tls.Conn))
+	}
+}
+
+func (r *SNIRouter) serve(conn *tls.Conn) {
+	timeout := r.HandshakeTimeout
+	if timeout <= 0 {
+		timeout = DefaultHandshakeTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := conn.HandshakeContext(ctx); err != nil {
+		log.Println("rpc server: tls handshake error:", err)
+		conn.Close()
+		return
+	}
+	host := conn.ConnectionState().ServerName
+	s := r.Match(host)
+	if s == nil {
+		log.Printf("rpc server: no server for SNI %q", host)
+		conn.Close()
+		return
+	}
+	s.ServeConn(conn)
+}
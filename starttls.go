@@ -0,0 +1,98 @@
+package mrpc
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"time"
+)
+
+// STARTTLS：在已经建立的明文连接上协商升级到TLS，用于连接之后才能决定是否加密的场景，
+// 比如要先看对端的地址、或者服务发现给出的策略。客户端在发送Magic之前发送：
+//
+//	StartTLSMagic | 升级类型(uint32大端，目前只有TLS=1)
+//
+// 服务端开启了WithStartTLS时回复StartTLSMagic，双方随即在这条连接上进行TLS握手，
+// 之后与TLS连接完全相同：客户端发送Magic和编码类型，证书中的身份同样可以由IdentityFromContext取出。
+// 服务端没有开启或者不认识升级类型时回复拒绝帧，旧版本的服务端按错误的Magic拒绝。
+// 压缩已经由WithCompression按消息协商，不需要升级连接
+//
+//	conn, _ := net.Dial("tcp", addr)
+//	if policy.Encrypt(addr) {
+//		if conn, err = mrpc.StartTLS(conn, &tls.Config{RootCAs: pool}); err != nil {
+//			return err
+//		}
+//	}
+//	client, err := mrpc.NewClientOptions(conn)
+
+// 请求升级连接的开头
+const StartTLSMagic uint32 = 0x5a2b71d4
+
+// 升级类型
+const upgradeTLS uint32 = 1
+
+// 接受明文连接上的STARTTLS，之后用config进行TLS握手。只接受STARTTLS连接时，
+// 应当用WithEncryption之外的方式(如WithAuthorizer检查IdentityFromContext)拒绝明文的调用
+func WithStartTLS(config *tls.Config) ServerOption {
+	return func(s *Server) {
+		s.startTLS = config
+	}
+}
+
+// 读到了StartTLSMagic，kind是升级类型。接受时回复后在原连接上重新开始握手，读期限沿用握手的期限
+func (s *Server) serveStartTLS(conn net.Conn, r io.Reader, kind uint32, ready func(*Client)) {
+	if s.startTLS == nil || kind != upgradeTLS {
+		log.Printf("rpc server: rejected connection upgrade %d from %v", kind, conn.RemoteAddr())
+		writeRejection(conn, rejectUpgradeUnsupported)
+		return
+	}
+	// 客户端应当等到回复再开始TLS握手，读端缓冲中不能有多读的数据
+	if b, ok := r.(*bufferedConn); ok && b.Buffered() > 0 {
+		log.Println("rpc server: unexpected data before TLS handshake from", conn.RemoteAddr())
+		return
+	}
+	if _, err := conn.Write(binary.BigEndian.AppendUint32(nil, StartTLSMagic)); err != nil {
+		log.Println("rpc server: write upgrade reply error:", err)
+		return
+	}
+	s.serveConn(tls.Server(conn, s.startTLS), ready)
+}
+
+// 请求服务端把明文连接升级为TLS，成功时返回TLS连接，之后用NewClientOptions等在其上创建客户端。
+// config没有ServerName时按对端的地址校验证书，握手最多等DefaultHandshakeTimeout。
+// 服务端拒绝时返回ErrProtocolMismatch，连接不再可用，由调用方关闭
+func StartTLS(conn net.Conn, config *tls.Config) (*tls.Conn, error) {
+	conn.SetDeadline(time.Now().Add(DefaultHandshakeTimeout))
+	defer conn.SetDeadline(time.Time{})
+	req := binary.BigEndian.AppendUint32(binary.BigEndian.AppendUint32(nil, StartTLSMagic), upgradeTLS)
+	if _, err := conn.Write(req); err != nil {
+		return nil, err
+	}
+	var head [4]byte
+	if _, err := io.ReadFull(conn, head[:]); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrProtocolMismatch, err)
+	}
+	if magic := binary.BigEndian.Uint32(head[:]); magic != StartTLSMagic {
+		// 读出拒绝帧的其余部分，报告原因
+		if err := readRejection(bufio.NewReader(io.MultiReader(bytes.NewReader(head[:]), conn))); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%w: unexpected reply %x to connection upgrade", ErrProtocolMismatch, magic)
+	}
+	if config.ServerName == "" && !config.InsecureSkipVerify { // 与tls.Dial一样按对端地址校验证书
+		if host, _, err := net.SplitHostPort(conn.RemoteAddr().String()); err == nil {
+			config = config.Clone()
+			config.ServerName = host
+		}
+	}
+	tc := tls.Client(conn, config)
+	if err := tc.Handshake(); err != nil {
+		return nil, err
+	}
+	return tc, nil
+}
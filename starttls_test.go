@@ -0,0 +1,84 @@
+package mrpc
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestStartTLS(t *testing.T) {
+	ca := newCert(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "test ca"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil)
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.Leaf)
+	serverCert := newCert(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "server"},
+		IPAddresses: []net.IP{net.IPv4(127, 0, 0, 1)},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, &ca)
+	clientCert := newCert(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "agent"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, &ca)
+
+	listen := func(opts ...ServerOption) string {
+		s := NewServer(opts...)
+		s.Register(new(Whoami))
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { lis.Close() })
+		go s.Accept(lis)
+		return lis.Addr().String()
+	}
+	addr := listen(WithStartTLS(&tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientCAs:    pool,
+		ClientAuth:   tls.VerifyClientCertIfGiven,
+	}))
+	dial := func(addr string) net.Conn {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		return conn
+	}
+
+	// 同一个服务端上，升级的连接带有客户端证书的身份，不升级的连接照常使用
+	conn, err := StartTLS(dial(addr), &tls.Config{RootCAs: pool, Certificates: []tls.Certificate{clientCert}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := NewClientOptions(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	var reply string
+	err = client.Call("Whoami.Get", 0, &reply)
+	assert(t, err == nil && reply == "agent ", "reply=%q err=%v", reply, err)
+	assert(t, client.Identity() != nil && client.Identity().CommonName == "server", "server identity %+v", client.Identity())
+
+	plain, err := NewClientOptions(dial(addr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer plain.Close()
+	reply = "unset"
+	err = plain.Call("Whoami.Get", 0, &reply)
+	assert(t, err == nil && reply == "", "plaintext call: reply=%q err=%v", reply, err)
+
+	// 没有开启STARTTLS的服务端拒绝升级
+	_, err = StartTLS(dial(listen()), &tls.Config{RootCAs: pool})
+	assert(t, errors.Is(err, ErrProtocolMismatch) && strings.Contains(err.Error(), "STARTTLS"), "want rejection, got %v", err)
+}
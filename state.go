@@ -0,0 +1,92 @@
+package mrpc
+
+import (
+	"context"
+	"strconv"
+)
+
+// 客户端连接的状态
+//
+//	for state := client.GetState(); state != mrpc.Shutdown; state = client.GetState() {
+//		client.WaitForStateChange(ctx, state)
+//	}
+type State int
+
+// Client在握手成功后才创建，没有连接中的状态，从Ready开始
+const (
+	// 可以发起调用
+	Ready State = iota
+	// 收到了服务端的GOAWAY，在途的调用照常完成，新调用返回ErrDraining，见Server.Shutdown
+	Degraded
+	// 调用了Close或CloseSend，连接正在关闭
+	Closing
+	// 连接已断开，不可恢复
+	Shutdown
+)
+
+var stateNames = [...]string{"Ready", "Degraded", "Closing", "Shutdown"}
+
+func (s State) String() string {
+	if s >= 0 && int(s) < len(stateNames) {
+		return stateNames[s]
+	}
+	return "State(" + strconv.Itoa(int(s)) + ")"
+}
+
+// 当前状态
+func (c *Client) GetState() State {
+	switch {
+	case c.shutdown.Load():
+		return Shutdown
+	case c.closing.Load() || c.sendClosed.Load():
+		return Closing
+	case c.draining.Load():
+		return Degraded
+	default:
+		return Ready
+	}
+}
+
+// 只有Ready时可以发起新调用
+func (c *Client) IsAvailable() bool {
+	return c.GetState() == Ready
+}
+
+// 检查状态，若客户端关闭、崩溃或正在排空则不可用
+//
+// Deprecated: 拼写有误，使用IsAvailable或GetState
+func (c *Client) IsAvaliable() bool {
+	return c.IsAvailable()
+}
+
+// 等到状态不再是source，返回true；ctx先结束时返回false
+func (c *Client) WaitForStateChange(ctx context.Context, source State) bool {
+	for {
+		c.stateMu.Lock()
+		if c.GetState() != source {
+			c.stateMu.Unlock()
+			return true
+		}
+		if c.stateCh == nil {
+			c.stateCh = make(chan struct{})
+		}
+		ch := c.stateCh
+		c.stateMu.Unlock()
+
+		select {
+		case <-ch:
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
+
+// 状态标志改变之后调用，唤醒WaitForStateChange
+func (c *Client) stateChanged() {
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+	if c.stateCh != nil {
+		close(c.stateCh)
+		c.stateCh = nil
+	}
+}
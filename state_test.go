@@ -0,0 +1,33 @@
+package mrpc
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestClientState(t *testing.T) {
+	client, _, err := NewClientServerPair(new(Calc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert(t, client.GetState() == Ready && client.IsAvailable(), "new client should be Ready, got %v", client.GetState())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert(t, !client.WaitForStateChange(ctx, Ready), "state should not change without Close")
+
+	changed := make(chan State, 1)
+	go func() {
+		client.WaitForStateChange(context.Background(), Ready)
+		changed <- client.GetState()
+	}()
+	client.Close()
+	state := <-changed
+	assert(t, state == Closing || state == Shutdown, "want Closing or Shutdown after Close, got %v", state)
+	for state := client.GetState(); state != Shutdown; state = client.GetState() {
+		client.WaitForStateChange(context.Background(), state)
+	}
+	assert(t, !client.IsAvailable(), "closed client should not be available")
+	assert(t, State(9).String() == "State(9)", "unexpected name %q", State(9).String())
+}
@@ -0,0 +1,218 @@
+package mrpc
+
+import (
+	"context"
+	"io"
+	"net"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// 统计钩子：客户端和服务端在连接建立/断开、请求开始/结束、收发消息时回调StatsHandler，
+// 指标、追踪等后端实现它即可接入，mrpc本身不依赖它们
+//
+//	s := mrpc.NewServer(mrpc.WithStatsHandler(h))
+//	client, err := mrpc.DialOptions("tcp", addr, mrpc.WithClientStatsHandler(h))
+//
+// 回调在收发数据的协程中同步执行，实现应当尽快返回，且要能被并发调用
+type StatsHandler interface {
+	// ctx是这次调用的ctx：客户端是CallContext传入的ctx，服务端是传给方法的ctx
+	HandleRPC(ctx context.Context, s RPCStats)
+	HandleConn(s ConnStats)
+}
+
+// 一次调用中的事件，具体类型为*Begin、*InPayload、*OutPayload、*End
+type RPCStats interface {
+	IsClient() bool
+}
+
+// 调用开始：客户端发送请求前，服务端读到请求后。
+// 各事件的Service是Method中"."之前的部分；Target是客户端连接的服务端地址，
+// 服务端和不经过net.Conn的客户端为空
+type Begin struct {
+	Client    bool
+	Method    string // "Service.Method"
+	Service   string
+	Target    string
+	Seq       uint64 // 客户端在发送前还没有分配序号，为0
+	BeginTime time.Time
+}
+
+// 读完一条消息：服务端收到请求，客户端收到响应
+type InPayload struct {
+	Client   bool
+	Method   string
+	Service  string
+	Target   string
+	Seq      uint64
+	Length   int // 消息头和消息体在连接上的字节数，codec不经过连接时为0
+	RecvTime time.Time
+}
+
+// 写完一条消息：客户端发出请求，服务端发出响应。
+// 写入可能与其它消息合并，此时还在缓冲中，未必已经写到连接
+type OutPayload struct {
+	Client   bool
+	Method   string
+	Service  string
+	Target   string
+	Seq      uint64
+	Length   int // 同InPayload
+	SentTime time.Time
+}
+
+// 调用结束，每个Begin都对应一个End
+type End struct {
+	Client    bool
+	Method    string
+	Service   string
+	Target    string
+	Seq       uint64
+	BeginTime time.Time
+	EndTime   time.Time
+	Error     error // 服务端是方法返回的错误，客户端是调用最终的错误
+	Code      Code  // CodeOf(Error)
+}
+
+// "Service.Method"中的服务名和方法名
+func splitMethod(name string) (service, method string) {
+	if dot := strings.LastIndex(name, "."); dot >= 0 {
+		return name[:dot], name[dot+1:]
+	}
+	return "", name
+}
+
+func (s *Begin) IsClient() bool      { return s.Client }
+func (s *InPayload) IsClient() bool  { return s.Client }
+func (s *OutPayload) IsClient() bool { return s.Client }
+func (s *End) IsClient() bool        { return s.Client }
+
+// 连接的事件，具体类型为*ConnBegin、*ConnEnd
+type ConnStats interface {
+	IsClient() bool
+}
+
+type ConnBegin struct {
+	Client     bool
+	LocalAddr  net.Addr // codec不经过net.Conn时为nil
+	RemoteAddr net.Addr
+}
+
+type ConnEnd struct {
+	Client          bool
+	LocalAddr       net.Addr
+	RemoteAddr      net.Addr
+	BytesRead       int64
+	BytesWritten    int64
+	MessagesRead    int64
+	MessagesWritten int64
+}
+
+func (s *ConnBegin) IsClient() bool { return s.Client }
+func (s *ConnEnd) IsClient() bool   { return s.Client }
+
+// 统计读写字节数、消息数的连接，也用于计算每条消息的大小
+type countingConn struct {
+	io.ReadWriteCloser
+	read, written       atomic.Int64
+	msgsRead, msgsWrite atomic.Int64
+	since               time.Time
+}
+
+// 读到一条消息，cn为nil时忽略
+func (cn *countingConn) countRead() {
+	if cn != nil {
+		cn.msgsRead.Add(1)
+	}
+}
+
+func (cn *countingConn) countWrite() {
+	if cn != nil {
+		cn.msgsWrite.Add(1)
+	}
+}
+
+func (c *countingConn) Read(p []byte) (int, error) {
+	n, err := c.ReadWriteCloser.Read(p)
+	c.read.Add(int64(n))
+	return n, err
+}
+
+// 读端实现了io.ByteReader时gob不再加缓冲，计数也要保留这一点
+func (c *countingConn) ReadByte() (byte, error) {
+	b, err := c.ReadWriteCloser.(io.ByteReader).ReadByte()
+	if err == nil {
+		c.read.Add(1)
+	}
+	return b, err
+}
+
+func (c *countingConn) Write(p []byte) (int, error) {
+	n, err := c.ReadWriteCloser.Write(p)
+	c.written.Add(int64(n))
+	return n, err
+}
+
+// 套上计数，读端先加缓冲，保证它实现了io.ByteReader
+func newCountingConn(rwc io.ReadWriteCloser) *countingConn {
+	if _, ok := rwc.(io.ByteReader); !ok {
+		rwc = newBufferedConn(rwc, DefaultReadBufferSize)
+	}
+	return &countingConn{ReadWriteCloser: rwc, since: time.Now()}
+}
+
+// 已写出的字节，加上codec缓冲中还没写到连接的部分
+func outBytes(cn *countingConn, bw interface{ Buffered() int }) int64 {
+	if cn == nil {
+		return 0
+	}
+	n := cn.written.Load()
+	if bw != nil {
+		n += int64(bw.Buffered())
+	}
+	return n
+}
+
+func inBytes(cn *countingConn) int64 {
+	if cn == nil {
+		return 0
+	}
+	return cn.read.Load()
+}
+
+// 一条连接的流量，见Server.ConnStats、Client.ConnStats
+type ConnInfo struct {
+	LocalAddr       net.Addr
+	RemoteAddr      net.Addr
+	Since           time.Time // 连接建立的时间
+	BytesRead       int64
+	BytesWritten    int64
+	MessagesRead    int64 // 包括流量控制等控制帧
+	MessagesWritten int64
+}
+
+func newConnInfo(conn net.Conn, cn *countingConn) ConnInfo {
+	return ConnInfo{
+		LocalAddr:       conn.LocalAddr(),
+		RemoteAddr:      conn.RemoteAddr(),
+		Since:           cn.since,
+		BytesRead:       cn.read.Load(),
+		BytesWritten:    cn.written.Load(),
+		MessagesRead:    cn.msgsRead.Load(),
+		MessagesWritten: cn.msgsWrite.Load(),
+	}
+}
+
+// 与这个连接的ConnInfo一致的ConnEnd
+func (ci ConnInfo) end(client bool) *ConnEnd {
+	return &ConnEnd{
+		Client:          client,
+		LocalAddr:       ci.LocalAddr,
+		RemoteAddr:      ci.RemoteAddr,
+		BytesRead:       ci.BytesRead,
+		BytesWritten:    ci.BytesWritten,
+		MessagesRead:    ci.MessagesRead,
+		MessagesWritten: ci.MessagesWritten,
+	}
+}
@@ -0,0 +1,104 @@
+package mrpc
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 按调用记录事件，不同调用的事件之间没有确定的顺序
+type recordingStats struct {
+	mu    sync.Mutex
+	conn  []string
+	calls map[string][]string
+	sizes map[string]int
+	tags  map[string]string // End的service、target和code
+}
+
+func newRecordingStats() *recordingStats {
+	return &recordingStats{calls: map[string][]string{}, sizes: map[string]int{}, tags: map[string]string{}}
+}
+
+func (r *recordingStats) HandleRPC(_ context.Context, s RPCStats) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	switch s := s.(type) {
+	case *Begin:
+		r.calls[s.Method] = append(r.calls[s.Method], "begin")
+	case *InPayload:
+		r.calls[s.Method] = append(r.calls[s.Method], "in")
+		r.sizes[s.Method+" in"] = s.Length
+	case *OutPayload:
+		r.calls[s.Method] = append(r.calls[s.Method], "out")
+		r.sizes[s.Method+" out"] = s.Length
+	case *End:
+		r.calls[s.Method] = append(r.calls[s.Method], fmt.Sprintf("end %v", s.Error))
+		r.tags[s.Method] = fmt.Sprintf("%s|%s|%v", s.Service, s.Target, s.Code)
+	}
+}
+
+func (r *recordingStats) HandleConn(s ConnStats) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	switch s := s.(type) {
+	case *ConnBegin:
+		r.conn = append(r.conn, "begin")
+	case *ConnEnd:
+		r.conn = append(r.conn, fmt.Sprintf("end %v", s.BytesRead > 0 && s.BytesWritten > 0))
+	}
+}
+
+func (r *recordingStats) String() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return fmt.Sprint(r.conn, r.calls["Calc.Sum"], r.calls["Calc.Missing"])
+}
+
+func TestStatsHandler(t *testing.T) {
+	sh, ch := newRecordingStats(), newRecordingStats()
+	s := NewServer(WithStatsHandler(sh))
+	s.Register(new(Calc))
+	c1, c2 := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		s.ServeConn(c2)
+		close(done)
+	}()
+	client, err := NewClientOptions(c1, WithClientStatsHandler(ch))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sum int
+	err = client.Call("Calc.Sum", Pair{1, 2}, &sum)
+	assert(t, err == nil && sum == 3, "Calc.Sum = %d, %v", sum, err)
+	err = client.Call("Calc.Missing", Pair{}, &sum)
+	assert(t, err != nil, "Calc.Missing should fail")
+	client.Close()
+	<-done
+	time.Sleep(10 * time.Millisecond) // 等客户端的receive退出
+
+	const missing = "end rpc server: cannot find method Missing on service Calc"
+	want := fmt.Sprint([]string{"begin", "end true"},
+		[]string{"begin", "out", "in", "end <nil>"},
+		[]string{"begin", "out", "in", missing})
+	assert(t, ch.String() == want, "client events:\n%s\nwant\n%s", ch, want)
+	want = fmt.Sprint([]string{"begin", "end true"},
+		[]string{"begin", "in", "out", "end <nil>"},
+		[]string{"begin", "in", "out", missing})
+	assert(t, sh.String() == want, "server events:\n%s\nwant\n%s", sh, want)
+
+	// 客户端带上服务端地址，服务端没有
+	assert(t, ch.tags["Calc.Sum"] == "Calc|pipe|OK", "client tags %q", ch.tags["Calc.Sum"])
+	assert(t, ch.tags["Calc.Missing"] == "Calc|pipe|Unknown", "client tags %q", ch.tags["Calc.Missing"])
+	assert(t, sh.tags["Calc.Sum"] == "Calc||OK", "server tags %q", sh.tags["Calc.Sum"])
+
+	// 两端看到的同一条消息大小一致
+	for _, m := range []string{"Calc.Sum", "Calc.Missing"} {
+		req, resp := ch.sizes[m+" out"], ch.sizes[m+" in"]
+		assert(t, req > 0 && req == sh.sizes[m+" in"], "%s request size: client %d server %d", m, req, sh.sizes[m+" in"])
+		assert(t, resp > 0 && resp == sh.sizes[m+" out"], "%s response size: client %d server %d", m, resp, sh.sizes[m+" out"])
+	}
+}
@@ -0,0 +1,104 @@
+package mrpc
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// 单个方法的选项，在HandleFunc注册时传入，或者注册后用SetMethodOptions设置
+type MethodOption func(*methodType)
+
+// 方法在workers个锁定了OS线程的协程中执行，用于要求在固定线程上调用的cgo库、
+// GPU驱动等。workers为1时所有调用都在同一个线程上依次执行。
+// 工作协程都忙时调用排队，排队期间ctx结束就不再执行。Shutdown返回时工作协程退出，
+// 之后的调用返回Unavailable
+//
+//	mrpc.HandleFunc(s, "GPU.Infer", infer, mrpc.WithLockedThreads(1))
+func WithLockedThreads(workers int) MethodOption {
+	return func(mt *methodType) {
+		mt.threads = newLockedThreads(max(workers, 1))
+	}
+}
+
+// 设置已注册方法的选项，name形如"Service.Method"，应当在开始服务之前调用
+//
+//	s.Register(new(Render))
+//	s.SetMethodOptions("Render.Frame", mrpc.WithLockedThreads(1))
+func (s *Server) SetMethodOptions(name string, opts ...MethodOption) error {
+	dot := strings.LastIndex(name, ".")
+	if dot < 0 {
+		return errors.New("rpc server: method name must be like \"Service.Method\"")
+	}
+	svc, ok := s.serviceMap[name[:dot]]
+	if !ok {
+		return errors.New("rpc server: cannot find service " + name[:dot])
+	}
+	mt, ok := svc.method[name[dot+1:]]
+	if !ok {
+		return errors.New("rpc server: cannot find method " + name)
+	}
+	for _, opt := range opts {
+		opt(mt)
+	}
+	return nil
+}
+
+// 锁定了线程的工作协程，从tasks中取调用执行
+type lockedThreads struct {
+	workers  int
+	tasks    chan func()
+	stopOnce sync.Once
+	done     chan struct{} // stop时关闭，工作协程退出
+	exited   sync.WaitGroup
+}
+
+func newLockedThreads(workers int) *lockedThreads {
+	t := &lockedThreads{workers: workers, tasks: make(chan func()), done: make(chan struct{})}
+	t.exited.Add(workers)
+	for range workers {
+		go func() {
+			defer t.exited.Done()
+			// 不解锁，协程一直占着这个线程，退出时线程也随之结束
+			runtime.LockOSThread()
+			for {
+				select {
+				case task := <-t.tasks:
+					task()
+				case <-t.done:
+					return
+				}
+			}
+		}()
+	}
+	return t
+}
+
+// 在工作协程中执行fn并等待它返回
+func (t *lockedThreads) run(ctx context.Context, fn func() error) error {
+	done := make(chan error, 1)
+	select {
+	case t.tasks <- func() { done <- fn() }:
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.done:
+		return Errorf(Unavailable, "rpc server: server is shut down")
+	}
+	return <-done
+}
+
+// 让工作协程退出，正在执行的调用完成后退出
+func (t *lockedThreads) stop() {
+	t.stopOnce.Do(func() { close(t.done) })
+}
+
+// 停止所有方法的工作协程，见Shutdown
+func (s *Server) stopThreads() {
+	s.rangeMethods(func(_ string, mt *methodType) {
+		if mt.threads != nil {
+			mt.threads.stop()
+		}
+	})
+}
@@ -0,0 +1,89 @@
+package mrpc
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type Device struct {
+	running, peak atomic.Int32
+	release       chan struct{}
+}
+
+func (d *Device) Run(_ int, reply *int) error {
+	n := d.running.Add(1)
+	defer d.running.Add(-1)
+	for p := d.peak.Load(); n > p && !d.peak.CompareAndSwap(p, n); p = d.peak.Load() {
+	}
+	<-d.release
+	*reply = 1
+	return nil
+}
+
+func TestLockedThreads(t *testing.T) {
+	d := &Device{release: make(chan struct{})}
+	s := NewServer()
+	if err := s.Register(d); err != nil {
+		t.Fatal(err)
+	}
+	err := s.SetMethodOptions("Device.Run", WithLockedThreads(2))
+	assert(t, err == nil, "SetMethodOptions: %v", err)
+	err = s.SetMethodOptions("Device.Nope", WithLockedThreads(2))
+	assert(t, err != nil, "unknown method should fail")
+	client, err := ConnectInProcess(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var n int
+			if err := client.Call("Device.Run", 0, &n); err != nil || n != 1 {
+				t.Errorf("Call: %v %d", err, n)
+			}
+		}()
+	}
+	for d.running.Load() < 2 {
+		time.Sleep(time.Millisecond)
+	}
+	// 两个工作协程都忙，排队的调用到期后不再执行
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err = client.CallContext(ctx, "Device.Run", 0, new(int))
+	assert(t, errors.Is(err, context.DeadlineExceeded), "queued call: want DeadlineExceeded, got %v", err)
+
+	close(d.release)
+	wg.Wait()
+	assert(t, d.peak.Load() == 2, "peak concurrency %d, want 2", d.peak.Load())
+}
+
+func TestLockedThreadsStopOnShutdown(t *testing.T) {
+	s := NewServer()
+	HandleFunc(s, "GPU.Infer", func(_ context.Context, n int, reply *int) error {
+		*reply = n
+		return nil
+	}, WithLockedThreads(2))
+	threads := s.serviceMap["GPU"].method["Infer"].threads
+	assert(t, s.Shutdown(context.Background()) == nil, "Shutdown failed")
+
+	exited := make(chan struct{})
+	go func() {
+		threads.exited.Wait()
+		close(exited)
+	}()
+	select {
+	case <-exited:
+	case <-time.After(time.Second):
+		t.Fatal("worker goroutines still running after Shutdown")
+	}
+	err := threads.run(context.Background(), func() error { return nil })
+	assert(t, CodeOf(err) == Unavailable, "call after Shutdown: want Unavailable, got %v", err)
+}
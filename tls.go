@@ -0,0 +1,110 @@
+package mrpc
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"log"
+	"net"
+	"strings"
+)
+
+// TLS与双向认证(mTLS)：服务端要求并校验客户端证书时，证书中的身份放进方法的ctx，
+// 由IdentityFromContext取出，也可以在WithAuthorizer设置的钩子中统一鉴权
+//
+//	config := &tls.Config{
+//		Certificates: []tls.Certificate{cert},
+//		ClientCAs:    pool,
+//		ClientAuth:   tls.RequireAndVerifyClientCert,
+//	}
+//	go server.ServeTLS(lis, config)
+//
+//	client, err := mrpc.DialTLS("tcp", addr, &tls.Config{RootCAs: pool, Certificates: []tls.Certificate{clientCert}})
+
+// 对端证书中的身份，只有校验通过的证书才有
+type Identity struct {
+	CommonName  string
+	DNSNames    []string
+	URIs        []string
+	SPIFFEID    string // 第一个spiffe://开头的URI SAN，没有时为空
+	Certificate *x509.Certificate
+}
+
+func newIdentity(cert *x509.Certificate) *Identity {
+	id := &Identity{
+		CommonName:  cert.Subject.CommonName,
+		DNSNames:    cert.DNSNames,
+		Certificate: cert,
+	}
+	for _, u := range cert.URIs {
+		id.URIs = append(id.URIs, u.String())
+		if id.SPIFFEID == "" && strings.EqualFold(u.Scheme, "spiffe") {
+			id.SPIFFEID = u.String()
+		}
+	}
+	return id
+}
+
+// 连接对端经过校验的身份，不是TLS连接或对端没有出示证书时为nil
+func connIdentity(conn net.Conn) *Identity {
+	tc, ok := conn.(*tls.Conn)
+	if !ok {
+		return nil
+	}
+	state := tc.ConnectionState()
+	if len(state.VerifiedChains) == 0 || len(state.PeerCertificates) == 0 {
+		return nil
+	}
+	return newIdentity(state.PeerCertificates[0])
+}
+
+type identityKey struct{}
+
+// 方法的ctx中客户端证书的身份，客户端没有经过校验的证书时为nil
+func IdentityFromContext(ctx context.Context) *Identity {
+	id, _ := ctx.Value(identityKey{}).(*Identity)
+	return id
+}
+
+// 服务端证书的身份，不是TLS连接时为nil
+func (c *Client) Identity() *Identity {
+	if c.conn == nil {
+		return nil
+	}
+	return connIdentity(c.conn)
+}
+
+// 在TLS上接受连接，config.ClientAuth为tls.RequireAndVerifyClientCert时是双向认证
+func (s *Server) ServeTLS(lis net.Listener, config *tls.Config) {
+	s.Accept(tls.NewListener(lis, config))
+}
+
+// 通过TLS连接服务端，双向认证时在config.Certificates中提供客户端证书
+func DialTLS(network, address string, config *tls.Config, opts ...ClientOption) (*Client, error) {
+	conn, err := tls.Dial(network, address, config)
+	if err != nil {
+		log.Println("rpc client: dial error:", err)
+		return nil, err
+	}
+	client, err := NewClientOptions(conn, opts...)
+	if err != nil {
+		conn.Close()
+		log.Println("rpc client: create client error:", err)
+		return nil, err
+	}
+	return client, nil
+}
+
+// 调用方法前的鉴权，返回错误时不调用方法，错误不是*Error时按PermissionDenied返回
+func WithAuthorizer(authorize func(ctx context.Context, method string) error) ServerOption {
+	return func(s *Server) {
+		s.authorize = authorize
+	}
+}
+
+func authorizeError(err error) error {
+	if _, ok := err.(*Error); ok {
+		return err
+	}
+	return Errorf(PermissionDenied, "%v", err)
+}
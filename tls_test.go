@@ -0,0 +1,173 @@
+package mrpc
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// 用ca签发证书，ca为nil时自签
+func newCert(t *testing.T, tmpl *x509.Certificate, ca *tls.Certificate) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl.SerialNumber = big.NewInt(time.Now().UnixNano())
+	tmpl.NotBefore, tmpl.NotAfter = time.Now().Add(-time.Hour), time.Now().Add(time.Hour)
+	parent, signer := tmpl, any(key)
+	if ca != nil {
+		parent, signer = ca.Leaf, ca.PrivateKey
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+type Whoami int
+
+func (*Whoami) Get(ctx context.Context, _ int, reply *string) error {
+	if id := IdentityFromContext(ctx); id != nil {
+		*reply = id.CommonName + " " + id.SPIFFEID
+	}
+	return nil
+}
+
+func (*Whoami) Admin(context.Context, int, *string) error {
+	return nil
+}
+
+func TestMutualTLS(t *testing.T) {
+	ca := newCert(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "test ca"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil)
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.Leaf)
+	serverCert := newCert(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "server"},
+		DNSNames:    []string{"localhost"},
+		IPAddresses: []net.IP{net.IPv4(127, 0, 0, 1)},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, &ca)
+	spiffe, _ := url.Parse("spiffe://example.org/agent")
+	clientCert := newCert(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "agent"},
+		URIs:        []*url.URL{spiffe},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, &ca)
+
+	s := NewServer(WithAuthorizer(func(ctx context.Context, method string) error {
+		if method == "Whoami.Admin" && IdentityFromContext(ctx).CommonName != "admin" {
+			return errors.New("admin only")
+		}
+		return nil
+	}))
+	s.Register(new(Whoami))
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+	go s.ServeTLS(lis, &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	})
+
+	client, err := DialTLS("tcp", lis.Addr().String(), &tls.Config{
+		RootCAs:      pool,
+		Certificates: []tls.Certificate{clientCert},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	var reply string
+	err = client.Call("Whoami.Get", 0, &reply)
+	assert(t, err == nil && reply == "agent spiffe://example.org/agent", "reply=%q err=%v", reply, err)
+	assert(t, client.Identity() != nil && client.Identity().CommonName == "server", "server identity %+v", client.Identity())
+	err = client.Call("Whoami.Admin", 0, &reply)
+	assert(t, CodeOf(err) == PermissionDenied && err.Error() == "admin only", "want PermissionDenied, got %v", err)
+
+	// 没有客户端证书时握手失败
+	noCert, err := DialTLS("tcp", lis.Addr().String(), &tls.Config{RootCAs: pool})
+	if err == nil {
+		err = noCert.Call("Whoami.Get", 0, &reply)
+		noCert.Close()
+	}
+	assert(t, err != nil, "client without certificate should be rejected")
+}
+
+func TestSNIRouter(t *testing.T) {
+	cert := newCert(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "tenants"},
+		DNSNames:    []string{"a.example.com", "*.b.example.com", "c.example.com"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, nil)
+	pool := x509.NewCertPool()
+	pool.AddCert(cert.Leaf)
+
+	a, b := NewServer(), NewServer()
+	a.Register(new(Calc))
+	b.Register(new(Echo))
+	r := NewSNIRouter()
+	r.Handle("A.example.com", a)
+	r.Handle("*.b.example.com", b)
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+	go r.ServeTLS(lis, &tls.Config{Certificates: []tls.Certificate{cert}})
+
+	dial := func(host string) (*Client, error) {
+		return DialTLS("tcp", lis.Addr().String(), &tls.Config{RootCAs: pool, ServerName: host})
+	}
+	ca, err := dial("a.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ca.Close()
+	var sum int
+	err = ca.Call("Calc.Sum", Pair{1, 2}, &sum)
+	assert(t, err == nil && sum == 3, "tenant a: sum=%d err=%v", sum, err)
+	err = ca.Call("Echo.Say", "hi", new(string))
+	assert(t, err != nil, "tenant a should not see tenant b's services")
+
+	cb, err := dial("x.b.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cb.Close()
+	var reply string
+	err = cb.Call("Echo.Say", "hi", &reply)
+	assert(t, err == nil && reply == "hi", "tenant b: reply=%q err=%v", reply, err)
+
+	// 没有匹配的租户，也没有Default
+	cc, err := dial("c.example.com")
+	if err == nil {
+		err = cc.Call("Calc.Sum", Pair{1, 2}, &sum)
+		cc.Close()
+	}
+	assert(t, err != nil, "unknown tenant should be rejected")
+	assert(t, r.Match("b.example.com") == nil && r.Match("y.b.example.com.") == b, "wildcard matches one level")
+}
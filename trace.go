@@ -0,0 +1,90 @@
+package mrpc
+
+import (
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net"
+
+	"github.com/micplus/mrpc/codec"
+)
+
+// 帧转储：排查codec和协议问题时，把连接上收发的每一帧(头部字段、大小、截断的消息体)写到日志。
+// 运行时随时开关，只影响之后的帧
+//
+//	client.SetTrace(log.Default())
+//	server.SetTrace(log.Default(), func(addr net.Addr) bool { return addr.String() == suspect })
+type tracer struct {
+	l     *log.Logger
+	match func(remote net.Addr) bool // 为nil时匹配所有连接
+}
+
+// 消息体最多转储的字节数
+const maxTraceBody = 128
+
+func (t *tracer) enabled(remote net.Addr) bool {
+	return t != nil && (t.match == nil || t.match(remote))
+}
+
+// dir是"send"或"recv"，size为0时表示大小未知
+func (t *tracer) frame(remote net.Addr, dir string, h *codec.Header, size int, body any) {
+	addr := "-"
+	if remote != nil {
+		addr = remote.String()
+	}
+	t.l.Printf("rpc trace: %s %s seq=%d name=%q error=%q meta=%v flags=%#x size=%d body=%s",
+		addr, dir, h.Seq, h.Name, h.Error, h.Meta, h.Flags, size, traceBody(body))
+}
+
+// 原始字节按十六进制转储，其余按%+v，都截断到maxTraceBody
+func traceBody(body any) string {
+	var raw []byte
+	switch b := body.(type) {
+	case nil:
+		return "-"
+	case []byte:
+		raw = b
+	case *[]byte:
+		raw = *b
+	case codec.RawMessage:
+		raw = b
+	case *codec.RawMessage:
+		raw = *b
+	default:
+		s := fmt.Sprintf("%+v", body)
+		if len(s) > maxTraceBody {
+			return fmt.Sprintf("%s...(%d bytes)", s[:maxTraceBody], len(s))
+		}
+		return s
+	}
+	if len(raw) > maxTraceBody/2 {
+		return fmt.Sprintf("%s...(%d bytes)", hex.EncodeToString(raw[:maxTraceBody/2]), len(raw))
+	}
+	return hex.EncodeToString(raw)
+}
+
+// 转储匹配的连接上的帧，l为nil时关闭。match为nil时匹配所有连接，
+// 它在每一帧上调用，返回值可以随时变化
+func (s *Server) SetTrace(l *log.Logger, match func(remote net.Addr) bool) {
+	if l == nil {
+		s.trace.Store(nil)
+		return
+	}
+	s.trace.Store(&tracer{l: l, match: match})
+}
+
+// 转储这个客户端连接上的帧，l为nil时关闭
+func (c *Client) SetTrace(l *log.Logger) {
+	if l == nil {
+		c.trace.Store(nil)
+		return
+	}
+	c.trace.Store(&tracer{l: l})
+}
+
+func (c *Client) remoteAddr() net.Addr {
+	if c.conn == nil {
+		return nil
+	}
+	return c.conn.RemoteAddr()
+}
@@ -0,0 +1,64 @@
+package mrpc
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestTrace(t *testing.T) {
+	s := NewServer()
+	s.Register(new(Calc))
+	s.Register(new(Blob))
+	var serverLog, clientLog bytes.Buffer
+	s.SetTrace(log.New(&serverLog, "", 0), func(net.Addr) bool { return true })
+	c1, c2 := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		s.ServeConn(c2)
+		close(done)
+	}()
+	client, err := NewClient(c1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var sum int
+	client.Call("Calc.Sum", Pair{1, 2}, &sum) // 关闭时不转储
+	client.SetTrace(log.New(&clientLog, "", 0))
+	client.Call("Calc.Sum", Pair{3, 4}, &sum)
+	var upper []byte
+	client.Call("Blob.Upper", []byte("ab"), &upper)
+	client.SetTrace(nil)
+	client.Call("Calc.Sum", Pair{5, 6}, &sum)
+	client.Close()
+	<-done
+
+	seq := fmt.Sprintf("seq=%d ", client.seq.Load()&^seqCounterMask|2)
+	lines := strings.Split(strings.TrimSpace(clientLog.String()), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("want 4 client frames, got:\n%s", clientLog.String())
+	}
+	assert(t, strings.Contains(lines[0], "send "+seq+"name=\"Calc.Sum\"") && strings.Contains(lines[0], "body={A:3 B:4}"),
+		"unexpected request frame %q", lines[0])
+	assert(t, strings.Contains(lines[1], "recv "+seq) && !strings.Contains(lines[1], "size=0"),
+		"unexpected response frame %q", lines[1])
+	assert(t, strings.Contains(lines[2], "flags=0x1") && strings.Contains(lines[2], "body=6162"),
+		"raw body should be dumped as hex: %q", lines[2])
+	assert(t, strings.Contains(lines[3], "body=4142"), "raw reply should be dumped as hex: %q", lines[3])
+
+	// 服务端：窗口未开启，没有控制帧，每个请求各一收一发
+	n := strings.Count(serverLog.String(), "\n")
+	assert(t, n == 8, "want 8 server frames, got:\n%s", serverLog.String())
+}
+
+func TestTraceBody(t *testing.T) {
+	long := strings.Repeat("x", 2*maxTraceBody)
+	got := traceBody(long)
+	assert(t, strings.HasSuffix(got, "...(256 bytes)") && len(got) < 2*maxTraceBody, "got %q", got)
+	assert(t, traceBody(nil) == "-", "nil body")
+	assert(t, traceBody(RawMessage{1, 2}) == "0102", "raw body")
+}
@@ -0,0 +1,108 @@
+package mrpc
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"sync"
+
+	"github.com/micplus/mrpc/codec"
+)
+
+// 尾部元数据：方法在写出结果之后才知道的信息(最终状态、校验和等)，
+// 紧跟在响应之后单独发送一帧，Seq与响应相同。响应头带FlagTrailer，
+// 客户端收到这样的响应会等到尾部帧再结束调用，从Call.Trailer读取。
+// 旧版本的客户端把尾部帧当作无主的响应丢弃
+
+// 尾部帧的名称，没有消息体
+const trailerFrame = "mrpc.trailer"
+
+var errNoTrailer = errors.New("rpc server: SetTrailer called outside a method with context")
+
+// 方法收到的ctx的根，收集SetTrailer设置的元数据。
+// 只为带ctx参数的方法创建，不接收ctx的方法不多一次分配
+type trailerCtx struct {
+	context.Context
+
+	mu sync.Mutex // protect md
+	md Metadata
+}
+
+type trailerKey struct{}
+
+func (tc *trailerCtx) Value(key any) any {
+	if key == (trailerKey{}) {
+		return tc
+	}
+	return tc.Context.Value(key)
+}
+
+// 取出设置的元数据，tc可以为nil
+func (tc *trailerCtx) metadata() Metadata {
+	if tc == nil {
+		return nil
+	}
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	return tc.md
+}
+
+// 在方法中设置响应的尾部元数据，多次调用时合并，只在方法返回前有效。
+// 返回错误时应答照常发送，只是没有尾部
+func SetTrailer(ctx context.Context, md Metadata) error {
+	tc, ok := ctx.Value(trailerKey{}).(*trailerCtx)
+	if !ok {
+		return errNoTrailer
+	}
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	if tc.md == nil {
+		tc.md = make(Metadata, len(md))
+	}
+	maps.Copy(tc.md, md)
+	return nil
+}
+
+// 写响应，方法设置了尾部元数据时紧接着写尾部帧，返回两帧的大小。
+// ServeCodec处理的codec(如jsonrpc)不认识尾部帧，不发送
+func (w *responseWriter) writeResponse(h *codec.Header, body any, tc *trailerCtx) int {
+	h.Flags &^= codec.FlagTrailer
+	md := tc.metadata()
+	if len(md) == 0 || !w.frames {
+		return w.write(h, body)
+	}
+	h.Flags |= codec.FlagTrailer
+	n := w.write(h, body)
+	return n + w.write(&codec.Header{Seq: h.Seq, Name: trailerFrame, Meta: md}, invalidRequest)
+}
+
+// 响应之后还有尾部帧时把call放回pending等它，否则结束调用
+func (c *Client) complete(h *codec.Header, call *Call) {
+	if h.Flags&codec.FlagTrailer == 0 || !c.awaitTrailer(call) {
+		c.finish(call)
+	}
+}
+
+// 与addCall一样在分片锁内检查状态，已经关闭时直接结束，不等尾部
+func (c *Client) awaitTrailer(call *Call) bool {
+	sh := c.shard(call.Seq)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	if c.closing.Load() || c.shutdown.Load() {
+		return false
+	}
+	sh.calls[call.Seq] = call
+	return true
+}
+
+// 收到尾部帧，结束等待它的调用
+func (c *Client) handleTrailer(h *codec.Header, read int64) error {
+	err := c.cc.ReadBody(nil)
+	c.traceRecv(h, read, nil)
+	clientBytesRead.Add(inBytes(c.cn) - read)
+	if call := c.removeCall(h.Seq); call != nil {
+		call.Trailer = h.Meta
+		c.finish(call)
+	}
+	return err
+}
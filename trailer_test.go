@@ -0,0 +1,45 @@
+package mrpc
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+)
+
+func TestTrailer(t *testing.T) {
+	s := NewServer()
+	s.Register(new(Calc))
+	HandleFunc(s, "Sum.Checked", func(ctx context.Context, p Pair, reply *int) error {
+		*reply = p.A + p.B
+		SetTrailer(ctx, Metadata{"checksum": strconv.Itoa(*reply % 7)})
+		if *reply < 0 {
+			SetTrailer(ctx, Metadata{"status": "negative"})
+			return errors.New("negative sum")
+		}
+		return nil
+	})
+	client, err := ConnectInProcess(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	var sum int
+	call := <-client.Go("Sum.Checked", Pair{4, 5}, &sum, nil).Done
+	assert(t, call.Error == nil && sum == 9, "call: got %d %v", sum, call.Error)
+	assert(t, call.Trailer["checksum"] == "2", "unexpected trailer %v", call.Trailer)
+
+	// 出错时尾部照常送达
+	call = <-client.Go("Sum.Checked", Pair{-4, 1}, &sum, nil).Done
+	assert(t, call.Error != nil && call.Trailer["status"] == "negative" && call.Trailer["checksum"] == "-3",
+		"error call: got %v trailer %v", call.Error, call.Trailer)
+
+	call = <-client.Go("Calc.Sum", Pair{1, 2}, &sum, nil).Done
+	assert(t, call.Error == nil && call.Trailer == nil, "no trailer expected, got %v %v", call.Trailer, call.Error)
+	// 尾部帧不会被当作下一个调用的响应
+	err = client.Call("Sum.Checked", Pair{1, 1}, &sum)
+	assert(t, err == nil && sum == 2, "sync call: got %d %v", sum, err)
+
+	assert(t, SetTrailer(context.Background(), Metadata{"a": "b"}) != nil, "SetTrailer outside a method should fail")
+}
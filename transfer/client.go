@@ -0,0 +1,196 @@
+package transfer
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/micplus/mrpc"
+)
+
+// 发起调用的客户端，*mrpc.Client、*xclient.XClient都实现了它。
+// XClient在连接断开后会重新连接，配合重试可以自动续传
+type Caller interface {
+	CallContext(ctx context.Context, name string, args, reply any) error
+}
+
+// 默认的块大小
+const DefaultChunkSize = 256 << 10
+
+type options struct {
+	service   string
+	chunkSize int
+	retries   int
+}
+
+type Option func(*options)
+
+// 服务注册的名称，默认"Transfer"
+func WithServiceName(name string) Option {
+	return func(o *options) {
+		o.service = name
+	}
+}
+
+// 每块的大小，默认DefaultChunkSize，不能超过服务端一次Read的上限4MB
+func WithChunkSize(n int) Option {
+	return func(o *options) {
+		o.chunkSize = min(max(n, 1), maxReadLength)
+	}
+}
+
+// 连接出错或断点不一致时重新查询断点继续的次数，默认3
+func WithRetries(n int) Option {
+	return func(o *options) {
+		o.retries = n
+	}
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{service: "Transfer", chunkSize: DefaultChunkSize, retries: 3}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+func (o *options) method(name string) string {
+	return o.service + "." + name
+}
+
+// 可以从断点继续的错误：连接层面的错误(不是服务端返回的)，或者断点不一致
+func retryable(err error) bool {
+	var e *mrpc.Error
+	if errors.As(err, &e) {
+		return e.Code == mrpc.FailedPrecondition || e.Code == mrpc.Unavailable
+	}
+	var se mrpc.ServerError
+	return !errors.As(err, &se) && !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+}
+
+// 把src的前size个字节上传为name。服务端已有同名的未完成上传且内容与src的开头一致时从断点继续，
+// 否则从头开始；已经完成的同名文件内容一致时直接返回，不一致时返回AlreadyExists
+func Upload(ctx context.Context, c Caller, name string, src io.ReaderAt, size int64, opts ...Option) (*FileInfo, error) {
+	o := newOptions(opts)
+	sum, err := hashRange(src, size)
+	if err != nil {
+		return nil, err
+	}
+	for attempt := 0; ; attempt++ {
+		info, err := upload(ctx, c, o, name, src, size, sum)
+		if err == nil || attempt >= o.retries || !retryable(err) {
+			return info, err
+		}
+	}
+}
+
+func upload(ctx context.Context, c Caller, o *options, name string, src io.ReaderAt, size int64, sum []byte) (*FileInfo, error) {
+	var info FileInfo
+	if err := c.CallContext(ctx, o.method("Stat"), name, &info); err != nil {
+		return nil, err
+	}
+	if info.Complete {
+		if info.Size == size && bytes.Equal(info.SHA256, sum) {
+			return &info, nil
+		}
+		return nil, mrpc.Errorf(mrpc.AlreadyExists, "rpc transfer: %s already exists with different content", name)
+	}
+	offset := info.Size
+	if offset > size {
+		offset = 0
+	} else if offset > 0 { // 已上传的部分与本地不一致时从头开始
+		prefix, err := hashRange(src, offset)
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(prefix, info.SHA256) {
+			offset = 0
+		}
+	}
+
+	buf := make([]byte, o.chunkSize)
+	// 至少写一块，空文件也要先建出未完成的部分才能Commit
+	for first := true; first || offset < size; first = false {
+		n, err := src.ReadAt(buf[:min(int64(len(buf)), size-offset)], offset)
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		chunk := &Chunk{Name: name, Offset: offset, Data: buf[:n], CRC32: checksum(buf[:n])}
+		var ack Ack
+		if err := c.CallContext(ctx, o.method("Write"), chunk, &ack); err != nil {
+			return nil, err
+		}
+		if ack.Offset != offset+int64(n) {
+			return nil, fmt.Errorf("rpc transfer: unexpected ack %d for chunk at %d", ack.Offset, offset)
+		}
+		offset = ack.Offset
+	}
+	err := c.CallContext(ctx, o.method("Commit"), &Commit{Name: name, Size: size, SHA256: sum}, &info)
+	return &info, err
+}
+
+// 目标文件，续传时要读回已下载的部分计算哈希
+type File interface {
+	io.ReaderAt
+	io.WriterAt
+}
+
+// 下载已完成的name到dst，dst中已有前offset个字节(之前下载的部分)，从那里继续。
+// 下载完校验整个文件的SHA-256，不一致时返回DataLoss，这时应当从0重新下载
+func Download(ctx context.Context, c Caller, name string, dst File, offset int64, opts ...Option) (*FileInfo, error) {
+	o := newOptions(opts)
+	var info FileInfo
+	for attempt := 0; ; attempt++ {
+		err := c.CallContext(ctx, o.method("Stat"), name, &info)
+		if err == nil && !info.Complete {
+			return nil, mrpc.Errorf(mrpc.NotFound, "rpc transfer: %s not found", name)
+		}
+		if err == nil {
+			offset, err = download(ctx, c, o, name, dst, min(offset, info.Size), info.Size)
+		}
+		if err == nil {
+			break
+		}
+		if attempt >= o.retries || !retryable(err) {
+			return nil, err
+		}
+	}
+	sum, err := hashRange(dst, info.Size)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(sum, info.SHA256) {
+		return nil, mrpc.Errorf(mrpc.DataLoss, "rpc transfer: %s checksum mismatch after download", name)
+	}
+	return &info, nil
+}
+
+// 从offset下载到size，返回已经写入dst的位置，出错时调用方从这里继续
+func download(ctx context.Context, c Caller, o *options, name string, dst File, offset, size int64) (int64, error) {
+	for offset < size {
+		var chunk Chunk
+		req := &ReadRequest{Name: name, Offset: offset, Length: o.chunkSize}
+		if err := c.CallContext(ctx, o.method("Read"), req, &chunk); err != nil {
+			return offset, err
+		}
+		if checksum(chunk.Data) != chunk.CRC32 || len(chunk.Data) == 0 {
+			return offset, fmt.Errorf("rpc transfer: bad chunk at %d", offset)
+		}
+		if _, err := dst.WriteAt(chunk.Data, offset); err != nil {
+			return offset, err
+		}
+		offset += int64(len(chunk.Data))
+	}
+	return offset, nil
+}
+
+func hashRange(r io.ReaderAt, n int64) ([]byte, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, io.NewSectionReader(r, 0, n)); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
@@ -0,0 +1,221 @@
+// transfer 在mrpc调用之上分块传输文件：每块带CRC32校验并由服务端确认已写入的位置，
+// 整个文件用SHA-256校验，连接断开后重新上传、下载时从断点继续。
+//
+//	s.RegisterName("Transfer", transfer.NewService("/data/uploads"))
+//
+//	info, err := transfer.Upload(ctx, client, "backup/db.tar", f, size)
+//	info, err := transfer.Download(ctx, client, "backup/db.tar", dst, downloaded)
+//
+// 服务端把未完成的上传写在name+".part"中，Commit校验整个文件的大小和哈希后才改名为name，
+// 下载只能读取已完成的文件
+package transfer
+
+import (
+	"crypto/sha256"
+	"errors"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/micplus/mrpc"
+)
+
+// 未完成的上传的后缀
+const partSuffix = ".part"
+
+// 一次Read最多返回的字节数
+const maxReadLength = 4 << 20
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// 数据块的校验和
+func checksum(data []byte) uint32 {
+	return crc32.Checksum(data, castagnoli)
+}
+
+// 文件在服务端的状态
+type FileInfo struct {
+	Name string
+	// 已完成时是文件大小，否则是已经收到的字节数，即续传的起点
+	Size     int64
+	Complete bool
+	// Size个字节的SHA-256，续传前用来确认已上传的部分与本地一致
+	SHA256 []byte
+}
+
+// 一块数据
+type Chunk struct {
+	Name   string
+	Offset int64
+	Data   []byte
+	CRC32  uint32 // Data的CRC32(Castagnoli)
+}
+
+// 服务端对一块数据的确认
+type Ack struct {
+	Offset int64 // 已写入的字节数，下一块从这里开始
+}
+
+// 上传完成，服务端校验大小和哈希后把文件标记为完成
+type Commit struct {
+	Name   string
+	Size   int64
+	SHA256 []byte
+}
+
+type ReadRequest struct {
+	Name   string
+	Offset int64
+	Length int
+}
+
+// 保存上传文件的服务，文件都在root目录下，名称是以/分隔的相对路径
+type Service struct {
+	root  string
+	locks sync.Map // name -> *sync.Mutex，同一个文件的写入串行
+}
+
+func NewService(root string) *Service {
+	return &Service{root: root}
+}
+
+// 名称必须是root下的相对路径，不能以.part结尾
+func (s *Service) path(name string) (string, error) {
+	local := filepath.FromSlash(name)
+	if name == "" || !filepath.IsLocal(local) || strings.HasSuffix(name, partSuffix) {
+		return "", mrpc.Errorf(mrpc.InvalidArgument, "rpc transfer: invalid file name %q", name)
+	}
+	return filepath.Join(s.root, local), nil
+}
+
+func (s *Service) lock(name string) func() {
+	mu, _ := s.locks.LoadOrStore(name, new(sync.Mutex))
+	mu.(*sync.Mutex).Lock()
+	return mu.(*sync.Mutex).Unlock
+}
+
+// 查询文件状态，没有时返回Size为0的未完成状态
+func (s *Service) Stat(name string, info *FileInfo) error {
+	path, err := s.path(name)
+	if err != nil {
+		return err
+	}
+	defer s.lock(name)()
+	*info = FileInfo{Name: name}
+	sum, size, err := hashFile(path)
+	if err == nil {
+		info.Size, info.SHA256, info.Complete = size, sum, true
+		return nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	sum, size, err = hashFile(path + partSuffix)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	info.Size, info.SHA256 = size, sum
+	return err
+}
+
+// 追加一块数据。Offset为0时重新开始，否则必须等于已收到的字节数
+func (s *Service) Write(chunk *Chunk, ack *Ack) error {
+	path, err := s.path(chunk.Name)
+	if err != nil {
+		return err
+	}
+	if checksum(chunk.Data) != chunk.CRC32 {
+		return mrpc.Errorf(mrpc.DataLoss, "rpc transfer: checksum mismatch in chunk at %d", chunk.Offset)
+	}
+	defer s.lock(chunk.Name)()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	flag := os.O_WRONLY | os.O_CREATE
+	if chunk.Offset == 0 {
+		flag |= os.O_TRUNC
+	}
+	f, err := os.OpenFile(path+partSuffix, flag, 0o644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if st.Size() != chunk.Offset {
+		return mrpc.Errorf(mrpc.FailedPrecondition, "rpc transfer: %s has %d bytes, got chunk at %d", chunk.Name, st.Size(), chunk.Offset)
+	}
+	if _, err := f.WriteAt(chunk.Data, chunk.Offset); err != nil {
+		return err
+	}
+	ack.Offset = chunk.Offset + int64(len(chunk.Data))
+	return nil
+}
+
+// 校验收到的整个文件，一致时完成上传，否则丢弃已收到的部分
+func (s *Service) Commit(c *Commit, info *FileInfo) error {
+	path, err := s.path(c.Name)
+	if err != nil {
+		return err
+	}
+	defer s.lock(c.Name)()
+	sum, size, err := hashFile(path + partSuffix)
+	if errors.Is(err, fs.ErrNotExist) {
+		return mrpc.Errorf(mrpc.NotFound, "rpc transfer: no upload in progress for %s", c.Name)
+	}
+	if err != nil {
+		return err
+	}
+	if size != c.Size || string(sum) != string(c.SHA256) {
+		os.Remove(path + partSuffix)
+		return mrpc.Errorf(mrpc.DataLoss, "rpc transfer: %s does not match: got %d bytes, want %d", c.Name, size, c.Size)
+	}
+	if err := os.Rename(path+partSuffix, path); err != nil {
+		return err
+	}
+	*info = FileInfo{Name: c.Name, Size: size, Complete: true, SHA256: sum}
+	return nil
+}
+
+// 读已完成文件的一块，到文件末尾时Data比Length短
+func (s *Service) Read(req *ReadRequest, chunk *Chunk) error {
+	path, err := s.path(req.Name)
+	if err != nil {
+		return err
+	}
+	if req.Offset < 0 || req.Length <= 0 || req.Length > maxReadLength {
+		return mrpc.Errorf(mrpc.InvalidArgument, "rpc transfer: invalid range %d+%d", req.Offset, req.Length)
+	}
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return mrpc.Errorf(mrpc.NotFound, "rpc transfer: %s not found", req.Name)
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	data := make([]byte, req.Length)
+	n, err := f.ReadAt(data, req.Offset)
+	if err != nil && err != io.EOF {
+		return err
+	}
+	*chunk = Chunk{Name: req.Name, Offset: req.Offset, Data: data[:n], CRC32: checksum(data[:n])}
+	return nil
+}
+
+func hashFile(path string) (sum []byte, size int64, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	size, err = io.Copy(h, f)
+	return h.Sum(nil), size, err
+}
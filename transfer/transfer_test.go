@@ -0,0 +1,146 @@
+package transfer
+
+import (
+	"bytes"
+	"context"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/micplus/mrpc"
+)
+
+func assert(t *testing.T, cond bool, format string, args ...any) {
+	t.Helper()
+	if !cond {
+		t.Fatalf(format, args...)
+	}
+}
+
+func newClient(t *testing.T) (*mrpc.Client, string) {
+	dir := t.TempDir()
+	s := mrpc.NewServer()
+	if err := s.RegisterName("Transfer", NewService(dir)); err != nil {
+		t.Fatal(err)
+	}
+	client, err := mrpc.ConnectInProcess(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client, dir
+}
+
+// 第fail次调用Transfer.Write时模拟断线
+type flaky struct {
+	Caller
+	writes, fail int
+}
+
+func (f *flaky) CallContext(ctx context.Context, name string, args, reply any) error {
+	if name == "Transfer.Write" {
+		if f.writes++; f.writes == f.fail {
+			return mrpc.ErrShutDown
+		}
+	}
+	return f.Caller.CallContext(ctx, name, args, reply)
+}
+
+func randomData(n int) []byte {
+	data := make([]byte, n)
+	rand.New(rand.NewSource(1)).Read(data)
+	return data
+}
+
+func TestUploadDownload(t *testing.T) {
+	client, dir := newClient(t)
+	ctx := context.Background()
+	data := randomData(100 << 10)
+
+	// 第3块断线，重试时从服务端记录的断点继续
+	f := &flaky{Caller: client, fail: 3}
+	info, err := Upload(ctx, f, "a/b.bin", bytes.NewReader(data), int64(len(data)), WithChunkSize(16<<10))
+	assert(t, err == nil, "Upload: %v", err)
+	assert(t, info.Complete && info.Size == int64(len(data)), "info = %+v", info)
+	assert(t, f.writes == 8, "want 7 chunks plus 1 failed write, got %d writes", f.writes)
+	got, err := os.ReadFile(filepath.Join(dir, "a", "b.bin"))
+	assert(t, err == nil && bytes.Equal(got, data), "stored file differs: %v", err)
+
+	// 内容相同时直接返回，不同时AlreadyExists
+	_, err = Upload(ctx, client, "a/b.bin", bytes.NewReader(data), int64(len(data)))
+	assert(t, err == nil, "re-upload of same content: %v", err)
+	_, err = Upload(ctx, client, "a/b.bin", bytes.NewReader(data[1:]), int64(len(data)-1))
+	assert(t, mrpc.CodeOf(err) == mrpc.AlreadyExists, "want AlreadyExists, got %v", err)
+
+	// 从已下载的一半继续
+	dst, err := os.Create(filepath.Join(t.TempDir(), "dst"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dst.Close()
+	half := int64(len(data) / 2)
+	dst.WriteAt(data[:half], 0)
+	info, err = Download(ctx, client, "a/b.bin", dst, half, WithChunkSize(10<<10))
+	assert(t, err == nil && info.Size == int64(len(data)), "Download: %v", err)
+	got, _ = os.ReadFile(dst.Name())
+	assert(t, bytes.Equal(got, data), "downloaded file differs")
+
+	// 已下载的部分损坏时校验失败
+	dst.WriteAt([]byte{^data[0]}, 0)
+	_, err = Download(ctx, client, "a/b.bin", dst, half)
+	assert(t, mrpc.CodeOf(err) == mrpc.DataLoss, "want DataLoss, got %v", err)
+}
+
+func TestUploadResume(t *testing.T) {
+	client, dir := newClient(t)
+	ctx := context.Background()
+	data := randomData(10 << 10)
+
+	// 上一次上传留下了前4KB
+	var ack Ack
+	chunk := &Chunk{Name: "f", Data: data[:4096], CRC32: checksum(data[:4096])}
+	err := client.Call("Transfer.Write", chunk, &ack)
+	assert(t, err == nil && ack.Offset == 4096, "Write: %v, ack %d", err, ack.Offset)
+	var info FileInfo
+	err = client.Call("Transfer.Stat", "f", &info)
+	assert(t, err == nil && !info.Complete && info.Size == 4096, "Stat: %v %+v", err, info)
+
+	f := &flaky{Caller: client}
+	_, err = Upload(ctx, f, "f", bytes.NewReader(data), int64(len(data)), WithChunkSize(4096))
+	assert(t, err == nil, "Upload: %v", err)
+	assert(t, f.writes == 2, "want 2 chunks after resume, got %d", f.writes)
+
+	// 留下的部分与要上传的不一致时从头开始
+	other := randomData(8 << 10)
+	other[0]++
+	chunk = &Chunk{Name: "g", Data: other[:4096], CRC32: checksum(other[:4096])}
+	client.Call("Transfer.Write", chunk, &ack)
+	f.writes = 0
+	_, err = Upload(ctx, f, "g", bytes.NewReader(data), int64(len(data)), WithChunkSize(4096))
+	assert(t, err == nil && f.writes == 3, "Upload: %v, %d writes", err, f.writes)
+	got, _ := os.ReadFile(filepath.Join(dir, "g"))
+	assert(t, bytes.Equal(got, data), "stored file differs")
+}
+
+func TestServiceErrors(t *testing.T) {
+	client, _ := newClient(t)
+	var ack Ack
+	err := client.Call("Transfer.Write", &Chunk{Name: "f", Data: []byte("abc"), CRC32: 1}, &ack)
+	assert(t, mrpc.CodeOf(err) == mrpc.DataLoss, "bad CRC: want DataLoss, got %v", err)
+	err = client.Call("Transfer.Write", &Chunk{Name: "f", Offset: 3, Data: []byte("abc"), CRC32: checksum([]byte("abc"))}, &ack)
+	assert(t, mrpc.CodeOf(err) == mrpc.FailedPrecondition, "gap: want FailedPrecondition, got %v", err)
+	for _, name := range []string{"../x", "/etc/passwd", "f.part", ""} {
+		err = client.Call("Transfer.Stat", name, new(FileInfo))
+		assert(t, mrpc.CodeOf(err) == mrpc.InvalidArgument, "%q: want InvalidArgument, got %v", name, err)
+	}
+	err = client.Call("Transfer.Commit", &Commit{Name: "none"}, new(FileInfo))
+	assert(t, mrpc.CodeOf(err) == mrpc.NotFound, "want NotFound, got %v", err)
+	_, err = Download(context.Background(), client, "none", nil, 0)
+	assert(t, mrpc.CodeOf(err) == mrpc.NotFound, "want NotFound, got %v", err)
+
+	// 空文件
+	_, err = Upload(context.Background(), client, "empty", strings.NewReader(""), 0)
+	assert(t, err == nil, "empty Upload: %v", err)
+}
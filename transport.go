@@ -0,0 +1,99 @@
+package mrpc
+
+import (
+	"errors"
+	"net"
+	"sync"
+)
+
+// 进程内传输：用net.Pipe连接客户端和服务端，测试时不需要监听端口，
+// 也不用等待listener就绪，但依然经过握手、codec编解码的完整流程
+
+type pipeAddr struct{}
+
+func (pipeAddr) Network() string { return "pipe" }
+func (pipeAddr) String() string  { return "pipe" }
+
+// 进程内的Listener，Dial得到net.Pipe的一端，另一端由Accept返回
+type PipeListener struct {
+	conns     chan net.Conn
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+var _ net.Listener = (*PipeListener)(nil)
+
+func NewPipeListener() *PipeListener {
+	return &PipeListener{
+		conns: make(chan net.Conn),
+		done:  make(chan struct{}),
+	}
+}
+
+func (l *PipeListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *PipeListener) Close() error {
+	l.closeOnce.Do(func() { close(l.done) })
+	return nil
+}
+
+func (l *PipeListener) Addr() net.Addr {
+	return pipeAddr{}
+}
+
+// 建立一条进程内连接，阻塞到对端被Accept
+func (l *PipeListener) Dial() (net.Conn, error) {
+	c1, c2 := net.Pipe()
+	select {
+	case l.conns <- c2:
+		return c1, nil
+	case <-l.done:
+		c1.Close()
+		c2.Close()
+		return nil, errors.New("rpc: pipe listener closed")
+	}
+}
+
+// 建立进程内连接并在其上创建客户端
+func (l *PipeListener) DialClient(codecType uint32) (*Client, error) {
+	conn, err := l.Dial()
+	if err != nil {
+		return nil, err
+	}
+	return NewClient(conn, codecType)
+}
+
+// 让服务端处理一条新的进程内连接，返回连接另一端的客户端(默认gob编码)
+func ConnectInProcess(s *Server, codecType ...uint32) (*Client, error) {
+	ccType, err := pickCodecType(codecType)
+	if err != nil {
+		return nil, err
+	}
+	c1, c2 := net.Pipe()
+	go s.ServeConn(c2)
+	return NewClient(c1, ccType)
+}
+
+// 创建服务端并注册rcvrs，再接上一个进程内客户端
+//
+//	client, server, err := mrpc.NewClientServerPair(new(Arith))
+func NewClientServerPair(rcvrs ...any) (*Client, *Server, error) {
+	s := NewServer()
+	for _, rcvr := range rcvrs {
+		if err := s.Register(rcvr); err != nil {
+			return nil, nil, err
+		}
+	}
+	client, err := ConnectInProcess(s)
+	if err != nil {
+		return nil, nil, err
+	}
+	return client, s, nil
+}
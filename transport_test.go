@@ -0,0 +1,98 @@
+package mrpc
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+)
+
+type Pair struct{ A, B int }
+
+type Calc int
+
+func (*Calc) Sum(args Pair, reply *int) error {
+	*reply = args.A + args.B
+	return nil
+}
+
+func TestClientServerPair(t *testing.T) {
+	client, _, err := NewClientServerPair(new(Calc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			var sum int
+			err := client.Call("Calc.Sum", Pair{i, i}, &sum)
+			assert(t, err == nil && sum == 2*i, "Calc.Sum(%d, %d) = %d, %v", i, i, sum, err)
+		}(i)
+	}
+	wg.Wait()
+
+	err = client.Call("Calc.Missing", Pair{}, new(int))
+	_, ok := err.(ServerError)
+	assert(t, ok, "want ServerError for unknown method, got %v", err)
+}
+
+func TestPipeListener(t *testing.T) {
+	s := NewServer()
+	s.Register(new(Calc))
+	lis := NewPipeListener()
+	done := make(chan struct{})
+	go func() {
+		s.Accept(lis)
+		close(done)
+	}()
+
+	client, err := lis.DialClient(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sum int
+	err = client.Call("Calc.Sum", Pair{1, 2}, &sum)
+	assert(t, err == nil && sum == 3, "Calc.Sum = %d, %v", sum, err)
+
+	lis.Close()
+	<-done // Accept在listener关闭后返回
+	_, err = lis.Dial()
+	assert(t, err != nil, "Dial on closed listener should fail")
+}
+
+type Blob int
+
+func (*Blob) Upper(args []byte, reply *[]byte) error {
+	*reply = bytes.ToUpper(args)
+	return nil
+}
+
+func (*Blob) Len(args RawMessage, reply *int) error {
+	*reply = len(args)
+	return nil
+}
+
+func TestRawBytes(t *testing.T) {
+	client, _, err := NewClientServerPair(new(Blob), new(Calc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	reply := make([]byte, 0, 16)
+	err = client.Call("Blob.Upper", []byte("hello"), &reply)
+	assert(t, err == nil && string(reply) == "HELLO", "Blob.Upper = %q, %v", reply, err)
+	var n int
+	err = client.Call("Blob.Len", RawMessage("hello"), &n)
+	assert(t, err == nil && n == 5, "Blob.Len = %d, %v", n, err)
+
+	// 参数类型不是字节切片时报错，连接仍然可用
+	err = client.Call("Calc.Sum", []byte("x"), &n)
+	_, ok := err.(ServerError)
+	assert(t, ok, "want ServerError, got %v", err)
+	err = client.Call("Calc.Sum", Pair{1, 2}, &n)
+	assert(t, err == nil && n == 3, "Calc.Sum = %d, %v", n, err)
+}
@@ -0,0 +1,123 @@
+package mrpc
+
+import (
+	"errors"
+	"fmt"
+	"go/token"
+	"strconv"
+	"strings"
+)
+
+// 方法版本：方法的参数或返回值要做不兼容的修改时，新签名注册为新版本，与旧版本同时提供服务，
+// 客户端逐个迁移。带版本的调用是"Arith.Add@2"，不带版本的调用使用默认版本：
+// SetDefaultVersion指定的版本，没有指定时是不带版本注册的服务，也没有时是最低的版本。
+// 版本是正整数，每个版本是一个单独的服务，在Services中名为"Arith@2"
+//
+//	s.Register(new(Arith))                      // 旧客户端调用Arith.Add
+//	s.RegisterVersion("Arith", 2, new(ArithV2)) // 新客户端调用Arith.Add@2
+//	mrpc.HandleFunc(s, "Arith.Mul@2", mul)
+//
+// 所有客户端都迁移后用SetDefaultVersion切换默认版本，再去掉旧版本
+
+type serviceVersions struct {
+	services map[string]*service // 版本号 -> 服务
+	def      *service            // 不带版本的调用使用的服务，没有指定时是最低版本
+	lowest   int
+	explicit bool // def由SetDefaultVersion指定
+}
+
+// 带版本的服务名
+func versionedName(name string, version int) string {
+	return name + "@" + strconv.Itoa(version)
+}
+
+// 方法的完整名称，服务名带版本("Arith@2")时是"Arith.Add@2"
+func methodName(service, method string) string {
+	if at := strings.IndexByte(service, '@'); at >= 0 {
+		return service[:at] + "." + method + service[at:]
+	}
+	return service + "." + method
+}
+
+// 解析"Add@2"中的版本，没有版本时为0
+func splitVersion(mName string) (string, int, error) {
+	at := strings.IndexByte(mName, '@')
+	if at < 0 {
+		return mName, 0, nil
+	}
+	version, err := strconv.Atoi(mName[at+1:])
+	if err != nil || version < 1 {
+		return "", 0, fmt.Errorf("rpc server: %q is not a valid method version", mName[at+1:])
+	}
+	return mName[:at], version, nil
+}
+
+// 以name的第version个版本注册服务，客户端用"name.Method@version"调用
+func (s *Server) RegisterVersion(name string, version int, rcvr any, opts ...ServiceOption) error {
+	if !token.IsIdentifier(name) || !token.IsExported(name) {
+		return fmt.Errorf("rpc server: %q is not a valid service name", name)
+	}
+	if version < 1 {
+		return fmt.Errorf("rpc server: invalid version %d of service %s", version, name)
+	}
+	key := versionedName(name, version)
+	if _, dup := s.serviceMap[key]; dup {
+		return errors.New("rpc server: duplicated service " + key)
+	}
+	svc := newNamedService(rcvr, key)
+	for _, opt := range opts {
+		opt(svc)
+	}
+	s.serviceMap[key] = svc
+	s.addVersion(name, version, svc)
+	return nil
+}
+
+func (s *Server) addVersion(name string, version int, svc *service) {
+	if s.versions == nil {
+		s.versions = make(map[string]*serviceVersions)
+	}
+	v := s.versions[name]
+	if v == nil {
+		v = &serviceVersions{services: make(map[string]*service)}
+		s.versions[name] = v
+	}
+	v.services[strconv.Itoa(version)] = svc
+	if !v.explicit && (v.def == nil || version < v.lowest) {
+		v.def, v.lowest = svc, version
+	}
+}
+
+// 指定不带版本的调用使用的版本，它必须已经注册。应当在开始服务之前调用
+func (s *Server) SetDefaultVersion(name string, version int) error {
+	v := s.versions[name]
+	if v == nil || v.services[strconv.Itoa(version)] == nil {
+		return errors.New("rpc server: cannot find service " + versionedName(name, version))
+	}
+	v.def, v.explicit = v.services[strconv.Itoa(version)], true
+	return nil
+}
+
+// 按服务名和方法名找到注册的服务，方法名可能带版本。返回的sName用于错误信息，mName去掉了版本
+func (s *Server) lookupService(sName, mName string) (*service, string, string) {
+	if s.versions == nil {
+		return s.serviceMap[sName], sName, mName
+	}
+	return s.findVersion(sName, mName)
+}
+
+func (s *Server) findVersion(sName, mName string) (*service, string, string) {
+	v := s.versions[sName]
+	if at := strings.IndexByte(mName, '@'); at >= 0 {
+		if v != nil {
+			if svc := v.services[mName[at+1:]]; svc != nil {
+				return svc, sName, mName[:at]
+			}
+		}
+		return nil, sName + mName[at:], mName[:at]
+	}
+	if v != nil && (v.explicit || s.serviceMap[sName] == nil) {
+		return v.def, sName, mName
+	}
+	return s.serviceMap[sName], sName, mName
+}
@@ -0,0 +1,77 @@
+package mrpc
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+// Calc.Sum的第2版，参数从Pair改成了任意个数
+type CalcV2 int
+
+func (*CalcV2) Sum(args []int, reply *int) error {
+	for _, n := range args {
+		*reply += n
+	}
+	return nil
+}
+
+func TestVersionedMethods(t *testing.T) {
+	s := NewServer()
+	s.Register(new(Calc))
+	assert(t, s.RegisterVersion("Calc", 2, new(CalcV2)) == nil, "register Calc@2")
+	err := HandleFunc(s, "Calc.Double@3", func(ctx context.Context, n int, reply *int) error {
+		*reply = 2 * n
+		return nil
+	})
+	assert(t, err == nil, "HandleFunc Calc.Double@3: %v", err)
+	err = s.RegisterVersion("Calc", 2, new(CalcV2))
+	assert(t, err != nil && strings.Contains(err.Error(), "duplicated service Calc@2"), "want duplicated, got %v", err)
+	assert(t, s.RegisterVersion("Calc", 0, new(CalcV2)) != nil, "version 0 should be rejected")
+	assert(t, HandleFunc(s, "Calc.Double@v1", func(ctx context.Context, n int, reply *int) error { return nil }) != nil,
+		"non-numeric version should be rejected")
+	assert(t, s.serviceMap["Calc@2"].method["Sum"].name == "Calc.Sum@2", "method name %q", s.serviceMap["Calc@2"].method["Sum"].name)
+
+	client, err := ConnectInProcess(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	call := func(name string, args any) (int, error) {
+		var reply int
+		err := client.Call(name, args, &reply)
+		return reply, err
+	}
+	for _, tc := range []struct {
+		name string
+		args any
+		want int
+		err  string
+	}{
+		{"Calc.Sum", Pair{1, 2}, 3, ""}, // 不带版本的调用先用不带版本注册的服务
+		{"Calc.Sum@2", []int{1, 2, 3}, 6, ""},
+		{"Calc.Double@3", 4, 8, ""},
+		{"Calc.Double", 4, 0, "cannot find method Double"},
+		{"Calc.Sum@4", Pair{}, 0, "cannot find service Calc@4"},
+	} {
+		got, err := call(tc.name, tc.args)
+		if tc.err == "" {
+			assert(t, err == nil && got == tc.want, "%s: want %d, got %d, %v", tc.name, tc.want, got, err)
+		} else {
+			assert(t, err != nil && strings.Contains(err.Error(), tc.err), "%s: want %q, got %v", tc.name, tc.err, err)
+		}
+	}
+
+	// 只有带版本的服务时默认是最低版本
+	err = HandleFunc(s, "Meter.Read@2", func(ctx context.Context, _ int, reply *int) error { *reply = 2; return nil })
+	assert(t, err == nil, "HandleFunc Meter.Read@2: %v", err)
+	err = HandleFunc(s, "Meter.Read@1", func(ctx context.Context, _ int, reply *int) error { *reply = 1; return nil })
+	assert(t, err == nil, "HandleFunc Meter.Read@1: %v", err)
+	got, err := call("Meter.Read", 0)
+	assert(t, err == nil && got == 1, "Meter.Read should use version 1, got %d, %v", got, err)
+
+	assert(t, s.SetDefaultVersion("Calc", 5) != nil, "default version must be registered")
+	assert(t, s.SetDefaultVersion("Calc", 2) == nil, "set default version")
+	got, err = call("Calc.Sum", []int{4, 5})
+	assert(t, err == nil && got == 9, "Calc.Sum should use version 2, got %d, %v", got, err)
+}
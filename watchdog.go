@@ -0,0 +1,240 @@
+package mrpc
+
+import (
+	"context"
+	"errors"
+	"log"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 卡住的方法检测：方法运行远超预期时，多半是业务代码死锁或者等一个永远不来的结果，
+// 这样的请求越积越多，最后把协程池和流量控制的窗口耗尽。开启后后台定时检查正在运行的方法，
+// 超过阈值的报告方法名(可选地带上它所在协程的调用栈)，可选地取消方法的ctx，
+// 并计入expvar的"server_stuck_handlers"，方法返回后减掉。后台检查在Server.Shutdown返回时停止
+//
+//	s := mrpc.NewServer(mrpc.WithWatchdog(mrpc.WatchdogConfig{
+//		Threshold: 30 * time.Second,
+//		Methods:   map[string]time.Duration{"Report.Build": 10 * time.Minute},
+//		Cancel:    true,
+//	}))
+type WatchdogConfig struct {
+	// 方法运行超过它视为卡住，为0时只检查Methods中的方法
+	Threshold time.Duration
+	// 按方法名覆盖Threshold，为0时不检查这个方法
+	Methods map[string]time.Duration
+	// 检查的间隔，为0时是最小阈值的一半
+	Interval time.Duration
+	// 取消卡住的方法的ctx，方法因此返回时响应Aborted
+	Cancel bool
+	// 报告时带上方法所在协程的调用栈。每个请求开始时要用runtime.Stack取协程编号，开销不小，默认关闭
+	Stack bool
+	// 发现卡住的方法时调用，为nil时写日志。每个请求只报告一次
+	OnStuck func(StuckHandler)
+}
+
+// 一个卡住的方法
+type StuckHandler struct {
+	Method  string
+	Elapsed time.Duration
+	Stack   string // 运行方法的协程的调用栈，未开启WatchdogConfig.Stack或找不到时为空
+}
+
+// 开启卡住的方法检测，见WatchdogConfig
+func WithWatchdog(cfg WatchdogConfig) ServerOption {
+	return func(s *Server) {
+		s.watchdog = &watchdog{cfg: cfg, active: make(map[*watched]struct{}), done: make(chan struct{})}
+	}
+}
+
+var errStuck = &Error{Code: Aborted, Message: "rpc server: handler stuck, canceled by watchdog"}
+
+type watchdog struct {
+	cfg   WatchdogConfig
+	clock Clock
+	once  sync.Once
+	// 关闭时后台检查退出
+	stopOnce sync.Once
+	done     chan struct{}
+
+	mu     sync.Mutex // protect following
+	active map[*watched]struct{}
+}
+
+// 一个正在运行的方法
+type watched struct {
+	method    string
+	start     time.Time
+	threshold time.Duration
+	goid      uint64 // 只在WatchdogConfig.Stack开启时记录
+	cancel    context.CancelCauseFunc
+	stuck     bool // 已经报告过，受watchdog.mu保护
+}
+
+func (d *watchdog) threshold(method string) time.Duration {
+	if t, ok := d.cfg.Methods[method]; ok {
+		return t
+	}
+	return d.cfg.Threshold
+}
+
+// 检查的间隔，没有阈值时为0
+func (d *watchdog) interval() time.Duration {
+	if d.cfg.Interval > 0 {
+		return d.cfg.Interval
+	}
+	least := d.cfg.Threshold
+	for _, t := range d.cfg.Methods {
+		if t > 0 && (least <= 0 || t < least) {
+			least = t
+		}
+	}
+	if least <= 0 {
+		return 0
+	}
+	return max(least/2, time.Millisecond)
+}
+
+// 方法开始运行，在运行方法的协程中调用。返回的ctx在Cancel时可被取消，
+// 不检查这个方法时原样返回ctx和nil
+func (d *watchdog) begin(ctx context.Context, method string) (context.Context, *watched) {
+	if d == nil {
+		return ctx, nil
+	}
+	threshold := d.threshold(method)
+	if threshold <= 0 {
+		return ctx, nil
+	}
+	d.once.Do(d.start)
+	w := &watched{method: method, start: d.clock.Now(), threshold: threshold}
+	if d.cfg.Stack {
+		w.goid = goroutineID()
+	}
+	if d.cfg.Cancel {
+		ctx, w.cancel = context.WithCancelCause(ctx)
+	}
+	d.mu.Lock()
+	d.active[w] = struct{}{}
+	d.mu.Unlock()
+	return ctx, w
+}
+
+// 方法返回，err是它的结果。被看门狗取消而返回时换成errStuck，方法自己返回了*Error时保留它
+func (d *watchdog) end(w *watched, err error) error {
+	if w == nil {
+		return err
+	}
+	d.mu.Lock()
+	delete(d.active, w)
+	stuck := w.stuck
+	d.mu.Unlock()
+	if stuck {
+		serverStuckHandlers.Add(-1)
+	}
+	if w.cancel == nil {
+		return err
+	}
+	var e *Error
+	if stuck && err != nil && !errors.As(err, &e) {
+		err = errStuck
+	}
+	w.cancel(nil)
+	return err
+}
+
+// 后台定时检查，直到stop
+func (d *watchdog) start() {
+	interval := d.interval()
+	go func() {
+		ticker := d.clock.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case now := <-ticker.C():
+				d.check(now)
+			case <-d.done:
+				return
+			}
+		}
+	}()
+}
+
+// 停止后台检查，可以重复调用
+func (d *watchdog) stop() {
+	if d == nil {
+		return
+	}
+	d.stopOnce.Do(func() { close(d.done) })
+}
+
+func (d *watchdog) check(now time.Time) {
+	var stuck []*watched
+	d.mu.Lock()
+	for w := range d.active {
+		if !w.stuck && now.Sub(w.start) >= w.threshold {
+			w.stuck = true
+			stuck = append(stuck, w)
+		}
+	}
+	d.mu.Unlock()
+	if len(stuck) == 0 {
+		return
+	}
+	serverStuckHandlers.Add(int64(len(stuck)))
+	var stacks map[uint64]string
+	if d.cfg.Stack {
+		stacks = goroutineStacks()
+	}
+	for _, w := range stuck {
+		h := StuckHandler{Method: w.method, Elapsed: now.Sub(w.start), Stack: stacks[w.goid]}
+		if d.cfg.OnStuck != nil {
+			d.cfg.OnStuck(h)
+		} else {
+			log.Printf("rpc server: handler %s stuck for %v\n%s", h.Method, h.Elapsed, h.Stack)
+		}
+		if w.cancel != nil {
+			w.cancel(errStuck)
+		}
+	}
+}
+
+// 当前协程的编号，从调用栈的第一行"goroutine 123 [running]:"中取得
+func goroutineID() uint64 {
+	var buf [32]byte
+	s := string(buf[:runtime.Stack(buf[:], false)])
+	s = strings.TrimPrefix(s, "goroutine ")
+	if i := strings.IndexByte(s, ' '); i > 0 {
+		s = s[:i]
+	}
+	id, _ := strconv.ParseUint(s, 10, 64)
+	return id
+}
+
+// 所有协程的调用栈，按协程编号索引
+func goroutineStacks() map[uint64]string {
+	buf := make([]byte, 64<<10)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+	stacks := make(map[uint64]string)
+	for _, g := range strings.Split(string(buf), "\n\n") {
+		rest, ok := strings.CutPrefix(g, "goroutine ")
+		if !ok {
+			continue
+		}
+		if i := strings.IndexByte(rest, ' '); i > 0 {
+			if id, err := strconv.ParseUint(rest[:i], 10, 64); err == nil {
+				stacks[id] = g
+			}
+		}
+	}
+	return stacks
+}
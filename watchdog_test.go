@@ -0,0 +1,101 @@
+package mrpc
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func blockUntilCanceled(ctx context.Context, _ int, reply *int) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestWatchdog(t *testing.T) {
+	reported := make(chan StuckHandler, 4)
+	s := NewServer(WithWatchdog(WatchdogConfig{
+		Threshold: 20 * time.Millisecond,
+		Methods:   map[string]time.Duration{"Slow.Unwatched": 0},
+		Cancel:    true,
+		Stack:     true,
+		OnStuck:   func(h StuckHandler) { reported <- h },
+	}))
+	HandleFunc(s, "Slow.Block", blockUntilCanceled)
+	HandleFunc(s, "Slow.Unwatched", func(ctx context.Context, _ int, reply *int) error {
+		time.Sleep(60 * time.Millisecond)
+		return nil
+	})
+	client := pipeClient(t, s)
+
+	before := serverStuckHandlers.Value()
+	var reply int
+	err := client.Call("Slow.Block", 0, &reply)
+	assert(t, CodeOf(err) == Aborted && strings.Contains(err.Error(), "watchdog"), "want Aborted, got %v", err)
+	h := <-reported
+	assert(t, h.Method == "Slow.Block" && h.Elapsed >= 20*time.Millisecond, "reported %s after %v", h.Method, h.Elapsed)
+	assert(t, strings.Contains(h.Stack, "blockUntilCanceled"), "stack does not show the handler:\n%s", h.Stack)
+	assert(t, serverStuckHandlers.Value() == before, "stuck gauge %d, want %d", serverStuckHandlers.Value(), before)
+
+	// 阈值为0的方法不检查
+	err = client.Call("Slow.Unwatched", 0, &reply)
+	assert(t, err == nil, "unwatched: %v", err)
+	select {
+	case h := <-reported:
+		t.Fatalf("unexpected report %+v", h)
+	default:
+	}
+}
+
+// 报告停止的计时器的间隔
+type tickerClock struct {
+	systemClock
+	stopped chan time.Duration
+}
+
+type stopTicker struct {
+	Ticker
+	d       time.Duration
+	stopped chan time.Duration
+}
+
+func (t stopTicker) Stop() {
+	t.Ticker.Stop()
+	t.stopped <- t.d
+}
+
+func (c tickerClock) NewTicker(d time.Duration) Ticker {
+	return stopTicker{Ticker: c.systemClock.NewTicker(d), d: d, stopped: c.stopped}
+}
+
+func TestWatchdogStopsOnShutdown(t *testing.T) {
+	const interval = 7 * time.Millisecond
+	clock := tickerClock{stopped: make(chan time.Duration, 16)}
+	s := NewServer(WithClock(clock), WithWatchdog(WatchdogConfig{Threshold: time.Minute, Interval: interval}))
+	HandleFunc(s, "Quick.Echo", func(_ context.Context, n int, reply *int) error {
+		*reply = n
+		return nil
+	})
+	client, err := ConnectInProcess(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var reply int
+	err = client.Call("Quick.Echo", 1, &reply) // 第一个请求启动后台检查
+	assert(t, err == nil && reply == 1, "Quick.Echo = %d, %v", reply, err)
+	client.Close()
+	if err := s.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case d := <-clock.stopped:
+			if d == interval {
+				return
+			}
+		case <-timeout:
+			t.Fatal("watchdog ticker not stopped after Shutdown")
+		}
+	}
+}
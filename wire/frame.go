@@ -0,0 +1,105 @@
+// wire 在连接层录制原始的请求/响应字节流，并能把录下的请求重新发给服务端，
+// 用于排查协议问题和回放线上流量做回归压测。
+//
+// gob流带有状态(类型定义只发送一次)，所以录制和回放都以整条连接为单位，
+// 每次Read/Write记为一帧，按原顺序重放就能得到服务端可解析的字节流。
+//
+// 录制文件由连续的帧组成，每帧：
+//
+//	Time(int64 unix纳秒) | Conn(uint32) | Dir(uint8) | Len(uint32) | Data
+package wire
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// 数据的方向，与录制的是客户端还是服务端无关
+type Dir uint8
+
+const (
+	Request  Dir = iota // 客户端发往服务端
+	Response            // 服务端发往客户端
+)
+
+func (d Dir) String() string {
+	switch d {
+	case Request:
+		return "request"
+	case Response:
+		return "response"
+	}
+	return fmt.Sprintf("Dir(%d)", uint8(d))
+}
+
+// 单帧的最大长度，防止读到损坏的文件时分配过大内存
+const maxFrameLen = 64 << 20
+
+const frameHeaderLen = 8 + 4 + 1 + 4
+
+// 一次Read或Write的数据
+type Frame struct {
+	Time time.Time
+	Conn uint32 // 同一录制文件内的连接编号，从1开始
+	Dir  Dir
+	Data []byte
+}
+
+func (f *Frame) String() string {
+	return fmt.Sprintf("%s conn=%d %s %d bytes", f.Time.Format(time.RFC3339Nano), f.Conn, f.Dir, len(f.Data))
+}
+
+// 写一帧
+func WriteFrame(w io.Writer, f *Frame) error {
+	buf := make([]byte, frameHeaderLen+len(f.Data))
+	binary.BigEndian.PutUint64(buf, uint64(f.Time.UnixNano()))
+	binary.BigEndian.PutUint32(buf[8:], f.Conn)
+	buf[12] = byte(f.Dir)
+	binary.BigEndian.PutUint32(buf[13:], uint32(len(f.Data)))
+	copy(buf[frameHeaderLen:], f.Data)
+	_, err := w.Write(buf)
+	return err
+}
+
+// 读一帧，文件结束时返回io.EOF
+func ReadFrame(r io.Reader) (*Frame, error) {
+	var h [frameHeaderLen]byte
+	if _, err := io.ReadFull(r, h[:]); err != nil {
+		return nil, err
+	}
+	n := binary.BigEndian.Uint32(h[13:])
+	if n > maxFrameLen {
+		return nil, fmt.Errorf("wire: frame too large: %d bytes", n)
+	}
+	f := &Frame{
+		Time: time.Unix(0, int64(binary.BigEndian.Uint64(h[:]))),
+		Conn: binary.BigEndian.Uint32(h[8:]),
+		Dir:  Dir(h[12]),
+		Data: make([]byte, n),
+	}
+	if _, err := io.ReadFull(r, f.Data); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return f, nil
+}
+
+// 读出全部帧
+func ReadAll(r io.Reader) ([]*Frame, error) {
+	var frames []*Frame
+	for {
+		f, err := ReadFrame(r)
+		if errors.Is(err, io.EOF) {
+			return frames, nil
+		}
+		if err != nil {
+			return frames, err
+		}
+		frames = append(frames, f)
+	}
+}
@@ -0,0 +1,98 @@
+package wire
+
+import (
+	"io"
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 把经过包装连接的数据写成帧，多条连接可以共用一个Recorder
+//
+//	rec := wire.NewRecorder(f)
+//	server.Accept(rec.Listener(lis))
+type Recorder struct {
+	mu     sync.Mutex // protect w
+	w      io.Writer
+	nextID uint32
+}
+
+func NewRecorder(w io.Writer) *Recorder {
+	return &Recorder{w: w}
+}
+
+func (r *Recorder) record(id uint32, dir Dir, data []byte) {
+	f := &Frame{Time: time.Now(), Conn: id, Dir: dir, Data: data}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	// 录制失败不应该影响业务连接
+	if err := WriteFrame(r.w, f); err != nil {
+		log.Println("wire: record frame error:", err)
+	}
+}
+
+func (r *Recorder) wrap(c net.Conn, server bool) net.Conn {
+	rc := &conn{Conn: c, r: r, id: atomic.AddUint32(&r.nextID, 1)}
+	if server {
+		rc.read, rc.write = Request, Response
+	} else {
+		rc.read, rc.write = Response, Request
+	}
+	return rc
+}
+
+// 包装客户端的连接，写出去的是请求
+//
+//	conn, _ := net.Dial("tcp", addr)
+//	client, _ := mrpc.NewClient(rec.Client(conn), codec.GobType)
+func (r *Recorder) Client(c net.Conn) net.Conn {
+	return r.wrap(c, false)
+}
+
+// 包装服务端的连接，读进来的是请求
+func (r *Recorder) Server(c net.Conn) net.Conn {
+	return r.wrap(c, true)
+}
+
+// 包装listener，Accept得到的连接都会被录制
+func (r *Recorder) Listener(l net.Listener) net.Listener {
+	return &listener{Listener: l, r: r}
+}
+
+type listener struct {
+	net.Listener
+	r *Recorder
+}
+
+func (l *listener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return l.r.Server(c), nil
+}
+
+type conn struct {
+	net.Conn
+	r           *Recorder
+	id          uint32
+	read, write Dir
+}
+
+func (c *conn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		c.r.record(c.id, c.read, p[:n])
+	}
+	return n, err
+}
+
+func (c *conn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	if n > 0 {
+		c.r.record(c.id, c.write, p[:n])
+	}
+	return n, err
+}
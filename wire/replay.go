@@ -0,0 +1,163 @@
+package wire
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 把录下的请求按连接重新发给服务端
+//
+//	frames, _ := wire.ReadAll(f)
+//	r := &wire.Replayer{Dial: func() (net.Conn, error) { return net.Dial("tcp", addr) }, Speed: 1}
+//	stats, err := r.Replay(frames)
+type Replayer struct {
+	Dial func() (net.Conn, error)
+	// 按录制时的间隔发送，Speed为倍速；<=0时不等待，尽快发出
+	Speed float64
+	// 请求发完后等待响应的最长时间，收到的字节数达到录制时的响应大小就提前结束。默认1秒
+	Wait time.Duration
+	// 每条连接重复回放的次数，默认1次
+	Loops int
+}
+
+// 回放结果
+type Stats struct {
+	Conns         int
+	Frames        int // 发送的请求帧数
+	BytesSent     int64
+	BytesReceived int64
+	Elapsed       time.Duration
+}
+
+// 同一条连接的请求帧，以及录制时收到的响应字节数
+type session struct {
+	id       uint32
+	requests []*Frame
+	expect   int64
+}
+
+func sessions(frames []*Frame) []*session {
+	byID := make(map[uint32]*session)
+	for _, f := range frames {
+		s := byID[f.Conn]
+		if s == nil {
+			s = &session{id: f.Conn}
+			byID[f.Conn] = s
+		}
+		if f.Dir == Request {
+			s.requests = append(s.requests, f)
+		} else {
+			s.expect += int64(len(f.Data))
+		}
+	}
+	list := make([]*session, 0, len(byID))
+	for _, s := range byID {
+		if len(s.requests) > 0 {
+			list = append(list, s)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].id < list[j].id })
+	return list
+}
+
+// 每条录制的连接各自建立新连接并发回放，返回第一个遇到的错误
+func (r *Replayer) Replay(frames []*Frame) (Stats, error) {
+	if r.Dial == nil {
+		return Stats{}, errors.New("wire: Replayer.Dial is nil")
+	}
+	loops := r.Loops
+	if loops <= 0 {
+		loops = 1
+	}
+	var (
+		start     = time.Now()
+		wg        sync.WaitGroup
+		errOnce   sync.Once
+		firstErr  error
+		sent, rcv int64
+		nframes   int64
+	)
+	list := sessions(frames)
+	for _, s := range list {
+		wg.Add(1)
+		go func(s *session) {
+			defer wg.Done()
+			for i := 0; i < loops; i++ {
+				n, out, in, err := r.replaySession(s)
+				atomic.AddInt64(&nframes, int64(n))
+				atomic.AddInt64(&sent, out)
+				atomic.AddInt64(&rcv, in)
+				if err != nil {
+					errOnce.Do(func() { firstErr = err })
+					return
+				}
+			}
+		}(s)
+	}
+	wg.Wait()
+	return Stats{
+		Conns:         len(list) * loops,
+		Frames:        int(nframes),
+		BytesSent:     sent,
+		BytesReceived: rcv,
+		Elapsed:       time.Since(start),
+	}, firstErr
+}
+
+func (r *Replayer) replaySession(s *session) (frames int, sent, received int64, err error) {
+	conn, err := r.Dial()
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	defer conn.Close()
+
+	wait := r.Wait
+	if wait <= 0 {
+		wait = time.Second
+	}
+	// 持续读响应，直到读够录制时的字节数或者空闲超过wait
+	var rcv int64
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		buf := make([]byte, 32<<10)
+		for {
+			conn.SetReadDeadline(time.Now().Add(wait))
+			n, err := conn.Read(buf)
+			if err != nil {
+				return
+			}
+			if atomic.AddInt64(&rcv, int64(n)) >= s.expect && s.expect > 0 {
+				return
+			}
+		}
+	}()
+
+	begin, first := time.Now(), s.requests[0].Time
+	for _, f := range s.requests {
+		if r.Speed > 0 {
+			at := time.Duration(float64(f.Time.Sub(first)) / r.Speed)
+			if d := at - time.Since(begin); d > 0 {
+				time.Sleep(d)
+			}
+		}
+		if _, err = conn.Write(f.Data); err != nil {
+			break
+		}
+		frames++
+		sent += int64(len(f.Data))
+	}
+	if err != nil {
+		conn.Close()
+	}
+	<-done
+	if errors.Is(err, io.EOF) {
+		err = nil
+	}
+	return frames, sent, atomic.LoadInt64(&rcv), err
+}
@@ -0,0 +1,145 @@
+package wire
+
+import (
+	"bytes"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/micplus/mrpc"
+)
+
+type Counter struct {
+	n int64
+}
+
+func (c *Counter) Add(delta int, reply *int64) error {
+	*reply = atomic.AddInt64(&c.n, int64(delta))
+	return nil
+}
+
+// 服务端写完响应后才记录，可能晚于客户端收到响应，读录制结果要加锁
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) Bytes() []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]byte(nil), b.buf.Bytes()...)
+}
+
+// 服务端关闭连接时通知，这时连接上的响应都已录制
+type closeListener struct {
+	net.Listener
+	closed chan struct{}
+}
+
+func (l *closeListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &closeConn{Conn: c, closed: l.closed}, nil
+}
+
+type closeConn struct {
+	net.Conn
+	closed chan struct{}
+	once   sync.Once
+}
+
+func (c *closeConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(func() { c.closed <- struct{}{} })
+	return err
+}
+
+func serve(t *testing.T, rcvr any, wrap func(net.Listener) net.Listener) *mrpc.PipeListener {
+	s := mrpc.NewServer()
+	if err := s.Register(rcvr); err != nil {
+		t.Fatal(err)
+	}
+	lis := mrpc.NewPipeListener()
+	var l net.Listener = lis
+	if wrap != nil {
+		l = wrap(lis)
+	}
+	go s.Accept(l)
+	t.Cleanup(func() { lis.Close() })
+	return lis
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	var buf syncBuffer
+	rec := NewRecorder(&buf)
+	closed := make(chan struct{}, 2)
+	lis := serve(t, new(Counter), func(l net.Listener) net.Listener {
+		return &closeListener{Listener: rec.Listener(l), closed: closed}
+	})
+	for c := 0; c < 2; c++ {
+		client, err := lis.DialClient(0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 1; i <= 3; i++ {
+			var n int64
+			if err := client.Call("Counter.Add", i, &n); err != nil {
+				t.Fatal(err)
+			}
+		}
+		client.Close()
+	}
+	// 客户端可能在服务端录下最后一个响应之前就关闭了，等服务端关闭连接
+	<-closed
+	<-closed
+
+	frames, err := ReadAll(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var requests, responses int
+	for _, f := range frames {
+		if f.Dir == Request {
+			requests++
+		} else {
+			responses++
+		}
+	}
+	if requests == 0 || responses == 0 || frames[len(frames)-1].Conn != 2 {
+		t.Fatalf("unexpected recording: %d requests, %d responses, %d frames", requests, responses, len(frames))
+	}
+
+	// 回放到一个新的服务端，每条连接的三次调用都会再执行一遍
+	counter := new(Counter)
+	target := serve(t, counter, nil)
+	r := &Replayer{Dial: target.Dial, Speed: 10, Wait: time.Second, Loops: 2}
+	stats, err := r.Replay(frames)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Conns != 4 || stats.Frames != 2*requests || stats.BytesReceived == 0 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+	if n := atomic.LoadInt64(&counter.n); n != 4*(1+2+3) {
+		t.Errorf("replayed calls added up to %d, want %d", n, 4*(1+2+3))
+	}
+}
+
+func TestReadFrameTruncated(t *testing.T) {
+	var buf bytes.Buffer
+	WriteFrame(&buf, &Frame{Time: time.Now(), Conn: 1, Data: []byte("hello")})
+	_, err := ReadAll(bytes.NewReader(buf.Bytes()[:buf.Len()-1]))
+	if err == nil {
+		t.Error("want error for truncated frame")
+	}
+}
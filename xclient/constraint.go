@@ -0,0 +1,152 @@
+package xclient
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// 元数据约束：服务端把版本、标签等写进实例元数据并发布到注册中心，
+// 客户端用"version>=2"、"canary=false"这样的表达式筛选实例，用于灰度发布
+
+// 一条约束，形如key op value
+type Constraint struct {
+	Key   string
+	Op    string // = != > >= < <=
+	Value string
+}
+
+// 按长度降序排列，保证">="先于">"被匹配
+var constraintOps = []string{"==", "!=", ">=", "<=", "=", ">", "<"}
+
+// 解析一条约束表达式
+func ParseConstraint(expr string) (Constraint, error) {
+	i := strings.IndexAny(expr, "=!<>")
+	if i > 0 {
+		for _, op := range constraintOps {
+			if !strings.HasPrefix(expr[i:], op) {
+				continue
+			}
+			c := Constraint{
+				Key:   strings.TrimSpace(expr[:i]),
+				Op:    op,
+				Value: strings.TrimSpace(expr[i+len(op):]),
+			}
+			if c.Op == "==" {
+				c.Op = "="
+			}
+			if c.Key != "" {
+				return c, nil
+			}
+		}
+	}
+	return Constraint{}, fmt.Errorf("rpc xclient: invalid constraint %q", expr)
+}
+
+// 解析多条约束，它们之间是"与"的关系
+func ParseConstraints(exprs ...string) ([]Constraint, error) {
+	cs := make([]Constraint, 0, len(exprs))
+	for _, expr := range exprs {
+		c, err := ParseConstraint(expr)
+		if err != nil {
+			return nil, err
+		}
+		cs = append(cs, c)
+	}
+	return cs, nil
+}
+
+func (c Constraint) String() string {
+	return c.Key + c.Op + c.Value
+}
+
+// 判断元数据是否满足约束。缺失的键按空串处理，与"false"比较时空串视为false，
+// 所以"canary=false"也能选中没有标记canary的实例
+func (c Constraint) Match(meta map[string]string) bool {
+	v := meta[c.Key]
+	if v == "" && c.Value == "false" {
+		v = "false"
+	}
+	cmp := compareVersion(v, c.Value)
+	switch c.Op {
+	case "=":
+		return v == c.Value
+	case "!=":
+		return v != c.Value
+	case ">":
+		return v != "" && cmp > 0
+	case ">=":
+		return v != "" && cmp >= 0
+	case "<":
+		return v != "" && cmp < 0
+	case "<=":
+		return v != "" && cmp <= 0
+	}
+	return false
+}
+
+// 按"."分段比较，数字段按数值比较，否则按字符串比较；"1.10" > "1.9"，"v10" > "v2"
+func compareVersion(a, b string) int {
+	as, bs := strings.Split(strings.TrimPrefix(a, "v"), "."), strings.Split(strings.TrimPrefix(b, "v"), ".")
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		var x, y string
+		if i < len(as) {
+			x = as[i]
+		}
+		if i < len(bs) {
+			y = bs[i]
+		}
+		xn, errx := strconv.ParseFloat(x, 64)
+		yn, erry := strconv.ParseFloat(y, 64)
+		if x == "" {
+			xn, errx = 0, nil
+		}
+		if y == "" {
+			yn, erry = 0, nil
+		}
+		switch {
+		case errx == nil && erry == nil:
+			if xn != yn {
+				if xn < yn {
+					return -1
+				}
+				return 1
+			}
+		case x != y:
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+func matchAll(cs []Constraint, meta map[string]string) bool {
+	for _, c := range cs {
+		if !c.Match(meta) {
+			return false
+		}
+	}
+	return true
+}
+
+// 只在满足全部约束的实例中选择
+func WithConstraints(cs ...Constraint) Option {
+	return func(xc *XClient) {
+		xc.constraints = append(xc.constraints, cs...)
+	}
+}
+
+func (xc *XClient) filterConstraints(endpoints []Endpoint) []Endpoint {
+	if len(xc.constraints) == 0 {
+		return endpoints
+	}
+	matched := make([]Endpoint, 0, len(endpoints))
+	for _, ep := range endpoints {
+		if matchAll(xc.constraints, ep.Meta) {
+			matched = append(matched, ep)
+		}
+	}
+	return matched
+}
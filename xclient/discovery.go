@@ -0,0 +1,46 @@
+package xclient
+
+import (
+	"errors"
+	"strings"
+)
+
+// 一个服务通常由多个实例提供，客户端需要先发现它们，再从中挑选一个发起调用。
+// 服务发现只负责"有哪些实例"，挑选哪一个由XClient按SelectMode决定。
+
+// 服务实例
+type Endpoint struct {
+	// 形如"tcp@127.0.0.1:1234"，省略"network@"时默认为tcp
+	Addr string
+	// 权重，<=0时按1处理
+	Weight int
+	// 实例附带的元数据，如版本、机房等
+	Meta map[string]string
+}
+
+// 拆出Addr中的网络类型和地址
+func (e Endpoint) network() (network, address string) {
+	if i := strings.Index(e.Addr, "@"); i >= 0 {
+		return e.Addr[:i], e.Addr[i+1:]
+	}
+	return "tcp", e.Addr
+}
+
+func (e Endpoint) weight() int {
+	if e.Weight <= 0 {
+		return 1
+	}
+	return e.Weight
+}
+
+var ErrNoEndpoint = errors.New("rpc discovery: no available endpoints")
+
+// 服务发现接口，可以由静态列表、配置文件、注册中心等实现
+type Discovery interface {
+	// 从数据源重新拉取实例列表
+	Refresh() error
+	// 手动覆盖实例列表
+	Update(endpoints []Endpoint) error
+	// 返回当前已知的全部实例，调用方不应修改返回的切片
+	GetAll() ([]Endpoint, error)
+}
@@ -0,0 +1,175 @@
+package xclient
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 固定的实例列表，适合实例很少且不常变化的场景
+type StaticDiscovery struct {
+	mu        sync.RWMutex // protect following
+	endpoints []Endpoint
+}
+
+var _ Discovery = (*StaticDiscovery)(nil)
+
+func NewStaticDiscovery(endpoints ...Endpoint) *StaticDiscovery {
+	return &StaticDiscovery{endpoints: endpoints}
+}
+
+// 静态列表没有数据源，不需要刷新
+func (d *StaticDiscovery) Refresh() error {
+	return nil
+}
+
+func (d *StaticDiscovery) Update(endpoints []Endpoint) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.endpoints = endpoints
+	return nil
+}
+
+func (d *StaticDiscovery) GetAll() ([]Endpoint, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.endpoints, nil
+}
+
+// 从文件读取实例列表，并定期检查文件变化后重新加载。
+// 文件每行一个实例，"#"开头为注释：
+//
+//	tcp@10.0.0.1:8001 weight=10 zone=a
+//	10.0.0.2:8001
+//
+// 除weight外的key=value都作为元数据
+type FileDiscovery struct {
+	StaticDiscovery
+	path      string
+	interval  time.Duration
+	closeOnce sync.Once
+	done      chan struct{}
+
+	statMu  sync.Mutex // protect following
+	modTime time.Time
+	size    int64
+}
+
+var _ Discovery = (*FileDiscovery)(nil)
+
+const defaultWatchInterval = 2 * time.Second
+
+// 立即加载一次，加载失败返回错误；interval<=0时使用默认的检查间隔
+func NewFileDiscovery(path string, interval time.Duration) (*FileDiscovery, error) {
+	if interval <= 0 {
+		interval = defaultWatchInterval
+	}
+	d := &FileDiscovery{
+		path:     path,
+		interval: interval,
+		done:     make(chan struct{}),
+	}
+	if err := d.Refresh(); err != nil {
+		return nil, err
+	}
+	go d.watch()
+	return d, nil
+}
+
+// 轮询文件的修改时间和大小，不依赖平台相关的文件通知机制
+func (d *FileDiscovery) watch() {
+	t := time.NewTicker(d.interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-d.done:
+			return
+		case <-t.C:
+			fi, err := os.Stat(d.path)
+			if err != nil {
+				log.Println("rpc discovery: stat file error:", err)
+				continue
+			}
+			d.statMu.Lock()
+			same := fi.ModTime().Equal(d.modTime) && fi.Size() == d.size
+			d.statMu.Unlock()
+			if same {
+				continue
+			}
+			// 文件写到一半时可能解析失败，保留旧列表等下一轮
+			if err := d.Refresh(); err != nil {
+				log.Println("rpc discovery: reload file error:", err)
+			}
+		}
+	}
+}
+
+// 重新读取文件
+func (d *FileDiscovery) Refresh() error {
+	fi, err := os.Stat(d.path)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(d.path)
+	if err != nil {
+		return err
+	}
+	endpoints, err := ParseEndpoints(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("%s: %w", d.path, err)
+	}
+	d.statMu.Lock()
+	d.modTime, d.size = fi.ModTime(), fi.Size()
+	d.statMu.Unlock()
+	return d.Update(endpoints)
+}
+
+// 停止监视文件
+func (d *FileDiscovery) Close() error {
+	d.closeOnce.Do(func() { close(d.done) })
+	return nil
+}
+
+// 解析FileDiscovery使用的文本格式
+func ParseEndpoints(r io.Reader) ([]Endpoint, error) {
+	var endpoints []Endpoint
+	sc := bufio.NewScanner(r)
+	for line := 1; sc.Scan(); line++ {
+		text := sc.Text()
+		if i := strings.Index(text, "#"); i >= 0 {
+			text = text[:i]
+		}
+		fields := strings.Fields(text)
+		if len(fields) == 0 {
+			continue
+		}
+		ep := Endpoint{Addr: fields[0]}
+		for _, kv := range fields[1:] {
+			k, v, ok := strings.Cut(kv, "=")
+			if !ok {
+				return nil, fmt.Errorf("line %d: want key=value, got %q", line, kv)
+			}
+			if k == "weight" {
+				w, err := strconv.Atoi(v)
+				if err != nil {
+					return nil, fmt.Errorf("line %d: invalid weight %q", line, v)
+				}
+				ep.Weight = w
+				continue
+			}
+			if ep.Meta == nil {
+				ep.Meta = make(map[string]string)
+			}
+			ep.Meta[k] = v
+		}
+		endpoints = append(endpoints, ep)
+	}
+	return endpoints, sc.Err()
+}
@@ -0,0 +1,101 @@
+package xclient
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// 从可用实例中随机挑出n个，n<=0或超过实例数时返回全部
+func (xc *XClient) pick(n int) ([]Endpoint, error) {
+	endpoints, err := xc.available()
+	if err != nil {
+		return nil, err
+	}
+	if len(endpoints) == 0 {
+		return nil, ErrNoEndpoint
+	}
+	if n <= 0 || n >= len(endpoints) {
+		return endpoints, nil
+	}
+	picked := make([]Endpoint, len(endpoints))
+	copy(picked, endpoints) // 不能打乱discovery持有的切片
+	xc.mu.Lock()
+	xc.rnd.Shuffle(len(picked), func(i, j int) { picked[i], picked[j] = picked[j], picked[i] })
+	xc.mu.Unlock()
+	return picked[:n], nil
+}
+
+// 为每个并发调用准备独立的reply，避免多个响应同时写同一个对象
+func newReplyLike(reply any) any {
+	if reply == nil {
+		return nil
+	}
+	return reflect.New(reflect.TypeOf(reply).Elem()).Interface()
+}
+
+// 同时向n个实例发起相同的调用(n<=0表示全部)，采用最先成功的结果。
+// 全部失败时返回其中一个错误。适合从多个副本中读取、取最快的场景
+func (xc *XClient) Fork(n int, name string, args, reply any) error {
+	endpoints, err := xc.pick(n)
+	if err != nil {
+		return err
+	}
+
+	type result struct {
+		reply any
+		err   error
+	}
+	// 带缓冲，慢的调用完成后不会阻塞
+	results := make(chan result, len(endpoints))
+	for _, ep := range endpoints {
+		go func(ep Endpoint) {
+			r := newReplyLike(reply)
+			results <- result{r, xc.call(context.Background(), ep, name, args, r)}
+		}(ep)
+	}
+
+	var firstErr error
+	for range endpoints {
+		r := <-results
+		if r.err == nil {
+			if reply != nil {
+				reflect.ValueOf(reply).Elem().Set(reflect.ValueOf(r.reply).Elem())
+			}
+			return nil
+		}
+		if firstErr == nil {
+			firstErr = r.err
+		}
+	}
+	return fmt.Errorf("rpc xclient: all %d forked calls failed: %w", len(endpoints), firstErr)
+}
+
+// ForkAll中每个实例的调用结果
+type ForkResult struct {
+	Endpoint Endpoint
+	Reply    any // 与传入的reply同类型的指针
+	Error    error
+}
+
+// 向全部可用实例发起相同的调用并等待全部完成，返回每个实例的结果，
+// 由调用方决定如何合并。reply只用来确定返回值的类型
+func (xc *XClient) ForkAll(name string, args, reply any) ([]ForkResult, error) {
+	endpoints, err := xc.pick(0)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]ForkResult, len(endpoints))
+	var wg sync.WaitGroup
+	for i, ep := range endpoints {
+		wg.Add(1)
+		go func(i int, ep Endpoint) {
+			defer wg.Done()
+			r := newReplyLike(reply)
+			results[i] = ForkResult{Endpoint: ep, Reply: r, Error: xc.call(context.Background(), ep, name, args, r)}
+		}(i, ep)
+	}
+	wg.Wait()
+	return results, nil
+}
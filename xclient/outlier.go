@@ -0,0 +1,227 @@
+package xclient
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/micplus/mrpc"
+)
+
+// 异常实例检测：统计每个实例的失败和延迟，把异常的实例暂时摘除，
+// 摘除期满后先放行试探请求，成功才恢复，失败则加倍摘除时长
+type OutlierConfig struct {
+	// 连续失败多少次后摘除，默认5
+	ConsecutiveErrors int
+	// 统计窗口内的失败率达到该值时摘除，0表示不按失败率判断
+	ErrorRate float64
+	// 计算失败率需要的最少请求数，默认10
+	MinRequests int
+	// 失败率的统计窗口，默认10s
+	Window time.Duration
+	// 平均延迟超过该值时摘除，0表示不按延迟判断
+	SlowThreshold time.Duration
+	// 第n次摘除持续n*BaseEjection，默认30s
+	BaseEjection time.Duration
+	// 同一时刻最多摘除的实例比例(百分比)，默认50
+	MaxEjectionPercent int
+	// 服务端返回的业务错误(ServerError或带错误码的*mrpc.Error)是否算作失败，
+	// 默认只统计连接、超时等传输层错误
+	CountServerErrors bool
+}
+
+func (cfg *OutlierConfig) setDefaults() {
+	if cfg.ConsecutiveErrors <= 0 {
+		cfg.ConsecutiveErrors = 5
+	}
+	if cfg.MinRequests <= 0 {
+		cfg.MinRequests = 10
+	}
+	if cfg.Window <= 0 {
+		cfg.Window = 10 * time.Second
+	}
+	if cfg.BaseEjection <= 0 {
+		cfg.BaseEjection = 30 * time.Second
+	}
+	if cfg.MaxEjectionPercent <= 0 {
+		cfg.MaxEjectionPercent = 50
+	}
+}
+
+// 延迟的指数加权平均系数
+const latencyDecay = 0.2
+
+type endpointHealth struct {
+	consecutive   int
+	windowStart   time.Time
+	total, failed int
+	latency       time.Duration
+
+	ejections    int       // 累计摘除次数，恢复后清零
+	ejectedUntil time.Time // 摘除截止时间
+	probation    bool      // 摘除期满，等待试探结果
+	probing      bool      // 试探请求正在进行
+}
+
+type outlierDetector struct {
+	cfg OutlierConfig
+
+	mu     sync.Mutex // protect following
+	health map[string]*endpointHealth
+	known  int // 最近一次看到的实例数，用来计算摘除比例
+}
+
+func newOutlierDetector(cfg OutlierConfig) *outlierDetector {
+	cfg.setDefaults()
+	return &outlierDetector{
+		cfg:    cfg,
+		health: make(map[string]*endpointHealth),
+	}
+}
+
+// 去掉被摘除的实例；全部被摘除时返回原列表，总比没有可用实例好
+func (o *outlierDetector) filter(endpoints []Endpoint, now time.Time) []Endpoint {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.known = len(endpoints)
+	available := make([]Endpoint, 0, len(endpoints))
+	for _, ep := range endpoints {
+		h := o.health[ep.Addr]
+		if h != nil && !o.admit(h, now) {
+			continue
+		}
+		available = append(available, ep)
+	}
+	if len(available) == 0 {
+		return endpoints
+	}
+	return available
+}
+
+// 判断实例能否接收请求；摘除期满的实例进入观察期，一次只放行一个试探请求
+func (o *outlierDetector) admit(h *endpointHealth, now time.Time) bool {
+	if now.Before(h.ejectedUntil) {
+		return false
+	}
+	if !h.ejectedUntil.IsZero() {
+		h.ejectedUntil = time.Time{}
+		h.probation = true
+	}
+	return !h.probing
+}
+
+// 选中实例后调用，观察期的实例标记为正在试探
+func (o *outlierDetector) begin(addr string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if h := o.health[addr]; h != nil && h.probation {
+		h.probing = true
+	}
+}
+
+// 是否计为失败。服务端返回了错误说明实例是健康的，不论有没有错误码
+func (o *outlierDetector) isFailure(err error) bool {
+	if err == nil {
+		return false
+	}
+	var se mrpc.ServerError
+	var coded *mrpc.Error
+	if errors.As(err, &se) || errors.As(err, &coded) {
+		return o.cfg.CountServerErrors
+	}
+	return true
+}
+
+// 记录一次调用的结果，返回实例是否刚从摘除中恢复
+func (o *outlierDetector) report(addr string, latency time.Duration, err error, now time.Time) (recovered bool) {
+	failed := o.isFailure(err)
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	h := o.health[addr]
+	if h == nil {
+		h = &endpointHealth{windowStart: now, latency: latency}
+		o.health[addr] = h
+	}
+	if now.Sub(h.windowStart) > o.cfg.Window {
+		h.windowStart, h.total, h.failed = now, 0, 0
+	}
+	h.total++
+	h.latency += time.Duration(latencyDecay * float64(latency-h.latency))
+	if failed {
+		h.failed++
+		h.consecutive++
+	} else {
+		h.consecutive = 0
+	}
+
+	if h.probation {
+		h.probing = false
+		if failed || (o.cfg.SlowThreshold > 0 && latency > o.cfg.SlowThreshold) {
+			o.eject(h, now)
+		} else { // 试探成功，恢复
+			h.probation = false
+			h.ejections = 0
+			h.windowStart, h.total, h.failed = now, 0, 0
+			h.latency = latency
+			return true
+		}
+		return false
+	}
+	if o.outlier(h) && o.canEject(now) {
+		o.eject(h, now)
+	}
+	return false
+}
+
+func (o *outlierDetector) slow(h *endpointHealth) bool {
+	return o.cfg.SlowThreshold > 0 && h.latency > o.cfg.SlowThreshold
+}
+
+func (o *outlierDetector) outlier(h *endpointHealth) bool {
+	if h.consecutive >= o.cfg.ConsecutiveErrors || o.slow(h) {
+		return true
+	}
+	return o.cfg.ErrorRate > 0 && h.total >= o.cfg.MinRequests &&
+		float64(h.failed)/float64(h.total) >= o.cfg.ErrorRate
+}
+
+// 摘除数量不能超过上限，以免误判时把整个集群都摘掉
+func (o *outlierDetector) canEject(now time.Time) bool {
+	ejected := 0
+	for _, h := range o.health {
+		if now.Before(h.ejectedUntil) {
+			ejected++
+		}
+	}
+	return (ejected+1)*100 <= o.known*o.cfg.MaxEjectionPercent
+}
+
+func (o *outlierDetector) eject(h *endpointHealth, now time.Time) {
+	h.ejections++
+	h.ejectedUntil = now.Add(time.Duration(h.ejections) * o.cfg.BaseEjection)
+	h.probation, h.probing = false, false
+	h.consecutive = 0
+	h.windowStart, h.total, h.failed = now, 0, 0
+}
+
+// 实例当前是否未被摘除，只读不改变状态
+func (o *outlierDetector) healthy(addr string, now time.Time) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	h := o.health[addr]
+	return h == nil || !now.Before(h.ejectedUntil)
+}
+
+// 当前处于摘除状态的实例
+func (o *outlierDetector) ejected(now time.Time) []string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	var addrs []string
+	for addr, h := range o.health {
+		if now.Before(h.ejectedUntil) {
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs
+}
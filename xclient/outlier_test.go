@@ -0,0 +1,150 @@
+package xclient
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/micplus/mrpc"
+)
+
+func TestOutlierEjectAndProbe(t *testing.T) {
+	o := newOutlierDetector(OutlierConfig{ConsecutiveErrors: 2, BaseEjection: time.Second})
+	eps := []Endpoint{{Addr: "a"}, {Addr: "b"}}
+	now := time.Now()
+	errDial := errors.New("dial error")
+
+	o.filter(eps, now)
+	o.report("a", time.Millisecond, mrpc.ServerError("not found"), now)
+	o.report("a", time.Millisecond, mrpc.ServerError("not found"), now)
+	if got := o.filter(eps, now); len(got) != 2 {
+		t.Fatalf("server errors should not eject by default, got %v", got)
+	}
+
+	o.report("a", time.Millisecond, errDial, now)
+	o.report("a", time.Millisecond, errDial, now)
+	if got := o.filter(eps, now); len(got) != 1 || got[0].Addr != "b" {
+		t.Fatalf("a should be ejected, got %v", got)
+	}
+
+	// 期满后放行一个试探请求，试探失败则再次摘除且时间加倍
+	now = now.Add(1100 * time.Millisecond)
+	if got := o.filter(eps, now); len(got) != 2 {
+		t.Fatalf("a should be probed after ejection, got %v", got)
+	}
+	o.begin("a")
+	if got := o.filter(eps, now); len(got) != 1 {
+		t.Fatalf("only one probe at a time, got %v", got)
+	}
+	o.report("a", time.Millisecond, errDial, now)
+	if got := o.filter(eps, now.Add(1500*time.Millisecond)); len(got) != 1 {
+		t.Fatalf("second ejection should last 2s, got %v", got)
+	}
+
+	now = now.Add(2100 * time.Millisecond)
+	o.filter(eps, now)
+	o.begin("a")
+	if !o.report("a", time.Millisecond, nil, now) {
+		t.Fatal("a successful probe should report recovery")
+	}
+	if got := o.filter(eps, now); len(got) != 2 || len(o.ejected(now)) != 0 {
+		t.Fatalf("a should be readmitted after a successful probe, got %v", got)
+	}
+}
+
+// 带错误码的错误同样是服务端返回的，包括解码器转换后的
+func TestOutlierCodedErrors(t *testing.T) {
+	coded := mrpc.Errorf(mrpc.FailedPrecondition, "insufficient funds")
+	for _, err := range []error{coded, fmt.Errorf("decoded: %w", coded)} {
+		o := newOutlierDetector(OutlierConfig{ConsecutiveErrors: 2, BaseEjection: time.Second})
+		eps := []Endpoint{{Addr: "a"}, {Addr: "b"}}
+		now := time.Now()
+		o.filter(eps, now)
+		o.report("a", time.Millisecond, err, now)
+		o.report("a", time.Millisecond, err, now)
+		if got := o.filter(eps, now); len(got) != 2 {
+			t.Fatalf("%v: coded errors should not eject by default, got %v", err, got)
+		}
+
+		o = newOutlierDetector(OutlierConfig{ConsecutiveErrors: 2, BaseEjection: time.Second, CountServerErrors: true})
+		o.filter(eps, now)
+		o.report("a", time.Millisecond, err, now)
+		o.report("a", time.Millisecond, err, now)
+		if got := o.filter(eps, now); len(got) != 1 {
+			t.Fatalf("%v: coded errors should eject with CountServerErrors, got %v", err, got)
+		}
+	}
+}
+
+func TestSlowStart(t *testing.T) {
+	s := newSlowStart(SlowStartConfig{Window: 10 * time.Second, MinPercent: 10})
+	a, b, c := Endpoint{Addr: "a"}, Endpoint{Addr: "b"}, Endpoint{Addr: "c"}
+	now := time.Now()
+	s.observe([]Endpoint{a, b}, now)
+	if w := s.warming(now); len(w) != 0 {
+		t.Fatalf("the first list should not warm up, got %v", w)
+	}
+
+	s.observe([]Endpoint{a, b, c}, now)
+	for _, tc := range []struct {
+		elapsed time.Duration
+		share   float64
+	}{{0, 0.1}, {5 * time.Second, 0.55}, {10 * time.Second, 1}} {
+		if got := s.share("c", now.Add(tc.elapsed)); got < tc.share-1e-9 || got > tc.share+1e-9 {
+			t.Errorf("share after %v: want %v, got %v", tc.elapsed, tc.share, got)
+		}
+	}
+
+	// 预热结束后再消失、出现，重新预热，份额约为MinPercent
+	s.observe([]Endpoint{a, b}, now)
+	s.observe([]Endpoint{a, b, c}, now)
+	const n = 2000
+	kept := 0
+	for i := 0; i < n; i++ {
+		for _, ep := range s.filter([]Endpoint{a, b, c}, now) {
+			if ep.Addr == "c" {
+				kept++
+			}
+		}
+	}
+	if kept < n/20 || kept > n/5 {
+		t.Errorf("c was kept %d of %d times, want about 10%%", kept, n)
+	}
+	// 只有预热中的实例时不去掉它
+	if got := s.filter([]Endpoint{c}, now); len(got) != 1 {
+		t.Errorf("the only endpoint should be kept, got %v", got)
+	}
+
+	s.restart("a", now)
+	if w := s.warming(now.Add(time.Second)); len(w) != 2 {
+		t.Errorf("a and c should be warming, got %v", w)
+	}
+}
+
+func TestLocalityPrefer(t *testing.T) {
+	l := &Locality{Region: "r1", Zone: "z1", MinHealthyPercent: 50}
+	eps := []Endpoint{
+		{Addr: "a", Meta: map[string]string{MetaRegion: "r1", MetaZone: "z1"}},
+		{Addr: "b", Meta: map[string]string{MetaRegion: "r1", MetaZone: "z2"}},
+		{Addr: "c", Meta: map[string]string{MetaRegion: "r2", MetaZone: "z3"}},
+	}
+	addrs := func(eps []Endpoint) (s string) {
+		for _, ep := range eps {
+			s += ep.Addr
+		}
+		return
+	}
+	all := func(Endpoint) bool { return true }
+	if got := addrs(l.prefer(eps, all)); got != "a" {
+		t.Errorf("healthy local zone: got %s, want a", got)
+	}
+	notA := func(ep Endpoint) bool { return ep.Addr != "a" }
+	if got := addrs(l.prefer(eps, notA)); got != "ab" {
+		t.Errorf("local zone down: got %s, want ab", got)
+	}
+	onlyC := func(ep Endpoint) bool { return ep.Addr == "c" }
+	if got := addrs(l.prefer(eps, onlyC)); got != "abc" {
+		t.Errorf("region down: got %s, want abc", got)
+	}
+}
@@ -0,0 +1,110 @@
+package xclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strconv"
+)
+
+// 分片路由：后端按分片部署时，用路由键(如用户ID)算出分片号，
+// 只在属于该分片的实例中选择。实例通过元数据MetaShard声明自己所在的分片
+
+// 元数据中表示分片号的键，值为从0开始的整数
+const MetaShard = "shard"
+
+// 把路由键映射到[0, shards)中的一个分片
+type ShardFunc func(key string, shards int) int
+
+func hashKey(key string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	return h.Sum64()
+}
+
+// 取模分片：键是整数时直接取模，否则对哈希值取模
+func ModuloShard(key string, shards int) int {
+	if n, err := strconv.ParseUint(key, 10, 64); err == nil {
+		return int(n % uint64(shards))
+	}
+	return int(hashKey(key) % uint64(shards))
+}
+
+// Jump一致性哈希(Lamping & Veach)：分片数变化时只有约1/n的键需要迁移
+func JumpHashShard(key string, shards int) int {
+	k := hashKey(key)
+	var b, j int64 = -1, 0
+	for j < int64(shards) {
+		b = j
+		k = k*2862933555777941757 + 1
+		j = int64(float64(b+1) * (float64(int64(1)<<31) / float64((k>>33)+1)))
+	}
+	return int(b)
+}
+
+// 范围分片：bounds为升序的上界，bounds[i]之前(不含)的键属于分片i，
+// 不小于最后一个上界的键属于最后一个分片，共len(bounds)+1个分片
+func RangeShard(bounds ...string) ShardFunc {
+	return func(key string, shards int) int {
+		i := sort.SearchStrings(bounds, key)
+		if i < len(bounds) && bounds[i] == key {
+			i++ // 上界本身属于下一个分片
+		}
+		if i >= shards {
+			i = shards - 1
+		}
+		return i
+	}
+}
+
+type shardRouter struct {
+	fn     ShardFunc
+	shards int // <=0时根据实例元数据推算
+}
+
+// 按分片号分组，并确定分片总数
+func (r *shardRouter) route(key string, endpoints []Endpoint) ([]Endpoint, error) {
+	shards := r.shards
+	groups := make(map[int][]Endpoint)
+	for _, ep := range endpoints {
+		s, err := strconv.Atoi(ep.Meta[MetaShard])
+		if err != nil {
+			continue // 没有声明分片的实例不参与分片路由
+		}
+		groups[s] = append(groups[s], ep)
+		if r.shards <= 0 && s+1 > shards {
+			shards = s + 1
+		}
+	}
+	if shards <= 0 {
+		return nil, ErrNoEndpoint
+	}
+	shard := r.fn(key, shards)
+	if len(groups[shard]) == 0 {
+		return nil, fmt.Errorf("rpc xclient: no endpoint for shard %d (key %q)", shard, key)
+	}
+	return groups[shard], nil
+}
+
+// 开启分片路由，shards为分片总数，<=0表示按实例声明的最大分片号推算；
+// fn为nil时使用JumpHashShard
+func WithSharding(shards int, fn ShardFunc) Option {
+	if fn == nil {
+		fn = JumpHashShard
+	}
+	return func(xc *XClient) {
+		xc.shard = &shardRouter{fn: fn, shards: shards}
+	}
+}
+
+// 按路由键找到所属分片，在该分片的实例中选择一个发起调用
+func (xc *XClient) CallKey(key string, name string, args, reply any) error {
+	if xc.shard == nil {
+		return errors.New("rpc xclient: sharding is not enabled")
+	}
+	return xc.invoke(context.Background(), func(endpoints []Endpoint) ([]Endpoint, error) {
+		return xc.shard.route(key, endpoints)
+	}, name, args, reply)
+}
@@ -0,0 +1,116 @@
+package xclient
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// 流量切分：按注册中心的实例元数据把实例分成若干组，每次调用按百分比选一组，
+// 再在组内按负载均衡策略选实例。各组分别统计调用数、错误数和耗时，
+// 灰度发布时在客户端就能比较新旧版本
+//
+//	stable, _ := xclient.ParseConstraints("track!=canary")
+//	canary, _ := xclient.ParseConstraints("track=canary")
+//	xc := xclient.NewXClient(d, xclient.RandomSelect, xclient.WithTrafficSplit(
+//		xclient.Group{Name: "stable", Constraints: stable, Percent: 95},
+//		xclient.Group{Name: "canary", Constraints: canary, Percent: 5},
+//	))
+
+// 一个流量分组
+type Group struct {
+	Name        string
+	Constraints []Constraint // 实例元数据须满足的约束，为空时匹配所有实例
+	Percent     float64      // 分到的流量比例，0~100
+}
+
+// 一个分组的累计调用
+type GroupStats struct {
+	Name    string
+	Calls   uint64
+	Errors  uint64
+	Latency time.Duration // 平均耗时
+}
+
+type group struct {
+	Group
+	calls, errors, nanos atomic.Uint64
+}
+
+// 实例归入第一个满足约束的组，不属于任何组的实例不参与选择。
+// 选中的组没有可用实例时，按比例在有实例的组中重新选，流量不会因此失败
+func WithTrafficSplit(groups ...Group) Option {
+	return func(xc *XClient) {
+		xc.groups = make([]*group, len(groups))
+		for i, g := range groups {
+			xc.groups[i] = &group{Group: g}
+		}
+	}
+}
+
+// 按比例选一组，返回组内的实例
+func (xc *XClient) split(endpoints []Endpoint) []Endpoint {
+	members := make([][]Endpoint, len(xc.groups))
+	for _, ep := range endpoints {
+		if i := xc.groupOf(ep); i >= 0 {
+			members[i] = append(members[i], ep)
+		}
+	}
+	total := 0.0
+	for i, g := range xc.groups {
+		if len(members[i]) > 0 {
+			total += g.Percent
+		}
+	}
+	if total <= 0 {
+		return nil
+	}
+	xc.mu.Lock()
+	r := xc.rnd.Float64() * total
+	xc.mu.Unlock()
+	last := -1
+	for i, g := range xc.groups {
+		if len(members[i]) == 0 || g.Percent <= 0 {
+			continue
+		}
+		if last = i; r < g.Percent {
+			break
+		}
+		r -= g.Percent
+	}
+	return members[last]
+}
+
+func (xc *XClient) groupOf(ep Endpoint) int {
+	for i, g := range xc.groups {
+		if matchAll(g.Constraints, ep.Meta) {
+			return i
+		}
+	}
+	return -1
+}
+
+// 记录一次调用，实例不属于任何组时忽略
+func (xc *XClient) reportGroup(ep Endpoint, elapsed time.Duration, err error) {
+	i := xc.groupOf(ep)
+	if i < 0 {
+		return
+	}
+	g := xc.groups[i]
+	g.calls.Add(1)
+	g.nanos.Add(uint64(elapsed))
+	if err != nil {
+		g.errors.Add(1)
+	}
+}
+
+// 各组的调用统计，按WithTrafficSplit的顺序，未开启流量切分时为空
+func (xc *XClient) SplitStats() []GroupStats {
+	stats := make([]GroupStats, len(xc.groups))
+	for i, g := range xc.groups {
+		stats[i] = GroupStats{Name: g.Name, Calls: g.calls.Load(), Errors: g.errors.Load()}
+		if stats[i].Calls > 0 {
+			stats[i].Latency = time.Duration(g.nanos.Load() / stats[i].Calls)
+		}
+	}
+	return stats
+}
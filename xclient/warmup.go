@@ -0,0 +1,129 @@
+package xclient
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// 慢启动：新出现在服务发现中的实例，以及摘除后恢复的实例，缓存是冷的、JIT还没有预热，
+// 一下子给满流量容易被压垮或者拖慢调用。预热期内它的流量份额从MinPercent线性增加到100%，
+// 做法是按份额的概率让它参与选择，对所有的SelectMode都适用。
+// XClient看到的第一份实例列表不预热，否则启动时所有实例都在预热
+type SlowStartConfig struct {
+	// 预热时长，默认30s
+	Window time.Duration
+	// 预热开始时的流量份额(百分比)，默认10
+	MinPercent int
+}
+
+// 开启慢启动
+func WithSlowStart(cfg SlowStartConfig) Option {
+	return func(xc *XClient) {
+		xc.warmup = newSlowStart(cfg)
+	}
+}
+
+type slowStart struct {
+	cfg SlowStartConfig
+
+	mu    sync.Mutex           // protect following
+	since map[string]time.Time // 实例开始预热的时间，零值表示不预热
+	seen  bool                 // 已经看到过第一份列表
+	rnd   *rand.Rand
+}
+
+func newSlowStart(cfg SlowStartConfig) *slowStart {
+	if cfg.Window <= 0 {
+		cfg.Window = 30 * time.Second
+	}
+	if cfg.MinPercent <= 0 {
+		cfg.MinPercent = 10
+	}
+	return &slowStart{
+		cfg:   cfg,
+		since: make(map[string]time.Time),
+		rnd:   rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// 记录服务发现给出的实例，新出现的开始预热，消失的忘掉，再出现时重新预热
+func (s *slowStart) observe(endpoints []Endpoint, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	present := make(map[string]bool, len(endpoints))
+	for _, ep := range endpoints {
+		present[ep.Addr] = true
+		if _, ok := s.since[ep.Addr]; !ok {
+			if s.seen {
+				s.since[ep.Addr] = now
+			} else {
+				s.since[ep.Addr] = time.Time{}
+			}
+		}
+	}
+	s.seen = s.seen || len(endpoints) > 0
+	for addr := range s.since {
+		if !present[addr] {
+			delete(s.since, addr)
+		}
+	}
+}
+
+// 实例从摘除中恢复，重新预热
+func (s *slowStart) restart(addr string, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.since[addr] = now
+}
+
+// 实例当前的流量份额，0到1
+func (s *slowStart) share(addr string, now time.Time) float64 {
+	since := s.since[addr]
+	if since.IsZero() {
+		return 1
+	}
+	elapsed := now.Sub(since)
+	if elapsed >= s.cfg.Window {
+		s.since[addr] = time.Time{} // 预热结束，之后不再计算
+		return 1
+	}
+	lo := float64(s.cfg.MinPercent) / 100
+	return lo + (1-lo)*float64(elapsed)/float64(s.cfg.Window)
+}
+
+// 预热中的实例按份额的概率留下；都没有留下时返回原列表
+func (s *slowStart) filter(endpoints []Endpoint, now time.Time) []Endpoint {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var kept []Endpoint
+	for i, ep := range endpoints {
+		share := s.share(ep.Addr, now)
+		if share >= 1 || s.rnd.Float64() < share {
+			if kept != nil {
+				kept = append(kept, ep)
+			}
+			continue
+		}
+		if kept == nil { // 第一次去掉实例时才复制
+			kept = append(make([]Endpoint, 0, len(endpoints)), endpoints[:i]...)
+		}
+	}
+	if len(kept) == 0 { // 没有去掉实例，或者全部去掉了
+		return endpoints
+	}
+	return kept
+}
+
+// 正在预热的实例
+func (s *slowStart) warming(now time.Time) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var addrs []string
+	for addr := range s.since {
+		if s.share(addr, now) < 1 {
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs
+}
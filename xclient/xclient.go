@@ -0,0 +1,322 @@
+package xclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/micplus/mrpc"
+	"github.com/micplus/mrpc/codec"
+)
+
+// 负载均衡策略
+type SelectMode int
+
+const (
+	RandomSelect         SelectMode = iota // 随机
+	RoundRobinSelect                       // 轮询
+	WeightedRandomSelect                   // 按权重随机
+)
+
+// 支持负载均衡的客户端，对每个实例复用一个mrpc.Client
+type XClient struct {
+	d           Discovery
+	mode        SelectMode
+	codecType   uint32
+	outlier     *outlierDetector // 为nil时不做异常检测
+	warmup      *slowStart       // 为nil时新实例直接给满流量
+	shard       *shardRouter     // 为nil时不支持CallKey
+	constraints []Constraint
+	locality    *Locality // 为nil时不区分远近
+	groups      []*group  // 流量分组，为nil时不切分
+	clock       mrpc.Clock
+	// 请求没能发出时等待重试，见WithWaitForReady
+	waitForReady bool
+
+	mu      sync.Mutex // protect following
+	clients map[string]*mrpc.Client
+	rnd     *rand.Rand
+	index   int // 轮询位置
+}
+
+// XClient的可选配置
+type Option func(*XClient)
+
+// 指定连接使用的编码类型，默认gob
+func WithCodecType(codecType uint32) Option {
+	return func(xc *XClient) {
+		xc.codecType = codecType
+	}
+}
+
+// 异常检测的统计窗口、摘除时间和调用耗时使用的时钟，默认mrpc.SystemClock
+func WithClock(c mrpc.Clock) Option {
+	return func(xc *XClient) {
+		xc.clock = c
+	}
+}
+
+// WithWaitForReady重试的退避间隔，每次翻倍
+const (
+	readyMinBackoff = 20 * time.Millisecond
+	readyMaxBackoff = time.Second
+)
+
+// 调用时服务发现出错、没有可用实例或者连接不上，不立即失败，
+// 而是按退避间隔重新选择实例，直到请求发出或ctx结束，服务之间的启动顺序就无关紧要了。
+// 请求发出之后的错误照常返回。Call、CallKey没有ctx，会一直等下去
+func WithWaitForReady() Option {
+	return func(xc *XClient) {
+		xc.waitForReady = true
+	}
+}
+
+// 开启异常实例检测
+func WithOutlierDetection(cfg OutlierConfig) Option {
+	return func(xc *XClient) {
+		xc.outlier = newOutlierDetector(cfg)
+	}
+}
+
+func NewXClient(d Discovery, mode SelectMode, opts ...Option) *XClient {
+	xc := &XClient{
+		d:         d,
+		mode:      mode,
+		codecType: codec.GobType,
+		clients:   make(map[string]*mrpc.Client),
+		rnd:       rand.New(rand.NewSource(time.Now().UnixNano())),
+		clock:     mrpc.SystemClock,
+	}
+	for _, opt := range opts {
+		opt(xc)
+	}
+	return xc
+}
+
+// 关闭所有缓存的连接
+func (xc *XClient) Close() error {
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	for addr, client := range xc.clients {
+		client.Close() // 出错也无需处理
+		delete(xc.clients, addr)
+	}
+	return nil
+}
+
+// 更换所有缓存的连接，如证书轮换之后。旧连接上已发出的调用照常完成，之后关闭；
+// 新的调用建立新连接
+func (xc *XClient) Reconnect() {
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	for _, client := range xc.clients {
+		client.Drain()
+	}
+}
+
+// 按策略从实例列表中挑选一个
+func (xc *XClient) selectEndpoint(endpoints []Endpoint) (Endpoint, error) {
+	n := len(endpoints)
+	if n == 0 {
+		return Endpoint{}, ErrNoEndpoint
+	}
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	switch xc.mode {
+	case RoundRobinSelect:
+		ep := endpoints[xc.index%n]
+		xc.index = (xc.index + 1) % n
+		return ep, nil
+	case WeightedRandomSelect:
+		total := 0
+		for _, ep := range endpoints {
+			total += ep.weight()
+		}
+		r := xc.rnd.Intn(total)
+		for _, ep := range endpoints {
+			if r -= ep.weight(); r < 0 {
+				return ep, nil
+			}
+		}
+		return endpoints[n-1], nil
+	default:
+		return endpoints[xc.rnd.Intn(n)], nil
+	}
+}
+
+// 取得到某个实例的连接，没有或已不可用就重新建立。
+// 拨号不持有锁，一个慢的实例不会阻塞其它调用选择实例和拨号
+func (xc *XClient) dial(ep Endpoint) (*mrpc.Client, error) {
+	xc.mu.Lock()
+	client := xc.cachedClient(ep.Addr)
+	xc.mu.Unlock()
+	if client != nil {
+		return client, nil
+	}
+	network, address := ep.network()
+	client, err := mrpc.Dial(network, address, xc.codecType)
+	if err != nil {
+		return nil, err
+	}
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	// 拨号期间其它调用可能已经建立了连接，用先放进去的那条
+	if cached := xc.cachedClient(ep.Addr); cached != nil {
+		client.Close()
+		return cached, nil
+	}
+	xc.clients[ep.Addr] = client
+	return client, nil
+}
+
+// 缓存的可用连接，不可用的从缓存中移除，调用时持有xc.mu
+func (xc *XClient) cachedClient(addr string) *mrpc.Client {
+	client, ok := xc.clients[addr]
+	if !ok {
+		return nil
+	}
+	if client.GetState() != mrpc.Ready {
+		if client.GetState() != mrpc.Degraded { // 排空中的连接处理完在途调用后自己关闭
+			client.Close()
+		}
+		delete(xc.clients, addr)
+		return nil
+	}
+	return client
+}
+
+func (xc *XClient) call(ctx context.Context, ep Endpoint, name string, args, reply any) error {
+	start := xc.clock.Now()
+	client, err := xc.dial(ep)
+	if err == nil {
+		err = client.CallContext(ctx, name, args, reply)
+	}
+	if errors.Is(err, mrpc.ErrDraining) { // 选中之后连接收到了GOAWAY，请求没有发出，换一条新连接
+		if client, err = xc.dial(ep); err == nil {
+			err = client.CallContext(ctx, name, args, reply)
+		}
+	}
+	now := xc.clock.Now()
+	if xc.outlier != nil && xc.outlier.report(ep.Addr, now.Sub(start), err, now) && xc.warmup != nil {
+		xc.warmup.restart(ep.Addr, now)
+	}
+	if xc.groups != nil {
+		xc.reportGroup(ep, now.Sub(start), err)
+	}
+	return err
+}
+
+// 从服务发现获取实例，只保留满足元数据约束的
+func (xc *XClient) discover() ([]Endpoint, error) {
+	endpoints, err := xc.d.GetAll()
+	if err != nil {
+		return nil, err
+	}
+	return xc.filterConstraints(endpoints), nil
+}
+
+// 可供选择的实例，已摘除的异常实例不参与选择
+func (xc *XClient) available() ([]Endpoint, error) {
+	endpoints, err := xc.discover()
+	if err != nil {
+		return nil, err
+	}
+	if xc.outlier != nil {
+		endpoints = xc.outlier.filter(endpoints, xc.clock.Now())
+	}
+	return endpoints, nil
+}
+
+// 当前被摘除的实例地址，未开启异常检测时为空
+func (xc *XClient) Ejected() []string {
+	if xc.outlier == nil {
+		return nil
+	}
+	return xc.outlier.ejected(xc.clock.Now())
+}
+
+// 正在慢启动预热的实例地址，未开启慢启动时为空
+func (xc *XClient) Warming() []string {
+	if xc.warmup == nil {
+		return nil
+	}
+	return xc.warmup.warming(xc.clock.Now())
+}
+
+// 获取实例列表，经过路由筛选(route可为nil)和异常检测后按策略选出一个
+func (xc *XClient) choose(route func([]Endpoint) ([]Endpoint, error)) (Endpoint, error) {
+	endpoints, err := xc.discover()
+	if err != nil {
+		return Endpoint{}, err
+	}
+	if xc.warmup != nil {
+		xc.warmup.observe(endpoints, xc.clock.Now())
+	}
+	if route != nil {
+		if endpoints, err = route(endpoints); err != nil {
+			return Endpoint{}, err
+		}
+	}
+	endpoints = xc.preferLocal(endpoints)
+	if xc.outlier != nil {
+		endpoints = xc.outlier.filter(endpoints, xc.clock.Now())
+	}
+	if xc.groups != nil {
+		endpoints = xc.split(endpoints)
+	}
+	if xc.warmup != nil {
+		endpoints = xc.warmup.filter(endpoints, xc.clock.Now())
+	}
+	ep, err := xc.selectEndpoint(endpoints)
+	if err != nil {
+		return Endpoint{}, err
+	}
+	if xc.outlier != nil {
+		xc.outlier.begin(ep.Addr)
+	}
+	return ep, nil
+}
+
+// 选出实例发起调用，开启了WithWaitForReady时请求没能发出就等待重试
+func (xc *XClient) invoke(ctx context.Context, route func([]Endpoint) ([]Endpoint, error), name string, args, reply any) error {
+	backoff := readyMinBackoff
+	for {
+		ep, err := xc.choose(route)
+		if err == nil {
+			if err = xc.call(ctx, ep, name, args, reply); !notSent(err) {
+				return err
+			}
+		}
+		if !xc.waitForReady {
+			return err
+		}
+		t := xc.clock.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return fmt.Errorf("rpc xclient: %w while waiting for ready: %v", ctx.Err(), err)
+		case <-t.C():
+		}
+		backoff = min(2*backoff, readyMaxBackoff)
+	}
+}
+
+// 请求还没有发出的错误：连接不上，或者连接正在排空
+func notSent(err error) bool {
+	var op *net.OpError
+	return errors.Is(err, mrpc.ErrDraining) || errors.As(err, &op) && op.Op == "dial"
+}
+
+// 从发现的实例中选一个发起同步调用
+func (xc *XClient) Call(name string, args, reply any) error {
+	return xc.invoke(context.Background(), nil, name, args, reply)
+}
+
+// 同Call，ctx中的元数据和期限随请求发送
+func (xc *XClient) CallContext(ctx context.Context, name string, args, reply any) error {
+	return xc.invoke(ctx, nil, name, args, reply)
+}
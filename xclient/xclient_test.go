@@ -0,0 +1,321 @@
+package xclient
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/micplus/mrpc"
+)
+
+type Arith int
+
+type Args struct{ A, B int }
+
+func (*Arith) Add(args *Args, reply *int) error {
+	*reply = args.A + args.B
+	return nil
+}
+
+// 记录被调用的实例，便于检查负载均衡结果
+type Who string
+
+func (w *Who) Name(_ int, reply *string) error {
+	*reply = string(*w)
+	return nil
+}
+
+func startServer(t *testing.T, rcvrs ...any) string {
+	t.Helper()
+	s := mrpc.NewServer()
+	for _, rcvr := range rcvrs {
+		if err := s.Register(rcvr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Accept(lis)
+	return "tcp@" + lis.Addr().String()
+}
+
+func newWho(name string) *Who {
+	w := Who(name)
+	return &w
+}
+
+func TestXClientCall(t *testing.T) {
+	addr1 := startServer(t, new(Arith), newWho("s1"))
+	addr2 := startServer(t, new(Arith), newWho("s2"))
+	d := NewStaticDiscovery(Endpoint{Addr: addr1}, Endpoint{Addr: addr2})
+	xc := NewXClient(d, RoundRobinSelect)
+	defer xc.Close()
+
+	var sum int
+	if err := xc.Call("Arith.Add", &Args{1, 2}, &sum); err != nil || sum != 3 {
+		t.Fatalf("Arith.Add: got %d %v", sum, err)
+	}
+	seen := make(map[string]bool)
+	for i := 0; i < 4; i++ {
+		var name string
+		if err := xc.Call("Who.Name", 0, &name); err != nil {
+			t.Fatal(err)
+		}
+		seen[name] = true
+	}
+	if !seen["s1"] || !seen["s2"] {
+		t.Errorf("round robin should reach both servers, got %v", seen)
+	}
+}
+
+func TestFileDiscoveryReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "endpoints")
+	if err := os.WriteFile(path, []byte("# cluster\ntcp@10.0.0.1:8001 weight=10 zone=a\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	d, err := NewFileDiscovery(path, 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	eps, _ := d.GetAll()
+	if len(eps) != 1 || eps[0].Weight != 10 || eps[0].Meta["zone"] != "a" {
+		t.Fatalf("unexpected endpoints %+v", eps)
+	}
+
+	if err := os.WriteFile(path, []byte("10.0.0.1:8001\n10.0.0.2:8001\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if eps, _ := d.GetAll(); len(eps) == 2 {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Error("file change was not picked up")
+}
+
+func TestFileDiscoveryCloseTwice(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "endpoints")
+	if err := os.WriteFile(path, []byte("10.0.0.1:8001\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	d, err := NewFileDiscovery(path, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.Close()
+	if err := d.Close(); err != nil {
+		t.Errorf("second Close: %v", err)
+	}
+}
+
+func TestFork(t *testing.T) {
+	addr1 := startServer(t, newWho("s1"))
+	addr2 := startServer(t, newWho("s2"))
+	d := NewStaticDiscovery(Endpoint{Addr: addr1}, Endpoint{Addr: addr2}, Endpoint{Addr: "tcp@127.0.0.1:1"})
+	xc := NewXClient(d, RandomSelect)
+	defer xc.Close()
+
+	var name string
+	if err := xc.Fork(0, "Who.Name", 0, &name); err != nil || (name != "s1" && name != "s2") {
+		t.Fatalf("Fork: got %q %v", name, err)
+	}
+
+	results, err := xc.ForkAll("Who.Name", 0, &name)
+	if err != nil || len(results) != 3 {
+		t.Fatalf("ForkAll: got %v %v", results, err)
+	}
+	want := map[string]string{addr1: "s1", addr2: "s2"}
+	failed := 0
+	for _, r := range results {
+		if r.Error != nil {
+			failed++
+			continue
+		}
+		if got := *r.Reply.(*string); got != want[r.Endpoint.Addr] {
+			t.Errorf("unexpected reply %q from %s", got, r.Endpoint.Addr)
+		}
+	}
+	if failed != 1 {
+		t.Errorf("want exactly 1 failed endpoint, got %d", failed)
+	}
+}
+
+func TestShardRouting(t *testing.T) {
+	for _, fn := range []ShardFunc{ModuloShard, JumpHashShard} {
+		for _, key := range []string{"0", "7", "user-42", ""} {
+			if s := fn(key, 4); s < 0 || s >= 4 {
+				t.Errorf("shard of %q out of range: %d", key, s)
+			}
+		}
+	}
+	byRange := RangeShard("g", "p")
+	for key, want := range map[string]int{"apple": 0, "g": 1, "kiwi": 1, "zebra": 2} {
+		if got := byRange(key, 3); got != want {
+			t.Errorf("RangeShard(%q) = %d, want %d", key, got, want)
+		}
+	}
+
+	addr0 := startServer(t, newWho("shard0"))
+	addr1 := startServer(t, newWho("shard1"))
+	d := NewStaticDiscovery(
+		Endpoint{Addr: addr0, Meta: map[string]string{MetaShard: "0"}},
+		Endpoint{Addr: addr1, Meta: map[string]string{MetaShard: "1"}},
+	)
+	xc := NewXClient(d, RandomSelect, WithSharding(0, ModuloShard))
+	defer xc.Close()
+	for key, want := range map[string]string{"10": "shard0", "11": "shard1"} {
+		var name string
+		if err := xc.CallKey(key, "Who.Name", 0, &name); err != nil || name != want {
+			t.Errorf("CallKey(%q): got %q %v, want %q", key, name, err, want)
+		}
+	}
+}
+
+func TestConstraints(t *testing.T) {
+	cs, err := ParseConstraints("version>=2", "canary=false")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for meta, want := range map[*map[string]string]bool{
+		{"version": "2.1"}:                  true,
+		{"version": "10"}:                   true,
+		{"version": "1.9"}:                  false,
+		{"version": "3", "canary": "true"}:  false,
+		{"version": "3", "canary": "false"}: true,
+		{"canary": "false"}:                 false,
+	} {
+		if got := matchAll(cs, *meta); got != want {
+			t.Errorf("match %v = %v, want %v", *meta, got, want)
+		}
+	}
+	if _, err := ParseConstraint(">=2"); err == nil {
+		t.Error("constraint without key should fail")
+	}
+
+	v1 := startServer(t, newWho("v1"))
+	v2 := startServer(t, newWho("v2"))
+	d := NewStaticDiscovery(
+		Endpoint{Addr: v1, Meta: map[string]string{"version": "1"}},
+		Endpoint{Addr: v2, Meta: map[string]string{"version": "2"}},
+	)
+	xc := NewXClient(d, RoundRobinSelect, WithConstraints(cs...))
+	defer xc.Close()
+	for i := 0; i < 3; i++ {
+		var name string
+		if err := xc.Call("Who.Name", 0, &name); err != nil || name != "v2" {
+			t.Fatalf("got %q %v, want v2", name, err)
+		}
+	}
+}
+
+func TestWaitForReady(t *testing.T) {
+	d := NewStaticDiscovery()
+	xc := NewXClient(d, RandomSelect, WithWaitForReady())
+	defer xc.Close()
+
+	// 实例稍后才出现，调用等到它就绪
+	addr := startServer(t, new(Arith))
+	time.AfterFunc(50*time.Millisecond, func() { d.Update([]Endpoint{{Addr: addr}}) })
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var sum int
+	if err := xc.CallContext(ctx, "Arith.Add", &Args{1, 2}, &sum); err != nil || sum != 3 {
+		t.Fatalf("Arith.Add: got %d %v", sum, err)
+	}
+
+	// 连接不上的实例一直重试到ctx结束
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lis.Close()
+	d.Update([]Endpoint{{Addr: "tcp@" + lis.Addr().String()}})
+	ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	err = xc.CallContext(ctx, "Arith.Add", &Args{1, 2}, &sum)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("want DeadlineExceeded, got %v", err)
+	}
+
+	// 不开启时立即失败
+	d.Update(nil)
+	if err := NewXClient(d, RandomSelect).Call("Arith.Add", &Args{1, 2}, &sum); !errors.Is(err, ErrNoEndpoint) {
+		t.Errorf("want ErrNoEndpoint, got %v", err)
+	}
+}
+
+func TestTrafficSplit(t *testing.T) {
+	stable, canary := newWho("stable"), newWho("canary")
+	s1, s2, c1 := startServer(t, stable), startServer(t, stable), startServer(t, canary)
+	stableCs, _ := ParseConstraints("track!=canary")
+	canaryCs, _ := ParseConstraints("track=canary")
+	groups := WithTrafficSplit(
+		Group{Name: "stable", Constraints: stableCs, Percent: 80},
+		Group{Name: "canary", Constraints: canaryCs, Percent: 20},
+	)
+	d := NewStaticDiscovery(
+		Endpoint{Addr: s1},
+		Endpoint{Addr: s2, Meta: map[string]string{"track": "stable"}},
+		Endpoint{Addr: c1, Meta: map[string]string{"track": "canary"}},
+	)
+	xc := NewXClient(d, RoundRobinSelect, groups)
+	defer xc.Close()
+
+	const n = 500
+	counts := map[string]int{}
+	for i := 0; i < n; i++ {
+		var name string
+		if err := xc.Call("Who.Name", 0, &name); err != nil {
+			t.Fatal(err)
+		}
+		counts[name]++
+	}
+	if counts["canary"] < n/10 || counts["canary"] > n*3/10 {
+		t.Errorf("canary got %d of %d calls, want about 20%%", counts["canary"], n)
+	}
+	stats := xc.SplitStats()
+	if len(stats) != 2 || stats[0].Calls != uint64(counts["stable"]) || stats[1].Calls != uint64(counts["canary"]) {
+		t.Errorf("stats %+v don't match %v", stats, counts)
+	}
+	if stats[1].Errors != 0 || stats[1].Latency <= 0 {
+		t.Errorf("canary stats %+v", stats[1])
+	}
+
+	// 金丝雀组没有实例时全部流量给稳定组
+	xc2 := NewXClient(NewStaticDiscovery(Endpoint{Addr: s1}), RandomSelect, groups)
+	defer xc2.Close()
+	for i := 0; i < 20; i++ {
+		var name string
+		if err := xc2.Call("Who.Name", 0, &name); err != nil || name != "stable" {
+			t.Fatalf("got %q %v, want stable", name, err)
+		}
+	}
+}
+
+func TestReconnect(t *testing.T) {
+	addr := startServer(t, newWho("a"))
+	xc := NewXClient(NewStaticDiscovery(Endpoint{Addr: addr}), RandomSelect)
+	defer xc.Close()
+	var name string
+	if err := xc.Call("Who.Name", 0, &name); err != nil {
+		t.Fatal(err)
+	}
+	old := xc.clients[addr]
+	xc.Reconnect()
+	if err := xc.Call("Who.Name", 0, &name); err != nil {
+		t.Fatalf("call after Reconnect: %v", err)
+	}
+	// 旧连接没有未完成的调用，已经开始关闭
+	if xc.clients[addr] == old || old.IsAvailable() {
+		t.Errorf("old connection state %v, want replaced and drained", old.GetState())
+	}
+}
@@ -0,0 +1,78 @@
+package xclient
+
+// 就近路由：优先选择同可用区的实例，其次同地域，本地健康实例不足时才溢出到更远的地方，
+// 以减少跨可用区流量的费用和延迟
+
+// 实例元数据中表示地域、可用区的键
+const (
+	MetaRegion = "region"
+	MetaZone   = "zone"
+)
+
+// 客户端所在的位置
+type Locality struct {
+	Region string
+	Zone   string
+	// 本地健康实例占比低于该百分比时，把下一层的实例也纳入选择，默认50
+	MinHealthyPercent int
+}
+
+// 开启就近路由。实例是否健康取决于异常检测，未开启异常检测时实例都视为健康
+func WithLocality(l Locality) Option {
+	if l.MinHealthyPercent <= 0 {
+		l.MinHealthyPercent = 50
+	}
+	return func(xc *XClient) {
+		xc.locality = &l
+	}
+}
+
+// 按距离把实例分为同可用区、同地域、其他三层
+func (l *Locality) tier(ep Endpoint) int {
+	switch {
+	case l.Zone != "" && ep.Meta[MetaZone] == l.Zone &&
+		(l.Region == "" || ep.Meta[MetaRegion] == "" || ep.Meta[MetaRegion] == l.Region):
+		return 0
+	case l.Region != "" && ep.Meta[MetaRegion] == l.Region:
+		return 1
+	default:
+		return 2
+	}
+}
+
+// 由近及远累加各层实例，直到健康实例占比达到要求
+func (l *Locality) prefer(endpoints []Endpoint, healthy func(Endpoint) bool) []Endpoint {
+	var tiers [3][]Endpoint
+	for _, ep := range endpoints {
+		t := l.tier(ep)
+		tiers[t] = append(tiers[t], ep)
+	}
+	var chosen []Endpoint
+	total, ok := 0, 0
+	for _, tier := range tiers {
+		if len(tier) == 0 {
+			continue
+		}
+		chosen = append(chosen, tier...)
+		for _, ep := range tier {
+			total++
+			if healthy(ep) {
+				ok++
+			}
+		}
+		if ok*100 >= total*l.MinHealthyPercent && ok > 0 {
+			break
+		}
+	}
+	return chosen
+}
+
+func (xc *XClient) preferLocal(endpoints []Endpoint) []Endpoint {
+	if xc.locality == nil {
+		return endpoints
+	}
+	now := xc.clock.Now()
+	return xc.locality.prefer(endpoints, func(ep Endpoint) bool {
+		return xc.outlier == nil || xc.outlier.healthy(ep.Addr, now)
+	})
+}